@@ -0,0 +1,64 @@
+package libnetwork
+
+import (
+	"reflect"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/pkg/options"
+)
+
+// feature is a feature the network or endpoint specific options can request,
+// detected from the option names the built-in drivers share.
+type feature struct {
+	name      string
+	options   []string
+	supported func(driverapi.DriverCapability) bool
+}
+
+var (
+	networkFeatures = []feature{
+		{"ipv6", []string{"EnableIPv6", "FixedCIDRv6"}, func(c driverapi.DriverCapability) bool { return c.IPv6 }},
+	}
+
+	endpointFeatures = []feature{
+		{"ipv6", []string{"AddressIPv6"}, func(c driverapi.DriverCapability) bool { return c.IPv6 }},
+		{"static ip", []string{"AddressIPv4", "AddressIPv6"}, func(c driverapi.DriverCapability) bool { return c.StaticIP }},
+		{"port mapping", []string{"PortBindings"}, func(c driverapi.DriverCapability) bool { return c.PortMapping }},
+	}
+)
+
+// checkFeatures returns UnsupportedFeatureError for the first of the
+// features the options request which the driver d does not support.
+func checkFeatures(d driverapi.Driver, features []feature, opts interface{}) error {
+	caps := d.Capabilities()
+	for _, f := range features {
+		if f.supported(caps) {
+			continue
+		}
+		for _, name := range f.options {
+			if optionSet(opts, name) {
+				return &UnsupportedFeatureError{NetworkType: d.Type(), Feature: f.name}
+			}
+		}
+	}
+	return nil
+}
+
+// optionSet tells whether the option name is set to a non zero value in
+// opts, which are generic options or a driver config structure.
+func optionSet(opts interface{}, name string) bool {
+	var v reflect.Value
+	if generic, ok := opts.(options.Generic); ok {
+		v = reflect.ValueOf(generic[name])
+	} else {
+		v = reflect.ValueOf(opts)
+		for v.Kind() == reflect.Ptr && !v.IsNil() {
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return false
+		}
+		v = v.FieldByName(name)
+	}
+	return v.IsValid() && !v.IsZero()
+}
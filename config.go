@@ -0,0 +1,78 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/docker/libnetwork/pkg/options"
+)
+
+// Config is the JSON document NetworkController.LoadConfig applies, listing
+// the drivers to configure and the networks to create:
+//
+//	{
+//		"drivers": [
+//			{"type": "bridge", "options": {"BridgeName": "br0", "AddressIPv4": "192.168.100.1/24"}}
+//		],
+//		"networks": [
+//			{"name": "network1", "type": "bridge", "labels": {"env": "prod"}}
+//		]
+//	}
+//
+// The options are the generic ones of the driver, their values are converted
+// to the types of the driver configuration fields through their JSON encoding.
+type Config struct {
+	Drivers  []DriverConfig  `json:"drivers"`
+	Networks []NetworkConfig `json:"networks"`
+}
+
+// DriverConfig is the configuration of a driver in a Config.
+type DriverConfig struct {
+	Type    string          `json:"type"`
+	Options options.Generic `json:"options"`
+}
+
+// NetworkConfig is a network to create in a Config.
+type NetworkConfig struct {
+	Name    string            `json:"name"`
+	Type    string            `json:"type"`
+	Options options.Generic   `json:"options"`
+	Labels  map[string]string `json:"labels"`
+}
+
+func (c *controller) LoadConfig(r io.Reader) error {
+	var config Config
+	if err := json.NewDecoder(r).Decode(&config); err != nil {
+		return err
+	}
+
+	var errs []*ConfigEntryError
+	for _, dc := range config.Drivers {
+		option := dc.Options
+		if option == nil {
+			option = options.Generic{}
+		}
+		if err := c.ConfigureNetworkDriver(dc.Type, option); err != nil {
+			errs = append(errs, &ConfigEntryError{Kind: "driver", Name: dc.Type, Err: err})
+		}
+	}
+
+	for _, nc := range config.Networks {
+		// The networks already created are left as they are
+		if c.NetworkByName(nc.Name) != nil {
+			continue
+		}
+		var netOptions []NetworkOption
+		if nc.Labels != nil {
+			netOptions = append(netOptions, NetworkOptionLabels(nc.Labels))
+		}
+		if _, err := c.NewNetwork(nc.Type, nc.Name, nc.Options, netOptions...); err != nil {
+			errs = append(errs, &ConfigEntryError{Kind: "network", Name: nc.Name, Err: err})
+		}
+	}
+
+	if len(errs) != 0 {
+		return &LoadConfigError{Errors: errs}
+	}
+	return nil
+}
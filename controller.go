@@ -40,9 +40,21 @@ create network namespaces and allocate interfaces for containers to use.
 package libnetwork
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
 	"sync"
+	"syscall"
+	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/resolver"
 	"github.com/docker/libnetwork/sandbox"
 	"github.com/docker/libnetwork/types"
 )
@@ -50,17 +62,81 @@ import (
 // NetworkController provides the interface for controller instance which manages
 // networks.
 type NetworkController interface {
-	// ConfigureNetworkDriver applies the passed options to the driver instance for the specified network type
+	// ID returns the identity of the controller. It is generated along with
+	// the store: a controller backed by a persistent store gets the same id
+	// back on restart, while an in-memory controller gets a fresh one.
+	ID() string
+
+	// ConfigureNetworkDriver applies the passed options to the driver instance for the specified network type.
+	// It fails with InsufficientPrivilegesError if the driver programs the
+	// host without the privileges to.
 	ConfigureNetworkDriver(networkType string, options interface{}) error
 
+	// ReloadDriverConfig applies the passed options to the configured driver
+	// for the specified network type in place of the previous ones, while
+	// it has networks. Only the changed options are applied, and the
+	// changes to the options the existing networks depend on are refused
+	// with a driverapi.ImmutableOptionsError listing them, leaving the
+	// driver unchanged. It fails with ErrDriverNotConfigured if no
+	// configuration was applied, and with ReloadNotSupportedError if the
+	// driver can not reload its configuration. An EventDriverReload is
+	// emitted on success.
+	ReloadDriverConfig(networkType string, options interface{}) error
+
+	// RegisterDriver adds a driver for the specified network type next to
+	// the built-in ones. It fails with ErrDriverExists if the network type
+	// already has a driver.
+	RegisterDriver(networkType string, d driverapi.Driver) error
+
+	// DriverCapabilities returns the features the driver of the network
+	// type supports. An empty network type selects the default driver.
+	DriverCapabilities(networkType string) (driverapi.DriverCapability, error)
+
+	// SetDefaultDriver sets the network type of the networks created with
+	// an empty one. An empty network type unsets it.
+	SetDefaultDriver(networkType string)
+
 	// Create a new network. The options parameter carries network specific options.
-	// Labels support will be added in the near future.
-	NewNetwork(networkType, name string, options interface{}) (Network, error)
+	// Driver independent settings such as labels are passed as NetworkOption(s).
+	// The name must start with a letter or digit, followed by letters, digits,
+	// '_', '.' or '-', and be at most 255 characters long.
+	// It fails with ErrDriverNotConfigured if the driver of the network type
+	// requires a configuration and ConfigureNetworkDriver did not apply one.
+	// An empty network type selects the default driver, or fails with
+	// ErrNoDefaultDriver if SetDefaultDriver did not set one. Options
+	// requesting a feature the driver does not support, such as IPv6, are
+	// rejected with UnsupportedFeatureError. The networks of the drivers
+	// programming the host, such as bridge, need the CAP_NET_ADMIN
+	// capability and fail with InsufficientPrivilegesError without it, while
+	// the null and host ones do not.
+	NewNetwork(networkType, name string, options interface{}, netOptions ...NetworkOption) (Network, error)
+
+	// NewNetworkWithContext creates a new network as NewNetwork does, giving
+	// up once the passed context is done. The partially created network is
+	// then removed and the context error returned.
+	NewNetworkWithContext(ctx context.Context, networkType, name string, options interface{}, netOptions ...NetworkOption) (Network, error)
+
+	// LoadConfig configures the drivers and creates the networks described
+	// by the JSON Config document read from r. The networks which already
+	// exist by name are skipped, so that loading the same document again is
+	// a no-op. An entry failing does not stop the others from being applied,
+	// the failures are returned together as a LoadConfigError.
+	LoadConfig(r io.Reader) error
+
+	// ValidateNetworkConfig checks that NewNetwork would accept the network
+	// type and the network specific options, returning the error it would
+	// fail with. Nothing is allocated nor set up.
+	ValidateNetworkConfig(networkType string, options interface{}) error
 
 	// Networks returns the list of Network(s) managed by this controller.
 	Networks() []Network
 
 	// WalkNetworks uses the provided function to walk the Network(s) managed by this controller.
+	// The walk goes over a snapshot of the networks taken when it starts, in
+	// no particular order, and stops as soon as the walker returns true. The
+	// networks created during the walk are not visited, the ones deleted
+	// during the walk may still be. No lock is held while the walker runs,
+	// which can call the controller.
 	WalkNetworks(walker NetworkWalker)
 
 	// NetworkByName returns the Network which has the passed name, if it exists otherwise nil is returned
@@ -68,71 +144,599 @@ type NetworkController interface {
 
 	// NetworkByID returns the Network which has the passed id, if it exists otherwise nil is returned
 	NetworkByID(id string) Network
+
+	// NetworksByLabel returns the list of Network(s) which have the passed label key set to value
+	NetworksByLabel(key, value string) []Network
+
+	// NetworksByType returns the list of Network(s) of the passed network type
+	NetworksByType(networkType string) []Network
+
+	// FilterNetworks returns the list of Network(s) selected by all the passed filters
+	FilterNetworks(filters ...NetworkFilter) []Network
+
+	// DeleteNetwork deletes the Network which has the passed id. It fails if
+	// the network still has active endpoints.
+	DeleteNetwork(id string) error
+
+	// Subscribe returns a channel on which the network and endpoint lifecycle
+	// events are delivered, along with a function to cancel the subscription.
+	// Events are dropped when the subscriber does not drain the channel fast
+	// enough.
+	Subscribe() (<-chan Event, func())
+
+	// GC destroys the sandboxes found on the host which no endpoint of this
+	// controller is joined to, such as the ones left behind by a crash, and
+	// returns their keys. The sandboxes for which alive returns true are
+	// kept. With dryRun set, the orphan sandboxes are only reported.
+	//
+	// The sandbox root, sandbox.DefaultKeyRoot unless set by
+	// ControllerOptionSandboxRoot, may be shared with other processes such
+	// as the Docker daemon, whose running containers' sandboxes this
+	// controller does not know of: alive must report them, and GC fails
+	// with ErrNoAlivePredicate if it is nil.
+	GC(alive func(key string) bool, dryRun bool) ([]string, error)
+
+	// Sandboxes returns the sandboxes of the controller, with the endpoints
+	// joined to them, for debugging. The controller lock is held while they
+	// are copied, so that the reference count and the endpoints of each
+	// sandbox are consistent with each other.
+	Sandboxes() []SandboxInfo
+
+	// WalkSandboxes uses the provided function to walk the sandboxes of the
+	// controller. As WalkNetworks does, the walk goes over a snapshot taken
+	// when it starts, in no particular order, and stops as soon as the
+	// walker returns true.
+	WalkSandboxes(walker SandboxWalker)
+
+	// Stop releases the resources held by the controller: the networks are
+	// deleted along with their endpoints, which releases what their drivers
+	// allocated, and the sandboxes are destroyed. It fails with
+	// ActiveEndpointsError, leaving everything in place, while a network has
+	// endpoints. Once stopped, the controller creates no more networks and
+	// ErrControllerStopped is returned by further calls.
+	Stop() error
+
+	// ForceStop stops the controller as Stop does, first making the joined
+	// containers leave and deleting the endpoints of the networks.
+	ForceStop() error
+
+	// ReleaseLeasesFor releases the addresses leased to the passed container,
+	// for the reapers of the containers which died without leaving their
+	// endpoints: the endpoints still joined by the container are left, and
+	// the endpoints leased to it are deleted along with their address. The
+	// first error met is returned, once all the leases are processed.
+	ReleaseLeasesFor(containerID string) error
+
+	// Snapshot returns a copy of the networks, their endpoints and the
+	// sandboxes the endpoints are joined to, meant for frequent inspection.
+	// The controller lock is held for the copy only, and the returned
+	// Topology is read without any lock.
+	Snapshot() Topology
+
+	// MarshalJSON encodes the networks managed by this controller and their
+	// endpoints as a list of NetworkResource, for API responses.
+	MarshalJSON() ([]byte, error)
 }
 
+// controllerKey is the store key of the controller record.
+const controllerKey = "controller"
+
+// maxIDAttempts bounds the ids generated for a network or an endpoint until
+// one is not in use.
+const maxIDAttempts = 10
+
 // NetworkWalker is a client provided function which will be used to walk the Networks.
 // When the function returns true, the walk will stop.
 type NetworkWalker func(nw Network) bool
 
+// SandboxWalker is a client provided function which will be used to walk the
+// sandboxes. When the function returns true, the walk will stop.
+type SandboxWalker func(sb SandboxInfo) bool
+
+// SandboxInfo is the copy of a sandbox of the controller, as returned by
+// NetworkController.Sandboxes.
+type SandboxInfo struct {
+	// Key of the sandbox.
+	Key string
+
+	// RefCount is the number of joins referencing the sandbox, which is
+	// destroyed when it drops to zero. It matches the number of
+	// Endpoints unless a reference leaked.
+	RefCount int
+
+	// Interfaces in the sandbox, with their final name.
+	Interfaces []*sandbox.Interface
+
+	// Endpoints holds the ids of the endpoints joined to the sandbox, in
+	// join order.
+	Endpoints []string
+
+	// SharesHostNetwork tells whether the sandbox shares the host network
+	// namespace.
+	SharesHostNetwork bool
+}
+
+// NetworkFilter is a client provided function which selects the Networks it
+// returns true for. The filters provided by libnetwork look like
+// NetworkFilter[...](...)
+type NetworkFilter func(nw Network) bool
+
 type sandboxData struct {
 	sandbox sandbox.Sandbox
 	refCnt  int
+	// id of the host network whose namespace is shared by the sandbox,
+	// empty when the sandbox has its own network namespace.
+	hostNetwork types.UUID
+	// endpoints joined to the sandbox, in join order.
+	endpoints []*endpoint
+	// copy of the hosts and DNS settings of the container of each joined
+	// endpoint, taken under the endpoint lock, which the other endpoints
+	// read without holding it.
+	configs map[*endpoint]containerConfig
+	// sysctls set by the joined endpoints, by name.
+	sysctls map[string]*sandboxSysctl
+	// embedded DNS resolver, running while an endpoint of a network with
+	// embedded DNS is joined.
+	resolver *resolver.Resolver
+	// dnsLock serializes the changes to the DNS settings of the joined
+	// endpoints along with the resolv.conf rewrites merging them.
+	dnsLock sync.Mutex
+}
+
+// sandboxSysctl is a sysctl the joined endpoints of a sandbox set, along with
+// its value before the first of them set it, which the last one to leave
+// restores.
+type sandboxSysctl struct {
+	value   string
+	orig    string
+	setters map[*endpoint]bool
 }
 
 type networkTable map[types.UUID]*network
 type endpointTable map[types.UUID]*endpoint
-type sandboxTable map[string]sandboxData
+type sandboxTable map[string]*sandboxData
 
 type controller struct {
+	id        string
 	networks  networkTable
 	drivers   driverTable
 	sandboxes sandboxTable
+	store     datastore.DataStore
+	events    *eventBroker
+	// names of the networks being created, reserved until they are
+	// stored in the networks table or their creation fails.
+	pendingNames map[string]struct{}
+	// ids handed out to the networks and endpoints being created, reserved
+	// until they are stored in their table or their creation fails.
+	pendingIDs map[types.UUID]struct{}
+	// genID generates the network and endpoint ids.
+	genID IDGenerator
+	// network types whose driver accepted a configuration.
+	configured map[string]bool
+	// network type of the networks created with an empty one.
+	defaultDriver string
+	// set once the controller is being stopped.
+	stopped bool
+	// sandboxFactory creates the sandboxes the endpoints join.
+	sandboxFactory SandboxFactory
+	// sandboxRoot is the directory of the keys of the sandboxes.
+	sandboxRoot string
+	// retryPolicy applies to the transient failures of the drivers.
+	retryPolicy RetryPolicy
+	// metrics collects the metrics of the controller, updated without
+	// holding the controller lock.
+	metrics Metrics
+	// netAdmin tells whether the privileged drivers can program the host.
+	netAdmin func() bool
+	// ipForwarding tells whether the drivers may enable the IP forwarding
+	// of the host.
+	ipForwarding bool
+	// The controller lock guards the tables above. When both are needed,
+	// the controller lock is acquired before the lock of a network, and a
+	// join, leave or delete holds the endpoint lock before either of them.
 	sync.Mutex
 }
 
-// New creates a new instance of network controller.
-func New() NetworkController {
-	return &controller{networkTable{}, enumerateDrivers(), sandboxTable{}, sync.Mutex{}}
+// SandboxFactory creates the sandboxes of the containers joining the
+// endpoints, as sandbox.NewSandbox does by default. The key identifies the
+// sandbox, osCreate is false for a sandbox sharing the host network
+// namespace. Another factory, such as sandbox.NullFactory, lets the join and
+// leave flow run without creating network namespaces.
+type SandboxFactory interface {
+	NewSandbox(key string, osCreate bool) (sandbox.Sandbox, error)
+}
+
+// osSandboxFactory is the default SandboxFactory, creating network
+// namespaces.
+type osSandboxFactory struct{}
+
+func (osSandboxFactory) NewSandbox(key string, osCreate bool) (sandbox.Sandbox, error) {
+	return sandbox.NewSandbox(key, osCreate)
+}
+
+// ControllerOption is a option setter function type used to pass various
+// options to New and NewWithOptions. The various setter functions of type
+// ControllerOption are provided by libnetwork, they look like
+// ControllerOption[...](...)
+type ControllerOption func(c *controller)
+
+// ControllerOptionSandboxFactory function returns an option setter for the
+// factory creating the sandboxes of the controller. GC still looks for the
+// orphan sandboxes among the network namespaces of the host.
+func ControllerOptionSandboxFactory(factory SandboxFactory) ControllerOption {
+	return func(c *controller) {
+		c.sandboxFactory = factory
+	}
+}
+
+// ControllerOptionSandboxRoot function returns an option setter for the
+// directory the keys of the sandboxes of the controller are generated in,
+// sandbox.DefaultKeyRoot by default. The controllers sharing a host, or a
+// process, with distinct roots do not clobber each other's sandboxes, and GC
+// only looks for the orphans in the root of the controller.
+func ControllerOptionSandboxRoot(dir string) ControllerOption {
+	return func(c *controller) {
+		c.sandboxRoot = dir
+	}
+}
+
+// ControllerOptionUserspaceOnly function returns an option setter for a
+// controller which does not program the host, for the processes running
+// without privileges: the drivers needing them fail with
+// InsufficientPrivilegesError, and the containers join in-memory sandboxes
+// rather than network namespaces, which leaves the null and host drivers
+// usable.
+func ControllerOptionUserspaceOnly() ControllerOption {
+	return func(c *controller) {
+		c.sandboxFactory = &sandbox.NullFactory{}
+		c.netAdmin = func() bool { return false }
+	}
+}
+
+// IDGenerator generates the ids of the networks and endpoints of a
+// controller, such as with a node prefix to keep the ids of several
+// controllers apart.
+type IDGenerator func() string
+
+// ControllerOptionIDGenerator function returns an option setter for the
+// generator of the network and endpoint ids, stringid.GenerateRandomID by
+// default. An empty id or one already in use is generated again, up to a
+// bounded number of attempts after which ErrNoUniqueID is returned. The
+// controller id is random regardless.
+func ControllerOptionIDGenerator(gen IDGenerator) ControllerOption {
+	return func(c *controller) {
+		c.genID = gen
+	}
+}
+
+// ControllerOptionIPForwarding function returns an option setter for the
+// management of the IPv4 forwarding of the host, net.ipv4.ip_forward. It is
+// enabled by default: the drivers configured to, such as the bridge one with
+// EnableIPForwarding, enable the forwarding when they create a network. With
+// it disabled the drivers leave the sysctl to the operator, and unless they
+// enable it the outgoing traffic of the containers and their published ports
+// are not routed.
+func ControllerOptionIPForwarding(enable bool) ControllerOption {
+	return func(c *controller) {
+		c.ipForwarding = enable
+	}
+}
+
+// New creates a new instance of network controller. Its state is kept in
+// memory only.
+func New(options ...ControllerOption) NetworkController {
+	c, _ := NewWithOptions(datastore.NewMemoryStore(), options...)
+	return c
+}
+
+// NewWithOptions creates a new instance of network controller which persists
+// its state in the passed store. The networks and endpoints previously saved
+// in the store are restored.
+func NewWithOptions(store datastore.DataStore, options ...ControllerOption) (NetworkController, error) {
+	c := &controller{
+		networks:       networkTable{},
+		drivers:        enumerateDrivers(),
+		sandboxes:      sandboxTable{},
+		store:          store,
+		pendingNames:   map[string]struct{}{},
+		pendingIDs:     map[types.UUID]struct{}{},
+		genID:          stringid.GenerateRandomID,
+		configured:     map[string]bool{},
+		sandboxFactory: osSandboxFactory{},
+		sandboxRoot:    sandbox.DefaultKeyRoot,
+		retryPolicy:    DefaultRetryPolicy,
+		metrics:        NullMetrics{},
+		netAdmin:       netutils.HasNetAdmin,
+		ipForwarding:   true,
+	}
+	for _, opt := range options {
+		opt(c)
+	}
+	for _, d := range c.drivers {
+		c.setGenericDataStore(d)
+		c.setIPForwarding(d)
+	}
+
+	if err := c.loadID(); err != nil {
+		return nil, err
+	}
+	c.events = newEventBroker(c.id)
+
+	if err := c.restore(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// controllerRecord is the record persisting the controller identity.
+type controllerRecord struct {
+	ID string `json:"id"`
+}
+
+func (r *controllerRecord) Key() []string {
+	return []string{controllerKey}
+}
+
+func (r *controllerRecord) Value() []byte {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// loadID reads the controller id from the store, generating and storing one
+// the first time the store is used.
+func (c *controller) loadID() error {
+	r := &controllerRecord{}
+	err := c.store.GetObject(r.Key(), r)
+	if err == nil && r.ID != "" {
+		c.id = r.ID
+		return nil
+	}
+	if err != nil && err != datastore.ErrKeyNotFound {
+		return err
+	}
+
+	r.ID = stringid.GenerateRandomID()
+	if err := c.store.PutObject(r); err != nil {
+		return err
+	}
+	c.id = r.ID
+	return nil
+}
+
+func (c *controller) ID() string {
+	return c.id
 }
 
 func (c *controller) ConfigureNetworkDriver(networkType string, options interface{}) error {
-	d, ok := c.drivers[networkType]
+	d, ok := c.driverGet(networkType)
+	if !ok {
+		return NetworkTypeError(networkType)
+	}
+
+	if err := c.checkPrivileges(networkType, d); err != nil {
+		return err
+	}
+
+	// A rejected configuration leaves the driver as it was, configured or
+	// not
+	if err := d.Config(options); err != nil {
+		return privilegesError(networkType, err)
+	}
+
+	c.Lock()
+	c.configured[networkType] = true
+	pending := c.pendingRestores(networkType)
+	c.Unlock()
+
+	for _, n := range pending {
+		c.restoreNetwork(n)
+	}
+
+	return nil
+}
+
+func (c *controller) ReloadDriverConfig(networkType string, options interface{}) error {
+	d, ok := c.driverGet(networkType)
 	if !ok {
 		return NetworkTypeError(networkType)
 	}
-	return d.Config(options)
+
+	if !c.isConfigured(networkType) {
+		return ErrDriverNotConfigured
+	}
+
+	r, ok := d.(driverapi.Reloader)
+	if !ok {
+		return ReloadNotSupportedError(networkType)
+	}
+
+	if err := r.ReloadConfig(options); err != nil {
+		return err
+	}
+
+	c.events.publish(EventDriverReload, networkType)
+
+	return nil
+}
+
+func (c *controller) SetDefaultDriver(networkType string) {
+	c.Lock()
+	c.defaultDriver = networkType
+	c.Unlock()
+}
+
+func (c *controller) RegisterDriver(networkType string, d driverapi.Driver) error {
+	if networkType == "" {
+		return ErrEmptyNetworkType
+	}
+
+	if d == nil {
+		return ErrNilNetworkDriver
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.drivers[networkType]; ok {
+		return ErrDriverExists
+	}
+	c.drivers[networkType] = d
+	c.setGenericDataStore(d)
+	c.setIPForwarding(d)
+
+	return nil
+}
+
+// checkPrivileges returns InsufficientPrivilegesError if the driver d of the
+// network type programs the host while the process can not.
+func (c *controller) checkPrivileges(networkType string, d driverapi.Driver) error {
+	if d.Capabilities().Privileged && !c.netAdmin() {
+		return &InsufficientPrivilegesError{NetworkType: networkType}
+	}
+	return nil
+}
+
+// privilegesError returns the error of the driver of the network type as an
+// InsufficientPrivilegesError when the host denied the driver an operation.
+func privilegesError(networkType string, err error) error {
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) {
+		return &InsufficientPrivilegesError{NetworkType: networkType, Err: err}
+	}
+	return err
+}
+
+// setGenericDataStore hands the controller to the driver d as its
+// driverapi.GenericDataStore, if d keeps generic data.
+func (c *controller) setGenericDataStore(d driverapi.Driver) {
+	if u, ok := d.(driverapi.GenericDataUser); ok {
+		u.SetGenericDataStore(c)
+	}
+}
+
+// setIPForwarding tells the driver d whether it may enable the IP forwarding
+// of the host.
+func (c *controller) setIPForwarding(d driverapi.Driver) {
+	if u, ok := d.(driverapi.IPForwardingUser); ok {
+		u.SetIPForwarding(c.ipForwarding)
+	}
+}
+
+func (c *controller) isConfigured(networkType string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.configured[networkType]
+}
+
+func (c *controller) driverGet(networkType string) (driverapi.Driver, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	d, ok := c.drivers[networkType]
+	return d, ok
 }
 
 // NewNetwork creates a new network of the specified network type. The options
 // are network specific and modeled in a generic way.
-func (c *controller) NewNetwork(networkType, name string, options interface{}) (Network, error) {
-	// Check if a driver for the specified network type is available
-	d, ok := c.drivers[networkType]
-	if !ok {
-		return nil, ErrInvalidNetworkDriver
+func (c *controller) NewNetwork(networkType, name string, options interface{}, netOptions ...NetworkOption) (Network, error) {
+	return c.NewNetworkWithContext(context.Background(), networkType, name, options, netOptions...)
+}
+
+func (c *controller) NewNetworkWithContext(ctx context.Context, networkType, name string, options interface{}, netOptions ...NetworkOption) (Network, error) {
+	if !validName(name) {
+		return nil, InvalidNameError(name)
+	}
+
+	networkType, err := c.networkType(networkType)
+	if err != nil {
+		return nil, err
+	}
+	d, err := c.networkDriver(networkType)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkFeatures(d, networkFeatures, options); err != nil {
+		return nil, err
 	}
 
-	// Check if a network already exists with the specified network name
+	// Check if a network already exists with the specified network name and
+	// reserve the name while the driver creates the network
 	c.Lock()
+	if c.stopped {
+		c.Unlock()
+		return nil, ErrControllerStopped
+	}
+	if _, ok := c.pendingNames[name]; ok {
+		c.Unlock()
+		return nil, NetworkNameError(name)
+	}
 	for _, n := range c.networks {
 		if n.name == name {
 			c.Unlock()
 			return nil, NetworkNameError(name)
 		}
 	}
+	c.pendingNames[name] = struct{}{}
 	c.Unlock()
+	defer func() {
+		c.Lock()
+		delete(c.pendingNames, name)
+		c.Unlock()
+	}()
+
+	id, err := c.newID()
+	if err != nil {
+		return nil, err
+	}
+	defer c.releaseID(id)
 
 	// Construct the network object
 	network := &network{
-		name:      name,
-		id:        types.UUID(stringid.GenerateRandomID()),
-		ctrlr:     c,
-		driver:    d,
-		endpoints: endpointTable{},
+		name:        name,
+		networkType: networkType,
+		id:          id,
+		ctrlr:       c,
+		driver:      d,
+		endpoints:   endpointTable{},
 	}
 
-	// Create the network
-	if err := d.CreateNetwork(network.id, options); err != nil {
+	network.processOptions(netOptions...)
+
+	// Create the network. Should the driver fail half way, have it remove
+	// what it may have leaked, before any retry.
+	err = c.retryPolicy.run(ctx, "network creation", func() error {
+		start := time.Now()
+		err := d.CreateNetworkWithContext(ctx, network.id, options)
+		c.observeDriver(networkType, driverCreateNetwork, start)
+		if err != nil {
+			if e := d.DeleteNetwork(network.id); e != nil && e != driverapi.ErrNoNetwork {
+				log.Warnf("Failed to remove network %s after creation failure: %v", network.id, e)
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, privilegesError(networkType, err)
+	}
+
+	// The driver may have completed right as the context got done
+	if err := ctx.Err(); err != nil {
+		if e := d.DeleteNetwork(network.id); e != nil {
+			log.Warnf("Failed to remove network %s after cancellation: %v", network.id, e)
+		}
+		return nil, err
+	}
+
+	// Persist the network so that it can be restored after a restart
+	if err := c.store.PutObject(network); err != nil {
+		if e := d.DeleteNetwork(network.id); e != nil {
+			log.Warnf("Failed to remove network %s after store failure: %v", network.id, e)
+		}
 		return nil, err
 	}
 
@@ -141,9 +745,73 @@ func (c *controller) NewNetwork(networkType, name string, options interface{}) (
 	c.networks[network.id] = network
 	c.Unlock()
 
+	c.events.publish(EventNetworkCreate, string(network.id))
+	c.metrics.IncCounter(MetricNetworksCreated)
+
 	return network, nil
 }
 
+func (c *controller) ValidateNetworkConfig(networkType string, options interface{}) error {
+	networkType, err := c.networkType(networkType)
+	if err != nil {
+		return err
+	}
+	d, err := c.networkDriver(networkType)
+	if err != nil {
+		return err
+	}
+	if err := checkFeatures(d, networkFeatures, options); err != nil {
+		return err
+	}
+	return d.ValidateNetwork(options)
+}
+
+func (c *controller) DriverCapabilities(networkType string) (driverapi.DriverCapability, error) {
+	networkType, err := c.networkType(networkType)
+	if err != nil {
+		return driverapi.DriverCapability{}, err
+	}
+	d, ok := c.driverGet(networkType)
+	if !ok {
+		return driverapi.DriverCapability{}, ErrInvalidNetworkDriver
+	}
+	return d.Capabilities(), nil
+}
+
+// networkType returns the passed network type, or the default one when empty.
+func (c *controller) networkType(networkType string) (string, error) {
+	if networkType != "" {
+		return networkType, nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if c.defaultDriver == "" {
+		return "", ErrNoDefaultDriver
+	}
+	return c.defaultDriver, nil
+}
+
+// networkDriver returns the driver networks of the network type can be
+// created with.
+func (c *controller) networkDriver(networkType string) (driverapi.Driver, error) {
+	// Check if a driver for the specified network type is available
+	d, ok := c.driverGet(networkType)
+	if !ok {
+		return nil, ErrInvalidNetworkDriver
+	}
+
+	if err := c.checkPrivileges(networkType, d); err != nil {
+		return nil, err
+	}
+
+	if d.ConfigRequired() && !c.isConfigured(networkType) {
+		return nil, ErrDriverNotConfigured
+	}
+
+	return d, nil
+}
+
 func (c *controller) Networks() []Network {
 	c.Lock()
 	defer c.Unlock()
@@ -191,36 +859,472 @@ func (c *controller) NetworkByID(id string) Network {
 	return nil
 }
 
-func (c *controller) sandboxAdd(key string) (sandbox.Sandbox, error) {
+func (c *controller) NetworksByLabel(key, value string) []Network {
+	return c.FilterNetworks(NetworkFilterLabel(key, value))
+}
+
+func (c *controller) NetworksByType(networkType string) []Network {
+	return c.FilterNetworks(NetworkFilterType(networkType))
+}
+
+func (c *controller) FilterNetworks(filters ...NetworkFilter) []Network {
+	var list []Network
+
+	s := func(current Network) bool {
+		for _, f := range filters {
+			if !f(current) {
+				return false
+			}
+		}
+		list = append(list, current)
+		return false
+	}
+
+	c.WalkNetworks(s)
+
+	return list
+}
+
+// NetworkFilterType function returns a filter selecting the networks of the
+// passed network type.
+func NetworkFilterType(networkType string) NetworkFilter {
+	return func(nw Network) bool {
+		return nw.Type() == networkType
+	}
+}
+
+// NetworkFilterLabel function returns a filter selecting the networks which
+// have the passed label key set to value.
+func NetworkFilterLabel(key, value string) NetworkFilter {
+	return func(nw Network) bool {
+		v, ok := nw.Labels()[key]
+		return ok && v == value
+	}
+}
+
+func (c *controller) DeleteNetwork(id string) error {
+	c.Lock()
+	n, ok := c.networks[types.UUID(id)]
+	c.Unlock()
+	if !ok {
+		return &UnknownNetworkError{id: id}
+	}
+
+	return n.Delete()
+}
+
+func (c *controller) Subscribe() (<-chan Event, func()) {
+	return c.events.subscribe()
+}
+
+func (c *controller) GC(alive func(key string) bool, dryRun bool) ([]string, error) {
+	if alive == nil {
+		return nil, ErrNoAlivePredicate
+	}
+
+	keys, err := sandbox.KeysIn(c.sandboxRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	known := c.sandboxKeys()
+
+	var orphans []string
+	for _, key := range keys {
+		if _, ok := known[key]; ok {
+			continue
+		}
+		if alive(key) {
+			continue
+		}
+
+		if !dryRun {
+			if err := sandbox.Remove(key); err != nil {
+				return orphans, err
+			}
+		}
+		orphans = append(orphans, key)
+	}
+
+	return orphans, nil
+}
+
+func (c *controller) Stop() error {
+	return c.stop(false)
+}
+
+func (c *controller) ForceStop() error {
+	return c.stop(true)
+}
+
+func (c *controller) stop(force bool) error {
+	c.Lock()
+	if c.stopped {
+		c.Unlock()
+		return ErrControllerStopped
+	}
+
+	networks := make([]*network, 0, len(c.networks))
+	for _, n := range c.networks {
+		n.Lock()
+		numEps := len(n.endpoints)
+		n.Unlock()
+		if numEps != 0 && !force {
+			c.Unlock()
+			return &ActiveEndpointsError{name: n.name, id: string(n.id)}
+		}
+		networks = append(networks, n)
+	}
+
+	// No network gets created while the existing ones are deleted
+	c.stopped = true
+	c.Unlock()
+
+	if err := c.deleteNetworks(networks); err != nil {
+		// Leave the controller usable, the stop can be retried
+		c.Lock()
+		c.stopped = false
+		c.Unlock()
+		return err
+	}
+
+	// Only the sandboxes no endpoint released are left at this point
+	c.Lock()
+	defer c.Unlock()
+	for key, sData := range c.sandboxes {
+		if sData.resolver != nil {
+			sData.resolver.Stop()
+		}
+		if err := sData.sandbox.Destroy(); err != nil {
+			log.Warnf("Failed to destroy sandbox %s: %v", key, err)
+		}
+		delete(c.sandboxes, key)
+	}
+
+	return nil
+}
+
+// deleteNetworks deletes the passed networks, after their endpoints.
+func (c *controller) deleteNetworks(networks []*network) error {
+	for _, n := range networks {
+		for _, e := range n.Endpoints() {
+			ep := e.(*endpoint)
+			if id := ep.ContainerID(); id != "" {
+				if err := ep.Leave(id); err != nil {
+					return err
+				}
+			} else if ep.State() == EndpointAttached {
+				if err := ep.RemoveFromSandbox(); err != nil {
+					return err
+				}
+			}
+			if err := ep.Delete(); err != nil {
+				return err
+			}
+		}
+
+		if err := n.Delete(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *controller) ReleaseLeasesFor(containerID string) error {
+	if containerID == "" {
+		return InvalidContainerIDError(containerID)
+	}
+
+	var err error
+	for _, n := range c.Networks() {
+		for _, e := range n.Endpoints() {
+			ep := e.(*endpoint)
+			if ep.LeasedTo() != containerID {
+				continue
+			}
+			if ep.ContainerID() == containerID {
+				if e := ep.Leave(containerID); e != nil {
+					if err == nil {
+						err = e
+					}
+					continue
+				}
+			}
+			if e := ep.Delete(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+
+	return err
+}
+
+// Sandboxes copies the sandboxes of the controller under the controller
+// lock. The interfaces are copied from the sandboxes themselves.
+func (c *controller) Sandboxes() []SandboxInfo {
+	c.Lock()
+	defer c.Unlock()
+
+	list := make([]SandboxInfo, 0, len(c.sandboxes))
+	for key, sData := range c.sandboxes {
+		info := SandboxInfo{
+			Key:               key,
+			RefCount:          sData.refCnt,
+			SharesHostNetwork: sData.hostNetwork != "",
+		}
+		for _, i := range sData.sandbox.Interfaces() {
+			info.Interfaces = append(info.Interfaces, i.GetCopy())
+		}
+		for _, ep := range sData.endpoints {
+			info.Endpoints = append(info.Endpoints, string(ep.id))
+		}
+		list = append(list, info)
+	}
+
+	return list
+}
+
+// WalkSandboxes walks the copy Sandboxes returns, without holding any lock
+// while the walker runs.
+func (c *controller) WalkSandboxes(walker SandboxWalker) {
+	for _, sb := range c.Sandboxes() {
+		if walker(sb) {
+			return
+		}
+	}
+}
+
+// sandboxKeys returns the keys of the sandboxes the endpoints of this
+// controller are joined to.
+func (c *controller) sandboxKeys() map[string]struct{} {
+	c.Lock()
+	defer c.Unlock()
+
+	known := make(map[string]struct{}, len(c.sandboxes))
+	for key := range c.sandboxes {
+		known[key] = struct{}{}
+	}
+
+	return known
+}
+
+func (c *controller) restore() error {
+	records, err := c.store.List([]string{networkKeyPrefix})
+	if err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		n := &network{ctrlr: c, endpoints: endpointTable{}}
+		if err := n.setValue(r); err != nil {
+			return err
+		}
+
+		d, ok := c.driverGet(n.networkType)
+		if !ok {
+			log.Warnf("Skipping restore of network %s: unknown driver %q", n.id, n.networkType)
+			continue
+		}
+		n.driver = d
+
+		epRecords, err := c.store.List([]string{endpointKeyPrefix, string(n.id)})
+		if err != nil {
+			return err
+		}
+
+		for _, er := range epRecords {
+			ep := &endpoint{network: n}
+			if err := ep.setValue(er); err != nil {
+				return err
+			}
+			n.endpoints[ep.id] = ep
+			n.endpointCnt++
+			c.addAllocatedIPs(ep.sandboxInfo, false)
+		}
+
+		c.networks[n.id] = n
+
+		// The driver requiring a configuration takes the network back
+		// once configured
+		if d.ConfigRequired() {
+			n.restore = restorePending
+			continue
+		}
+		n.restore = restoreRunning
+		c.restoreNetwork(n)
+	}
+
+	return nil
+}
+
+// pendingRestores returns the networks of the network type restored from the
+// store which wait for their driver to be configured, marking them as being
+// taken back. It must be called with the controller lock held.
+func (c *controller) pendingRestores(networkType string) []*network {
+	var pending []*network
+	for _, n := range c.networks {
+		n.Lock()
+		if n.networkType == networkType && n.restore == restorePending {
+			n.restore = restoreRunning
+			pending = append(pending, n)
+		}
+		n.Unlock()
+	}
+	return pending
+}
+
+// restoreNetwork has the driver take back the network restored from the
+// store, then its endpoints. A network or an endpoint the driver fails to
+// take back is kept, so that it can still be deleted.
+func (c *controller) restoreNetwork(n *network) {
+	state := restoreDone
+	if err := n.driver.RestoreNetwork(n.id); err != nil {
+		log.Warnf("Failed to restore network %s with its driver: %v", n.id, err)
+		state = restoreFailed
+	} else {
+		for _, e := range n.Endpoints() {
+			ep := e.(*endpoint)
+			if err := n.driver.RestoreEndpoint(n.id, ep.id, ep.SandboxInfo()); err != nil {
+				log.Warnf("Failed to restore endpoint %s with its driver: %v", ep.id, err)
+				ep.Lock()
+				ep.restoreFailed = true
+				ep.Unlock()
+			}
+		}
+	}
+
+	n.Lock()
+	n.restore = state
+	n.Unlock()
+}
+
+// newID generates an id no network or endpoint uses and reserves it until
+// released, regenerating it up to maxIDAttempts times on collision.
+func (c *controller) newID() (types.UUID, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	for i := 0; i < maxIDAttempts; i++ {
+		id := types.UUID(c.genID())
+		if id == "" || c.idInUse(id) {
+			continue
+		}
+		c.pendingIDs[id] = struct{}{}
+		return id, nil
+	}
+
+	return "", ErrNoUniqueID
+}
+
+// idInUse tells whether id is used or reserved by a network or an endpoint.
+// It must be called with the controller lock held.
+func (c *controller) idInUse(id types.UUID) bool {
+	if _, ok := c.pendingIDs[id]; ok {
+		return true
+	}
+	if _, ok := c.networks[id]; ok {
+		return true
+	}
+
+	for _, n := range c.networks {
+		n.Lock()
+		_, ok := n.endpoints[id]
+		n.Unlock()
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *controller) releaseID(id types.UUID) {
+	c.Lock()
+	delete(c.pendingIDs, id)
+	c.Unlock()
+}
+
+// sandboxKey returns the key of the sandbox of the container, in the root of
+// the controller.
+func (c *controller) sandboxKey(containerID string) string {
+	return sandbox.GenerateKeyIn(c.sandboxRoot, containerID)
+}
+
+// sandboxAdd creates or references the sandbox identified by key on behalf of
+// the joining endpoint ep. A non empty hostNetwork makes the sandbox share the
+// host network namespace on behalf of the passed host network.
+// It must be called with the endpoint lock held.
+func (c *controller) sandboxAdd(key string, hostNetwork types.UUID, ep *endpoint) (sandbox.Sandbox, error) {
+	// Counted once the lock is released
+	created := false
+	defer func() {
+		if created {
+			c.metrics.AddGauge(MetricSandboxes, 1)
+		}
+	}()
+
 	c.Lock()
 	defer c.Unlock()
 
 	sData, ok := c.sandboxes[key]
 	if !ok {
-		sb, err := sandbox.NewSandbox(key)
+		sb, err := c.sandboxFactory.NewSandbox(key, hostNetwork == "")
 		if err != nil {
 			return nil, err
 		}
 
-		sData = sandboxData{sandbox: sb, refCnt: 1}
+		sData = &sandboxData{sandbox: sb, refCnt: 1, hostNetwork: hostNetwork, configs: make(map[*endpoint]containerConfig)}
+		sData.endpoints = append(sData.endpoints, ep)
+		sData.configs[ep] = ep.container.Config.sandboxCopy()
 		c.sandboxes[key] = sData
+		created = true
 		return sData.sandbox, nil
 	}
 
+	if sData.hostNetwork != hostNetwork {
+		return nil, ErrHostNetworkConflict
+	}
+
 	sData.refCnt++
+	sData.endpoints = append(sData.endpoints, ep)
+	sData.configs[ep] = ep.container.Config.sandboxCopy()
 	return sData.sandbox, nil
 }
 
-func (c *controller) sandboxRm(key string) {
+func (c *controller) sandboxRm(key string, ep *endpoint) {
+	// Counted once the lock is released
+	destroyed := false
+	defer func() {
+		if destroyed {
+			c.metrics.AddGauge(MetricSandboxes, -1)
+		}
+	}()
+
 	c.Lock()
 	defer c.Unlock()
 
-	sData := c.sandboxes[key]
+	sData, ok := c.sandboxes[key]
+	if !ok {
+		return
+	}
+
 	sData.refCnt--
+	for i, e := range sData.endpoints {
+		if e == ep {
+			sData.endpoints = append(sData.endpoints[:i], sData.endpoints[i+1:]...)
+			break
+		}
+	}
+	delete(sData.configs, ep)
 
 	if sData.refCnt == 0 {
+		if sData.resolver != nil {
+			sData.resolver.Stop()
+		}
 		sData.sandbox.Destroy()
 		delete(c.sandboxes, key)
+		destroyed = true
 	}
 }
 
@@ -235,3 +1339,205 @@ func (c *controller) sandboxGet(key string) sandbox.Sandbox {
 
 	return sData.sandbox
 }
+
+// sandboxEndpoints returns the endpoints joined to the sandbox identified by
+// key, in join order, along with a copy of the hosts and DNS settings of
+// their container. The settings are to be read from the copies: the other
+// endpoints change theirs under their own lock.
+func (c *controller) sandboxEndpoints(key string) ([]*endpoint, []containerConfig) {
+	c.Lock()
+	defer c.Unlock()
+
+	sData, ok := c.sandboxes[key]
+	if !ok {
+		return nil, nil
+	}
+
+	eps := make([]*endpoint, len(sData.endpoints))
+	configs := make([]containerConfig, len(sData.endpoints))
+	for i, ep := range sData.endpoints {
+		eps[i] = ep
+		configs[i] = sData.configs[ep].sandboxCopy()
+	}
+	return eps, configs
+}
+
+// sandboxSetSysctl sets the sysctl name to value in the sandbox identified by
+// key on behalf of ep, unless another joined endpoint already did.
+// SysctlConflictError is returned if another endpoint set it to another
+// value.
+func (c *controller) sandboxSetSysctl(key string, ep *endpoint, name, value string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	sData, ok := c.sandboxes[key]
+	if !ok {
+		return ErrNoContainer
+	}
+
+	if s, ok := sData.sysctls[name]; ok {
+		if s.value != value {
+			return &SysctlConflictError{Name: name, Value: s.value}
+		}
+		s.setters[ep] = true
+		return nil
+	}
+
+	orig, err := sData.sandbox.Sysctl(name)
+	if err != nil {
+		return err
+	}
+	if err := sData.sandbox.SetSysctl(name, value); err != nil {
+		return err
+	}
+	if sData.sysctls == nil {
+		sData.sysctls = make(map[string]*sandboxSysctl)
+	}
+	sData.sysctls[name] = &sandboxSysctl{value: value, orig: orig, setters: map[*endpoint]bool{ep: true}}
+	return nil
+}
+
+// sandboxUnsetSysctls releases the sysctls ep set in the sandbox identified
+// by key, setting back to their previous value the ones no other joined
+// endpoint set. The sysctls of the interfaces which left the sandbox are
+// ignored.
+func (c *controller) sandboxUnsetSysctls(key string, ep *endpoint) {
+	c.Lock()
+	defer c.Unlock()
+
+	sData, ok := c.sandboxes[key]
+	if !ok {
+		return
+	}
+
+	for name, s := range sData.sysctls {
+		if !s.setters[ep] {
+			continue
+		}
+		delete(s.setters, ep)
+		if len(s.setters) != 0 {
+			continue
+		}
+		delete(sData.sysctls, name)
+		if err := sData.sandbox.SetSysctl(name, s.orig); err != nil {
+			if _, ok := err.(sandbox.InvalidSysctlError); !ok {
+				log.Warnf("Failed to restore sysctl %s of endpoint %s: %v", name, ep.id, err)
+			}
+		}
+	}
+}
+
+// sandboxUpdateConfig copies the hosts and DNS settings of the container of
+// ep, joined to the sandbox identified by key, again. It must be called with
+// the endpoint lock held.
+func (c *controller) sandboxUpdateConfig(key string, ep *endpoint) {
+	c.Lock()
+	defer c.Unlock()
+
+	if sData, ok := c.sandboxes[key]; ok {
+		if _, ok := sData.configs[ep]; ok {
+			sData.configs[ep] = ep.container.Config.sandboxCopy()
+		}
+	}
+}
+
+// sandboxDNSLock returns the lock serializing the resolv.conf rewrites of the
+// sandbox identified by key, nil if the sandbox does not exist.
+func (c *controller) sandboxDNSLock(key string) *sync.Mutex {
+	c.Lock()
+	defer c.Unlock()
+
+	sData, ok := c.sandboxes[key]
+	if !ok {
+		return nil
+	}
+
+	return &sData.dnsLock
+}
+
+// sandboxResolver starts the embedded DNS resolver of the sandbox identified
+// by key when one of its joined endpoints belongs to a network with embedded
+// DNS, or stops it once none does. It returns the running resolver, nil if
+// none. Sandboxes sharing the host network namespace have no resolver.
+func (c *controller) sandboxResolver(key string) (*resolver.Resolver, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	sData, ok := c.sandboxes[key]
+	if !ok || sData.hostNetwork != "" {
+		return nil, nil
+	}
+
+	wanted := false
+	for _, ep := range sData.endpoints {
+		if ep.network.embeddedDNS {
+			wanted = true
+			break
+		}
+	}
+
+	if !wanted {
+		if sData.resolver != nil {
+			sData.resolver.Stop()
+			sData.resolver = nil
+		}
+		return nil, nil
+	}
+
+	if sData.resolver == nil {
+		r := resolver.New(func(name string, ipv6 bool) ([]net.IP, bool) {
+			return c.resolveName(key, name, ipv6)
+		})
+		if err := r.Start(key); err != nil {
+			return nil, err
+		}
+		sData.resolver = r
+	}
+
+	return sData.resolver, nil
+}
+
+// resolveName returns the addresses of the joined endpoints known by name in
+// the networks with embedded DNS the sandbox identified by key joined. Names
+// match regardless of their case.
+func (c *controller) resolveName(key, name string, ipv6 bool) ([]net.IP, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	sData, ok := c.sandboxes[key]
+	if !ok {
+		return nil, false
+	}
+
+	networks := make(map[*network]bool)
+	for _, ep := range sData.endpoints {
+		if ep.network.embeddedDNS {
+			networks[ep.network] = true
+		}
+	}
+
+	var (
+		ips   []net.IP
+		found bool
+	)
+	for _, s := range c.sandboxes {
+		for _, ep := range s.endpoints {
+			if !networks[ep.network] || !ep.hasDNSName(name) {
+				continue
+			}
+			found = true
+
+			if ep.sandboxInfo == nil || len(ep.sandboxInfo.Interfaces) == 0 {
+				continue
+			}
+			i := ep.sandboxInfo.Interfaces[0]
+			if !ipv6 && i.Address != nil {
+				ips = append(ips, netutils.GetIPCopy(i.Address.IP))
+			} else if ipv6 && i.AddressIPv6 != nil {
+				ips = append(ips, netutils.GetIPCopy(i.AddressIPv6.IP))
+			}
+		}
+	}
+
+	return ips, found
+}
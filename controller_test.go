@@ -0,0 +1,1677 @@
+package libnetwork
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/pkg/options"
+	"github.com/docker/libnetwork/sandbox"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+func TestSandboxRefCount(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	c := New().(*controller)
+
+	key := sandbox.GenerateKey("refcount_container")
+	eps := []*endpoint{{container: &containerInfo{}}, {container: &containerInfo{}}, {container: &containerInfo{}}}
+	for _, ep := range eps {
+		if _, err := c.sandboxAdd(key, "", ep); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if c.sandboxes[key].refCnt != 3 {
+		t.Fatalf("Expected sandbox refcount 3, got %d", c.sandboxes[key].refCnt)
+	}
+
+	for i := 0; i < 2; i++ {
+		c.sandboxRm(key, eps[i])
+		if c.sandboxGet(key) == nil {
+			t.Fatalf("Sandbox destroyed after %d removals out of 3", i+1)
+		}
+	}
+
+	if joined, _ := c.sandboxEndpoints(key); len(joined) != 1 || joined[0] != eps[2] {
+		t.Fatalf("Expected the last endpoint only to remain joined, got %v", joined)
+	}
+
+	c.sandboxRm(key, eps[2])
+	if c.sandboxGet(key) != nil {
+		t.Fatal("Sandbox not destroyed after the last removal")
+	}
+}
+
+var errFakeCreate = errors.New("fake network creation failure")
+
+// leakyDriver fails network creation after having created a resource for
+// the network, which only DeleteNetwork releases.
+type leakyDriver struct {
+	resources map[types.UUID]bool
+}
+
+func (d *leakyDriver) Config(config interface{}) error {
+	return nil
+}
+
+func (d *leakyDriver) CreateNetwork(nid types.UUID, config interface{}) error {
+	return d.CreateNetworkWithContext(context.Background(), nid, config)
+}
+
+func (d *leakyDriver) CreateNetworkWithContext(ctx context.Context, nid types.UUID, config interface{}) error {
+	d.resources[nid] = true
+	return errFakeCreate
+}
+
+func (d *leakyDriver) ValidateNetwork(config interface{}) error {
+	return nil
+}
+
+func (d *leakyDriver) UpdateNetwork(nid types.UUID, config interface{}) error {
+	return nil
+}
+
+func (d *leakyDriver) DeleteNetwork(nid types.UUID) error {
+	if !d.resources[nid] {
+		return driverapi.ErrNoNetwork
+	}
+	delete(d.resources, nid)
+	return nil
+}
+
+func (d *leakyDriver) NetworkInfo(nid types.UUID) (*driverapi.NetworkInfo, error) {
+	return nil, driverapi.ErrNoNetwork
+}
+
+func (d *leakyDriver) CreateEndpoint(nid, eid types.UUID, config interface{}) (*sandbox.Info, error) {
+	return nil, nil
+}
+
+func (d *leakyDriver) CreateEndpointWithContext(ctx context.Context, nid, eid types.UUID, config interface{}) (*sandbox.Info, error) {
+	return nil, nil
+}
+
+func (d *leakyDriver) ValidateEndpoint(nid types.UUID, config interface{}) error {
+	return nil
+}
+
+func (d *leakyDriver) DeleteEndpoint(nid, eid types.UUID) error {
+	return nil
+}
+
+func (d *leakyDriver) Join(nid, eid types.UUID, sboxKey string) (*driverapi.JoinInfo, error) {
+	return nil, nil
+}
+
+func (d *leakyDriver) Leave(nid, eid types.UUID) error {
+	return nil
+}
+
+func (d *leakyDriver) RestoreNetwork(nid types.UUID) error {
+	return nil
+}
+
+func (d *leakyDriver) RestoreEndpoint(nid, eid types.UUID, sinfo *sandbox.Info) error {
+	return nil
+}
+
+func (d *leakyDriver) ConfigRequired() bool {
+	return false
+}
+
+func (d *leakyDriver) Type() string {
+	return "leaky"
+}
+
+func (d *leakyDriver) Capabilities() driverapi.DriverCapability {
+	return driverapi.DriverCapability{IPv6: true, PortMapping: true, MultiHost: true, StaticIP: true}
+}
+
+func TestNewNetworkFailureCleanup(t *testing.T) {
+	c := New().(*controller)
+
+	d := &leakyDriver{resources: map[types.UUID]bool{}}
+	c.drivers[d.Type()] = d
+
+	if _, err := c.NewNetwork(d.Type(), "testnetwork", nil); err != errFakeCreate {
+		t.Fatalf("Expected %v, got %v", errFakeCreate, err)
+	}
+
+	if len(d.resources) != 0 {
+		t.Fatalf("Resources of the failed network were not cleaned up: %v", d.resources)
+	}
+
+	if len(c.networks) != 0 {
+		t.Fatalf("Failed network stored in the controller: %v", c.networks)
+	}
+}
+
+// deniedDriver is a privileged driver the host denies the network creation.
+type deniedDriver struct {
+	*leakyDriver
+}
+
+func (d *deniedDriver) CreateNetworkWithContext(ctx context.Context, nid types.UUID, config interface{}) error {
+	return syscall.EPERM
+}
+
+func (d *deniedDriver) Type() string {
+	return "denied"
+}
+
+func (d *deniedDriver) Capabilities() driverapi.DriverCapability {
+	return driverapi.DriverCapability{Privileged: true}
+}
+
+func TestInsufficientPrivileges(t *testing.T) {
+	c := New().(*controller)
+	c.netAdmin = func() bool { return false }
+
+	for _, networkType := range []string{"bridge", "macvlan"} {
+		err := c.ConfigureNetworkDriver(networkType, options.Generic{})
+		if _, ok := err.(*InsufficientPrivilegesError); !ok || !errors.Is(err, ErrInsufficientPrivileges) {
+			t.Fatalf("Expected an insufficient privileges error configuring %s, got %v", networkType, err)
+		}
+		if _, err := c.NewNetwork(networkType, networkType+"network", nil); !errors.Is(err, ErrInsufficientPrivileges) || !types.IsForbidden(err) {
+			t.Fatalf("Expected an insufficient privileges error creating a %s network, got %v", networkType, err)
+		}
+	}
+
+	// The drivers not programming the host need no privileges
+	for _, networkType := range []string{"null", "host"} {
+		if _, err := c.NewNetwork(networkType, networkType+"network", nil); err != nil {
+			t.Fatalf("Failed to create a %s network: %v", networkType, err)
+		}
+	}
+
+	// The denials of the host surface the same way
+	c.netAdmin = func() bool { return true }
+	d := &deniedDriver{&leakyDriver{resources: map[types.UUID]bool{}}}
+	c.drivers[d.Type()] = d
+	_, err := c.NewNetwork(d.Type(), "deniednetwork", nil)
+	if e, ok := err.(*InsufficientPrivilegesError); !ok || e.Err != syscall.EPERM || !errors.Is(err, ErrInsufficientPrivileges) {
+		t.Fatalf("Expected an insufficient privileges error wrapping %v, got %v", syscall.EPERM, err)
+	}
+}
+
+func TestControllerOptionUserspaceOnly(t *testing.T) {
+	c := New(ControllerOptionUserspaceOnly()).(*controller)
+
+	if _, err := c.NewNetwork("bridge", "bridgenetwork", nil); !errors.Is(err, ErrInsufficientPrivileges) {
+		t.Fatalf("Expected an insufficient privileges error, got %v", err)
+	}
+
+	n, err := c.NewNetwork("null", "nullnetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := n.CreateEndpoint("ep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The container joins an in-memory sandbox
+	cData, err := ep.Join("userspace_container")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.sandboxGet(cData.SandboxKey).(*sandbox.NullSandbox); !ok {
+		t.Fatalf("Expected an in-memory sandbox, got %T", c.sandboxGet(cData.SandboxKey))
+	}
+	if err := ep.Leave("userspace_container"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sequenceIDs returns an id generator handing out the passed ids in order,
+// then repeating the last one.
+func sequenceIDs(ids ...string) func() string {
+	return func() string {
+		id := ids[0]
+		if len(ids) > 1 {
+			ids = ids[1:]
+		}
+		return id
+	}
+}
+
+var errFakeJoin = errors.New("fake join failure")
+
+// faultyJoinDriver creates endpoints with a veth interface to move into the
+// sandbox, and fails to join them once the interface is in the sandbox.
+type faultyJoinDriver struct {
+	leakyDriver
+}
+
+func (d *faultyJoinDriver) CreateNetwork(nid types.UUID, config interface{}) error {
+	return nil
+}
+
+func (d *faultyJoinDriver) CreateNetworkWithContext(ctx context.Context, nid types.UUID, config interface{}) error {
+	return nil
+}
+
+func (d *faultyJoinDriver) CreateEndpoint(nid, eid types.UUID, config interface{}) (*sandbox.Info, error) {
+	return d.CreateEndpointWithContext(context.Background(), nid, eid, config)
+}
+
+func (d *faultyJoinDriver) CreateEndpointWithContext(ctx context.Context, nid, eid types.UUID, config interface{}) (*sandbox.Info, error) {
+	name := "faulty" + string(eid)[:4]
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: name + "p"}
+	if err := netlink.LinkAdd(veth); err != nil {
+		return nil, err
+	}
+	addr := &net.IPNet{IP: net.IPv4(192, 168, 254, 2), Mask: net.CIDRMask(24, 32)}
+	return &sandbox.Info{Interfaces: []*sandbox.Interface{{SrcName: name, DstName: "eth", Address: addr}}}, nil
+}
+
+func (d *faultyJoinDriver) Join(nid, eid types.UUID, sboxKey string) (*driverapi.JoinInfo, error) {
+	return nil, errFakeJoin
+}
+
+func (d *faultyJoinDriver) Type() string {
+	return "faulty"
+}
+
+func TestJoinFailureRollback(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	c := New().(*controller)
+
+	d := &faultyJoinDriver{}
+	c.drivers[d.Type()] = d
+
+	n, err := c.NewNetwork(d.Type(), "faultynetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := n.CreateEndpoint("faultyep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcName := ep.SandboxInfo().Interfaces[0].SrcName
+
+	// The sandbox created for the join is destroyed
+	key := sandbox.GenerateKey("faulty_container")
+	_, err = ep.Join("faulty_container")
+	if _, ok := err.(*SandboxProgrammingError); !ok || !errors.Is(err, errFakeJoin) {
+		t.Fatalf("Expected a sandbox programming error wrapping %v, got %v", errFakeJoin, err)
+	}
+	if c.sandboxGet(key) != nil {
+		t.Fatal("Sandbox left behind after the join failure")
+	}
+	if ep.ContainerID() != "" {
+		t.Fatalf("Endpoint joined by %q after the join failure", ep.ContainerID())
+	}
+	if _, err := netlink.LinkByName(srcName); err != nil {
+		t.Fatalf("Interface not moved back from the sandbox: %v", err)
+	}
+
+	// The sandbox shared with another endpoint keeps that endpoint only
+	nn, err := c.NewNetwork("null", "nullnetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nep, err := nn.CreateEndpoint("nullep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cData, err := nep.Join("faulty_container", JoinOptionExtraHost("web", "10.0.0.2"), JoinOptionDNS("10.0.0.53"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nep.Leave("faulty_container")
+	hosts, err := ioutil.ReadFile(cData.HostsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolvConf, err := ioutil.ReadFile(cData.ResolvConfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ep.Join("faulty_container", JoinOptionExtraHost("bad", "6.6.6.6"), JoinOptionDNS("9.9.9.9")); !errors.Is(err, errFakeJoin) {
+		t.Fatalf("Expected %v, got %v", errFakeJoin, err)
+	}
+	sb := c.sandboxGet(key)
+	if sb == nil {
+		t.Fatal("Sandbox of the joined endpoint destroyed")
+	}
+	if c.sandboxes[key].refCnt != 1 {
+		t.Fatalf("Expected sandbox refcount 1, got %d", c.sandboxes[key].refCnt)
+	}
+	if ifaces := sb.Interfaces(); len(ifaces) != 0 {
+		t.Fatalf("Interfaces left in the sandbox after the join failure: %v", ifaces)
+	}
+	if _, err := netlink.LinkByName(srcName); err != nil {
+		t.Fatalf("Interface not moved back from the sandbox: %v", err)
+	}
+
+	// The container files are left as they were, also when the sandbox
+	// refuses the endpoint
+	hn, err := c.NewNetwork("host", "hostnetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hep, err := hn.CreateEndpoint("hostep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hep.Join("faulty_container"); err != ErrHostNetworkConflict {
+		t.Fatalf("Expected %v, got %v", ErrHostNetworkConflict, err)
+	}
+	for path, expected := range map[string][]byte{cData.HostsPath: hosts, cData.ResolvConfPath: resolvConf} {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != string(expected) {
+			t.Fatalf("Expected %s after the join failures:\n%s\ngot:\n%s", path, expected, content)
+		}
+	}
+}
+
+// optionsDriver records the options each endpoint is created with.
+type optionsDriver struct {
+	leakyDriver
+	options map[types.UUID]interface{}
+}
+
+func (d *optionsDriver) CreateNetwork(nid types.UUID, config interface{}) error {
+	return nil
+}
+
+func (d *optionsDriver) CreateNetworkWithContext(ctx context.Context, nid types.UUID, config interface{}) error {
+	return nil
+}
+
+func (d *optionsDriver) CreateEndpoint(nid, eid types.UUID, config interface{}) (*sandbox.Info, error) {
+	d.options[eid] = config
+	return nil, nil
+}
+
+func (d *optionsDriver) CreateEndpointWithContext(ctx context.Context, nid, eid types.UUID, config interface{}) (*sandbox.Info, error) {
+	return d.CreateEndpoint(nid, eid, config)
+}
+
+func (d *optionsDriver) Type() string {
+	return "options"
+}
+
+func TestNetworkEndpointDefaults(t *testing.T) {
+	c := New().(*controller)
+
+	d := &optionsDriver{options: map[types.UUID]interface{}{}}
+	c.drivers[d.Type()] = d
+
+	defaults := options.Generic{"Mtu": 1400, "EgressRate": "1mbit"}
+	n, err := c.NewNetwork(d.Type(), "testnetwork", nil, NetworkOptionEndpointDefaults(defaults))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The network keeps its own copy of the defaults
+	defaults["Mtu"] = 9000
+
+	for _, tc := range []struct {
+		name     string
+		options  interface{}
+		expected options.Generic
+	}{
+		{"ep1", nil, options.Generic{"Mtu": 1400, "EgressRate": "1mbit"}},
+		{"ep2", options.Generic(nil), options.Generic{"Mtu": 1400, "EgressRate": "1mbit"}},
+		{"ep3", options.Generic{"Mtu": 1500}, options.Generic{"Mtu": 1500, "EgressRate": "1mbit"}},
+		{"ep4", options.Generic{"AddressIPv4": "10.0.0.2", "EgressRate": ""}, options.Generic{"Mtu": 1400, "EgressRate": "", "AddressIPv4": "10.0.0.2"}},
+	} {
+		ep, err := n.CreateEndpoint(tc.name, tc.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := d.options[types.UUID(ep.ID())]; !reflect.DeepEqual(got, tc.expected) {
+			t.Fatalf("Expected %s options %v, got %v", tc.name, tc.expected, got)
+		}
+	}
+
+	eps, err := n.CreateEndpoints([]EndpointSpec{{Name: "ep5"}, {Name: "ep6", Options: options.Generic{"Mtu": 1500}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, mtu := range []int{1400, 1500} {
+		expected := options.Generic{"Mtu": mtu, "EgressRate": "1mbit"}
+		if got := d.options[types.UUID(eps[i].ID())]; !reflect.DeepEqual(got, expected) {
+			t.Fatalf("Expected %s options %v, got %v", eps[i].Name(), expected, got)
+		}
+	}
+
+	// The per-endpoint options do not leak into the defaults
+	if nw := n.(*network); !reflect.DeepEqual(nw.endpointDefaults, options.Generic{"Mtu": 1400, "EgressRate": "1mbit"}) {
+		t.Fatalf("Network defaults modified: %v", nw.endpointDefaults)
+	}
+
+	if _, err := n.CreateEndpoint("ep7", &struct{ Mtu int }{1500}); !types.IsInvalidParameter(err) {
+		t.Fatalf("Expected an invalid parameter error, got %v", err)
+	}
+
+	// Without defaults the options are passed unchanged
+	plain, err := c.NewNetwork(d.Type(), "plainnetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := plain.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.options[types.UUID(ep.ID())]; got != nil {
+		t.Fatalf("Expected no options, got %v", got)
+	}
+}
+
+func TestIDCollision(t *testing.T) {
+	c := New().(*controller)
+	c.genID = sequenceIDs("id1", "id1", "id2", "id2", "id1", "id3")
+
+	n, err := c.NewNetwork("null", "testnetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.ID() != "id1" {
+		t.Fatalf("Expected network id id1, got %s", n.ID())
+	}
+
+	// The network id is regenerated for the second network
+	n2, err := c.NewNetwork("null", "testnetwork2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n2.ID() != "id2" {
+		t.Fatalf("Expected network id id2, got %s", n2.ID())
+	}
+
+	// Endpoint ids do not collide with the network ids either
+	ep, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ep.ID() != "id3" {
+		t.Fatalf("Expected endpoint id id3, got %s", ep.ID())
+	}
+
+	// A generator stuck on a used id fails after a bounded number of attempts
+	if _, err := n.CreateEndpoint("ep2", nil); err != ErrNoUniqueID {
+		t.Fatalf("Expected %v, got %v", ErrNoUniqueID, err)
+	}
+	if _, err := c.NewNetwork("null", "testnetwork3", nil); err != ErrNoUniqueID {
+		t.Fatalf("Expected %v, got %v", ErrNoUniqueID, err)
+	}
+}
+
+func TestControllerOptionIDGenerator(t *testing.T) {
+	c := New(ControllerOptionIDGenerator(sequenceIDs("node1-1", "", "node1-1", "node1-2", ""))).(*controller)
+
+	n, err := c.NewNetwork("null", "testnetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.ID() != "node1-1" {
+		t.Fatalf("Expected network id node1-1, got %s", n.ID())
+	}
+
+	// Empty ids are generated again, as used ones are
+	ep, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ep.ID() != "node1-2" {
+		t.Fatalf("Expected endpoint id node1-2, got %s", ep.ID())
+	}
+	if _, err := n.CreateEndpoint("ep2", nil); err != ErrNoUniqueID {
+		t.Fatalf("Expected %v, got %v", ErrNoUniqueID, err)
+	}
+
+	// The controller id does not come from the generator
+	if c.ID() == "" || strings.HasPrefix(c.ID(), "node1") {
+		t.Fatalf("Expected a random controller id, got %q", c.ID())
+	}
+}
+
+func TestEndpointLeaseCrashRestart(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	store := datastore.NewMemoryStore()
+
+	c, err := NewWithOptions(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ConfigureNetworkDriver("bridge", options.Generic{}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork("bridge", "testnetwork", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := ep.Info().Interfaces[0].Address.IP
+	if _, err := ep.Join("lease_container"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The controller crashes and is restored along with the driver state,
+	// which outlives it as the host network resources do. The container
+	// died without leaving.
+	restored := New().(*controller)
+	restored.store = store
+	restored.drivers["bridge"] = c.(*controller).drivers["bridge"]
+	if err := restored.restore(); err != nil {
+		t.Fatal(err)
+	}
+	// The driver takes the network back once configured
+	if err := restored.ConfigureNetworkDriver("bridge", options.Generic{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.Leave("lease_container"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lease survives the restart, dead until the container joins again
+	rep := restored.NetworkByID(n.ID()).EndpointByID(ep.ID())
+	if rep.ContainerID() != "" || rep.LeasedTo() != "lease_container" {
+		t.Fatalf("Expected a dead lease, got %q joined by %q", rep.LeasedTo(), rep.ContainerID())
+	}
+
+	_, err = rep.Join("other_container")
+	if _, ok := err.(*LeasedEndpointError); !ok {
+		t.Fatalf("Expected a leased endpoint error, got %v", err)
+	}
+
+	if _, err := rep.Join("lease_container"); err != nil {
+		t.Fatalf("Failed to join again after the restart: %v", err)
+	}
+	if got := rep.Info().Interfaces[0].Address.IP; !got.Equal(ip) {
+		t.Fatalf("Expected address %v after restart, got %v", ip, got)
+	}
+
+	// The reaper releases the address of the container
+	if err := restored.ReleaseLeasesFor("lease_container"); err != nil {
+		t.Fatal(err)
+	}
+	rn := restored.NetworkByID(n.ID())
+	if len(rn.Endpoints()) != 0 {
+		t.Fatalf("Leased endpoint not deleted: %v", rn.Endpoints())
+	}
+	ep, err = rn.CreateEndpoint("ep2", options.Generic{"AddressIPv4": ip})
+	if err != nil {
+		t.Fatalf("Failed to reuse the released address: %v", err)
+	}
+
+	// A clean leave ends the lease
+	if _, err := ep.Join("lease_container"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.Leave("lease_container"); err != nil {
+		t.Fatal(err)
+	}
+	if ep.LeasedTo() != "" {
+		t.Fatalf("Lease to %q left after leave", ep.LeasedTo())
+	}
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// gatewayDriver creates endpoints with a veth interface in a subnet of their
+// own, whose first address is the gateway of the endpoint. The veth pairs are
+// not created for the in memory sandboxes.
+type gatewayDriver struct {
+	leakyDriver
+	count    int
+	inMemory bool
+}
+
+func (d *gatewayDriver) CreateNetwork(nid types.UUID, config interface{}) error {
+	return nil
+}
+
+func (d *gatewayDriver) CreateNetworkWithContext(ctx context.Context, nid types.UUID, config interface{}) error {
+	return nil
+}
+
+func (d *gatewayDriver) CreateEndpoint(nid, eid types.UUID, config interface{}) (*sandbox.Info, error) {
+	return d.CreateEndpointWithContext(context.Background(), nid, eid, config)
+}
+
+func (d *gatewayDriver) CreateEndpointWithContext(ctx context.Context, nid, eid types.UUID, config interface{}) (*sandbox.Info, error) {
+	d.count++
+	name := "gw" + string(eid)[:4]
+	if !d.inMemory {
+		veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: name + "p"}
+		if err := netlink.LinkAdd(veth); err != nil {
+			return nil, err
+		}
+	}
+	addr := &net.IPNet{IP: net.IPv4(192, 168, byte(100+d.count), 2), Mask: net.CIDRMask(24, 32)}
+	static := &net.IPNet{IP: net.IPv4(10, byte(10+d.count), 0, 0), Mask: net.CIDRMask(16, 32)}
+	return &sandbox.Info{
+		Interfaces: []*sandbox.Interface{{SrcName: name, DstName: "eth0", Address: addr}},
+		Gateway:    net.IPv4(192, 168, byte(100+d.count), 1),
+		Routes:     []*sandbox.Route{{Destination: static, NextHop: net.IPv4(192, 168, byte(100+d.count), 254), Interface: "eth0"}},
+	}, nil
+}
+
+func (d *gatewayDriver) Type() string {
+	return "gateway"
+}
+
+func TestJoinPriorityDefaultRoute(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	c := New().(*controller)
+
+	d := &gatewayDriver{}
+	c.drivers[d.Type()] = d
+
+	var eps []Endpoint
+	for index, priority := range []int{0, 10, 10} {
+		n, err := c.NewNetwork(d.Type(), fmt.Sprintf("network%d", index), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ep, err := n.CreateEndpoint(fmt.Sprintf("ep%d", index), nil, EndpointOptionPriority(priority))
+		if err != nil {
+			t.Fatal(err)
+		}
+		eps = append(eps, ep)
+	}
+
+	key := sandbox.GenerateKey("priority_container")
+	verifyDefaultRoute := func(ep Endpoint) {
+		var routes []netlink.Route
+		err := sandbox.Invoke(key, func() error {
+			var err error
+			routes, err = netlink.RouteList(nil, netlink.FAMILY_V4)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		gw := ep.SandboxInfo().Gateway
+		for _, r := range routes {
+			if r.Dst == nil {
+				if !r.Gw.Equal(gw) {
+					t.Fatalf("Expected the default route via %v, got %v", gw, r.Gw)
+				}
+				return
+			}
+		}
+		t.Fatalf("No default route via %v: %v", gw, routes)
+	}
+
+	// The endpoint of higher priority takes the default route over, the one
+	// joined first keeps it on a tie
+	for _, c := range []struct {
+		ep, gw Endpoint
+	}{
+		{eps[0], eps[0]},
+		{eps[1], eps[1]},
+		{eps[2], eps[1]},
+	} {
+		if _, err := c.ep.Join("priority_container"); err != nil {
+			t.Fatal(err)
+		}
+		defer c.ep.Leave("priority_container")
+		verifyDefaultRoute(c.gw)
+	}
+
+	// The interfaces are numbered in join order
+	for index, ep := range eps {
+		if name, expected := ep.Info().Interfaces[0].DstName, fmt.Sprintf("eth%d", index); name != expected {
+			t.Fatalf("Expected interface %s, got %s", expected, name)
+		}
+	}
+
+	// Leaving promotes the endpoint of next highest priority
+	if err := eps[1].Leave("priority_container"); err != nil {
+		t.Fatal(err)
+	}
+	verifyDefaultRoute(eps[2])
+	if err := eps[2].Leave("priority_container"); err != nil {
+		t.Fatal(err)
+	}
+	verifyDefaultRoute(eps[0])
+}
+
+func TestJoinNullSandbox(t *testing.T) {
+	factory := &sandbox.NullFactory{}
+	c := New(ControllerOptionSandboxFactory(factory)).(*controller)
+
+	d := &gatewayDriver{inMemory: true}
+	c.drivers[d.Type()] = d
+
+	var eps []Endpoint
+	for index, priority := range []int{0, 10} {
+		n, err := c.NewNetwork(d.Type(), fmt.Sprintf("network%d", index), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ep, err := n.CreateEndpoint(fmt.Sprintf("ep%d", index), nil, EndpointOptionPriority(priority))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ep.Join("null_container", JoinOptionHostname("nullhost")); err != nil {
+			t.Fatal(err)
+		}
+		eps = append(eps, ep)
+	}
+
+	key := sandbox.GenerateKey("null_container")
+	sb := factory.Sandbox(key)
+	if sb == nil || c.sandboxGet(key) != sb {
+		t.Fatalf("The sandbox of the container was not created by the factory")
+	}
+	if sb.Hostname() != "nullhost" {
+		t.Fatalf("Expected hostname nullhost, got %q", sb.Hostname())
+	}
+
+	ifaces := sb.Interfaces()
+	if len(ifaces) != 2 || ifaces[0].DstName != "eth0" || ifaces[1].DstName != "eth1" {
+		t.Fatalf("Unexpected interfaces in the sandbox: %v", ifaces)
+	}
+	if gw := eps[1].SandboxInfo().Gateway; !sb.Gateway().Equal(gw) {
+		t.Fatalf("Expected gateway %v, got %v", gw, sb.Gateway())
+	}
+
+	// The routes go through the interfaces as renamed by the sandbox
+	routes := sb.Routes()
+	if len(routes) != 2 || routes[0].Interface != "eth0" || routes[1].Interface != "eth1" {
+		t.Fatalf("Unexpected routes in the sandbox: %v", routes)
+	}
+
+	if err := eps[1].Leave("null_container"); err != nil {
+		t.Fatal(err)
+	}
+	if gw := eps[0].SandboxInfo().Gateway; !sb.Gateway().Equal(gw) {
+		t.Fatalf("Expected gateway %v after leave, got %v", gw, sb.Gateway())
+	}
+	if ifaces := sb.Interfaces(); len(ifaces) != 1 || ifaces[0].DstName != "eth0" {
+		t.Fatalf("Unexpected interfaces after leave: %v", ifaces)
+	}
+	if routes := sb.Routes(); len(routes) != 1 || routes[0].Interface != "eth0" {
+		t.Fatalf("Unexpected routes after leave: %v", routes)
+	}
+
+	if err := eps[0].Leave("null_container"); err != nil {
+		t.Fatal(err)
+	}
+	if !sb.Destroyed() || c.sandboxGet(key) != nil {
+		t.Fatal("Sandbox not destroyed after the last leave")
+	}
+}
+
+func TestJoinStaticRoutes(t *testing.T) {
+	factory := &sandbox.NullFactory{}
+	c := New(ControllerOptionSandboxFactory(factory)).(*controller)
+
+	d := &gatewayDriver{inMemory: true}
+	c.drivers[d.Type()] = d
+
+	n, err := c.NewNetwork(d.Type(), "routenetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := n.CreateEndpoint("routes", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, mgmt, _ := net.ParseCIDR("172.30.0.0/16")
+	_, onLink, _ := net.ParseCIDR("172.31.0.0/16")
+	_, mgmtv6, _ := net.ParseCIDR("2001:db8:30::/48")
+	key := sandbox.GenerateKey("route_container")
+
+	// The invalid routes fail the join, leaving no sandbox behind
+	for _, r := range []StaticRoute{
+		{NextHop: net.ParseIP("192.168.101.254")},
+		{Destination: mgmtv6, NextHop: net.ParseIP("192.168.101.254")},
+		{Destination: mgmt, NextHop: net.ParseIP("10.0.0.1")},
+	} {
+		_, err := ep.Join("route_container", JoinOptionStaticRoutes([]StaticRoute{r}))
+		if _, ok := err.(*StaticRouteError); !ok || !types.IsInvalidParameter(err) {
+			t.Fatalf("Expected a StaticRouteError for %v, got %v", r, err)
+		}
+		if sb := factory.Sandbox(key); sb != nil && !sb.Destroyed() {
+			t.Fatalf("Sandbox left behind after the join failure with %v: %v", r, sb.Routes())
+		}
+	}
+
+	routes := []StaticRoute{
+		{Destination: mgmt, NextHop: net.ParseIP("192.168.101.254")},
+		{Destination: onLink},
+	}
+	info, err := ep.JoinWithInfo("route_container", JoinOptionStaticRoutes(routes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.StaticRoutes) != 2 {
+		t.Fatalf("Unexpected static routes in the join info: %v", info.StaticRoutes)
+	}
+	for k, r := range info.StaticRoutes {
+		if r.Destination.String() != routes[k].Destination.String() || !r.NextHop.Equal(routes[k].NextHop) || r.Interface != "eth0" {
+			t.Fatalf("Unexpected static route %v for %v", r, routes[k])
+		}
+	}
+
+	// The static routes follow the one of the driver
+	sb := factory.Sandbox(key)
+	if added := sb.Routes(); len(added) != 3 || !added[1].Equal(info.StaticRoutes[0]) || !added[2].Equal(info.StaticRoutes[1]) {
+		t.Fatalf("Unexpected routes in the sandbox: %v", added)
+	}
+
+	if err := ep.Leave("route_container"); err != nil {
+		t.Fatal(err)
+	}
+	if left := sb.Routes(); len(left) != 0 {
+		t.Fatalf("Routes left in the sandbox after leave: %v", left)
+	}
+}
+
+func TestEndpointProbe(t *testing.T) {
+	// The null sandbox probes from the namespace of the locked test thread
+	defer netutils.SetupTestNetNS(t)()
+	lo, err := netlink.LinkByName("lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkSetUp(lo); err != nil {
+		t.Fatal(err)
+	}
+
+	factory := &sandbox.NullFactory{}
+	c := New(ControllerOptionSandboxFactory(factory)).(*controller)
+
+	d := &gatewayDriver{inMemory: true}
+	c.drivers[d.Type()] = d
+
+	n, err := c.NewNetwork(d.Type(), "probenetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := n.CreateEndpoint("probe", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	addr := l.Addr().String()
+
+	if _, err := ep.Probe(addr, 0); err != ErrNoContainer {
+		t.Fatalf("Expected %v, got %v", ErrNoContainer, err)
+	}
+
+	if _, err := ep.Join("probe_container"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := ep.Probe(addr, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to probe %s: %v", addr, err)
+	}
+	if !r.Success || r.Address != addr {
+		t.Fatalf("Unexpected result of the probe of %s: %#v", addr, r)
+	}
+	if _, err := ep.Probe("probe", 0); err != sandbox.InvalidProbeAddressError("probe") {
+		t.Fatalf("Expected %v, got %v", sandbox.InvalidProbeAddressError("probe"), err)
+	}
+
+	if err := ep.Leave("probe_container"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEndpointMoveToSandbox(t *testing.T) {
+	factory := &sandbox.NullFactory{}
+	c := New(ControllerOptionSandboxFactory(factory)).(*controller)
+
+	d := &gatewayDriver{inMemory: true}
+	c.drivers[d.Type()] = d
+
+	n, err := c.NewNetwork(d.Type(), "movenetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := n.CreateEndpoint("moved", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ep.State() != EndpointAllocated {
+		t.Fatalf("Expected a new endpoint %s, got %s", EndpointAllocated, ep.State())
+	}
+
+	err = ep.RemoveFromSandbox()
+	if e, ok := err.(*EndpointStateError); !ok || e.State() != EndpointAllocated {
+		t.Fatalf("Expected an EndpointStateError, got %v", err)
+	}
+	if err := ep.MoveToSandbox(""); err != InvalidSandboxKeyError("") {
+		t.Fatalf("Expected %v, got %v", InvalidSandboxKeyError(""), err)
+	}
+
+	key := "/var/run/netns/moved"
+	if err := ep.MoveToSandbox(key); err != nil {
+		t.Fatalf("Failed to move the endpoint to sandbox %s: %v", key, err)
+	}
+	sb := factory.Sandbox(key)
+	if sb == nil || c.sandboxGet(key) != sb {
+		t.Fatal("The sandbox of the endpoint was not created by the factory")
+	}
+	if ifaces := sb.Interfaces(); len(ifaces) != 1 || ifaces[0].DstName != "eth0" {
+		t.Fatalf("Unexpected interfaces in the sandbox: %v", ifaces)
+	}
+	if gw := ep.SandboxInfo().Gateway; !sb.Gateway().Equal(gw) {
+		t.Fatalf("Expected gateway %v, got %v", gw, sb.Gateway())
+	}
+	if ep.State() != EndpointAttached || ep.Info().SandboxKey != key || ep.ContainerID() != "" {
+		t.Fatalf("Unexpected state %s in sandbox %q", ep.State(), ep.Info().SandboxKey)
+	}
+
+	// The attached endpoint is neither moved, joined nor deleted
+	for _, op := range []func() error{
+		func() error { return ep.MoveToSandbox(key) },
+		func() error { _, err := ep.Join("move_container"); return err },
+		ep.Delete,
+	} {
+		err := op()
+		if e, ok := err.(*EndpointStateError); !ok || e.State() != EndpointAttached || !types.IsForbidden(err) {
+			t.Fatalf("Expected an EndpointStateError, got %v", err)
+		}
+	}
+	if err := ep.Leave("move_container"); err != ErrNoContainer {
+		t.Fatalf("Expected %v, got %v", ErrNoContainer, err)
+	}
+
+	// The sandbox of a container is shared with the endpoints moved to it
+	joined, err := n.CreateEndpoint("joined", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := joined.Join("move_container"); err != nil {
+		t.Fatal(err)
+	}
+	other, err := n.CreateEndpoint("other", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cKey := sandbox.GenerateKey("move_container")
+	if err := other.MoveToSandbox(cKey); err != nil {
+		t.Fatal(err)
+	}
+	if ifaces := factory.Sandbox(cKey).Interfaces(); len(ifaces) != 2 {
+		t.Fatalf("Unexpected interfaces in the container sandbox: %v", ifaces)
+	}
+	if err := joined.RemoveFromSandbox(); err != ErrEndpointInUse {
+		t.Fatalf("Expected %v, got %v", ErrEndpointInUse, err)
+	}
+	if err := other.RemoveFromSandbox(); err != nil {
+		t.Fatal(err)
+	}
+	if ifaces := factory.Sandbox(cKey).Interfaces(); len(ifaces) != 1 || factory.Sandbox(cKey).Destroyed() {
+		t.Fatalf("Unexpected interfaces in the container sandbox: %v", ifaces)
+	}
+
+	if err := ep.RemoveFromSandbox(); err != nil {
+		t.Fatal(err)
+	}
+	if !sb.Destroyed() || c.sandboxGet(key) != nil || ep.State() != EndpointAllocated {
+		t.Fatal("Sandbox not destroyed after the endpoint was removed from it")
+	}
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// errFlaky is a transient driver failure.
+type errFlaky string
+
+func (e errFlaky) Error() string {
+	return string(e)
+}
+
+func (e errFlaky) Retryable() {}
+
+// flakyDriver fails the first attempts at creating a network, creating an
+// endpoint and joining with err, up to failures attempts at each.
+type flakyDriver struct {
+	leakyDriver
+	failures int
+	err      error
+	attempts map[string]int
+}
+
+func (d *flakyDriver) attempt(op string) error {
+	d.attempts[op]++
+	if d.attempts[op] <= d.failures {
+		return d.err
+	}
+	return nil
+}
+
+func (d *flakyDriver) CreateNetworkWithContext(ctx context.Context, nid types.UUID, config interface{}) error {
+	return d.attempt("network")
+}
+
+func (d *flakyDriver) CreateEndpointWithContext(ctx context.Context, nid, eid types.UUID, config interface{}) (*sandbox.Info, error) {
+	return nil, d.attempt("endpoint")
+}
+
+func (d *flakyDriver) Join(nid, eid types.UUID, sboxKey string) (*driverapi.JoinInfo, error) {
+	if err := d.attempt("join"); err != nil {
+		return nil, err
+	}
+	return &driverapi.JoinInfo{}, nil
+}
+
+func (d *flakyDriver) Type() string {
+	return "flaky"
+}
+
+func TestDriverRetry(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+	c := New(ControllerOptionSandboxFactory(&sandbox.NullFactory{}), ControllerOptionRetryPolicy(policy)).(*controller)
+
+	d := &flakyDriver{failures: 2, err: errFlaky("plugin timed out"), attempts: map[string]int{}}
+	c.drivers[d.Type()] = d
+
+	// The operations succeed at the third attempt
+	n, err := c.NewNetwork(d.Type(), "flakynetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := n.CreateEndpoint("flakyep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ep.Join("flaky_container"); err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range []string{"network", "endpoint", "join"} {
+		if d.attempts[op] != 3 {
+			t.Fatalf("Expected 3 attempts at the %s operation, got %d", op, d.attempts[op])
+		}
+	}
+	if err := ep.Leave("flaky_container"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The last error is returned once the attempts are exhausted
+	d.failures, d.attempts = 3, map[string]int{}
+	if _, err := c.NewNetwork(d.Type(), "flakynetwork2", nil); err != d.err {
+		t.Fatalf("Expected %v, got %v", d.err, err)
+	}
+	if d.attempts["network"] != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", d.attempts["network"])
+	}
+
+	// The errors which are not retryable fail at once
+	d.err, d.attempts = errFakeCreate, map[string]int{}
+	if _, err := n.CreateEndpoint("flakyep2", nil); err != errFakeCreate {
+		t.Fatalf("Expected %v, got %v", errFakeCreate, err)
+	}
+	if d.attempts["endpoint"] != 1 {
+		t.Fatalf("Expected a single attempt, got %d", d.attempts["endpoint"])
+	}
+	if c.NetworkByName("flakynetwork2") != nil || n.EndpointByName("flakyep2") != nil {
+		t.Fatal("Failed operations left objects behind")
+	}
+}
+
+// metricsRecorder records the metrics updates, checking that the controller
+// lock is not held while they are made.
+type metricsRecorder struct {
+	c          *controller
+	counters   map[string]int
+	gauges     map[string]float64
+	operations map[string]int
+	locked     bool
+	sync.Mutex
+}
+
+func (m *metricsRecorder) checkUnlocked() {
+	if !m.c.TryLock() {
+		m.locked = true
+		return
+	}
+	m.c.Unlock()
+}
+
+func (m *metricsRecorder) IncCounter(name string) {
+	m.Lock()
+	defer m.Unlock()
+	m.checkUnlocked()
+	m.counters[name]++
+}
+
+func (m *metricsRecorder) AddGauge(name string, delta float64) {
+	m.Lock()
+	defer m.Unlock()
+	m.checkUnlocked()
+	m.gauges[name] += delta
+}
+
+func (m *metricsRecorder) Observe(name string, labels MetricLabels, value float64) {
+	m.Lock()
+	defer m.Unlock()
+	m.checkUnlocked()
+	if name == MetricDriverOperationSeconds && labels["driver"] == "gateway" && value >= 0 {
+		m.operations[labels["operation"]]++
+	}
+}
+
+// metricsDriver is a gatewayDriver whose networks can be deleted.
+type metricsDriver struct {
+	gatewayDriver
+}
+
+func (d *metricsDriver) DeleteNetwork(nid types.UUID) error {
+	return nil
+}
+
+func TestControllerMetrics(t *testing.T) {
+	m := &metricsRecorder{counters: map[string]int{}, gauges: map[string]float64{}, operations: map[string]int{}}
+	c := New(ControllerOptionSandboxFactory(&sandbox.NullFactory{}), ControllerOptionMetrics(m)).(*controller)
+	m.c = c
+
+	d := &metricsDriver{gatewayDriver{inMemory: true}}
+	c.drivers[d.Type()] = d
+
+	n, err := c.NewNetwork(d.Type(), "metricsnetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eps, err := n.CreateEndpoints([]EndpointSpec{{Name: "ep1"}, {Name: "ep2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep3, err := n.CreateEndpoint("ep3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ep := range append(eps, ep3) {
+		if _, err := ep.Join("metrics_container"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if m.counters[MetricNetworksCreated] != 1 || m.counters[MetricEndpointsCreated] != 3 {
+		t.Fatalf("Unexpected counters after creation: %v", m.counters)
+	}
+	if m.gauges[MetricSandboxes] != 1 || m.gauges[MetricAllocatedIPs] != 3 {
+		t.Fatalf("Unexpected gauges after the joins: %v", m.gauges)
+	}
+
+	for _, ep := range append(eps, ep3) {
+		if err := ep.Leave("metrics_container"); err != nil {
+			t.Fatal(err)
+		}
+		if err := ep.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.counters[MetricNetworksDeleted] != 1 || m.counters[MetricEndpointsDeleted] != 3 {
+		t.Fatalf("Unexpected counters after deletion: %v", m.counters)
+	}
+	if m.gauges[MetricSandboxes] != 0 || m.gauges[MetricAllocatedIPs] != 0 {
+		t.Fatalf("Unexpected gauges after deletion: %v", m.gauges)
+	}
+
+	expected := map[string]int{
+		driverCreateNetwork:  1,
+		driverDeleteNetwork:  1,
+		driverCreateEndpoint: 3,
+		driverDeleteEndpoint: 3,
+		driverJoin:           3,
+		driverLeave:          3,
+	}
+	if !reflect.DeepEqual(m.operations, expected) {
+		t.Fatalf("Expected the driver operations %v, got %v", expected, m.operations)
+	}
+	if m.locked {
+		t.Fatal("Metrics updated with the controller lock held")
+	}
+}
+
+// handle is the data a stateful driver keeps with its endpoints.
+type handle struct {
+	Port  string
+	Index int
+}
+
+// dataDriver keeps a handle with the endpoints it joins.
+type dataDriver struct {
+	gatewayDriver
+	store driverapi.GenericDataStore
+}
+
+func (d *dataDriver) SetGenericDataStore(store driverapi.GenericDataStore) {
+	d.store = store
+}
+
+func (d *dataDriver) Join(nid, eid types.UUID, sboxKey string) (*driverapi.JoinInfo, error) {
+	if err := d.store.SetEndpointData(nid, eid, "handle", handle{Port: "port" + string(eid)[:4], Index: d.count}); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (d *dataDriver) Type() string {
+	return "data"
+}
+
+func TestGenericDataRestart(t *testing.T) {
+	store := datastore.NewMemoryStore()
+
+	c, err := NewWithOptions(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork("null", "datanetwork", nil,
+		NetworkOptionEndpointDefaults(options.Generic{"Mtu": 1400, "EgressRate": "1mbit"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := n.CreateEndpoint("ep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := n.SetGenericData("handle", handle{Port: "net0", Index: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.SetGenericData("handle", handle{Port: "ep0", Index: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.SetGenericData("removed", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.SetGenericData("removed", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.SetGenericData("invalid", make(chan int)); err == nil {
+		t.Fatal("Expected a value not encoding to JSON to be rejected")
+	}
+
+	// The data is left out of the API representation by default
+	b, err := c.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "GenericData") {
+		t.Fatalf("Generic data exposed in the API representation: %s", b)
+	}
+
+	// Nor is it carried by the encoding of the handles, the store records
+	// being private
+	for _, v := range []interface{}{n, ep} {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(b), "genericData") || strings.Contains(string(b), "net0") || strings.Contains(string(b), "ep0") {
+			t.Fatalf("Generic data exposed in the JSON encoding of a handle: %s", b)
+		}
+	}
+	res := NewNetworkResource(n, ResourceOptionGenericData())
+	if string(res.GenericData["handle"]) != `{"Port":"net0","Index":1}` || string(res.Endpoints[0].GenericData["handle"]) != `{"Port":"ep0","Index":2}` {
+		t.Fatalf("Unexpected generic data in the resource: %#v", res)
+	}
+
+	// The data is restored with the network and the endpoint
+	c, err = NewWithOptions(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n = c.NetworkByName("datanetwork")
+	if n == nil {
+		t.Fatal("Network not restored")
+	}
+	ep = n.EndpointByName("ep")
+	if ep == nil {
+		t.Fatal("Endpoint not restored")
+	}
+
+	var h handle
+	if err := n.GenericData("handle", &h); err != nil {
+		t.Fatal(err)
+	}
+	if h != (handle{Port: "net0", Index: 1}) {
+		t.Fatalf("Unexpected network handle %#v", h)
+	}
+	if err := ep.GenericData("handle", &h); err != nil {
+		t.Fatal(err)
+	}
+	if h != (handle{Port: "ep0", Index: 2}) {
+		t.Fatalf("Unexpected endpoint handle %#v", h)
+	}
+	if err := ep.GenericData("removed", &h); err != GenericDataNotFoundError("removed") {
+		t.Fatalf("Expected %v, got %v", GenericDataNotFoundError("removed"), err)
+	}
+
+	// So are the endpoint defaults, which the drivers read as before
+	type epConfig struct {
+		Mtu        int
+		EgressRate string
+	}
+	cfg, err := options.GenerateFromModel(n.(*network).endpointDefaults, &epConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *cfg.(*epConfig) != (epConfig{Mtu: 1400, EgressRate: "1mbit"}) {
+		t.Fatalf("Unexpected endpoint defaults after restart: %v", n.(*network).endpointDefaults)
+	}
+}
+
+func TestDriverGenericData(t *testing.T) {
+	factory := &sandbox.NullFactory{}
+	c := New(ControllerOptionSandboxFactory(factory))
+
+	d := &dataDriver{gatewayDriver: gatewayDriver{inMemory: true}}
+	if err := c.RegisterDriver(d.Type(), d); err != nil {
+		t.Fatal(err)
+	}
+	if d.store == nil {
+		t.Fatal("Driver not handed the generic data store")
+	}
+
+	n, err := c.NewNetwork(d.Type(), "datanetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := n.CreateEndpoint("ep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ep.Join("data_container"); err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Leave("data_container")
+
+	var h handle
+	if err := ep.GenericData("handle", &h); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (handle{Port: "port" + ep.ID()[:4], Index: 1}); h != expected {
+		t.Fatalf("Expected handle %#v, got %#v", expected, h)
+	}
+
+	if err := d.store.NetworkData("unknown", "handle", &h); !types.IsNotFound(err) {
+		t.Fatalf("Expected a not found error for an unknown network, got %v", err)
+	}
+	if err := d.store.EndpointData(types.UUID(n.ID()), "unknown", "handle", &h); !types.IsNotFound(err) {
+		t.Fatalf("Expected a not found error for an unknown endpoint, got %v", err)
+	}
+}
+
+// faultyRouteFactory creates in memory sandboxes failing to remove routes.
+type faultyRouteFactory struct {
+	sandbox.NullFactory
+}
+
+type faultyRouteSandbox struct {
+	*sandbox.NullSandbox
+}
+
+func (s faultyRouteSandbox) RemoveRoute(dst *net.IPNet, gw net.IP, iface string) error {
+	return errFakeLeave
+}
+
+func (f *faultyRouteFactory) NewSandbox(key string, osCreate bool) (sandbox.Sandbox, error) {
+	if _, err := f.NullFactory.NewSandbox(key, osCreate); err != nil {
+		return nil, err
+	}
+	return faultyRouteSandbox{f.Sandbox(key)}, nil
+}
+
+var errFakeLeave = errors.New("fake leave failure")
+
+func TestLeaveCleanupFailure(t *testing.T) {
+	factory := &faultyRouteFactory{}
+	c := New(ControllerOptionSandboxFactory(factory)).(*controller)
+
+	d := &gatewayDriver{inMemory: true}
+	c.drivers[d.Type()] = d
+
+	gn, err := c.NewNetwork(d.Type(), "gatewaynetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gep, err := gn.CreateEndpoint("gatewayep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nn, err := c.NewNetwork("null", "nullnetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nep, err := nn.CreateEndpoint("nullep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ep := range []Endpoint{gep, nep} {
+		if _, err := ep.Join("cleanup_container"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The endpoint leaves the sandbox although its route is left behind
+	key := c.sandboxKey("cleanup_container")
+	if err := gep.Leave("cleanup_container"); err != nil {
+		t.Fatal(err)
+	}
+	sb := factory.Sandbox(key)
+	if sb.Destroyed() || len(sb.Interfaces()) != 0 || sb.Gateway() != nil {
+		t.Fatalf("Unexpected sandbox after leave: interfaces %v, gateway %v", sb.Interfaces(), sb.Gateway())
+	}
+	if eps, _ := c.sandboxEndpoints(key); len(eps) != 1 || eps[0] != nep {
+		t.Fatalf("Expected only the null endpoint in the sandbox, got %v", eps)
+	}
+
+	if err := nep.Leave("cleanup_container"); err != nil {
+		t.Fatal(err)
+	}
+	if !sb.Destroyed() || c.sandboxGet(key) != nil {
+		t.Fatal("Sandbox not destroyed after the last leave")
+	}
+}
+
+// stallingHostnameFactory creates in memory sandboxes which, once stalled is
+// set, signal it on each hostname setting and wait for release.
+type stallingHostnameFactory struct {
+	sandbox.NullFactory
+	stalled chan struct{}
+	release chan struct{}
+}
+
+type stallingHostnameSandbox struct {
+	*sandbox.NullSandbox
+	f *stallingHostnameFactory
+}
+
+func (s stallingHostnameSandbox) Hostname() string {
+	return ""
+}
+
+func (s stallingHostnameSandbox) SetHostname(name string) error {
+	if s.f.stalled != nil {
+		s.f.stalled <- struct{}{}
+		<-s.f.release
+	}
+	return s.NullSandbox.SetHostname(name)
+}
+
+func (f *stallingHostnameFactory) NewSandbox(key string, osCreate bool) (sandbox.Sandbox, error) {
+	if _, err := f.NullFactory.NewSandbox(key, osCreate); err != nil {
+		return nil, err
+	}
+	return stallingHostnameSandbox{f.Sandbox(key), f}, nil
+}
+
+func TestJoinSiblingLeave(t *testing.T) {
+	factory := &stallingHostnameFactory{}
+	c := New(ControllerOptionSandboxFactory(factory)).(*controller)
+
+	n, err := c.NewNetwork("null", "nullnetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep1, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep2, err := n.CreateEndpoint("ep2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ep1.Join("sibling_container", JoinOptionHostname("sibling"), JoinOptionExtraHost("web", "10.0.0.2")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first endpoint leaves while the second one, having listed it
+	// among the endpoints of the sandbox, joins
+	factory.stalled = make(chan struct{})
+	factory.release = make(chan struct{})
+	errCh := make(chan error)
+	go func() {
+		_, err := ep2.Join("sibling_container", JoinOptionHostname("sibling"))
+		errCh <- err
+	}()
+	<-factory.stalled
+	if err := ep1.Leave("sibling_container"); err != nil {
+		t.Fatal(err)
+	}
+	close(factory.release)
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep2.Leave("sibling_container"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSandboxes(t *testing.T) {
+	factory := &sandbox.NullFactory{}
+	c := New(ControllerOptionSandboxFactory(factory)).(*controller)
+
+	d := &gatewayDriver{inMemory: true}
+	c.drivers[d.Type()] = d
+
+	gn, err := c.NewNetwork(d.Type(), "gatewaynetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nn, err := c.NewNetwork("null", "nullnetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var eps []Endpoint
+	for _, n := range []Network{gn, nn, nn} {
+		ep, err := n.CreateEndpoint(fmt.Sprintf("ep%d", len(eps)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		eps = append(eps, ep)
+	}
+	for i, containerID := range []string{"walk_one", "walk_one", "walk_two"} {
+		if _, err := eps[i].Join(containerID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sandboxes := map[string]SandboxInfo{}
+	for _, sb := range c.Sandboxes() {
+		sandboxes[sb.Key] = sb
+	}
+	if len(sandboxes) != 2 {
+		t.Fatalf("Expected 2 sandboxes, got %v", sandboxes)
+	}
+
+	sb := sandboxes[c.sandboxKey("walk_one")]
+	if sb.RefCount != 2 || len(sb.Endpoints) != 2 || sb.Endpoints[0] != eps[0].ID() || sb.Endpoints[1] != eps[1].ID() {
+		t.Fatalf("Unexpected sandbox of the first container: %+v", sb)
+	}
+	if len(sb.Interfaces) != 1 || sb.Interfaces[0].DstName != "eth0" || sb.SharesHostNetwork {
+		t.Fatalf("Unexpected sandbox of the first container: %+v", sb)
+	}
+	// The interfaces are copies
+	sb.Interfaces[0].DstName = "modified"
+	if factory.Sandbox(sb.Key).Interfaces()[0].DstName != "eth0" {
+		t.Fatal("Interface of the sandbox modified through its copy")
+	}
+
+	sb = sandboxes[c.sandboxKey("walk_two")]
+	if sb.RefCount != 1 || len(sb.Endpoints) != 1 || sb.Endpoints[0] != eps[2].ID() || len(sb.Interfaces) != 0 {
+		t.Fatalf("Unexpected sandbox of the second container: %+v", sb)
+	}
+
+	// The walk stops once the walker returns true
+	walked := 0
+	c.WalkSandboxes(func(sb SandboxInfo) bool {
+		walked++
+		return true
+	})
+	if walked != 1 {
+		t.Fatalf("Expected the walk to stop after one sandbox, walked %d", walked)
+	}
+
+	if err := eps[0].Leave("walk_one"); err != nil {
+		t.Fatal(err)
+	}
+	walked = 0
+	c.WalkSandboxes(func(sb SandboxInfo) bool {
+		walked++
+		if sb.Key == c.sandboxKey("walk_one") && (sb.RefCount != 1 || len(sb.Endpoints) != 1 || len(sb.Interfaces) != 0) {
+			t.Fatalf("Unexpected sandbox after leave: %+v", sb)
+		}
+		return false
+	})
+	if walked != 2 {
+		t.Fatalf("Expected 2 sandboxes walked, got %d", walked)
+	}
+
+	for i, containerID := range []string{"walk_one", "walk_two"} {
+		if err := eps[i+1].Leave(containerID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sandboxes := c.Sandboxes(); len(sandboxes) != 0 {
+		t.Fatalf("Sandboxes left after the last leave: %v", sandboxes)
+	}
+}
@@ -0,0 +1,125 @@
+// Package datastore provides the persistence layer used by libnetwork to save
+// the state of its networks and endpoints across restarts.
+package datastore
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrKeyNotFound is returned when no object is stored under the requested key.
+	ErrKeyNotFound = errors.New("key not found in store")
+	// ErrInvalidKey is returned when the object key is empty or malformed.
+	ErrInvalidKey = errors.New("invalid store key")
+)
+
+// KV is the interface implemented by the objects which can be saved in a
+// DataStore.
+type KV interface {
+	// Key returns the hierarchical key under which the object is stored.
+	Key() []string
+
+	// Value returns the JSON encoded object.
+	Value() []byte
+}
+
+// DataStore is the interface a state store needs to implement to back a
+// libnetwork controller. Values are JSON encoded.
+type DataStore interface {
+	// PutObject adds a new record or updates an existing one.
+	PutObject(kvObject KV) error
+
+	// GetObject gets the record stored under key and unmarshals it into o.
+	GetObject(key []string, o interface{}) error
+
+	// DeleteObject deletes the record of the passed object.
+	DeleteObject(kvObject KV) error
+
+	// List returns the raw values of all the records found under prefix.
+	List(prefix []string) ([][]byte, error)
+}
+
+// Key joins the passed key components into a single store key.
+func Key(key ...string) string {
+	return strings.Join(key, "/")
+}
+
+func validKey(key []string) bool {
+	if len(key) == 0 {
+		return false
+	}
+
+	for _, k := range key {
+		if k == "" || strings.Contains(k, "/") || k == "." || k == ".." {
+			return false
+		}
+	}
+
+	return true
+}
+
+type memoryStore struct {
+	records map[string][]byte
+	sync.Mutex
+}
+
+// NewMemoryStore returns a DataStore which keeps its records in memory only.
+func NewMemoryStore() DataStore {
+	return &memoryStore{records: make(map[string][]byte)}
+}
+
+func (ms *memoryStore) PutObject(kvObject KV) error {
+	if !validKey(kvObject.Key()) {
+		return ErrInvalidKey
+	}
+
+	ms.Lock()
+	ms.records[Key(kvObject.Key()...)] = kvObject.Value()
+	ms.Unlock()
+
+	return nil
+}
+
+func (ms *memoryStore) GetObject(key []string, o interface{}) error {
+	ms.Lock()
+	value, ok := ms.records[Key(key...)]
+	ms.Unlock()
+	if !ok {
+		return ErrKeyNotFound
+	}
+
+	return json.Unmarshal(value, o)
+}
+
+func (ms *memoryStore) DeleteObject(kvObject KV) error {
+	key := Key(kvObject.Key()...)
+
+	ms.Lock()
+	defer ms.Unlock()
+
+	if _, ok := ms.records[key]; !ok {
+		return ErrKeyNotFound
+	}
+	delete(ms.records, key)
+
+	return nil
+}
+
+func (ms *memoryStore) List(prefix []string) ([][]byte, error) {
+	p := Key(prefix...) + "/"
+
+	ms.Lock()
+	defer ms.Unlock()
+
+	var list [][]byte
+	for k, v := range ms.records {
+		if strings.HasPrefix(k, p) && !strings.Contains(k[len(p):], "/") {
+			list = append(list, v)
+		}
+	}
+
+	return list, nil
+}
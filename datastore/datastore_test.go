@@ -0,0 +1,101 @@
+package datastore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type dummyObject struct {
+	Name string
+	ID   string
+}
+
+func (d *dummyObject) Key() []string {
+	return []string{"dummy", d.ID}
+}
+
+func (d *dummyObject) Value() []byte {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func testStore(t *testing.T, store DataStore) {
+	obj := &dummyObject{Name: "dummy1", ID: "1234"}
+	if err := store.PutObject(obj); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.PutObject(&dummyObject{Name: "dummy2", ID: "5678"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := &dummyObject{}
+	if err := store.GetObject(obj.Key(), got); err != nil {
+		t.Fatal(err)
+	}
+	if *got != *obj {
+		t.Fatalf("GetObject() returned %v instead of %v", got, obj)
+	}
+
+	list, err := store.List([]string{"dummy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("List() returned %d records instead of 2", len(list))
+	}
+
+	if err := store.DeleteObject(obj); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.GetObject(obj.Key(), got); err != ErrKeyNotFound {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+
+	if err := store.DeleteObject(obj); err != ErrKeyNotFound {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+
+	if err := store.PutObject(&dummyObject{ID: "../escape"}); err != ErrInvalidKey {
+		t.Fatalf("Expected ErrInvalidKey, got %v", err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "datastore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, store)
+
+	// A new instance on the same directory must see the saved records
+	store, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := store.List([]string{"dummy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("List() returned %d records instead of 1", len(list))
+	}
+}
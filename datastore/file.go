@@ -0,0 +1,118 @@
+package datastore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type fileStore struct {
+	root string
+	sync.Mutex
+}
+
+// NewFileStore returns a DataStore which saves each record as a file under
+// the passed root directory. A record key maps to the file path relative to
+// root.
+func NewFileStore(root string) (DataStore, error) {
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+
+	return &fileStore{root: root}, nil
+}
+
+func (fs *fileStore) path(key []string) string {
+	return filepath.Join(append([]string{fs.root}, key...)...)
+}
+
+func (fs *fileStore) PutObject(kvObject KV) error {
+	key := kvObject.Key()
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+
+	path := fs.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a crash never leaves a
+	// partially written record behind.
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, kvObject.Value(), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (fs *fileStore) GetObject(key []string, o interface{}) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	fs.Lock()
+	value, err := ioutil.ReadFile(fs.path(key))
+	fs.Unlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrKeyNotFound
+		}
+		return err
+	}
+
+	return json.Unmarshal(value, o)
+}
+
+func (fs *fileStore) DeleteObject(kvObject KV) error {
+	key := kvObject.Key()
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+
+	if err := os.Remove(fs.path(key)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrKeyNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (fs *fileStore) List(prefix []string) ([][]byte, error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	files, err := ioutil.ReadDir(fs.path(prefix))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var list [][]byte
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) == ".tmp" {
+			continue
+		}
+
+		value, err := ioutil.ReadFile(filepath.Join(fs.path(prefix), f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+	}
+
+	return list, nil
+}
@@ -1,7 +1,10 @@
 package driverapi
 
 import (
-	"errors"
+	"context"
+	"fmt"
+	"net"
+	"strings"
 
 	"github.com/docker/libnetwork/sandbox"
 	"github.com/docker/libnetwork/types"
@@ -9,37 +12,226 @@ import (
 
 var (
 	// ErrEndpointExists is returned if more than one endpoint is added to the network
-	ErrEndpointExists = errors.New("Endpoint already exists (Only one endpoint allowed)")
+	ErrEndpointExists = types.ForbiddenErrorf("Endpoint already exists (Only one endpoint allowed)")
 	// ErrNoNetwork is returned if no network with the specified id exists
-	ErrNoNetwork = errors.New("No network exists")
+	ErrNoNetwork = types.NotFoundErrorf("No network exists")
 	// ErrNoEndpoint is returned if no endpoint with the specified id exists
-	ErrNoEndpoint = errors.New("No endpoint exists")
+	ErrNoEndpoint = types.NotFoundErrorf("No endpoint exists")
+	// ErrRestoreNotSupported is returned by the drivers which can not take
+	// back the networks they created before a restart
+	ErrRestoreNotSupported = types.UnavailableErrorf("Network restore not supported by the driver")
 )
 
+// ImmutableOptionError is returned when a network update changes an option
+// which is fixed at the network creation.
+type ImmutableOptionError string
+
+func (ioe ImmutableOptionError) Error() string {
+	return fmt.Sprintf("network option %s can not be changed", string(ioe))
+}
+
+// InvalidParameter denotes the type of this error
+func (ioe ImmutableOptionError) InvalidParameter() {}
+
+// ImmutableOptionsError is returned when a driver configuration reload changes
+// options which the existing networks of the driver depend on.
+type ImmutableOptionsError []string
+
+func (ioe ImmutableOptionsError) Error() string {
+	return fmt.Sprintf("driver options %s can not be changed while the driver has networks", strings.Join(ioe, ", "))
+}
+
+// InvalidParameter denotes the type of this error
+func (ioe ImmutableOptionsError) InvalidParameter() {}
+
 // Driver is an interface that every plugin driver needs to implement.
 type Driver interface {
 	// Push driver specific config to the driver
 	Config(config interface{}) error
 
+	// ConfigRequired tells whether Config must be applied before networks
+	// of the driver type can be created.
+	ConfigRequired() bool
+
 	// CreateNetwork invokes the driver method to create a network passing
 	// the network id and network specific config. The config mechanism will
 	// eventually be replaced with labels which are yet to be introduced.
+	// Network creation must be atomic: on failure the driver must undo what
+	// it had set up, as the network is not known to libnetwork in that case.
+	// DeleteNetwork is still invoked with the failed network id to clean up
+	// what the driver may have leaked, so it must return ErrNoNetwork rather
+	// than act on another network when the id is unknown.
 	CreateNetwork(nid types.UUID, config interface{}) error
 
+	// CreateNetworkWithContext behaves as CreateNetwork but gives up as soon
+	// as the passed context is done, returning the context error.
+	// CreateNetwork is equivalent to passing context.Background().
+	CreateNetworkWithContext(ctx context.Context, nid types.UUID, config interface{}) error
+
+	// ValidateNetwork runs the checks CreateNetwork applies to the passed
+	// network specific config, returning the error CreateNetwork would fail
+	// with, without allocating any resource.
+	ValidateNetwork(config interface{}) error
+
+	// UpdateNetwork applies the passed network specific config to the
+	// existing network with the passed network id. The config has the
+	// CreateNetwork form, or is a set of generic options naming the fields
+	// to change. An option the driver can not change once the network is
+	// created is rejected with ImmutableOptionError, leaving the network
+	// unchanged.
+	UpdateNetwork(nid types.UUID, config interface{}) error
+
 	// DeleteNetwork invokes the driver method to delete network passing
 	// the network id.
 	DeleteNetwork(nid types.UUID) error
 
+	// NetworkInfo returns the settings the driver applied to the network
+	// with the passed network id.
+	NetworkInfo(nid types.UUID) (*NetworkInfo, error)
+
 	// CreateEndpoint invokes the driver method to create an endpoint
 	// passing the network id, endpoint id and driver
 	// specific config. The config mechanism will eventually be replaced
 	// with labels which are yet to be introduced.
 	CreateEndpoint(nid, eid types.UUID, config interface{}) (*sandbox.Info, error)
 
+	// CreateEndpointWithContext behaves as CreateEndpoint but gives up as
+	// soon as the passed context is done, returning the context error. No
+	// endpoint is left behind in that case. CreateEndpoint is equivalent to
+	// passing context.Background().
+	CreateEndpointWithContext(ctx context.Context, nid, eid types.UUID, config interface{}) (*sandbox.Info, error)
+
+	// ValidateEndpoint runs the checks CreateEndpoint applies to the passed
+	// endpoint specific config on the network with the passed network id,
+	// without allocating any resource.
+	ValidateEndpoint(nid types.UUID, config interface{}) error
+
 	// DeleteEndpoint invokes the driver method to delete an endpoint
 	// passing the network id and endpoint id.
 	DeleteEndpoint(nid, eid types.UUID) error
 
+	// Join method is invoked when a Sandbox is attached to an endpoint,
+	// once the endpoint interfaces have been placed in the sandbox.
+	Join(nid, eid types.UUID, sboxKey string) (*JoinInfo, error)
+
+	// Leave method is invoked when a Sandbox detaches from an endpoint.
+	Leave(nid, eid types.UUID) error
+
+	// RestoreNetwork takes back the network with the passed network id,
+	// which the driver created before the controller restarted, so that
+	// it can be used and deleted again. It is invoked once the driver is
+	// configured, if it requires a configuration, and before any other
+	// call on the network. The network specific config is not kept across
+	// restarts: a driver needing it keeps it in the GenericDataStore. A
+	// driver which can not take its networks back returns
+	// ErrRestoreNotSupported.
+	RestoreNetwork(nid types.UUID) error
+
+	// RestoreEndpoint takes back the endpoint with the passed endpoint id
+	// of a restored network, along with the sandbox info CreateEndpoint
+	// returned for it. No container is joined to a restored endpoint.
+	RestoreEndpoint(nid, eid types.UUID, sinfo *sandbox.Info) error
+
 	// Type returns the the type of this driver, the network type this driver manages
 	Type() string
+
+	// Capabilities returns the features the driver supports, which the
+	// network and endpoint specific configs are checked against before
+	// the driver is invoked.
+	Capabilities() DriverCapability
+}
+
+// DriverCapability represents the features a driver supports.
+type DriverCapability struct {
+	// IPv6 tells whether the networks and endpoints can get IPv6 addresses.
+	IPv6 bool
+
+	// PortMapping tells whether the endpoints ports can be published on the
+	// host.
+	PortMapping bool
+
+	// MultiHost tells whether the networks can span several hosts.
+	MultiHost bool
+
+	// StaticIP tells whether the endpoints can request their address.
+	StaticIP bool
+
+	// Privileged tells whether the driver programs the network stack of
+	// the host, which requires the CAP_NET_ADMIN capability.
+	Privileged bool
+}
+
+// Reloader is implemented by the drivers whose configuration can be reloaded
+// while they have networks.
+type Reloader interface {
+	// ReloadConfig replaces the configuration Config applied, in the forms
+	// Config accepts, applying the changed options to the existing
+	// networks. The options the networks depend on can not change while
+	// there are some: ImmutableOptionsError lists them, and the driver is
+	// left unchanged.
+	ReloadConfig(config interface{}) error
+}
+
+// GenericDataStore keeps opaque data with the networks and endpoints of the
+// controller, persisted along with them, such as the handles a stateful
+// driver needs back after a restart. The data can be kept once the network
+// or the endpoint is created, the values must encode to JSON and are
+// decoded into v as json.Unmarshal does.
+type GenericDataStore interface {
+	SetNetworkData(nid types.UUID, key string, value interface{}) error
+	NetworkData(nid types.UUID, key string, v interface{}) error
+	SetEndpointData(nid, eid types.UUID, key string, value interface{}) error
+	EndpointData(nid, eid types.UUID, key string, v interface{}) error
+}
+
+// GenericDataUser is implemented by the drivers keeping data with their
+// networks and endpoints. The controller hands them its GenericDataStore
+// once, before any network is created or restored.
+type GenericDataUser interface {
+	SetGenericDataStore(store GenericDataStore)
+}
+
+// IPForwardingUser is implemented by the drivers enabling the IP forwarding of
+// the host. The controller tells them once, before any network is created or
+// restored, whether they may change it.
+type IPForwardingUser interface {
+	SetIPForwarding(enable bool)
+}
+
+// NetworkInfo represents the settings a driver applied to a network.
+type NetworkInfo struct {
+	// Subnets the endpoints addresses are allocated from.
+	Subnets []*net.IPNet
+
+	// IPv4 gateway of the network.
+	Gateway net.IP
+
+	// IPv6 gateway of the network.
+	GatewayIPv6 net.IP
+
+	// IPv4Addresses counts the IPv4 addresses the driver allocates to the
+	// endpoints, nil if the driver does not count them.
+	IPv4Addresses *AddressCount
+}
+
+// AddressCount is the number of addresses a driver can allocate, and the
+// number of them in use, the reserved ones such as the gateway included.
+type AddressCount struct {
+	Total int
+	Used  int
+}
+
+// JoinInfo represents a set of resources that the driver has the ability to
+// provide once a sandbox joined one of its endpoints.
+type JoinInfo struct {
+	// PortBindings are the effective container ports published on the host.
+	PortBindings []types.PortBinding
+
+	// Bandwidth limits applied to the endpoint interfaces in the sandbox,
+	// nil when unlimited.
+	Bandwidth *types.Bandwidth
+
+	// FirewallRules filtering the traffic of the endpoint interfaces in the
+	// sandbox, in the order they apply.
+	FirewallRules []types.FirewallRule
 }
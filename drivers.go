@@ -1,9 +1,15 @@
 package libnetwork
 
 import (
+	log "github.com/Sirupsen/logrus"
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/drivers/bridge"
+	"github.com/docker/libnetwork/drivers/host"
+	"github.com/docker/libnetwork/drivers/macvlan"
 	"github.com/docker/libnetwork/drivers/null"
+	"github.com/docker/libnetwork/drivers/overlay"
+	"github.com/docker/libnetwork/drivers/remote"
+	"github.com/docker/libnetwork/drivers/tunnel"
 )
 
 type driverTable map[string]driverapi.Driver
@@ -11,10 +17,19 @@ type driverTable map[string]driverapi.Driver
 func enumerateDrivers() driverTable {
 	drivers := make(driverTable)
 
-	for _, fn := range [](func() (string, driverapi.Driver)){bridge.New, null.New} {
+	for _, fn := range [](func() (string, driverapi.Driver)){bridge.New, host.New, null.New, overlay.New, macvlan.New, macvlan.NewIPVlan, tunnel.New} {
 		name, driver := fn()
 		drivers[name] = driver
 	}
 
+	// Remote plugins provide the network types named after them
+	for _, driver := range remote.Discover(remote.PluginDir) {
+		if _, ok := drivers[driver.Type()]; ok {
+			log.Warnf("Ignoring remote plugin %s which clashes with a built-in driver", driver.Type())
+			continue
+		}
+		drivers[driver.Type()] = driver
+	}
+
 	return drivers
 }
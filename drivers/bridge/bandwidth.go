@@ -0,0 +1,186 @@
+package bridge
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/sandbox"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	tcCmd = "tc"
+
+	// Smallest burst the token bucket filters get, in bytes, so that a
+	// full size frame always fits in.
+	minBurst = 1600
+)
+
+// rateUnits are the multipliers to bits per second of the rate units, as
+// tc understands them.
+var rateUnits = map[string]uint64{
+	"bit":  1,
+	"kbit": 1000,
+	"mbit": 1000 * 1000,
+	"gbit": 1000 * 1000 * 1000,
+	"tbit": 1000 * 1000 * 1000 * 1000,
+	"bps":  8,
+	"kbps": 8 * 1000,
+	"mbps": 8 * 1000 * 1000,
+	"gbps": 8 * 1000 * 1000 * 1000,
+	"tbps": 8 * 1000 * 1000 * 1000 * 1000,
+}
+
+// parseRate converts a rate such as "10mbit" to bits per second. The unit
+// is mandatory, as tc reads a bare number as bytes per second.
+func parseRate(rate string) (uint64, error) {
+	s := strings.ToLower(strings.TrimSpace(rate))
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i <= 0 {
+		return 0, InvalidRateError(rate)
+	}
+
+	mult, ok := rateUnits[s[i:]]
+	if !ok {
+		return 0, InvalidRateError(rate)
+	}
+
+	v, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || v <= 0 {
+		return 0, InvalidRateError(rate)
+	}
+
+	bits := uint64(v * float64(mult))
+	if bits == 0 {
+		return 0, InvalidRateError(rate)
+	}
+	return bits, nil
+}
+
+// parseBandwidth validates the rate limits of the endpoint configuration,
+// returning nil when it sets none. The tc command must be available to
+// program them.
+func parseBandwidth(epConfig *EndpointConfiguration) (*types.Bandwidth, error) {
+	if epConfig == nil || (epConfig.IngressRate == "" && epConfig.EgressRate == "") {
+		return nil, nil
+	}
+
+	bw := &types.Bandwidth{}
+	var err error
+	if epConfig.IngressRate != "" {
+		if bw.Ingress, err = parseRate(epConfig.IngressRate); err != nil {
+			return nil, err
+		}
+	}
+	if epConfig.EgressRate != "" {
+		if bw.Egress, err = parseRate(epConfig.EgressRate); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := exec.LookPath(tcCmd); err != nil {
+		return nil, &TCUnavailableError{err: err}
+	}
+
+	return bw, nil
+}
+
+// burst returns the token bucket size for the rate, 10ms worth of traffic.
+func burst(rate uint64) uint64 {
+	if b := rate / 8 / 100; b > minBurst {
+		return b
+	}
+	return minBurst
+}
+
+func tc(args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(tcCmd, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("tc %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// sandboxLink returns the name the endpoint interface got in the sandbox it
+// was moved to, which may differ from the requested one. The driver sets a
+// MAC address on the interface, which identifies it.
+func sandboxLink(mac net.HardwareAddr) (string, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return "", err
+	}
+	for _, l := range links {
+		if bytes.Equal(l.Attrs().HardwareAddr, mac) {
+			return l.Attrs().Name, nil
+		}
+	}
+	return "", fmt.Errorf("no interface with MAC address %s in the sandbox", mac)
+}
+
+// setupBandwidth programs the rate limits of the endpoint as token bucket
+// filters on its veth pair: the sandbox side for the egress traffic, and the
+// host side for the ingress one, as the traffic can only be policed rather
+// than shaped on receive.
+func setupBandwidth(sboxKey string, ep *bridgeEndpoint) error {
+	bw := ep.bandwidth
+
+	if bw.Egress != 0 {
+		err := sandbox.Invoke(sboxKey, func() error {
+			name, err := sandboxLink(ep.port.MacAddress)
+			if err != nil {
+				return err
+			}
+			return addTBF(name, bw.Egress)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if bw.Ingress != 0 {
+		if err := addTBF(ep.hostVeth, bw.Ingress); err != nil {
+			if bw.Egress != 0 {
+				teardownEgress(sboxKey, ep)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// teardownBandwidth removes the rate limits setupBandwidth programmed.
+func teardownBandwidth(sboxKey string, ep *bridgeEndpoint) error {
+	var err error
+	if ep.bandwidth.Egress != 0 {
+		err = teardownEgress(sboxKey, ep)
+	}
+	if ep.bandwidth.Ingress != 0 {
+		if e := tc("qdisc", "del", "dev", ep.hostVeth, "root"); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func teardownEgress(sboxKey string, ep *bridgeEndpoint) error {
+	return sandbox.Invoke(sboxKey, func() error {
+		name, err := sandboxLink(ep.port.MacAddress)
+		if err != nil {
+			return err
+		}
+		return tc("qdisc", "del", "dev", name, "root")
+	})
+}
+
+func addTBF(name string, rate uint64) error {
+	return tc("qdisc", "add", "dev", name, "root", "tbf", "rate", strconv.FormatUint(rate, 10)+"bit",
+		"burst", strconv.FormatUint(burst(rate), 10), "latency", "50ms")
+}
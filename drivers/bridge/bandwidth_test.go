@@ -0,0 +1,133 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/sandbox"
+)
+
+func TestParseRate(t *testing.T) {
+	valid := map[string]uint64{
+		"10mbit":  10 * 1000 * 1000,
+		"1.5kbit": 1500,
+		"1Gbit":   1000 * 1000 * 1000,
+		"100bps":  800,
+		"2mbps":   16 * 1000 * 1000,
+	}
+	for rate, expected := range valid {
+		bits, err := parseRate(rate)
+		if err != nil {
+			t.Fatalf("Failed to parse rate %q: %v", rate, err)
+		}
+		if bits != expected {
+			t.Fatalf("Expected %d bits per second for %q, got %d", expected, rate, bits)
+		}
+	}
+
+	for _, rate := range []string{"", "10", "mbit", "10mb", "-1mbit", "0kbit", "1..5mbit"} {
+		if _, err := parseRate(rate); err != InvalidRateError(rate) {
+			t.Fatalf("Expected %v, got %v", InvalidRateError(rate), err)
+		}
+	}
+}
+
+// tcShow lists the qdiscs of the interface, in the sandbox unless the key
+// is empty.
+func tcShow(t *testing.T, sboxKey, name string) string {
+	var out []byte
+	show := func() error {
+		var err error
+		out, err = exec.Command(tcCmd, "qdisc", "show", "dev", name).CombinedOutput()
+		return err
+	}
+	var err error
+	if sboxKey == "" {
+		err = show()
+	} else {
+		err = sandbox.Invoke(sboxKey, show)
+	}
+	if err != nil {
+		t.Fatalf("Failed to list the qdiscs of %s: %v: %s", name, err, out)
+	}
+	return string(out)
+}
+
+func TestEndpointBandwidth(t *testing.T) {
+	if _, err := exec.LookPath(tcCmd); err != nil {
+		t.Skip("tc is not available")
+	}
+
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	if err := d.Config(&Configuration{BridgeName: DefaultBridgeName}); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+	if err := d.CreateNetwork("dummy", ""); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	if _, err := d.CreateEndpoint("dummy", "ep1", &EndpointConfiguration{EgressRate: "10"}); err != InvalidRateError("10") {
+		t.Fatalf("Expected %v, got %v", InvalidRateError("10"), err)
+	}
+
+	epConfig := &EndpointConfiguration{IngressRate: "20mbit", EgressRate: "10mbit"}
+	sinfo, err := d.CreateEndpoint("dummy", "ep1", epConfig)
+	if err != nil {
+		t.Fatalf("Failed to create the endpoint: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "bandwidth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sbox, err := sandbox.NewSandbox(filepath.Join(dir, "sbox"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbox.Destroy()
+
+	// The sandbox may rename the interface
+	intf := sinfo.Interfaces[0].GetCopy()
+	intf.DstName = "eth1"
+	if err := sbox.AddInterface(intf.SrcName, intf.DstName, intf.Addresses()); err != nil {
+		t.Fatal(err)
+	}
+
+	jinfo, err := d.Join("dummy", "ep1", sbox.Key())
+	if err != nil {
+		t.Fatalf("Failed to join the endpoint: %v", err)
+	}
+	if jinfo.Bandwidth == nil || jinfo.Bandwidth.Ingress != 20*1000*1000 || jinfo.Bandwidth.Egress != 10*1000*1000 {
+		t.Fatalf("Unexpected effective limits %v", jinfo.Bandwidth)
+	}
+
+	// The egress traffic is shaped in the sandbox, the ingress one on the
+	// host side of the veth pair
+	hostVeth := d.(*driver).network.endpoints["ep1"].hostVeth
+	if out := tcShow(t, sbox.Key(), intf.DstName); !strings.Contains(out, "tbf") || !strings.Contains(out, "10Mbit") {
+		t.Fatalf("Egress limit not programmed on %s: %s", intf.DstName, out)
+	}
+	if out := tcShow(t, "", hostVeth); !strings.Contains(out, "tbf") || !strings.Contains(out, "20Mbit") {
+		t.Fatalf("Ingress limit not programmed on %s: %s", hostVeth, out)
+	}
+
+	if err := d.Leave("dummy", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if out := tcShow(t, sbox.Key(), intf.DstName); strings.Contains(out, "tbf") {
+		t.Fatalf("Egress limit left on %s: %s", intf.DstName, out)
+	}
+	if out := tcShow(t, "", hostVeth); strings.Contains(out, "tbf") {
+		t.Fatalf("Ingress limit left on %s: %s", hostVeth, out)
+	}
+}
@@ -1,14 +1,18 @@
 package bridge
 
 import (
+	"context"
 	"net"
 	"strings"
 	"sync"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/ipallocator"
+	"github.com/docker/libnetwork/ipamapi"
 	"github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/pkg/options"
+	"github.com/docker/libnetwork/pkg/portallocator"
 	"github.com/docker/libnetwork/portmapper"
 	"github.com/docker/libnetwork/sandbox"
 	"github.com/docker/libnetwork/types"
@@ -20,39 +24,162 @@ const (
 	vethPrefix    = "veth"
 	vethLen       = 7
 	containerVeth = "eth0"
+	// Range of the MTU accepted for the bridge and the endpoints, from the
+	// IPv4 minimum to the largest the veth interfaces support
+	minMtu = 68
+	maxMtu = 65535
+	// Range of the 802.1q VLAN ids, 0 and 4095 being reserved
+	minVlanID = 1
+	maxVlanID = 4094
+	// Ranges of the bridge timers in seconds, the forward delay one of the
+	// kernel and the aging time one of 802.1D
+	minForwardDelay = 2
+	maxForwardDelay = 30
+	minAgingTime    = 10
+	maxAgingTime    = 1000000
 )
 
 var (
-	ipAllocator *ipallocator.IPAllocator
+	ipAllocator *ipallocator.IPAllocator // Default IPAM for the networks
 	portMapper  *portmapper.PortMapper
 )
 
 // Configuration info for the "bridge" driver.
 type Configuration struct {
-	BridgeName            string
-	AddressIPv4           *net.IPNet
-	FixedCIDR             *net.IPNet
-	FixedCIDRv6           *net.IPNet
-	EnableIPv6            bool
-	EnableIPTables        bool
-	EnableIPMasquerade    bool
-	EnableICC             bool
-	EnableIPForwarding    bool
-	AllowNonDefaultBridge bool
-	Mtu                   int
-	DefaultGatewayIPv4    net.IP
-	DefaultGatewayIPv6    net.IP
+	BridgeName             string
+	AddressIPv4            *net.IPNet
+	FixedCIDR              *net.IPNet
+	FixedCIDRv6            *net.IPNet
+	EnableIPv6             bool
+	EnableIPTables         bool
+	EnableIPMasquerade     bool
+	EnableICC              bool
+	EnableNetworkIsolation bool
+	AllowNonDefaultBridge  bool
+	Mtu                    int
+	DefaultGatewayIPv4     net.IP
+	DefaultGatewayIPv6     net.IP
+	// HostPortRangeStart and HostPortRangeEnd bound the host ports picked
+	// for the port bindings which request none, 49153-65535 when unset.
+	HostPortRangeStart int
+	HostPortRangeEnd   int
+	// IPv6AcceptRA makes the endpoints of an IPv6 network autoconfigure
+	// their global address from the router advertisements received on the
+	// bridge, rather than get a static one from FixedCIDRv6. The static
+	// mode, the default, makes the endpoints ignore the advertisements so
+	// that they do not get a second address. Router advertisements are not
+	// accepted along with the static settings FixedCIDRv6 and
+	// DefaultGatewayIPv6, nor with an endpoint AddressIPv6.
+	IPv6AcceptRA bool
+	// EnableIPForwarding makes the driver enable the IPv4 forwarding of the
+	// host, net.ipv4.ip_forward, when the network is created, unless the
+	// controller manages none of the host forwarding. With it unset the
+	// host sysctl is left to the operator, and unless they enable the
+	// forwarding the containers only reach the bridge and each other: their
+	// outgoing traffic and the published ports are not routed.
+	EnableIPForwarding bool
+	// EnableHairpinMode lets the containers reach their own published
+	// ports: the bridge sends the traffic back through the port it came
+	// from, and, with EnableIPTables, the traffic of an endpoint to itself
+	// is masqueraded so that the replies go through the NAT again.
+	EnableHairpinMode bool
+	// AllowSubnetOverlap lets the AddressIPv4 of a new bridge overlap the
+	// subnets already routed on the host, such as the one of another bridge
+	// network, which is otherwise rejected with ErrSubnetOverlap. The
+	// traffic to the overlapping addresses then follows the most specific
+	// route, or the first one added on a tie.
+	AllowSubnetOverlap bool
+	// UseExistingBridge makes the network use the bridge named BridgeName,
+	// which is managed outside the driver: the bridge must exist, its
+	// IPv4 address gives the network subnet, and it is left in place when
+	// the network is deleted.
+	UseExistingBridge bool
+	// EnablePromiscuous puts the bridge in promiscuous mode when the network
+	// is created, for the L2 setups receiving the frames of other MAC
+	// addresses, such as nested virtual machines. A bridge the operator
+	// already put in promiscuous mode is left so on the network deletion.
+	EnablePromiscuous bool
+	// EnableUserlandProxy runs a userland proxy for each published port,
+	// forwarding the connections iptables does not, such as the ones to
+	// the host loopback address.
+	EnableUserlandProxy bool
+	// VlanParent and VlanID attach the bridge to the 802.1q VLAN
+	// sub-interface VlanID, within 1-4094, of the VlanParent host
+	// interface, named after them such as eth0.100. The sub-interface is
+	// created if absent, and deleted along with the network only then.
+	VlanParent string
+	VlanID     int
+	// EnableSTP, ForwardDelay and AgingTime tune the bridge when the
+	// network is created: the spanning tree protocol, the seconds the ports
+	// spend listening and learning with STP, within 2-30, and the seconds
+	// the learnt MAC addresses are kept for, within 10-1000000. The unset
+	// ones keep the kernel default, or the value of a bridge which already
+	// exists, and the ones set on such a bridge are restored along with
+	// the network.
+	EnableSTP    bool
+	ForwardDelay int
+	AgingTime    int
+	// Internal cuts the network from the outside: the endpoints get no
+	// default gateway, the traffic of the bridge is not masqueraded, and
+	// the one forwarded between the bridge and the other interfaces is
+	// dropped, leaving the containers to reach each other and the host
+	// only. It requires EnableIPTables.
+	Internal bool
+	// EnableLeakCheck makes the driver log the address pools and the host
+	// ports of a deleted network which are still allocated, a debugging aid
+	// for the resource leaks. The pools are only checked in the default
+	// IPAM of the driver.
+	EnableLeakCheck bool
+	// Override lets the configuration replace the one previously applied,
+	// it is not part of the configuration itself.
+	Override bool
+}
+
+// mutableOptions are the Configuration fields UpdateNetwork can change once
+// the network is created.
+var mutableOptions = map[string]bool{
+	"EnableICC":          true,
+	"DefaultGatewayIPv4": true,
+	"DefaultGatewayIPv6": true,
+}
+
+// NetworkConfiguration represents the user specified configuration for the bridge network
+type NetworkConfiguration struct {
+	// IPAM the network addresses are allocated from. The driver default
+	// in-memory allocator is used when nil.
+	IPAM ipamapi.IPAM
+	// AuxAddresses are the addresses of the bridge subnet never allocated
+	// to an endpoint, such as the ones of hosts outside the driver control,
+	// keyed by the name they are reserved for.
+	AuxAddresses map[string]net.IP
 }
 
 // EndpointConfiguration represents the user specified configuration for the sandbox endpoint
 type EndpointConfiguration struct {
-	MacAddress net.HardwareAddr
+	MacAddress   net.HardwareAddr
+	AddressIPv4  net.IP
+	AddressIPv6  net.IP
+	PortBindings []types.PortBinding
+	// Mtu of the veth pair of the endpoint, overriding the one of the
+	// bridge when set.
+	Mtu int
+	// IngressRate and EgressRate limit the traffic the endpoint receives
+	// and sends while a container is joined, such as "10mbit".
+	IngressRate string
+	EgressRate  string
+	// FirewallRules filter the traffic of the endpoint while a container
+	// is joined, the first rule matching a packet applying.
+	FirewallRules []types.FirewallRule
 }
 
 type bridgeEndpoint struct {
-	id     types.UUID
-	port   *sandbox.Interface
-	config *EndpointConfiguration // User specified parameters
+	id          types.UUID
+	port        *sandbox.Interface
+	hostVeth    string                 // Host side of the veth pair
+	config      *EndpointConfiguration // User specified parameters
+	portMapping []types.PortBinding    // Operational port bindings
+	bandwidth   *types.Bandwidth       // Validated rate limits
+	sboxKey     string                 // Sandbox the rate limits and firewall rules are programmed in
 }
 
 type bridgeNetwork struct {
@@ -65,6 +192,9 @@ type bridgeNetwork struct {
 type driver struct {
 	config  *Configuration
 	network *bridgeNetwork
+	// noIPForwarding keeps the driver from enabling the IP forwarding of
+	// the host, whatever its configuration says.
+	noIPForwarding bool
 	sync.Mutex
 }
 
@@ -81,8 +211,36 @@ func New() (string, driverapi.Driver) {
 // Validate performs a static validation on the configuration parameters.
 // Whatever can be assessed a priori before attempting any programming.
 func (c *Configuration) Validate() error {
-	if c.Mtu < 0 {
-		return ErrInvalidMtu
+	if err := validateMtu(c.Mtu); err != nil {
+		return err
+	}
+
+	if c.EnableNetworkIsolation && !c.EnableIPTables {
+		return ErrIsolationNoIPTables
+	}
+
+	if c.Internal {
+		if !c.EnableIPTables {
+			return ErrInternalNoIPTables
+		}
+		if c.DefaultGatewayIPv4 != nil || c.DefaultGatewayIPv6 != nil {
+			return ErrInternalGateway
+		}
+	}
+
+	if c.VlanParent != "" {
+		if c.VlanID < minVlanID || c.VlanID > maxVlanID {
+			return InvalidVlanIDError(c.VlanID)
+		}
+	} else if c.VlanID != 0 {
+		return ErrVlanNoParent
+	}
+
+	if c.ForwardDelay != 0 && (c.ForwardDelay < minForwardDelay || c.ForwardDelay > maxForwardDelay) {
+		return InvalidForwardDelayError(c.ForwardDelay)
+	}
+	if c.AgingTime != 0 && (c.AgingTime < minAgingTime || c.AgingTime > maxAgingTime) {
+		return InvalidAgingTimeError(c.AgingTime)
 	}
 
 	// If bridge v4 subnet is specified
@@ -108,6 +266,15 @@ func (c *Configuration) Validate() error {
 		}
 	}
 
+	if c.IPv6AcceptRA {
+		if c.FixedCIDRv6 != nil {
+			return IPv6AddressingConflictError("FixedCIDRv6")
+		}
+		if c.DefaultGatewayIPv6 != nil {
+			return IPv6AddressingConflictError("DefaultGatewayIPv6")
+		}
+	}
+
 	// If default v6 gw is specified, FixedCIDRv6 must be specified and gw must belong to FixedCIDRv6 subnet
 	if c.EnableIPv6 && c.DefaultGatewayIPv6 != nil {
 		if c.FixedCIDRv6 == nil || !c.FixedCIDRv6.Contains(c.DefaultGatewayIPv6) {
@@ -115,9 +282,23 @@ func (c *Configuration) Validate() error {
 		}
 	}
 
+	if c.HostPortRangeStart != 0 || c.HostPortRangeEnd != 0 {
+		if c.HostPortRangeStart < 1 || c.HostPortRangeEnd > 65535 || c.HostPortRangeStart > c.HostPortRangeEnd {
+			return ErrInvalidPortRange
+		}
+	}
+
 	return nil
 }
 
+// hostPortRange returns the range the host ports are allocated from.
+func (c *Configuration) hostPortRange() (int, int) {
+	if c.HostPortRangeStart == 0 && c.HostPortRangeEnd == 0 {
+		return portallocator.DefaultPortRangeStart, portallocator.DefaultPortRangeEnd
+	}
+	return c.HostPortRangeStart, c.HostPortRangeEnd
+}
+
 func (n *bridgeNetwork) getEndpoint(eid types.UUID) (*bridgeEndpoint, error) {
 	n.Lock()
 	defer n.Unlock()
@@ -133,52 +314,154 @@ func (n *bridgeNetwork) getEndpoint(eid types.UUID) (*bridgeEndpoint, error) {
 	return nil, nil
 }
 
+// SetIPForwarding keeps the driver from enabling the IP forwarding of the host
+// when enable is false.
+func (d *driver) SetIPForwarding(enable bool) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.noIPForwarding = !enable
+}
+
 func (d *driver) Config(option interface{}) error {
-	var config *Configuration
+	config, err := parseConfig(option)
+	if err != nil {
+		return err
+	}
 
 	d.Lock()
 	defer d.Unlock()
 
-	if d.config != nil {
-		return ErrConfigExists
+	return d.setConfig(config)
+}
+
+// setConfig applies the configuration, it must be called with the driver lock
+// held.
+func (d *driver) setConfig(config *Configuration) error {
+	override := config.Override
+	if override {
+		c := *config
+		c.Override = false
+		config = &c
 	}
 
-	switch opt := option.(type) {
-	case options.Generic:
-		opaqueConfig, err := options.GenerateFromModel(opt, &Configuration{})
+	// Applying the same configuration again is a no-op, replacing it takes
+	// an override
+	if d.config != nil {
+		changed, err := configChanges(d.config, config)
 		if err != nil {
 			return err
 		}
-		config = opaqueConfig.(*Configuration)
-	case *Configuration:
-		config = opt
+		if len(changed) == 0 {
+			return nil
+		}
+		if !override {
+			return ErrDriverAlreadyConfigured
+		}
+
+		// Reconfigure the existing network in place rather than recreating
+		// its bridge from under the running containers
+		if d.network != nil {
+			return d.updateConfig(d.network, config)
+		}
 	}
 
 	if err := config.Validate(); err != nil {
 		return err
 	}
 
+	if err := portMapper.Allocator.SetPortRange(config.hostPortRange()); err != nil {
+		return err
+	}
+
 	d.config = config
 
 	return nil
 }
 
+// ReloadConfig applies the configuration in place of the current one, as an
+// override would. The changes to the options other than the mutable ones are
+// rejected while the network exists.
+func (d *driver) ReloadConfig(option interface{}) error {
+	config, err := parseConfig(option)
+	if err != nil {
+		return err
+	}
+	c := *config
+	c.Override = true
+
+	d.Lock()
+	defer d.Unlock()
+
+	if d.network != nil && d.config != nil {
+		changed, err := configChanges(d.config, &c)
+		if err != nil {
+			return err
+		}
+		var immutable driverapi.ImmutableOptionsError
+		for _, field := range changed {
+			if !mutableOptions[field] && field != "Override" {
+				immutable = append(immutable, field)
+			}
+		}
+		if immutable != nil {
+			return immutable
+		}
+	}
+
+	return d.setConfig(&c)
+}
+
+// parseConfig returns the driver configuration passed as generic options or
+// a Configuration.
+func parseConfig(option interface{}) (*Configuration, error) {
+	switch opt := option.(type) {
+	case options.Generic:
+		opaqueConfig, err := options.GenerateFromModel(opt, &Configuration{})
+		if err != nil {
+			return nil, err
+		}
+		return opaqueConfig.(*Configuration), nil
+	case *Configuration:
+		return opt, nil
+	}
+	return nil, ErrInvalidDriverConfig
+}
+
+// configChanges returns the names of the fields config changes from cur, the
+// bridge name defaulting as it does on network creation.
+func configChanges(cur, config *Configuration) ([]string, error) {
+	a, b := *cur, *config
+	for _, c := range []*Configuration{&a, &b} {
+		if c.BridgeName == "" {
+			c.BridgeName = DefaultBridgeName
+		}
+	}
+
+	_, changed, err := options.UpdateModel(&b, &a)
+	return changed, err
+}
+
+// ConfigRequired is true, the bridge is set up by Config.
+func (d *driver) ConfigRequired() bool {
+	return true
+}
+
 // Create a new network using bridge plugin
 func (d *driver) CreateNetwork(id types.UUID, option interface{}) error {
+	return d.CreateNetworkWithContext(context.Background(), id, option)
+}
+
+func (d *driver) CreateNetworkWithContext(ctx context.Context, id types.UUID, option interface{}) error {
 	var err error
 
-	// Driver must be configured
 	d.Lock()
-	if d.config == nil {
-		d.Unlock()
-		return ErrInvalidConfig
-	}
 	config := d.config
-
-	// Sanity checks
-	if d.network != nil {
+	ipForwarding := config != nil && config.EnableIPForwarding && !d.noIPForwarding
+	nConfig, err := d.validateNetwork(option)
+	if err != nil {
 		d.Unlock()
-		return ErrNetworkExists
+		return err
 	}
 
 	// Create and set network handler in driver
@@ -196,6 +479,13 @@ func (d *driver) CreateNetwork(id types.UUID, option interface{}) error {
 
 	// Create or retrieve the bridge L3 interface
 	bridgeIface := newInterface(config)
+	bridgeIface.ipam = nConfig.IPAM
+	bridgeIface.auxAddresses = nConfig.AuxAddresses
+	if config.UseExistingBridge {
+		if err = bridgeIface.checkExternal(config.BridgeName); err != nil {
+			return err
+		}
+	}
 	d.network.bridge = bridgeIface
 
 	// Prepare the bridge setup configuration
@@ -204,16 +494,68 @@ func (d *driver) CreateNetwork(id types.UUID, option interface{}) error {
 	// If the bridge interface doesn't exist, we need to start the setup steps
 	// by creating a new device and assigning it an IPv4 address.
 	bridgeAlreadyExists := bridgeIface.exists()
+	if !bridgeAlreadyExists && config.AddressIPv4 != nil && !config.AllowSubnetOverlap {
+		// The routes hold the 4 bytes form of the addresses
+		subnet := &net.IPNet{IP: config.AddressIPv4.IP.To4(), Mask: config.AddressIPv4.Mask}
+		if err = netutils.CheckRouteOverlaps(subnet); err == netutils.ErrNetworkOverlaps {
+			err = ErrSubnetOverlap
+		}
+		if err != nil {
+			return err
+		}
+	}
 	if !bridgeAlreadyExists {
 		bridgeSetup.queueStep(setupDevice)
 		bridgeSetup.queueStep(setupBridgeIPv4)
 	}
 
+	// On failure undo the setup steps applied so far, so that the network
+	// creation is atomic: release the address pools requested from ipam,
+	// and remove the device and its iptables rules if it was created here
+	defer func() {
+		if err != nil {
+			if e := teardownVlan(config, bridgeIface); e != nil {
+				log.Warnf("Failed to remove VLAN sub-interface %s after network %s creation failure: %v", vlanName(config), id, e)
+			}
+			for _, pool := range bridgeIface.pools {
+				if e := bridgeIface.allocator().ReleasePool(pool); e != nil {
+					log.Warnf("Failed to release pool %v after network %s creation failure: %v", pool, id, e)
+				}
+			}
+			if e := teardownIsolation(config.BridgeName); e != nil {
+				log.Warnf("Failed to remove isolation rules after network %s creation failure: %v", id, e)
+			}
+			if bridgeAlreadyExists {
+				if e := teardownPromisc(config, bridgeIface); e != nil {
+					log.Warnf("Failed to restore the promiscuous mode of bridge %s after network %s creation failure: %v", config.BridgeName, id, e)
+				}
+				if e := teardownBridgeOptions(config, bridgeIface); e != nil {
+					log.Warnf("Failed to restore the options of bridge %s after network %s creation failure: %v", config.BridgeName, id, e)
+				}
+				return
+			}
+			if config.EnableIPTables && bridgeIface.bridgeIPv4 != nil {
+				if e := teardownIPTables(config, bridgeIface); e != nil {
+					log.Warnf("Failed to remove iptables rules after network %s creation failure: %v", id, e)
+				}
+			}
+			if link, e := netlink.LinkByName(config.BridgeName); e == nil {
+				if e := netlink.LinkDel(link); e != nil {
+					log.Warnf("Failed to remove bridge %s after network %s creation failure: %v", config.BridgeName, id, e)
+				}
+			}
+		}
+	}()
+
 	// Conditionnally queue setup steps depending on configuration values.
 	for _, step := range []struct {
 		Condition bool
 		Fn        setupStep
 	}{
+		// Attach the bridge to the VLAN sub-interface, creating it if
+		// needed.
+		{config.VlanParent != "", setupVlan},
+
 		// Enable IPv6 on the bridge if required. We do this even for a
 		// previously  existing bridge, as it may be here from a previous
 		// installation where IPv6 wasn't supported yet and needs to be
@@ -236,13 +578,19 @@ func (d *driver) CreateNetwork(id types.UUID, option interface{}) error {
 		{config.EnableIPTables, setupIPTables},
 
 		// Setup IP forwarding.
-		{config.EnableIPForwarding, setupIPForwarding},
+		{ipForwarding, setupIPForwarding},
 
 		// Setup DefaultGatewayIPv4
 		{config.DefaultGatewayIPv4 != nil, setupGatewayIPv4},
 
 		// Setup DefaultGatewayIPv6
 		{config.DefaultGatewayIPv6 != nil, setupGatewayIPv6},
+
+		// Put the bridge in promiscuous mode
+		{config.EnablePromiscuous, setupPromisc},
+
+		// Tune the bridge STP and timers
+		{config.hasBridgeOptions(), setupBridgeOptions},
 	} {
 		if step.Condition {
 			bridgeSetup.queueStep(step.Fn)
@@ -250,20 +598,161 @@ func (d *driver) CreateNetwork(id types.UUID, option interface{}) error {
 	}
 
 	// Apply the prepared list of steps, and abort at the first error.
+	bridgeSetup.queueStep(setupReserveBridgeIPv4)
+	bridgeSetup.queueStep(setupAuxAddresses)
 	bridgeSetup.queueStep(setupDeviceUp)
-	if err = bridgeSetup.apply(); err != nil {
+	if err = bridgeSetup.apply(ctx); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// ValidateNetwork checks the network can be created with the passed options,
+// without setting anything up.
+func (d *driver) ValidateNetwork(option interface{}) error {
+	d.Lock()
+	defer d.Unlock()
+
+	_, err := d.validateNetwork(option)
+	return err
+}
+
+// validateNetwork parses the network options, and checks the driver can
+// create the network. It must be called with the driver lock held.
+func (d *driver) validateNetwork(option interface{}) (*NetworkConfiguration, error) {
+	// Driver must be configured
+	if d.config == nil {
+		return nil, ErrInvalidConfig
+	}
+
+	// Sanity checks
+	if d.network != nil {
+		return nil, ErrNetworkExists
+	}
+
+	return parseNetworkOptions(option)
+}
+
+// UpdateNetwork changes the inter container communication and the default
+// gateways of the network, taking the driver Configuration form. The new
+// gateways apply to the endpoints created afterwards.
+func (d *driver) UpdateNetwork(nid types.UUID, option interface{}) error {
+	d.Lock()
+	defer d.Unlock()
+
+	n := d.network
+	if n == nil || n.id != nid {
+		return driverapi.ErrNoNetwork
+	}
+
+	upd, _, err := options.UpdateModel(option, d.config)
+	if err != nil {
+		return err
+	}
+
+	return d.updateConfig(n, upd.(*Configuration))
+}
+
+// updateConfig applies config to the existing network n, as long as only the
+// mutable options change. It must be called with the driver lock held.
+func (d *driver) updateConfig(n *bridgeNetwork, config *Configuration) error {
+	var err error
+
+	// Compare the settings as normalized at the network creation
+	if config.BridgeName == "" {
+		config.BridgeName = DefaultBridgeName
+	}
+	_, changed, err := options.UpdateModel(config, d.config)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range changed {
+		if !mutableOptions[field] {
+			return driverapi.ImmutableOptionError(field)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	if err = config.Validate(); err != nil {
+		return err
+	}
+
+	// Reserve the new gateways before letting the previous ones go
+	ipam := n.bridge.allocator()
+	gw4, gw6 := n.bridge.gatewayIPv4, n.bridge.gatewayIPv6
+	if !config.DefaultGatewayIPv4.Equal(d.config.DefaultGatewayIPv4) {
+		if gw4, err = updateGateway(ipam, n.bridge.bridgeIPv4, config.DefaultGatewayIPv4, n.bridge.bridgeIPv4.IP); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil && config.DefaultGatewayIPv4 != nil {
+				ipam.ReleaseAddress(n.bridge.bridgeIPv4, config.DefaultGatewayIPv4)
+			}
+		}()
+	}
+	if config.EnableIPv6 && !config.DefaultGatewayIPv6.Equal(d.config.DefaultGatewayIPv6) {
+		if config.DefaultGatewayIPv6 != nil && config.FixedCIDRv6 == nil {
+			err = ErrInvalidContainerSubnet
+			return err
+		}
+		if gw6, err = updateGateway(ipam, ipv6Pool(config, n.bridge), config.DefaultGatewayIPv6, n.bridge.bridgeIPv6.IP); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil && config.DefaultGatewayIPv6 != nil {
+				ipam.ReleaseAddress(config.FixedCIDRv6, config.DefaultGatewayIPv6)
+			}
+		}()
+	}
+
+	if config.EnableIPTables && config.EnableICC != d.config.EnableICC {
+		if err = setIcc(config.BridgeName, config.EnableICC, true); err != nil {
+			return err
+		}
+	}
+
+	if d.config.DefaultGatewayIPv4 != nil && !gw4.Equal(d.config.DefaultGatewayIPv4) {
+		ipam.ReleaseAddress(n.bridge.bridgeIPv4, d.config.DefaultGatewayIPv4)
+	}
+	if d.config.DefaultGatewayIPv6 != nil && !gw6.Equal(d.config.DefaultGatewayIPv6) {
+		ipam.ReleaseAddress(d.config.FixedCIDRv6, d.config.DefaultGatewayIPv6)
+	}
+
+	n.Lock()
+	n.bridge.gatewayIPv4, n.bridge.gatewayIPv6 = gw4, gw6
+	n.Unlock()
+	d.config = config
+
+	return nil
+}
+
+// updateGateway reserves the requested gateway of the network, and returns
+// the gateway the endpoints get: the requested one, or the bridge address
+// when nil.
+func updateGateway(ipam ipamapi.IPAM, network *net.IPNet, gw, bridgeIP net.IP) (net.IP, error) {
+	if gw == nil {
+		return bridgeIP, nil
+	}
+	if !network.Contains(gw) {
+		return nil, ErrInvalidGateway
+	}
+	if _, err := ipam.RequestAddress(network, gw); err != nil {
+		return nil, err
+	}
+	return gw, nil
+}
+
 func (d *driver) DeleteNetwork(nid types.UUID) error {
 	var err error
 
 	// Get network handler and remove it from driver
 	d.Lock()
 	n := d.network
+	config := d.config
 	d.network = nil
 	d.Unlock()
 
@@ -280,7 +769,7 @@ func (d *driver) DeleteNetwork(nid types.UUID) error {
 	}()
 
 	// Sanity check
-	if n == nil {
+	if n == nil || n.id != nid {
 		err = driverapi.ErrNoNetwork
 		return err
 	}
@@ -292,12 +781,99 @@ func (d *driver) DeleteNetwork(nid types.UUID) error {
 	}
 
 	// Programming
-	err = netlink.LinkDel(n.bridge.Link)
+	if config.EnableIPTables {
+		if e := teardownIPTables(config, n.bridge); e != nil {
+			log.Warnf("Failed to remove the iptables rules of network %s: %v", nid, e)
+		}
+	}
 
-	return err
+	// The bridge managed outside the driver is left in place, as it was
+	if !n.bridge.external {
+		err = netlink.LinkDel(n.bridge.Link)
+		if err != nil {
+			return err
+		}
+	} else {
+		if e := teardownPromisc(config, n.bridge); e != nil {
+			log.Warnf("Failed to restore the promiscuous mode of bridge %s: %v", config.BridgeName, e)
+		}
+		if e := teardownBridgeOptions(config, n.bridge); e != nil {
+			log.Warnf("Failed to restore the options of bridge %s: %v", config.BridgeName, e)
+		}
+	}
+	if e := teardownVlan(config, n.bridge); e != nil {
+		log.Warnf("Failed to remove VLAN sub-interface %s of network %s: %v", vlanName(config), nid, e)
+	}
+
+	// Release the address pools of the network
+	ipam := n.bridge.allocator()
+	for _, pool := range n.bridge.networkPools(config) {
+		if e := ipam.ReleasePool(pool); e != nil {
+			log.Warnf("Failed to release pool %v of network %s: %v", pool, nid, e)
+		}
+	}
+
+	if config.EnableLeakCheck {
+		checkLeaks(nid, config, n.bridge)
+	}
+
+	return nil
+}
+
+// checkLeaks logs the address pools and the host ports of the deleted network
+// nid which are still allocated.
+func checkLeaks(nid types.UUID, config *Configuration, i *bridgeInterface) {
+	for _, pool := range i.networkPools(config) {
+		if i.ipam == nil {
+			if count, ok := ipAllocator.Allocated(pool); ok {
+				log.Errorf("Pool %v of deleted network %s still registered, with %d addresses allocated", pool, nid, count)
+			}
+		}
+		for _, host := range portMapper.MappedTo(pool) {
+			log.Errorf("Host port %v still mapped to pool %v of deleted network %s", host, pool, nid)
+		}
+	}
+}
+
+func (d *driver) NetworkInfo(nid types.UUID) (*driverapi.NetworkInfo, error) {
+	d.Lock()
+	n := d.network
+	config := d.config
+	d.Unlock()
+
+	if n == nil || n.id != nid {
+		return nil, driverapi.ErrNoNetwork
+	}
+
+	n.Lock()
+	gw4, gw6 := n.bridge.gatewayIPv4, n.bridge.gatewayIPv6
+	n.Unlock()
+
+	subnet := &net.IPNet{IP: n.bridge.bridgeIPv4.IP.Mask(n.bridge.bridgeIPv4.Mask), Mask: n.bridge.bridgeIPv4.Mask}
+	info := &driverapi.NetworkInfo{
+		Subnets: []*net.IPNet{subnet},
+		Gateway: gw4,
+	}
+
+	// The pool holds the fixed CIDR range and the reserved addresses
+	if c, ok := n.bridge.allocator().(ipamapi.Counter); ok {
+		total, used := c.CountAddresses(n.bridge.bridgeIPv4)
+		info.IPv4Addresses = &driverapi.AddressCount{Total: total, Used: used}
+	}
+
+	if config.EnableIPv6 {
+		info.Subnets = append(info.Subnets, ipv6Pool(config, n.bridge))
+		info.GatewayIPv6 = gw6
+	}
+
+	return info, nil
 }
 
 func (d *driver) CreateEndpoint(nid, eid types.UUID, epOptions interface{}) (*sandbox.Info, error) {
+	return d.CreateEndpointWithContext(context.Background(), nid, eid, epOptions)
+}
+
+func (d *driver) CreateEndpointWithContext(ctx context.Context, nid, eid types.UUID, epOptions interface{}) (*sandbox.Info, error) {
 	var (
 		ipv6Addr *net.IPNet
 		err      error
@@ -318,6 +894,7 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epOptions interface{}) (*sa
 		n.Unlock()
 		return nil, InvalidNetworkIDError(nid)
 	}
+	gw4, gw6 := n.bridge.gatewayIPv4, n.bridge.gatewayIPv6
 	n.Unlock()
 
 	// Check if endpoint id is good and retrieve correspondent endpoint
@@ -331,16 +908,14 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epOptions interface{}) (*sa
 		return nil, driverapi.ErrEndpointExists
 	}
 
-	// Try to convert the options to endpoint configuration
-	epConfig, err := parseEndpointOptions(epOptions)
+	epConfig, bw, err := validateEndpoint(config, n.bridge, gw4, gw6, epOptions)
 	if err != nil {
-		n.Unlock()
 		return nil, err
 	}
 
 	// Create and add the endpoint
 	n.Lock()
-	endpoint := &bridgeEndpoint{id: eid, config: epConfig}
+	endpoint := &bridgeEndpoint{id: eid, config: epConfig, bandwidth: bw}
 	n.endpoints[eid] = endpoint
 	n.Unlock()
 
@@ -353,6 +928,35 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epOptions interface{}) (*sa
 		}
 	}()
 
+	// v4 address for the sandbox side pipe interface
+	var reqIP4 net.IP
+	if epConfig != nil && epConfig.AddressIPv4 != nil {
+		reqIP4 = epConfig.AddressIPv4
+	}
+
+	ipam := n.bridge.allocator()
+	ip4, err := requestIP(ipam, n.bridge.bridgeIPv4, reqIP4)
+	if err != nil {
+		return nil, err
+	}
+	ipv4Addr := &net.IPNet{IP: ip4, Mask: n.bridge.bridgeIPv4.Mask}
+	defer func() {
+		if err != nil {
+			ipam.ReleaseAddress(n.bridge.bridgeIPv4, ip4)
+		}
+	}()
+
+	// Derive the MAC address from the v4 address unless specified, so that it
+	// is stable across restarts
+	mac := netutils.GenerateMACFromIP(ip4)
+	if epConfig != nil && epConfig.MacAddress != nil {
+		mac = epConfig.MacAddress
+	}
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	// Generate a name for what will be the host side pipe interface
 	name1, err := generateIfaceName()
 	if err != nil {
@@ -395,24 +999,23 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epOptions interface{}) (*sa
 		}
 	}()
 
-	mac := netutils.GenerateRandomMAC()
-	// Add user specified attributes
-	if epConfig != nil && epConfig.MacAddress != nil {
-		mac = epConfig.MacAddress
-	}
-
 	err = netlink.LinkSetHardwareAddr(sbox, mac)
 	if err != nil {
 		return nil, err
 	}
 
-	// Add bridge inherited attributes to pipe interfaces
-	if config.Mtu != 0 {
-		err = netlink.LinkSetMTU(host, config.Mtu)
+	// Add bridge inherited attributes to pipe interfaces, the sandbox side
+	// keeping its MTU once moved into the sandbox
+	mtu := config.Mtu
+	if epConfig != nil && epConfig.Mtu != 0 {
+		mtu = epConfig.Mtu
+	}
+	if mtu != 0 {
+		err = netlink.LinkSetMTU(host, mtu)
 		if err != nil {
 			return nil, err
 		}
-		err = netlink.LinkSetMTU(sbox, config.Mtu)
+		err = netlink.LinkSetMTU(sbox, mtu)
 		if err != nil {
 			return nil, err
 		}
@@ -424,24 +1027,36 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epOptions interface{}) (*sa
 		return nil, err
 	}
 
-	// v4 address for the sandbox side pipe interface
-	ip4, err := ipAllocator.RequestIP(n.bridge.bridgeIPv4, nil)
-	if err != nil {
+	if config.EnableHairpinMode {
+		if err = netlink.LinkSetHairpin(host, true); err != nil {
+			return nil, err
+		}
+		if config.EnableIPTables {
+			if err = setupHairpinNAT(ip4, true); err != nil {
+				return nil, err
+			}
+			defer func() {
+				if err != nil {
+					setupHairpinNAT(ip4, false)
+				}
+			}()
+		}
+	}
+
+	if err = ctx.Err(); err != nil {
 		return nil, err
 	}
-	ipv4Addr := &net.IPNet{IP: ip4, Mask: n.bridge.bridgeIPv4.Mask}
 
 	// v6 address for the sandbox side pipe interface
 	if config.EnableIPv6 {
 		var ip6 net.IP
 
-		network := n.bridge.bridgeIPv6
-		if config.FixedCIDRv6 != nil {
-			network = config.FixedCIDRv6
-		}
+		network := ipv6Pool(config, n.bridge)
 
 		ones, _ := network.Mask.Size()
-		if ones <= 80 {
+		if epConfig != nil && epConfig.AddressIPv6 != nil {
+			ip6 = epConfig.AddressIPv6
+		} else if ones <= 80 {
 			ip6 = make(net.IP, len(network.IP))
 			copy(ip6, network.IP)
 			for i, h := range mac {
@@ -449,7 +1064,7 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epOptions interface{}) (*sa
 			}
 		}
 
-		ip6, err := ipAllocator.RequestIP(network, ip6)
+		ip6, err = requestIP(ipam, network, ip6)
 		if err != nil {
 			return nil, err
 		}
@@ -462,24 +1077,102 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epOptions interface{}) (*sa
 	intf := &sandbox.Interface{}
 	intf.SrcName = name2
 	intf.DstName = containerVeth
+	intf.MacAddress = mac
 	intf.Address = ipv4Addr
 
 	// Update endpoint with the sandbox interface info
 	endpoint.port = intf
+	endpoint.hostVeth = name1
 
 	// Generate the sandbox info to return
 	sinfo := &sandbox.Info{Interfaces: []*sandbox.Interface{intf}}
 
-	// Set the default gateway(s) for the sandbox
-	sinfo.Gateway = n.bridge.gatewayIPv4
+	// Set the default gateway(s) for the sandbox, but on an internal
+	// network whose traffic stays local
+	if !config.Internal {
+		sinfo.Gateway = gw4
+	}
 	if config.EnableIPv6 {
 		intf.AddressIPv6 = ipv6Addr
-		sinfo.GatewayIPv6 = n.bridge.gatewayIPv6
+		if !config.Internal {
+			sinfo.GatewayIPv6 = gw6
+		}
 	}
 
 	return sinfo, nil
 }
 
+// ValidateEndpoint checks an endpoint can be created on the network with the
+// passed options, without allocating anything.
+func (d *driver) ValidateEndpoint(nid types.UUID, epOptions interface{}) error {
+	d.Lock()
+	n := d.network
+	config := d.config
+	d.Unlock()
+	if n == nil {
+		return driverapi.ErrNoNetwork
+	}
+
+	n.Lock()
+	if n.id != nid {
+		n.Unlock()
+		return InvalidNetworkIDError(nid)
+	}
+	gw4, gw6 := n.bridge.gatewayIPv4, n.bridge.gatewayIPv6
+	n.Unlock()
+
+	_, _, err := validateEndpoint(config, n.bridge, gw4, gw6, epOptions)
+	return err
+}
+
+// validateEndpoint parses and checks the endpoint options against the
+// network, returning the endpoint configuration and its rate limits.
+func validateEndpoint(config *Configuration, i *bridgeInterface, gw4, gw6 net.IP, epOptions interface{}) (*EndpointConfiguration, *types.Bandwidth, error) {
+	// Try to convert the options to endpoint configuration
+	epConfig, err := parseEndpointOptions(epOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if epConfig != nil {
+		if err := validateMtu(epConfig.Mtu); err != nil {
+			return nil, nil, err
+		}
+		if epConfig.MacAddress != nil {
+			if err := validateMacAddress(epConfig.MacAddress); err != nil {
+				return nil, nil, err
+			}
+		}
+		if epConfig.AddressIPv4 != nil {
+			if err := validateRequestedIP(i.bridgeIPv4, gw4, epConfig.AddressIPv4); err != nil {
+				return nil, nil, err
+			}
+			if name, ok := i.reservedIPv4(epConfig.AddressIPv4); ok {
+				return nil, nil, ReservedAddressError(name)
+			}
+		}
+		if config.EnableIPv6 && config.IPv6AcceptRA && epConfig.AddressIPv6 != nil {
+			return nil, nil, IPv6AddressingConflictError("AddressIPv6")
+		}
+		if config.EnableIPv6 && epConfig.AddressIPv6 != nil {
+			if err := validateRequestedIP(ipv6Pool(config, i), gw6, epConfig.AddressIPv6); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	bw, err := parseBandwidth(epConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := validateFirewallRules(epConfig); err != nil {
+		return nil, nil, err
+	}
+
+	return epConfig, bw, nil
+}
+
 func (d *driver) DeleteEndpoint(nid, eid types.UUID) error {
 	var err error
 
@@ -514,6 +1207,20 @@ func (d *driver) DeleteEndpoint(nid, eid types.UUID) error {
 	delete(n.endpoints, eid)
 	n.Unlock()
 
+	// Release the ports still bound if the sandbox never left
+	if ep.portMapping != nil {
+		if e := releasePorts(ep); e != nil {
+			log.Warnf("Failed to release port bindings of endpoint %s: %v", eid, e)
+		}
+		ep.portMapping = nil
+	}
+
+	if config.EnableHairpinMode && config.EnableIPTables {
+		if e := setupHairpinNAT(ep.port.Address.IP, false); e != nil {
+			log.Warnf("Failed to remove the hairpin NAT rule of endpoint %s: %v", eid, e)
+		}
+	}
+
 	// On failure make sure to set back ep in n.endpoints, but only
 	// if it hasn't been taken over already by some other thread.
 	defer func() {
@@ -527,14 +1234,15 @@ func (d *driver) DeleteEndpoint(nid, eid types.UUID) error {
 	}()
 
 	// Release the v4 address allocated to this endpoint's sandbox interface
-	err = ipAllocator.ReleaseIP(n.bridge.bridgeIPv4, ep.port.Address.IP)
+	ipam := n.bridge.allocator()
+	err = ipam.ReleaseAddress(n.bridge.bridgeIPv4, ep.port.Address.IP)
 	if err != nil {
 		return err
 	}
 
 	// Release the v6 address allocated to this endpoint's sandbox interface
 	if config.EnableIPv6 {
-		err := ipAllocator.ReleaseIP(n.bridge.bridgeIPv6, ep.port.AddressIPv6.IP)
+		err := ipam.ReleaseAddress(ipv6Pool(config, n.bridge), ep.port.AddressIPv6.IP)
 		if err != nil {
 			return err
 		}
@@ -550,10 +1258,216 @@ func (d *driver) DeleteEndpoint(nid, eid types.UUID) error {
 	return nil
 }
 
+// Join publishes the endpoint ports on the host
+func (d *driver) Join(nid, eid types.UUID, sboxKey string) (*driverapi.JoinInfo, error) {
+	ep, err := d.getEndpoint(nid, eid)
+	if err != nil {
+		return nil, err
+	}
+
+	d.Lock()
+	config := d.config
+	d.Unlock()
+
+	if config.EnableIPv6 {
+		if err := setupAcceptRA(sboxKey, ep, config.IPv6AcceptRA); err != nil {
+			return nil, err
+		}
+	}
+
+	bindings, err := allocatePorts(ep.id, ep.config, ep.port, config.EnableUserlandProxy)
+	if err != nil {
+		return nil, err
+	}
+	ep.portMapping = bindings
+
+	jinfo := &driverapi.JoinInfo{}
+	for _, b := range bindings {
+		jinfo.PortBindings = append(jinfo.PortBindings, b.GetCopy())
+	}
+
+	if ep.bandwidth != nil {
+		if err := setupBandwidth(sboxKey, ep); err != nil {
+			releasePorts(ep)
+			ep.portMapping = nil
+			return nil, err
+		}
+		ep.sboxKey = sboxKey
+		bw := *ep.bandwidth
+		jinfo.Bandwidth = &bw
+	}
+
+	if ep.config != nil && len(ep.config.FirewallRules) != 0 {
+		if err := setupFirewall(sboxKey, ep); err != nil {
+			if ep.bandwidth != nil {
+				teardownBandwidth(sboxKey, ep)
+			}
+			ep.sboxKey = ""
+			releasePorts(ep)
+			ep.portMapping = nil
+			return nil, err
+		}
+		ep.sboxKey = sboxKey
+		for _, r := range ep.config.FirewallRules {
+			jinfo.FirewallRules = append(jinfo.FirewallRules, r.GetCopy())
+		}
+	}
+
+	return jinfo, nil
+}
+
+// Leave removes the endpoint ports published on the host and the rate limits
+// of the endpoint
+func (d *driver) Leave(nid, eid types.UUID) error {
+	ep, err := d.getEndpoint(nid, eid)
+	if err != nil {
+		return err
+	}
+
+	if ep.sboxKey != "" {
+		if ep.bandwidth != nil {
+			if e := teardownBandwidth(ep.sboxKey, ep); e != nil {
+				log.Warnf("Failed to remove the rate limits of endpoint %s: %v", eid, e)
+			}
+		}
+		if ep.config != nil && len(ep.config.FirewallRules) != 0 {
+			if e := teardownFirewall(ep.sboxKey, ep); e != nil {
+				log.Warnf("Failed to remove the firewall rules of endpoint %s: %v", eid, e)
+			}
+		}
+		ep.sboxKey = ""
+	}
+
+	err = releasePorts(ep)
+	ep.portMapping = nil
+
+	return err
+}
+
+// RestoreNetwork sets the network up again as CreateNetwork does, which
+// reconciles the bridge left in place with the configuration, or creates it
+// again after a reboot of the host. The network options are not kept across
+// restarts: the restored network allocates from the default allocator and
+// reserves no auxiliary address. A network the driver still knows, as when
+// the controller restarted in the same process, is left as is.
+func (d *driver) RestoreNetwork(nid types.UUID) error {
+	d.Lock()
+	known := d.network != nil && d.network.id == nid
+	d.Unlock()
+	if known {
+		return nil
+	}
+
+	return d.CreateNetworkWithContext(context.Background(), nid, nil)
+}
+
+// RestoreEndpoint takes back the interface and the addresses CreateEndpoint
+// allocated to the endpoint, reserving the addresses again. The endpoint
+// options are not kept across restarts: the restored endpoint publishes no
+// port and has no rate limit nor firewall rule. An endpoint the driver still
+// knows is left as is.
+func (d *driver) RestoreEndpoint(nid, eid types.UUID, sinfo *sandbox.Info) error {
+	d.Lock()
+	n := d.network
+	config := d.config
+	d.Unlock()
+	if n == nil {
+		return driverapi.ErrNoNetwork
+	}
+
+	n.Lock()
+	if n.id != nid {
+		n.Unlock()
+		return InvalidNetworkIDError(nid)
+	}
+	n.Unlock()
+
+	if sinfo == nil || len(sinfo.Interfaces) == 0 || sinfo.Interfaces[0].Address == nil {
+		return ErrInvalidEndpointConfig
+	}
+	intf := sinfo.Interfaces[0].GetCopy()
+	if config.EnableIPv6 && intf.AddressIPv6 == nil {
+		return ErrInvalidEndpointConfig
+	}
+
+	ep, err := n.getEndpoint(eid)
+	if err != nil || ep != nil {
+		return err
+	}
+
+	// The addresses are still allocated if the controller restarted in
+	// the same process
+	ipam := n.bridge.allocator()
+	if _, err := requestIP(ipam, n.bridge.bridgeIPv4, intf.Address.IP); err != nil && err != ErrIPAlreadyAllocated {
+		return err
+	}
+	if config.EnableIPv6 {
+		if _, err := requestIP(ipam, ipv6Pool(config, n.bridge), intf.AddressIPv6.IP); err != nil && err != ErrIPAlreadyAllocated {
+			ipam.ReleaseAddress(n.bridge.bridgeIPv4, intf.Address.IP)
+			return err
+		}
+	}
+
+	n.Lock()
+	n.endpoints[eid] = &bridgeEndpoint{id: eid, port: intf}
+	n.Unlock()
+
+	return nil
+}
+
+func (d *driver) getEndpoint(nid, eid types.UUID) (*bridgeEndpoint, error) {
+	// Get the network handler and make sure it exists
+	d.Lock()
+	n := d.network
+	d.Unlock()
+	if n == nil {
+		return nil, driverapi.ErrNoNetwork
+	}
+
+	// Sanity Check
+	n.Lock()
+	if n.id != nid {
+		n.Unlock()
+		return nil, InvalidNetworkIDError(nid)
+	}
+	n.Unlock()
+
+	// Check endpoint id and if an endpoint is actually there
+	ep, err := n.getEndpoint(eid)
+	if err != nil {
+		return nil, err
+	}
+	if ep == nil {
+		return nil, EndpointNotFoundError(eid)
+	}
+
+	return ep, nil
+}
+
 func (d *driver) Type() string {
 	return networkType
 }
 
+func (d *driver) Capabilities() driverapi.DriverCapability {
+	return driverapi.DriverCapability{IPv6: true, PortMapping: true, StaticIP: true, Privileged: true}
+}
+
+func parseNetworkOptions(option interface{}) (*NetworkConfiguration, error) {
+	switch opt := option.(type) {
+	case options.Generic:
+		opaqueConfig, err := options.GenerateFromModel(opt, &NetworkConfiguration{})
+		if err != nil {
+			return nil, err
+		}
+		return opaqueConfig.(*NetworkConfiguration), nil
+	case *NetworkConfiguration:
+		return opt, nil
+	default:
+		// Callers not configuring the network may pass anything
+		return &NetworkConfiguration{}, nil
+	}
+}
+
 func parseEndpointOptions(epOptions interface{}) (*EndpointConfiguration, error) {
 	if epOptions == nil {
 		return nil, nil
@@ -572,6 +1486,59 @@ func parseEndpointOptions(epOptions interface{}) (*EndpointConfiguration, error)
 	}
 }
 
+// validateMtu checks the MTU, when set, is within the range the interfaces
+// accept.
+func validateMtu(mtu int) error {
+	if mtu != 0 && (mtu < minMtu || mtu > maxMtu) {
+		return InvalidMtuError(mtu)
+	}
+	return nil
+}
+
+// validateRequestedIP checks the user requested endpoint address belongs to
+// the network and does not collide with the network gateway.
+func validateRequestedIP(network *net.IPNet, gw net.IP, ip net.IP) error {
+	if !network.Contains(ip) {
+		return ErrIPOutOfRange
+	}
+	if ip.Equal(gw) {
+		return ErrIPAlreadyAllocated
+	}
+	return nil
+}
+
+// requestIP requests the passed address, or the next available one when nil,
+// translating the allocator errors into the driver ones.
+func requestIP(ipam ipamapi.IPAM, network *net.IPNet, ip net.IP) (net.IP, error) {
+	allocated, err := ipam.RequestAddress(network, ip)
+	switch err {
+	case ipamapi.ErrIPAlreadyAllocated:
+		return nil, ErrIPAlreadyAllocated
+	case ipamapi.ErrIPOutOfRange:
+		return nil, ErrIPOutOfRange
+	}
+	return allocated, err
+}
+
+// validateMacAddress checks the user requested MAC address can be assigned to
+// the sandbox interface.
+func validateMacAddress(mac net.HardwareAddr) error {
+	// A broadcast address also has the group bit set
+	if len(mac) != 6 || mac[0]&0x01 != 0 {
+		return InvalidMacAddressError(mac.String())
+	}
+	return nil
+}
+
+// ipv6Pool returns the pool the endpoints global IPv6 addresses are
+// allocated from.
+func ipv6Pool(config *Configuration, i *bridgeInterface) *net.IPNet {
+	if config.FixedCIDRv6 != nil {
+		return config.FixedCIDRv6
+	}
+	return i.bridgeIPv6
+}
+
 // Generates a name to be used for a virtual ethernet
 // interface. The name is constructed by 'veth' appended
 // by a randomly generated hex value. (example: veth0f60e2c)
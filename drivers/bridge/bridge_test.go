@@ -2,10 +2,19 @@ package bridge
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"net"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ipallocator"
+	"github.com/docker/libnetwork/ipamapi"
 	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/pkg/options"
+	"github.com/docker/libnetwork/types"
 	"github.com/vishvananda/netlink"
 )
 
@@ -23,6 +32,40 @@ func TestCreate(t *testing.T) {
 	}
 }
 
+func TestCreateIPForwarding(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	// The forwarding is only enabled when configured and allowed, and left
+	// untouched otherwise whatever the form of the configuration
+	for _, c := range []struct {
+		config  interface{}
+		allowed bool
+		setting string
+	}{
+		{options.Generic{"BridgeName": DefaultBridgeName}, true, "0\n"},
+		{&Configuration{BridgeName: DefaultBridgeName}, true, "0\n"},
+		{options.Generic{"BridgeName": DefaultBridgeName, "EnableIPForwarding": true}, false, "0\n"},
+		{options.Generic{"BridgeName": DefaultBridgeName, "EnableIPForwarding": true}, true, "1\n"},
+	} {
+		writeIPForwardingSetting(t, []byte{'0', '\n'})
+
+		_, d := New()
+		d.(*driver).SetIPForwarding(c.allowed)
+		if err := d.Config(c.config); err != nil {
+			t.Fatalf("Failed to setup driver config: %v", err)
+		}
+		if err := d.CreateNetwork("dummy", nil); err != nil {
+			t.Fatalf("Failed to create bridge: %v", err)
+		}
+		if setting := readCurrentIPForwardingSetting(t); string(setting) != c.setting {
+			t.Fatalf("Expected IP forwarding setting %q with config %+v, allowed %t, got %q", c.setting, c.config, c.allowed, setting)
+		}
+		if err := d.DeleteNetwork("dummy"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 func TestCreateFail(t *testing.T) {
 	defer netutils.SetupTestNetNS(t)()
 	_, d := New()
@@ -37,6 +80,343 @@ func TestCreateFail(t *testing.T) {
 	}
 }
 
+func TestCreateContextCancelled(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	config := &Configuration{BridgeName: DefaultBridgeName}
+	if err := d.Config(config); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := d.CreateNetworkWithContext(ctx, "dummy", ""); err != context.Canceled {
+		t.Fatalf("Expected %v, got %v", context.Canceled, err)
+	}
+
+	// The cancelled network must not be held by the driver
+	if err := d.CreateNetwork("dummy", ""); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+}
+
+func TestCreateFailRollback(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	_, cidrv6, _ := net.ParseCIDR("2001:db8:245::/64")
+	config := &Configuration{
+		BridgeName:  DefaultBridgeName,
+		EnableIPv6:  true,
+		FixedCIDRv6: cidrv6,
+	}
+	if err := d.Config(config); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	// Have the last pool request of the setup fail
+	if err := ipAllocator.RequestPool(cidrv6, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer ipAllocator.ReleasePool(cidrv6)
+
+	if err := d.CreateNetwork("dummy", ""); err == nil {
+		t.Fatal("Bridge creation was expected to fail")
+	}
+
+	if _, err := netlink.LinkByName(DefaultBridgeName); err == nil {
+		t.Fatal("Bridge device left behind after the network creation failure")
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != driverapi.ErrNoNetwork {
+		t.Fatalf("Expected %v, got %v", driverapi.ErrNoNetwork, err)
+	}
+}
+
+func TestCreateUseExistingBridge(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+	dr := d.(*driver)
+
+	br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "ext0"}}
+	if err := netlink.LinkAdd(br); err != nil {
+		t.Fatal(err)
+	}
+	ip, subnet, _ := net.ParseCIDR("192.168.100.1/24")
+	subnet.IP = ip
+	if err := netlink.AddrAdd(br, &netlink.Addr{IPNet: subnet}); err != nil {
+		t.Fatal(err)
+	}
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth0"}, PeerName: "veth1"}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name string
+		err  error
+	}{
+		{"missing0", BridgeNotFoundError("missing0")},
+		{"veth0", NotABridgeError("veth0")},
+	} {
+		config := &Configuration{BridgeName: c.name, UseExistingBridge: true, Override: true}
+		if err := d.Config(config); err != nil {
+			t.Fatalf("Failed to setup driver config: %v", err)
+		}
+		if err := d.CreateNetwork("dummy", ""); err != c.err {
+			t.Fatalf("Expected %v, got %v", c.err, err)
+		}
+	}
+
+	config := &Configuration{BridgeName: "ext0", UseExistingBridge: true, Override: true}
+	if err := d.Config(config); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+	if err := d.CreateNetwork("dummy", ""); err != nil {
+		t.Fatalf("Failed to create network on the existing bridge: %v", err)
+	}
+	if n := dr.network.bridge.bridgeIPv4; n.String() != subnet.String() {
+		t.Fatalf("Expected the bridge subnet %s, got %s", subnet, n)
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatalf("Failed to delete network: %v", err)
+	}
+	if _, err := netlink.LinkByName("ext0"); err != nil {
+		t.Fatalf("Existing bridge deleted along with the network: %v", err)
+	}
+}
+
+func TestCreatePromiscuous(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "ext0"}}
+	if err := netlink.LinkAdd(br); err != nil {
+		t.Fatal(err)
+	}
+	ip, subnet, _ := net.ParseCIDR("192.168.100.1/24")
+	subnet.IP = ip
+	if err := netlink.AddrAdd(br, &netlink.Addr{IPNet: subnet}); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Configuration{BridgeName: "ext0", UseExistingBridge: true, EnablePromiscuous: true}
+	if err := d.Config(config); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	// The mode the operator set is left in place, the one the driver set
+	// is removed along with the network
+	for _, operatorSet := range []bool{false, true} {
+		if err := netutils.SetPromisc("ext0", operatorSet); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.CreateNetwork("dummy", ""); err != nil {
+			t.Fatalf("Failed to create network: %v", err)
+		}
+		if on, err := netutils.Promisc("ext0"); err != nil || !on {
+			t.Fatalf("Bridge not in promiscuous mode: %v", err)
+		}
+
+		if err := d.DeleteNetwork("dummy"); err != nil {
+			t.Fatalf("Failed to delete network: %v", err)
+		}
+		if on, err := netutils.Promisc("ext0"); err != nil || on != operatorSet {
+			t.Fatalf("Expected the promiscuous mode %t after the deletion, got %t: %v", operatorSet, on, err)
+		}
+	}
+}
+
+func TestCreateVlan(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	// The network creation fails without the parent, leaving no bridge
+	config := &Configuration{BridgeName: DefaultBridgeName, VlanParent: vlanParent, VlanID: 300}
+	if err := d.Config(config); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+	if err := d.CreateNetwork("dummy", nil); err != VlanParentNotFoundError(vlanParent) {
+		t.Fatalf("Expected %v, got %v", VlanParentNotFoundError(vlanParent), err)
+	}
+	if _, err := netlink.LinkByName(DefaultBridgeName); err == nil {
+		t.Fatalf("Bridge %s left behind", DefaultBridgeName)
+	}
+
+	parent := setupVlanParent(t)
+	if err := d.CreateNetwork("dummy", nil); err != nil {
+		t.Fatalf("Failed to create network: %v", err)
+	}
+	verifyVlan(t, config, parent)
+
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatalf("Failed to delete network: %v", err)
+	}
+	if _, err := netlink.LinkByName(vlanName(config)); err == nil {
+		t.Fatalf("VLAN sub-interface %s left behind", vlanName(config))
+	}
+}
+
+func TestDeleteNetworkReleasesPools(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	_, fixedCIDRv6, _ := net.ParseCIDR("2001:db8:2000::/64")
+	config := &Configuration{BridgeName: DefaultBridgeName, EnableIPv6: true, FixedCIDRv6: fixedCIDRv6, EnableLeakCheck: true}
+	if err := d.Config(config); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	// The usage of the allocator does not grow as networks come and go. It
+	// is measured after a first deletion, which also releases the pools of
+	// the same subnet the earlier tests left in the shared allocator
+	var networks, addresses int
+	for i := 0; i < 5; i++ {
+		if err := d.CreateNetwork("dummy", nil); err != nil {
+			t.Fatalf("Failed to create network: %v", err)
+		}
+		if _, err := d.CreateEndpoint("dummy", "ep", nil); err != nil {
+			t.Fatalf("Failed to create endpoint: %v", err)
+		}
+		if count, ok := ipAllocator.Allocated(fixedCIDRv6); !ok || count == 0 {
+			t.Fatalf("Expected addresses allocated from %v, got %d", fixedCIDRv6, count)
+		}
+		if err := d.DeleteEndpoint("dummy", "ep"); err != nil {
+			t.Fatalf("Failed to delete endpoint: %v", err)
+		}
+		if err := d.DeleteNetwork("dummy"); err != nil {
+			t.Fatalf("Failed to delete network: %v", err)
+		}
+
+		if i == 0 {
+			networks, addresses = ipAllocator.Usage()
+		} else if n, a := ipAllocator.Usage(); n != networks || a != addresses {
+			t.Fatalf("Expected %d pools and %d addresses in use after deletion %d, got %d and %d", networks, addresses, i, n, a)
+		}
+	}
+}
+
+func TestCreateBridgeOptionsExistingBridge(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "ext0"}}
+	if err := netlink.LinkAdd(br); err != nil {
+		t.Fatal(err)
+	}
+	ip, subnet, _ := net.ParseCIDR("192.168.100.1/24")
+	subnet.IP = ip
+	if err := netlink.AddrAdd(br, &netlink.Addr{IPNet: subnet}); err != nil {
+		t.Fatal(err)
+	}
+	defaults, err := netutils.GetBridgeOptions("ext0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Configuration{BridgeName: "ext0", UseExistingBridge: true, AgingTime: 30}
+	if err := d.Config(config); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+	if err := d.CreateNetwork("dummy", ""); err != nil {
+		t.Fatalf("Failed to create network: %v", err)
+	}
+
+	// Only the configured option changes
+	opts, err := netutils.GetBridgeOptions("ext0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := *defaults
+	expected.AgingTime = 30 * time.Second
+	if *opts != expected {
+		t.Fatalf("Expected bridge options %+v, got %+v", expected, *opts)
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatalf("Failed to delete network: %v", err)
+	}
+	opts, err = netutils.GetBridgeOptions("ext0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *opts != *defaults {
+		t.Fatalf("Expected the bridge options %+v restored, got %+v", *defaults, *opts)
+	}
+}
+
+func TestCreateSubnetOverlap(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	_, bridgeNet, _ := net.ParseCIDR("192.168.100.1/24")
+	bridgeNet.IP = net.ParseIP("192.168.100.1")
+	if err := d.Config(&Configuration{BridgeName: DefaultBridgeName, AddressIPv4: bridgeNet}); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+	if err := d.CreateNetwork("dummy", nil); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	for _, c := range []struct {
+		cidr  string
+		allow bool
+		err   error
+	}{
+		{"192.168.101.1/24", false, nil},
+		{"192.168.100.129/25", false, ErrSubnetOverlap},
+		{"192.168.0.1/16", false, ErrSubnetOverlap},
+		{"10.20.0.1/16", false, nil},
+		{"192.168.100.129/25", true, nil},
+	} {
+		ip, addr, _ := net.ParseCIDR(c.cidr)
+		addr.IP = ip
+
+		_, od := New()
+		config := &Configuration{BridgeName: "ovltest0", AllowNonDefaultBridge: true, AddressIPv4: addr, AllowSubnetOverlap: c.allow}
+		if err := od.Config(config); err != nil {
+			t.Fatalf("Failed to setup driver config: %v", err)
+		}
+		if err := od.CreateNetwork("other", nil); err != c.err {
+			t.Fatalf("Expected %v creating a bridge on %s, got %v", c.err, c.cidr, err)
+		}
+		if c.err != nil {
+			if _, err := netlink.LinkByName("ovltest0"); err == nil {
+				t.Fatalf("Bridge created on the overlapping subnet %s", c.cidr)
+			}
+			continue
+		}
+		if err := od.DeleteNetwork("other"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDeleteNetworkOtherID(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	config := &Configuration{BridgeName: DefaultBridgeName}
+	if err := d.Config(config); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	if err := d.CreateNetwork("dummy", ""); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	if err := d.DeleteNetwork("other"); err != driverapi.ErrNoNetwork {
+		t.Fatalf("Expected %v, got %v", driverapi.ErrNoNetwork, err)
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatalf("Failed to delete bridge: %v", err)
+	}
+}
+
 func TestCreateFullOptions(t *testing.T) {
 	defer netutils.SetupTestNetNS(t)()
 	_, d := New()
@@ -92,6 +472,49 @@ func TestCreateLinkWithOptions(t *testing.T) {
 	}
 }
 
+func TestCreateEndpointFixedCIDR(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	ip, network, _ := net.ParseCIDR("192.168.246.1/24")
+	network.IP = ip
+	_, fixedCIDR, _ := net.ParseCIDR("192.168.246.128/25")
+
+	config := &Configuration{
+		BridgeName:            "dockertest1",
+		AllowNonDefaultBridge: true,
+		AddressIPv4:           network,
+		FixedCIDR:             fixedCIDR,
+		Mtu:                   1400,
+	}
+	if err := d.Config(config); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	if err := d.CreateNetwork("dummy", ""); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	defer d.DeleteNetwork("dummy")
+
+	lnk, err := netlink.LinkByName(config.BridgeName)
+	if err != nil {
+		t.Fatalf("Failed to retrieve bridge device: %v", err)
+	}
+	if lnk.Attrs().MTU != config.Mtu {
+		t.Fatalf("Expected bridge MTU %d, got %d", config.Mtu, lnk.Attrs().MTU)
+	}
+
+	sinfo, err := d.CreateEndpoint("dummy", "ep1", nil)
+	if err != nil {
+		t.Fatalf("Failed to create endpoint: %v", err)
+	}
+	defer d.DeleteEndpoint("dummy", "ep1")
+
+	if addr := sinfo.Interfaces[0].Address; !fixedCIDR.Contains(addr.IP) {
+		t.Fatalf("Endpoint address %v not allocated from %v", addr, fixedCIDR)
+	}
+}
+
 func TestValidateConfig(t *testing.T) {
 
 	// Test mtu
@@ -101,12 +524,48 @@ func TestValidateConfig(t *testing.T) {
 		t.Fatalf("Failed to detect invalid MTU number")
 	}
 
+	c.Mtu = 70000
+	if err := c.Validate(); err != InvalidMtuError(70000) {
+		t.Fatalf("Expected %v, got %v", InvalidMtuError(70000), err)
+	}
+
+	// Test IPv6 addressing modes
+	_, fixedCIDRv6, _ := net.ParseCIDR("2001:db8::/64")
+	c = Configuration{EnableIPv6: true, IPv6AcceptRA: true, FixedCIDRv6: fixedCIDRv6}
+	if err := c.Validate(); err != IPv6AddressingConflictError("FixedCIDRv6") {
+		t.Fatalf("Expected %v, got %v", IPv6AddressingConflictError("FixedCIDRv6"), err)
+	}
+	c.FixedCIDRv6 = nil
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Unexpected validation error on router advertisements: %v", err)
+	}
+
 	c.Mtu = 9000
 	err = c.Validate()
 	if err != nil {
 		t.Fatalf("unexpected validation error on MTU number")
 	}
 
+	// Test network isolation
+	c = Configuration{EnableNetworkIsolation: true}
+	if err := c.Validate(); err != ErrIsolationNoIPTables {
+		t.Fatalf("Expected %v, got %v", ErrIsolationNoIPTables, err)
+	}
+
+	// Test internal network
+	c = Configuration{Internal: true}
+	if err := c.Validate(); err != ErrInternalNoIPTables {
+		t.Fatalf("Expected %v, got %v", ErrInternalNoIPTables, err)
+	}
+	c = Configuration{Internal: true, EnableIPTables: true, DefaultGatewayIPv4: net.ParseIP("172.28.0.1")}
+	if err := c.Validate(); err != ErrInternalGateway {
+		t.Fatalf("Expected %v, got %v", ErrInternalGateway, err)
+	}
+	c.DefaultGatewayIPv4 = nil
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Unexpected validation error on internal network: %v", err)
+	}
+
 	// Bridge network
 	_, network, _ := net.ParseCIDR("172.28.0.0/16")
 
@@ -179,6 +638,17 @@ func TestValidateConfig(t *testing.T) {
 	if err == nil {
 		t.Fatalf("Failed to detect invalid v6 default gateway")
 	}
+
+	// Test host port range
+	c = Configuration{HostPortRangeStart: 60000}
+	if err := c.Validate(); err != ErrInvalidPortRange {
+		t.Fatalf("Expected %v, got %v", ErrInvalidPortRange, err)
+	}
+
+	c.HostPortRangeEnd = 60000
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Unexpected validation error on host port range: %v", err)
+	}
 }
 
 func TestSetDefaultGw(t *testing.T) {
@@ -220,3 +690,444 @@ func TestSetDefaultGw(t *testing.T) {
 		t.Fatalf("Failed to configure default gateway. Expected %v. Found %v", gw6, sinfo.GatewayIPv6)
 	}
 }
+
+func TestUpdateNetwork(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	_, bridgeNet, _ := net.ParseCIDR("192.168.252.1/24")
+	bridgeNet.IP = net.ParseIP("192.168.252.1")
+	config := &Configuration{BridgeName: DefaultBridgeName, AddressIPv4: bridgeNet}
+	if err := d.Config(config); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	if err := d.CreateNetwork("dummy", nil); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	gw4 := net.ParseIP("192.168.252.254")
+	if err := d.UpdateNetwork("dummy", options.Generic{"DefaultGatewayIPv4": gw4, "EnableICC": true}); err != nil {
+		t.Fatalf("Failed to update the network: %v", err)
+	}
+
+	info, err := d.NetworkInfo("dummy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Gateway.Equal(gw4) {
+		t.Fatalf("Expected gateway %v, got %v", gw4, info.Gateway)
+	}
+
+	sinfo, err := d.CreateEndpoint("dummy", "ep", nil)
+	if err != nil {
+		t.Fatalf("Failed to create endpoint: %v", err)
+	}
+	if !sinfo.Gateway.Equal(gw4) {
+		t.Fatalf("Expected gateway %v, got %v", gw4, sinfo.Gateway)
+	}
+
+	// The subnet is fixed at the network creation
+	_, other, _ := net.ParseCIDR("192.168.253.1/24")
+	if err := d.UpdateNetwork("dummy", options.Generic{"AddressIPv4": other}); err != driverapi.ImmutableOptionError("AddressIPv4") {
+		t.Fatalf("Expected %v, got %v", driverapi.ImmutableOptionError("AddressIPv4"), err)
+	}
+
+	// A gateway outside of the network leaves the network unchanged
+	if err := d.UpdateNetwork("dummy", options.Generic{"DefaultGatewayIPv4": net.ParseIP("192.168.253.254")}); err != ErrInvalidGateway {
+		t.Fatalf("Expected %v, got %v", ErrInvalidGateway, err)
+	}
+
+	// Back to the bridge address, the previous gateway is available to the
+	// endpoints again
+	if err := d.UpdateNetwork("dummy", options.Generic{"DefaultGatewayIPv4": net.IP(nil)}); err != nil {
+		t.Fatalf("Failed to update the network: %v", err)
+	}
+	if info, _ = d.NetworkInfo("dummy"); !info.Gateway.Equal(bridgeNet.IP) {
+		t.Fatalf("Expected gateway %v, got %v", bridgeNet.IP, info.Gateway)
+	}
+	if _, err := d.CreateEndpoint("dummy", "ep2", &EndpointConfiguration{AddressIPv4: gw4}); err != nil {
+		t.Fatalf("Failed to reuse the previous gateway address: %v", err)
+	}
+
+	if err := d.UpdateNetwork("other", options.Generic{}); err != driverapi.ErrNoNetwork {
+		t.Fatalf("Expected %v, got %v", driverapi.ErrNoNetwork, err)
+	}
+}
+
+func TestConfigAgain(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	_, bridgeNet, _ := net.ParseCIDR("192.168.250.1/24")
+	bridgeNet.IP = net.ParseIP("192.168.250.1")
+	if err := d.Config(options.Generic{"AddressIPv4": bridgeNet}); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	// The same configuration again is a no-op
+	if err := d.Config(&Configuration{AddressIPv4: bridgeNet}); err != nil {
+		t.Fatalf("Failed to apply the same config again: %v", err)
+	}
+
+	if err := d.Config(&Configuration{AddressIPv4: bridgeNet, EnableICC: true}); err != ErrDriverAlreadyConfigured {
+		t.Fatalf("Expected %v, got %v", ErrDriverAlreadyConfigured, err)
+	}
+
+	// Replaced as a whole before any network exists
+	if err := d.Config(&Configuration{AddressIPv4: bridgeNet, EnableICC: true, Override: true}); err != nil {
+		t.Fatalf("Failed to override the config: %v", err)
+	}
+	if config := d.(*driver).config; !config.EnableICC || config.Override {
+		t.Fatalf("Unexpected config after override: %+v", config)
+	}
+
+	if err := d.CreateNetwork("dummy", nil); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	// The bridge name defaults as on the network creation
+	if err := d.Config(options.Generic{"AddressIPv4": bridgeNet, "EnableICC": true}); err != nil {
+		t.Fatalf("Failed to apply the same config again: %v", err)
+	}
+
+	// Once the network exists, only the options of UpdateNetwork can be
+	// overridden
+	_, other, _ := net.ParseCIDR("192.168.249.1/24")
+	if err := d.Config(&Configuration{AddressIPv4: other, Override: true}); err != driverapi.ImmutableOptionError("AddressIPv4") {
+		t.Fatalf("Expected %v, got %v", driverapi.ImmutableOptionError("AddressIPv4"), err)
+	}
+
+	gw4 := net.ParseIP("192.168.250.254")
+	if err := d.Config(&Configuration{AddressIPv4: bridgeNet, DefaultGatewayIPv4: gw4, Override: true}); err != nil {
+		t.Fatalf("Failed to override the config: %v", err)
+	}
+	if info, err := d.NetworkInfo("dummy"); err != nil || !info.Gateway.Equal(gw4) {
+		t.Fatalf("Expected gateway %v, got %v (%v)", gw4, info, err)
+	}
+
+	// The bridge was kept
+	if _, err := netlink.LinkByName(DefaultBridgeName); err != nil {
+		t.Fatalf("Bridge %s removed by the override: %v", DefaultBridgeName, err)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	_, bridgeNet, _ := net.ParseCIDR("192.168.250.1/24")
+	bridgeNet.IP = net.ParseIP("192.168.250.1")
+	if err := d.Config(&Configuration{AddressIPv4: bridgeNet}); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+	if err := d.CreateNetwork("dummy", nil); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	// The mutable options are applied to the network
+	gw4 := net.ParseIP("192.168.250.253")
+	if err := d.(*driver).ReloadConfig(&Configuration{AddressIPv4: bridgeNet, DefaultGatewayIPv4: gw4}); err != nil {
+		t.Fatalf("Failed to reload the config: %v", err)
+	}
+	if info, err := d.NetworkInfo("dummy"); err != nil || !info.Gateway.Equal(gw4) {
+		t.Fatalf("Expected gateway %v, got %v (%v)", gw4, info, err)
+	}
+	if config := d.(*driver).config; config.Override {
+		t.Fatalf("Unexpected config after reload: %+v", config)
+	}
+
+	// The options the network depends on are all reported, and none of the
+	// changes applied
+	_, other, _ := net.ParseCIDR("192.168.249.1/24")
+	err := d.(*driver).ReloadConfig(&Configuration{AddressIPv4: other, Mtu: 1400})
+	if expected := (driverapi.ImmutableOptionsError{"AddressIPv4", "Mtu"}); !reflect.DeepEqual(err, expected) {
+		t.Fatalf("Expected %v, got %v", expected, err)
+	}
+	if !types.IsInvalidParameter(err) {
+		t.Fatalf("Expected an invalid parameter error, got %v", err)
+	}
+	if config := d.(*driver).config; !config.AddressIPv4.IP.Equal(bridgeNet.IP) || !config.DefaultGatewayIPv4.Equal(gw4) {
+		t.Fatalf("Config changed by the rejected reload: %+v", config)
+	}
+
+	// Without network everything can change
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.(*driver).ReloadConfig(options.Generic{"AddressIPv4": other, "Mtu": 1400}); err != nil {
+		t.Fatalf("Failed to reload the config: %v", err)
+	}
+	if config := d.(*driver).config; config.Mtu != 1400 {
+		t.Fatalf("Unexpected config after reload: %+v", config)
+	}
+}
+
+func TestCreateEndpointStaticIP(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	ip, subnet, _ := net.ParseCIDR("192.168.242.1/24")
+	subnet.IP = ip
+	config := &Configuration{BridgeName: DefaultBridgeName, AddressIPv4: subnet}
+	if err := d.Config(config); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	if err := d.CreateNetwork("dummy", nil); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	reqIP := net.ParseIP("192.168.242.100")
+	sinfo, err := d.CreateEndpoint("dummy", "ep1", &EndpointConfiguration{AddressIPv4: reqIP})
+	if err != nil {
+		t.Fatalf("Failed to create endpoint: %v", err)
+	}
+
+	if !sinfo.Interfaces[0].Address.IP.Equal(reqIP) {
+		t.Fatalf("Requested address %v not honored, got %v", reqIP, sinfo.Interfaces[0].Address.IP)
+	}
+
+	_, err = d.CreateEndpoint("dummy", "ep2", &EndpointConfiguration{AddressIPv4: reqIP})
+	if err != ErrIPAlreadyAllocated {
+		t.Fatalf("Expected ErrIPAlreadyAllocated, got %v", err)
+	}
+
+	_, err = d.CreateEndpoint("dummy", "ep2", &EndpointConfiguration{AddressIPv4: ip})
+	if err != ErrIPAlreadyAllocated {
+		t.Fatalf("Expected ErrIPAlreadyAllocated for the gateway address, got %v", err)
+	}
+
+	_, err = d.CreateEndpoint("dummy", "ep2", &EndpointConfiguration{AddressIPv4: net.ParseIP("10.10.10.10")})
+	if err != ErrIPOutOfRange {
+		t.Fatalf("Expected ErrIPOutOfRange, got %v", err)
+	}
+
+	if err := d.DeleteEndpoint("dummy", "ep1"); err != nil {
+		t.Fatalf("Failed to delete endpoint: %v", err)
+	}
+
+	// Once released the address can be requested again
+	if _, err := d.CreateEndpoint("dummy", "ep2", &EndpointConfiguration{AddressIPv4: reqIP}); err != nil {
+		t.Fatalf("Failed to reuse released address: %v", err)
+	}
+}
+
+func TestCreateAuxAddresses(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	ip, subnet, _ := net.ParseCIDR("192.168.236.1/29")
+	subnet.IP = ip
+	if err := d.Config(&Configuration{BridgeName: DefaultBridgeName, AddressIPv4: subnet}); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	// An auxiliary address outside the subnet is rejected, and no bridge is
+	// left behind
+	outside := &NetworkConfiguration{AuxAddresses: map[string]net.IP{"dns": net.ParseIP("192.168.237.2")}}
+	err := d.CreateNetwork("dummy", outside)
+	if _, ok := err.(*AuxAddressOutOfRangeError); !ok || !types.IsInvalidParameter(err) {
+		t.Fatalf("Expected an AuxAddressOutOfRangeError, got %v", err)
+	}
+	if _, err := netlink.LinkByName(DefaultBridgeName); err == nil {
+		t.Fatalf("Bridge left behind after the network creation failure")
+	}
+
+	aux := net.ParseIP("192.168.236.2")
+	nConfig := &NetworkConfiguration{AuxAddresses: map[string]net.IP{"dns": aux}}
+	if err := d.CreateNetwork("dummy", nConfig); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	for reqIP, name := range map[string]string{"192.168.236.2": "dns", "192.168.236.0": "network", "192.168.236.7": "broadcast"} {
+		_, err := d.CreateEndpoint("dummy", "ep", &EndpointConfiguration{AddressIPv4: net.ParseIP(reqIP)})
+		if err != ReservedAddressError(name) || !types.IsForbidden(err) {
+			t.Fatalf("Expected %v for %s, got %v", ReservedAddressError(name), reqIP, err)
+		}
+	}
+
+	// The subnet addresses left are allocated, never the reserved ones
+	for n := 0; n < 4; n++ {
+		sinfo, err := d.CreateEndpoint("dummy", types.UUID(fmt.Sprintf("ep%d", n)), nil)
+		if err != nil {
+			t.Fatalf("Failed to create endpoint %d: %v", n, err)
+		}
+		if addr := sinfo.Interfaces[0].Address.IP; addr.Equal(aux) || addr.Equal(ip) {
+			t.Fatalf("Reserved address %v allocated to an endpoint", addr)
+		}
+	}
+	if _, err := d.CreateEndpoint("dummy", "ep4", nil); err != ipamapi.ErrNoAvailableIPs {
+		t.Fatalf("Expected %v, got %v", ipamapi.ErrNoAvailableIPs, err)
+	}
+}
+
+func TestValidateEndpoint(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	ip, subnet, _ := net.ParseCIDR("192.168.247.1/24")
+	subnet.IP = ip
+	if err := d.Config(&Configuration{BridgeName: DefaultBridgeName, AddressIPv4: subnet}); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	if err := d.ValidateNetwork(nil); err != nil {
+		t.Fatalf("Failed to validate the network: %v", err)
+	}
+	if err := d.CreateNetwork("dummy", nil); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	if err := d.ValidateNetwork(nil); err != ErrNetworkExists {
+		t.Fatalf("Expected %v, got %v", ErrNetworkExists, err)
+	}
+
+	if err := d.ValidateEndpoint("dummy", &EndpointConfiguration{AddressIPv4: net.ParseIP("10.10.10.10")}); err != ErrIPOutOfRange {
+		t.Fatalf("Expected %v, got %v", ErrIPOutOfRange, err)
+	}
+	if err := d.ValidateEndpoint("dummy", &EndpointConfiguration{EgressRate: "10"}); err != InvalidRateError("10") {
+		t.Fatalf("Expected %v, got %v", InvalidRateError("10"), err)
+	}
+
+	// The validated address is not reserved
+	reqIP := net.ParseIP("192.168.247.100")
+	if err := d.ValidateEndpoint("dummy", &EndpointConfiguration{AddressIPv4: reqIP}); err != nil {
+		t.Fatalf("Failed to validate the endpoint: %v", err)
+	}
+	if _, err := d.CreateEndpoint("dummy", "ep1", &EndpointConfiguration{AddressIPv4: reqIP}); err != nil {
+		t.Fatalf("Failed to create endpoint: %v", err)
+	}
+}
+
+type recordingIPAM struct {
+	*ipallocator.IPAllocator
+	requested []net.IP
+	released  []net.IP
+	pools     []*net.IPNet
+}
+
+func (r *recordingIPAM) RequestAddress(pool *net.IPNet, preferred net.IP) (net.IP, error) {
+	ip, err := r.IPAllocator.RequestAddress(pool, preferred)
+	if err == nil {
+		r.requested = append(r.requested, ip)
+	}
+	return ip, err
+}
+
+func (r *recordingIPAM) ReleaseAddress(pool *net.IPNet, ip net.IP) error {
+	r.released = append(r.released, ip)
+	return r.IPAllocator.ReleaseAddress(pool, ip)
+}
+
+func (r *recordingIPAM) ReleasePool(pool *net.IPNet) error {
+	r.pools = append(r.pools, pool)
+	return r.IPAllocator.ReleasePool(pool)
+}
+
+func TestCreateNetworkIPAM(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	_, subnet, _ := net.ParseCIDR("192.168.243.0/24")
+	config := &Configuration{
+		BridgeName:  DefaultBridgeName,
+		AddressIPv4: &net.IPNet{IP: net.ParseIP("192.168.243.1"), Mask: subnet.Mask},
+	}
+	if err := d.Config(config); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	ipam := &recordingIPAM{IPAllocator: ipallocator.New()}
+	if err := d.CreateNetwork("dummy", &NetworkConfiguration{IPAM: ipam}); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	sinfo, err := d.CreateEndpoint("dummy", "ep1", nil)
+	if err != nil {
+		t.Fatalf("Failed to create endpoint: %v", err)
+	}
+
+	// The bridge address is reserved first
+	ip := sinfo.Interfaces[0].Address.IP
+	if len(ipam.requested) != 2 || !ipam.requested[0].Equal(config.AddressIPv4.IP) || !ipam.requested[1].Equal(ip) {
+		t.Fatalf("Endpoint address %v was not allocated by the network IPAM: %v", ip, ipam.requested)
+	}
+
+	// The address must not be known to the driver default allocator
+	if _, err := ipAllocator.RequestIP(config.AddressIPv4, ip); err != nil {
+		t.Fatalf("Endpoint address was allocated by the default allocator: %v", err)
+	}
+	ipAllocator.ReleaseIP(config.AddressIPv4, ip)
+
+	if err := d.DeleteEndpoint("dummy", "ep1"); err != nil {
+		t.Fatalf("Failed to delete endpoint: %v", err)
+	}
+	if len(ipam.released) != 1 || !ipam.released[0].Equal(ip) {
+		t.Fatalf("Endpoint address %v was not released to the network IPAM: %v", ip, ipam.released)
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatalf("Failed to delete network: %v", err)
+	}
+	if len(ipam.pools) != 1 {
+		t.Fatalf("Expected the network pool to be released, got %v", ipam.pools)
+	}
+}
+
+func TestCreateNetworkInvalidIPAMOption(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	if err := d.Config(&Configuration{BridgeName: DefaultBridgeName}); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	if err := d.CreateNetwork("dummy", options.Generic{"Allocator": nil}); err == nil {
+		t.Fatal("Expected failure on an unknown network option")
+	}
+}
+
+func TestCreateEndpointMacAddress(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	ip, subnet, _ := net.ParseCIDR("192.168.244.1/24")
+	subnet.IP = ip
+	config := &Configuration{BridgeName: DefaultBridgeName, AddressIPv4: subnet}
+	if err := d.Config(config); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	if err := d.CreateNetwork("dummy", nil); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	// With no MAC requested, the MAC is derived from the IPv4 address
+	sinfo, err := d.CreateEndpoint("dummy", "ep1", nil)
+	if err != nil {
+		t.Fatalf("Failed to create endpoint: %v", err)
+	}
+	intf := sinfo.Interfaces[0]
+	if expected := netutils.GenerateMACFromIP(intf.Address.IP); !bytes.Equal(expected, intf.MacAddress) {
+		t.Fatalf("Expected MAC %s derived from %s, got %s", expected, intf.Address.IP, intf.MacAddress)
+	}
+
+	veth, err := netlink.LinkByName(intf.SrcName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(intf.MacAddress, veth.Attrs().HardwareAddr) {
+		t.Fatalf("MAC %s not programmed on the interface, got %s", intf.MacAddress, veth.Attrs().HardwareAddr)
+	}
+
+	for _, mac := range []net.HardwareAddr{
+		{0x01, 0x00, 0x5e, 0x00, 0x00, 0x01},
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		{0x02, 0x42, 0xac, 0x11},
+	} {
+		_, err := d.CreateEndpoint("dummy", "ep2", &EndpointConfiguration{MacAddress: mac})
+		if _, ok := err.(InvalidMacAddressError); !ok {
+			t.Fatalf("Expected InvalidMacAddressError for %s, got %v", mac, err)
+		}
+	}
+}
@@ -4,11 +4,22 @@ import (
 	"errors"
 	"fmt"
 	"net"
+
+	"github.com/docker/libnetwork/types"
 )
 
 var (
-	// ErrConfigExists error is returned when driver already has a config applied.
-	ErrConfigExists = errors.New("configuration already exists, bridge configuration can be applied only once")
+	// ErrDriverAlreadyConfigured error is returned when a configuration
+	// different from the one already applied is applied without override.
+	ErrDriverAlreadyConfigured = errors.New("bridge driver is already configured with different options")
+
+	// ErrConfigExists is the former name of ErrDriverAlreadyConfigured, kept
+	// for compatibility.
+	ErrConfigExists = ErrDriverAlreadyConfigured
+
+	// ErrInvalidDriverConfig error is returned when the driver configuration
+	// is neither generic options nor a Configuration.
+	ErrInvalidDriverConfig = errors.New("invalid configuration passed to the bridge driver")
 
 	// ErrInvalidConfig error is returned when a network is created on a driver without valid config.
 	ErrInvalidConfig = errors.New("trying to create a network on a driver without valid config")
@@ -31,10 +42,207 @@ var (
 	// ErrInvalidContainerSubnet is returned when the container subnet (FixedCIDR) is not valid.
 	ErrInvalidContainerSubnet = errors.New("container subnet must be a subset of bridge network")
 
-	// ErrInvalidMtu is returned when the user provided MTU is not valid
-	ErrInvalidMtu = errors.New("invalid MTU number")
+	// ErrIsolationNoIPTables is returned when network isolation is requested with iptables disabled.
+	ErrIsolationNoIPTables = errors.New("network isolation requires iptables to be enabled")
+
+	// ErrInternalNoIPTables is returned when an internal network is requested with iptables disabled.
+	ErrInternalNoIPTables = errors.New("internal network requires iptables to be enabled")
+
+	// ErrInternalGateway is returned when a default gateway is configured for an internal network.
+	ErrInternalGateway = errors.New("internal network can not have a default gateway")
+
+	// ErrIPv6Disabled is returned when IPv6 is requested but the kernel has IPv6 support disabled.
+	ErrIPv6Disabled = errors.New("IPv6 is requested but the kernel has IPv6 disabled")
+
+	// ErrIPAlreadyAllocated is returned when the address requested for an endpoint is already in use.
+	ErrIPAlreadyAllocated = errors.New("requested endpoint address is already in use")
+
+	// ErrIPOutOfRange is returned when the address requested for an endpoint is outside the network subnet.
+	ErrIPOutOfRange = errors.New("requested endpoint address is outside the network subnet")
+
+	// ErrInvalidPortRange is returned when the host port range is not within 1-65535 or ends before it begins.
+	ErrInvalidPortRange = errors.New("invalid host port range")
+
+	// ErrSubnetOverlap is returned when the subnet requested for a new bridge overlaps the subnet of a network already routed on the host.
+	ErrSubnetOverlap = errors.New("requested bridge subnet overlaps an existing network")
+
+	// ErrVlanNoParent is returned when a VLAN id is configured without the parent interface of the sub-interface.
+	ErrVlanNoParent = errors.New("a VLAN sub-interface requires a parent interface")
+
+	// ErrPortRangeExhausted is returned when a port binding requests no host port while all the ports of the range are allocated.
+	ErrPortRangeExhausted = errors.New("no free host port left in the port range")
 )
 
+// InvalidMtuError is returned when the user provided MTU of the bridge or of
+// an endpoint is out of the range the interfaces accept.
+type InvalidMtuError int
+
+func (mtu InvalidMtuError) Error() string {
+	return fmt.Sprintf("invalid MTU %d: must be within %d-%d", int(mtu), minMtu, maxMtu)
+}
+
+// InvalidParameter denotes the type of this error
+func (mtu InvalidMtuError) InvalidParameter() {}
+
+// InvalidVlanIDError is returned when the VLAN id of the sub-interface is out
+// of the 802.1q range.
+type InvalidVlanIDError int
+
+func (id InvalidVlanIDError) Error() string {
+	return fmt.Sprintf("invalid VLAN id %d: must be within %d-%d", int(id), minVlanID, maxVlanID)
+}
+
+// InvalidParameter denotes the type of this error
+func (id InvalidVlanIDError) InvalidParameter() {}
+
+// InvalidForwardDelayError is returned when the forward delay of the bridge
+// is out of the range the kernel accepts.
+type InvalidForwardDelayError int
+
+func (delay InvalidForwardDelayError) Error() string {
+	return fmt.Sprintf("invalid forward delay %ds: must be within %d-%d", int(delay), minForwardDelay, maxForwardDelay)
+}
+
+// InvalidParameter denotes the type of this error
+func (delay InvalidForwardDelayError) InvalidParameter() {}
+
+// InvalidAgingTimeError is returned when the MAC aging time of the bridge is
+// out of the 802.1D range.
+type InvalidAgingTimeError int
+
+func (aging InvalidAgingTimeError) Error() string {
+	return fmt.Sprintf("invalid aging time %ds: must be within %d-%d", int(aging), minAgingTime, maxAgingTime)
+}
+
+// InvalidParameter denotes the type of this error
+func (aging InvalidAgingTimeError) InvalidParameter() {}
+
+// VlanParentNotFoundError is returned when the parent interface of the VLAN
+// sub-interface can not be found.
+type VlanParentNotFoundError string
+
+func (name VlanParentNotFoundError) Error() string {
+	return fmt.Sprintf("parent interface %s of the VLAN sub-interface not found", string(name))
+}
+
+// NotFound denotes the type of this error
+func (name VlanParentNotFoundError) NotFound() {}
+
+// VlanConflictError is returned when the device named after the VLAN
+// sub-interface is not the sub-interface of the configured parent and id.
+type VlanConflictError struct {
+	Name   string
+	Parent string
+	ID     int
+}
+
+func (vce *VlanConflictError) Error() string {
+	return fmt.Sprintf("device %s is not the VLAN %d sub-interface of %s", vce.Name, vce.ID, vce.Parent)
+}
+
+// Forbidden denotes the type of this error
+func (vce *VlanConflictError) Forbidden() {}
+
+// IPv6AddressingConflictError is returned when router advertisements are
+// accepted along with the named static IPv6 setting.
+type IPv6AddressingConflictError string
+
+func (name IPv6AddressingConflictError) Error() string {
+	return fmt.Sprintf("IPv6 router advertisements can not be accepted along with the static %s setting", string(name))
+}
+
+// InvalidParameter denotes the type of this error
+func (name IPv6AddressingConflictError) InvalidParameter() {}
+
+// InvalidProtocolBindingError is returned when the port binding protocol is not valid.
+type InvalidProtocolBindingError string
+
+func (ipbe InvalidProtocolBindingError) Error() string {
+	return fmt.Sprintf("invalid transport protocol: %s", string(ipbe))
+}
+
+// ErrUnsupportedAddressType is returned when the specified address type is not supported.
+type ErrUnsupportedAddressType string
+
+func (uat ErrUnsupportedAddressType) Error() string {
+	return fmt.Sprintf("unsupported address type: %s", string(uat))
+}
+
+// InvalidMacAddressError is returned when the requested MAC address is not
+// a valid unicast Ethernet address.
+type InvalidMacAddressError string
+
+func (imae InvalidMacAddressError) Error() string {
+	return fmt.Sprintf("invalid MAC address %q: a 6 bytes unicast address is required", string(imae))
+}
+
+// InvalidRateError is returned when a bandwidth limit is not a positive
+// rate with a tc unit, such as 10mbit.
+type InvalidRateError string
+
+func (ire InvalidRateError) Error() string {
+	return fmt.Sprintf("invalid rate %q: a rate such as 10mbit is required", string(ire))
+}
+
+// TCUnavailableError is returned when bandwidth limits are requested but
+// the tc command can not be found.
+type TCUnavailableError struct {
+	err error
+}
+
+func (tue *TCUnavailableError) Error() string {
+	return fmt.Sprintf("bandwidth limits require the tc command: %v", tue.err)
+}
+
+// InvalidFirewallRuleError is returned when a firewall rule of an endpoint
+// can not be installed as passed.
+type InvalidFirewallRuleError struct {
+	Rule   types.FirewallRule
+	Reason string
+}
+
+func (ifre *InvalidFirewallRuleError) Error() string {
+	return fmt.Sprintf("invalid firewall rule %q: %s", ifre.Rule, ifre.Reason)
+}
+
+// InvalidParameter denotes the type of this error
+func (ifre *InvalidFirewallRuleError) InvalidParameter() {}
+
+// FirewallUnavailableError is returned when firewall rules are requested but
+// the iptables command can not be found.
+type FirewallUnavailableError struct {
+	err error
+}
+
+func (fue *FirewallUnavailableError) Error() string {
+	return fmt.Sprintf("firewall rules require the iptables command: %v", fue.err)
+}
+
+// Unavailable denotes the type of this error
+func (fue *FirewallUnavailableError) Unavailable() {}
+
+// PromiscModeError is returned when the bridge can not be put in
+// promiscuous mode.
+type PromiscModeError struct {
+	Name string
+	Err  error
+}
+
+func (pme *PromiscModeError) Error() string {
+	return fmt.Sprintf("failed to put bridge %s in promiscuous mode: %v", pme.Name, pme.Err)
+}
+
+// BridgeOptionsError is returned when the STP or the timers of the bridge
+// can not be set.
+type BridgeOptionsError struct {
+	Name string
+	Err  error
+}
+
+func (boe *BridgeOptionsError) Error() string {
+	return fmt.Sprintf("failed to set the options of bridge %s: %v", boe.Name, boe.Err)
+}
+
 // ActiveEndpointsError is returned when there are
 // still active endpoints in the network being deleted.
 type ActiveEndpointsError string
@@ -83,6 +291,54 @@ func (ndbee NonDefaultBridgeExistError) Error() string {
 	return fmt.Sprintf("bridge device with non default name %s must be created manually", string(ndbee))
 }
 
+// BridgeNotFoundError is returned when the network is configured to use an
+// existing bridge which can not be found.
+type BridgeNotFoundError string
+
+func (name BridgeNotFoundError) Error() string {
+	return fmt.Sprintf("existing bridge device %s not found", string(name))
+}
+
+// NotFound denotes the type of this error
+func (name BridgeNotFoundError) NotFound() {}
+
+// NotABridgeError is returned when the network is configured to use an
+// existing device which is not a bridge.
+type NotABridgeError string
+
+func (name NotABridgeError) Error() string {
+	return fmt.Sprintf("device %s is not a bridge", string(name))
+}
+
+// InvalidParameter denotes the type of this error
+func (name NotABridgeError) InvalidParameter() {}
+
+// AuxAddressOutOfRangeError is returned when an auxiliary address of a network
+// is outside the bridge subnet.
+type AuxAddressOutOfRangeError struct {
+	Name   string
+	IP     net.IP
+	Subnet *net.IPNet
+}
+
+func (aaoe *AuxAddressOutOfRangeError) Error() string {
+	return fmt.Sprintf("auxiliary address %s (%s) is outside the bridge subnet %s", aaoe.Name, aaoe.IP, aaoe.Subnet)
+}
+
+// InvalidParameter denotes the type of this error
+func (aaoe *AuxAddressOutOfRangeError) InvalidParameter() {}
+
+// ReservedAddressError is returned when the address requested for an endpoint
+// is reserved, for the name it holds.
+type ReservedAddressError string
+
+func (rae ReservedAddressError) Error() string {
+	return fmt.Sprintf("requested endpoint address is reserved for %s", string(rae))
+}
+
+// Forbidden denotes the type of this error
+func (rae ReservedAddressError) Forbidden() {}
+
 // FixedCIDRv4Error is returned when fixed-cidrv4 configuration
 // failed.
 type FixedCIDRv4Error struct {
@@ -0,0 +1,150 @@
+package bridge
+
+import (
+	"os/exec"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/iptables"
+	"github.com/docker/libnetwork/sandbox"
+	"github.com/docker/libnetwork/types"
+)
+
+const (
+	iptablesCmd = "iptables"
+
+	firewallAllow   = "allow"
+	firewallDeny    = "deny"
+	firewallIngress = "ingress"
+	firewallEgress  = "egress"
+)
+
+var firewallProtos = map[string]bool{"": true, "tcp": true, "udp": true, "icmp": true}
+
+// validateFirewallRules checks the firewall rules of the endpoint
+// configuration. The iptables command must be available to install them.
+func validateFirewallRules(epConfig *EndpointConfiguration) error {
+	if epConfig == nil || len(epConfig.FirewallRules) == 0 {
+		return nil
+	}
+
+	for _, r := range epConfig.FirewallRules {
+		if err := validateFirewallRule(r); err != nil {
+			return err
+		}
+	}
+
+	if _, err := exec.LookPath(iptablesCmd); err != nil {
+		return &FirewallUnavailableError{err: err}
+	}
+
+	return nil
+}
+
+func validateFirewallRule(r types.FirewallRule) error {
+	switch {
+	case r.Action != firewallAllow && r.Action != firewallDeny:
+		return &InvalidFirewallRuleError{Rule: r, Reason: "the action must be allow or deny"}
+	case r.Direction != firewallIngress && r.Direction != firewallEgress:
+		return &InvalidFirewallRuleError{Rule: r, Reason: "the direction must be ingress or egress"}
+	case !firewallProtos[r.Proto]:
+		return &InvalidFirewallRuleError{Rule: r, Reason: "the protocol must be tcp, udp, icmp or any"}
+	case r.Port < 0 || r.Port > 65535:
+		return &InvalidFirewallRuleError{Rule: r, Reason: "the port must be within 1-65535, or 0 for any"}
+	case r.Port != 0 && r.Proto != "tcp" && r.Proto != "udp":
+		return &InvalidFirewallRuleError{Rule: r, Reason: "a port requires the tcp or udp protocol"}
+	case r.CIDR != nil && r.CIDR.IP.To4() == nil:
+		return &InvalidFirewallRuleError{Rule: r, Reason: "the subnet must be an IPv4 one"}
+	}
+	if r.CIDR != nil {
+		if _, bits := r.CIDR.Mask.Size(); bits == 0 {
+			return &InvalidFirewallRuleError{Rule: r, Reason: "the subnet mask is invalid"}
+		}
+	}
+	return nil
+}
+
+// firewallRule returns the iptables rule of the sandbox matching the traffic
+// of the rule on the sandbox interface iface: the ingress traffic goes
+// through the INPUT chain, the egress one through the OUTPUT chain.
+func firewallRule(r types.FirewallRule, iface string) iptRule {
+	rule := iptRule{table: iptables.Filter, chain: "INPUT"}
+	ifaceFlag, cidrFlag := "-i", "-s"
+	if r.Direction == firewallEgress {
+		rule.chain = "OUTPUT"
+		ifaceFlag, cidrFlag = "-o", "-d"
+	}
+
+	rule.args = []string{ifaceFlag, iface}
+	if r.Proto != "" {
+		rule.args = append(rule.args, "-p", r.Proto)
+	}
+	if r.Port != 0 {
+		rule.args = append(rule.args, "--dport", strconv.Itoa(r.Port))
+	}
+	if r.CIDR != nil {
+		rule.args = append(rule.args, cidrFlag, r.CIDR.String())
+	}
+
+	target := "ACCEPT"
+	if r.Action == firewallDeny {
+		target = "DROP"
+	}
+	rule.args = append(rule.args, "-j", target)
+
+	return rule
+}
+
+// setupFirewall installs the firewall rules of the endpoint in the sandbox,
+// scoped to the endpoint interface and appended in order so that the first
+// one matching applies.
+func setupFirewall(sboxKey string, ep *bridgeEndpoint) error {
+	return sandbox.Invoke(sboxKey, func() error {
+		name, err := sandboxLink(ep.port.MacAddress)
+		if err != nil {
+			return err
+		}
+
+		for i, r := range ep.config.FirewallRules {
+			rule := firewallRule(r, name)
+			if err := programFirewallRule(rule, "-A"); err != nil {
+				// Leave none of the rules of the endpoint behind
+				for _, r := range ep.config.FirewallRules[:i] {
+					if e := programFirewallRule(firewallRule(r, name), "-D"); e != nil {
+						log.Warnf("Failed to remove firewall rule %s of endpoint %s: %v", r, ep.id, e)
+					}
+				}
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// teardownFirewall removes the firewall rules setupFirewall installed.
+func teardownFirewall(sboxKey string, ep *bridgeEndpoint) error {
+	return sandbox.Invoke(sboxKey, func() error {
+		name, err := sandboxLink(ep.port.MacAddress)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range ep.config.FirewallRules {
+			if e := programFirewallRule(firewallRule(r, name), "-D"); e != nil && err == nil {
+				err = e
+			}
+		}
+		return err
+	})
+}
+
+func programFirewallRule(rule iptRule, operation string) error {
+	output, err := iptables.Raw(append([]string{operation, rule.chain}, rule.args...)...)
+	if err != nil {
+		return err
+	}
+	if len(output) != 0 {
+		return &iptables.ChainError{Chain: rule.chain, Output: output}
+	}
+	return nil
+}
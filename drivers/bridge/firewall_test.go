@@ -0,0 +1,151 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/sandbox"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestValidateFirewallRule(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/8")
+	_, subnet6, _ := net.ParseCIDR("2001:db8::/64")
+
+	for _, r := range []types.FirewallRule{
+		{Action: "allow", Direction: "ingress"},
+		{Action: "deny", Direction: "egress", Proto: "icmp", CIDR: subnet},
+		{Action: "allow", Direction: "ingress", Proto: "tcp", Port: 80},
+		{Action: "deny", Direction: "egress", Proto: "udp", Port: 53, CIDR: subnet},
+	} {
+		if err := validateFirewallRule(r); err != nil {
+			t.Fatalf("Failed to validate rule %s: %v", r, err)
+		}
+	}
+
+	for _, r := range []types.FirewallRule{
+		{Action: "reject", Direction: "ingress"},
+		{Action: "allow", Direction: "both"},
+		{Action: "allow", Direction: "ingress", Proto: "sctp"},
+		{Action: "allow", Direction: "ingress", Proto: "tcp", Port: 65536},
+		{Action: "allow", Direction: "ingress", Proto: "tcp", Port: -1},
+		{Action: "allow", Direction: "ingress", Port: 80},
+		{Action: "allow", Direction: "ingress", Proto: "icmp", Port: 80},
+		{Action: "allow", Direction: "ingress", CIDR: subnet6},
+		{Action: "allow", Direction: "ingress", CIDR: &net.IPNet{IP: net.ParseIP("10.0.0.0")}},
+	} {
+		err := validateFirewallRule(r)
+		if _, ok := err.(*InvalidFirewallRuleError); !ok || !types.IsInvalidParameter(err) {
+			t.Fatalf("Expected an InvalidFirewallRuleError for %s, got %v", r, err)
+		}
+	}
+}
+
+func TestFirewallRuleArgs(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/8")
+
+	rule := firewallRule(types.FirewallRule{Action: "allow", Direction: "ingress", Proto: "tcp", Port: 80, CIDR: subnet}, "eth0")
+	if expected := []string{"-i", "eth0", "-p", "tcp", "--dport", "80", "-s", "10.0.0.0/8", "-j", "ACCEPT"}; rule.chain != "INPUT" || !reflect.DeepEqual(rule.args, expected) {
+		t.Fatalf("Expected %v in INPUT, got %v in %s", expected, rule.args, rule.chain)
+	}
+
+	rule = firewallRule(types.FirewallRule{Action: "deny", Direction: "egress", CIDR: subnet}, "eth0")
+	if expected := []string{"-o", "eth0", "-d", "10.0.0.0/8", "-j", "DROP"}; rule.chain != "OUTPUT" || !reflect.DeepEqual(rule.args, expected) {
+		t.Fatalf("Expected %v in OUTPUT, got %v in %s", expected, rule.args, rule.chain)
+	}
+}
+
+// iptablesSave dumps the filter table of the sandbox.
+func iptablesSave(t *testing.T, sboxKey string) string {
+	var out []byte
+	err := sandbox.Invoke(sboxKey, func() error {
+		var err error
+		out, err = exec.Command(iptablesCmd, "-S").CombinedOutput()
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to list the iptables rules: %v: %s", err, out)
+	}
+	return string(out)
+}
+
+func TestEndpointFirewall(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	if err := d.Config(&Configuration{BridgeName: DefaultBridgeName}); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+	if err := d.CreateNetwork("dummy", ""); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	invalid := &EndpointConfiguration{FirewallRules: []types.FirewallRule{{Action: "allow", Direction: "ingress", Port: 80}}}
+	if _, err := d.CreateEndpoint("dummy", "ep1", invalid); !types.IsInvalidParameter(err) {
+		t.Fatalf("Expected an InvalidFirewallRuleError, got %v", err)
+	}
+
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/8")
+	rules := []types.FirewallRule{
+		{Action: "allow", Direction: "ingress", Proto: "tcp", Port: 80},
+		{Action: "deny", Direction: "egress", CIDR: subnet},
+	}
+	sinfo, err := d.CreateEndpoint("dummy", "ep1", &EndpointConfiguration{FirewallRules: rules})
+	if _, e := exec.LookPath(iptablesCmd); e != nil {
+		if _, ok := err.(*FirewallUnavailableError); !ok {
+			t.Fatalf("Expected a FirewallUnavailableError, got %v", err)
+		}
+		t.Skip("iptables is not available")
+	}
+	if err != nil {
+		t.Fatalf("Failed to create the endpoint: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "firewall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sbox, err := sandbox.NewSandbox(filepath.Join(dir, "sbox"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbox.Destroy()
+
+	intf := sinfo.Interfaces[0].GetCopy()
+	intf.DstName = "eth1"
+	if err := sbox.AddInterface(intf.SrcName, intf.DstName, intf.Addresses()); err != nil {
+		t.Fatal(err)
+	}
+
+	jinfo, err := d.Join("dummy", "ep1", sbox.Key())
+	if err != nil {
+		t.Fatalf("Failed to join the endpoint: %v", err)
+	}
+	if len(jinfo.FirewallRules) != 2 || jinfo.FirewallRules[0].String() != rules[0].String() {
+		t.Fatalf("Unexpected effective rules %v", jinfo.FirewallRules)
+	}
+
+	// The rules are scoped to the sandbox interface
+	out := iptablesSave(t, sbox.Key())
+	for _, expected := range []string{"-A INPUT -i eth1 -p tcp -m tcp --dport 80 -j ACCEPT", "-A OUTPUT -d 10.0.0.0/8 -o eth1 -j DROP"} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("Rule %q not installed: %s", expected, out)
+		}
+	}
+
+	if err := d.Leave("dummy", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if out := iptablesSave(t, sbox.Key()); strings.Contains(out, "eth1") {
+		t.Fatalf("Rules left in the sandbox: %s", out)
+	}
+}
@@ -3,6 +3,8 @@ package bridge
 import (
 	"net"
 
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/netutils"
 	"github.com/vishvananda/netlink"
 )
 
@@ -19,6 +21,18 @@ type bridgeInterface struct {
 	bridgeIPv6  *net.IPNet
 	gatewayIPv4 net.IP
 	gatewayIPv6 net.IP
+	ipam        ipamapi.IPAM
+	pools       []*net.IPNet // pools requested from ipam by the setup steps
+	external    bool         // managed outside the driver, never deleted by it
+	promiscSet  bool         // put in promiscuous mode by the driver
+	vlanSet     bool         // attached to the VLAN sub-interface by the driver
+	vlanCreated bool         // VLAN sub-interface created by the driver
+	// savedOptions are the tunables of an existing bridge before the
+	// driver changed them, restored when the network goes away
+	savedOptions *netutils.BridgeOptions
+	// auxAddresses are the addresses reserved in the IPv4 pool, keyed by
+	// the name they are reserved for
+	auxAddresses map[string]net.IP
 }
 
 // newInterface creates a new bridge interface structure. It attempts to find
@@ -43,6 +57,56 @@ func (i *bridgeInterface) exists() bool {
 	return i.Link != nil
 }
 
+// checkExternal verifies that the bridge found by newInterface can be used
+// as the bridge managed outside the driver, and marks it as such.
+func (i *bridgeInterface) checkExternal(name string) error {
+	if !i.exists() {
+		return BridgeNotFoundError(name)
+	}
+	if _, ok := i.Link.(*netlink.Bridge); !ok {
+		return NotABridgeError(name)
+	}
+	i.external = true
+	return nil
+}
+
+// allocator returns the IPAM the network addresses are allocated from, the
+// default in-memory allocator unless the network was configured with another.
+func (i *bridgeInterface) allocator() ipamapi.IPAM {
+	if i.ipam == nil {
+		return ipAllocator
+	}
+	return i.ipam
+}
+
+// networkPools returns the address pools of the network: the ones the setup
+// steps requested, and the ones the endpoint addresses are allocated from,
+// which the first request registers.
+func (i *bridgeInterface) networkPools(config *Configuration) []*net.IPNet {
+	candidates := []*net.IPNet{i.bridgeIPv4}
+	if config.EnableIPv6 {
+		candidates = append(candidates, ipv6Pool(config, i))
+	}
+
+	pools := append([]*net.IPNet(nil), i.pools...)
+	for _, c := range candidates {
+		if c == nil {
+			continue
+		}
+		found := false
+		for _, pool := range pools {
+			if pool.String() == c.String() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			pools = append(pools, c)
+		}
+	}
+	return pools
+}
+
 // addresses returns a single IPv4 address and all IPv6 addresses for the
 // bridge interface.
 func (i *bridgeInterface) addresses() (netlink.Addr, []netlink.Addr, error) {
@@ -61,3 +125,21 @@ func (i *bridgeInterface) addresses() (netlink.Addr, []netlink.Addr, error) {
 	}
 	return v4addr[0], v6addr, nil
 }
+
+// reservedIPv4 tells whether ip is the network or broadcast address of the
+// bridge subnet, or one of its auxiliary addresses, and names the reservation.
+func (i *bridgeInterface) reservedIPv4(ip net.IP) (string, bool) {
+	first, last := netutils.NetworkRange(i.bridgeIPv4)
+	switch {
+	case ip.Equal(first):
+		return "network", true
+	case ip.Equal(last):
+		return "broadcast", true
+	}
+	for name, aux := range i.auxAddresses {
+		if ip.Equal(aux) {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,33 @@
+package bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/docker/libnetwork/sandbox"
+)
+
+// setupAcceptRA makes the sandbox side interface of the endpoint accept the
+// router advertisements and autoconfigure its address from them, or ignore
+// them when the addresses are static. It is called once the interface is in
+// the sandbox, as the interfaces moved across namespaces lose their settings.
+func setupAcceptRA(sboxKey string, ep *bridgeEndpoint, acceptRA bool) error {
+	value := "0"
+	if acceptRA {
+		value = "1"
+	}
+
+	return sandbox.Invoke(sboxKey, func() error {
+		name, err := sandboxLink(ep.port.MacAddress)
+		if err != nil {
+			return err
+		}
+		for _, param := range []string{"accept_ra", "autoconf"} {
+			path := fmt.Sprintf("/proc/sys/net/ipv6/conf/%s/%s", name, param)
+			if err := ioutil.WriteFile(path, []byte(value), 0644); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
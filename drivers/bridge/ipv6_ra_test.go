@@ -0,0 +1,89 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/sandbox"
+)
+
+// acceptRAIn returns the accept_ra setting of the endpoint interface once
+// joined to a sandbox, with the returned driver in the passed IPv6 mode.
+func acceptRAIn(t *testing.T, acceptRA bool) (driverapi.Driver, string) {
+	_, d := New()
+
+	config := &Configuration{BridgeName: DefaultBridgeName, EnableIPv6: true, IPv6AcceptRA: acceptRA}
+	if err := d.Config(config); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+	if err := d.CreateNetwork("dummy", ""); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	sinfo, err := d.CreateEndpoint("dummy", "ep1", nil)
+	if err != nil {
+		t.Fatalf("Failed to create the endpoint: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "acceptra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sbox, err := sandbox.NewSandbox(filepath.Join(dir, "sbox"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbox.Destroy()
+
+	intf := sinfo.Interfaces[0].GetCopy()
+	intf.DstName = "eth1"
+	if err := sbox.AddInterface(intf.SrcName, intf.DstName, intf.Addresses()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.Join("dummy", "ep1", sbox.Key()); err != nil {
+		t.Fatalf("Failed to join the endpoint: %v", err)
+	}
+	defer d.Leave("dummy", "ep1")
+
+	var b []byte
+	err = sandbox.Invoke(sbox.Key(), func() error {
+		var err error
+		b, err = ioutil.ReadFile("/proc/sys/net/ipv6/conf/eth1/accept_ra")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, strings.TrimSpace(string(b))
+}
+
+func TestEndpointStaticIPv6(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	if _, v := acceptRAIn(t, false); v != "0" {
+		t.Fatalf("Expected router advertisements to be ignored, got accept_ra %s", v)
+	}
+}
+
+func TestEndpointAcceptRA(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	d, v := acceptRAIn(t, true)
+	if v != "1" {
+		t.Fatalf("Expected router advertisements to be accepted, got accept_ra %s", v)
+	}
+
+	// The addresses are not static
+	epConfig := &EndpointConfiguration{AddressIPv6: net.ParseIP("fe80::42")}
+	if _, err := d.CreateEndpoint("dummy", "ep2", epConfig); err != IPv6AddressingConflictError("AddressIPv6") {
+		t.Fatalf("Expected %v, got %v", IPv6AddressingConflictError("AddressIPv6"), err)
+	}
+}
@@ -1,10 +1,16 @@
 package bridge
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
 
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
 	"github.com/vishvananda/netlink"
 )
 
@@ -196,3 +202,82 @@ func TestLinkDelete(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestLinkCreateMtu(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+	dr := d.(*driver)
+
+	config := &Configuration{BridgeName: DefaultBridgeName, Mtu: 9000}
+	if err := d.Config(config); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	if err := d.CreateNetwork("dummy", ""); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	if _, err := d.CreateEndpoint("dummy", "ep1", &EndpointConfiguration{Mtu: 10}); err != InvalidMtuError(10) {
+		t.Fatalf("Expected %v, got %v", InvalidMtuError(10), err)
+	}
+
+	// Both ends of the veth pair inherit the bridge MTU, unless overridden
+	for eid, mtu := range map[string]int{"ep1": 9000, "ep2": 1400} {
+		var epConfig *EndpointConfiguration
+		if mtu != config.Mtu {
+			epConfig = &EndpointConfiguration{Mtu: mtu}
+		}
+		sinfo, err := d.CreateEndpoint("dummy", types.UUID(eid), epConfig)
+		if err != nil {
+			t.Fatalf("Failed to create a link: %v", err)
+		}
+
+		ep, _ := dr.network.getEndpoint(types.UUID(eid))
+		for _, name := range []string{sinfo.Interfaces[0].SrcName, ep.hostVeth} {
+			lnk, err := netlink.LinkByName(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lnk.Attrs().MTU != mtu {
+				t.Fatalf("Expected MTU %d on %s, got %d", mtu, name, lnk.Attrs().MTU)
+			}
+		}
+	}
+}
+
+func TestLinkCreateHairpin(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+	dr := d.(*driver)
+
+	config := &Configuration{BridgeName: DefaultBridgeName, EnableHairpinMode: true}
+	if err := d.Config(config); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+	if err := d.CreateNetwork("dummy", ""); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	if _, err := d.CreateEndpoint("dummy", "ep1", nil); err != nil {
+		t.Fatalf("Failed to create a link: %v", err)
+	}
+	ep, _ := dr.network.getEndpoint("ep1")
+
+	// A sysfs mounted from the test namespace lists its interfaces
+	dir, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := syscall.Mount("sysfs", dir, "sysfs", 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Unmount(dir, 0)
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "class/net", ep.hostVeth, "brport/hairpin_mode"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := strings.TrimSpace(string(b)); mode != "1" {
+		t.Fatalf("Expected hairpin mode on %s, got %s", ep.hostVeth, mode)
+	}
+}
@@ -0,0 +1,111 @@
+package bridge
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/pkg/portallocator"
+	"github.com/docker/libnetwork/sandbox"
+	"github.com/docker/libnetwork/types"
+)
+
+var defaultBindingIP = net.IPv4(0, 0, 0, 0)
+
+// allocatePorts maps the port bindings of the endpoint, tagging their rules
+// with the endpoint id.
+func allocatePorts(eid types.UUID, epConfig *EndpointConfiguration, intf *sandbox.Interface, useProxy bool) ([]types.PortBinding, error) {
+	if epConfig == nil || epConfig.PortBindings == nil {
+		return nil, nil
+	}
+
+	var bs []types.PortBinding
+	for _, c := range epConfig.PortBindings {
+		b := c.GetCopy()
+		if err := allocatePort(eid, &b, intf.Address.IP, useProxy); err != nil {
+			// On allocation failure, release previously allocated ports. On cleanup error, just log a warning message
+			if cuErr := releasePortsInternal(bs); cuErr != nil {
+				log.Warnf("Upon allocation failure for %v, failed to clear previously allocated port bindings: %v", b, cuErr)
+			}
+			return nil, err
+		}
+		bs = append(bs, b)
+	}
+
+	return bs, nil
+}
+
+func allocatePort(eid types.UUID, bnd *types.PortBinding, containerIP net.IP, useProxy bool) error {
+	// Default host IP to 0.0.0.0 if not specified
+	if len(bnd.HostIP) == 0 {
+		bnd.HostIP = defaultBindingIP
+	}
+
+	container, err := portAddr(bnd.Proto, containerIP, bnd.ContainerPort)
+	if err != nil {
+		return err
+	}
+
+	// A zero HostPort lets the port mapper pick a free port in the host port range
+	host, err := portMapper.MapTagged(container, bnd.HostIP, bnd.HostPort, useProxy, string(eid))
+	if err == portallocator.ErrAllPortsAllocated {
+		return ErrPortRangeExhausted
+	}
+	if err != nil {
+		return err
+	}
+
+	// Save the effective host port
+	switch netAddr := host.(type) {
+	case *net.TCPAddr:
+		bnd.HostPort = netAddr.Port
+	case *net.UDPAddr:
+		bnd.HostPort = netAddr.Port
+	default:
+		// For completeness
+		return ErrUnsupportedAddressType(fmt.Sprintf("%T", netAddr))
+	}
+
+	return nil
+}
+
+func releasePorts(ep *bridgeEndpoint) error {
+	return releasePortsInternal(ep.portMapping)
+}
+
+func releasePortsInternal(bindings []types.PortBinding) error {
+	var errorBuf bytes.Buffer
+
+	// Attempt to release all port bindings, do not stop on failure
+	for _, m := range bindings {
+		if err := releasePort(m); err != nil {
+			errorBuf.WriteString(fmt.Sprintf("\ncould not release %v because of %v", m, err))
+		}
+	}
+
+	if errorBuf.Len() != 0 {
+		return errors.New(errorBuf.String())
+	}
+	return nil
+}
+
+func releasePort(bnd types.PortBinding) error {
+	host, err := portAddr(bnd.Proto, bnd.HostIP, bnd.HostPort)
+	if err != nil {
+		return err
+	}
+	return portMapper.Unmap(host)
+}
+
+func portAddr(proto types.Protocol, ip net.IP, port int) (net.Addr, error) {
+	switch proto {
+	case types.TCP:
+		return &net.TCPAddr{IP: ip, Port: port}, nil
+	case types.UDP:
+		return &net.UDPAddr{IP: ip, Port: port}, nil
+	default:
+		return nil, InvalidProtocolBindingError(proto.String())
+	}
+}
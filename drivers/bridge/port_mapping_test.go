@@ -0,0 +1,156 @@
+package bridge
+
+import (
+	"os"
+	"testing"
+
+	"github.com/docker/docker/pkg/reexec"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestMain(m *testing.M) {
+	if reexec.Init() {
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func TestPortMappingConfig(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	config := &Configuration{
+		BridgeName:     DefaultBridgeName,
+		EnableIPTables: false,
+	}
+	if err := d.Config(config); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	binding1 := types.PortBinding{Proto: types.UDP, ContainerPort: 400, HostPort: 54000}
+	binding2 := types.PortBinding{Proto: types.TCP, ContainerPort: 500}
+	portBindings := []types.PortBinding{binding1, binding2}
+	epConfig := &EndpointConfiguration{PortBindings: portBindings}
+
+	if err := d.CreateNetwork("dummy", ""); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	if _, err := d.CreateEndpoint("dummy", "ep1", epConfig); err != nil {
+		t.Fatalf("Failed to create the endpoint: %s", err.Error())
+	}
+
+	jinfo, err := d.Join("dummy", "ep1", "sbox")
+	if err != nil {
+		t.Fatalf("Failed to join the endpoint: %v", err)
+	}
+
+	if len(jinfo.PortBindings) != 2 {
+		t.Fatalf("Expected 2 port bindings. Got: %v", jinfo.PortBindings)
+	}
+
+	if jinfo.PortBindings[0].HostPort != 54000 {
+		t.Fatalf("Expected requested host port 54000. Got: %d", jinfo.PortBindings[0].HostPort)
+	}
+
+	if jinfo.PortBindings[1].HostPort == 0 {
+		t.Fatalf("Expected a dynamically allocated host port. Got: %v", jinfo.PortBindings[1])
+	}
+
+	if err := d.Leave("dummy", "ep1"); err != nil {
+		t.Fatalf("Failed to leave the endpoint: %v", err)
+	}
+
+	// The host ports must be free again once the sandbox leaves
+	if _, err := d.Join("dummy", "ep1", "sbox"); err != nil {
+		t.Fatalf("Failed to rejoin the endpoint: %v", err)
+	}
+
+	if err := d.DeleteEndpoint("dummy", "ep1"); err != nil {
+		t.Fatalf("Failed to delete the endpoint: %v", err)
+	}
+
+	if _, err := portMapper.Allocator.RequestPort(defaultBindingIP, "udp", 54000); err != nil {
+		t.Fatalf("Host port was not released on endpoint deletion: %v", err)
+	}
+	portMapper.Allocator.ReleasePort(defaultBindingIP, "udp", 54000)
+}
+
+func TestPortMappingRange(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	config := &Configuration{BridgeName: DefaultBridgeName, HostPortRangeStart: 60001, HostPortRangeEnd: 60002}
+	if err := d.Config(config); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	if err := d.CreateNetwork("dummy", ""); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	bindings := []types.PortBinding{
+		{Proto: types.TCP, ContainerPort: 500},
+		{Proto: types.TCP, ContainerPort: 501},
+	}
+	if _, err := d.CreateEndpoint("dummy", "ep1", &EndpointConfiguration{PortBindings: bindings}); err != nil {
+		t.Fatalf("Failed to create the endpoint: %v", err)
+	}
+	binding := []types.PortBinding{{Proto: types.TCP, ContainerPort: 502}}
+	if _, err := d.CreateEndpoint("dummy", "ep2", &EndpointConfiguration{PortBindings: binding}); err != nil {
+		t.Fatalf("Failed to create the endpoint: %v", err)
+	}
+
+	jinfo, err := d.Join("dummy", "ep1", "sbox1")
+	if err != nil {
+		t.Fatalf("Failed to join the endpoint: %v", err)
+	}
+	for _, b := range jinfo.PortBindings {
+		if b.HostPort < 60001 || b.HostPort > 60002 {
+			t.Fatalf("Host port %d allocated out of the range", b.HostPort)
+		}
+	}
+
+	// The range is full
+	if _, err := d.Join("dummy", "ep2", "sbox2"); err != ErrPortRangeExhausted {
+		t.Fatalf("Expected %v, got %v", ErrPortRangeExhausted, err)
+	}
+
+	if err := d.Leave("dummy", "ep1"); err != nil {
+		t.Fatalf("Failed to leave the endpoint: %v", err)
+	}
+	if _, err := d.Join("dummy", "ep2", "sbox2"); err != nil {
+		t.Fatalf("Failed to join the endpoint once the ports were released: %v", err)
+	}
+	if err := d.Leave("dummy", "ep2"); err != nil {
+		t.Fatalf("Failed to leave the endpoint: %v", err)
+	}
+}
+
+func TestPortMappingInvalidProtocol(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	config := &Configuration{BridgeName: DefaultBridgeName}
+	if err := d.Config(config); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	binding := types.PortBinding{Proto: types.Protocol(111), ContainerPort: 400}
+	epConfig := &EndpointConfiguration{PortBindings: []types.PortBinding{binding}}
+
+	if err := d.CreateNetwork("dummy", ""); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	if _, err := d.CreateEndpoint("dummy", "ep1", epConfig); err != nil {
+		t.Fatalf("Failed to create the endpoint: %s", err.Error())
+	}
+
+	if _, err := d.Join("dummy", "ep1", "sbox"); err == nil {
+		t.Fatalf("Expected failure on joining with an invalid port binding protocol")
+	} else if _, ok := err.(InvalidProtocolBindingError); !ok {
+		t.Fatalf("Unexpected error type: %v", err)
+	}
+}
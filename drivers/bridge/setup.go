@@ -1,5 +1,7 @@
 package bridge
 
+import "context"
+
 type setupStep func(*Configuration, *bridgeInterface) error
 
 type bridgeSetup struct {
@@ -12,8 +14,13 @@ func newBridgeSetup(c *Configuration, i *bridgeInterface) *bridgeSetup {
 	return &bridgeSetup{config: c, bridge: i}
 }
 
-func (b *bridgeSetup) apply() error {
+// apply runs the queued steps in order, stopping at the first error or once
+// the context is done.
+func (b *bridgeSetup) apply(ctx context.Context) error {
 	for _, fn := range b.steps {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if err := fn(b.config, b.bridge); err != nil {
 			return err
 		}
@@ -1,6 +1,8 @@
 package bridge
 
 import (
+	"time"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/parsers/kernel"
 	"github.com/docker/libnetwork/netutils"
@@ -15,10 +17,11 @@ func setupDevice(config *Configuration, i *bridgeInterface) error {
 		return NonDefaultBridgeExistError(config.BridgeName)
 	}
 
-	// Set the bridgeInterface netlink.Bridge.
+	// Set the bridgeInterface netlink.Bridge, with the requested MTU if any.
 	i.Link = &netlink.Bridge{
 		LinkAttrs: netlink.LinkAttrs{
 			Name: config.BridgeName,
+			MTU:  config.Mtu,
 		},
 	}
 
@@ -48,3 +51,81 @@ func setupDeviceUp(config *Configuration, i *bridgeInterface) error {
 	}
 	return nil
 }
+
+// setupPromisc puts the bridge in promiscuous mode, remembering whether it
+// already was so that the network deletion leaves the operator setting.
+func setupPromisc(config *Configuration, i *bridgeInterface) error {
+	on, err := netutils.Promisc(config.BridgeName)
+	if err != nil {
+		return &PromiscModeError{Name: config.BridgeName, Err: err}
+	}
+	if on {
+		return nil
+	}
+	if err := netutils.SetPromisc(config.BridgeName, true); err != nil {
+		return &PromiscModeError{Name: config.BridgeName, Err: err}
+	}
+	i.promiscSet = true
+	return nil
+}
+
+// teardownPromisc takes the bridge out of the promiscuous mode setupPromisc
+// put it in.
+func teardownPromisc(config *Configuration, i *bridgeInterface) error {
+	if !i.promiscSet {
+		return nil
+	}
+	if err := netutils.SetPromisc(config.BridgeName, false); err != nil {
+		return err
+	}
+	i.promiscSet = false
+	return nil
+}
+
+// hasBridgeOptions tells whether the configuration tunes the bridge STP or
+// timers.
+func (c *Configuration) hasBridgeOptions() bool {
+	return c.EnableSTP || c.ForwardDelay != 0 || c.AgingTime != 0
+}
+
+// setupBridgeOptions applies the STP and the timers of the configuration to
+// the bridge, the other ones keeping their value, and remembers the previous
+// ones so that the network deletion restores them on an existing bridge.
+func setupBridgeOptions(config *Configuration, i *bridgeInterface) error {
+	saved, err := netutils.GetBridgeOptions(config.BridgeName)
+	if err != nil {
+		return &BridgeOptionsError{Name: config.BridgeName, Err: err}
+	}
+
+	opts := *saved
+	if config.EnableSTP {
+		opts.STP = true
+	}
+	if config.ForwardDelay != 0 {
+		opts.ForwardDelay = time.Duration(config.ForwardDelay) * time.Second
+	}
+	if config.AgingTime != 0 {
+		opts.AgingTime = time.Duration(config.AgingTime) * time.Second
+	}
+	if opts == *saved {
+		return nil
+	}
+	if err := netutils.SetBridgeOptions(config.BridgeName, &opts); err != nil {
+		return &BridgeOptionsError{Name: config.BridgeName, Err: err}
+	}
+	i.savedOptions = saved
+	return nil
+}
+
+// teardownBridgeOptions restores the STP and the timers the bridge had before
+// setupBridgeOptions.
+func teardownBridgeOptions(config *Configuration, i *bridgeInterface) error {
+	if i.savedOptions == nil {
+		return nil
+	}
+	if err := netutils.SetBridgeOptions(config.BridgeName, i.savedOptions); err != nil {
+		return err
+	}
+	i.savedOptions = nil
+	return nil
+}
@@ -2,8 +2,14 @@ package bridge
 
 import (
 	"bytes"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/docker/libnetwork/netutils"
 	"github.com/vishvananda/netlink"
@@ -29,6 +35,25 @@ func TestSetupNewBridge(t *testing.T) {
 	}
 }
 
+func TestSetupNewBridgeMtu(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	config := &Configuration{BridgeName: DefaultBridgeName, Mtu: 1400}
+	br := &bridgeInterface{}
+
+	if err := setupDevice(config, br); err != nil {
+		t.Fatalf("Bridge creation failed: %v", err)
+	}
+
+	lnk, err := netlink.LinkByName(DefaultBridgeName)
+	if err != nil {
+		t.Fatalf("Failed to retrieve bridge device: %v", err)
+	}
+	if lnk.Attrs().MTU != config.Mtu {
+		t.Fatalf("Expected bridge MTU %d, got %d", config.Mtu, lnk.Attrs().MTU)
+	}
+}
+
 func TestSetupNewNonDefaultBridge(t *testing.T) {
 	defer netutils.SetupTestNetNS(t)()
 
@@ -73,3 +98,66 @@ func TestGenerateRandomMAC(t *testing.T) {
 		t.Fatalf("Generated twice the same MAC address %v", mac1)
 	}
 }
+
+func TestValidateBridgeOptions(t *testing.T) {
+	for _, delay := range []int{-1, 1, 31} {
+		if err := (&Configuration{ForwardDelay: delay}).Validate(); err != InvalidForwardDelayError(delay) {
+			t.Fatalf("Expected %v, got %v", InvalidForwardDelayError(delay), err)
+		}
+	}
+	for _, aging := range []int{-1, 9, 1000001} {
+		if err := (&Configuration{AgingTime: aging}).Validate(); err != InvalidAgingTimeError(aging) {
+			t.Fatalf("Expected %v, got %v", InvalidAgingTimeError(aging), err)
+		}
+	}
+
+	config := &Configuration{EnableSTP: true, ForwardDelay: 30, AgingTime: 10}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Failed to validate the bridge options: %v", err)
+	}
+}
+
+func TestSetupBridgeOptions(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	config := &Configuration{BridgeName: DefaultBridgeName, EnableSTP: true, ForwardDelay: 4, AgingTime: 600}
+	br := &bridgeInterface{}
+
+	if err := setupDevice(config, br); err != nil {
+		t.Fatalf("Bridge creation failed: %v", err)
+	}
+	if err := setupBridgeOptions(config, br); err != nil {
+		t.Fatalf("Failed to set the bridge options: %v", err)
+	}
+
+	opts, err := netutils.GetBridgeOptions(DefaultBridgeName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := netutils.BridgeOptions{STP: true, ForwardDelay: 4 * time.Second, AgingTime: 600 * time.Second}
+	if *opts != expected {
+		t.Fatalf("Expected bridge options %+v, got %+v", expected, *opts)
+	}
+
+	// A sysfs mounted from the test namespace lists its interfaces
+	dir, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := syscall.Mount("sysfs", dir, "sysfs", 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Unmount(dir, 0)
+
+	// The sysfs reports the timers in hundredths of a second
+	for file, value := range map[string]string{"stp_state": "1", "forward_delay": "400", "ageing_time": "60000"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, "class/net", DefaultBridgeName, "bridge", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := strings.TrimSpace(string(b)); v != value {
+			t.Fatalf("Expected %s %s, got %s", file, value, v)
+		}
+	}
+}
@@ -9,9 +9,10 @@ func setupFixedCIDRv4(config *Configuration, i *bridgeInterface) error {
 	}
 
 	log.Debugf("Using IPv4 subnet: %v", config.FixedCIDR)
-	if err := ipAllocator.RegisterSubnet(addrv4.IPNet, config.FixedCIDR); err != nil {
+	if err := i.allocator().RequestPool(addrv4.IPNet, config.FixedCIDR); err != nil {
 		return &FixedCIDRv4Error{subnet: config.FixedCIDR, net: addrv4.IPNet, err: err}
 	}
+	i.pools = append(i.pools, addrv4.IPNet)
 
 	return nil
 }
@@ -4,9 +4,10 @@ import log "github.com/Sirupsen/logrus"
 
 func setupFixedCIDRv6(config *Configuration, i *bridgeInterface) error {
 	log.Debugf("Using IPv6 subnet: %v", config.FixedCIDRv6)
-	if err := ipAllocator.RegisterSubnet(config.FixedCIDRv6, config.FixedCIDRv6); err != nil {
+	if err := i.allocator().RequestPool(config.FixedCIDRv6, nil); err != nil {
 		return &FixedCIDRv6Error{net: config.FixedCIDRv6, err: err}
 	}
+	i.pools = append(i.pools, config.FixedCIDRv6)
 
 	return nil
 }
@@ -3,7 +3,9 @@ package bridge
 import (
 	"fmt"
 	"net"
+	"sync"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/iptables"
 	"github.com/docker/libnetwork/netutils"
 )
@@ -13,6 +15,13 @@ const (
 	DockerChain = "DOCKER"
 )
 
+// The bridges with iptables programmed by any driver instance, and whether
+// each asked to be isolated from the other bridge networks.
+var (
+	activeBridges   = map[string]bool{}
+	activeBridgesMu sync.Mutex
+)
+
 func setupIPTables(config *Configuration, i *bridgeInterface) error {
 	// Sanity check.
 	if config.EnableIPTables == false {
@@ -23,7 +32,7 @@ func setupIPTables(config *Configuration, i *bridgeInterface) error {
 	if err != nil {
 		return fmt.Errorf("Failed to setup IP tables, cannot acquire Interface address: %s", err.Error())
 	}
-	if err = setupIPTablesInternal(config.BridgeName, addrv4, config.EnableICC, config.EnableIPMasquerade, true); err != nil {
+	if err = setupIPTablesInternal(config.BridgeName, addrv4, config.EnableICC, config.EnableIPMasquerade, config.Internal, true); err != nil {
 		return fmt.Errorf("Failed to Setup IP tables: %s", err.Error())
 	}
 
@@ -39,9 +48,96 @@ func setupIPTables(config *Configuration, i *bridgeInterface) error {
 
 	portMapper.SetIptablesChain(chain)
 
+	// Remove the port publishing rules a previous instance left behind when
+	// it did not shut down cleanly. The chain is shared, so the rules of the
+	// endpoints with live mappings, on any bridge network, are kept.
+	if err := portMapper.PruneStale(); err != nil {
+		log.Warnf("Failed to remove the stale port publishing rules: %v", err)
+	}
+
+	if err := setupIsolation(config.BridgeName, config.EnableNetworkIsolation); err != nil {
+		return fmt.Errorf("Failed to isolate bridge network: %s", err.Error())
+	}
+
 	return nil
 }
 
+// teardownIPTables removes the rules setupIPTables programmed for the bridge.
+func teardownIPTables(config *Configuration, i *bridgeInterface) error {
+	if err := teardownIsolation(config.BridgeName); err != nil {
+		return err
+	}
+
+	return setupIPTablesInternal(config.BridgeName, i.bridgeIPv4, config.EnableICC, config.EnableIPMasquerade, config.Internal, false)
+}
+
+// isolationRules returns the rules dropping the traffic forwarded between
+// the bridge and each of the other bridges.
+func isolationRules(bridgeIface string, others []string) []iptRule {
+	var rules []iptRule
+	for _, other := range others {
+		rules = append(rules,
+			iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", bridgeIface, "-o", other, "-j", "DROP"}},
+			iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", other, "-o", bridgeIface, "-j", "DROP"}})
+	}
+	return rules
+}
+
+// isolatedPeers returns the active bridges the traffic of the bridge must
+// be isolated from: all of them when it asked for isolation, otherwise those
+// which asked for it themselves.
+func isolatedPeers(bridgeIface string, isolate bool) []string {
+	var peers []string
+	for other, otherIsolate := range activeBridges {
+		if other != bridgeIface && (isolate || otherIsolate) {
+			peers = append(peers, other)
+		}
+	}
+	return peers
+}
+
+func setupIsolation(bridgeIface string, isolate bool) error {
+	activeBridgesMu.Lock()
+	defer activeBridgesMu.Unlock()
+
+	for _, rule := range isolationRules(bridgeIface, isolatedPeers(bridgeIface, isolate)) {
+		if err := programChainRule(rule, "NETWORK ISOLATION", true); err != nil {
+			return err
+		}
+	}
+	activeBridges[bridgeIface] = isolate
+
+	return nil
+}
+
+func teardownIsolation(bridgeIface string) error {
+	activeBridgesMu.Lock()
+	defer activeBridgesMu.Unlock()
+
+	isolate, ok := activeBridges[bridgeIface]
+	if !ok {
+		return nil
+	}
+
+	for _, rule := range isolationRules(bridgeIface, isolatedPeers(bridgeIface, isolate)) {
+		if err := programChainRule(rule, "NETWORK ISOLATION", false); err != nil {
+			return err
+		}
+	}
+	delete(activeBridges, bridgeIface)
+
+	return nil
+}
+
+// setupHairpinNAT programs, or removes, the masquerading of the traffic of
+// the endpoint address to itself, which the bridge sends back in hairpin
+// mode once its published ports translate the destination.
+func setupHairpinNAT(ip net.IP, enable bool) error {
+	address := ip.String() + "/32"
+	rule := iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: []string{"-s", address, "-d", address, "-j", "MASQUERADE"}}
+	return programChainRule(rule, "HAIRPIN NAT", enable)
+}
+
 type iptRule struct {
 	table   iptables.Table
 	chain   string
@@ -49,7 +145,17 @@ type iptRule struct {
 	args    []string
 }
 
-func setupIPTablesInternal(bridgeIface string, addr net.Addr, icc, ipmasq, enable bool) error {
+// internalRules returns the rules dropping the traffic forwarded from the
+// bridge to the other interfaces and back, which cuts an internal network
+// from the outside.
+func internalRules(bridgeIface string) []iptRule {
+	return []iptRule{
+		{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", bridgeIface, "!", "-o", bridgeIface, "-j", "DROP"}},
+		{table: iptables.Filter, chain: "FORWARD", args: []string{"!", "-i", bridgeIface, "-o", bridgeIface, "-j", "DROP"}},
+	}
+}
+
+func setupIPTablesInternal(bridgeIface string, addr net.Addr, icc, ipmasq, internal, enable bool) error {
 
 	var (
 		address = addr.String()
@@ -58,6 +164,17 @@ func setupIPTablesInternal(bridgeIface string, addr net.Addr, icc, ipmasq, enabl
 		inRule  = iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-o", bridgeIface, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}}
 	)
 
+	// An internal network is neither masqueraded nor forwarded to the
+	// outside, only the traffic between its containers is.
+	if internal {
+		for _, rule := range internalRules(bridgeIface) {
+			if err := programChainRule(rule, "INTERNAL NETWORK", enable); err != nil {
+				return err
+			}
+		}
+		return setIcc(bridgeIface, icc, enable)
+	}
+
 	// Set NAT.
 	if ipmasq {
 		if err := programChainRule(natRule, "NAT", enable); err != nil {
@@ -101,3 +101,34 @@ func assertBridgeConfig(config *Configuration, br *bridgeInterface, t *testing.T
 		t.Fatalf("%v", err)
 	}
 }
+
+func TestIsolatedPeers(t *testing.T) {
+	activeBridgesMu.Lock()
+	saved := activeBridges
+	activeBridges = map[string]bool{"br0": false, "br1": true, "br2": false}
+	defer func() {
+		activeBridges = saved
+		activeBridgesMu.Unlock()
+	}()
+
+	// An isolated bridge is isolated from all the others
+	if peers := isolatedPeers("br3", true); len(peers) != 3 {
+		t.Fatalf("Expected 3 isolated peers, got %v", peers)
+	}
+
+	// Other bridges are only isolated from those which asked for it
+	peers := isolatedPeers("br0", false)
+	if len(peers) != 1 || peers[0] != "br1" {
+		t.Fatalf("Expected br1 as the only isolated peer, got %v", peers)
+	}
+
+	rules := isolationRules("br0", peers)
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 isolation rules, got %d", len(rules))
+	}
+	for _, r := range rules {
+		if r.chain != "FORWARD" || r.args[len(r.args)-1] != "DROP" {
+			t.Fatalf("Unexpected isolation rule %v", r)
+		}
+	}
+}
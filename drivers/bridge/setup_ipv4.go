@@ -4,6 +4,7 @@ import (
 	"net"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/ipamapi"
 	"github.com/docker/libnetwork/netutils"
 	"github.com/vishvananda/netlink"
 )
@@ -88,7 +89,7 @@ func setupGatewayIPv4(config *Configuration, i *bridgeInterface) error {
 	if !i.bridgeIPv4.Contains(config.DefaultGatewayIPv4) {
 		return ErrInvalidGateway
 	}
-	if _, err := ipAllocator.RequestIP(i.bridgeIPv4, config.DefaultGatewayIPv4); err != nil {
+	if _, err := i.allocator().RequestAddress(i.bridgeIPv4, config.DefaultGatewayIPv4); err != nil {
 		return err
 	}
 
@@ -97,3 +98,42 @@ func setupGatewayIPv4(config *Configuration, i *bridgeInterface) error {
 
 	return nil
 }
+
+// setupReserveBridgeIPv4 reserves the bridge address in the IPv4 pool, so that
+// it is not allocated to an endpoint. It is already reserved when it is also
+// the default gateway, and needs not be when outside the fixed CIDR.
+func setupReserveBridgeIPv4(config *Configuration, i *bridgeInterface) error {
+	_, err := i.allocator().RequestAddress(i.bridgeIPv4, i.bridgeIPv4.IP)
+	switch err {
+	case nil:
+		// The request registers the pool if no step did, it must be
+		// released should a later step fail
+		for _, pool := range i.pools {
+			if pool.String() == i.bridgeIPv4.String() {
+				return nil
+			}
+		}
+		i.pools = append(i.pools, i.bridgeIPv4)
+		return nil
+	case ipamapi.ErrIPAlreadyAllocated, ipamapi.ErrIPOutOfRange:
+		return nil
+	}
+	return err
+}
+
+// setupAuxAddresses reserves the auxiliary addresses in the IPv4 pool. Those
+// outside the fixed CIDR are never allocated, and need not be reserved.
+func setupAuxAddresses(config *Configuration, i *bridgeInterface) error {
+	for name, ip := range i.auxAddresses {
+		if !i.bridgeIPv4.Contains(ip) {
+			return &AuxAddressOutOfRangeError{Name: name, IP: ip, Subnet: i.bridgeIPv4}
+		}
+		_, err := i.allocator().RequestAddress(i.bridgeIPv4, ip)
+		switch err {
+		case nil, ipamapi.ErrIPAlreadyAllocated, ipamapi.ErrIPOutOfRange:
+		default:
+			return err
+		}
+	}
+	return nil
+}
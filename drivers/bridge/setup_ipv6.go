@@ -4,11 +4,17 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"os"
 
 	"github.com/vishvananda/netlink"
 )
 
-var bridgeIPv6 *net.IPNet
+var (
+	bridgeIPv6 *net.IPNet
+
+	// ipv6ProcDir is only present when the kernel supports IPv6.
+	ipv6ProcDir = "/proc/sys/net/ipv6"
+)
 
 const bridgeIPv6Str = "fe80::1/64"
 
@@ -23,8 +29,16 @@ func init() {
 }
 
 func setupBridgeIPv6(config *Configuration, i *bridgeInterface) error {
+	// Make sure the kernel was not booted with IPv6 disabled
+	if _, err := os.Stat(ipv6ProcDir); err != nil {
+		if os.IsNotExist(err) {
+			return ErrIPv6Disabled
+		}
+		return err
+	}
+
 	// Enable IPv6 on the bridge
-	procFile := "/proc/sys/net/ipv6/conf/" + config.BridgeName + "/disable_ipv6"
+	procFile := ipv6ProcDir + "/conf/" + config.BridgeName + "/disable_ipv6"
 	if err := ioutil.WriteFile(procFile, []byte{'0', '\n'}, 0644); err != nil {
 		return fmt.Errorf("Unable to enable IPv6 addresses on bridge: %v", err)
 	}
@@ -54,7 +68,7 @@ func setupGatewayIPv6(config *Configuration, i *bridgeInterface) error {
 	if !config.FixedCIDRv6.Contains(config.DefaultGatewayIPv6) {
 		return ErrInvalidGateway
 	}
-	if _, err := ipAllocator.RequestIP(config.FixedCIDRv6, config.DefaultGatewayIPv6); err != nil {
+	if _, err := i.allocator().RequestAddress(config.FixedCIDRv6, config.DefaultGatewayIPv6); err != nil {
 		return err
 	}
 
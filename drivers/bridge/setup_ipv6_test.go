@@ -68,3 +68,15 @@ func TestSetupGatewayIPv6(t *testing.T) {
 		t.Fatalf("Set Default Gateway failed. Expected %v, Found %v", gw, br.gatewayIPv6)
 	}
 }
+
+func TestSetupIPv6KernelDisabled(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	defer func(dir string) { ipv6ProcDir = dir }(ipv6ProcDir)
+	ipv6ProcDir = "/proc/sys/net/nonexistent"
+
+	config, br := setupTestInterface(t)
+	if err := setupBridgeIPv6(config, br); err != ErrIPv6Disabled {
+		t.Fatalf("Expected ErrIPv6Disabled, got %v", err)
+	}
+}
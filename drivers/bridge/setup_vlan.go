@@ -0,0 +1,78 @@
+package bridge
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+)
+
+// vlanName returns the name of the VLAN sub-interface of the configuration.
+func vlanName(config *Configuration) string {
+	return fmt.Sprintf("%s.%d", config.VlanParent, config.VlanID)
+}
+
+// setupVlan attaches the bridge to the VLAN sub-interface of the parent
+// interface, creating the sub-interface unless it exists, and brings the
+// sub-interface up.
+func setupVlan(config *Configuration, i *bridgeInterface) error {
+	parent, err := netlink.LinkByName(config.VlanParent)
+	if err != nil {
+		return VlanParentNotFoundError(config.VlanParent)
+	}
+
+	bridge, err := netlink.LinkByName(config.BridgeName)
+	if err != nil {
+		return err
+	}
+
+	name := vlanName(config)
+	link, err := netlink.LinkByName(name)
+	if err == nil {
+		// A sub-interface managed outside the driver is used as it is
+		vlan, ok := link.(*netlink.Vlan)
+		if !ok || vlan.ParentIndex != parent.Attrs().Index || vlan.VlanId != config.VlanID {
+			return &VlanConflictError{Name: name, Parent: config.VlanParent, ID: config.VlanID}
+		}
+	} else {
+		link = &netlink.Vlan{
+			LinkAttrs: netlink.LinkAttrs{Name: name, ParentIndex: parent.Attrs().Index},
+			VlanId:    config.VlanID,
+		}
+		if err := netlink.LinkAdd(link); err != nil {
+			return err
+		}
+		i.vlanCreated = true
+	}
+
+	if err := netlink.LinkSetMasterByIndex(link, bridge.Attrs().Index); err != nil {
+		return err
+	}
+	i.vlanSet = true
+
+	return netlink.LinkSetUp(link)
+}
+
+// teardownVlan deletes the VLAN sub-interface setupVlan created, or detaches
+// the one it found from the bridge.
+func teardownVlan(config *Configuration, i *bridgeInterface) error {
+	if !i.vlanSet && !i.vlanCreated {
+		return nil
+	}
+
+	link, err := netlink.LinkByName(vlanName(config))
+	if err != nil {
+		return err
+	}
+
+	if i.vlanCreated {
+		err = netlink.LinkDel(link)
+	} else {
+		err = netlink.LinkSetMasterByIndex(link, 0)
+	}
+	if err != nil {
+		return err
+	}
+
+	i.vlanSet, i.vlanCreated = false, false
+	return nil
+}
@@ -0,0 +1,151 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/docker/libnetwork/netutils"
+	"github.com/vishvananda/netlink"
+)
+
+const vlanParent = "vlanparent0"
+
+// setupVlanParent creates the parent interface of the VLAN sub-interfaces of
+// the tests, which are skipped if the kernel does not support them.
+func setupVlanParent(t *testing.T) netlink.Link {
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: vlanParent}, PeerName: vlanParent + "p"}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatalf("Failed to create the parent interface: %v", err)
+	}
+	parent, err := netlink.LinkByName(vlanParent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	probe := &netlink.Vlan{LinkAttrs: netlink.LinkAttrs{Name: vlanParent + ".1", ParentIndex: parent.Attrs().Index}, VlanId: 1}
+	if err := netlink.LinkAdd(probe); err != nil {
+		t.Skipf("VLAN sub-interfaces are not supported: %v", err)
+	}
+	if err := netlink.LinkDel(probe); err != nil {
+		t.Fatal(err)
+	}
+
+	return parent
+}
+
+// verifyVlan checks that the VLAN sub-interface of the configuration is
+// attached to the bridge.
+func verifyVlan(t *testing.T, config *Configuration, parent netlink.Link) {
+	link, err := netlink.LinkByName(vlanName(config))
+	if err != nil {
+		t.Fatalf("VLAN sub-interface %s not found: %v", vlanName(config), err)
+	}
+	vlan, ok := link.(*netlink.Vlan)
+	if !ok || vlan.VlanId != config.VlanID || vlan.ParentIndex != parent.Attrs().Index {
+		t.Fatalf("Unexpected VLAN sub-interface %#v", link)
+	}
+
+	bridge, err := netlink.LinkByName(config.BridgeName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vlan.MasterIndex != bridge.Attrs().Index {
+		t.Fatalf("VLAN sub-interface %s not attached to bridge %s", vlanName(config), config.BridgeName)
+	}
+}
+
+func TestValidateVlan(t *testing.T) {
+	for _, id := range []int{0, -1, 4095} {
+		config := &Configuration{VlanParent: "eth0", VlanID: id}
+		if err := config.Validate(); err != InvalidVlanIDError(id) {
+			t.Fatalf("Expected %v, got %v", InvalidVlanIDError(id), err)
+		}
+	}
+
+	if err := (&Configuration{VlanID: 100}).Validate(); err != ErrVlanNoParent {
+		t.Fatalf("Expected %v, got %v", ErrVlanNoParent, err)
+	}
+
+	for _, id := range []int{1, 4094} {
+		if err := (&Configuration{VlanParent: "eth0", VlanID: id}).Validate(); err != nil {
+			t.Fatalf("Failed to validate VLAN id %d: %v", id, err)
+		}
+	}
+}
+
+func TestSetupVlan(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	parent := setupVlanParent(t)
+
+	config := &Configuration{BridgeName: DefaultBridgeName, VlanParent: "nonexistent0", VlanID: 100}
+	br := &bridgeInterface{}
+	if err := setupDevice(config, br); err != nil {
+		t.Fatalf("Bridge creation failed: %v", err)
+	}
+	if err := setupVlan(config, br); err != VlanParentNotFoundError("nonexistent0") {
+		t.Fatalf("Expected %v, got %v", VlanParentNotFoundError("nonexistent0"), err)
+	}
+
+	// The sub-interface created by the driver is deleted by it
+	config.VlanParent = vlanParent
+	if err := setupVlan(config, br); err != nil {
+		t.Fatalf("Failed to setup the VLAN sub-interface: %v", err)
+	}
+	verifyVlan(t, config, parent)
+	if !br.vlanCreated {
+		t.Fatal("VLAN sub-interface not recorded as created by the driver")
+	}
+
+	if err := teardownVlan(config, br); err != nil {
+		t.Fatalf("Failed to teardown the VLAN sub-interface: %v", err)
+	}
+	if _, err := netlink.LinkByName(vlanName(config)); err == nil {
+		t.Fatalf("VLAN sub-interface %s left behind", vlanName(config))
+	}
+}
+
+func TestSetupExistingVlan(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	parent := setupVlanParent(t)
+
+	config := &Configuration{BridgeName: DefaultBridgeName, VlanParent: vlanParent, VlanID: 200}
+	br := &bridgeInterface{}
+	if err := setupDevice(config, br); err != nil {
+		t.Fatalf("Bridge creation failed: %v", err)
+	}
+
+	// A device of the same name which is not the sub-interface is rejected
+	dummy := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: vlanName(config)}, PeerName: "vlanpeer0"}
+	if err := netlink.LinkAdd(dummy); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := setupVlan(config, br).(*VlanConflictError); !ok {
+		t.Fatalf("Expected a VlanConflictError")
+	}
+	if err := netlink.LinkDel(dummy); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sub-interface managed outside the driver is left in place
+	vlan := &netlink.Vlan{LinkAttrs: netlink.LinkAttrs{Name: vlanName(config), ParentIndex: parent.Attrs().Index}, VlanId: config.VlanID}
+	if err := netlink.LinkAdd(vlan); err != nil {
+		t.Fatal(err)
+	}
+	if err := setupVlan(config, br); err != nil {
+		t.Fatalf("Failed to setup the VLAN sub-interface: %v", err)
+	}
+	verifyVlan(t, config, parent)
+	if br.vlanCreated {
+		t.Fatal("Existing VLAN sub-interface recorded as created by the driver")
+	}
+
+	if err := teardownVlan(config, br); err != nil {
+		t.Fatalf("Failed to teardown the VLAN sub-interface: %v", err)
+	}
+	link, err := netlink.LinkByName(vlanName(config))
+	if err != nil {
+		t.Fatalf("Existing VLAN sub-interface %s removed: %v", vlanName(config), err)
+	}
+	if link.Attrs().MasterIndex != 0 {
+		t.Fatalf("VLAN sub-interface %s still attached to the bridge", vlanName(config))
+	}
+}
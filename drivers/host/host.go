@@ -0,0 +1,94 @@
+package host
+
+import (
+	"context"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/sandbox"
+	"github.com/docker/libnetwork/types"
+)
+
+const networkType = "host"
+
+type driver struct{}
+
+// New provides a new instance of host driver
+func New() (string, driverapi.Driver) {
+	return networkType, &driver{}
+}
+
+func (d *driver) Config(option interface{}) error {
+	return nil
+}
+
+func (d *driver) ConfigRequired() bool {
+	return false
+}
+
+func (d *driver) CreateNetwork(id types.UUID, option interface{}) error {
+	return d.CreateNetworkWithContext(context.Background(), id, option)
+}
+
+func (d *driver) CreateNetworkWithContext(ctx context.Context, id types.UUID, option interface{}) error {
+	return ctx.Err()
+}
+
+func (d *driver) ValidateNetwork(option interface{}) error {
+	return nil
+}
+
+func (d *driver) UpdateNetwork(nid types.UUID, option interface{}) error {
+	return nil
+}
+
+func (d *driver) DeleteNetwork(nid types.UUID) error {
+	return nil
+}
+
+func (d *driver) NetworkInfo(nid types.UUID) (*driverapi.NetworkInfo, error) {
+	return &driverapi.NetworkInfo{}, nil
+}
+
+// CreateEndpoint does not allocate any address nor interface: containers
+// joining a host network share the host network namespace as is.
+func (d *driver) CreateEndpoint(nid, eid types.UUID, epOptions interface{}) (*sandbox.Info, error) {
+	return d.CreateEndpointWithContext(context.Background(), nid, eid, epOptions)
+}
+
+func (d *driver) CreateEndpointWithContext(ctx context.Context, nid, eid types.UUID, epOptions interface{}) (*sandbox.Info, error) {
+	return nil, ctx.Err()
+}
+
+func (d *driver) ValidateEndpoint(nid types.UUID, epOptions interface{}) error {
+	return nil
+}
+
+func (d *driver) DeleteEndpoint(nid, eid types.UUID) error {
+	return nil
+}
+
+func (d *driver) Join(nid, eid types.UUID, sboxKey string) (*driverapi.JoinInfo, error) {
+	return nil, nil
+}
+
+func (d *driver) Leave(nid, eid types.UUID) error {
+	return nil
+}
+
+// RestoreNetwork is a no-op, the driver keeps no state.
+func (d *driver) RestoreNetwork(nid types.UUID) error {
+	return nil
+}
+
+// RestoreEndpoint is a no-op, the driver keeps no state.
+func (d *driver) RestoreEndpoint(nid, eid types.UUID, sinfo *sandbox.Info) error {
+	return nil
+}
+
+func (d *driver) Type() string {
+	return networkType
+}
+
+func (d *driver) Capabilities() driverapi.DriverCapability {
+	return driverapi.DriverCapability{}
+}
@@ -0,0 +1,80 @@
+package macvlan
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNetworkExists error is returned when a network is created with the id of an existing one.
+	ErrNetworkExists = errors.New("network already exists")
+
+	// ErrNoParent error is returned when a network is created without a parent interface.
+	ErrNoParent = errors.New("a parent interface is required")
+
+	// ErrNoSubnet error is returned when a network is created without a subnet.
+	ErrNoSubnet = errors.New("a subnet is required")
+
+	// ErrInvalidGateway is returned when the user provided gateway is not part of the subnet.
+	ErrInvalidGateway = errors.New("gateway ip must be part of the network")
+
+	// ErrInvalidEndpointConfig error is returned when a endpoint create is attempted with an invalid endpoint configuration.
+	ErrInvalidEndpointConfig = errors.New("trying to create an endpoint with an invalid endpoint configuration")
+
+	// ErrIfaceName error is returned when a new name could not be generated.
+	ErrIfaceName = errors.New("failed to find name for new interface")
+
+	// ErrIPOutOfRange is returned when the address requested for an endpoint is outside the network subnets.
+	ErrIPOutOfRange = errors.New("requested endpoint address is outside the network subnets")
+)
+
+// SubnetOverlapError is returned when a subnet of a network overlaps another
+// subnet of the network.
+type SubnetOverlapError string
+
+func (soe SubnetOverlapError) Error() string {
+	return fmt.Sprintf("subnet %s overlaps another subnet of the network", string(soe))
+}
+
+// ParentNotFoundError is returned when the parent interface of a network
+// does not exist on the host.
+type ParentNotFoundError string
+
+func (pnfe ParentNotFoundError) Error() string {
+	return fmt.Sprintf("parent interface %s not found", string(pnfe))
+}
+
+// InvalidModeError is returned when the requested mode is not supported by
+// the network type.
+type InvalidModeError string
+
+func (ime InvalidModeError) Error() string {
+	return fmt.Sprintf("invalid mode: %s", string(ime))
+}
+
+// PromiscModeError is returned when the parent interface of a network can not
+// be put in promiscuous mode.
+type PromiscModeError struct {
+	Name string
+	Err  error
+}
+
+func (pme *PromiscModeError) Error() string {
+	return fmt.Sprintf("failed to put parent interface %s in promiscuous mode: %v", pme.Name, pme.Err)
+}
+
+// ActiveEndpointsError is returned when there are
+// still active endpoints in the network being deleted.
+type ActiveEndpointsError string
+
+func (aee ActiveEndpointsError) Error() string {
+	return fmt.Sprintf("network %s has active endpoint", string(aee))
+}
+
+// EndpointNotFoundError is returned when the no endpoint
+// with the passed endpoint id is found.
+type EndpointNotFoundError string
+
+func (enfe EndpointNotFoundError) Error() string {
+	return fmt.Sprintf("endpoint not found: %s", string(enfe))
+}
@@ -0,0 +1,99 @@
+package macvlan
+
+import (
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+const (
+	modeBridge  = "bridge"
+	modeVEPA    = "vepa"
+	modePrivate = "private"
+	modeL2      = "l2"
+	modeL3      = "l3"
+
+	// Kernel values of the macvlan modes
+	macvlanModePrivate = 1
+	macvlanModeVEPA    = 2
+	macvlanModeBridge  = 4
+
+	iflaMacvlanMode = 1
+)
+
+// linkKind creates the sub interfaces of a parent interface for a network
+// type.
+type linkKind struct {
+	networkType string
+	prefix      string
+	// modes supported by the sub interfaces, the first one being used when
+	// the network options do not set one.
+	modes []string
+	// add creates the sub interface named name in the passed mode.
+	add func(name string, parent netlink.Link, mode string) error
+	// ownMac tells whether the sub interfaces have their own MAC address
+	// rather than sharing the parent one.
+	ownMac bool
+}
+
+var macvlanKind = &linkKind{
+	networkType: "macvlan",
+	prefix:      "macv",
+	modes:       []string{modeBridge, modeVEPA, modePrivate},
+	add:         addMacvlan,
+	ownMac:      true,
+}
+
+var ipvlanKind = &linkKind{
+	networkType: "ipvlan",
+	prefix:      "ipvl",
+	modes:       []string{modeL2, modeL3},
+	add:         addIPVlan,
+}
+
+var macvlanModes = map[string]uint32{
+	modeBridge:  macvlanModeBridge,
+	modeVEPA:    macvlanModeVEPA,
+	modePrivate: macvlanModePrivate,
+}
+
+var ipvlanModes = map[string]netlink.IPVlanMode{
+	modeL2: netlink.IPVLAN_MODE_L2,
+	modeL3: netlink.IPVLAN_MODE_L3,
+}
+
+// validMode tells whether the network type supports mode.
+func (k *linkKind) validMode(mode string) bool {
+	for _, m := range k.modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// addMacvlan creates a macvlan interface. The netlink package does not set
+// the macvlan mode, the request is built here instead.
+func addMacvlan(name string, parent netlink.Link, mode string) error {
+	req := nl.NewNetlinkRequest(syscall.RTM_NEWLINK, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+	req.AddData(nl.NewIfInfomsg(syscall.AF_UNSPEC))
+	req.AddData(nl.NewRtAttr(syscall.IFLA_LINK, nl.Uint32Attr(uint32(parent.Attrs().Index))))
+	req.AddData(nl.NewRtAttr(syscall.IFLA_IFNAME, nl.ZeroTerminated(name)))
+
+	linkInfo := nl.NewRtAttr(syscall.IFLA_LINKINFO, nil)
+	nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_KIND, nl.NonZeroTerminated("macvlan"))
+	data := nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_DATA, nil)
+	nl.NewRtAttrChild(data, iflaMacvlanMode, nl.Uint32Attr(macvlanModes[mode]))
+	req.AddData(linkInfo)
+
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
+func addIPVlan(name string, parent netlink.Link, mode string) error {
+	return netlink.LinkAdd(&netlink.IPVlan{
+		LinkAttrs: netlink.LinkAttrs{Name: name, ParentIndex: parent.Attrs().Index},
+		Mode:      ipvlanModes[mode],
+	})
+}
@@ -0,0 +1,689 @@
+package macvlan
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ipallocator"
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/pkg/options"
+	"github.com/docker/libnetwork/sandbox"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	ifaceLen      = 7
+	containerVeth = "eth0"
+)
+
+var ipAllocator *ipallocator.IPAllocator // Default IPAM for the networks
+
+// NetworkConfiguration represents the user specified configuration for the
+// macvlan and ipvlan networks
+type NetworkConfiguration struct {
+	// Parent is the name of the host interface the endpoints are attached
+	// to.
+	Parent string
+	// Mode of the sub interfaces: bridge (default), vepa or private for
+	// macvlan, l2 (default) or l3 for ipvlan.
+	Mode string
+	// Subnet of the physical network the endpoints addresses are allocated
+	// from.
+	Subnet *net.IPNet
+	// SecondarySubnets of the physical network the endpoints addresses are
+	// allocated from once Subnet is exhausted, each in turn. The subnets
+	// must not overlap. Gateway belonging to Subnet, the endpoints of the
+	// secondary subnets get no gateway.
+	SecondarySubnets []*net.IPNet
+	// Gateway of the physical network, if any. It is not used in the ipvlan
+	// l3 mode where the parent interface routes the traffic.
+	Gateway net.IP
+	// EnablePromiscuous puts the parent interface in promiscuous mode while
+	// the network exists, such as for the endpoints to receive the frames
+	// a passthrough setup sends to other MAC addresses. A parent the
+	// operator already put in promiscuous mode is left so.
+	EnablePromiscuous bool
+}
+
+// EndpointConfiguration represents the user specified configuration for the sandbox endpoint
+type EndpointConfiguration struct {
+	MacAddress  net.HardwareAddr
+	AddressIPv4 net.IP
+}
+
+type subEndpoint struct {
+	id   types.UUID
+	port *sandbox.Interface
+}
+
+type subNetwork struct {
+	id        types.UUID
+	config    *NetworkConfiguration
+	endpoints map[types.UUID]*subEndpoint
+	// promiscSet is set on the network which put the parent interface in
+	// promiscuous mode, to be disabled once no network requests it
+	promiscSet bool
+	sync.Mutex
+}
+
+type driver struct {
+	kind     *linkKind
+	networks map[types.UUID]*subNetwork
+	sync.Mutex
+}
+
+func init() {
+	ipAllocator = ipallocator.New()
+}
+
+// New provides a new instance of macvlan driver
+func New() (string, driverapi.Driver) {
+	return newDriver(macvlanKind)
+}
+
+// NewIPVlan provides a new instance of ipvlan driver
+func NewIPVlan() (string, driverapi.Driver) {
+	return newDriver(ipvlanKind)
+}
+
+func newDriver(kind *linkKind) (string, driverapi.Driver) {
+	return kind.networkType, &driver{kind: kind, networks: make(map[types.UUID]*subNetwork)}
+}
+
+// Config is a no-op, the networks carry all their settings.
+func (d *driver) Config(option interface{}) error {
+	return nil
+}
+
+func (d *driver) ConfigRequired() bool {
+	return false
+}
+
+func (d *driver) CreateNetwork(id types.UUID, option interface{}) error {
+	return d.CreateNetworkWithContext(context.Background(), id, option)
+}
+
+func (d *driver) CreateNetworkWithContext(ctx context.Context, id types.UUID, option interface{}) error {
+	config, err := parseNetworkOptions(option)
+	if err != nil {
+		return err
+	}
+
+	if err := d.validate(config); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	if _, ok := d.networks[id]; ok {
+		return ErrNetworkExists
+	}
+
+	subnets := config.subnets()
+	for i, subnet := range subnets {
+		if err := ipAllocator.RequestPool(subnet, nil); err != nil {
+			releasePools(subnets[:i])
+			return err
+		}
+	}
+
+	// The gateway belongs to the physical network
+	if config.Gateway != nil {
+		if _, err := ipAllocator.RequestAddress(config.Subnet, config.Gateway); err != nil {
+			releasePools(subnets)
+			return err
+		}
+	}
+
+	n := &subNetwork{id: id, config: config, endpoints: make(map[types.UUID]*subEndpoint)}
+	if config.EnablePromiscuous {
+		if n.promiscSet, err = setupPromisc(config.Parent); err != nil {
+			releasePools(subnets)
+			return err
+		}
+	}
+	d.networks[id] = n
+
+	return nil
+}
+
+// subnets returns the subnets of the network, in allocation order.
+func (c *NetworkConfiguration) subnets() []*net.IPNet {
+	return append([]*net.IPNet{c.Subnet}, c.SecondarySubnets...)
+}
+
+// subnet returns the subnet of the network ip belongs to, nil if none.
+func (c *NetworkConfiguration) subnet(ip net.IP) *net.IPNet {
+	for _, subnet := range c.subnets() {
+		if subnet.Contains(ip) {
+			return subnet
+		}
+	}
+	return nil
+}
+
+// releasePools releases the pools of the subnets from the allocator.
+func releasePools(subnets []*net.IPNet) {
+	for _, subnet := range subnets {
+		if err := ipAllocator.ReleasePool(subnet); err != nil {
+			log.Warnf("Failed to release the pool of subnet %s: %v", subnet, err)
+		}
+	}
+}
+
+// setupPromisc puts the parent interface in promiscuous mode, telling whether
+// it was not already.
+func setupPromisc(parent string) (bool, error) {
+	on, err := netutils.Promisc(parent)
+	if err != nil {
+		return false, &PromiscModeError{Name: parent, Err: err}
+	}
+	if on {
+		return false, nil
+	}
+	if err := netutils.SetPromisc(parent, true); err != nil {
+		return false, &PromiscModeError{Name: parent, Err: err}
+	}
+	return true, nil
+}
+
+// teardownPromisc takes the parent interface of the network being deleted
+// out of the promiscuous mode it put it in, unless another network of the
+// parent requests the mode, which then takes it over. It must be called with
+// the driver lock held.
+func (d *driver) teardownPromisc(n *subNetwork) error {
+	if !n.promiscSet {
+		return nil
+	}
+	parent := n.getConfig().Parent
+	for _, other := range d.networks {
+		if other != n && other.getConfig().Parent == parent && other.getConfig().EnablePromiscuous {
+			other.Lock()
+			other.promiscSet = true
+			other.Unlock()
+			return nil
+		}
+	}
+	return netutils.SetPromisc(parent, false)
+}
+
+// ValidateNetwork checks the network options, and that the parent interface
+// exists.
+func (d *driver) ValidateNetwork(option interface{}) error {
+	config, err := parseNetworkOptions(option)
+	if err != nil {
+		return err
+	}
+	return d.validate(config)
+}
+
+// validate checks the network configuration, defaulting the mode, and
+// normalizes the subnet.
+func (d *driver) validate(config *NetworkConfiguration) error {
+	if config.Parent == "" {
+		return ErrNoParent
+	}
+	if _, err := netlink.LinkByName(config.Parent); err != nil {
+		return ParentNotFoundError(config.Parent)
+	}
+
+	if config.Mode == "" {
+		config.Mode = d.kind.modes[0]
+	}
+	if !d.kind.validMode(config.Mode) {
+		return InvalidModeError(config.Mode)
+	}
+
+	if config.Subnet == nil {
+		return ErrNoSubnet
+	}
+	config.Subnet = &net.IPNet{IP: config.Subnet.IP.Mask(config.Subnet.Mask), Mask: config.Subnet.Mask}
+
+	for i, subnet := range config.SecondarySubnets {
+		if subnet == nil {
+			return ErrNoSubnet
+		}
+		config.SecondarySubnets[i] = &net.IPNet{IP: subnet.IP.Mask(subnet.Mask), Mask: subnet.Mask}
+	}
+	subnets := config.subnets()
+	for i, subnet := range subnets {
+		for _, other := range subnets[:i] {
+			if netutils.NetworkOverlaps(subnet, other) {
+				return SubnetOverlapError(subnet.String())
+			}
+		}
+	}
+
+	if config.Gateway != nil && !config.Subnet.Contains(config.Gateway) {
+		return ErrInvalidGateway
+	}
+
+	return nil
+}
+
+// UpdateNetwork moves the gateway of the network, which applies to the
+// endpoints created afterwards. The other options are fixed at the network
+// creation.
+func (d *driver) UpdateNetwork(nid types.UUID, option interface{}) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	defer n.Unlock()
+
+	upd, _, err := options.UpdateModel(option, n.config)
+	if err != nil {
+		return err
+	}
+
+	// Compare the settings as normalized at the network creation
+	config := upd.(*NetworkConfiguration)
+	if config.Mode == "" {
+		config.Mode = d.kind.modes[0]
+	}
+	if config.Subnet != nil {
+		config.Subnet = &net.IPNet{IP: config.Subnet.IP.Mask(config.Subnet.Mask), Mask: config.Subnet.Mask}
+	}
+	for i, subnet := range config.SecondarySubnets {
+		if subnet != nil {
+			config.SecondarySubnets[i] = &net.IPNet{IP: subnet.IP.Mask(subnet.Mask), Mask: subnet.Mask}
+		}
+	}
+	_, changed, err := options.UpdateModel(config, n.config)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range changed {
+		if field != "Gateway" {
+			return driverapi.ImmutableOptionError(field)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	if config.Gateway != nil {
+		if !config.Subnet.Contains(config.Gateway) {
+			return ErrInvalidGateway
+		}
+		if _, err := ipAllocator.RequestAddress(config.Subnet, config.Gateway); err != nil {
+			return err
+		}
+	}
+	if n.config.Gateway != nil {
+		if err := ipAllocator.ReleaseAddress(config.Subnet, n.config.Gateway); err != nil {
+			log.Warnf("Failed to release the previous gateway of network %s: %v", nid, err)
+		}
+	}
+	n.config = config
+
+	return nil
+}
+
+func (d *driver) DeleteNetwork(nid types.UUID) error {
+	d.Lock()
+	defer d.Unlock()
+
+	n, ok := d.networks[nid]
+	if !ok {
+		return driverapi.ErrNoNetwork
+	}
+
+	// Cannot remove network if endpoints are still present
+	n.Lock()
+	numEps := len(n.endpoints)
+	n.Unlock()
+	if numEps != 0 {
+		return ActiveEndpointsError(nid)
+	}
+
+	delete(d.networks, nid)
+
+	if err := d.teardownPromisc(n); err != nil {
+		log.Warnf("Failed to restore the promiscuous mode of the parent of network %s: %v", nid, err)
+	}
+
+	releasePools(n.getConfig().subnets())
+
+	return nil
+}
+
+func (d *driver) NetworkInfo(nid types.UUID) (*driverapi.NetworkInfo, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	config := n.getConfig()
+	info := &driverapi.NetworkInfo{Gateway: netutils.GetIPCopy(config.Gateway)}
+	for _, subnet := range config.subnets() {
+		info.Subnets = append(info.Subnets, netutils.GetIPNetCopy(subnet))
+	}
+	return info, nil
+}
+
+func (d *driver) CreateEndpoint(nid, eid types.UUID, epOptions interface{}) (*sandbox.Info, error) {
+	return d.CreateEndpointWithContext(context.Background(), nid, eid, epOptions)
+}
+
+func (d *driver) CreateEndpointWithContext(ctx context.Context, nid, eid types.UUID, epOptions interface{}) (*sandbox.Info, error) {
+	var err error
+
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+	config := n.getConfig()
+
+	epConfig, err := validateEndpoint(config, epOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	// The parent interface may have gone since the network was created
+	parent, err := netlink.LinkByName(config.Parent)
+	if err != nil {
+		return nil, ParentNotFoundError(config.Parent)
+	}
+
+	// Create and add the endpoint
+	n.Lock()
+	if _, ok := n.endpoints[eid]; ok {
+		n.Unlock()
+		return nil, driverapi.ErrEndpointExists
+	}
+	endpoint := &subEndpoint{id: eid}
+	n.endpoints[eid] = endpoint
+	n.Unlock()
+
+	// On failure make sure to remove the endpoint
+	defer func() {
+		if err != nil {
+			n.Lock()
+			delete(n.endpoints, eid)
+			n.Unlock()
+		}
+	}()
+
+	var reqIP net.IP
+	if epConfig != nil && epConfig.AddressIPv4 != nil {
+		reqIP = epConfig.AddressIPv4
+	}
+
+	subnet, ip, err := allocateIP(config, reqIP)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			ipAllocator.ReleaseAddress(subnet, ip)
+		}
+	}()
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	name, err := generateIfaceName(d.kind.prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = d.kind.add(name, parent, config.Mode); err != nil {
+		return nil, err
+	}
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			netlink.LinkDel(link)
+		}
+	}()
+
+	intf := &sandbox.Interface{}
+	intf.SrcName = name
+	intf.DstName = containerVeth
+	intf.Address = &net.IPNet{IP: ip, Mask: subnet.Mask}
+	intf.Pool = netutils.GetIPNetCopy(subnet)
+
+	// The ipvlan interfaces share the MAC address of the parent interface
+	if d.kind.ownMac {
+		mac := netutils.GenerateMACFromIP(ip)
+		if epConfig != nil && epConfig.MacAddress != nil {
+			mac = epConfig.MacAddress
+		}
+		if err = netlink.LinkSetHardwareAddr(link, mac); err != nil {
+			return nil, err
+		}
+		intf.MacAddress = mac
+	}
+
+	endpoint.port = intf
+
+	sinfo := &sandbox.Info{Interfaces: []*sandbox.Interface{intf}}
+
+	// In the ipvlan l3 mode the parent interface routes all the traffic
+	if config.Mode == modeL3 {
+		sinfo.Routes = []*sandbox.Route{{Interface: containerVeth}}
+	} else if subnet == config.Subnet {
+		sinfo.Gateway = netutils.GetIPCopy(config.Gateway)
+	}
+
+	return sinfo, nil
+}
+
+func (d *driver) ValidateEndpoint(nid types.UUID, epOptions interface{}) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+
+	_, err = validateEndpoint(n.getConfig(), epOptions)
+	return err
+}
+
+func validateEndpoint(config *NetworkConfiguration, epOptions interface{}) (*EndpointConfiguration, error) {
+	epConfig, err := parseEndpointOptions(epOptions)
+	if err != nil {
+		return nil, err
+	}
+	if epConfig != nil && epConfig.AddressIPv4 != nil && config.subnet(epConfig.AddressIPv4) == nil {
+		return nil, ErrIPOutOfRange
+	}
+	return epConfig, nil
+}
+
+func (d *driver) DeleteEndpoint(nid, eid types.UUID) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	ep, ok := n.endpoints[eid]
+	if !ok {
+		n.Unlock()
+		return EndpointNotFoundError(eid)
+	}
+	delete(n.endpoints, eid)
+	n.Unlock()
+
+	if err := ipAllocator.ReleaseAddress(n.getConfig().subnet(ep.port.Address.IP), ep.port.Address.IP); err != nil {
+		log.Warnf("Failed to release the address of endpoint %s: %v", eid, err)
+	}
+
+	// Try removal of link. Discard error: link might have already been
+	// deleted by sandbox delete.
+	if link, err := netlink.LinkByName(ep.port.SrcName); err == nil {
+		netlink.LinkDel(link)
+	}
+
+	return nil
+}
+
+// Join is a no-op, the endpoint is attached to the physical network as soon
+// as it is created.
+func (d *driver) Join(nid, eid types.UUID, sboxKey string) (*driverapi.JoinInfo, error) {
+	if _, err := d.getEndpoint(nid, eid); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (d *driver) Leave(nid, eid types.UUID) error {
+	_, err := d.getEndpoint(nid, eid)
+	return err
+}
+
+// RestoreNetwork fails with ErrRestoreNotSupported: the configuration the
+// network was created with is not kept across restarts.
+func (d *driver) RestoreNetwork(nid types.UUID) error {
+	return driverapi.ErrRestoreNotSupported
+}
+
+// RestoreEndpoint fails with ErrRestoreNotSupported, as RestoreNetwork does.
+func (d *driver) RestoreEndpoint(nid, eid types.UUID, sinfo *sandbox.Info) error {
+	return driverapi.ErrRestoreNotSupported
+}
+
+func (d *driver) Type() string {
+	return d.kind.networkType
+}
+
+func (d *driver) Capabilities() driverapi.DriverCapability {
+	return driverapi.DriverCapability{StaticIP: true, Privileged: true}
+}
+
+func (d *driver) getNetwork(nid types.UUID) (*subNetwork, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	n, ok := d.networks[nid]
+	if !ok {
+		return nil, driverapi.ErrNoNetwork
+	}
+	return n, nil
+}
+
+func (d *driver) getEndpoint(nid, eid types.UUID) (*subEndpoint, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Lock()
+	defer n.Unlock()
+
+	ep, ok := n.endpoints[eid]
+	if !ok {
+		return nil, EndpointNotFoundError(eid)
+	}
+	return ep, nil
+}
+
+func (n *subNetwork) getConfig() *NetworkConfiguration {
+	n.Lock()
+	defer n.Unlock()
+
+	return n.config
+}
+
+func parseNetworkOptions(option interface{}) (*NetworkConfiguration, error) {
+	switch opt := option.(type) {
+	case options.Generic:
+		opaqueConfig, err := options.GenerateFromModel(opt, &NetworkConfiguration{})
+		if err != nil {
+			return nil, err
+		}
+		return opaqueConfig.(*NetworkConfiguration), nil
+	case *NetworkConfiguration:
+		// The configuration is normalized, leave the caller one alone
+		config := *opt
+		return &config, nil
+	default:
+		return &NetworkConfiguration{}, nil
+	}
+}
+
+func parseEndpointOptions(epOptions interface{}) (*EndpointConfiguration, error) {
+	if epOptions == nil {
+		return nil, nil
+	}
+	switch opt := epOptions.(type) {
+	case options.Generic:
+		opaqueConfig, err := options.GenerateFromModel(opt, &EndpointConfiguration{})
+		if err != nil {
+			return nil, err
+		}
+		return opaqueConfig.(*EndpointConfiguration), nil
+	case *EndpointConfiguration:
+		return opt, nil
+	default:
+		return nil, ErrInvalidEndpointConfig
+	}
+}
+
+// requestIP requests the passed address, or the next available one when nil.
+// allocateIP allocates the requested address ip from the subnet of the
+// network it belongs to, or the next available address of the first subnet
+// which has one when ip is nil.
+func allocateIP(config *NetworkConfiguration, ip net.IP) (*net.IPNet, net.IP, error) {
+	if ip != nil {
+		subnet := config.subnet(ip)
+		if subnet == nil {
+			return nil, nil, ErrIPOutOfRange
+		}
+		allocated, err := requestIP(ipAllocator, subnet, ip)
+		return subnet, allocated, err
+	}
+
+	for _, subnet := range config.subnets() {
+		allocated, err := requestIP(ipAllocator, subnet, nil)
+		if err == ipamapi.ErrNoAvailableIPs {
+			continue
+		}
+		return subnet, allocated, err
+	}
+	return nil, nil, ipamapi.ErrNoAvailableIPs
+}
+
+func requestIP(ipam ipamapi.IPAM, network *net.IPNet, ip net.IP) (net.IP, error) {
+	allocated, err := ipam.RequestAddress(network, ip)
+	if err == ipamapi.ErrIPOutOfRange {
+		return nil, ErrIPOutOfRange
+	}
+	return allocated, err
+}
+
+func generateIfaceName(prefix string) (string, error) {
+	for i := 0; i < 3; i++ {
+		name, err := netutils.GenerateRandomName(prefix, ifaceLen)
+		if err != nil {
+			continue
+		}
+		if _, err := net.InterfaceByName(name); err != nil {
+			if strings.Contains(err.Error(), "no such") {
+				return name, nil
+			}
+			return "", err
+		}
+	}
+	return "", ErrIfaceName
+}
@@ -0,0 +1,372 @@
+package macvlan
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/pkg/options"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+const parentName = "dummy0"
+
+func getSubnet(t *testing.T, cidr string) *net.IPNet {
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return subnet
+}
+
+// setupParent creates the interface the endpoints of the tests are attached
+// to.
+func setupParent(t *testing.T) netlink.Link {
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: parentName}, PeerName: "dummy1"}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatalf("Failed to create the parent interface: %v", err)
+	}
+	link, err := netlink.LinkByName(parentName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		t.Fatal(err)
+	}
+	return link
+}
+
+func TestCreateNetworkInvalid(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	setupParent(t)
+	_, d := New()
+
+	config := &NetworkConfiguration{Subnet: getSubnet(t, "192.168.251.0/24")}
+	if err := d.CreateNetwork("dummy", config); err != ErrNoParent {
+		t.Fatalf("Expected %v, got %v", ErrNoParent, err)
+	}
+
+	config.Parent = "nonexistent0"
+	if err := d.CreateNetwork("dummy", config); err != ParentNotFoundError("nonexistent0") {
+		t.Fatalf("Expected %v, got %v", ParentNotFoundError("nonexistent0"), err)
+	}
+
+	config.Parent = parentName
+	config.Mode = modeL3
+	if err := d.CreateNetwork("dummy", config); err != InvalidModeError(modeL3) {
+		t.Fatalf("Expected %v, got %v", InvalidModeError(modeL3), err)
+	}
+
+	config.Mode = ""
+	config.Gateway = net.ParseIP("192.168.252.1")
+	if err := d.CreateNetwork("dummy", config); err != ErrInvalidGateway {
+		t.Fatalf("Expected %v, got %v", ErrInvalidGateway, err)
+	}
+
+	config.Gateway = nil
+	config.Subnet = nil
+	if err := d.CreateNetwork("dummy", config); err != ErrNoSubnet {
+		t.Fatalf("Expected %v, got %v", ErrNoSubnet, err)
+	}
+}
+
+func TestCreateEndpoint(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	parent := setupParent(t)
+	_, d := New()
+
+	config := &NetworkConfiguration{
+		Parent:  parentName,
+		Subnet:  getSubnet(t, "192.168.251.0/24"),
+		Gateway: net.ParseIP("192.168.251.1"),
+	}
+	if err := d.CreateNetwork("dummy", config); err != nil {
+		t.Fatalf("Failed to create macvlan network: %v", err)
+	}
+	if mode := d.(*driver).networks["dummy"].config.Mode; mode != modeBridge {
+		t.Fatalf("Expected the %s mode by default, got %s", modeBridge, mode)
+	}
+
+	sinfo, err := d.CreateEndpoint("dummy", "ep1", nil)
+	if err != nil {
+		t.Fatalf("Failed to create endpoint: %v", err)
+	}
+
+	intf := sinfo.Interfaces[0]
+	if !config.Subnet.Contains(intf.Address.IP) || intf.Address.IP.Equal(config.Gateway) {
+		t.Fatalf("Unexpected endpoint address %v", intf.Address)
+	}
+	if !sinfo.Gateway.Equal(config.Gateway) {
+		t.Fatalf("Expected gateway %v, got %v", config.Gateway, sinfo.Gateway)
+	}
+
+	link, err := netlink.LinkByName(intf.SrcName)
+	if err != nil {
+		t.Fatalf("Endpoint interface not found: %v", err)
+	}
+	if link.Type() != "macvlan" || link.Attrs().ParentIndex != parent.Attrs().Index {
+		t.Fatalf("Expected a macvlan interface of %s, got %v", parentName, link)
+	}
+	if link.Attrs().HardwareAddr.String() != intf.MacAddress.String() {
+		t.Fatalf("Expected MAC address %v, got %v", intf.MacAddress, link.Attrs().HardwareAddr)
+	}
+
+	if err := d.DeleteNetwork("dummy"); err == nil {
+		t.Fatal("Expected network deletion to fail with an active endpoint")
+	}
+
+	if err := d.DeleteEndpoint("dummy", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := netlink.LinkByName(intf.SrcName); err == nil {
+		t.Fatal("Interface of the deleted endpoint still exists")
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateEndpointSecondarySubnets(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	setupParent(t)
+	_, d := New()
+
+	// The subnets can not overlap
+	overlapping := &NetworkConfiguration{
+		Parent:           parentName,
+		Subnet:           getSubnet(t, "192.168.251.0/24"),
+		SecondarySubnets: []*net.IPNet{getSubnet(t, "192.168.251.128/25")},
+	}
+	if err := d.CreateNetwork("dummy", overlapping); err != SubnetOverlapError("192.168.251.128/25") {
+		t.Fatalf("Expected a SubnetOverlapError, got %v", err)
+	}
+
+	// A single address is left in the first subnet, after the gateway
+	config := &NetworkConfiguration{
+		Parent:           parentName,
+		Subnet:           getSubnet(t, "192.168.251.0/30"),
+		Gateway:          net.ParseIP("192.168.251.1"),
+		SecondarySubnets: []*net.IPNet{getSubnet(t, "192.168.252.0/30"), getSubnet(t, "192.168.253.0/30")},
+	}
+	if err := d.CreateNetwork("dummy", config); err != nil {
+		t.Fatalf("Failed to create macvlan network: %v", err)
+	}
+
+	info, err := d.NetworkInfo("dummy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Subnets) != 3 {
+		t.Fatalf("Expected the 3 subnets of the network, got %v", info.Subnets)
+	}
+
+	// The allocation spills into the secondary subnets once the first one
+	// is exhausted
+	expected := []struct{ ip, pool string }{
+		{"192.168.251.2", "192.168.251.0/30"},
+		{"192.168.252.1", "192.168.252.0/30"},
+		{"192.168.252.2", "192.168.252.0/30"},
+		{"192.168.253.1", "192.168.253.0/30"},
+	}
+	for i, e := range expected {
+		sinfo, err := d.CreateEndpoint("dummy", types.UUID(fmt.Sprintf("ep%d", i)), nil)
+		if err != nil {
+			t.Fatalf("Failed to create endpoint %d: %v", i, err)
+		}
+		intf := sinfo.Interfaces[0]
+		if intf.Address.String() != e.ip+"/30" || intf.Pool.String() != e.pool {
+			t.Fatalf("Expected address %s from pool %s, got %v from %v", e.ip, e.pool, intf.Address, intf.Pool)
+		}
+		// The gateway belongs to the first subnet
+		if (i == 0) != (sinfo.Gateway != nil) {
+			t.Fatalf("Unexpected gateway %v for the address %v", sinfo.Gateway, intf.Address)
+		}
+	}
+
+	// The address released from the first subnet is allocated first again
+	if err := d.DeleteEndpoint("dummy", "ep0"); err != nil {
+		t.Fatal(err)
+	}
+	sinfo, err := d.CreateEndpoint("dummy", "ep0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip := sinfo.Interfaces[0].Address.IP.String(); ip != "192.168.251.2" {
+		t.Fatalf("Expected address 192.168.251.2, got %s", ip)
+	}
+
+	// A secondary address can be requested
+	if err := d.DeleteEndpoint("dummy", "ep3"); err != nil {
+		t.Fatal(err)
+	}
+	sinfo, err = d.CreateEndpoint("dummy", "ep3", &EndpointConfiguration{AddressIPv4: net.ParseIP("192.168.253.2")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pool := sinfo.Interfaces[0].Pool.String(); pool != "192.168.253.0/30" {
+		t.Fatalf("Expected pool 192.168.253.0/30, got %s", pool)
+	}
+
+	if _, err := d.CreateEndpoint("dummy", "ep4", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.CreateEndpoint("dummy", "ep5", nil); err != ipamapi.ErrNoAvailableIPs {
+		t.Fatalf("Expected %v, got %v", ipamapi.ErrNoAvailableIPs, err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := d.DeleteEndpoint("dummy", types.UUID(fmt.Sprintf("ep%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pools of all the subnets are released
+	if err := d.CreateNetwork("dummy", config); err != nil {
+		t.Fatalf("Failed to create the network again: %v", err)
+	}
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateNetworkPromiscuous(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	setupParent(t)
+	_, d := New()
+
+	promisc := func() bool {
+		on, err := netutils.Promisc(parentName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return on
+	}
+
+	config := &NetworkConfiguration{Parent: parentName, Subnet: getSubnet(t, "192.168.251.0/24"), EnablePromiscuous: true}
+	if err := d.CreateNetwork("net1", config); err != nil {
+		t.Fatalf("Failed to create macvlan network: %v", err)
+	}
+	config = &NetworkConfiguration{Parent: parentName, Subnet: getSubnet(t, "192.168.252.0/24"), EnablePromiscuous: true}
+	if err := d.CreateNetwork("net2", config); err != nil {
+		t.Fatalf("Failed to create macvlan network: %v", err)
+	}
+	if !promisc() {
+		t.Fatal("Parent interface not in promiscuous mode")
+	}
+
+	// The mode stays while a network of the parent requests it
+	if err := d.DeleteNetwork("net1"); err != nil {
+		t.Fatal(err)
+	}
+	if !promisc() {
+		t.Fatal("Promiscuous mode disabled while a network requests it")
+	}
+	if err := d.DeleteNetwork("net2"); err != nil {
+		t.Fatal(err)
+	}
+	if promisc() {
+		t.Fatal("Promiscuous mode left after the networks deletion")
+	}
+
+	// The mode the operator set is left in place
+	if err := netutils.SetPromisc(parentName, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CreateNetwork("net1", config); err != nil {
+		t.Fatalf("Failed to create macvlan network: %v", err)
+	}
+	if err := d.DeleteNetwork("net1"); err != nil {
+		t.Fatal(err)
+	}
+	if !promisc() {
+		t.Fatal("Promiscuous mode set by the operator disabled")
+	}
+}
+
+func TestUpdateNetwork(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	setupParent(t)
+	_, d := New()
+
+	config := &NetworkConfiguration{Parent: parentName, Subnet: getSubnet(t, "192.168.251.0/24")}
+	if err := d.CreateNetwork("dummy", config); err != nil {
+		t.Fatalf("Failed to create macvlan network: %v", err)
+	}
+
+	// The settings defaulted at the creation are not changes
+	gw := net.ParseIP("192.168.251.1")
+	if err := d.UpdateNetwork("dummy", &NetworkConfiguration{Parent: parentName, Subnet: config.Subnet, Gateway: gw}); err != nil {
+		t.Fatalf("Failed to update the network: %v", err)
+	}
+
+	sinfo, err := d.CreateEndpoint("dummy", "ep1", nil)
+	if err != nil {
+		t.Fatalf("Failed to create endpoint: %v", err)
+	}
+	if !sinfo.Gateway.Equal(gw) || sinfo.Interfaces[0].Address.IP.Equal(gw) {
+		t.Fatalf("Expected gateway %v apart from the endpoint address, got %v and %v", gw, sinfo.Gateway, sinfo.Interfaces[0].Address)
+	}
+
+	if err := d.UpdateNetwork("dummy", options.Generic{"Mode": modeVEPA}); err != driverapi.ImmutableOptionError("Mode") {
+		t.Fatalf("Expected %v, got %v", driverapi.ImmutableOptionError("Mode"), err)
+	}
+
+	if err := d.DeleteEndpoint("dummy", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateIPVlanEndpoint(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	parent := setupParent(t)
+	_, d := NewIPVlan()
+
+	config := &NetworkConfiguration{
+		Parent: parentName,
+		Mode:   modeL3,
+		Subnet: getSubnet(t, "192.168.251.0/24"),
+	}
+	if err := d.CreateNetwork("dummy", config); err != nil {
+		t.Fatalf("Failed to create ipvlan network: %v", err)
+	}
+
+	sinfo, err := d.CreateEndpoint("dummy", "ep1", nil)
+	if err != nil {
+		if err := addIPVlan("ipvltest", parent, modeL3); err != nil {
+			t.Skipf("The kernel does not support ipvlan: %v", err)
+		}
+		t.Fatalf("Failed to create endpoint: %v", err)
+	}
+
+	intf := sinfo.Interfaces[0]
+	link, err := netlink.LinkByName(intf.SrcName)
+	if err != nil {
+		t.Fatalf("Endpoint interface not found: %v", err)
+	}
+	if link.Type() != "ipvlan" || link.Attrs().ParentIndex != parent.Attrs().Index {
+		t.Fatalf("Expected an ipvlan interface of %s, got %v", parentName, link)
+	}
+
+	// In the l3 mode the default route goes through the interface
+	if len(sinfo.Routes) != 1 || sinfo.Routes[0].Destination != nil || sinfo.Routes[0].Interface != containerVeth {
+		t.Fatalf("Expected a default route through %s, got %v", containerVeth, sinfo.Routes)
+	}
+
+	if err := d.DeleteEndpoint("dummy", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,6 +1,8 @@
 package null
 
 import (
+	"context"
+
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/sandbox"
 	"github.com/docker/libnetwork/types"
@@ -19,7 +21,23 @@ func (d *driver) Config(option interface{}) error {
 	return nil
 }
 
+func (d *driver) ConfigRequired() bool {
+	return false
+}
+
 func (d *driver) CreateNetwork(id types.UUID, option interface{}) error {
+	return d.CreateNetworkWithContext(context.Background(), id, option)
+}
+
+func (d *driver) CreateNetworkWithContext(ctx context.Context, id types.UUID, option interface{}) error {
+	return ctx.Err()
+}
+
+func (d *driver) ValidateNetwork(option interface{}) error {
+	return nil
+}
+
+func (d *driver) UpdateNetwork(nid types.UUID, option interface{}) error {
 	return nil
 }
 
@@ -27,14 +45,48 @@ func (d *driver) DeleteNetwork(nid types.UUID) error {
 	return nil
 }
 
+func (d *driver) NetworkInfo(nid types.UUID) (*driverapi.NetworkInfo, error) {
+	return &driverapi.NetworkInfo{}, nil
+}
+
 func (d *driver) CreateEndpoint(nid, eid types.UUID, epOptions interface{}) (*sandbox.Info, error) {
-	return nil, nil
+	return d.CreateEndpointWithContext(context.Background(), nid, eid, epOptions)
+}
+
+func (d *driver) CreateEndpointWithContext(ctx context.Context, nid, eid types.UUID, epOptions interface{}) (*sandbox.Info, error) {
+	return nil, ctx.Err()
+}
+
+func (d *driver) ValidateEndpoint(nid types.UUID, epOptions interface{}) error {
+	return nil
 }
 
 func (d *driver) DeleteEndpoint(nid, eid types.UUID) error {
 	return nil
 }
 
+func (d *driver) Join(nid, eid types.UUID, sboxKey string) (*driverapi.JoinInfo, error) {
+	return nil, nil
+}
+
+func (d *driver) Leave(nid, eid types.UUID) error {
+	return nil
+}
+
+// RestoreNetwork is a no-op, the driver keeps no state.
+func (d *driver) RestoreNetwork(nid types.UUID) error {
+	return nil
+}
+
+// RestoreEndpoint is a no-op, the driver keeps no state.
+func (d *driver) RestoreEndpoint(nid, eid types.UUID, sinfo *sandbox.Info) error {
+	return nil
+}
+
 func (d *driver) Type() string {
 	return networkType
 }
+
+func (d *driver) Capabilities() driverapi.DriverCapability {
+	return driverapi.DriverCapability{}
+}
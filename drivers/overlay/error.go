@@ -0,0 +1,53 @@
+package overlay
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrConfigExists error is returned when the driver is configured once networks exist.
+	ErrConfigExists = errors.New("overlay configuration can only be applied before creating networks")
+
+	// ErrNetworkExists error is returned when a network is created with the id of an existing one.
+	ErrNetworkExists = errors.New("network already exists")
+
+	// ErrNoSubnet error is returned when a network is created without a subnet.
+	ErrNoSubnet = errors.New("an overlay network requires a subnet")
+
+	// ErrInvalidEndpointConfig error is returned when a endpoint create is attempted with an invalid endpoint configuration.
+	ErrInvalidEndpointConfig = errors.New("trying to create an endpoint with an invalid endpoint configuration")
+
+	// ErrNoVNI error is returned when all the VXLAN network identifiers are in use.
+	ErrNoVNI = errors.New("no VXLAN network identifier available")
+
+	// ErrIfaceName error is returned when a new name could not be generated.
+	ErrIfaceName = errors.New("failed to find name for new interface")
+
+	// ErrIPOutOfRange is returned when the address requested for an endpoint is outside the network subnet.
+	ErrIPOutOfRange = errors.New("requested endpoint address is outside the network subnet")
+)
+
+// InvalidVNIError is returned when the requested VXLAN network identifier is
+// out of range or already used by another network.
+type InvalidVNIError uint32
+
+func (ive InvalidVNIError) Error() string {
+	return fmt.Sprintf("VXLAN network identifier %d is out of range or in use", uint32(ive))
+}
+
+// ActiveEndpointsError is returned when there are
+// still active endpoints in the network being deleted.
+type ActiveEndpointsError string
+
+func (aee ActiveEndpointsError) Error() string {
+	return fmt.Sprintf("network %s has active endpoint", string(aee))
+}
+
+// EndpointNotFoundError is returned when the no endpoint
+// with the passed endpoint id is found.
+type EndpointNotFoundError string
+
+func (enfe EndpointNotFoundError) Error() string {
+	return fmt.Sprintf("endpoint not found: %s", string(enfe))
+}
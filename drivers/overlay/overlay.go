@@ -0,0 +1,670 @@
+package overlay
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ipallocator"
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/pkg/options"
+	"github.com/docker/libnetwork/sandbox"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	networkType   = "overlay"
+	vethPrefix    = "veth"
+	vethLen       = 7
+	containerVeth = "eth0"
+	defaultMtu    = 1500
+
+	// nsDir is where the network namespaces holding the bridge of each
+	// overlay network are mounted, apart from the container sandboxes.
+	nsDir = "/var/lib/docker/network/overlay"
+)
+
+var ipAllocator *ipallocator.IPAllocator // Default IPAM for the networks
+
+// Configuration info for the "overlay" driver.
+type Configuration struct {
+	// LocalIP is the underlay address the VXLAN traffic is sent from. The
+	// kernel picks it from the routing table when nil.
+	LocalIP net.IP
+	// PeerDB provides the remote hosts each network spans. The Peers listed
+	// in the network options are used when nil.
+	PeerDB PeerDB
+	// Mtu of the underlay network, defaults to 1500. The endpoints get the
+	// room left by the VXLAN encapsulation.
+	Mtu int
+}
+
+// NetworkConfiguration represents the user specified configuration for the overlay network
+type NetworkConfiguration struct {
+	// Subnet the endpoints addresses are allocated from. It must be the
+	// same on all the hosts the network spans.
+	Subnet *net.IPNet
+	// VNI is the VXLAN network identifier of the network, the next
+	// available one is allocated when zero.
+	VNI uint32
+	// Peers are the underlay addresses of the remote hosts the network
+	// spans, when the driver has no PeerDB configured.
+	Peers []net.IP
+}
+
+// EndpointConfiguration represents the user specified configuration for the sandbox endpoint
+type EndpointConfiguration struct {
+	MacAddress  net.HardwareAddr
+	AddressIPv4 net.IP
+}
+
+type overlayEndpoint struct {
+	id   types.UUID
+	name string // host side of the veth pair, in the network namespace
+	port *sandbox.Interface
+}
+
+type overlayNetwork struct {
+	id        types.UUID
+	config    *NetworkConfiguration // User specified parameters
+	vni       uint32
+	subnet    *net.IPNet
+	sbox      sandbox.Sandbox // namespace holding the network bridge
+	peerDB    PeerDB
+	peers     []net.IP // remote hosts programmed in the forwarding table
+	endpoints map[types.UUID]*overlayEndpoint
+	sync.Mutex
+}
+
+type driver struct {
+	config   *Configuration
+	networks map[types.UUID]*overlayNetwork
+	vnis     *vniTable
+	sync.Mutex
+}
+
+func init() {
+	ipAllocator = ipallocator.New()
+}
+
+// New provides a new instance of overlay driver
+func New() (string, driverapi.Driver) {
+	return networkType, &driver{
+		config:   &Configuration{},
+		networks: make(map[types.UUID]*overlayNetwork),
+		vnis:     newVNITable(),
+	}
+}
+
+func (d *driver) Config(option interface{}) error {
+	var config *Configuration
+
+	d.Lock()
+	defer d.Unlock()
+
+	if len(d.networks) != 0 {
+		return ErrConfigExists
+	}
+
+	switch opt := option.(type) {
+	case options.Generic:
+		opaqueConfig, err := options.GenerateFromModel(opt, &Configuration{})
+		if err != nil {
+			return err
+		}
+		config = opaqueConfig.(*Configuration)
+	case *Configuration:
+		config = opt
+	default:
+		config = &Configuration{}
+	}
+
+	d.config = config
+
+	return nil
+}
+
+func (d *driver) ConfigRequired() bool {
+	return false
+}
+
+// Create a new network using overlay plugin
+func (d *driver) CreateNetwork(id types.UUID, option interface{}) error {
+	return d.CreateNetworkWithContext(context.Background(), id, option)
+}
+
+func (d *driver) CreateNetworkWithContext(ctx context.Context, id types.UUID, option interface{}) error {
+	var err error
+
+	nConfig, err := validateNetwork(option)
+	if err != nil {
+		return err
+	}
+	subnet := &net.IPNet{IP: nConfig.Subnet.IP.Mask(nConfig.Subnet.Mask), Mask: nConfig.Subnet.Mask}
+
+	d.Lock()
+	config := d.config
+	if _, ok := d.networks[id]; ok {
+		d.Unlock()
+		return ErrNetworkExists
+	}
+	d.Unlock()
+
+	n := &overlayNetwork{id: id, config: nConfig, subnet: subnet, peerDB: config.PeerDB, endpoints: make(map[types.UUID]*overlayEndpoint)}
+	if n.peerDB == nil {
+		n.peerDB = staticPeerDB(nConfig.Peers)
+	}
+
+	// On failure undo the steps applied so far, so that the network creation
+	// is atomic
+	if n.vni, err = d.vnis.request(nConfig.VNI); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			d.vnis.release(n.vni)
+		}
+	}()
+
+	if err = ipAllocator.RequestPool(subnet, nil); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			ipAllocator.ReleasePool(subnet)
+		}
+	}()
+
+	if err = os.MkdirAll(nsDir, 0755); err != nil {
+		return err
+	}
+	if n.sbox, err = sandbox.NewSandbox(filepath.Join(nsDir, string(id)), true); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			n.sbox.Destroy()
+		}
+	}()
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	if err = setupNamespace(n.sbox.Key(), n.vni, config.LocalIP); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			teardownNamespace(n.sbox.Key(), n.vni)
+		}
+	}()
+
+	if err = n.syncPeers(); err != nil {
+		return err
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	d.Lock()
+	if _, ok := d.networks[id]; ok {
+		d.Unlock()
+		err = ErrNetworkExists
+		return err
+	}
+	d.networks[id] = n
+	d.Unlock()
+
+	return nil
+}
+
+// ValidateNetwork checks the options of a network, and that the VNI it
+// requests is available.
+func (d *driver) ValidateNetwork(option interface{}) error {
+	nConfig, err := validateNetwork(option)
+	if err != nil {
+		return err
+	}
+	return d.vnis.check(nConfig.VNI)
+}
+
+func validateNetwork(option interface{}) (*NetworkConfiguration, error) {
+	nConfig, err := parseNetworkOptions(option)
+	if err != nil {
+		return nil, err
+	}
+	if nConfig.Subnet == nil {
+		return nil, ErrNoSubnet
+	}
+	return nConfig, nil
+}
+
+// UpdateNetwork applies a new list of static peers. The other options are
+// fixed at the network creation.
+func (d *driver) UpdateNetwork(nid types.UUID, option interface{}) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	upd, changed, err := options.UpdateModel(option, n.config)
+	n.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, field := range changed {
+		if field != "Peers" {
+			return driverapi.ImmutableOptionError(field)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	nConfig := upd.(*NetworkConfiguration)
+
+	n.Lock()
+	n.config = nConfig
+	if _, ok := n.peerDB.(staticPeerDB); ok {
+		n.peerDB = staticPeerDB(nConfig.Peers)
+	}
+	n.Unlock()
+
+	return n.syncPeers()
+}
+
+func (d *driver) DeleteNetwork(nid types.UUID) error {
+	d.Lock()
+	n, ok := d.networks[nid]
+	if !ok {
+		d.Unlock()
+		return driverapi.ErrNoNetwork
+	}
+
+	// Cannot remove network if endpoints are still present
+	n.Lock()
+	numEps := len(n.endpoints)
+	n.Unlock()
+	if numEps != 0 {
+		d.Unlock()
+		return ActiveEndpointsError(nid)
+	}
+
+	delete(d.networks, nid)
+	d.Unlock()
+
+	if err := teardownNamespace(n.sbox.Key(), n.vni); err != nil {
+		log.Warnf("Failed to remove the VXLAN interface of network %s: %v", nid, err)
+	}
+	if err := n.sbox.Destroy(); err != nil {
+		log.Warnf("Failed to remove the namespace of network %s: %v", nid, err)
+	}
+
+	if err := ipAllocator.ReleasePool(n.subnet); err != nil {
+		log.Warnf("Failed to release the pool of network %s: %v", nid, err)
+	}
+	d.vnis.release(n.vni)
+
+	return nil
+}
+
+func (d *driver) NetworkInfo(nid types.UUID) (*driverapi.NetworkInfo, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &driverapi.NetworkInfo{Subnets: []*net.IPNet{netutils.GetIPNetCopy(n.subnet)}}, nil
+}
+
+func (d *driver) CreateEndpoint(nid, eid types.UUID, epOptions interface{}) (*sandbox.Info, error) {
+	return d.CreateEndpointWithContext(context.Background(), nid, eid, epOptions)
+}
+
+func (d *driver) CreateEndpointWithContext(ctx context.Context, nid, eid types.UUID, epOptions interface{}) (*sandbox.Info, error) {
+	var err error
+
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	d.Lock()
+	config := d.config
+	d.Unlock()
+
+	epConfig, err := n.validateEndpoint(epOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create and add the endpoint
+	n.Lock()
+	if _, ok := n.endpoints[eid]; ok {
+		n.Unlock()
+		return nil, driverapi.ErrEndpointExists
+	}
+	endpoint := &overlayEndpoint{id: eid}
+	n.endpoints[eid] = endpoint
+	n.Unlock()
+
+	// On failure make sure to remove the endpoint
+	defer func() {
+		if err != nil {
+			n.Lock()
+			delete(n.endpoints, eid)
+			n.Unlock()
+		}
+	}()
+
+	// The hosts joining the network since it was created
+	if err = n.syncPeers(); err != nil {
+		return nil, err
+	}
+
+	var reqIP net.IP
+	if epConfig != nil && epConfig.AddressIPv4 != nil {
+		reqIP = epConfig.AddressIPv4
+	}
+
+	ip, err := requestIP(ipAllocator, n.subnet, reqIP)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			ipAllocator.ReleaseAddress(n.subnet, ip)
+		}
+	}()
+
+	mac := netutils.GenerateMACFromIP(ip)
+	if epConfig != nil && epConfig.MacAddress != nil {
+		mac = epConfig.MacAddress
+	}
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Generate the names of the network and sandbox sides of the veth pair
+	name1, err := generateIfaceName()
+	if err != nil {
+		return nil, err
+	}
+	name2, err := generateIfaceName()
+	if err != nil {
+		return nil, err
+	}
+
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: name1, TxQLen: 0},
+		PeerName:  name2}
+	if err = netlink.LinkAdd(veth); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			netlink.LinkDel(veth)
+		}
+	}()
+
+	sbox, err := netlink.LinkByName(name2)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = netlink.LinkSetHardwareAddr(sbox, mac); err != nil {
+		return nil, err
+	}
+
+	// Leave room for the VXLAN encapsulation
+	mtu := defaultMtu
+	if config.Mtu != 0 {
+		mtu = config.Mtu
+	}
+	mtu -= vxlanOverhead
+	if err = netlink.LinkSetMTU(sbox, mtu); err != nil {
+		return nil, err
+	}
+
+	if err = attachPort(n.sbox.Key(), name1, mtu); err != nil {
+		return nil, err
+	}
+	endpoint.name = name1
+
+	intf := &sandbox.Interface{}
+	intf.SrcName = name2
+	intf.DstName = containerVeth
+	intf.MacAddress = mac
+	intf.Address = &net.IPNet{IP: ip, Mask: n.subnet.Mask}
+	endpoint.port = intf
+
+	return &sandbox.Info{Interfaces: []*sandbox.Interface{intf}}, nil
+}
+
+func (d *driver) ValidateEndpoint(nid types.UUID, epOptions interface{}) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+
+	_, err = n.validateEndpoint(epOptions)
+	return err
+}
+
+func (n *overlayNetwork) validateEndpoint(epOptions interface{}) (*EndpointConfiguration, error) {
+	epConfig, err := parseEndpointOptions(epOptions)
+	if err != nil {
+		return nil, err
+	}
+	if epConfig != nil && epConfig.AddressIPv4 != nil && !n.subnet.Contains(epConfig.AddressIPv4) {
+		return nil, ErrIPOutOfRange
+	}
+	return epConfig, nil
+}
+
+func (d *driver) DeleteEndpoint(nid, eid types.UUID) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	ep, ok := n.endpoints[eid]
+	if !ok {
+		n.Unlock()
+		return EndpointNotFoundError(eid)
+	}
+	delete(n.endpoints, eid)
+	n.Unlock()
+
+	if err := ipAllocator.ReleaseAddress(n.subnet, ep.port.Address.IP); err != nil {
+		log.Warnf("Failed to release the address of endpoint %s: %v", eid, err)
+	}
+
+	// Removing the network side of the pair removes the sandbox one, where
+	// ever it is
+	if err := detachPort(n.sbox.Key(), ep.name); err != nil {
+		log.Warnf("Failed to remove the interface of endpoint %s: %v", eid, err)
+	}
+
+	return nil
+}
+
+// Join is a no-op, the endpoint is reachable from the remote hosts as soon as
+// it is created.
+func (d *driver) Join(nid, eid types.UUID, sboxKey string) (*driverapi.JoinInfo, error) {
+	if _, err := d.getEndpoint(nid, eid); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (d *driver) Leave(nid, eid types.UUID) error {
+	_, err := d.getEndpoint(nid, eid)
+	return err
+}
+
+// RestoreNetwork fails with ErrRestoreNotSupported: the configuration the
+// network was created with is not kept across restarts.
+func (d *driver) RestoreNetwork(nid types.UUID) error {
+	return driverapi.ErrRestoreNotSupported
+}
+
+// RestoreEndpoint fails with ErrRestoreNotSupported, as RestoreNetwork does.
+func (d *driver) RestoreEndpoint(nid, eid types.UUID, sinfo *sandbox.Info) error {
+	return driverapi.ErrRestoreNotSupported
+}
+
+func (d *driver) Type() string {
+	return networkType
+}
+
+func (d *driver) Capabilities() driverapi.DriverCapability {
+	return driverapi.DriverCapability{MultiHost: true, StaticIP: true, Privileged: true}
+}
+
+func (d *driver) getNetwork(nid types.UUID) (*overlayNetwork, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	n, ok := d.networks[nid]
+	if !ok {
+		return nil, driverapi.ErrNoNetwork
+	}
+	return n, nil
+}
+
+func (d *driver) getEndpoint(nid, eid types.UUID) (*overlayEndpoint, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Lock()
+	defer n.Unlock()
+
+	ep, ok := n.endpoints[eid]
+	if !ok {
+		return nil, EndpointNotFoundError(eid)
+	}
+	return ep, nil
+}
+
+// syncPeers programs the forwarding table of the network with the remote
+// hosts the peer database currently lists.
+func (n *overlayNetwork) syncPeers() error {
+	n.Lock()
+	peerDB := n.peerDB
+	n.Unlock()
+
+	peers, err := peerDB.Peers(n.id)
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	defer n.Unlock()
+
+	for _, p := range peers {
+		if !containsIP(n.peers, p) {
+			if err := programPeer(n.sbox.Key(), n.vni, p, true); err != nil {
+				return err
+			}
+			n.peers = append(n.peers, p)
+		}
+	}
+
+	var kept []net.IP
+	for _, p := range n.peers {
+		if containsIP(peers, p) {
+			kept = append(kept, p)
+			continue
+		}
+		if err := programPeer(n.sbox.Key(), n.vni, p, false); err != nil {
+			log.Warnf("Failed to remove peer %s from network %s: %v", p, n.id, err)
+		}
+	}
+	n.peers = kept
+
+	return nil
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseNetworkOptions(option interface{}) (*NetworkConfiguration, error) {
+	switch opt := option.(type) {
+	case options.Generic:
+		opaqueConfig, err := options.GenerateFromModel(opt, &NetworkConfiguration{})
+		if err != nil {
+			return nil, err
+		}
+		return opaqueConfig.(*NetworkConfiguration), nil
+	case *NetworkConfiguration:
+		// The configuration is kept, leave the caller one alone
+		config := *opt
+		return &config, nil
+	default:
+		return &NetworkConfiguration{}, nil
+	}
+}
+
+func parseEndpointOptions(epOptions interface{}) (*EndpointConfiguration, error) {
+	if epOptions == nil {
+		return nil, nil
+	}
+	switch opt := epOptions.(type) {
+	case options.Generic:
+		opaqueConfig, err := options.GenerateFromModel(opt, &EndpointConfiguration{})
+		if err != nil {
+			return nil, err
+		}
+		return opaqueConfig.(*EndpointConfiguration), nil
+	case *EndpointConfiguration:
+		return opt, nil
+	default:
+		return nil, ErrInvalidEndpointConfig
+	}
+}
+
+// requestIP requests the passed address, or the next available one when nil.
+func requestIP(ipam ipamapi.IPAM, network *net.IPNet, ip net.IP) (net.IP, error) {
+	allocated, err := ipam.RequestAddress(network, ip)
+	if err == ipamapi.ErrIPOutOfRange {
+		return nil, ErrIPOutOfRange
+	}
+	return allocated, err
+}
+
+func generateIfaceName() (string, error) {
+	for i := 0; i < 3; i++ {
+		name, err := netutils.GenerateRandomName(vethPrefix, vethLen)
+		if err != nil {
+			continue
+		}
+		if _, err := net.InterfaceByName(name); err != nil {
+			if strings.Contains(err.Error(), "no such") {
+				return name, nil
+			}
+			return "", err
+		}
+	}
+	return "", ErrIfaceName
+}
@@ -0,0 +1,338 @@
+package overlay
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/pkg/options"
+	"github.com/docker/libnetwork/sandbox"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+func getSubnet(t *testing.T, cidr string) *net.IPNet {
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return subnet
+}
+
+// fdbPeers returns the remote hosts programmed in the forwarding table of
+// the network.
+func fdbPeers(t *testing.T, n *overlayNetwork) []net.IP {
+	var peers []net.IP
+	err := sandbox.Invoke(n.sbox.Key(), func() error {
+		link, err := netlink.LinkByName(vxlanName(n.vni))
+		if err != nil {
+			return err
+		}
+		neighs, err := netlink.NeighList(link.Attrs().Index, syscall.AF_BRIDGE)
+		if err != nil {
+			return err
+		}
+		for _, neigh := range neighs {
+			if neigh.IP != nil && neigh.HardwareAddr.String() == "00:00:00:00:00:00" {
+				peers = append(peers, neigh.IP)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return peers
+}
+
+func TestCreateNetwork(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	peers := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")}
+	config := &NetworkConfiguration{
+		Subnet: getSubnet(t, "192.168.248.0/24"),
+		VNI:    42,
+		Peers:  peers,
+	}
+	if err := d.CreateNetwork("dummy", config); err != nil {
+		t.Fatalf("Failed to create overlay network: %v", err)
+	}
+
+	n := d.(*driver).networks["dummy"]
+
+	// The bridge and the VXLAN interface live in the network namespace
+	err := sandbox.Invoke(n.sbox.Key(), func() error {
+		br, err := netlink.LinkByName(bridgeName)
+		if err != nil {
+			return err
+		}
+		link, err := netlink.LinkByName(vxlanName(42))
+		if err != nil {
+			return err
+		}
+		vxlan, ok := link.(*netlink.Vxlan)
+		if !ok || vxlan.VxlanId != 42 {
+			t.Fatalf("Expected a VXLAN interface with VNI 42, got %v", link)
+		}
+		if vxlan.MasterIndex != br.Attrs().Index {
+			t.Fatal("VXLAN interface not attached to the network bridge")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := netlink.LinkByName(vxlanName(42)); err == nil {
+		t.Fatal("VXLAN interface left in the host namespace")
+	}
+
+	if got := fdbPeers(t, n); len(got) != 2 || !containsIP(got, peers[0]) || !containsIP(got, peers[1]) {
+		t.Fatalf("Expected peers %v in the forwarding table, got %v", peers, got)
+	}
+
+	info, err := d.NetworkInfo("dummy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Subnets) != 1 || info.Subnets[0].String() != "192.168.248.0/24" {
+		t.Fatalf("Unexpected network subnets %v", info.Subnets)
+	}
+
+	// The VNI identifies a single network
+	other := &NetworkConfiguration{Subnet: getSubnet(t, "192.168.249.0/24"), VNI: 42}
+	if err := d.CreateNetwork("other", other); err != InvalidVNIError(42) {
+		t.Fatalf("Expected %v, got %v", InvalidVNIError(42), err)
+	}
+
+	key := n.sbox.Key()
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(key); !os.IsNotExist(err) {
+		t.Fatalf("Network namespace not removed: %v", err)
+	}
+
+	// The VNI is available again
+	if err := d.CreateNetwork("other", other); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteNetwork("other"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateNetworkNoSubnet(t *testing.T) {
+	_, d := New()
+
+	if err := d.CreateNetwork("dummy", &NetworkConfiguration{}); err != ErrNoSubnet {
+		t.Fatalf("Expected %v, got %v", ErrNoSubnet, err)
+	}
+}
+
+func TestValidateNetwork(t *testing.T) {
+	_, d := New()
+
+	if err := d.ValidateNetwork(&NetworkConfiguration{}); err != ErrNoSubnet {
+		t.Fatalf("Expected %v, got %v", ErrNoSubnet, err)
+	}
+
+	config := &NetworkConfiguration{Subnet: getSubnet(t, "192.168.242.0/24"), VNI: minVNI}
+	if err := d.ValidateNetwork(config); err != nil {
+		t.Fatalf("Failed to validate the network: %v", err)
+	}
+
+	// The validation does not reserve the VNI
+	if _, err := d.(*driver).vnis.request(minVNI); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ValidateNetwork(config); err != InvalidVNIError(minVNI) {
+		t.Fatalf("Expected %v, got %v", InvalidVNIError(minVNI), err)
+	}
+}
+
+func TestCreateEndpoint(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	config := &NetworkConfiguration{Subnet: getSubnet(t, "192.168.248.0/24")}
+	if err := d.CreateNetwork("dummy", config); err != nil {
+		t.Fatalf("Failed to create overlay network: %v", err)
+	}
+	n := d.(*driver).networks["dummy"]
+
+	sinfo, err := d.CreateEndpoint("dummy", "ep1", nil)
+	if err != nil {
+		t.Fatalf("Failed to create endpoint: %v", err)
+	}
+
+	if len(sinfo.Interfaces) != 1 {
+		t.Fatalf("Expected one interface, got %d", len(sinfo.Interfaces))
+	}
+	intf := sinfo.Interfaces[0]
+	if !config.Subnet.Contains(intf.Address.IP) {
+		t.Fatalf("Endpoint address %v outside of the network subnet", intf.Address)
+	}
+	if sinfo.Gateway != nil {
+		t.Fatalf("Unexpected gateway %v", sinfo.Gateway)
+	}
+
+	link, err := netlink.LinkByName(intf.SrcName)
+	if err != nil {
+		t.Fatalf("Sandbox side of the endpoint not found: %v", err)
+	}
+	if mtu := link.Attrs().MTU; mtu != defaultMtu-vxlanOverhead {
+		t.Fatalf("Expected MTU %d, got %d", defaultMtu-vxlanOverhead, mtu)
+	}
+
+	// The network side is a port of the bridge in the network namespace
+	ep := n.endpoints["ep1"]
+	err = sandbox.Invoke(n.sbox.Key(), func() error {
+		br, err := netlink.LinkByName(bridgeName)
+		if err != nil {
+			return err
+		}
+		port, err := netlink.LinkByName(ep.name)
+		if err != nil {
+			return err
+		}
+		if port.Attrs().MasterIndex != br.Attrs().Index {
+			t.Fatal("Endpoint not attached to the network bridge")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.DeleteNetwork("dummy"); err == nil {
+		t.Fatal("Expected network deletion to fail with an active endpoint")
+	}
+
+	if err := d.DeleteEndpoint("dummy", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := netlink.LinkByName(intf.SrcName); err == nil {
+		t.Fatal("Sandbox side of the deleted endpoint still exists")
+	}
+
+	// The address of the deleted endpoint is available again
+	if _, err := d.CreateEndpoint("dummy", "ep2", &EndpointConfiguration{AddressIPv4: intf.Address.IP}); err != nil {
+		t.Fatalf("Failed to reuse the address of the deleted endpoint: %v", err)
+	}
+	if err := d.DeleteEndpoint("dummy", "ep2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateNetwork(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	config := &NetworkConfiguration{Subnet: getSubnet(t, "192.168.248.0/24"), Peers: []net.IP{net.ParseIP("192.0.2.1")}}
+	if err := d.CreateNetwork("dummy", config); err != nil {
+		t.Fatalf("Failed to create overlay network: %v", err)
+	}
+	n := d.(*driver).networks["dummy"]
+
+	peers := []net.IP{net.ParseIP("192.0.2.2")}
+	if err := d.UpdateNetwork("dummy", options.Generic{"Peers": peers}); err != nil {
+		t.Fatalf("Failed to update the network: %v", err)
+	}
+	if got := fdbPeers(t, n); len(got) != 1 || !got[0].Equal(peers[0]) {
+		t.Fatalf("Expected peers %v in the forwarding table, got %v", peers, got)
+	}
+
+	if err := d.UpdateNetwork("dummy", options.Generic{"VNI": uint32(7)}); err != driverapi.ImmutableOptionError("VNI") {
+		t.Fatalf("Expected %v, got %v", driverapi.ImmutableOptionError("VNI"), err)
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type fakePeerDB struct {
+	peers []net.IP
+}
+
+func (db *fakePeerDB) Peers(nid types.UUID) ([]net.IP, error) {
+	return db.peers, nil
+}
+
+func TestPeerDB(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	db := &fakePeerDB{peers: []net.IP{net.ParseIP("192.0.2.1")}}
+	if err := d.Config(&Configuration{PeerDB: db}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The peer database takes precedence over the static peers
+	config := &NetworkConfiguration{Subnet: getSubnet(t, "192.168.248.0/24"), Peers: []net.IP{net.ParseIP("192.0.2.9")}}
+	if err := d.CreateNetwork("dummy", config); err != nil {
+		t.Fatalf("Failed to create overlay network: %v", err)
+	}
+	n := d.(*driver).networks["dummy"]
+
+	if got := fdbPeers(t, n); len(got) != 1 || !got[0].Equal(db.peers[0]) {
+		t.Fatalf("Expected peers %v in the forwarding table, got %v", db.peers, got)
+	}
+
+	// The hosts joining and leaving the network are applied as endpoints
+	// are created
+	db.peers = []net.IP{net.ParseIP("192.0.2.2")}
+	if _, err := d.CreateEndpoint("dummy", "ep1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fdbPeers(t, n); len(got) != 1 || !got[0].Equal(db.peers[0]) {
+		t.Fatalf("Expected peers %v in the forwarding table, got %v", db.peers, got)
+	}
+
+	if err := d.DeleteEndpoint("dummy", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVNITable(t *testing.T) {
+	vnis := newVNITable()
+
+	vni, err := vnis.request(0)
+	if err != nil || vni != minVNI {
+		t.Fatalf("Expected VNI %d, got %d: %v", minVNI, vni, err)
+	}
+
+	if _, err := vnis.request(minVNI + 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The requested VNI is skipped when allocating the next one
+	if vni, err = vnis.request(0); err != nil || vni != minVNI+2 {
+		t.Fatalf("Expected VNI %d, got %d: %v", minVNI+2, vni, err)
+	}
+
+	if _, err := vnis.request(minVNI); err != InvalidVNIError(minVNI) {
+		t.Fatalf("Expected %v, got %v", InvalidVNIError(minVNI), err)
+	}
+	if _, err := vnis.request(maxVNI + 1); err != InvalidVNIError(maxVNI+1) {
+		t.Fatalf("Expected %v, got %v", InvalidVNIError(maxVNI+1), err)
+	}
+
+	vnis.release(minVNI)
+	if _, err := vnis.request(minVNI); err != nil {
+		t.Fatalf("Released VNI not available: %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+package overlay
+
+import (
+	"net"
+
+	"github.com/docker/libnetwork/types"
+)
+
+// PeerDB is the interface the overlay driver discovers the remote hosts
+// participating in a network through, so that the way the hosts learn about
+// each other, such as a shared datastore, can be plugged in.
+type PeerDB interface {
+	// Peers returns the underlay addresses of the remote hosts the network
+	// with the passed id spans.
+	Peers(nid types.UUID) ([]net.IP, error)
+}
+
+// staticPeerDB provides the remote hosts listed in the network options.
+type staticPeerDB []net.IP
+
+func (db staticPeerDB) Peers(nid types.UUID) ([]net.IP, error) {
+	return db, nil
+}
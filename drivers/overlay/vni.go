@@ -0,0 +1,64 @@
+package overlay
+
+import "sync"
+
+const (
+	minVNI = 1
+	maxVNI = 1<<24 - 1
+)
+
+// vniTable tracks the VXLAN network identifiers used by the networks of a
+// driver.
+type vniTable struct {
+	used map[uint32]bool
+	next uint32
+	sync.Mutex
+}
+
+func newVNITable() *vniTable {
+	return &vniTable{used: make(map[uint32]bool), next: minVNI}
+}
+
+// request allocates the passed VNI, or the next available one when zero.
+func (t *vniTable) request(vni uint32) (uint32, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if vni != 0 {
+		if vni > maxVNI || t.used[vni] {
+			return 0, InvalidVNIError(vni)
+		}
+		t.used[vni] = true
+		return vni, nil
+	}
+
+	for i := 0; i < maxVNI; i++ {
+		vni = t.next
+		if t.next++; t.next > maxVNI {
+			t.next = minVNI
+		}
+		if !t.used[vni] {
+			t.used[vni] = true
+			return vni, nil
+		}
+	}
+
+	return 0, ErrNoVNI
+}
+
+// check tells whether request would grant vni, without reserving it.
+func (t *vniTable) check(vni uint32) error {
+	t.Lock()
+	defer t.Unlock()
+
+	if vni != 0 && (vni > maxVNI || t.used[vni]) {
+		return InvalidVNIError(vni)
+	}
+	return nil
+}
+
+func (t *vniTable) release(vni uint32) {
+	t.Lock()
+	delete(t.used, vni)
+	t.Unlock()
+}
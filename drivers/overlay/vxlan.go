@@ -0,0 +1,160 @@
+package overlay
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/docker/libnetwork/sandbox"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	bridgeName = "br0"
+
+	// vxlanOverhead is the size of the headers encapsulating the frames
+	// sent over the underlay network.
+	vxlanOverhead = 50
+)
+
+// vxlanName returns the name of the VXLAN interface of the network with the
+// passed VNI, unique on the host as it is created in the host namespace.
+func vxlanName(vni uint32) string {
+	return fmt.Sprintf("vx-%06x", vni)
+}
+
+// setupNamespace creates the bridge of the network in the namespace at key,
+// along with the VXLAN interface connecting it to the remote hosts. The VXLAN
+// interface is created in the host namespace, where its underlay socket lives,
+// then moved into the network namespace. It uses the kernel default UDP port.
+func setupNamespace(key string, vni uint32, localIP net.IP) error {
+	vxlan := &netlink.Vxlan{
+		LinkAttrs: netlink.LinkAttrs{Name: vxlanName(vni)},
+		VxlanId:   int(vni),
+		SrcAddr:   localIP,
+		Learning:  true,
+	}
+	if err := netlink.LinkAdd(vxlan); err != nil {
+		return err
+	}
+
+	if err := moveToNamespace(vxlan, key); err != nil {
+		netlink.LinkDel(vxlan)
+		return err
+	}
+
+	return sandbox.Invoke(key, func() error {
+		bridge := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: bridgeName}}
+		if err := netlink.LinkAdd(bridge); err != nil {
+			return err
+		}
+
+		return attachToBridge(vxlan.Name, bridge)
+	})
+}
+
+// teardownNamespace removes the VXLAN interface of the network from the
+// namespace at key, releasing its underlay socket right away.
+func teardownNamespace(key string, vni uint32) error {
+	return sandbox.Invoke(key, func() error {
+		link, err := netlink.LinkByName(vxlanName(vni))
+		if err != nil {
+			return err
+		}
+		return netlink.LinkDel(link)
+	})
+}
+
+// attachPort moves the host side of an endpoint veth pair into the namespace
+// at key and attaches it to the network bridge.
+func attachPort(key, name string, mtu int) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+
+	if err := moveToNamespace(link, key); err != nil {
+		return err
+	}
+
+	return sandbox.Invoke(key, func() error {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			return err
+		}
+		if err := netlink.LinkSetMTU(link, mtu); err != nil {
+			return err
+		}
+		return attachToBridge(name, &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: bridgeName}})
+	})
+}
+
+// detachPort removes the host side of an endpoint veth pair from the
+// namespace at key, which removes its peer as well.
+func detachPort(key, name string) error {
+	return sandbox.Invoke(key, func() error {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			return err
+		}
+		return netlink.LinkDel(link)
+	})
+}
+
+// programPeer adds or removes the forwarding entry flooding the frames of
+// unknown destination to the remote host at peer, for it to learn about the
+// local endpoints and the other way around.
+func programPeer(key string, vni uint32, peer net.IP, isAdd bool) error {
+	return sandbox.Invoke(key, func() error {
+		link, err := netlink.LinkByName(vxlanName(vni))
+		if err != nil {
+			return err
+		}
+
+		neigh := &netlink.Neigh{
+			LinkIndex:    link.Attrs().Index,
+			Family:       syscall.AF_BRIDGE,
+			State:        netlink.NUD_PERMANENT,
+			Flags:        netlink.NTF_SELF,
+			IP:           peer,
+			HardwareAddr: make(net.HardwareAddr, 6),
+		}
+
+		if isAdd {
+			return netlink.NeighAppend(neigh)
+		}
+		return netlink.NeighDel(neigh)
+	})
+}
+
+// attachToBridge sets the interface named name as a port of bridge and
+// brings both up. It must be called from the network namespace.
+func attachToBridge(name string, bridge *netlink.Bridge) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	if err := netlink.LinkSetMaster(link, bridge); err != nil {
+		return err
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return err
+	}
+
+	br, err := netlink.LinkByName(bridge.Name)
+	if err != nil {
+		return err
+	}
+	return netlink.LinkSetUp(br)
+}
+
+func moveToNamespace(link netlink.Link, key string) error {
+	f, err := os.OpenFile(key, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed get network namespace %q: %v", key, err)
+	}
+	defer f.Close()
+
+	return netlink.LinkSetNsFd(link, int(f.Fd()))
+}
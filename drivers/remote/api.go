@@ -0,0 +1,110 @@
+package remote
+
+// Messages exchanged with the remote plugins. Every call is an HTTP POST of
+// the JSON encoded request to the path named after the method, such as
+// /NetworkDriver.CreateNetwork, and every response carries an Err field which
+// is empty on success.
+
+const (
+	activateMethod       = "Plugin.Activate"
+	createNetworkMethod  = "NetworkDriver.CreateNetwork"
+	updateNetworkMethod  = "NetworkDriver.UpdateNetwork"
+	deleteNetworkMethod  = "NetworkDriver.DeleteNetwork"
+	networkInfoMethod    = "NetworkDriver.NetworkInfo"
+	createEndpointMethod = "NetworkDriver.CreateEndpoint"
+	deleteEndpointMethod = "NetworkDriver.DeleteEndpoint"
+	joinMethod           = "NetworkDriver.Join"
+	leaveMethod          = "NetworkDriver.Leave"
+	capabilitiesMethod   = "NetworkDriver.GetCapabilities"
+
+	// networkDriverInterface is the interface remote plugins must claim to
+	// implement in the handshake.
+	networkDriverInterface = "NetworkDriver"
+)
+
+type response struct {
+	Err string
+}
+
+func (r *response) GetError() string {
+	return r.Err
+}
+
+type errorResponse interface {
+	GetError() string
+}
+
+type activateResponse struct {
+	response
+	Implements []string
+}
+
+type capabilitiesResponse struct {
+	response
+	IPv6        bool
+	PortMapping bool
+	MultiHost   bool
+	StaticIP    bool
+}
+
+type createNetworkRequest struct {
+	NetworkID string
+	Options   interface{}
+}
+
+type updateNetworkRequest struct {
+	NetworkID string
+	Options   interface{}
+}
+
+type deleteNetworkRequest struct {
+	NetworkID string
+}
+
+type networkInfoRequest struct {
+	NetworkID string
+}
+
+type networkInfoResponse struct {
+	response
+	Subnets     []string
+	Gateway     string
+	GatewayIPv6 string
+}
+
+type createEndpointRequest struct {
+	NetworkID  string
+	EndpointID string
+	Options    interface{}
+}
+
+type endpointInterface struct {
+	SrcName     string
+	DstName     string
+	Address     string
+	AddressIPv6 string
+	MacAddress  string
+}
+
+type createEndpointResponse struct {
+	response
+	Interfaces  []*endpointInterface
+	Gateway     string
+	GatewayIPv6 string
+}
+
+type deleteEndpointRequest struct {
+	NetworkID  string
+	EndpointID string
+}
+
+type joinRequest struct {
+	NetworkID  string
+	EndpointID string
+	SandboxKey string
+}
+
+type leaveRequest struct {
+	NetworkID  string
+	EndpointID string
+}
@@ -0,0 +1,60 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/docker/libnetwork/driverapi"
+)
+
+var (
+	// ErrNotNetworkDriver is returned when the plugin does not implement the
+	// network driver interface.
+	ErrNotNetworkDriver = errors.New("plugin does not implement the network driver interface")
+)
+
+// DriverError is returned when the plugin reports an error which does not
+// map to a driver api error.
+type DriverError string
+
+func (de DriverError) Error() string {
+	return fmt.Sprintf("remote driver error: %s", string(de))
+}
+
+// InvalidResponseError is returned when the plugin response cannot be
+// decoded.
+type InvalidResponseError string
+
+func (ire InvalidResponseError) Error() string {
+	return fmt.Sprintf("invalid plugin response: %s", string(ire))
+}
+
+// UnreachableError is returned when the plugin can not be reached, such as
+// while it restarts. The request may succeed once the plugin is back.
+type UnreachableError struct {
+	Err error
+}
+
+func (ue *UnreachableError) Error() string {
+	return fmt.Sprintf("remote plugin unreachable: %v", ue.Err)
+}
+
+// Retryable denotes the type of this error
+func (ue *UnreachableError) Retryable() {}
+
+// driverErrors are the driver api errors plugins may report by message.
+var driverErrors = []error{
+	driverapi.ErrEndpointExists,
+	driverapi.ErrNoNetwork,
+	driverapi.ErrNoEndpoint,
+}
+
+// pluginError returns the error reported by a plugin as msg.
+func pluginError(msg string) error {
+	for _, err := range driverErrors {
+		if err.Error() == msg {
+			return err
+		}
+	}
+	return DriverError(msg)
+}
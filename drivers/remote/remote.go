@@ -0,0 +1,346 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/sandbox"
+	"github.com/docker/libnetwork/types"
+)
+
+const (
+	// PluginDir is the directory where the unix sockets of the remote
+	// plugins are discovered. A plugin listening on <name>.sock provides
+	// the <name> network type.
+	PluginDir = "/run/docker/plugins"
+
+	pluginTimeout = 30 * time.Second
+)
+
+type driver struct {
+	networkType  string
+	client       *http.Client
+	capabilities driverapi.DriverCapability
+}
+
+// New provides a new instance of remote driver for the plugin listening on
+// the addr unix socket. The plugin must acknowledge the handshake, claiming
+// it implements the network driver interface. The capabilities the plugin
+// reports are queried once: a plugin which does not report any is assumed to
+// support all the features, and left to reject the ones it does not.
+func New(networkType, addr string) (driverapi.Driver, error) {
+	d := &driver{
+		networkType: networkType,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Dial: func(string, string) (net.Conn, error) {
+					return net.DialTimeout("unix", addr, pluginTimeout)
+				},
+			},
+			Timeout: pluginTimeout,
+		},
+	}
+
+	var res activateResponse
+	if err := d.call(context.Background(), activateMethod, nil, &res); err != nil {
+		return nil, err
+	}
+
+	implements := false
+	for _, i := range res.Implements {
+		if i == networkDriverInterface {
+			implements = true
+			break
+		}
+	}
+	if !implements {
+		return nil, ErrNotNetworkDriver
+	}
+
+	var caps capabilitiesResponse
+	if err := d.call(context.Background(), capabilitiesMethod, nil, &caps); err != nil {
+		log.Debugf("Remote plugin %s reported no capabilities: %v", networkType, err)
+		d.capabilities = driverapi.DriverCapability{IPv6: true, PortMapping: true, MultiHost: true, StaticIP: true}
+	} else {
+		d.capabilities = driverapi.DriverCapability{IPv6: caps.IPv6, PortMapping: caps.PortMapping, MultiHost: caps.MultiHost, StaticIP: caps.StaticIP}
+	}
+
+	return d, nil
+}
+
+// Discover provides a remote driver for each plugin found in dir which
+// acknowledges the handshake.
+func Discover(dir string) []driverapi.Driver {
+	socks, err := filepath.Glob(filepath.Join(dir, "*.sock"))
+	if err != nil {
+		log.Warnf("Failed to discover remote plugins in %s: %v", dir, err)
+		return nil
+	}
+
+	var drivers []driverapi.Driver
+	for _, sock := range socks {
+		name := strings.TrimSuffix(filepath.Base(sock), ".sock")
+		d, err := New(name, sock)
+		if err != nil {
+			log.Warnf("Ignoring remote plugin %s: %v", name, err)
+			continue
+		}
+		drivers = append(drivers, d)
+	}
+
+	return drivers
+}
+
+// call posts the request to the plugin method and decodes the plugin
+// response in res, returning the error the plugin reported if any.
+func (d *driver) call(ctx context.Context, method string, req interface{}, res errorResponse) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	hreq, err := http.NewRequest("POST", "http://plugin/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+
+	hres, err := d.client.Do(hreq.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &UnreachableError{Err: err}
+	}
+	defer hres.Body.Close()
+
+	data, err := ioutil.ReadAll(hres.Body)
+	if err != nil {
+		return err
+	}
+
+	// A failed reply is never a success, even with a valid body. It may
+	// carry the error the plugin reported though.
+	if hres.StatusCode != http.StatusOK {
+		if err := json.Unmarshal(data, res); err == nil {
+			if msg := res.GetError(); msg != "" {
+				return pluginError(msg)
+			}
+		}
+		return DriverError(fmt.Sprintf("%s: %s", hres.Status, strings.TrimSpace(string(data))))
+	}
+
+	if err := json.Unmarshal(data, res); err != nil {
+		return InvalidResponseError(fmt.Sprintf("%s: %v", method, err))
+	}
+
+	if msg := res.GetError(); msg != "" {
+		return pluginError(msg)
+	}
+
+	return nil
+}
+
+// Config is a no-op, remote plugins are configured on their own.
+func (d *driver) Config(option interface{}) error {
+	return nil
+}
+
+func (d *driver) ConfigRequired() bool {
+	return false
+}
+
+func (d *driver) CreateNetwork(id types.UUID, option interface{}) error {
+	return d.CreateNetworkWithContext(context.Background(), id, option)
+}
+
+func (d *driver) CreateNetworkWithContext(ctx context.Context, id types.UUID, option interface{}) error {
+	req := &createNetworkRequest{NetworkID: string(id), Options: option}
+	return d.call(ctx, createNetworkMethod, req, &response{})
+}
+
+// ValidateNetwork does not reach the plugin, which checks the options when
+// the network is created.
+func (d *driver) ValidateNetwork(option interface{}) error {
+	return nil
+}
+
+func (d *driver) UpdateNetwork(nid types.UUID, option interface{}) error {
+	req := &updateNetworkRequest{NetworkID: string(nid), Options: option}
+	return d.call(context.Background(), updateNetworkMethod, req, &response{})
+}
+
+func (d *driver) DeleteNetwork(nid types.UUID) error {
+	req := &deleteNetworkRequest{NetworkID: string(nid)}
+	return d.call(context.Background(), deleteNetworkMethod, req, &response{})
+}
+
+func (d *driver) NetworkInfo(nid types.UUID) (*driverapi.NetworkInfo, error) {
+	req := &networkInfoRequest{NetworkID: string(nid)}
+
+	var res networkInfoResponse
+	if err := d.call(context.Background(), networkInfoMethod, req, &res); err != nil {
+		return nil, err
+	}
+
+	return res.networkInfo()
+}
+
+func (d *driver) CreateEndpoint(nid, eid types.UUID, epOptions interface{}) (*sandbox.Info, error) {
+	return d.CreateEndpointWithContext(context.Background(), nid, eid, epOptions)
+}
+
+func (d *driver) CreateEndpointWithContext(ctx context.Context, nid, eid types.UUID, epOptions interface{}) (*sandbox.Info, error) {
+	req := &createEndpointRequest{NetworkID: string(nid), EndpointID: string(eid), Options: epOptions}
+
+	var res createEndpointResponse
+	if err := d.call(ctx, createEndpointMethod, req, &res); err != nil {
+		return nil, err
+	}
+
+	sinfo, err := res.sandboxInfo()
+	if err != nil {
+		// The plugin created an endpoint libnetwork cannot use
+		if e := d.DeleteEndpoint(nid, eid); e != nil {
+			log.Warnf("Failed to remove endpoint %s after invalid plugin response: %v", eid, e)
+		}
+		return nil, err
+	}
+
+	return sinfo, nil
+}
+
+// ValidateEndpoint does not reach the plugin, which checks the options when
+// the endpoint is created.
+func (d *driver) ValidateEndpoint(nid types.UUID, epOptions interface{}) error {
+	return nil
+}
+
+func (d *driver) DeleteEndpoint(nid, eid types.UUID) error {
+	req := &deleteEndpointRequest{NetworkID: string(nid), EndpointID: string(eid)}
+	return d.call(context.Background(), deleteEndpointMethod, req, &response{})
+}
+
+func (d *driver) Join(nid, eid types.UUID, sboxKey string) (*driverapi.JoinInfo, error) {
+	req := &joinRequest{NetworkID: string(nid), EndpointID: string(eid), SandboxKey: sboxKey}
+	if err := d.call(context.Background(), joinMethod, req, &response{}); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (d *driver) Leave(nid, eid types.UUID) error {
+	req := &leaveRequest{NetworkID: string(nid), EndpointID: string(eid)}
+	return d.call(context.Background(), leaveMethod, req, &response{})
+}
+
+// RestoreNetwork is a no-op: the plugin keeps its networks across the
+// restarts of the controller.
+func (d *driver) RestoreNetwork(nid types.UUID) error {
+	return nil
+}
+
+// RestoreEndpoint is a no-op: the plugin keeps its endpoints across the
+// restarts of the controller.
+func (d *driver) RestoreEndpoint(nid, eid types.UUID, sinfo *sandbox.Info) error {
+	return nil
+}
+
+func (d *driver) Type() string {
+	return d.networkType
+}
+
+func (d *driver) Capabilities() driverapi.DriverCapability {
+	return d.capabilities
+}
+
+// networkInfo decodes the settings the plugin applied to the network.
+func (res *networkInfoResponse) networkInfo() (*driverapi.NetworkInfo, error) {
+	info := &driverapi.NetworkInfo{}
+
+	for _, s := range res.Subnets {
+		_, subnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, InvalidResponseError(fmt.Sprintf("subnet %q: %v", s, err))
+		}
+		info.Subnets = append(info.Subnets, subnet)
+	}
+
+	var err error
+	if info.Gateway, err = parseIP(res.Gateway); err != nil {
+		return nil, err
+	}
+	if info.GatewayIPv6, err = parseIP(res.GatewayIPv6); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// sandboxInfo decodes the sandbox settings of the endpoint created by the
+// plugin.
+func (res *createEndpointResponse) sandboxInfo() (*sandbox.Info, error) {
+	sinfo := &sandbox.Info{}
+
+	for _, i := range res.Interfaces {
+		iface := &sandbox.Interface{SrcName: i.SrcName, DstName: i.DstName}
+
+		var err error
+		if iface.Address, err = parseCIDR(i.Address); err != nil {
+			return nil, err
+		}
+		if iface.AddressIPv6, err = parseCIDR(i.AddressIPv6); err != nil {
+			return nil, err
+		}
+		if i.MacAddress != "" {
+			if iface.MacAddress, err = net.ParseMAC(i.MacAddress); err != nil {
+				return nil, InvalidResponseError(fmt.Sprintf("mac address %q: %v", i.MacAddress, err))
+			}
+		}
+
+		sinfo.Interfaces = append(sinfo.Interfaces, iface)
+	}
+
+	var err error
+	if sinfo.Gateway, err = parseIP(res.Gateway); err != nil {
+		return nil, err
+	}
+	if sinfo.GatewayIPv6, err = parseIP(res.GatewayIPv6); err != nil {
+		return nil, err
+	}
+
+	return sinfo, nil
+}
+
+func parseCIDR(s string) (*net.IPNet, error) {
+	if s == "" {
+		return nil, nil
+	}
+	ip, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, InvalidResponseError(fmt.Sprintf("address %q: %v", s, err))
+	}
+	ipNet.IP = ip
+	return ipNet, nil
+}
+
+func parseIP(s string) (net.IP, error) {
+	if s == "" {
+		return nil, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, InvalidResponseError(fmt.Sprintf("ip %q", s))
+	}
+	return ip, nil
+}
@@ -0,0 +1,359 @@
+package remote
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/pkg/options"
+	"github.com/docker/libnetwork/types"
+)
+
+// fakePlugin is an in-process plugin serving the registered method handlers
+// on a unix socket.
+type fakePlugin struct {
+	sock     string
+	listener net.Listener
+	mux      *http.ServeMux
+}
+
+func newFakePlugin(t *testing.T, dir, name string, implements ...string) *fakePlugin {
+	sock := filepath.Join(dir, name+".sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("Failed to listen on %s: %v", sock, err)
+	}
+
+	p := &fakePlugin{sock: sock, listener: l, mux: http.NewServeMux()}
+	p.handle(activateMethod, func(req map[string]interface{}) interface{} {
+		return map[string]interface{}{"Implements": implements}
+	})
+
+	go http.Serve(l, p.mux)
+	return p
+}
+
+// handle serves method with fn, which gets the decoded request and returns
+// the response to encode.
+func (p *fakePlugin) handle(method string, fn func(req map[string]interface{}) interface{}) {
+	p.mux.HandleFunc("/"+method, func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(fn(req))
+	})
+}
+
+func (p *fakePlugin) close() {
+	p.listener.Close()
+}
+
+func newPluginDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "remote-plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestRemoteHandshake(t *testing.T) {
+	dir := newPluginDir(t)
+	defer os.RemoveAll(dir)
+
+	p := newFakePlugin(t, dir, "fake", networkDriverInterface)
+	defer p.close()
+
+	d, err := New("fake", p.sock)
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+
+	if d.Type() != "fake" {
+		t.Fatalf("Expected network type fake, got %s", d.Type())
+	}
+
+	other := newFakePlugin(t, dir, "other", "VolumeDriver")
+	defer other.close()
+
+	if _, err := New("other", other.sock); err != ErrNotNetworkDriver {
+		t.Fatalf("Expected %v, got %v", ErrNotNetworkDriver, err)
+	}
+}
+
+func TestRemoteCapabilities(t *testing.T) {
+	dir := newPluginDir(t)
+	defer os.RemoveAll(dir)
+
+	p := newFakePlugin(t, dir, "fake", networkDriverInterface)
+	defer p.close()
+	p.handle(capabilitiesMethod, func(req map[string]interface{}) interface{} {
+		return map[string]interface{}{"MultiHost": true, "StaticIP": true}
+	})
+
+	d, err := New("fake", p.sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps := d.Capabilities(); caps != (driverapi.DriverCapability{MultiHost: true, StaticIP: true}) {
+		t.Fatalf("Unexpected capabilities %+v", caps)
+	}
+
+	// A plugin reporting no capabilities is assumed to support all
+	other := newFakePlugin(t, dir, "other", networkDriverInterface)
+	defer other.close()
+
+	d, err = New("other", other.sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps := d.Capabilities(); caps != (driverapi.DriverCapability{IPv6: true, PortMapping: true, MultiHost: true, StaticIP: true}) {
+		t.Fatalf("Unexpected capabilities %+v", caps)
+	}
+}
+
+func TestRemoteDiscover(t *testing.T) {
+	dir := newPluginDir(t)
+	defer os.RemoveAll(dir)
+
+	p := newFakePlugin(t, dir, "fake", networkDriverInterface)
+	defer p.close()
+
+	other := newFakePlugin(t, dir, "other", "VolumeDriver")
+	defer other.close()
+
+	drivers := Discover(dir)
+	if len(drivers) != 1 || drivers[0].Type() != "fake" {
+		t.Fatalf("Expected to discover the fake plugin only, got %v", drivers)
+	}
+}
+
+func TestRemoteCreateNetwork(t *testing.T) {
+	dir := newPluginDir(t)
+	defer os.RemoveAll(dir)
+
+	p := newFakePlugin(t, dir, "fake", networkDriverInterface)
+	defer p.close()
+
+	var received map[string]interface{}
+	p.handle(createNetworkMethod, func(req map[string]interface{}) interface{} {
+		received = req
+		return map[string]interface{}{}
+	})
+	p.handle(deleteNetworkMethod, func(req map[string]interface{}) interface{} {
+		return map[string]interface{}{"Err": driverapi.ErrNoNetwork.Error()}
+	})
+
+	d, err := New("fake", p.sock)
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+
+	if err := d.CreateNetwork("dummy", options.Generic{"Mode": "vxlan"}); err != nil {
+		t.Fatalf("Failed to create network: %v", err)
+	}
+
+	if received["NetworkID"] != "dummy" {
+		t.Fatalf("Expected network id dummy, got %v", received["NetworkID"])
+	}
+	if opts, ok := received["Options"].(map[string]interface{}); !ok || opts["Mode"] != "vxlan" {
+		t.Fatalf("Network options not forwarded: %v", received["Options"])
+	}
+
+	// Plugin errors map to the driver api errors
+	if err := d.DeleteNetwork("dummy"); err != driverapi.ErrNoNetwork {
+		t.Fatalf("Expected %v, got %v", driverapi.ErrNoNetwork, err)
+	}
+}
+
+func TestRemoteUpdateNetwork(t *testing.T) {
+	dir := newPluginDir(t)
+	defer os.RemoveAll(dir)
+
+	p := newFakePlugin(t, dir, "fake", networkDriverInterface)
+	defer p.close()
+
+	var received map[string]interface{}
+	p.handle(updateNetworkMethod, func(req map[string]interface{}) interface{} {
+		received = req
+		return map[string]interface{}{}
+	})
+
+	d, err := New("fake", p.sock)
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+
+	if err := d.UpdateNetwork("dummy", options.Generic{"Mode": "vlan"}); err != nil {
+		t.Fatalf("Failed to update network: %v", err)
+	}
+
+	if received["NetworkID"] != "dummy" {
+		t.Fatalf("Expected network id dummy, got %v", received["NetworkID"])
+	}
+	if opts, ok := received["Options"].(map[string]interface{}); !ok || opts["Mode"] != "vlan" {
+		t.Fatalf("Network options not forwarded: %v", received["Options"])
+	}
+}
+
+func TestRemoteError(t *testing.T) {
+	dir := newPluginDir(t)
+	defer os.RemoveAll(dir)
+
+	p := newFakePlugin(t, dir, "fake", networkDriverInterface)
+	defer p.close()
+
+	p.handle(joinMethod, func(req map[string]interface{}) interface{} {
+		return map[string]interface{}{"Err": "no room left"}
+	})
+
+	d, err := New("fake", p.sock)
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+
+	_, err = d.Join("dummy", "ep", "/var/run/netns/dummy")
+	if de, ok := err.(DriverError); !ok || string(de) != "no room left" {
+		t.Fatalf("Expected driver error, got %v", err)
+	}
+
+	// Methods the plugin does not serve fail as well
+	if err := d.Leave("dummy", "ep"); err == nil {
+		t.Fatal("Expected leave to fail")
+	}
+
+	// So does a failed reply without error, its body being valid JSON
+	p.mux.HandleFunc("/"+deleteNetworkMethod, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message":"boom"}`))
+	})
+	err = d.DeleteNetwork("dummy")
+	if de, ok := err.(DriverError); !ok || string(de) != `500 Internal Server Error: {"message":"boom"}` {
+		t.Fatalf("Expected driver error, got %v", err)
+	}
+
+	// A plugin which is not listening may come back, the failure is
+	// retryable
+	_, err = New("gone", filepath.Join(dir, "gone.sock"))
+	if _, ok := err.(*UnreachableError); !ok || !types.IsRetryable(err) {
+		t.Fatalf("Expected a retryable UnreachableError, got %v", err)
+	}
+}
+
+func TestRemoteCreateEndpoint(t *testing.T) {
+	dir := newPluginDir(t)
+	defer os.RemoveAll(dir)
+
+	p := newFakePlugin(t, dir, "fake", networkDriverInterface)
+	defer p.close()
+
+	p.handle(createEndpointMethod, func(req map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"Interfaces": []map[string]interface{}{{
+				"SrcName":    "veth0",
+				"DstName":    "eth0",
+				"Address":    "10.55.0.2/24",
+				"MacAddress": "02:42:0a:37:00:02",
+			}},
+			"Gateway": "10.55.0.1",
+		}
+	})
+
+	d, err := New("fake", p.sock)
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+
+	sinfo, err := d.CreateEndpoint("dummy", "ep", nil)
+	if err != nil {
+		t.Fatalf("Failed to create endpoint: %v", err)
+	}
+
+	if len(sinfo.Interfaces) != 1 {
+		t.Fatalf("Expected one interface, got %d", len(sinfo.Interfaces))
+	}
+	iface := sinfo.Interfaces[0]
+	if iface.SrcName != "veth0" || iface.DstName != "eth0" {
+		t.Fatalf("Unexpected interface names %s and %s", iface.SrcName, iface.DstName)
+	}
+	if iface.Address.String() != "10.55.0.2/24" {
+		t.Fatalf("Unexpected interface address %v", iface.Address)
+	}
+	if iface.MacAddress.String() != "02:42:0a:37:00:02" {
+		t.Fatalf("Unexpected interface mac address %v", iface.MacAddress)
+	}
+	if !sinfo.Gateway.Equal(net.ParseIP("10.55.0.1")) {
+		t.Fatalf("Unexpected gateway %v", sinfo.Gateway)
+	}
+}
+
+func TestRemoteCreateEndpointInvalid(t *testing.T) {
+	dir := newPluginDir(t)
+	defer os.RemoveAll(dir)
+
+	p := newFakePlugin(t, dir, "fake", networkDriverInterface)
+	defer p.close()
+
+	p.handle(createEndpointMethod, func(req map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"Interfaces": []map[string]interface{}{{"Address": "10.55.0.2"}},
+		}
+	})
+
+	deleted := false
+	p.handle(deleteEndpointMethod, func(req map[string]interface{}) interface{} {
+		deleted = true
+		return map[string]interface{}{}
+	})
+
+	d, err := New("fake", p.sock)
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+
+	if _, err := d.CreateEndpoint("dummy", "ep", nil); err == nil {
+		t.Fatal("Expected endpoint creation to fail")
+	} else if _, ok := err.(InvalidResponseError); !ok {
+		t.Fatalf("Expected invalid response error, got %v", err)
+	}
+
+	// The endpoint libnetwork cannot use is removed from the plugin
+	if !deleted {
+		t.Fatal("Endpoint with invalid settings not deleted")
+	}
+}
+
+func TestRemoteNetworkInfo(t *testing.T) {
+	dir := newPluginDir(t)
+	defer os.RemoveAll(dir)
+
+	p := newFakePlugin(t, dir, "fake", networkDriverInterface)
+	defer p.close()
+
+	p.handle(networkInfoMethod, func(req map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"Subnets": []string{"10.55.0.0/24"},
+			"Gateway": "10.55.0.1",
+		}
+	})
+
+	d, err := New("fake", p.sock)
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+
+	info, err := d.NetworkInfo("dummy")
+	if err != nil {
+		t.Fatalf("Failed to get network info: %v", err)
+	}
+
+	if len(info.Subnets) != 1 || info.Subnets[0].String() != "10.55.0.0/24" {
+		t.Fatalf("Unexpected network subnets %v", info.Subnets)
+	}
+	if !info.Gateway.Equal(net.ParseIP("10.55.0.1")) {
+		t.Fatalf("Unexpected gateway %v", info.Gateway)
+	}
+}
@@ -0,0 +1,93 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNetworkExists error is returned when a network is created with the id of an existing one.
+	ErrNetworkExists = errors.New("network already exists")
+
+	// ErrNoSubnet error is returned when a network is created without a subnet.
+	ErrNoSubnet = errors.New("a subnet is required")
+
+	// ErrInvalidGateway is returned when the user provided gateway is not part of the subnet.
+	ErrInvalidGateway = errors.New("gateway ip must be part of the network")
+
+	// ErrNotIPv4 error is returned when a subnet or a tunnel address is not an IPv4 one.
+	ErrNotIPv4 = errors.New("tunnel networks are IPv4 only")
+
+	// ErrNoPeerRemote error is returned when a peer has no remote address.
+	ErrNoPeerRemote = errors.New("a peer requires a remote address")
+
+	// ErrNoPeerSubnet error is returned when a peer routes no subnet.
+	ErrNoPeerSubnet = errors.New("a peer requires at least one subnet")
+
+	// ErrInvalidEndpointConfig error is returned when a endpoint create is attempted with an invalid endpoint configuration.
+	ErrInvalidEndpointConfig = errors.New("trying to create an endpoint with an invalid endpoint configuration")
+
+	// ErrIfaceName error is returned when a new name could not be generated.
+	ErrIfaceName = errors.New("failed to find name for new interface")
+
+	// ErrIPOutOfRange is returned when the address requested for an endpoint is outside the network subnet.
+	ErrIPOutOfRange = errors.New("requested endpoint address is outside the network subnet")
+)
+
+// InvalidModeError is returned when the requested tunnel mode is not
+// supported.
+type InvalidModeError string
+
+func (ime InvalidModeError) Error() string {
+	return fmt.Sprintf("invalid tunnel mode: %s", string(ime))
+}
+
+// InvalidParameter denotes the type of this error
+func (ime InvalidModeError) InvalidParameter() {}
+
+// PeerSubnetOverlapError is returned when the subnet a peer routes overlaps
+// the network subnet or the subnet of another peer.
+type PeerSubnetOverlapError string
+
+func (psoe PeerSubnetOverlapError) Error() string {
+	return fmt.Sprintf("peer subnet %s overlaps another subnet of the network", string(psoe))
+}
+
+// InvalidParameter denotes the type of this error
+func (psoe PeerSubnetOverlapError) InvalidParameter() {}
+
+// TunnelUnavailableError is returned when the tunnels of the requested mode
+// can not be created, such as when the kernel module providing them is
+// missing.
+type TunnelUnavailableError struct {
+	Mode string
+	Err  error
+}
+
+func (tue *TunnelUnavailableError) Error() string {
+	return fmt.Sprintf("%s tunnels are not available: %v", tue.Mode, tue.Err)
+}
+
+// Unwrap returns the error the tunnel creation failed with.
+func (tue *TunnelUnavailableError) Unwrap() error {
+	return tue.Err
+}
+
+// Unavailable denotes the type of this error
+func (tue *TunnelUnavailableError) Unavailable() {}
+
+// ActiveEndpointsError is returned when there are
+// still active endpoints in the network being deleted.
+type ActiveEndpointsError string
+
+func (aee ActiveEndpointsError) Error() string {
+	return fmt.Sprintf("network %s has active endpoint", string(aee))
+}
+
+// EndpointNotFoundError is returned when the no endpoint
+// with the passed endpoint id is found.
+type EndpointNotFoundError string
+
+func (enfe EndpointNotFoundError) Error() string {
+	return fmt.Sprintf("endpoint not found: %s", string(enfe))
+}
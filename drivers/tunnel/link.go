@@ -0,0 +1,100 @@
+package tunnel
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink/nl"
+)
+
+const (
+	modeIPIP = "ipip"
+	modeGRE  = "gre"
+
+	// Kernel attributes of the ipip and gre tunnels
+	iflaIPTunLocal  = 2
+	iflaIPTunRemote = 3
+	iflaIPTunTTL    = 4
+	iflaGRELocal    = 6
+	iflaGRERemote   = 7
+	iflaGRETTL      = 8
+
+	// TTL of the encapsulating packets, the kernel default inherits the
+	// TTL of the encapsulated ones which breaks the path MTU discovery
+	tunnelTTL = 64
+)
+
+// tunnelKind describes the tunnels of a mode, as the kernel requests them.
+type tunnelKind struct {
+	// module is the kernel module providing the link kind.
+	module string
+	// local, remote and ttl are the link attributes setting the tunnel
+	// endpoints and the TTL of the encapsulating packets.
+	local, remote, ttl int
+}
+
+var tunnelKinds = map[string]*tunnelKind{
+	modeIPIP: {module: "ipip", local: iflaIPTunLocal, remote: iflaIPTunRemote, ttl: iflaIPTunTTL},
+	modeGRE:  {module: "ip_gre", local: iflaGRELocal, remote: iflaGRERemote, ttl: iflaGRETTL},
+}
+
+// checkModule verifies the kernel module providing the tunnels of mode is
+// loaded, built in, or installed so that the kernel loads it on the first
+// tunnel creation.
+func checkModule(mode string) error {
+	module := tunnelKinds[mode].module
+	if _, err := os.Stat(filepath.Join("/sys/module", module)); err == nil {
+		return nil
+	}
+
+	release, err := ioutil.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return &TunnelUnavailableError{Mode: mode, Err: err}
+	}
+	dir := filepath.Join("/lib/modules", strings.TrimSpace(string(release)))
+	for _, index := range []string{"modules.builtin", "modules.dep"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, index))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			path := strings.SplitN(line, ":", 2)[0]
+			if base := filepath.Base(path); base == module+".ko" || strings.HasPrefix(base, module+".ko.") {
+				return nil
+			}
+		}
+	}
+
+	return &TunnelUnavailableError{Mode: mode, Err: os.ErrNotExist}
+}
+
+// addTunnel creates the tunnel named name from local to remote. The netlink
+// package does not know the tunnel link kinds, the request is built here
+// instead. A local address left nil lets the routing pick the source.
+func addTunnel(name, mode string, local, remote net.IP) error {
+	kind := tunnelKinds[mode]
+
+	req := nl.NewNetlinkRequest(syscall.RTM_NEWLINK, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+	req.AddData(nl.NewIfInfomsg(syscall.AF_UNSPEC))
+	req.AddData(nl.NewRtAttr(syscall.IFLA_IFNAME, nl.ZeroTerminated(name)))
+
+	linkInfo := nl.NewRtAttr(syscall.IFLA_LINKINFO, nil)
+	nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_KIND, nl.NonZeroTerminated(mode))
+	data := nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_DATA, nil)
+	if local != nil {
+		nl.NewRtAttrChild(data, kind.local, []byte(local.To4()))
+	}
+	nl.NewRtAttrChild(data, kind.remote, []byte(remote.To4()))
+	nl.NewRtAttrChild(data, kind.ttl, nl.Uint8Attr(tunnelTTL))
+	req.AddData(linkInfo)
+
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	if err == syscall.EOPNOTSUPP {
+		return &TunnelUnavailableError{Mode: mode, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,665 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ipallocator"
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/pkg/options"
+	"github.com/docker/libnetwork/sandbox"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	networkType   = "tunnel"
+	ifaceLen      = 7
+	bridgePrefix  = "tnbr"
+	tunnelPrefix  = "tnl"
+	vethPrefix    = "veth"
+	containerVeth = "eth0"
+)
+
+var ipAllocator *ipallocator.IPAllocator // Default IPAM for the networks
+
+// Peer is a remote host reached through a tunnel, which routes the listed
+// container subnets.
+type Peer struct {
+	// Remote is the address of the host the tunnel ends at.
+	Remote net.IP
+	// Subnets routed through the tunnel, those of the containers on the
+	// remote host.
+	Subnets []*net.IPNet
+}
+
+// NetworkConfiguration represents the user specified configuration for the
+// tunnel networks
+type NetworkConfiguration struct {
+	// Mode of the tunnels: ipip (default) or gre.
+	Mode string
+	// Subnet of the local containers, the endpoints addresses are allocated
+	// from.
+	Subnet *net.IPNet
+	// Gateway of the local containers, the address of the network bridge.
+	// The first address of the subnet is used when unset.
+	Gateway net.IP
+	// Local is the address of this host the tunnels start from, picked by
+	// the routing when unset.
+	Local net.IP
+	// Peers the tunnels are created to, one per peer.
+	Peers []Peer
+}
+
+// EndpointConfiguration represents the user specified configuration for the sandbox endpoint
+type EndpointConfiguration struct {
+	MacAddress  net.HardwareAddr
+	AddressIPv4 net.IP
+}
+
+type tunnelEndpoint struct {
+	id       types.UUID
+	port     *sandbox.Interface
+	hostVeth string
+}
+
+type tunnelNetwork struct {
+	id        types.UUID
+	config    *NetworkConfiguration
+	bridge    string   // Bridge the endpoints are attached to
+	gateway   net.IP   // Address of the bridge
+	tunnels   []string // Tunnel interfaces, in the peers order
+	endpoints map[types.UUID]*tunnelEndpoint
+	sync.Mutex
+}
+
+type driver struct {
+	networks map[types.UUID]*tunnelNetwork
+	sync.Mutex
+}
+
+func init() {
+	ipAllocator = ipallocator.New()
+}
+
+// New provides a new instance of tunnel driver. Its networks connect the
+// local containers to the containers of the remote hosts over ipip or gre
+// tunnels, routing the subnets of each peer through its tunnel. The host
+// must forward the IPv4 traffic.
+func New() (string, driverapi.Driver) {
+	return networkType, &driver{networks: make(map[types.UUID]*tunnelNetwork)}
+}
+
+// Config is a no-op, the networks carry all their settings.
+func (d *driver) Config(option interface{}) error {
+	return nil
+}
+
+func (d *driver) ConfigRequired() bool {
+	return false
+}
+
+func (d *driver) CreateNetwork(id types.UUID, option interface{}) error {
+	return d.CreateNetworkWithContext(context.Background(), id, option)
+}
+
+func (d *driver) CreateNetworkWithContext(ctx context.Context, id types.UUID, option interface{}) error {
+	var err error
+
+	config, err := parseNetworkOptions(option)
+	if err != nil {
+		return err
+	}
+
+	if err = validate(config); err != nil {
+		return err
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	if _, ok := d.networks[id]; ok {
+		return ErrNetworkExists
+	}
+
+	n := &tunnelNetwork{id: id, config: config, endpoints: make(map[types.UUID]*tunnelEndpoint)}
+
+	if err = ipAllocator.RequestPool(config.Subnet, nil); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			ipAllocator.ReleasePool(config.Subnet)
+		}
+	}()
+
+	if n.gateway, err = ipAllocator.RequestAddress(config.Subnet, config.Gateway); err != nil {
+		return err
+	}
+
+	// On failure remove the interfaces created so far, along with their
+	// routes
+	defer func() {
+		if err != nil {
+			n.removeLinks()
+		}
+	}()
+
+	if err = n.setupBridge(); err != nil {
+		return err
+	}
+
+	for _, peer := range config.Peers {
+		if err = n.setupTunnel(peer); err != nil {
+			return err
+		}
+	}
+
+	d.networks[id] = n
+
+	return nil
+}
+
+// setupBridge creates the bridge the endpoints of the network are attached
+// to, with the gateway address.
+func (n *tunnelNetwork) setupBridge() error {
+	name, err := generateIfaceName(bridgePrefix)
+	if err != nil {
+		return err
+	}
+
+	br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: name}}
+	if err := netlink.LinkAdd(br); err != nil {
+		return err
+	}
+	n.bridge = name
+
+	addr := &netlink.Addr{IPNet: &net.IPNet{IP: n.gateway, Mask: n.config.Subnet.Mask}}
+	if err := netlink.AddrAdd(br, addr); err != nil {
+		return err
+	}
+
+	return netlink.LinkSetUp(br)
+}
+
+// setupTunnel creates the tunnel to peer, and routes the peer subnets through
+// it.
+func (n *tunnelNetwork) setupTunnel(peer Peer) error {
+	name, err := generateIfaceName(tunnelPrefix)
+	if err != nil {
+		return err
+	}
+
+	if err := addTunnel(name, n.config.Mode, n.config.Local, peer.Remote); err != nil {
+		return err
+	}
+	n.tunnels = append(n.tunnels, name)
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return err
+	}
+
+	for _, subnet := range peer.Subnets {
+		route := &netlink.Route{LinkIndex: link.Attrs().Index, Scope: netlink.SCOPE_LINK, Dst: subnet}
+		if err := netlink.RouteAdd(route); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeLinks deletes the tunnels and the bridge of the network, their
+// routes going along.
+func (n *tunnelNetwork) removeLinks() {
+	for _, name := range append(n.tunnels, n.bridge) {
+		if name == "" {
+			continue
+		}
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			continue
+		}
+		if err := netlink.LinkDel(link); err != nil {
+			log.Warnf("Failed to remove interface %s of network %s: %v", name, n.id, err)
+		}
+	}
+}
+
+// ValidateNetwork checks the network options, and that the kernel provides
+// the tunnels.
+func (d *driver) ValidateNetwork(option interface{}) error {
+	config, err := parseNetworkOptions(option)
+	if err != nil {
+		return err
+	}
+	return validate(config)
+}
+
+// validate checks the network configuration, defaulting the mode, and
+// normalizes the subnets.
+func validate(config *NetworkConfiguration) error {
+	if config.Mode == "" {
+		config.Mode = modeIPIP
+	}
+	if _, ok := tunnelKinds[config.Mode]; !ok {
+		return InvalidModeError(config.Mode)
+	}
+
+	if config.Subnet == nil {
+		return ErrNoSubnet
+	}
+	if config.Subnet.IP.To4() == nil || (config.Local != nil && config.Local.To4() == nil) {
+		return ErrNotIPv4
+	}
+	config.Subnet = normalize(config.Subnet)
+
+	if config.Gateway != nil && !config.Subnet.Contains(config.Gateway) {
+		return ErrInvalidGateway
+	}
+
+	// The subnets of the peers are routed away from the local ones
+	routed := []*net.IPNet{config.Subnet}
+	peers := make([]Peer, 0, len(config.Peers))
+	for _, peer := range config.Peers {
+		if peer.Remote == nil {
+			return ErrNoPeerRemote
+		}
+		if peer.Remote.To4() == nil {
+			return ErrNotIPv4
+		}
+		if len(peer.Subnets) == 0 {
+			return ErrNoPeerSubnet
+		}
+		p := Peer{Remote: peer.Remote}
+		for _, subnet := range peer.Subnets {
+			subnet = normalize(subnet)
+			for _, r := range routed {
+				if netutils.NetworkOverlaps(r, subnet) {
+					return PeerSubnetOverlapError(subnet.String())
+				}
+			}
+			routed = append(routed, subnet)
+			p.Subnets = append(p.Subnets, subnet)
+		}
+		peers = append(peers, p)
+	}
+	config.Peers = peers
+
+	return checkModule(config.Mode)
+}
+
+func normalize(subnet *net.IPNet) *net.IPNet {
+	return &net.IPNet{IP: subnet.IP.Mask(subnet.Mask), Mask: subnet.Mask}
+}
+
+// UpdateNetwork rejects any change, the options are fixed at the network
+// creation.
+func (d *driver) UpdateNetwork(nid types.UUID, option interface{}) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	defer n.Unlock()
+
+	upd, _, err := options.UpdateModel(option, n.config)
+	if err != nil {
+		return err
+	}
+
+	// Compare the settings as normalized at the network creation
+	config := upd.(*NetworkConfiguration)
+	if err := validate(config); err != nil {
+		return err
+	}
+	_, changed, err := options.UpdateModel(config, n.config)
+	if err != nil {
+		return err
+	}
+	if len(changed) != 0 {
+		return driverapi.ImmutableOptionError(changed[0])
+	}
+
+	return nil
+}
+
+func (d *driver) DeleteNetwork(nid types.UUID) error {
+	d.Lock()
+	defer d.Unlock()
+
+	n, ok := d.networks[nid]
+	if !ok {
+		return driverapi.ErrNoNetwork
+	}
+
+	// Cannot remove network if endpoints are still present
+	n.Lock()
+	numEps := len(n.endpoints)
+	n.Unlock()
+	if numEps != 0 {
+		return ActiveEndpointsError(nid)
+	}
+
+	delete(d.networks, nid)
+
+	n.removeLinks()
+
+	if err := ipAllocator.ReleaseAddress(n.config.Subnet, n.gateway); err != nil {
+		log.Warnf("Failed to release the gateway of network %s: %v", nid, err)
+	}
+	if err := ipAllocator.ReleasePool(n.config.Subnet); err != nil {
+		log.Warnf("Failed to release the pool of network %s: %v", nid, err)
+	}
+
+	return nil
+}
+
+func (d *driver) NetworkInfo(nid types.UUID) (*driverapi.NetworkInfo, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &driverapi.NetworkInfo{
+		Subnets: []*net.IPNet{netutils.GetIPNetCopy(n.config.Subnet)},
+		Gateway: netutils.GetIPCopy(n.gateway),
+	}, nil
+}
+
+func (d *driver) CreateEndpoint(nid, eid types.UUID, epOptions interface{}) (*sandbox.Info, error) {
+	return d.CreateEndpointWithContext(context.Background(), nid, eid, epOptions)
+}
+
+func (d *driver) CreateEndpointWithContext(ctx context.Context, nid, eid types.UUID, epOptions interface{}) (*sandbox.Info, error) {
+	var err error
+
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+	config := n.config
+
+	epConfig, err := validateEndpoint(config, epOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create and add the endpoint
+	n.Lock()
+	if _, ok := n.endpoints[eid]; ok {
+		n.Unlock()
+		return nil, driverapi.ErrEndpointExists
+	}
+	endpoint := &tunnelEndpoint{id: eid}
+	n.endpoints[eid] = endpoint
+	n.Unlock()
+
+	// On failure make sure to remove the endpoint
+	defer func() {
+		if err != nil {
+			n.Lock()
+			delete(n.endpoints, eid)
+			n.Unlock()
+		}
+	}()
+
+	var reqIP net.IP
+	if epConfig != nil && epConfig.AddressIPv4 != nil {
+		reqIP = epConfig.AddressIPv4
+	}
+
+	ip, err := requestIP(ipAllocator, config.Subnet, reqIP)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			ipAllocator.ReleaseAddress(config.Subnet, ip)
+		}
+	}()
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	name1, err := generateIfaceName(vethPrefix)
+	if err != nil {
+		return nil, err
+	}
+	name2, err := generateIfaceName(vethPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name1, TxQLen: 0}, PeerName: name2}
+	if err = netlink.LinkAdd(veth); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			netlink.LinkDel(veth)
+		}
+	}()
+
+	host, err := netlink.LinkByName(name1)
+	if err != nil {
+		return nil, err
+	}
+	if err = netlink.LinkSetMaster(host, &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: n.bridge}}); err != nil {
+		return nil, err
+	}
+	if err = netlink.LinkSetUp(host); err != nil {
+		return nil, err
+	}
+
+	sbox, err := netlink.LinkByName(name2)
+	if err != nil {
+		return nil, err
+	}
+	mac := netutils.GenerateMACFromIP(ip)
+	if epConfig != nil && epConfig.MacAddress != nil {
+		mac = epConfig.MacAddress
+	}
+	if err = netlink.LinkSetHardwareAddr(sbox, mac); err != nil {
+		return nil, err
+	}
+
+	intf := &sandbox.Interface{}
+	intf.SrcName = name2
+	intf.DstName = containerVeth
+	intf.MacAddress = mac
+	intf.Address = &net.IPNet{IP: ip, Mask: config.Subnet.Mask}
+
+	endpoint.hostVeth = name1
+	endpoint.port = intf
+
+	// The host routes the traffic to the peers through the tunnels
+	return &sandbox.Info{
+		Interfaces: []*sandbox.Interface{intf},
+		Gateway:    netutils.GetIPCopy(n.gateway),
+	}, nil
+}
+
+func (d *driver) ValidateEndpoint(nid types.UUID, epOptions interface{}) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+
+	_, err = validateEndpoint(n.config, epOptions)
+	return err
+}
+
+func validateEndpoint(config *NetworkConfiguration, epOptions interface{}) (*EndpointConfiguration, error) {
+	epConfig, err := parseEndpointOptions(epOptions)
+	if err != nil {
+		return nil, err
+	}
+	if epConfig != nil && epConfig.AddressIPv4 != nil && !config.Subnet.Contains(epConfig.AddressIPv4) {
+		return nil, ErrIPOutOfRange
+	}
+	return epConfig, nil
+}
+
+func (d *driver) DeleteEndpoint(nid, eid types.UUID) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	ep, ok := n.endpoints[eid]
+	if !ok {
+		n.Unlock()
+		return EndpointNotFoundError(eid)
+	}
+	delete(n.endpoints, eid)
+	n.Unlock()
+
+	if err := ipAllocator.ReleaseAddress(n.config.Subnet, ep.port.Address.IP); err != nil {
+		log.Warnf("Failed to release the address of endpoint %s: %v", eid, err)
+	}
+
+	// Try removal of link. Discard error: link pair might have
+	// already been deleted by sandbox delete.
+	if link, err := netlink.LinkByName(ep.hostVeth); err == nil {
+		netlink.LinkDel(link)
+	}
+
+	return nil
+}
+
+// Join is a no-op, the endpoint is attached to the bridge as soon as it is
+// created.
+func (d *driver) Join(nid, eid types.UUID, sboxKey string) (*driverapi.JoinInfo, error) {
+	if _, err := d.getEndpoint(nid, eid); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (d *driver) Leave(nid, eid types.UUID) error {
+	_, err := d.getEndpoint(nid, eid)
+	return err
+}
+
+// RestoreNetwork fails with ErrRestoreNotSupported: the configuration the
+// network was created with is not kept across restarts.
+func (d *driver) RestoreNetwork(nid types.UUID) error {
+	return driverapi.ErrRestoreNotSupported
+}
+
+// RestoreEndpoint fails with ErrRestoreNotSupported, as RestoreNetwork does.
+func (d *driver) RestoreEndpoint(nid, eid types.UUID, sinfo *sandbox.Info) error {
+	return driverapi.ErrRestoreNotSupported
+}
+
+func (d *driver) Type() string {
+	return networkType
+}
+
+func (d *driver) Capabilities() driverapi.DriverCapability {
+	return driverapi.DriverCapability{MultiHost: true, StaticIP: true, Privileged: true}
+}
+
+func (d *driver) getNetwork(nid types.UUID) (*tunnelNetwork, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	n, ok := d.networks[nid]
+	if !ok {
+		return nil, driverapi.ErrNoNetwork
+	}
+	return n, nil
+}
+
+func (d *driver) getEndpoint(nid, eid types.UUID) (*tunnelEndpoint, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Lock()
+	defer n.Unlock()
+
+	ep, ok := n.endpoints[eid]
+	if !ok {
+		return nil, EndpointNotFoundError(eid)
+	}
+	return ep, nil
+}
+
+func parseNetworkOptions(option interface{}) (*NetworkConfiguration, error) {
+	switch opt := option.(type) {
+	case options.Generic:
+		opaqueConfig, err := options.GenerateFromModel(opt, &NetworkConfiguration{})
+		if err != nil {
+			return nil, err
+		}
+		return opaqueConfig.(*NetworkConfiguration), nil
+	case *NetworkConfiguration:
+		// The configuration is normalized, leave the caller one alone
+		config := *opt
+		return &config, nil
+	default:
+		return &NetworkConfiguration{}, nil
+	}
+}
+
+func parseEndpointOptions(epOptions interface{}) (*EndpointConfiguration, error) {
+	if epOptions == nil {
+		return nil, nil
+	}
+	switch opt := epOptions.(type) {
+	case options.Generic:
+		opaqueConfig, err := options.GenerateFromModel(opt, &EndpointConfiguration{})
+		if err != nil {
+			return nil, err
+		}
+		return opaqueConfig.(*EndpointConfiguration), nil
+	case *EndpointConfiguration:
+		return opt, nil
+	default:
+		return nil, ErrInvalidEndpointConfig
+	}
+}
+
+// requestIP requests the passed address, or the next available one when nil.
+func requestIP(ipam ipamapi.IPAM, network *net.IPNet, ip net.IP) (net.IP, error) {
+	allocated, err := ipam.RequestAddress(network, ip)
+	if err == ipamapi.ErrIPOutOfRange {
+		return nil, ErrIPOutOfRange
+	}
+	return allocated, err
+}
+
+func generateIfaceName(prefix string) (string, error) {
+	for i := 0; i < 3; i++ {
+		name, err := netutils.GenerateRandomName(prefix, ifaceLen)
+		if err != nil {
+			continue
+		}
+		if _, err := net.InterfaceByName(name); err != nil {
+			if strings.Contains(err.Error(), "no such") {
+				return name, nil
+			}
+			return "", err
+		}
+	}
+	return "", ErrIfaceName
+}
@@ -0,0 +1,150 @@
+package tunnel
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+func getSubnet(t *testing.T, cidr string) *net.IPNet {
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return subnet
+}
+
+func TestCreateNetworkInvalid(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	config := &NetworkConfiguration{Mode: "vxlan", Subnet: getSubnet(t, "192.168.250.0/24")}
+	if err := d.CreateNetwork("dummy", config); err != InvalidModeError("vxlan") {
+		t.Fatalf("Expected %v, got %v", InvalidModeError("vxlan"), err)
+	}
+
+	config.Mode = modeGRE
+	config.Subnet = nil
+	if err := d.CreateNetwork("dummy", config); err != ErrNoSubnet {
+		t.Fatalf("Expected %v, got %v", ErrNoSubnet, err)
+	}
+
+	config.Subnet = getSubnet(t, "2001:db8::/64")
+	if err := d.CreateNetwork("dummy", config); err != ErrNotIPv4 {
+		t.Fatalf("Expected %v, got %v", ErrNotIPv4, err)
+	}
+
+	config.Subnet = getSubnet(t, "192.168.250.0/24")
+	config.Gateway = net.ParseIP("192.168.251.1")
+	if err := d.CreateNetwork("dummy", config); err != ErrInvalidGateway {
+		t.Fatalf("Expected %v, got %v", ErrInvalidGateway, err)
+	}
+
+	config.Gateway = nil
+	for _, c := range []struct {
+		peer Peer
+		err  error
+	}{
+		{Peer{Subnets: []*net.IPNet{getSubnet(t, "192.168.251.0/24")}}, ErrNoPeerRemote},
+		{Peer{Remote: net.ParseIP("10.10.0.2")}, ErrNoPeerSubnet},
+		{Peer{Remote: net.ParseIP("10.10.0.2"), Subnets: []*net.IPNet{getSubnet(t, "192.168.0.0/16")}}, PeerSubnetOverlapError("192.168.0.0/16")},
+	} {
+		config.Peers = []Peer{c.peer}
+		if err := d.CreateNetwork("dummy", config); err != c.err {
+			t.Fatalf("Expected %v, got %v", c.err, err)
+		}
+	}
+
+	// The subnets of two peers must not overlap either
+	config.Peers = []Peer{
+		{Remote: net.ParseIP("10.10.0.2"), Subnets: []*net.IPNet{getSubnet(t, "192.168.251.0/24")}},
+		{Remote: net.ParseIP("10.10.0.3"), Subnets: []*net.IPNet{getSubnet(t, "192.168.251.128/25")}},
+	}
+	if err := d.CreateNetwork("dummy", config); err != PeerSubnetOverlapError("192.168.251.128/25") {
+		t.Fatalf("Expected %v, got %v", PeerSubnetOverlapError("192.168.251.128/25"), err)
+	}
+}
+
+func TestCreateNetwork(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+	dr := d.(*driver)
+
+	peerSubnet := getSubnet(t, "192.168.251.0/24")
+	config := &NetworkConfiguration{
+		Subnet: getSubnet(t, "192.168.250.0/24"),
+		Local:  net.ParseIP("10.10.0.1"),
+		Peers:  []Peer{{Remote: net.ParseIP("10.10.0.2"), Subnets: []*net.IPNet{peerSubnet}}},
+	}
+
+	err := d.CreateNetwork("dummy", config)
+	if err != nil {
+		// Without the kernel support, no interface is left behind
+		if _, ok := err.(*TunnelUnavailableError); !ok || !types.IsUnavailable(err) {
+			t.Fatalf("Failed to create the network: %v", err)
+		}
+		links, e := netlink.LinkList()
+		if e != nil {
+			t.Fatal(e)
+		}
+		for _, l := range links {
+			if l.Attrs().Name != "lo" {
+				t.Fatalf("Interface %s left behind after the network creation failure", l.Attrs().Name)
+			}
+		}
+		t.Skipf("The kernel provides no tunnels: %v", err)
+	}
+
+	n := dr.networks["dummy"]
+	tunnel, err := netlink.LinkByName(n.tunnels[0])
+	if err != nil {
+		t.Fatalf("Tunnel interface not found: %v", err)
+	}
+	routes, err := netlink.RouteList(tunnel, netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, r := range routes {
+		if r.Dst != nil && r.Dst.String() == peerSubnet.String() {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("No route to the peer subnet %s through the tunnel: %v", peerSubnet, routes)
+	}
+
+	sinfo, err := d.CreateEndpoint("dummy", "ep1", nil)
+	if err != nil {
+		t.Fatalf("Failed to create an endpoint: %v", err)
+	}
+	if addr := sinfo.Interfaces[0].Address; !config.Subnet.Contains(addr.IP) {
+		t.Fatalf("Endpoint address %s outside the network subnet %s", addr, config.Subnet)
+	}
+	if !sinfo.Gateway.Equal(n.gateway) {
+		t.Fatalf("Expected gateway %s, got %s", n.gateway, sinfo.Gateway)
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != ActiveEndpointsError("dummy") {
+		t.Fatalf("Expected %v, got %v", ActiveEndpointsError("dummy"), err)
+	}
+	if err := d.DeleteEndpoint("dummy", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{n.bridge, n.tunnels[0]} {
+		if _, err := netlink.LinkByName(name); err == nil {
+			t.Fatalf("Interface %s left behind after the network deletion", name)
+		}
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != driverapi.ErrNoNetwork {
+		t.Fatalf("Expected %v, got %v", driverapi.ErrNoNetwork, err)
+	}
+}
@@ -1,10 +1,22 @@
 package libnetwork
 
 import (
+	"context"
+	"encoding/json"
+	"net"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/etchosts"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/resolvconf"
+	"github.com/docker/libnetwork/resolver"
 	"github.com/docker/libnetwork/sandbox"
 	"github.com/docker/libnetwork/types"
 )
@@ -17,29 +29,204 @@ type Endpoint interface {
 	// Name returns the name of this endpoint.
 	Name() string
 
+	// Aliases returns the names the endpoint is also known by in its
+	// network, in addition to its name.
+	Aliases() []string
+
 	// Network returns the name of the network to which this endpoint is attached.
 	Network() string
 
 	// Join creates a new sandbox for the given container ID and populates the
 	// network resources allocated for the endpoint and joins the sandbox to
-	// the endpoint. It returns the sandbox key to the caller
+	// the endpoint. It returns the sandbox key to the caller in the
+	// ContainerData, the same key for every endpoint the container joins
+	// since they all share its sandbox. An endpoint can be joined by one
+	// container at a time: ErrEndpointInUse is returned until the joined
+	// container leaves, after which the endpoint can be joined again.
+	//
+	// The endpoint address is leased to the joined container until it
+	// leaves. The lease survives a restart of the controller, while the join
+	// does not: a container which did not leave, such as on a crash, gets
+	// the same address back by joining again. Meanwhile the other containers
+	// fail to join with LeasedEndpointError, until the lease is released by
+	// NetworkController.ReleaseLeasesFor.
+	//
+	// When a container joins several endpoints, its resolv.conf merges the
+	// DNS settings of all of them: the settings of the endpoint joined first
+	// come first, and the later ones only add the servers, search domains and
+	// options not listed yet. For each of the three settings left unspecified
+	// by all the endpoints, the host resolv.conf value is used.
+	//
+	// If programming the sandbox fails, Join returns SandboxProgrammingError
+	// and leaves the sandbox as it was before the Join.
 	Join(containerID string, options ...JoinOption) (*ContainerData, error)
 
-	// Leave removes the sandbox associated with  container ID and detaches
-	// the network resources populated in the sandbox
+	// JoinWithInfo joins the container to the endpoint as Join does, and
+	// returns along with the sandbox key and file paths the interfaces it
+	// placed in the sandbox and the default gateways, all a caller needs to
+	// configure the container.
+	JoinWithInfo(containerID string, options ...JoinOption) (*JoinInfo, error)
+
+	// Leave detaches the container from the endpoint: the interfaces,
+	// routes, sysctls and DNS settings the endpoint brought to the sandbox
+	// are removed, those of the other endpoints the container joined are
+	// kept, and the sandbox is removed along with its last endpoint. It
+	// returns ErrNoContainer if no container has joined the endpoint.
 	Leave(containerID string) error
 
+	// MoveToSandbox places the endpoint in the sandbox identified by key,
+	// for the orchestrators allocating the endpoints before the namespace
+	// of the container is known. The sandbox is created at key, unless the
+	// controller already has one there, such as the sandbox of a joined
+	// container, and is shared the same way. The interfaces, gateways and
+	// routes of the endpoint are programmed as Join does, without any of
+	// the container files, hostname or sysctls. The endpoint must be in the
+	// EndpointAllocated state, EndpointStateError is returned otherwise.
+	MoveToSandbox(key string) error
+
+	// RemoveFromSandbox takes the endpoint placed with MoveToSandbox out of
+	// its sandbox, back to the EndpointAllocated state. The sandbox is
+	// destroyed once no endpoint is attached to it. EndpointStateError is
+	// returned if the endpoint is in no sandbox, and ErrEndpointInUse if a
+	// container joined it, which leaves with Leave.
+	RemoveFromSandbox() error
+
+	// State returns the placement state of the endpoint. An endpoint moved
+	// to a sandbox is deleted, joined or moved again only once removed from
+	// it, the corresponding operations return EndpointStateError meanwhile.
+	State() EndpointState
+
+	// UpdateDNS replaces the DNS servers, search domains and resolver
+	// options the joined container got from this endpoint with the passed
+	// ones, while it runs. Its resolv.conf is rewritten in place, merging
+	// the settings of all the endpoints it joined as Join does, and its
+	// embedded DNS resolver, if any, forwards to the new servers. It returns
+	// ErrNoContainer if no container has joined the endpoint.
+	UpdateDNS(servers, search, options []string) error
+
 	// SandboxInfo returns the sandbox information for this endpoint.
 	SandboxInfo() *sandbox.Info
 
-	// Delete and detaches this endpoint from the network.
+	// SetGenericData stores value under key in the generic data of the
+	// endpoint, an opaque slot persisted along with it where the
+	// applications and the drivers keep external metadata. The value must
+	// encode to JSON, a nil one removes the key. The generic data is left
+	// out of the EndpointResource unless ResourceOptionGenericData is
+	// passed.
+	SetGenericData(key string, value interface{}) error
+
+	// GenericData decodes the value stored under key into v, as
+	// json.Unmarshal does, the same before and after a restart. It returns
+	// GenericDataNotFoundError if the key holds no value.
+	GenericData(key string, v interface{}) error
+
+	// Info returns a snapshot of the network settings of this endpoint.
+	Info() EndpointInfo
+
+	// Statistics returns the traffic counters of the interfaces of the
+	// endpoint in the sandbox of the joined container, indexed by their name
+	// in the sandbox. It returns ErrNoContainer if no container has joined
+	// the endpoint.
+	Statistics() (map[string]*sandbox.InterfaceStatistics, error)
+
+	// Probe checks that addr, an IP address and port, accepts TCP
+	// connections from the sandbox of the endpoint within timeout, or
+	// sandbox.DefaultProbeTimeout when zero. It returns ErrNoContainer if the
+	// endpoint is in no sandbox.
+	Probe(addr string, timeout time.Duration) (*sandbox.ProbeResult, error)
+
+	// ContainerID returns the id of the container which joined this endpoint,
+	// or an empty string if none.
+	ContainerID() string
+
+	// LeasedTo returns the id of the container the endpoint address is
+	// leased to, or an empty string if none. The lease is dead when no
+	// container is joined to the endpoint meanwhile.
+	LeasedTo() string
+
+	// Delete and detaches this endpoint from the network, releasing the
+	// resources the driver allocated for it. It returns ErrEndpointInUse
+	// while a container is joined to the endpoint.
 	Delete() error
 }
 
+// EndpointInfo provides the network settings of an endpoint, as allocated by
+// the driver on endpoint creation.
+type EndpointInfo struct {
+	// Interfaces the endpoint places into the sandbox, with their
+	// addresses and MAC address, and the pool the IPv4 address came from
+	// on the networks with several subnets. While a container is joined,
+	// they carry their final name in the sandbox.
+	Interfaces []*sandbox.Interface
+
+	// IPv4 gateway for the sandbox.
+	Gateway net.IP
+
+	// IPv6 gateway for the sandbox.
+	GatewayIPv6 net.IP
+
+	// SandboxKey of the sandbox of the container which joined the
+	// endpoint, empty if no container joined.
+	SandboxKey string
+
+	// Hostname of the sandbox of the container which joined the endpoint.
+	Hostname string
+
+	// PortBindings published on the host by the driver while a container
+	// is joined, with the effective host ports.
+	PortBindings []types.PortBinding
+
+	// Bandwidth limits the driver applied while a container is joined, nil
+	// when unlimited.
+	Bandwidth *types.Bandwidth
+
+	// FirewallRules the driver installed while a container is joined, in
+	// the order they apply.
+	FirewallRules []types.FirewallRule
+}
+
 // ContainerData is a set of data returned when a container joins an endpoint.
 type ContainerData struct {
+	SandboxKey     string
+	HostsPath      string
+	ResolvConfPath string
+}
+
+// JoinInfo is the set of data returned when a container joins an endpoint with
+// JoinWithInfo.
+type JoinInfo struct {
+	// SandboxKey of the sandbox of the container, shared by all the
+	// endpoints it joins.
 	SandboxKey string
-	HostsPath  string
+
+	// HostsPath is the path of the hosts file of the container.
+	HostsPath string
+
+	// ResolvConfPath is the path of the resolv.conf of the container.
+	ResolvConfPath string
+
+	// Interfaces the endpoint placed into the sandbox, with their final
+	// name in the sandbox and their addresses.
+	Interfaces []*sandbox.Interface
+
+	// Gateway is the IPv4 default gateway of the sandbox after the join,
+	// which another endpoint of the sandbox may provide. Nil if none.
+	Gateway net.IP
+
+	// GatewayIPv6 is the IPv6 default gateway of the sandbox after the
+	// join, which another endpoint of the sandbox may provide. Nil if none.
+	GatewayIPv6 net.IP
+
+	// StaticRoutes are the routes passed with JoinOptionStaticRoutes, with
+	// the name in the sandbox of the interface they go through.
+	StaticRoutes []*sandbox.Route
+}
+
+// StaticRoute is a route to Destination added to the sandbox of a container
+// on join, via NextHop unless nil for an on-link route.
+type StaticRoute struct {
+	Destination *net.IPNet
+	NextHop     net.IP
 }
 
 // JoinOption is a option setter function type used to pass varios options to
@@ -47,27 +234,109 @@ type ContainerData struct {
 // provided by libnetwork, they look like JoinOption[...](...)
 type JoinOption func(ep *endpoint)
 
+// EndpointOption is a option setter function type used to pass various
+// options to the network CreateEndpoint method. The various setter functions
+// of type EndpointOption are provided by libnetwork, they look like
+// EndpointOption[...](...)
+type EndpointOption func(ep *endpoint)
+
 type containerConfig struct {
-	Hostname   string
-	Domainname string
+	Hostname      string
+	Domainname    string
+	DNS           []string
+	DNSSearch     []string
+	DNSOptions    []string
+	ExtraHosts    []extraHost
+	Sysctls       map[string]string
+	InterfaceName string
+	StaticRoutes  []StaticRoute
+}
+
+// sandboxCopy returns a copy of the hosts and DNS settings of the config, the
+// ones the other endpoints joined to the sandbox read.
+func (c containerConfig) sandboxCopy() containerConfig {
+	return containerConfig{
+		Hostname:   c.Hostname,
+		Domainname: c.Domainname,
+		DNS:        append([]string(nil), c.DNS...),
+		DNSSearch:  append([]string(nil), c.DNSSearch...),
+		DNSOptions: append([]string(nil), c.DNSOptions...),
+		ExtraHosts: append([]extraHost(nil), c.ExtraHosts...),
+	}
+}
+
+type extraHost struct {
+	name string
+	IP   string
 }
 
+// containerInfo holds the join state of an endpoint. The ID is empty for an
+// endpoint moved to a sandbox, whose Data only holds the sandbox key.
 type containerInfo struct {
 	ID     string
 	Config containerConfig
 	Data   ContainerData
 }
 
+// EndpointState is the placement of an endpoint, from its creation to its
+// deletion.
+type EndpointState string
+
+const (
+	// EndpointAllocated is the state of an endpoint whose resources, such as
+	// its address and host interface, are allocated, outside any sandbox.
+	EndpointAllocated EndpointState = "allocated"
+	// EndpointAttached is the state of an endpoint placed in a sandbox, by
+	// the join of a container or MoveToSandbox.
+	EndpointAttached EndpointState = "attached"
+)
+
+// gatewayPolicy selects whether an endpoint provides the default routes of
+// the sandboxes it joins.
+type gatewayPolicy int
+
+const (
+	// The endpoint joined first among the ones having a gateway provides it
+	gatewayAuto gatewayPolicy = iota
+	// The endpoint provides the default routes whatever the join order
+	gatewayRequired
+	// The endpoint never provides the default routes
+	gatewaySkip
+)
+
 type endpoint struct {
 	name        string
+	aliases     []string
+	gateway     gatewayPolicy
+	priority    int // precedence for the default routes, the highest wins
 	id          types.UUID
 	network     *network
 	sandboxInfo *sandbox.Info
 	sandBox     sandbox.Sandbox
+	sboxIfaces  []*sandbox.Interface // interfaces as named in the joined sandbox
+	sboxRoutes  []*sandbox.Route     // routes added to the joined sandbox
+	joinInfo    *driverapi.JoinInfo
 	container   *containerInfo
+	lease       string      // container the address is leased to, persisted until it leaves
+	generic     genericData // opaque data kept with the endpoint, under its own lock
+	// restoreFailed is set if the driver failed to take the endpoint back
+	// after a restart, its deletion only removes its record
+	restoreFailed bool
+	// The endpoint lock guards the join state above and is held for the
+	// whole of Join, Leave and Delete.
+	sync.Mutex
 }
 
-const prefix = "/var/lib/docker/network/files"
+const (
+	prefix            = "/var/lib/docker/network/files"
+	endpointKeyPrefix = "endpoint"
+)
+
+var hostnameLabelRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// ifaceNameRegexp matches the interface names within the kernel limit of 15
+// characters.
+var ifaceNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,14}$`)
 
 func (ep *endpoint) ID() string {
 	return string(ep.id)
@@ -77,10 +346,65 @@ func (ep *endpoint) Name() string {
 	return ep.name
 }
 
+func (ep *endpoint) Aliases() []string {
+	return append([]string(nil), ep.aliases...)
+}
+
 func (ep *endpoint) Network() string {
 	return ep.network.name
 }
 
+func (ep *endpoint) Key() []string {
+	return []string{endpointKeyPrefix, string(ep.network.id), string(ep.id)}
+}
+
+// endpointRecord is the store record of an endpoint. It is kept apart from
+// the endpoint so the JSON encoding of an endpoint handle does not expose it.
+type endpointRecord struct {
+	Name        string                     `json:"name"`
+	Aliases     []string                   `json:"aliases"`
+	Gateway     gatewayPolicy              `json:"gateway"`
+	Priority    int                        `json:"priority"`
+	ID          string                     `json:"id"`
+	SandboxInfo *sandbox.Info              `json:"sandboxInfo"`
+	Lease       string                     `json:"lease"`
+	GenericData map[string]json.RawMessage `json:"genericData,omitempty"`
+}
+
+func (ep *endpoint) Value() []byte {
+	b, err := json.Marshal(&endpointRecord{
+		Name:        ep.name,
+		Aliases:     ep.aliases,
+		Gateway:     ep.gateway,
+		Priority:    ep.priority,
+		ID:          string(ep.id),
+		SandboxInfo: ep.sandboxInfo,
+		Lease:       ep.lease,
+		GenericData: ep.generic.snapshot(),
+	})
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// setValue restores the endpoint from its store record.
+func (ep *endpoint) setValue(b []byte) error {
+	var r endpointRecord
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+	ep.name = r.Name
+	ep.aliases = r.Aliases
+	ep.gateway = r.Gateway
+	ep.priority = r.Priority
+	ep.id = types.UUID(r.ID)
+	ep.sandboxInfo = r.SandboxInfo
+	ep.lease = r.Lease
+	ep.generic.data = r.GenericData
+	return nil
+}
+
 func (ep *endpoint) SandboxInfo() *sandbox.Info {
 	if ep.sandboxInfo == nil {
 		return nil
@@ -88,8 +412,133 @@ func (ep *endpoint) SandboxInfo() *sandbox.Info {
 	return ep.sandboxInfo.GetCopy()
 }
 
+func (ep *endpoint) ContainerID() string {
+	ep.Lock()
+	defer ep.Unlock()
+
+	if ep.container == nil {
+		return ""
+	}
+	return ep.container.ID
+}
+
+func (ep *endpoint) State() EndpointState {
+	ep.Lock()
+	defer ep.Unlock()
+
+	if ep.container == nil {
+		return EndpointAllocated
+	}
+	return EndpointAttached
+}
+
+// stateError returns the EndpointStateError of the operation op, which the
+// current state of the endpoint does not allow. It must be called with the
+// endpoint lock held.
+func (ep *endpoint) stateError(op string) error {
+	state := EndpointAllocated
+	if ep.container != nil {
+		state = EndpointAttached
+	}
+	return &EndpointStateError{name: ep.name, id: string(ep.id), state: state, operation: op}
+}
+
+func (ep *endpoint) LeasedTo() string {
+	ep.Lock()
+	defer ep.Unlock()
+
+	return ep.lease
+}
+
+func (ep *endpoint) Info() EndpointInfo {
+	ep.Lock()
+	defer ep.Unlock()
+
+	var info EndpointInfo
+
+	if sinfo := ep.SandboxInfo(); sinfo != nil {
+		info.Interfaces = sinfo.Interfaces
+		info.Gateway = sinfo.Gateway
+		info.GatewayIPv6 = sinfo.GatewayIPv6
+	}
+
+	if ep.sboxIfaces != nil {
+		info.Interfaces = make([]*sandbox.Interface, 0, len(ep.sboxIfaces))
+		for _, i := range ep.sboxIfaces {
+			info.Interfaces = append(info.Interfaces, i.GetCopy())
+		}
+	}
+
+	if ep.container != nil {
+		info.SandboxKey = ep.container.Data.SandboxKey
+		if sb := ep.network.ctrlr.sandboxGet(info.SandboxKey); sb != nil {
+			info.Hostname = sb.Hostname()
+		}
+	}
+
+	if ep.joinInfo != nil {
+		for _, b := range ep.joinInfo.PortBindings {
+			info.PortBindings = append(info.PortBindings, b.GetCopy())
+		}
+		if bw := ep.joinInfo.Bandwidth; bw != nil {
+			info.Bandwidth = &types.Bandwidth{Ingress: bw.Ingress, Egress: bw.Egress}
+		}
+		for _, r := range ep.joinInfo.FirewallRules {
+			info.FirewallRules = append(info.FirewallRules, r.GetCopy())
+		}
+	}
+
+	return info
+}
+
+func (ep *endpoint) Statistics() (map[string]*sandbox.InterfaceStatistics, error) {
+	ep.Lock()
+	defer ep.Unlock()
+
+	if ep.container == nil || ep.container.Data.SandboxKey == "" {
+		return nil, ErrNoContainer
+	}
+
+	sboxKey := ep.container.Data.SandboxKey
+	sb := ep.network.ctrlr.sandboxGet(sboxKey)
+	if sb == nil {
+		return nil, sandbox.DestroyedError(sboxKey)
+	}
+
+	all, err := sb.Statistics()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]*sandbox.InterfaceStatistics, len(ep.sboxIfaces))
+	for _, i := range ep.sboxIfaces {
+		if s, ok := all[i.DstName]; ok {
+			stats[i.DstName] = s
+		}
+	}
+
+	return stats, nil
+}
+
+func (ep *endpoint) Probe(addr string, timeout time.Duration) (*sandbox.ProbeResult, error) {
+	ep.Lock()
+	if ep.container == nil || ep.container.Data.SandboxKey == "" {
+		ep.Unlock()
+		return nil, ErrNoContainer
+	}
+	sboxKey := ep.container.Data.SandboxKey
+	ep.Unlock()
+
+	// The probe runs unlocked, it may last until the timeout
+	sb := ep.network.ctrlr.sandboxGet(sboxKey)
+	if sb == nil {
+		return nil, sandbox.DestroyedError(sboxKey)
+	}
+	return sb.Probe(addr, timeout)
+}
+
 func createBasePath(dir string) error {
-	err := os.MkdirAll(dir, 0644)
+	err := os.MkdirAll(dir, 0755)
 	if err != nil && !os.IsExist(err) {
 		return err
 	}
@@ -115,20 +564,45 @@ func createHostsFile(path string) error {
 }
 
 func (ep *endpoint) Join(containerID string, options ...JoinOption) (*ContainerData, error) {
+	info, err := ep.JoinWithInfo(containerID, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ContainerData{SandboxKey: info.SandboxKey, HostsPath: info.HostsPath, ResolvConfPath: info.ResolvConfPath}, nil
+}
+
+func (ep *endpoint) JoinWithInfo(containerID string, options ...JoinOption) (*JoinInfo, error) {
 	var err error
 
 	if containerID == "" {
 		return nil, InvalidContainerIDError(containerID)
 	}
 
+	ep.Lock()
+	defer ep.Unlock()
+
 	if ep.container != nil {
-		return nil, ErrInvalidJoin
+		if ep.container.ID == "" {
+			return nil, ep.stateError("join")
+		}
+		return nil, ErrEndpointInUse
+	}
+
+	if ep.lease != "" && ep.lease != containerID {
+		return nil, &LeasedEndpointError{name: ep.name, id: string(ep.id), container: ep.lease}
+	}
+
+	if _, err := ep.network.driverKnows(); err != nil {
+		return nil, err
 	}
 
 	ep.container = &containerInfo{}
 	defer func() {
 		if err != nil {
 			ep.container = nil
+			ep.sboxIfaces = nil
+			ep.sboxRoutes = nil
 		}
 	}()
 
@@ -136,70 +610,632 @@ func (ep *endpoint) Join(containerID string, options ...JoinOption) (*ContainerD
 		ep.processOptions(options...)
 	}
 
+	if name := ep.container.Config.Hostname; name != "" && !validHostname(name) {
+		err = InvalidHostnameError(name)
+		return nil, err
+	}
+
+	if name := ep.container.Config.InterfaceName; name != "" && !ifaceNameRegexp.MatchString(name) {
+		err = InvalidNameError(name)
+		return nil, err
+	}
+
+	if len(ep.container.Config.Sysctls) != 0 && ep.network.Type() == "host" {
+		err = ErrHostNetworkSysctls
+		return nil, err
+	}
+
+	for _, r := range ep.container.Config.StaticRoutes {
+		if err = checkStaticRoute(r); err != nil {
+			return nil, err
+		}
+	}
+
 	ep.container.Data.HostsPath = prefix + "/" + containerID + "/hosts"
-	err = createHostsFile(ep.container.Data.HostsPath)
+	ep.container.Data.ResolvConfPath = prefix + "/" + containerID + "/resolv.conf"
+
+	// Containers joining a host network share the host network namespace
+	var hostNetwork types.UUID
+	if ep.network.Type() == "host" {
+		hostNetwork = ep.network.id
+	}
+
+	sboxKey := ep.network.ctrlr.sandboxKey(containerID)
+	sb, err := ep.network.ctrlr.sandboxAdd(sboxKey, hostNetwork, ep)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			ep.network.ctrlr.sandboxRm(sboxKey, ep)
+			ep.updateContainerFiles(containerID, sboxKey)
+		}
+	}()
+
+	joined, configs := ep.network.ctrlr.sandboxEndpoints(sboxKey)
+
+	// The endpoints setting a hostname share the one of the sandbox
+	if name := ep.container.Config.Hostname; name != "" {
+		for i, e := range joined {
+			if h := configs[i].Hostname; e != ep && h != "" && h != name {
+				err = HostnameConflictError(h)
+				return nil, err
+			}
+		}
+	}
+
+	// The files of a container already joined are rebuilt below, they are
+	// created empty along with its sandbox only
+	if len(joined) == 1 {
+		err = createHostsFile(ep.container.Data.HostsPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	others, err := ep.sandboxPeers(joined)
+	if err != nil {
+		return nil, err
+	}
+
+	if name := ep.container.Config.Hostname; name != "" && name != sb.Hostname() {
+		err = sb.SetHostname(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = buildHostsFile(ep.container.Data.HostsPath, joined, configs)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := ep.network.ctrlr.sandboxResolver(sboxKey)
 	if err != nil {
 		return nil, err
 	}
 
-	err = ep.buildHostsFiles()
+	dnsLock := ep.network.ctrlr.sandboxDNSLock(sboxKey)
+	dnsLock.Lock()
+	eps, configs := ep.network.ctrlr.sandboxEndpoints(sboxKey)
+	err = buildResolvConf(ep.container.Data.ResolvConfPath, eps, configs, r)
+	dnsLock.Unlock()
 	if err != nil {
 		return nil, err
 	}
 
-	sboxKey := sandbox.GenerateKey(containerID)
-	sb, err := ep.network.ctrlr.sandboxAdd(sboxKey)
+	err = ep.programSandbox(sb, containerID, joined, others)
 	if err != nil {
 		return nil, err
 	}
+
+	ep.container.ID = containerID
+	ep.container.Data.SandboxKey = sb.Key()
+
+	if ep.lease != containerID {
+		ep.lease = containerID
+		ep.storeLease()
+	}
+
+	ep.network.ctrlr.events.publish(EventEndpointJoin, string(ep.id))
+
+	info := &JoinInfo{
+		SandboxKey:     ep.container.Data.SandboxKey,
+		HostsPath:      ep.container.Data.HostsPath,
+		ResolvConfPath: ep.container.Data.ResolvConfPath,
+	}
+	for _, i := range ep.sboxIfaces {
+		info.Interfaces = append(info.Interfaces, i.GetCopy())
+	}
+	// The static routes are the last ones the endpoint added
+	for _, r := range ep.sboxRoutes[len(ep.sboxRoutes)-len(ep.container.Config.StaticRoutes):] {
+		info.StaticRoutes = append(info.StaticRoutes, r.GetCopy())
+	}
+	if ep.sandboxInfo != nil {
+		gw4, gw6 := gatewayEndpoints(joined)
+		if gw4 != nil {
+			info.Gateway = netutils.GetIPCopy(gw4.sandboxInfo.Gateway)
+		}
+		if gw6 != nil {
+			info.GatewayIPv6 = netutils.GetIPCopy(gw6.sandboxInfo.GatewayIPv6)
+		}
+	}
+	return info, nil
+}
+
+// sandboxPeers returns the endpoints attached to the sandbox along with the
+// endpoint, among joined. GatewayConflictError is returned if the endpoint and
+// another one both require to provide the default routes.
+func (ep *endpoint) sandboxPeers(joined []*endpoint) ([]*endpoint, error) {
+	others := make([]*endpoint, 0, len(joined))
+	for _, e := range joined {
+		if e == ep {
+			continue
+		}
+		if ep.gateway == gatewayRequired && e.gateway == gatewayRequired {
+			return nil, GatewayConflictError(e.name)
+		}
+		others = append(others, e)
+	}
+	return others, nil
+}
+
+// programSandbox adds the interfaces, default routes, static routes and
+// sysctls of the endpoint to the sandbox sb which the endpoints joined, the
+// endpoint included, and others, the endpoint excluded, are attached to, then
+// has the driver join the endpoint. On failure what was programmed so far is
+// removed, giving the default routes back to the endpoint which provided
+// them. The interfaces are named after the join options of the container,
+// which is empty for an endpoint moved to the sandbox.
+func (ep *endpoint) programSandbox(sb sandbox.Sandbox, containerID string, joined, others []*endpoint) (err error) {
+	progError := func(err error) error {
+		return &SandboxProgrammingError{Container: containerID, Sandbox: sb.Key(), Err: err}
+	}
+
+	var (
+		prev4, prev6 *endpoint
+		took4, took6 bool
+		set4, set6   bool
+	)
 	defer func() {
 		if err != nil {
-			ep.network.ctrlr.sandboxRm(sboxKey)
+			ep.unprogramSandbox(sb, prev4, prev6, took4, took6, set4, set6)
 		}
 	}()
 
 	sinfo := ep.SandboxInfo()
 	if sinfo != nil {
+		// The first interface takes the requested name, the others get
+		// the first free one derived from the driver given name
+		if name := ep.container.Config.InterfaceName; name != "" && len(sinfo.Interfaces) != 0 {
+			for _, i := range sb.Interfaces() {
+				if i.DstName == name {
+					return InterfaceNameError(name)
+				}
+			}
+			sinfo.Interfaces[0].DstName = name
+		}
+
 		for _, i := range sinfo.Interfaces {
-			err = sb.AddInterface(i)
+			err = sb.AddInterface(i.SrcName, i.DstName, i.Addresses())
 			if err != nil {
-				return nil, err
+				return progError(err)
+			}
+			// The endpoint owns the interfaces it added, under the name the
+			// sandbox gave them, which are the only ones Leave removes
+			i.DstName = sandboxIfaceName(sb, i.SrcName)
+			ep.sboxIfaces = append(ep.sboxIfaces, i)
+		}
+
+		// Another endpoint of the sandbox may already provide the default
+		// routes, which an endpoint requiring to provide them takes over
+		prev4, prev6 = gatewayEndpoints(others)
+		gw4, gw6 := gatewayEndpoints(joined)
+		if gw4 == ep {
+			if prev4 != nil {
+				err = sb.UnsetGateway()
+				if err != nil {
+					return progError(err)
+				}
+			}
+			took4 = true
+			err = sb.SetGateway(sinfo.Gateway)
+			if err != nil {
+				return progError(err)
 			}
+			set4 = true
 		}
 
-		err = sb.SetGateway(sinfo.Gateway)
+		if gw6 == ep {
+			if prev6 != nil {
+				err = sb.UnsetGatewayIPv6()
+				if err != nil {
+					return progError(err)
+				}
+			}
+			took6 = true
+			err = sb.SetGatewayIPv6(sinfo.GatewayIPv6)
+			if err != nil {
+				return progError(err)
+			}
+			set6 = true
+		}
+
+		// Routes refer to the interfaces by the name the driver gave them
+		for _, r := range sinfo.Routes {
+			if r.Destination == nil && ep.gateway == gatewaySkip {
+				continue
+			}
+			iface := r.Interface
+			for k, i := range ep.sandboxInfo.Interfaces {
+				if i.DstName == r.Interface {
+					iface = sinfo.Interfaces[k].DstName
+					break
+				}
+			}
+			err = sb.AddRoute(r.Destination, r.NextHop, iface)
+			if err != nil {
+				return progError(err)
+			}
+			ep.sboxRoutes = append(ep.sboxRoutes, &sandbox.Route{Destination: r.Destination, NextHop: r.NextHop, Interface: iface})
+		}
+
+		for _, r := range ep.container.Config.StaticRoutes {
+			iface := staticRouteIface(ep.sboxIfaces, r)
+			if iface == "" {
+				return &StaticRouteError{Route: r, Reason: "next hop not in the subnet of an interface of the endpoint"}
+			}
+			err = sb.AddRoute(r.Destination, r.NextHop, iface)
+			if err != nil {
+				return progError(err)
+			}
+			ep.sboxRoutes = append(ep.sboxRoutes, &sandbox.Route{Destination: r.Destination, NextHop: r.NextHop, Interface: iface})
+		}
+	} else if routes := ep.container.Config.StaticRoutes; len(routes) != 0 {
+		return &StaticRouteError{Route: routes[0], Reason: "the endpoint has no interface"}
+	}
+
+	// Set once the interfaces exist, as the sysctls may refer to them
+	defer func() {
 		if err != nil {
-			return nil, err
+			ep.network.ctrlr.sandboxUnsetSysctls(sb.Key(), ep)
+		}
+	}()
+	for name, value := range ep.container.Config.Sysctls {
+		err = ep.network.ctrlr.sandboxSetSysctl(sb.Key(), ep, name, value)
+		if err != nil {
+			return err
 		}
+	}
+
+	var jinfo *driverapi.JoinInfo
+	err = ep.network.ctrlr.retryPolicy.run(context.Background(), "join", func() error {
+		var err error
+		start := time.Now()
+		jinfo, err = ep.network.driver.Join(ep.network.id, ep.id, sb.Key())
+		ep.network.ctrlr.observeDriver(ep.network.networkType, driverJoin, start)
+		return err
+	})
+	if err != nil {
+		return progError(err)
+	}
+	ep.joinInfo = jinfo
+
+	return nil
+}
+
+func (ep *endpoint) MoveToSandbox(key string) error {
+	var err error
 
-		err = sb.SetGatewayIPv6(sinfo.GatewayIPv6)
+	if key == "" {
+		return InvalidSandboxKeyError(key)
+	}
+
+	ep.Lock()
+	defer ep.Unlock()
+
+	if ep.container != nil {
+		return ep.stateError("move to a sandbox")
+	}
+
+	// The address leased to a container goes back to it only
+	if ep.lease != "" {
+		return &LeasedEndpointError{name: ep.name, id: string(ep.id), container: ep.lease}
+	}
+
+	ep.container = &containerInfo{Data: ContainerData{SandboxKey: key}}
+	defer func() {
 		if err != nil {
-			return nil, err
+			ep.container = nil
+			ep.sboxIfaces = nil
+			ep.sboxRoutes = nil
 		}
+	}()
+
+	var hostNetwork types.UUID
+	if ep.network.Type() == "host" {
+		hostNetwork = ep.network.id
 	}
 
-	ep.container.ID = containerID
-	ep.container.Data.SandboxKey = sb.Key()
+	sb, err := ep.network.ctrlr.sandboxAdd(key, hostNetwork, ep)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			ep.network.ctrlr.sandboxRm(key, ep)
+		}
+	}()
+
+	joined, _ := ep.network.ctrlr.sandboxEndpoints(key)
+	others, err := ep.sandboxPeers(joined)
+	if err != nil {
+		return err
+	}
+
+	err = ep.programSandbox(sb, "", joined, others)
+	if err != nil {
+		return err
+	}
+
+	ep.network.ctrlr.events.publish(EventEndpointAttach, string(ep.id))
+
+	return nil
+}
+
+func (ep *endpoint) RemoveFromSandbox() error {
+	ep.Lock()
+	defer ep.Unlock()
+
+	if ep.container == nil {
+		return ep.stateError("remove from a sandbox")
+	}
+	if ep.container.ID != "" {
+		return ErrEndpointInUse
+	}
+
+	if err := ep.leaveSandbox(ep.container.Data.SandboxKey); err != nil {
+		return err
+	}
+	ep.container = nil
+
+	ep.network.ctrlr.events.publish(EventEndpointDetach, string(ep.id))
+
+	return nil
+}
+
+// sandboxIfaceName returns the name the sandbox sb gave to the device srcName
+// it moved in, the most recently added one should the name be reused.
+func sandboxIfaceName(sb sandbox.Sandbox, srcName string) string {
+	ifaces := sb.Interfaces()
+	for index := len(ifaces) - 1; index >= 0; index-- {
+		if ifaces[index].SrcName == srcName {
+			return ifaces[index].DstName
+		}
+	}
+	return ""
+}
+
+// unprogramSandbox removes the routes and interfaces a failed Join added to
+// the sandbox sb. The default routes are unset if the endpoint set them, and
+// handed back to the endpoints prev4 and prev6 if it took them over.
+func (ep *endpoint) unprogramSandbox(sb sandbox.Sandbox, prev4, prev6 *endpoint, took4, took6, set4, set6 bool) {
+	for _, r := range ep.sboxRoutes {
+		if err := sb.RemoveRoute(r.Destination, r.NextHop, r.Interface); err != nil {
+			log.Warnf("Failed to remove route to %v after join failure: %v", r.Destination, err)
+		}
+	}
+
+	if set4 {
+		if err := sb.UnsetGateway(); err != nil {
+			log.Warnf("Failed to unset the default gateway after join failure: %v", err)
+		}
+	}
+	if took4 && prev4 != nil {
+		if err := sb.SetGateway(prev4.sandboxInfo.Gateway); err != nil {
+			log.Warnf("Failed to restore the default gateway after join failure: %v", err)
+		}
+	}
+
+	if set6 {
+		if err := sb.UnsetGatewayIPv6(); err != nil {
+			log.Warnf("Failed to unset the default IPv6 gateway after join failure: %v", err)
+		}
+	}
+	if took6 && prev6 != nil {
+		if err := sb.SetGatewayIPv6(prev6.sandboxInfo.GatewayIPv6); err != nil {
+			log.Warnf("Failed to restore the default IPv6 gateway after join failure: %v", err)
+		}
+	}
 
-	cData := ep.container.Data
-	return &cData, nil
+	for _, i := range ep.sboxIfaces {
+		if err := sb.RemoveInterface(i.DstName); err != nil {
+			log.Warnf("Failed to remove interface %s after join failure: %v", i.DstName, err)
+		}
+	}
+}
+
+func (ep *endpoint) UpdateDNS(servers, search, options []string) error {
+	for _, ns := range servers {
+		if net.ParseIP(ns) == nil {
+			return InvalidNameserverError(ns)
+		}
+	}
+
+	ep.Lock()
+	defer ep.Unlock()
+
+	if ep.container == nil || ep.container.ID == "" {
+		return ErrNoContainer
+	}
+
+	sboxKey := ep.network.ctrlr.sandboxKey(ep.container.ID)
+	r, err := ep.network.ctrlr.sandboxResolver(sboxKey)
+	if err != nil {
+		return err
+	}
+
+	dnsLock := ep.network.ctrlr.sandboxDNSLock(sboxKey)
+	if dnsLock == nil {
+		return ErrNoContainer
+	}
+	dnsLock.Lock()
+	defer dnsLock.Unlock()
+
+	config := &ep.container.Config
+	prev := *config
+	config.DNS = append([]string(nil), servers...)
+	config.DNSSearch = append([]string(nil), search...)
+	config.DNSOptions = append([]string(nil), options...)
+	ep.network.ctrlr.sandboxUpdateConfig(sboxKey, ep)
+
+	eps, configs := ep.network.ctrlr.sandboxEndpoints(sboxKey)
+	if err := buildResolvConf(ep.container.Data.ResolvConfPath, eps, configs, r); err != nil {
+		config.DNS, config.DNSSearch, config.DNSOptions = prev.DNS, prev.DNSSearch, prev.DNSOptions
+		ep.network.ctrlr.sandboxUpdateConfig(sboxKey, ep)
+		return err
+	}
+
+	ep.network.ctrlr.events.publish(EventEndpointDNSUpdate, string(ep.id))
+
+	return nil
 }
 
 func (ep *endpoint) Leave(containerID string) error {
-	if ep.container == nil || ep.container.ID == "" ||
-		containerID == "" || ep.container.ID != containerID {
+	ep.Lock()
+	defer ep.Unlock()
+
+	if ep.container == nil || ep.container.ID == "" {
+		return ErrNoContainer
+	}
+
+	if containerID == "" || ep.container.ID != containerID {
 		return InvalidContainerIDError(containerID)
 	}
 
-	ep.network.ctrlr.sandboxRm(sandbox.GenerateKey(containerID))
+	sboxKey := ep.network.ctrlr.sandboxKey(containerID)
+	if err := ep.leaveSandbox(sboxKey); err != nil {
+		return err
+	}
+
+	// Drop the entries of this endpoint from the container hosts and
+	// resolv.conf files
+	ep.updateContainerFiles(containerID, sboxKey)
+
 	ep.container = nil
+	ep.lease = ""
+	ep.storeLease()
+
+	ep.network.ctrlr.events.publish(EventEndpointLeave, string(ep.id))
+
 	return nil
 }
 
+// updateContainerFiles rebuilds the hosts and resolv.conf files of the
+// container from the endpoints left in its sandbox, identified by sboxKey,
+// once the endpoint is detached from it. Failures are only logged.
+func (ep *endpoint) updateContainerFiles(containerID, sboxKey string) {
+	eps, configs := ep.network.ctrlr.sandboxEndpoints(sboxKey)
+	if err := buildHostsFile(ep.container.Data.HostsPath, eps, configs); err != nil {
+		log.Warnf("Failed to update the hosts file of container %s: %v", containerID, err)
+	}
+	if len(eps) == 0 {
+		return
+	}
+
+	r, err := ep.network.ctrlr.sandboxResolver(sboxKey)
+	if err != nil {
+		log.Warnf("Failed to update the DNS resolver of container %s: %v", containerID, err)
+	}
+	if dnsLock := ep.network.ctrlr.sandboxDNSLock(sboxKey); dnsLock != nil {
+		dnsLock.Lock()
+		eps, configs := ep.network.ctrlr.sandboxEndpoints(sboxKey)
+		if err := buildResolvConf(ep.container.Data.ResolvConfPath, eps, configs, r); err != nil {
+			log.Warnf("Failed to update the resolv.conf of container %s: %v", containerID, err)
+		}
+		dnsLock.Unlock()
+	}
+}
+
+// leaveSandbox has the driver leave the endpoint, then removes the sysctls,
+// routes, default routes and interfaces of the endpoint from the sandbox
+// identified by sboxKey, which is destroyed once no endpoint is attached to
+// it. The resources of the other endpoints are left alone, and the default
+// routes the endpoint provided are handed over to the next attached endpoint
+// providing a gateway. Once the driver left, a resource failing to be
+// removed is only logged: the endpoint is detached from the sandbox
+// regardless, which would otherwise never be destroyed.
+func (ep *endpoint) leaveSandbox(sboxKey string) error {
+	start := time.Now()
+	err := ep.network.driver.Leave(ep.network.id, ep.id)
+	ep.network.ctrlr.observeDriver(ep.network.networkType, driverLeave, start)
+	if err != nil {
+		return err
+	}
+	ep.joinInfo = nil
+
+	sb := ep.network.ctrlr.sandboxGet(sboxKey)
+	joined, _ := ep.network.ctrlr.sandboxEndpoints(sboxKey)
+	gw4, gw6 := gatewayEndpoints(joined)
+	ep.network.ctrlr.sandboxUnsetSysctls(sboxKey, ep)
+	if sb != nil && ep.sboxIfaces != nil {
+		for _, r := range ep.sboxRoutes {
+			if err := sb.RemoveRoute(r.Destination, r.NextHop, r.Interface); err != nil {
+				log.Warnf("Failed to remove route to %v of endpoint %s: %v", r.Destination, ep.id, err)
+			}
+		}
+
+		if gw4 == ep {
+			if err := sb.UnsetGateway(); err != nil {
+				log.Warnf("Failed to unset the default gateway of endpoint %s: %v", ep.id, err)
+			}
+		}
+
+		if gw6 == ep {
+			if err := sb.UnsetGatewayIPv6(); err != nil {
+				log.Warnf("Failed to unset the default IPv6 gateway of endpoint %s: %v", ep.id, err)
+			}
+		}
+
+		for _, i := range ep.sboxIfaces {
+			if err := sb.RemoveInterface(i.DstName); err != nil {
+				log.Warnf("Failed to remove interface %s of endpoint %s: %v", i.DstName, ep.id, err)
+			}
+		}
+	}
+	ep.sboxIfaces = nil
+	ep.sboxRoutes = nil
+
+	ep.network.ctrlr.sandboxRm(sboxKey, ep)
+
+	// Hand the default routes provided by this endpoint over to the next
+	// joined endpoint providing a gateway
+	eps, _ := ep.network.ctrlr.sandboxEndpoints(sboxKey)
+	if sb != nil && len(eps) != 0 {
+		next4, next6 := gatewayEndpoints(eps)
+		if gw4 == ep && next4 != nil {
+			if err := sb.SetGateway(next4.sandboxInfo.Gateway); err != nil {
+				log.Warnf("Failed to set the default gateway of sandbox %s: %v", sboxKey, err)
+			}
+		}
+		if gw6 == ep && next6 != nil {
+			if err := sb.SetGatewayIPv6(next6.sandboxInfo.GatewayIPv6); err != nil {
+				log.Warnf("Failed to set the default IPv6 gateway of sandbox %s: %v", sboxKey, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// storeLease persists the lease of the endpoint address. It must be called
+// with the endpoint lock held.
+func (ep *endpoint) storeLease() {
+	if err := ep.network.ctrlr.store.PutObject(ep); err != nil {
+		log.Warnf("Failed to store the lease of endpoint %s: %v", ep.id, err)
+	}
+}
+
 func (ep *endpoint) Delete() error {
 	var err error
 
+	ep.Lock()
+	defer ep.Unlock()
+
+	if ep.container != nil {
+		if ep.container.ID == "" {
+			return ep.stateError("delete")
+		}
+		return ErrEndpointInUse
+	}
+
 	n := ep.network
+	known, err := n.driverKnows()
+	if err != nil {
+		return err
+	}
+	known = known && !ep.restoreFailed
+
 	n.Lock()
 	_, ok := n.endpoints[ep.id]
 	if !ok {
@@ -217,30 +1253,268 @@ func (ep *endpoint) Delete() error {
 		}
 	}()
 
-	err = n.driver.DeleteEndpoint(n.id, ep.id)
-	return err
+	if known {
+		start := time.Now()
+		err = n.driver.DeleteEndpoint(n.id, ep.id)
+		n.ctrlr.observeDriver(n.networkType, driverDeleteEndpoint, start)
+		if err != nil {
+			return err
+		}
+	} else {
+		log.Warnf("Deleting endpoint %s unknown to its driver, its resources are left behind", ep.id)
+	}
+
+	if e := n.ctrlr.store.DeleteObject(ep); e != nil {
+		log.Warnf("Failed to remove endpoint %s from the store: %v", ep.id, e)
+	}
+
+	n.releaseEndpoints(1)
+
+	n.ctrlr.events.publish(EventEndpointDelete, string(ep.id))
+	n.ctrlr.metrics.IncCounter(MetricEndpointsDeleted)
+	n.ctrlr.addAllocatedIPs(ep.sandboxInfo, true)
+
+	return nil
+}
+
+// gatewayEndpoints returns the endpoints which provide the IPv4 and IPv6
+// default gateways of a sandbox given the endpoints joined to it in join
+// order: the endpoint requiring to provide them if it has such a gateway,
+// else the endpoint of highest priority among the ones having one, the one
+// joined first on a tie. The endpoints skipping the default routes are never
+// selected.
+func gatewayEndpoints(eps []*endpoint) (gw4 *endpoint, gw6 *endpoint) {
+	for _, required := range []bool{true, false} {
+		var tier4, tier6 *endpoint
+		for _, ep := range eps {
+			if ep.sandboxInfo == nil || ep.gateway == gatewaySkip ||
+				(required && ep.gateway != gatewayRequired) {
+				continue
+			}
+			if len(ep.sandboxInfo.Gateway) != 0 && (tier4 == nil || ep.priority > tier4.priority) {
+				tier4 = ep
+			}
+			if len(ep.sandboxInfo.GatewayIPv6) != 0 && (tier6 == nil || ep.priority > tier6.priority) {
+				tier6 = ep
+			}
+		}
+		if gw4 == nil {
+			gw4 = tier4
+		}
+		if gw6 == nil {
+			gw6 = tier6
+		}
+	}
+	return gw4, gw6
+}
+
+// validHostname checks name is a valid RFC 1123 host name, which also fits
+// in the kernel hostname limit of 64 characters.
+func validHostname(name string) bool {
+	if len(name) > 64 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if !hostnameLabelRegexp.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// buildHostsFile writes the hosts file at path for the container joined to the
+// passed endpoints, configs holding a copy of their container settings. The
+// container hostname, which the endpoints setting one share, resolves to the
+// address of the endpoint joined first, the addresses of the other endpoints
+// being listed after it, followed by the extra hosts of all the endpoints.
+func buildHostsFile(path string, eps []*endpoint, configs []containerConfig) error {
+	var (
+		IP, hostname, domainname string
+		extraContent             []etchosts.Record
+	)
+
+	if len(configs) != 0 {
+		domainname = configs[0].Domainname
+	}
+	for _, config := range configs {
+		if config.Hostname != "" {
+			hostname = config.Hostname
+			break
+		}
+	}
+
+	name := hostname
+	if domainname != "" {
+		name = hostname + "." + domainname + " " + hostname
+	}
+
+	for _, ep := range eps {
+		if ep.sandboxInfo == nil || len(ep.sandboxInfo.Interfaces) == 0 ||
+			ep.sandboxInfo.Interfaces[0].Address == nil {
+			continue
+		}
+
+		addr := ep.sandboxInfo.Interfaces[0].Address.IP.String()
+		if IP == "" {
+			IP = addr
+			continue
+		}
+		extraContent = appendRecord(extraContent, etchosts.Record{Hosts: name, IP: addr})
+	}
+
+	for _, config := range configs {
+		for _, eh := range config.ExtraHosts {
+			extraContent = appendRecord(extraContent, etchosts.Record{Hosts: eh.name, IP: eh.IP})
+		}
+	}
+
+	return etchosts.Build(path, IP, hostname, domainname, extraContent)
+}
+
+func appendRecord(records []etchosts.Record, r etchosts.Record) []etchosts.Record {
+	for _, e := range records {
+		if e == r {
+			return records
+		}
+	}
+	return append(records, r)
 }
 
-func (ep *endpoint) buildHostsFiles() error {
-	var extraContent []etchosts.Record
+// buildResolvConf writes the resolv.conf at path merging the DNS settings of
+// the passed endpoints, configs holding a copy of their container settings,
+// as documented on Endpoint.Join. With an embedded resolver r, the merged
+// nameservers become its forwarders and the resolver is listed in their place.
+func buildResolvConf(path string, eps []*endpoint, configs []containerConfig, r *resolver.Resolver) error {
+	var dns, dnsSearch, dnsOptions []string
+	hostNetwork := false
+
+	for i, ep := range eps {
+		dns = appendMissing(dns, configs[i].DNS)
+		dnsSearch = appendMissing(dnsSearch, configs[i].DNSSearch)
+		dnsOptions = appendMissing(dnsOptions, configs[i].DNSOptions)
+		if ep.network.Type() == "host" {
+			hostNetwork = true
+		}
+	}
+
+	if len(dns) == 0 || len(dnsSearch) == 0 || len(dnsOptions) == 0 {
+		resolvConf, err := resolvconf.Get()
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if len(dns) == 0 {
+			dns = resolvconf.GetNameservers(resolvConf)
+			// Loopback nameservers are only reachable from the host
+			// namespace, which the embedded resolver forwards from
+			if !hostNetwork && r == nil {
+				dns = resolvconf.FilterLocalNameservers(dns)
+			}
+		}
+		if len(dnsSearch) == 0 {
+			dnsSearch = resolvconf.GetSearchDomains(resolvConf)
+		}
+		if len(dnsOptions) == 0 {
+			dnsOptions = resolvconf.GetOptions(resolvConf)
+		}
+	}
 
-	name := ep.container.Config.Hostname
-	if ep.container.Config.Domainname != "" {
-		name = name + "." + ep.container.Config.Domainname
+	if r != nil {
+		r.SetForwarders(dns)
+		dns = []string{resolver.Address}
 	}
 
-	IP := ""
-	if ep.sandboxInfo != nil && ep.sandboxInfo.Interfaces[0] != nil &&
-		ep.sandboxInfo.Interfaces[0].Address != nil {
-		IP = ep.sandboxInfo.Interfaces[0].Address.IP.String()
+	return resolvconf.Build(path, dns, dnsSearch, dnsOptions)
+}
+
+func appendMissing(list []string, values []string) []string {
+outer:
+	for _, v := range values {
+		for _, l := range list {
+			if l == v {
+				continue outer
+			}
+		}
+		list = append(list, v)
 	}
+	return list
+}
 
-	return etchosts.Build(ep.container.Data.HostsPath, IP, ep.container.Config.Hostname,
-		ep.container.Config.Domainname, extraContent)
+// EndpointOptionAliases function returns an option setter for the aliases
+// the endpoint being created is also known by in its network.
+func EndpointOptionAliases(aliases ...string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.aliases = append([]string(nil), aliases...)
+	}
+}
+
+// EndpointOptionDefaultGateway function returns an option setter making the
+// endpoint being created provide the default routes of the sandboxes it
+// joins, taking them over from the endpoints joined before. Joining a
+// sandbox already joined by another such endpoint fails with
+// GatewayConflictError.
+func EndpointOptionDefaultGateway() EndpointOption {
+	return func(ep *endpoint) {
+		ep.gateway = gatewayRequired
+	}
+}
+
+// EndpointOptionSkipDefaultRoute function returns an option setter making the
+// endpoint being created never provide the default routes of the sandboxes
+// it joins. Only the routes to its subnets are added.
+func EndpointOptionSkipDefaultRoute() EndpointOption {
+	return func(ep *endpoint) {
+		ep.gateway = gatewaySkip
+	}
+}
+
+// EndpointOptionPriority function returns an option setter for the priority
+// of the endpoint being created in the sandboxes it joins, 0 by default. The
+// default routes of a sandbox are provided by its endpoint of highest
+// priority having a gateway, taking them over on join and handing them over
+// to the next one on leave, the endpoint joined first winning a tie. The
+// endpoints set with EndpointOptionDefaultGateway or
+// EndpointOptionSkipDefaultRoute disregard the priority. The interfaces of a
+// sandbox are never renamed: their numbering follows the priority as long as
+// the endpoints join in priority order, the highest first.
+func EndpointOptionPriority(priority int) EndpointOption {
+	return func(ep *endpoint) {
+		ep.priority = priority
+	}
+}
+
+// hasName tells whether the endpoint is known by the name, as its name or
+// one of its aliases.
+func (ep *endpoint) hasName(name string) bool {
+	if ep.name == name {
+		return true
+	}
+	for _, a := range ep.aliases {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDNSName tells whether the endpoint name or one of its aliases matches
+// the DNS name, regardless of the case.
+func (ep *endpoint) hasDNSName(name string) bool {
+	if strings.EqualFold(ep.name, name) {
+		return true
+	}
+	for _, a := range ep.aliases {
+		if strings.EqualFold(a, name) {
+			return true
+		}
+	}
+	return false
 }
 
 // JoinOptionHostname function returns an option setter for hostname option to
-// be passed to endpoint Join method.
+// be passed to endpoint Join method. The endpoints of a container share its
+// hostname: the join of an endpoint setting one other than the hostname of
+// another joined endpoint fails with HostnameConflictError.
 func JoinOptionHostname(name string) JoinOption {
 	return func(ep *endpoint) {
 		ep.container.Config.Hostname = name
@@ -255,6 +1529,110 @@ func JoinOptionDomainname(name string) JoinOption {
 	}
 }
 
+// JoinOptionExtraHost function returns an option setter for an extra host
+// entry mapping name to IP in the container hosts file, to be passed to
+// endpoint Join method.
+func JoinOptionExtraHost(name string, IP string) JoinOption {
+	return func(ep *endpoint) {
+		ep.container.Config.ExtraHosts = append(ep.container.Config.ExtraHosts, extraHost{name: name, IP: IP})
+	}
+}
+
+// JoinOptionDNS function returns an option setter for a DNS server to be
+// listed in the container resolv.conf, to be passed to endpoint Join method.
+func JoinOptionDNS(dns string) JoinOption {
+	return func(ep *endpoint) {
+		ep.container.Config.DNS = append(ep.container.Config.DNS, dns)
+	}
+}
+
+// JoinOptionDNSSearch function returns an option setter for a DNS search
+// domain to be listed in the container resolv.conf, to be passed to endpoint
+// Join method.
+func JoinOptionDNSSearch(search string) JoinOption {
+	return func(ep *endpoint) {
+		ep.container.Config.DNSSearch = append(ep.container.Config.DNSSearch, search)
+	}
+}
+
+// JoinOptionDNSOptions function returns an option setter for a resolver
+// option to be listed in the container resolv.conf, to be passed to endpoint
+// Join method.
+func JoinOptionDNSOptions(option string) JoinOption {
+	return func(ep *endpoint) {
+		ep.container.Config.DNSOptions = append(ep.container.Config.DNSOptions, option)
+	}
+}
+
+// JoinOptionInterfaceName function returns an option setter for the name the
+// interface of the endpoint takes in the container sandbox, to be passed to
+// endpoint Join method. The join fails with InterfaceNameError if another
+// interface of the sandbox has the name. Unless requested, the interfaces are
+// named eth0, eth1, ... with the lowest index free in the sandbox.
+func JoinOptionInterfaceName(name string) JoinOption {
+	return func(ep *endpoint) {
+		ep.container.Config.InterfaceName = name
+	}
+}
+
+// JoinOptionSysctls function returns an option setter for sysctls of the
+// network namespace, such as net.ipv4.conf.eth0.rp_filter, set in the
+// container sandbox once the interfaces of the endpoint are added, to be
+// passed to endpoint Join method. A sysctl the endpoints joined to the
+// sandbox set is restored to its previous value once the last of them
+// leaves. Joining fails with SysctlConflictError if another endpoint set a
+// sysctl to another value.
+func JoinOptionSysctls(sysctls map[string]string) JoinOption {
+	return func(ep *endpoint) {
+		if ep.container.Config.Sysctls == nil {
+			ep.container.Config.Sysctls = make(map[string]string, len(sysctls))
+		}
+		for name, value := range sysctls {
+			ep.container.Config.Sysctls[name] = value
+		}
+	}
+}
+
+// JoinOptionStaticRoutes function returns an option setter for static routes
+// added to the container sandbox along with the default routes, to be passed
+// to endpoint Join method. A route goes through the interface of the endpoint
+// whose subnet holds its next hop, an on-link route through the first one,
+// and the join fails with StaticRouteError when there is none. The routes are
+// removed on leave.
+func JoinOptionStaticRoutes(routes []StaticRoute) JoinOption {
+	return func(ep *endpoint) {
+		ep.container.Config.StaticRoutes = append(ep.container.Config.StaticRoutes, routes...)
+	}
+}
+
+// checkStaticRoute returns StaticRouteError if the route has no destination,
+// or a next hop of another family.
+func checkStaticRoute(r StaticRoute) error {
+	if r.Destination == nil {
+		return &StaticRouteError{Route: r, Reason: "no destination"}
+	}
+	if r.NextHop != nil && (r.Destination.IP.To4() == nil) != (r.NextHop.To4() == nil) {
+		return &StaticRouteError{Route: r, Reason: "next hop of another address family"}
+	}
+	return nil
+}
+
+// staticRouteIface returns the name in the sandbox of the interface among
+// ifaces the static route r goes through, empty if none.
+func staticRouteIface(ifaces []*sandbox.Interface, r StaticRoute) string {
+	for _, i := range ifaces {
+		if r.NextHop == nil {
+			return i.DstName
+		}
+		for _, addr := range i.Addresses() {
+			if addr.Contains(r.NextHop) {
+				return i.DstName
+			}
+		}
+	}
+	return ""
+}
+
 func (ep *endpoint) processOptions(options ...JoinOption) {
 	for _, opt := range options {
 		opt(ep)
@@ -0,0 +1,43 @@
+package libnetwork
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/sandbox"
+)
+
+func TestGatewayEndpoints(t *testing.T) {
+	none := &endpoint{}
+	v4 := &endpoint{sandboxInfo: &sandbox.Info{Gateway: net.ParseIP("172.17.42.1")}}
+	v6 := &endpoint{sandboxInfo: &sandbox.Info{GatewayIPv6: net.ParseIP("2001:db8::1")}}
+	dual := &endpoint{sandboxInfo: &sandbox.Info{
+		Gateway:     net.ParseIP("172.18.42.1"),
+		GatewayIPv6: net.ParseIP("2001:db8:1::1"),
+	}}
+	required := &endpoint{gateway: gatewayRequired, sandboxInfo: &sandbox.Info{Gateway: net.ParseIP("172.19.42.1")}}
+	skip := &endpoint{gateway: gatewaySkip, sandboxInfo: dual.sandboxInfo}
+	high := &endpoint{priority: 10, sandboxInfo: v4.sandboxInfo}
+	tied := &endpoint{priority: 10, sandboxInfo: dual.sandboxInfo}
+
+	for _, c := range []struct {
+		eps      []*endpoint
+		gw4, gw6 *endpoint
+	}{
+		{nil, nil, nil},
+		{[]*endpoint{none}, nil, nil},
+		{[]*endpoint{none, v4, dual}, v4, dual},
+		{[]*endpoint{v6, dual, v4}, dual, v6},
+		{[]*endpoint{dual, v4, v6}, dual, dual},
+		{[]*endpoint{dual, required}, required, dual},
+		{[]*endpoint{skip, v4}, v4, nil},
+		{[]*endpoint{dual, high}, high, dual},
+		{[]*endpoint{high, tied}, high, tied},
+		{[]*endpoint{high, required}, required, nil},
+	} {
+		gw4, gw6 := gatewayEndpoints(c.eps)
+		if gw4 != c.gw4 || gw6 != c.gw6 {
+			t.Fatalf("Unexpected gateway endpoints for %v: got %v, %v", c.eps, gw4, gw6)
+		}
+	}
+}
@@ -1,22 +1,82 @@
 package libnetwork
 
 import (
-	"errors"
 	"fmt"
+	"strings"
+
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/types"
 )
 
 var (
 	// ErrNilNetworkDriver is returned if a nil network driver
 	// is passed to NewNetwork api.
-	ErrNilNetworkDriver = errors.New("nil NetworkDriver instance")
+	ErrNilNetworkDriver = types.InvalidParameterErrorf("nil NetworkDriver instance")
 	// ErrInvalidNetworkDriver is returned if an invalid driver
 	// instance is passed.
-	ErrInvalidNetworkDriver = errors.New("invalid driver bound to network")
-	// ErrInvalidJoin is returned if a join is attempted on an endpoint
+	ErrInvalidNetworkDriver = types.InvalidParameterErrorf("invalid driver bound to network")
+	// ErrEndpointInUse is returned if a join is attempted on an endpoint
 	// which already has a container joined.
-	ErrInvalidJoin = errors.New("A container has already joined the endpoint")
+	ErrEndpointInUse = types.ForbiddenErrorf("A container has already joined the endpoint")
+	// ErrInvalidJoin is the former name of ErrEndpointInUse, kept for
+	// compatibility.
+	ErrInvalidJoin = ErrEndpointInUse
+	// ErrNoContainer is returned if a leave is attempted on an endpoint
+	// which has no container joined.
+	ErrNoContainer = types.ForbiddenErrorf("no container attached to the endpoint")
+	// ErrHostNetworkConflict is returned if a container sharing the network
+	// namespace of a host network attempts to join an endpoint of any other
+	// network, or the other way around.
+	ErrHostNetworkConflict = types.ForbiddenErrorf("container can not mix a host network with other networks")
+	// ErrDriverExists is returned if a driver is registered for a network
+	// type which already has one.
+	ErrDriverExists = types.ForbiddenErrorf("a driver is already registered for the network type")
+	// ErrEmptyNetworkType is returned if a driver is registered for an
+	// empty network type.
+	ErrEmptyNetworkType = types.InvalidParameterErrorf("network type can not be empty")
+	// ErrNoDefaultDriver is returned if a network is created without a
+	// network type while the controller has no default driver set.
+	ErrNoDefaultDriver = types.InvalidParameterErrorf("no network type given and no default driver set")
+	// ErrNoUniqueID is returned if the id generator keeps returning ids
+	// already used by a network or an endpoint.
+	ErrNoUniqueID = types.UnavailableErrorf("could not generate a unique id")
+	// ErrDriverNotConfigured is returned if a network is created for a
+	// driver which requires a configuration before one was applied.
+	ErrDriverNotConfigured = types.UnavailableErrorf("network driver is not configured")
+	// ErrControllerStopped is returned if a network is created or the
+	// controller stopped once the controller was stopped.
+	ErrControllerStopped = types.UnavailableErrorf("network controller is stopped")
+	// ErrHostNetworkSysctls is returned if sysctls are passed to the join
+	// of an endpoint of a host network, which would set them on the host.
+	ErrHostNetworkSysctls = types.ForbiddenErrorf("sysctls can not be set in the host network namespace")
+	// ErrNoAvailableIPs is returned if an endpoint is created while all the
+	// addresses of the network subnet are in use, as Network.AddressCount
+	// reports.
+	ErrNoAvailableIPs = ipamapi.ErrNoAvailableIPs
+	// ErrUnsupportedFeature is wrapped by the UnsupportedFeatureError
+	// returned if a network or an endpoint requests a feature its driver
+	// does not support.
+	ErrUnsupportedFeature = types.InvalidParameterErrorf("feature not supported by the network driver")
+	// ErrInsufficientPrivileges is wrapped by the
+	// InsufficientPrivilegesError returned if a driver is used without the
+	// privileges it needs to program the host.
+	ErrInsufficientPrivileges = types.ForbiddenErrorf("insufficient privileges for the network driver")
+	// ErrNoAlivePredicate is returned by GC if it is called without the
+	// predicate telling the sandboxes in use by others.
+	ErrNoAlivePredicate = types.InvalidParameterErrorf("no predicate given for the sandboxes in use")
 )
 
+// ReloadNotSupportedError is returned when the configuration of the driver of
+// the network type can not be reloaded.
+type ReloadNotSupportedError string
+
+func (rnse ReloadNotSupportedError) Error() string {
+	return fmt.Sprintf("driver of network type %s can not reload its configuration", string(rnse))
+}
+
+// Forbidden denotes the type of this error
+func (rnse ReloadNotSupportedError) Forbidden() {}
+
 // NetworkTypeError type is returned when the network type string is not
 // known to libnetwork.
 type NetworkTypeError string
@@ -25,6 +85,9 @@ func (nt NetworkTypeError) Error() string {
 	return fmt.Sprintf("unknown driver %q", string(nt))
 }
 
+// NotFound denotes the type of this error
+func (nt NetworkTypeError) NotFound() {}
+
 // NetworkNameError is returned when a network with the same name already exists.
 type NetworkNameError string
 
@@ -32,6 +95,107 @@ func (name NetworkNameError) Error() string {
 	return fmt.Sprintf("network with name %s already exists", string(name))
 }
 
+// Forbidden denotes the type of this error
+func (name NetworkNameError) Forbidden() {}
+
+// EndpointNameError is returned when an endpoint alias is already used as a
+// name or an alias by an endpoint of the same network, or an endpoint name
+// is already used as an alias.
+type EndpointNameError string
+
+func (name EndpointNameError) Error() string {
+	return fmt.Sprintf("endpoint with name %s already exists", string(name))
+}
+
+// Forbidden denotes the type of this error
+func (name EndpointNameError) Forbidden() {}
+
+// EndpointSpecError reports why one of the specs passed to
+// Network.CreateEndpoints failed.
+type EndpointSpecError struct {
+	// Index of the spec in the passed list
+	Index int
+	Name  string
+	Err   error
+}
+
+func (e *EndpointSpecError) Error() string {
+	return fmt.Sprintf("endpoint %s (spec %d): %v", e.Name, e.Index, e.Err)
+}
+
+// Unwrap returns the error the spec failed with.
+func (e *EndpointSpecError) Unwrap() error {
+	return e.Err
+}
+
+// CreateEndpointsError is returned by Network.CreateEndpoints when some of
+// the specs failed, in which case none of the endpoints was created. All the
+// invalid specs are listed, while the creation stops at the first spec the
+// driver fails on.
+type CreateEndpointsError struct {
+	Errors []*EndpointSpecError
+}
+
+func (e *CreateEndpointsError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, se := range e.Errors {
+		msgs = append(msgs, se.Error())
+	}
+	return fmt.Sprintf("failed to create the endpoints: %s", strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the errors of the failed specs.
+func (e *CreateEndpointsError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, se := range e.Errors {
+		errs = append(errs, se)
+	}
+	return errs
+}
+
+// ConfigEntryError reports why one of the drivers or networks of the document
+// passed to NetworkController.LoadConfig failed.
+type ConfigEntryError struct {
+	// Kind is "driver" or "network"
+	Kind string
+	// Name is the network type of a driver, the name of a network
+	Name string
+	Err  error
+}
+
+func (e *ConfigEntryError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Kind, e.Name, e.Err)
+}
+
+// Unwrap returns the error the entry failed with.
+func (e *ConfigEntryError) Unwrap() error {
+	return e.Err
+}
+
+// LoadConfigError is returned by NetworkController.LoadConfig when some of
+// the drivers or networks of the document failed, in which case the other
+// ones were still applied.
+type LoadConfigError struct {
+	Errors []*ConfigEntryError
+}
+
+func (e *LoadConfigError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, ce := range e.Errors {
+		msgs = append(msgs, ce.Error())
+	}
+	return fmt.Sprintf("failed to load the configuration: %s", strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the errors of the failed entries.
+func (e *LoadConfigError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, ce := range e.Errors {
+		errs = append(errs, ce)
+	}
+	return errs
+}
+
 // UnknownNetworkError is returned when libnetwork could not find in it's database
 // a network with the same name and id.
 type UnknownNetworkError struct {
@@ -43,6 +207,9 @@ func (une *UnknownNetworkError) Error() string {
 	return fmt.Sprintf("unknown network %s id %s", une.name, une.id)
 }
 
+// NotFound denotes the type of this error
+func (une *UnknownNetworkError) NotFound() {}
+
 // ActiveEndpointsError is returned when a network is deleted which has active
 // endpoints in it.
 type ActiveEndpointsError struct {
@@ -54,6 +221,9 @@ func (aee *ActiveEndpointsError) Error() string {
 	return fmt.Sprintf("network with name %s id %s has active endpoints", aee.name, aee.id)
 }
 
+// Forbidden denotes the type of this error
+func (aee *ActiveEndpointsError) Forbidden() {}
+
 // UnknownEndpointError is returned when libnetwork could not find in it's database
 // an endpoint with the same name and id.
 type UnknownEndpointError struct {
@@ -65,6 +235,68 @@ func (uee *UnknownEndpointError) Error() string {
 	return fmt.Sprintf("unknown endpoint %s id %s", uee.name, uee.id)
 }
 
+// NotFound denotes the type of this error
+func (uee *UnknownEndpointError) NotFound() {}
+
+// GenericDataNotFoundError is returned when the generic data of a network or
+// an endpoint holds no value under the key.
+type GenericDataNotFoundError string
+
+func (key GenericDataNotFoundError) Error() string {
+	return fmt.Sprintf("no generic data under key %s", string(key))
+}
+
+// NotFound denotes the type of this error
+func (key GenericDataNotFoundError) NotFound() {}
+
+// LeasedEndpointError is returned when a container joins an endpoint whose
+// address is leased to another container.
+type LeasedEndpointError struct {
+	name      string
+	id        string
+	container string
+}
+
+func (lee *LeasedEndpointError) Error() string {
+	return fmt.Sprintf("endpoint %s id %s is leased to container %s", lee.name, lee.id, lee.container)
+}
+
+// Forbidden denotes the type of this error
+func (lee *LeasedEndpointError) Forbidden() {}
+
+// EndpointStateError is returned when an operation is attempted on an
+// endpoint whose state does not allow it, such as moving an endpoint already
+// in a sandbox.
+type EndpointStateError struct {
+	name      string
+	id        string
+	state     EndpointState
+	operation string
+}
+
+func (ese *EndpointStateError) Error() string {
+	return fmt.Sprintf("can not %s endpoint %s id %s which is %s", ese.operation, ese.name, ese.id, ese.state)
+}
+
+// State returns the state of the endpoint which did not allow the operation.
+func (ese *EndpointStateError) State() EndpointState {
+	return ese.state
+}
+
+// Forbidden denotes the type of this error
+func (ese *EndpointStateError) Forbidden() {}
+
+// InvalidSandboxKeyError is returned when an endpoint is moved to a sandbox
+// with an invalid key.
+type InvalidSandboxKeyError string
+
+func (key InvalidSandboxKeyError) Error() string {
+	return fmt.Sprintf("invalid sandbox key %q", string(key))
+}
+
+// InvalidParameter denotes the type of this error
+func (key InvalidSandboxKeyError) InvalidParameter() {}
+
 // InvalidContainerIDError is returned when an invalid container id is passed
 // in Join/Leave
 type InvalidContainerIDError string
@@ -72,3 +304,179 @@ type InvalidContainerIDError string
 func (id InvalidContainerIDError) Error() string {
 	return fmt.Sprintf("invalid container id %s", string(id))
 }
+
+// InvalidParameter denotes the type of this error
+func (id InvalidContainerIDError) InvalidParameter() {}
+
+// InvalidNameError is returned when a network or endpoint name does not
+// start with a letter or digit followed by letters, digits, '_', '.' or '-',
+// or is longer than 255 characters.
+type InvalidNameError string
+
+func (name InvalidNameError) Error() string {
+	return fmt.Sprintf("invalid name %q", string(name))
+}
+
+// InvalidParameter denotes the type of this error
+func (name InvalidNameError) InvalidParameter() {}
+
+// GatewayConflictError is returned when an endpoint requiring to provide the
+// default gateway joins a sandbox in which the named endpoint already does.
+type GatewayConflictError string
+
+func (name GatewayConflictError) Error() string {
+	return fmt.Sprintf("endpoint %s already provides the default gateway of the sandbox", string(name))
+}
+
+// Forbidden denotes the type of this error
+func (name GatewayConflictError) Forbidden() {}
+
+// SysctlConflictError is returned when an endpoint joins a sandbox with a
+// sysctl which another joined endpoint set to another value.
+type SysctlConflictError struct {
+	Name  string
+	Value string
+}
+
+func (e *SysctlConflictError) Error() string {
+	return fmt.Sprintf("sysctl %s is already set to %s in the sandbox", e.Name, e.Value)
+}
+
+// Forbidden denotes the type of this error
+func (e *SysctlConflictError) Forbidden() {}
+
+// HostnameConflictError is returned when an endpoint joins a sandbox with a
+// hostname while another joined endpoint set it to the one the error holds.
+type HostnameConflictError string
+
+func (name HostnameConflictError) Error() string {
+	return fmt.Sprintf("hostname of the sandbox is already set to %s", string(name))
+}
+
+// Forbidden denotes the type of this error
+func (name HostnameConflictError) Forbidden() {}
+
+// InterfaceNameError is returned when the interface name requested in Join is
+// already used by another interface of the sandbox.
+type InterfaceNameError string
+
+func (name InterfaceNameError) Error() string {
+	return fmt.Sprintf("interface name %s is already used in the sandbox", string(name))
+}
+
+// Forbidden denotes the type of this error
+func (name InterfaceNameError) Forbidden() {}
+
+// SandboxProgrammingError is returned by Join and MoveToSandbox when
+// programming the sandbox fails. The interfaces, routes and gateways of the
+// endpoint are removed from the sandbox beforehand, which is destroyed if no
+// other endpoint is attached to it. Container is empty for an endpoint moved
+// to the sandbox.
+type SandboxProgrammingError struct {
+	Container string
+	Sandbox   string
+	Err       error
+}
+
+func (e *SandboxProgrammingError) Error() string {
+	if e.Container == "" {
+		return fmt.Sprintf("failed to program the sandbox %s: %v", e.Sandbox, e.Err)
+	}
+	return fmt.Sprintf("failed to program the sandbox of container %s: %v", e.Container, e.Err)
+}
+
+// Unwrap returns the error the sandbox programming failed with.
+func (e *SandboxProgrammingError) Unwrap() error {
+	return e.Err
+}
+
+// StaticRouteError is returned when a static route passed in Join can not be
+// added to the sandbox, for the reason given.
+type StaticRouteError struct {
+	Route  StaticRoute
+	Reason string
+}
+
+func (e *StaticRouteError) Error() string {
+	return fmt.Sprintf("invalid static route to %v via %v: %s", e.Route.Destination, e.Route.NextHop, e.Reason)
+}
+
+// InvalidParameter denotes the type of this error
+func (e *StaticRouteError) InvalidParameter() {}
+
+// EndpointOptionsTypeError is returned when an endpoint is created with driver
+// options which can not be merged with the default endpoint options of the
+// network, as they are not an options.Generic. It holds the options type.
+type EndpointOptionsTypeError string
+
+func (t EndpointOptionsTypeError) Error() string {
+	return fmt.Sprintf("endpoint options of type %s can not be merged with the network defaults", string(t))
+}
+
+// InvalidParameter denotes the type of this error
+func (t EndpointOptionsTypeError) InvalidParameter() {}
+
+// InvalidNameserverError is returned when a DNS server passed to
+// Endpoint.UpdateDNS is not an IP address.
+type InvalidNameserverError string
+
+func (ns InvalidNameserverError) Error() string {
+	return fmt.Sprintf("invalid DNS server %q", string(ns))
+}
+
+// InvalidParameter denotes the type of this error
+func (ns InvalidNameserverError) InvalidParameter() {}
+
+// InvalidHostnameError is returned when the hostname passed in Join is not a
+// valid RFC 1123 host name.
+type InvalidHostnameError string
+
+func (name InvalidHostnameError) Error() string {
+	return fmt.Sprintf("invalid hostname %q", string(name))
+}
+
+// InvalidParameter denotes the type of this error
+func (name InvalidHostnameError) InvalidParameter() {}
+
+// InsufficientPrivilegesError is returned if the driver of the network type
+// needs the CAP_NET_ADMIN capability the process lacks, or if the host
+// denied it an operation with Err. It wraps ErrInsufficientPrivileges.
+type InsufficientPrivilegesError struct {
+	NetworkType string
+	Err         error
+}
+
+func (e *InsufficientPrivilegesError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("network type %s is not permitted to program the host: %v", e.NetworkType, e.Err)
+	}
+	return fmt.Sprintf("network type %s requires the CAP_NET_ADMIN capability", e.NetworkType)
+}
+
+// Unwrap returns ErrInsufficientPrivileges.
+func (e *InsufficientPrivilegesError) Unwrap() error {
+	return ErrInsufficientPrivileges
+}
+
+// Forbidden denotes the type of this error
+func (e *InsufficientPrivilegesError) Forbidden() {}
+
+// UnsupportedFeatureError is returned if the network or endpoint specific
+// options request a feature the driver of the network type does not
+// advertise in its capabilities. It wraps ErrUnsupportedFeature.
+type UnsupportedFeatureError struct {
+	NetworkType string
+	Feature     string
+}
+
+func (e *UnsupportedFeatureError) Error() string {
+	return fmt.Sprintf("network type %s does not support %s", e.NetworkType, e.Feature)
+}
+
+// Unwrap returns ErrUnsupportedFeature.
+func (e *UnsupportedFeatureError) Unwrap() error {
+	return ErrUnsupportedFeature
+}
+
+// InvalidParameter denotes the type of this error
+func (e *UnsupportedFeatureError) InvalidParameter() {}
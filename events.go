@@ -0,0 +1,106 @@
+package libnetwork
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType identifies the lifecycle operation an Event reports.
+type EventType string
+
+const (
+	// EventNetworkCreate is emitted when a network is created.
+	EventNetworkCreate EventType = "network-create"
+	// EventNetworkUpdate is emitted when the options of a network change.
+	EventNetworkUpdate EventType = "network-update"
+	// EventNetworkDelete is emitted when a network is deleted.
+	EventNetworkDelete EventType = "network-delete"
+	// EventEndpointCreate is emitted when an endpoint is created.
+	EventEndpointCreate EventType = "endpoint-create"
+	// EventEndpointJoin is emitted when a container joins an endpoint.
+	EventEndpointJoin EventType = "endpoint-join"
+	// EventEndpointDNSUpdate is emitted when the DNS settings of a joined
+	// endpoint change.
+	EventEndpointDNSUpdate EventType = "endpoint-dns-update"
+	// EventEndpointLeave is emitted when a container leaves an endpoint.
+	EventEndpointLeave EventType = "endpoint-leave"
+	// EventEndpointAttach is emitted when an endpoint is moved to a
+	// sandbox.
+	EventEndpointAttach EventType = "endpoint-attach"
+	// EventEndpointDetach is emitted when an endpoint is removed from the
+	// sandbox it was moved to.
+	EventEndpointDetach EventType = "endpoint-detach"
+	// EventEndpointDelete is emitted when an endpoint is deleted.
+	EventEndpointDelete EventType = "endpoint-delete"
+	// EventDriverReload is emitted when the configuration of a driver is
+	// reloaded, with the network type of the driver as the ID.
+	EventDriverReload EventType = "driver-reload"
+)
+
+// eventBufferSize is the number of events buffered for each subscriber.
+// Events are dropped for a subscriber which lets its buffer fill up, so that
+// a slow subscriber can never block the controller.
+const eventBufferSize = 64
+
+// Event describes a lifecycle change of a network, an endpoint or a driver.
+type Event struct {
+	// Type of the lifecycle operation.
+	Type EventType
+
+	// ID of the network or endpoint the event refers to, or the network
+	// type of the driver.
+	ID string
+
+	// Controller is the id of the controller which emitted the event.
+	Controller string
+
+	// Time at which the event was emitted.
+	Time time.Time
+}
+
+type eventBroker struct {
+	controller  string
+	subscribers map[int]chan Event
+	next        int
+	sync.Mutex
+}
+
+func newEventBroker(controller string) *eventBroker {
+	return &eventBroker{controller: controller, subscribers: make(map[int]chan Event)}
+}
+
+func (b *eventBroker) subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBufferSize)
+
+	b.Lock()
+	id := b.next
+	b.next++
+	b.subscribers[id] = ch
+	b.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.Lock()
+			delete(b.subscribers, id)
+			b.Unlock()
+			close(ch)
+		})
+	}
+
+	return ch, cancel
+}
+
+func (b *eventBroker) publish(t EventType, id string) {
+	ev := Event{Type: t, ID: id, Controller: b.controller, Time: time.Now()}
+
+	b.Lock()
+	defer b.Unlock()
+
+	for _, ch := range b.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
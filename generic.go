@@ -0,0 +1,175 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/docker/libnetwork/types"
+)
+
+// genericData is the opaque data the applications and the drivers keep with
+// a network or an endpoint. The values are held in their JSON encoding, as
+// they are persisted, so that they decode the same before and after a
+// restart.
+type genericData struct {
+	data map[string]json.RawMessage
+	sync.Mutex
+}
+
+// set stores the JSON encoding of value under key, removing the key for a
+// nil value. It returns the function putting the previous value back.
+func (g *genericData) set(key string, value interface{}) (func(), error) {
+	var b json.RawMessage
+	if value != nil {
+		var err error
+		if b, err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	prev, existed := g.data[key]
+	g.put(key, b)
+	return func() {
+		g.Lock()
+		defer g.Unlock()
+		if !existed {
+			prev = nil
+		}
+		g.put(key, prev)
+	}, nil
+}
+
+// put stores b under key, or removes the key when b is nil. The data map is
+// replaced rather than modified so that the copies handed out stay intact.
+// It must be called with the lock held.
+func (g *genericData) put(key string, b json.RawMessage) {
+	data := make(map[string]json.RawMessage, len(g.data)+1)
+	for k, v := range g.data {
+		data[k] = v
+	}
+	if b == nil {
+		delete(data, key)
+	} else {
+		data[key] = b
+	}
+	g.data = data
+}
+
+// get decodes the value stored under key into v.
+func (g *genericData) get(key string, v interface{}) error {
+	g.Lock()
+	b, ok := g.data[key]
+	g.Unlock()
+
+	if !ok {
+		return GenericDataNotFoundError(key)
+	}
+	return json.Unmarshal(b, v)
+}
+
+// snapshot returns the data, nil if there is none. It must not be modified.
+func (g *genericData) snapshot() map[string]json.RawMessage {
+	g.Lock()
+	defer g.Unlock()
+
+	if len(g.data) == 0 {
+		return nil
+	}
+	return g.data
+}
+
+func (n *network) SetGenericData(key string, value interface{}) error {
+	undo, err := n.generic.set(key, value)
+	if err != nil {
+		return err
+	}
+
+	if err := n.ctrlr.store.PutObject(n); err != nil {
+		undo()
+		return err
+	}
+	return nil
+}
+
+func (n *network) GenericData(key string, v interface{}) error {
+	return n.generic.get(key, v)
+}
+
+func (ep *endpoint) SetGenericData(key string, value interface{}) error {
+	undo, err := ep.generic.set(key, value)
+	if err != nil {
+		return err
+	}
+
+	if err := ep.network.ctrlr.store.PutObject(ep); err != nil {
+		undo()
+		return err
+	}
+	return nil
+}
+
+func (ep *endpoint) GenericData(key string, v interface{}) error {
+	return ep.generic.get(key, v)
+}
+
+// The controller is the driverapi.GenericDataStore of the drivers.
+
+func (c *controller) SetNetworkData(nid types.UUID, key string, value interface{}) error {
+	n, err := c.networkByUUID(nid)
+	if err != nil {
+		return err
+	}
+	return n.SetGenericData(key, value)
+}
+
+func (c *controller) NetworkData(nid types.UUID, key string, v interface{}) error {
+	n, err := c.networkByUUID(nid)
+	if err != nil {
+		return err
+	}
+	return n.GenericData(key, v)
+}
+
+func (c *controller) SetEndpointData(nid, eid types.UUID, key string, value interface{}) error {
+	ep, err := c.endpointByUUID(nid, eid)
+	if err != nil {
+		return err
+	}
+	return ep.SetGenericData(key, value)
+}
+
+func (c *controller) EndpointData(nid, eid types.UUID, key string, v interface{}) error {
+	ep, err := c.endpointByUUID(nid, eid)
+	if err != nil {
+		return err
+	}
+	return ep.GenericData(key, v)
+}
+
+func (c *controller) networkByUUID(nid types.UUID) (*network, error) {
+	c.Lock()
+	n, ok := c.networks[nid]
+	c.Unlock()
+	if !ok {
+		return nil, &UnknownNetworkError{id: string(nid)}
+	}
+	return n, nil
+}
+
+func (c *controller) endpointByUUID(nid, eid types.UUID) (*endpoint, error) {
+	n, err := c.networkByUUID(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Lock()
+	ep, ok := n.endpoints[eid]
+	n.Unlock()
+	if !ok {
+		return nil, &UnknownEndpointError{id: string(eid)}
+	}
+	return ep, nil
+}
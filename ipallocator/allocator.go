@@ -3,12 +3,12 @@
 package ipallocator
 
 import (
-	"errors"
 	"math/big"
 	"net"
 	"sync"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/ipamapi"
 	"github.com/docker/libnetwork/netutils"
 )
 
@@ -37,15 +37,15 @@ type networkSet map[string]*allocatedMap
 
 var (
 	// ErrNoAvailableIPs preformatted error
-	ErrNoAvailableIPs = errors.New("no available ip addresses on network")
+	ErrNoAvailableIPs = ipamapi.ErrNoAvailableIPs
 	// ErrIPAlreadyAllocated preformatted error
-	ErrIPAlreadyAllocated = errors.New("ip already allocated")
+	ErrIPAlreadyAllocated = ipamapi.ErrIPAlreadyAllocated
 	// ErrIPOutOfRange preformatted error
-	ErrIPOutOfRange = errors.New("requested ip is out of range")
+	ErrIPOutOfRange = ipamapi.ErrIPOutOfRange
 	// ErrNetworkAlreadyRegistered preformatted error
-	ErrNetworkAlreadyRegistered = errors.New("network already registered")
+	ErrNetworkAlreadyRegistered = ipamapi.ErrPoolAlreadyRegistered
 	// ErrBadSubnet preformatted error
-	ErrBadSubnet = errors.New("network does not contain specified subnet")
+	ErrBadSubnet = ipamapi.ErrBadSubPool
 )
 
 // IPAllocator manages the ipam
@@ -119,6 +119,77 @@ func (a *IPAllocator) ReleaseIP(network *net.IPNet, ip net.IP) error {
 	return nil
 }
 
+// RequestPool registers the pool in the allocator, as RegisterSubnet does,
+// restricting the allocation range to subPool when not nil.
+func (a *IPAllocator) RequestPool(pool, subPool *net.IPNet) error {
+	if subPool == nil {
+		subPool = pool
+	}
+	return a.RegisterSubnet(pool, subPool)
+}
+
+// ReleasePool forgets the pool along with the addresses allocated from it.
+func (a *IPAllocator) ReleasePool(pool *net.IPNet) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	delete(a.allocatedIPs, pool.String())
+	return nil
+}
+
+// RequestAddress is the ipamapi.IPAM flavor of RequestIP.
+func (a *IPAllocator) RequestAddress(pool *net.IPNet, preferred net.IP) (net.IP, error) {
+	return a.RequestIP(pool, preferred)
+}
+
+// ReleaseAddress is the ipamapi.IPAM flavor of ReleaseIP.
+func (a *IPAllocator) ReleaseAddress(pool *net.IPNet, ip net.IP) error {
+	return a.ReleaseIP(pool, ip)
+}
+
+// Allocated returns the number of addresses allocated from network, and
+// whether network is registered in the allocator.
+func (a *IPAllocator) Allocated(network *net.IPNet) (int, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	allocated, ok := a.allocatedIPs[network.String()]
+	if !ok {
+		return 0, false
+	}
+	return len(allocated.p), true
+}
+
+// CountAddresses returns the number of addresses which can be allocated from
+// network, within the range it was registered with, and the number of them
+// allocated. A network not registered yet has its full range.
+func (a *IPAllocator) CountAddresses(network *net.IPNet) (total, allocated int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	n, ok := a.allocatedIPs[network.String()]
+	if !ok {
+		n = newAllocatedMap(network)
+	}
+	size := big.NewInt(0).Sub(n.end, n.begin)
+	if size.Sign() < 0 {
+		return 0, 0
+	}
+	return int(size.Int64()) + 1, len(n.p)
+}
+
+// Usage returns the number of networks registered in the allocator and of
+// the addresses allocated from them.
+func (a *IPAllocator) Usage() (networks, addresses int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for _, allocated := range a.allocatedIPs {
+		addresses += len(allocated.p)
+	}
+	return len(a.allocatedIPs), addresses
+}
+
 func (allocated *allocatedMap) checkIP(ip net.IP) (net.IP, error) {
 	if _, ok := allocated.p[ip.String()]; ok {
 		return nil, ErrIPAlreadyAllocated
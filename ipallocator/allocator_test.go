@@ -5,6 +5,8 @@ import (
 	"math/big"
 	"net"
 	"testing"
+
+	"github.com/docker/libnetwork/ipamapi"
 )
 
 func TestConversion(t *testing.T) {
@@ -132,6 +134,37 @@ func TestReleaseIp(t *testing.T) {
 	}
 }
 
+func TestAllocatedUsage(t *testing.T) {
+	a := New()
+
+	network := &net.IPNet{
+		IP:   []byte{192, 168, 0, 1},
+		Mask: []byte{255, 255, 255, 0},
+	}
+	if _, ok := a.Allocated(network); ok {
+		t.Fatal("Unrequested network reported registered")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := a.RequestIP(network, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count, ok := a.Allocated(network); !ok || count != 2 {
+		t.Fatalf("Expected 2 addresses allocated, got %d", count)
+	}
+	if networks, addresses := a.Usage(); networks != 1 || addresses != 2 {
+		t.Fatalf("Expected 1 network and 2 addresses in use, got %d and %d", networks, addresses)
+	}
+
+	if err := a.ReleasePool(network); err != nil {
+		t.Fatal(err)
+	}
+	if networks, addresses := a.Usage(); networks != 0 || addresses != 0 {
+		t.Fatalf("Expected no usage after the release, got %d networks and %d addresses", networks, addresses)
+	}
+}
+
 func TestReleaseIpV6(t *testing.T) {
 	a := New()
 
@@ -688,3 +721,47 @@ func BenchmarkRequestIP(b *testing.B) {
 		}
 	}
 }
+
+func TestIPAMPool(t *testing.T) {
+	var a ipamapi.IPAM = New()
+
+	pool := &net.IPNet{IP: []byte{192, 168, 0, 1}, Mask: []byte{255, 255, 0, 0}}
+	subPool := &net.IPNet{IP: []byte{192, 168, 3, 0}, Mask: []byte{255, 255, 255, 0}}
+
+	if err := a.RequestPool(pool, subPool); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.RequestPool(pool, nil); err != ipamapi.ErrPoolAlreadyRegistered {
+		t.Fatalf("Expected ErrPoolAlreadyRegistered, got %v", err)
+	}
+
+	ip, err := a.RequestAddress(pool, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "192.168.3.1"; ip.String() != expected {
+		t.Fatalf("Expected ip %s, got %s", expected, ip)
+	}
+
+	if _, err := a.RequestAddress(pool, ip); err != ipamapi.ErrIPAlreadyAllocated {
+		t.Fatalf("Expected ErrIPAlreadyAllocated, got %v", err)
+	}
+
+	if err := a.ReleaseAddress(pool, ip); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.RequestAddress(pool, ip); err != nil {
+		t.Fatalf("Failed to request the released ip: %v", err)
+	}
+
+	// Releasing the pool forgets both the range and the allocations
+	if err := a.ReleasePool(pool); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.RequestPool(pool, nil); err != nil {
+		t.Fatalf("Failed to request the released pool: %v", err)
+	}
+	if _, err := a.RequestAddress(pool, ip); err != nil {
+		t.Fatalf("Failed to request an ip of the released pool: %v", err)
+	}
+}
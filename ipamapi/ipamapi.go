@@ -0,0 +1,51 @@
+// Package ipamapi defines the interface the IP address allocators plugged into
+// libnetwork drivers need to implement.
+package ipamapi
+
+import (
+	"net"
+
+	"github.com/docker/libnetwork/types"
+)
+
+var (
+	// ErrNoAvailableIPs is returned when the pool has no address left to allocate
+	ErrNoAvailableIPs = types.UnavailableErrorf("no available ip addresses on network")
+	// ErrIPAlreadyAllocated is returned when the requested address is already in use
+	ErrIPAlreadyAllocated = types.ForbiddenErrorf("ip already allocated")
+	// ErrIPOutOfRange is returned when the requested address does not belong to the pool
+	ErrIPOutOfRange = types.InvalidParameterErrorf("requested ip is out of range")
+	// ErrPoolAlreadyRegistered is returned when the pool was already requested
+	ErrPoolAlreadyRegistered = types.ForbiddenErrorf("network already registered")
+	// ErrBadSubPool is returned when the sub pool is not contained in the pool
+	ErrBadSubPool = types.InvalidParameterErrorf("network does not contain specified subnet")
+)
+
+// IPAM is the interface an IP address manager needs to implement to allocate
+// the addresses of a driver network.
+type IPAM interface {
+	// RequestPool registers the pool of addresses of a network. When subPool
+	// is not nil, addresses are only allocated within it. A pool which is not
+	// explicitly requested is registered with its full range on the first
+	// address request.
+	RequestPool(pool, subPool *net.IPNet) error
+
+	// ReleasePool releases the pool and all the addresses allocated from it.
+	ReleasePool(pool *net.IPNet) error
+
+	// RequestAddress allocates the preferred address from the pool, or the
+	// next available one when preferred is nil.
+	RequestAddress(pool *net.IPNet, preferred net.IP) (net.IP, error)
+
+	// ReleaseAddress returns the address to the pool.
+	ReleaseAddress(pool *net.IPNet, ip net.IP) error
+}
+
+// Counter is the optional interface of the IP address managers able to count
+// the addresses of their pools.
+type Counter interface {
+	// CountAddresses returns the number of addresses of the pool which can
+	// be allocated, within its sub pool if any, and the number of them
+	// allocated or reserved.
+	CountAddresses(pool *net.IPNet) (total, allocated int)
+}
@@ -1,13 +1,35 @@
 package libnetwork_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/reexec"
 	"github.com/docker/libnetwork"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/drivers/null"
 	"github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/pkg/options"
+	"github.com/docker/libnetwork/resolvconf"
+	"github.com/docker/libnetwork/resolver"
+	"github.com/docker/libnetwork/sandbox"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
 )
 
 const (
@@ -15,6 +37,13 @@ const (
 	bridgeName = "dockertest0"
 )
 
+func TestMain(m *testing.M) {
+	if reexec.Init() {
+		return
+	}
+	os.Exit(m.Run())
+}
+
 func createTestNetwork(networkType, networkName string, option options.Generic) (libnetwork.Network, error) {
 	controller := libnetwork.New()
 
@@ -63,167 +92,251 @@ func TestNull(t *testing.T) {
 	}
 }
 
-func TestBridge(t *testing.T) {
-	defer netutils.SetupTestNetNS(t)()
-	ip, subnet, err := net.ParseCIDR("192.168.100.1/24")
+func TestNewNetworkDefaultDriver(t *testing.T) {
+	controller := libnetwork.New()
+
+	if _, err := controller.NewNetwork("", "network1", nil); err != libnetwork.ErrNoDefaultDriver {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrNoDefaultDriver, err)
+	}
+	if err := controller.ValidateNetworkConfig("", nil); err != libnetwork.ErrNoDefaultDriver {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrNoDefaultDriver, err)
+	}
+	if !types.IsInvalidParameter(libnetwork.ErrNoDefaultDriver) {
+		t.Fatalf("Expected %v to be an invalid parameter error", libnetwork.ErrNoDefaultDriver)
+	}
+
+	controller.SetDefaultDriver("null")
+	n, err := controller.NewNetwork("", "network1", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	subnet.IP = ip
+	if n.Type() != "null" {
+		t.Fatalf("Expected the default network type null, got %s", n.Type())
+	}
 
-	ip, cidr, err := net.ParseCIDR("192.168.100.2/28")
+	// An explicit network type takes precedence
+	controller.SetDefaultDriver("bridge")
+	n, err = controller.NewNetwork("host", "network2", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	cidr.IP = ip
+	if n.Type() != "host" {
+		t.Fatalf("Expected the network type host, got %s", n.Type())
+	}
 
-	ip, cidrv6, err := net.ParseCIDR("fe90::1/96")
+	controller.SetDefaultDriver("")
+	if _, err := controller.NewNetwork("", "network3", nil); err != libnetwork.ErrNoDefaultDriver {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrNoDefaultDriver, err)
+	}
+}
+
+func TestDriverCapabilities(t *testing.T) {
+	controller := libnetwork.New()
+
+	caps, err := controller.DriverCapabilities("bridge")
 	if err != nil {
 		t.Fatal(err)
 	}
-	cidrv6.IP = ip
+	if !caps.IPv6 || !caps.PortMapping || !caps.StaticIP || caps.MultiHost {
+		t.Fatalf("Unexpected bridge capabilities %+v", caps)
+	}
 
-	log.Debug("Adding a bridge")
-	option := options.Generic{
-		"BridgeName":            bridgeName,
-		"AddressIPv4":           subnet,
-		"FixedCIDR":             cidr,
-		"FixedCIDRv6":           cidrv6,
-		"EnableIPv6":            true,
-		"EnableIPTables":        true,
-		"EnableIPMasquerade":    true,
-		"EnableICC":             true,
-		"EnableIPForwarding":    true,
-		"AllowNonDefaultBridge": true}
+	if caps, err := controller.DriverCapabilities("overlay"); err != nil || !caps.MultiHost {
+		t.Fatalf("Expected the overlay driver to be multi-host, got %+v: %v", caps, err)
+	}
 
-	network, err := createTestNetwork(netType, "testnetwork", option)
+	if _, err := controller.DriverCapabilities("unknown"); err != libnetwork.ErrInvalidNetworkDriver {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrInvalidNetworkDriver, err)
+	}
+
+	// The requested features are checked before the driver is invoked
+	ipv6 := options.Generic{"EnableIPv6": true}
+	for _, err := range []error{
+		controller.ValidateNetworkConfig("null", ipv6),
+		func() error { _, err := controller.NewNetwork("null", "network1", ipv6); return err }(),
+	} {
+		if _, ok := err.(*libnetwork.UnsupportedFeatureError); !ok || !errors.Is(err, libnetwork.ErrUnsupportedFeature) {
+			t.Fatalf("Expected an UnsupportedFeatureError, got %v", err)
+		}
+	}
+
+	n, err := controller.NewNetwork("null", "network1", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
+	_, err = n.CreateEndpoint("ep1", options.Generic{"PortBindings": []types.PortBinding{{Proto: types.TCP, ContainerPort: 80}}})
+	if !errors.Is(err, libnetwork.ErrUnsupportedFeature) || !types.IsInvalidParameter(err) {
+		t.Fatalf("Expected an UnsupportedFeatureError, got %v", err)
+	}
+	if err.Error() != "network type null does not support port mapping" {
+		t.Fatalf("Unexpected error message %q", err.Error())
+	}
+	if err := n.ValidateEndpointConfig(options.Generic{"AddressIPv4": net.ParseIP("10.0.0.2")}); !errors.Is(err, libnetwork.ErrUnsupportedFeature) {
+		t.Fatalf("Expected an UnsupportedFeatureError, got %v", err)
+	}
+	if _, err := n.CreateEndpoint("ep1", nil); err != nil {
+		t.Fatal(err)
+	}
+}
 
-	ep, err := network.CreateEndpoint("testep", nil)
-	if err != nil {
+func TestNullNoConfig(t *testing.T) {
+	controller := libnetwork.New()
+
+	if err := controller.ConfigureNetworkDriver("null", nil); err != nil {
 		t.Fatal(err)
 	}
 
-	if err := ep.Delete(); err != nil {
+	network, err := controller.NewNetwork("null", "none", nil)
+	if err != nil {
 		t.Fatal(err)
 	}
 
-	if err := network.Delete(); err != nil {
+	ep, err := network.CreateEndpoint("testep", nil)
+	if err != nil {
 		t.Fatal(err)
 	}
-}
 
-func TestUnknownDriver(t *testing.T) {
-	defer netutils.SetupTestNetNS(t)()
+	if ep.SandboxInfo() != nil {
+		t.Fatalf("Expected no sandbox info for null endpoint, got %v", ep.SandboxInfo())
+	}
 
-	_, err := createTestNetwork("unknowndriver", "testnetwork", options.Generic{})
-	if err == nil {
-		t.Fatal("Expected to fail. But instead succeeded")
+	cData, err := ep.Join(containerID)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	if _, ok := err.(libnetwork.NetworkTypeError); !ok {
-		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	if cData.SandboxKey == "" {
+		t.Fatal("Join returned an empty sandbox key")
 	}
-}
 
-func TestNilDriver(t *testing.T) {
-	controller := libnetwork.New()
+	if _, err := os.Stat(cData.SandboxKey); err != nil {
+		t.Fatalf("Sandbox key %s does not reference a namespace: %v", cData.SandboxKey, err)
+	}
 
-	option := options.Generic{}
-	_, err := controller.NewNetwork("framerelay", "dummy", option)
-	if err == nil {
-		t.Fatal("Expected to fail. But instead succeeded")
+	if err := ep.Leave(containerID); err != nil {
+		t.Fatal(err)
 	}
 
-	if err != libnetwork.ErrInvalidNetworkDriver {
-		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := network.Delete(); err != nil {
+		t.Fatal(err)
 	}
 }
 
-func TestNoInitDriver(t *testing.T) {
+func TestHost(t *testing.T) {
 	controller := libnetwork.New()
 
-	option := options.Generic{}
-	_, err := controller.NewNetwork("ppp", "dummy", option)
-	if err == nil {
-		t.Fatal("Expected to fail. But instead succeeded")
+	network, err := controller.NewNetwork("host", "testhost", nil)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	if err != libnetwork.ErrInvalidNetworkDriver {
-		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	ep, err := network.CreateEndpoint("testep", nil)
+	if err != nil {
+		t.Fatal(err)
 	}
-}
 
-func TestDuplicateNetwork(t *testing.T) {
-	defer netutils.SetupTestNetNS(t)()
-	controller := libnetwork.New()
+	if ep.SandboxInfo() != nil {
+		t.Fatalf("Expected no address allocation for host endpoint, got %v", ep.SandboxInfo())
+	}
 
-	option := options.Generic{}
-	err := controller.ConfigureNetworkDriver(netType, option)
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	cData, err := ep.Join(containerID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = controller.NewNetwork(netType, "testnetwork", "")
+	var sboxStat, hostStat syscall.Stat_t
+	if err := syscall.Stat(cData.SandboxKey, &sboxStat); err != nil {
+		t.Fatal(err)
+	}
+	hostNs := fmt.Sprintf("/proc/%d/task/%d/ns/net", os.Getpid(), syscall.Gettid())
+	if err := syscall.Stat(hostNs, &hostStat); err != nil {
+		t.Fatal(err)
+	}
+	if sboxStat.Ino != hostStat.Ino {
+		t.Fatalf("Sandbox key %s does not reference the host network namespace", cData.SandboxKey)
+	}
+
+	// A second host network endpoint can not be joined by the same container
+	network2, err := controller.NewNetwork("host", "testhost2", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = controller.NewNetwork(netType, "testnetwork", "")
-	if err == nil {
-		t.Fatal("Expected to fail. But instead succeeded")
+	ep2, err := network2.CreateEndpoint("testep2", nil)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	if _, ok := err.(libnetwork.NetworkNameError); !ok {
-		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	if _, err := ep2.Join(containerID); err != libnetwork.ErrHostNetworkConflict {
+		t.Fatalf("Expected ErrHostNetworkConflict, got %v", err)
 	}
-}
 
-func TestNetworkName(t *testing.T) {
-	networkName := "testnetwork"
+	// Neither can a regular network endpoint
+	network3, err := controller.NewNetwork("null", "testnull", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	n, err := createTestNetwork(netType, networkName, options.Generic{})
+	ep3, err := network3.CreateEndpoint("testep3", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if n.Name() != networkName {
-		t.Fatalf("Expected network name %s, got %s", networkName, n.Name())
+	if _, err := ep3.Join(containerID); err != libnetwork.ErrHostNetworkConflict {
+		t.Fatalf("Expected ErrHostNetworkConflict, got %v", err)
 	}
-}
 
-func TestNetworkType(t *testing.T) {
-	networkType := netType
+	if err := ep.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
 
-	n, err := createTestNetwork(networkType, "testnetwork", options.Generic{})
-	if err != nil {
+	if err := ep.Delete(); err != nil {
 		t.Fatal(err)
 	}
 
-	if n.Type() != networkType {
-		t.Fatalf("Expected network type %s, got %s", networkType, n.Type())
+	if err := network.Delete(); err != nil {
+		t.Fatal(err)
 	}
 }
 
-func TestNetworkID(t *testing.T) {
-	networkType := netType
+func TestBridge(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	ip, subnet, err := net.ParseCIDR("192.168.100.1/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subnet.IP = ip
 
-	n, err := createTestNetwork(networkType, "testnetwork", options.Generic{})
+	ip, cidr, err := net.ParseCIDR("192.168.100.2/28")
 	if err != nil {
 		t.Fatal(err)
 	}
+	cidr.IP = ip
 
-	if n.ID() == "" {
-		t.Fatal("Expected non-empty network id")
+	ip, cidrv6, err := net.ParseCIDR("fe90::1/96")
+	if err != nil {
+		t.Fatal(err)
 	}
-}
+	cidrv6.IP = ip
 
-func TestDeleteNetworkWithActiveEndpoints(t *testing.T) {
-	defer netutils.SetupTestNetNS(t)()
+	log.Debug("Adding a bridge")
 	option := options.Generic{
 		"BridgeName":            bridgeName,
+		"AddressIPv4":           subnet,
+		"FixedCIDR":             cidr,
+		"FixedCIDRv6":           cidrv6,
+		"EnableIPv6":            true,
+		"EnableIPTables":        true,
+		"EnableIPMasquerade":    true,
+		"EnableICC":             true,
+		"EnableIPForwarding":    true,
 		"AllowNonDefaultBridge": true}
 
 	network, err := createTestNetwork(netType, "testnetwork", option)
@@ -236,16 +349,6 @@ func TestDeleteNetworkWithActiveEndpoints(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = network.Delete()
-	if err == nil {
-		t.Fatal("Expected to fail. But instead succeeded")
-	}
-
-	if _, ok := err.(*libnetwork.ActiveEndpointsError); !ok {
-		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
-	}
-
-	// Done testing. Now cleanup.
 	if err := ep.Delete(); err != nil {
 		t.Fatal(err)
 	}
@@ -255,33 +358,36 @@ func TestDeleteNetworkWithActiveEndpoints(t *testing.T) {
 	}
 }
 
-func TestUnknownNetwork(t *testing.T) {
+func TestBridgeIPForwardingDisabled(t *testing.T) {
 	defer netutils.SetupTestNetNS(t)()
-	option := options.Generic{
-		"BridgeName":            bridgeName,
-		"AllowNonDefaultBridge": true}
 
-	network, err := createTestNetwork(netType, "testnetwork", option)
-	if err != nil {
+	const ipForward = "/proc/sys/net/ipv4/ip_forward"
+	if err := ioutil.WriteFile(ipForward, []byte("0\n"), 0644); err != nil {
 		t.Fatal(err)
 	}
 
-	err = network.Delete()
+	// The controller leaves the sysctl to the operator, whatever the bridge
+	// configuration says
+	controller := libnetwork.New(libnetwork.ControllerOptionIPForwarding(false))
+	if err := controller.ConfigureNetworkDriver("bridge", options.Generic{"EnableIPForwarding": true}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := controller.NewNetwork("bridge", "testnetwork", "")
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer n.Delete()
 
-	err = network.Delete()
-	if err == nil {
-		t.Fatal("Expected to fail. But instead succeeded")
+	b, err := ioutil.ReadFile(ipForward)
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	if _, ok := err.(*libnetwork.UnknownNetworkError); !ok {
-		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	if string(b) != "0\n" {
+		t.Fatalf("Expected the IP forwarding to be left disabled, got %q", b)
 	}
 }
 
-func TestUnknownEndpoint(t *testing.T) {
+func TestBridgeInternal(t *testing.T) {
 	defer netutils.SetupTestNetNS(t)()
 	ip, subnet, err := net.ParseCIDR("192.168.100.1/24")
 	if err != nil {
@@ -289,9 +395,32 @@ func TestUnknownEndpoint(t *testing.T) {
 	}
 	subnet.IP = ip
 
+	// An address of the host outside the bridge network
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth0"}, PeerName: "veth1"}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatal(err)
+	}
+	ip, external, _ := net.ParseCIDR("10.99.0.1/24")
+	external.IP = ip
+	if err := netlink.AddrAdd(veth, &netlink.Addr{IPNet: external}); err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkSetUp(veth); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "10.99.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
 	option := options.Generic{
 		"BridgeName":            bridgeName,
 		"AddressIPv4":           subnet,
+		"EnableIPTables":        true,
+		"EnableIPMasquerade":    true,
+		"EnableICC":             true,
+		"Internal":              true,
 		"AllowNonDefaultBridge": true}
 
 	network, err := createTestNetwork(netType, "testnetwork", option)
@@ -299,161 +428,199 @@ func TestUnknownEndpoint(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	ep, err := network.CreateEndpoint("testep", nil)
+	ep1, err := network.CreateEndpoint("ep1", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	err = ep.Delete()
+	ep2, err := network.CreateEndpoint("ep2", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	err = ep.Delete()
-	if err == nil {
-		t.Fatal("Expected to fail. But instead succeeded")
-	}
-
-	if _, ok := err.(*libnetwork.UnknownEndpointError); !ok {
-		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	if gw := ep1.Info().Gateway; gw != nil {
+		t.Fatalf("Expected no gateway on an internal network, got %v", gw)
 	}
 
-	// Done testing. Now cleanup
-	if err := network.Delete(); err != nil {
+	if _, err := ep1.Join("internal_container1"); err != nil {
 		t.Fatal(err)
 	}
-}
-
-func TestNetworkEndpointsWalkers(t *testing.T) {
-	defer netutils.SetupTestNetNS(t)()
-	controller := libnetwork.New()
-	netType := "bridge"
-
-	option := options.Generic{}
-	err := controller.ConfigureNetworkDriver(netType, option)
+	defer ep1.Leave("internal_container1")
+	cData, err := ep2.Join("internal_container2")
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer ep2.Leave("internal_container2")
 
-	// Create network 1 and add 2 endpoint: ep11, ep12
-	net1, err := controller.NewNetwork(netType, "network1", "")
-	if err != nil {
+	// The peer listens from its own sandbox
+	var peer net.Listener
+	peerIP := ep2.Info().Interfaces[0].Address.IP
+	if err := sandbox.Invoke(cData.SandboxKey, func() error {
+		peer, err = net.Listen("tcp", net.JoinHostPort(peerIP.String(), "0"))
+		return err
+	}); err != nil {
 		t.Fatal(err)
 	}
-	ep11, err := net1.CreateEndpoint("ep11", nil)
+	defer peer.Close()
+
+	r, err := ep1.Probe(peer.Addr().String(), time.Second)
 	if err != nil {
 		t.Fatal(err)
 	}
-	ep12, err := net1.CreateEndpoint("ep12", nil)
-	if err != nil {
-		t.Fatal(err)
+	if !r.Success {
+		t.Fatalf("Failed to reach the peer %s: %s", r.Address, r.Error)
 	}
 
-	// Test list methods on net1
-	epList1 := net1.Endpoints()
-	if len(epList1) != 2 {
-		t.Fatalf("Endpoints() returned wrong number of elements: %d instead of 2", len(epList1))
+	r, err = ep1.Probe(l.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
 	}
-	// endpoint order is not guaranteed
-	for _, e := range epList1 {
-		if e != ep11 && e != ep12 {
-			t.Fatal("Endpoints() did not return all the expected elements")
-		}
+	if r.Success {
+		t.Fatalf("Reached the external address %s from an internal network", r.Address)
 	}
+}
 
-	// Test Endpoint Walk method
-	var epName string
-	var epWanted libnetwork.Endpoint
-	wlk := func(ep libnetwork.Endpoint) bool {
-		if ep.Name() == epName {
-			epWanted = ep
-			return true
-		}
-		return false
+func TestUnknownDriver(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	_, err := createTestNetwork("unknowndriver", "testnetwork", options.Generic{})
+	if err == nil {
+		t.Fatal("Expected to fail. But instead succeeded")
 	}
 
-	// Look for ep1 on network1
-	epName = "ep11"
-	net1.WalkEndpoints(wlk)
-	if epWanted == nil {
-		t.Fatal(err)
+	if _, ok := err.(libnetwork.NetworkTypeError); !ok {
+		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
 	}
-	if ep11 != epWanted {
-		t.Fatal(err)
+}
+
+func TestNilDriver(t *testing.T) {
+	controller := libnetwork.New()
+
+	option := options.Generic{}
+	_, err := controller.NewNetwork("framerelay", "dummy", option)
+	if err == nil {
+		t.Fatal("Expected to fail. But instead succeeded")
 	}
 
-	// Test Network Walk method
-	var netName string
-	var netWanted libnetwork.Network
-	nwWlk := func(nw libnetwork.Network) bool {
-		if nw.Name() == netName {
-			netWanted = nw
-			return true
-		}
-		return false
+	if err != libnetwork.ErrInvalidNetworkDriver {
+		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
 	}
+}
 
-	// Look for network named "network1"
-	netName = "network1"
-	controller.WalkNetworks(nwWlk)
-	if netWanted == nil {
-		t.Fatal(err)
+func TestNoInitDriver(t *testing.T) {
+	controller := libnetwork.New()
+
+	option := options.Generic{}
+	_, err := controller.NewNetwork("ppp", "dummy", option)
+	if err == nil {
+		t.Fatal("Expected to fail. But instead succeeded")
 	}
-	if net1 != netWanted {
+
+	if err != libnetwork.ErrInvalidNetworkDriver {
+		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	}
+}
+
+func TestErrorCategories(t *testing.T) {
+	controller := libnetwork.New()
+
+	if _, err := controller.NewNetwork("null", "testnetwork", ""); err != nil {
 		t.Fatal(err)
 	}
+
+	_, errName := controller.NewNetwork("null", "-invalid", "")
+	_, errExists := controller.NewNetwork("null", "testnetwork", "")
+	_, errDriver := controller.NewNetwork(netType, "other", "")
+	for _, c := range []struct {
+		err error
+		is  func(error) bool
+	}{
+		{controller.DeleteNetwork("unknown"), types.IsNotFound},
+		{controller.ConfigureNetworkDriver("ppp", nil), types.IsNotFound},
+		{errName, types.IsInvalidParameter},
+		{errExists, types.IsForbidden},
+		{errDriver, types.IsUnavailable},
+	} {
+		if c.err == nil || !c.is(c.err) {
+			t.Fatalf("Error %v misclassified", c.err)
+		}
+	}
 }
 
-func TestControllerQuery(t *testing.T) {
+func TestDriverNotConfigured(t *testing.T) {
 	defer netutils.SetupTestNetNS(t)()
 	controller := libnetwork.New()
-	netType := "bridge"
 
-	option := options.Generic{}
-	err := controller.ConfigureNetworkDriver(netType, option)
-	if err != nil {
+	// The bridge driver can not create networks before it is configured
+	if _, err := controller.NewNetwork(netType, "testnetwork", ""); err != libnetwork.ErrDriverNotConfigured {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrDriverNotConfigured, err)
+	}
+
+	if err := controller.ConfigureNetworkDriver(netType, options.Generic{}); err != nil {
 		t.Fatal(err)
 	}
 
-	// Create network 1
-	net1, err := controller.NewNetwork(netType, "network1", "")
+	// A rejected configuration does not undo the applied one
+	if err := controller.ConfigureNetworkDriver(netType, options.Generic{"EnableICC": true}); err == nil {
+		t.Fatal("Expected the second configuration to be rejected")
+	}
+
+	n, err := controller.NewNetwork(netType, "testnetwork", "")
 	if err != nil {
 		t.Fatal(err)
 	}
+	if err := n.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
 
-	g := controller.NetworkByName("")
-	if g != nil {
-		t.Fatalf("NetworkByName() succeeded with invalid target name")
+func TestValidateConfig(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	controller := libnetwork.New()
+
+	if err := controller.ValidateNetworkConfig("unknown", nil); err != libnetwork.ErrInvalidNetworkDriver {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrInvalidNetworkDriver, err)
+	}
+	if err := controller.ValidateNetworkConfig(netType, ""); err != libnetwork.ErrDriverNotConfigured {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrDriverNotConfigured, err)
 	}
 
-	g = controller.NetworkByID("")
-	if g != nil {
-		t.Fatalf("NetworkByID() succeeded with invalid target id: %v", g)
+	if err := controller.ConfigureNetworkDriver(netType, options.Generic{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.ValidateNetworkConfig(netType, ""); err != nil {
+		t.Fatalf("Failed to validate the network config: %v", err)
 	}
 
-	g = controller.NetworkByID("network1")
-	if g != nil {
-		t.Fatalf("NetworkByID() succeeded with invalid target name")
+	// Nothing was created by the validation
+	if len(controller.Networks()) != 0 {
+		t.Fatalf("Expected no network, got %v", controller.Networks())
 	}
 
-	g = controller.NetworkByName("network1")
-	if g == nil {
-		t.Fatalf("NetworkByName() did not find the network")
+	n, err := controller.NewNetwork(netType, "testnetwork", "")
+	if err != nil {
+		t.Fatal(err)
 	}
-	if g != net1 {
-		t.Fatalf("NetworkByName() returned the wrong network")
+
+	if err := n.ValidateEndpointConfig(options.Generic{"EgressRate": "10"}); err == nil {
+		t.Fatal("Expected the rate without unit to be rejected")
+	}
+	if err := n.ValidateEndpointConfig(nil); err != nil {
+		t.Fatalf("Failed to validate the endpoint config: %v", err)
+	}
+	if len(n.Endpoints()) != 0 {
+		t.Fatalf("Expected no endpoint, got %v", n.Endpoints())
 	}
 
-	g = controller.NetworkByID(net1.ID())
-	if net1 != g {
-		t.Fatalf("NetworkByID() returned unexpected element: %v", g)
+	if err := n.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.ValidateEndpointConfig(nil); err == nil {
+		t.Fatal("Expected the validation to fail on a deleted network")
 	}
 }
 
-func TestNetworkQuery(t *testing.T) {
+func TestDuplicateNetwork(t *testing.T) {
 	defer netutils.SetupTestNetNS(t)()
 	controller := libnetwork.New()
-	netType := "bridge"
 
 	option := options.Generic{}
 	err := controller.ConfigureNetworkDriver(netType, option)
@@ -461,135 +628,303 @@ func TestNetworkQuery(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// Create network 1 and add 2 endpoint: ep11, ep12
-	net1, err := controller.NewNetwork(netType, "network1", "")
+	_, err = controller.NewNetwork(netType, "testnetwork", "")
 	if err != nil {
 		t.Fatal(err)
 	}
-	ep11, err := net1.CreateEndpoint("ep11", nil)
-	if err != nil {
+
+	_, err = controller.NewNetwork(netType, "testnetwork", "")
+	if err == nil {
+		t.Fatal("Expected to fail. But instead succeeded")
+	}
+
+	if _, ok := err.(libnetwork.NetworkNameError); !ok {
+		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	}
+}
+
+func TestDuplicateNetworkConcurrent(t *testing.T) {
+	controller := libnetwork.New()
+
+	const attempts = 10
+	errCh := make(chan error, attempts)
+	start := make(chan struct{})
+	for i := 0; i < attempts; i++ {
+		go func() {
+			<-start
+			_, err := controller.NewNetwork("null", "testnetwork", "")
+			errCh <- err
+		}()
+	}
+	close(start)
+
+	created := 0
+	for i := 0; i < attempts; i++ {
+		err := <-errCh
+		if err == nil {
+			created++
+			continue
+		}
+		if _, ok := err.(libnetwork.NetworkNameError); !ok {
+			t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+		}
+	}
+
+	if created != 1 {
+		t.Fatalf("Expected exactly one network to be created, got %d", created)
+	}
+
+	if n := len(controller.Networks()); n != 1 {
+		t.Fatalf("Expected one network in the controller, got %d", n)
+	}
+}
+
+func TestNewNetworkWithContextCancelled(t *testing.T) {
+	controller := libnetwork.New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := controller.NewNetworkWithContext(ctx, "null", "testnetwork", "")
+	if err != context.Canceled {
+		t.Fatalf("Expected %v, got %v", context.Canceled, err)
+	}
+
+	if n := len(controller.Networks()); n != 0 {
+		t.Fatalf("Expected no network in the controller, got %d", n)
+	}
+
+	// The name is not held by the cancelled creation
+	if _, err := controller.NewNetwork("null", "testnetwork", ""); err != nil {
 		t.Fatal(err)
 	}
-	ep12, err := net1.CreateEndpoint("ep12", nil)
+}
+
+func TestCreateEndpointWithContextCancelled(t *testing.T) {
+	controller := libnetwork.New()
+
+	network, err := controller.NewNetwork("null", "testnetwork", "")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	e := net1.EndpointByName("ep11")
-	if ep11 != e {
-		t.Fatalf("EndpointByName() returned %v instead of %v", e, ep11)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = network.CreateEndpointWithContext(ctx, "testep", nil)
+	if err != context.Canceled {
+		t.Fatalf("Expected %v, got %v", context.Canceled, err)
 	}
 
-	e = net1.EndpointByName("")
-	if e != nil {
-		t.Fatalf("EndpointByName(): expected nil, got %v", e)
+	if n := len(network.Endpoints()); n != 0 {
+		t.Fatalf("Expected no endpoint in the network, got %d", n)
 	}
+}
 
-	e = net1.EndpointByName("IamNotAnEndpoint")
-	if e != nil {
-		t.Fatalf("EndpointByName(): expected nil, got %v", e)
+func TestRegisterDriver(t *testing.T) {
+	controller := libnetwork.New()
+	_, d := null.New()
+
+	if err := controller.RegisterDriver("", d); err != libnetwork.ErrEmptyNetworkType {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrEmptyNetworkType, err)
 	}
 
-	e = net1.EndpointByID(ep12.ID())
-	if ep12 != e {
-		t.Fatalf("EndpointByID() returned %v instead of %v", e, ep12)
+	if err := controller.RegisterDriver("custom", nil); err != libnetwork.ErrNilNetworkDriver {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrNilNetworkDriver, err)
 	}
 
-	e = net1.EndpointByID("")
-	if e != nil {
-		t.Fatalf("EndpointByID(): expected nil, got %v", e)
+	if err := controller.RegisterDriver("bridge", d); err != libnetwork.ErrDriverExists {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrDriverExists, err)
 	}
 
-}
+	// Concurrent registrations for the same network type, only one wins
+	const attempts = 10
+	errCh := make(chan error, attempts)
+	for i := 0; i < attempts; i++ {
+		go func() {
+			errCh <- controller.RegisterDriver("custom", d)
+		}()
+	}
 
-const containerID = "valid_container"
+	registered := 0
+	for i := 0; i < attempts; i++ {
+		err := <-errCh
+		if err == nil {
+			registered++
+			continue
+		}
+		if err != libnetwork.ErrDriverExists {
+			t.Fatalf("Expected %v, got %v", libnetwork.ErrDriverExists, err)
+		}
+	}
 
-func TestEndpointJoin(t *testing.T) {
-	defer netutils.SetupTestNetNS(t)()
+	if registered != 1 {
+		t.Fatalf("Expected exactly one registration to succeed, got %d", registered)
+	}
 
-	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{})
-	if err != nil {
+	if err := controller.ConfigureNetworkDriver("custom", options.Generic{}); err != nil {
 		t.Fatal(err)
 	}
 
-	ep, err := n.CreateEndpoint("ep1", nil)
+	n, err := controller.NewNetwork("custom", "testnetwork", "")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = ep.Join(containerID,
-		libnetwork.JoinOptionHostname("test"),
-		libnetwork.JoinOptionDomainname("docker.io"))
+	if n.Type() != "custom" {
+		t.Fatalf("Expected network type custom, got %s", n.Type())
+	}
+}
+
+func TestInvalidNames(t *testing.T) {
+	controller := libnetwork.New()
+
+	invalid := []string{"", "-net", ".net", "_net", "net work", "net/work", strings.Repeat("n", 256)}
+	for _, name := range invalid {
+		_, err := controller.NewNetwork("null", name, "")
+		if _, ok := err.(libnetwork.InvalidNameError); !ok {
+			t.Fatalf("Expected invalid name error for network name %q, got %v", name, err)
+		}
+	}
+
+	network, err := controller.NewNetwork("null", "net_1.test-"+strings.Repeat("n", 244), "")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ep.Leave(containerID)
-	if err != nil {
+	for _, name := range invalid {
+		_, err := network.CreateEndpoint(name, nil)
+		if _, ok := err.(libnetwork.InvalidNameError); !ok {
+			t.Fatalf("Expected invalid name error for endpoint name %q, got %v", name, err)
+		}
+	}
+
+	if _, err := network.CreateEndpoint("ep_1.test-ep", nil); err != nil {
 		t.Fatal(err)
 	}
 }
 
-func TestEndpointJoinInvalidContainerId(t *testing.T) {
-	defer netutils.SetupTestNetNS(t)()
+func TestEndpointAliases(t *testing.T) {
+	controller := libnetwork.New()
 
-	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{})
+	network, err := controller.NewNetwork("null", "testnetwork", "")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	ep, err := n.CreateEndpoint("ep1", nil)
+	ep, err := network.CreateEndpoint("testep", nil, libnetwork.EndpointOptionAliases("web", "web.local"))
 	if err != nil {
 		t.Fatal(err)
 	}
+	if !reflect.DeepEqual(ep.Aliases(), []string{"web", "web.local"}) {
+		t.Fatalf("Unexpected aliases %v", ep.Aliases())
+	}
 
-	_, err = ep.Join("")
-	if err == nil {
-		t.Fatal("Expected to fail join with empty container id string")
+	for _, name := range []string{"testep", "web", "web.local"} {
+		if e := network.EndpointByName(name); e == nil || e.ID() != ep.ID() {
+			t.Fatalf("Endpoint not found by name %s, got %v", name, e)
+		}
 	}
 
-	if _, ok := err.(libnetwork.InvalidContainerIDError); !ok {
-		t.Fatalf("Failed for unexpected reason: %v", err)
+	if _, err := network.CreateEndpoint("testep2", nil, libnetwork.EndpointOptionAliases("-web")); err != libnetwork.InvalidNameError("-web") {
+		t.Fatalf("Expected %v, got %v", libnetwork.InvalidNameError("-web"), err)
 	}
-}
 
-func TestEndpointMultipleJoins(t *testing.T) {
-	defer netutils.SetupTestNetNS(t)()
+	// Aliases can not be shared with the names and aliases of the other
+	// endpoints, nor repeated
+	for _, aliases := range [][]string{{"testep"}, {"db", "web.local"}, {"db", "db"}} {
+		_, err := network.CreateEndpoint("testep2", nil, libnetwork.EndpointOptionAliases(aliases...))
+		if _, ok := err.(libnetwork.EndpointNameError); !ok {
+			t.Fatalf("Expected an endpoint name error for aliases %v, got %v", aliases, err)
+		}
+	}
+	if _, err := network.CreateEndpoint("web", nil); err != libnetwork.EndpointNameError("web") {
+		t.Fatalf("Expected %v, got %v", libnetwork.EndpointNameError("web"), err)
+	}
 
-	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{})
-	if err != nil {
+	if err := ep.Delete(); err != nil {
 		t.Fatal(err)
 	}
 
-	ep, err := n.CreateEndpoint("ep1", nil)
-	if err != nil {
+	// The aliases of a deleted endpoint can be reused
+	if _, err := network.CreateEndpoint("web", nil, libnetwork.EndpointOptionAliases("web.local")); err != nil {
 		t.Fatal(err)
 	}
+	if len(network.Endpoints()) != 1 {
+		t.Fatalf("Expected one endpoint, got %v", network.Endpoints())
+	}
+}
 
-	_, err = ep.Join(containerID,
-		libnetwork.JoinOptionHostname("test"),
-		libnetwork.JoinOptionDomainname("docker.io"))
+func TestCreateEndpoints(t *testing.T) {
+	controller := libnetwork.New()
 
+	network, err := controller.NewNetwork("null", "testnetwork", "")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = ep.Join("container2")
-	if err == nil {
-		t.Fatal("Expected to fail multiple joins for the same endpoint")
+	if _, err := network.CreateEndpoint("web", nil); err != nil {
+		t.Fatal(err)
 	}
 
-	if err != libnetwork.ErrInvalidJoin {
-		t.Fatalf("Failed for unexpected reason: %v", err)
+	// All the invalid specs are reported, and nothing is created
+	_, err = network.CreateEndpoints([]libnetwork.EndpointSpec{
+		{Name: "ep1"},
+		{Name: "-ep2"},
+		{Name: "ep3", EndpointOptions: []libnetwork.EndpointOption{libnetwork.EndpointOptionAliases("web")}},
+		{Name: "ep4", EndpointOptions: []libnetwork.EndpointOption{libnetwork.EndpointOptionAliases("ep1")}},
+	})
+	cerr, ok := err.(*libnetwork.CreateEndpointsError)
+	if !ok {
+		t.Fatalf("Expected a create endpoints error, got %v", err)
+	}
+	if len(cerr.Errors) != 3 {
+		t.Fatalf("Expected 3 failed specs, got %v", cerr)
+	}
+	for i, c := range []struct {
+		index int
+		err   error
+	}{
+		{1, libnetwork.InvalidNameError("-ep2")},
+		{2, libnetwork.EndpointNameError("web")},
+		{3, libnetwork.EndpointNameError("ep1")},
+	} {
+		if se := cerr.Errors[i]; se.Index != c.index || se.Err != c.err {
+			t.Fatalf("Expected %v for spec %d, got %v", c.err, c.index, se)
+		}
+	}
+	if !errors.Is(err, libnetwork.EndpointNameError("web")) {
+		t.Fatalf("Error %v does not wrap the errors of the specs", err)
+	}
+	if len(network.Endpoints()) != 1 {
+		t.Fatalf("Expected one endpoint, got %v", network.Endpoints())
 	}
 
-	err = ep.Leave(containerID)
+	eps, err := network.CreateEndpoints([]libnetwork.EndpointSpec{
+		{Name: "ep1"},
+		{Name: "ep2", EndpointOptions: []libnetwork.EndpointOption{libnetwork.EndpointOptionAliases("db")}},
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
+	if len(eps) != 2 || eps[0].Name() != "ep1" || eps[1].Name() != "ep2" {
+		t.Fatalf("Unexpected endpoints %v", eps)
+	}
+	for _, ep := range eps {
+		if network.EndpointByID(ep.ID()) == nil {
+			t.Fatalf("Endpoint %s not in the network", ep.Name())
+		}
+	}
+	if e := network.EndpointByName("db"); e == nil || e.ID() != eps[1].ID() {
+		t.Fatalf("Endpoint not found by alias, got %v", e)
+	}
+
+	if eps, err := network.CreateEndpoints(nil); err != nil || len(eps) != 0 {
+		t.Fatalf("Expected no endpoint, got %v, %v", eps, err)
+	}
 }
 
-func TestEndpointInvalidLeave(t *testing.T) {
+func TestCreateEndpointsRollback(t *testing.T) {
 	defer netutils.SetupTestNetNS(t)()
 
 	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{})
@@ -601,44 +936,3081 @@ func TestEndpointInvalidLeave(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	err = ep.Leave(containerID)
-	if err == nil {
-		t.Fatal("Expected to fail leave from an endpoint which has no active join")
+	ip := ep.Info().Interfaces[0].Address.IP
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
 	}
 
-	if _, ok := err.(libnetwork.InvalidContainerIDError); !ok {
-		t.Fatalf("Failed for unexpected reason: %v", err)
+	// The driver fails on the last spec, once the address is allocated to
+	// the first one
+	_, err = n.CreateEndpoints([]libnetwork.EndpointSpec{
+		{Name: "ep1", Options: options.Generic{"AddressIPv4": ip}},
+		{Name: "ep2"},
+		{Name: "ep3", Options: options.Generic{"AddressIPv4": ip}},
+	})
+	cerr, ok := err.(*libnetwork.CreateEndpointsError)
+	if !ok || len(cerr.Errors) != 1 || cerr.Errors[0].Index != 2 {
+		t.Fatalf("Expected the last spec to fail, got %v", err)
+	}
+	if len(n.Endpoints()) != 0 {
+		t.Fatalf("Endpoints left after rollback: %v", n.Endpoints())
 	}
 
-	_, err = ep.Join(containerID,
-		libnetwork.JoinOptionHostname("test"),
-		libnetwork.JoinOptionDomainname("docker.io"))
+	// The address was released on rollback
+	ep, err = n.CreateEndpoint("ep1", options.Generic{"AddressIPv4": ip})
+	if err != nil {
+		t.Fatalf("Failed to reuse the address after rollback: %v", err)
+	}
+
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := n.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNetworkName(t *testing.T) {
+	networkName := "testnetwork"
 
+	n, err := createTestNetwork(netType, networkName, options.Generic{})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ep.Leave("")
+	if n.Name() != networkName {
+		t.Fatalf("Expected network name %s, got %s", networkName, n.Name())
+	}
+}
+
+func TestNetworkType(t *testing.T) {
+	networkType := netType
+
+	n, err := createTestNetwork(networkType, "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n.Type() != networkType {
+		t.Fatalf("Expected network type %s, got %s", networkType, n.Type())
+	}
+}
+
+func TestNetworkID(t *testing.T) {
+	networkType := netType
+
+	n, err := createTestNetwork(networkType, "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n.ID() == "" {
+		t.Fatal("Expected non-empty network id")
+	}
+}
+
+func TestDeleteNetworkWithActiveEndpoints(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	option := options.Generic{
+		"BridgeName":            bridgeName,
+		"AllowNonDefaultBridge": true}
+
+	network, err := createTestNetwork(netType, "testnetwork", option)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := network.CreateEndpoint("testep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = network.Delete()
 	if err == nil {
-		t.Fatal("Expected to fail leave with empty container id")
+		t.Fatal("Expected to fail. But instead succeeded")
 	}
 
-	if _, ok := err.(libnetwork.InvalidContainerIDError); !ok {
-		t.Fatalf("Failed for unexpected reason: %v", err)
+	if _, ok := err.(*libnetwork.ActiveEndpointsError); !ok {
+		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
 	}
 
-	err = ep.Leave("container2")
+	if cnt := network.EndpointCount(); cnt != 1 {
+		t.Fatalf("Expected 1 endpoint, got %d", cnt)
+	}
+
+	// Done testing. Now cleanup.
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if cnt := network.EndpointCount(); cnt != 0 {
+		t.Fatalf("Expected no endpoints, got %d", cnt)
+	}
+
+	if err := network.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnknownNetwork(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	option := options.Generic{
+		"BridgeName":            bridgeName,
+		"AllowNonDefaultBridge": true}
+
+	network, err := createTestNetwork(netType, "testnetwork", option)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = network.Delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = network.Delete()
 	if err == nil {
-		t.Fatal("Expected to fail leave with wrong container id")
+		t.Fatal("Expected to fail. But instead succeeded")
 	}
 
-	if _, ok := err.(libnetwork.InvalidContainerIDError); !ok {
-		t.Fatalf("Failed for unexpected reason: %v", err)
+	if _, ok := err.(*libnetwork.UnknownNetworkError); !ok {
+		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
 	}
+}
 
-	err = ep.Leave(containerID)
+func TestUnknownEndpoint(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	ip, subnet, err := net.ParseCIDR("192.168.100.1/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subnet.IP = ip
+
+	option := options.Generic{
+		"BridgeName":            bridgeName,
+		"AddressIPv4":           subnet,
+		"AllowNonDefaultBridge": true}
+
+	network, err := createTestNetwork(netType, "testnetwork", option)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := network.CreateEndpoint("testep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ep.Delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ep.Delete()
+	if err == nil {
+		t.Fatal("Expected to fail. But instead succeeded")
+	}
+
+	if _, ok := err.(*libnetwork.UnknownEndpointError); !ok {
+		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	}
+
+	// Done testing. Now cleanup
+	if err := network.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNetworkEndpointsWalkers(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	controller := libnetwork.New()
+	netType := "bridge"
+
+	option := options.Generic{}
+	err := controller.ConfigureNetworkDriver(netType, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create network 1 and add 2 endpoint: ep11, ep12
+	net1, err := controller.NewNetwork(netType, "network1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep11, err := net1.CreateEndpoint("ep11", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep12, err := net1.CreateEndpoint("ep12", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	// Test list methods on net1
+	epList1 := net1.Endpoints()
+	if len(epList1) != 2 {
+		t.Fatalf("Endpoints() returned wrong number of elements: %d instead of 2", len(epList1))
+	}
+	// endpoint order is not guaranteed
+	for _, e := range epList1 {
+		if e != ep11 && e != ep12 {
+			t.Fatal("Endpoints() did not return all the expected elements")
+		}
+	}
+
+	// Test Endpoint Walk method
+	var epName string
+	var epWanted libnetwork.Endpoint
+	wlk := func(ep libnetwork.Endpoint) bool {
+		if ep.Name() == epName {
+			epWanted = ep
+			return true
+		}
+		return false
+	}
+
+	// Look for ep1 on network1
+	epName = "ep11"
+	net1.WalkEndpoints(wlk)
+	if epWanted == nil {
+		t.Fatal(err)
+	}
+	if ep11 != epWanted {
+		t.Fatal(err)
+	}
+
+	// Test Network Walk method
+	var netName string
+	var netWanted libnetwork.Network
+	nwWlk := func(nw libnetwork.Network) bool {
+		if nw.Name() == netName {
+			netWanted = nw
+			return true
+		}
+		return false
+	}
+
+	// Look for network named "network1"
+	netName = "network1"
+	controller.WalkNetworks(nwWlk)
+	if netWanted == nil {
+		t.Fatal(err)
+	}
+	if net1 != netWanted {
+		t.Fatal(err)
+	}
+}
+
+func TestWalkNetworksConcurrent(t *testing.T) {
+	controller := libnetwork.New()
+
+	for i := 0; i < 10; i++ {
+		if _, err := controller.NewNetwork("null", fmt.Sprintf("network%d", i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			n, err := controller.NewNetwork("null", fmt.Sprintf("transient%d", i), nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err := n.Delete(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		// The walk stops at the first network the walker returns true for
+		visited := 0
+		controller.WalkNetworks(func(nw libnetwork.Network) bool {
+			visited++
+			return true
+		})
+		if visited != 1 {
+			t.Fatalf("Walk not stopped by the walker, %d networks visited", visited)
+		}
+
+		// The networks present for the whole walk are all visited
+		seen := make(map[string]bool)
+		controller.WalkNetworks(func(nw libnetwork.Network) bool {
+			seen[nw.Name()] = true
+			return false
+		})
+		for j := 0; j < 10; j++ {
+			if name := fmt.Sprintf("network%d", j); !seen[name] {
+				t.Fatalf("Network %s not visited", name)
+			}
+		}
+
+		if controller.NetworkByName("network5") == nil {
+			t.Fatal("Network network5 not found")
+		}
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+func TestControllerQuery(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	controller := libnetwork.New()
+	netType := "bridge"
+
+	option := options.Generic{}
+	err := controller.ConfigureNetworkDriver(netType, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create network 1
+	net1, err := controller.NewNetwork(netType, "network1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := controller.NetworkByName("")
+	if g != nil {
+		t.Fatalf("NetworkByName() succeeded with invalid target name")
+	}
+
+	g = controller.NetworkByID("")
+	if g != nil {
+		t.Fatalf("NetworkByID() succeeded with invalid target id: %v", g)
+	}
+
+	g = controller.NetworkByID("network1")
+	if g != nil {
+		t.Fatalf("NetworkByID() succeeded with invalid target name")
+	}
+
+	g = controller.NetworkByName("network1")
+	if g == nil {
+		t.Fatalf("NetworkByName() did not find the network")
+	}
+	if g != net1 {
+		t.Fatalf("NetworkByName() returned the wrong network")
+	}
+
+	g = controller.NetworkByID(net1.ID())
+	if net1 != g {
+		t.Fatalf("NetworkByID() returned unexpected element: %v", g)
+	}
+}
+
+func TestNetworkQuery(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	controller := libnetwork.New()
+	netType := "bridge"
+
+	option := options.Generic{}
+	err := controller.ConfigureNetworkDriver(netType, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create network 1 and add 2 endpoint: ep11, ep12
+	net1, err := controller.NewNetwork(netType, "network1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep11, err := net1.CreateEndpoint("ep11", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep12, err := net1.CreateEndpoint("ep12", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := net1.EndpointByName("ep11")
+	if ep11 != e {
+		t.Fatalf("EndpointByName() returned %v instead of %v", e, ep11)
+	}
+
+	e = net1.EndpointByName("")
+	if e != nil {
+		t.Fatalf("EndpointByName(): expected nil, got %v", e)
+	}
+
+	e = net1.EndpointByName("IamNotAnEndpoint")
+	if e != nil {
+		t.Fatalf("EndpointByName(): expected nil, got %v", e)
+	}
+
+	e = net1.EndpointByID(ep12.ID())
+	if ep12 != e {
+		t.Fatalf("EndpointByID() returned %v instead of %v", e, ep12)
+	}
+
+	e = net1.EndpointByID("")
+	if e != nil {
+		t.Fatalf("EndpointByID(): expected nil, got %v", e)
+	}
+
+}
+
+func TestControllerSnapshot(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	controller := libnetwork.New()
+
+	if err := controller.ConfigureNetworkDriver("bridge", options.Generic{}); err != nil {
+		t.Fatal(err)
+	}
+
+	labels := map[string]string{"tier": "front"}
+	n, err := controller.NewNetwork("bridge", "network1", "", libnetwork.NetworkOptionLabels(labels))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep1, err := n.CreateEndpoint("ep1", nil, libnetwork.EndpointOptionAliases("web"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep2, err := n.CreateEndpoint("ep2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cd, err := ep1.Join(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep1.Leave(containerID)
+
+	topo := controller.Snapshot()
+
+	ns, ok := topo.NetworkByName("network1")
+	if !ok || ns.ID() != n.ID() || ns.Type() != "bridge" || !reflect.DeepEqual(ns.Labels(), labels) {
+		t.Fatalf("Unexpected network snapshot %v", ns)
+	}
+	if len(topo.Networks()) != 1 || len(ns.Endpoints()) != 2 {
+		t.Fatalf("Unexpected topology %v", topo)
+	}
+
+	es, ok := ns.EndpointByName("web")
+	if !ok || es.ID() != ep1.ID() || es.Network() != "network1" {
+		t.Fatalf("Endpoint not found by alias, got %v", es)
+	}
+	info := es.Info()
+	if info.SandboxKey != cd.SandboxKey || !info.Interfaces[0].Address.IP.Equal(ep1.Info().Interfaces[0].Address.IP) {
+		t.Fatalf("Unexpected endpoint settings %v", info)
+	}
+	if es, ok := ns.EndpointByID(ep2.ID()); !ok || es.Info().SandboxKey != "" {
+		t.Fatalf("Unexpected endpoint snapshot %v", es)
+	}
+
+	sb, ok := topo.SandboxByKey(cd.SandboxKey)
+	if !ok || sb.SharesHostNetwork() || !reflect.DeepEqual(sb.Endpoints(), []string{ep1.ID()}) {
+		t.Fatalf("Unexpected sandbox snapshot %v", sb)
+	}
+
+	// The snapshot is not affected by later changes, nor by its readers
+	ns.Labels()["tier"] = "back"
+	if err := ep2.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ns.EndpointByID(ep2.ID()); !ok || ns.Labels()["tier"] != "front" {
+		t.Fatalf("Snapshot changed: %v", ns)
+	}
+	if ns, _ := controller.Snapshot().NetworkByID(n.ID()); len(ns.Endpoints()) != 1 {
+		t.Fatalf("Expected one endpoint in a new snapshot, got %v", ns.Endpoints())
+	}
+}
+
+const containerID = "valid_container"
+
+func TestEndpointJoin(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ep.Join(containerID,
+		libnetwork.JoinOptionHostname("test"),
+		libnetwork.JoinOptionDomainname("docker.io"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ep.Leave(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEndpointJoinWithInfo(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := ep.JoinWithInfo(containerID, libnetwork.JoinOptionInterfaceName("web0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.SandboxKey == "" || info.HostsPath == "" || info.ResolvConfPath == "" {
+		t.Fatalf("Incomplete join info %+v", info)
+	}
+
+	if len(info.Interfaces) != 1 || info.Interfaces[0].DstName != "web0" {
+		t.Fatalf("Expected the interface web0 in the join info, got %v", info.Interfaces)
+	}
+
+	epInfo := ep.Info()
+	if !info.Gateway.Equal(epInfo.Gateway) {
+		t.Fatalf("Expected gateway %v, got %v", epInfo.Gateway, info.Gateway)
+	}
+
+	if info.SandboxKey != epInfo.SandboxKey {
+		t.Fatalf("Expected sandbox key %s, got %s", epInfo.SandboxKey, info.SandboxKey)
+	}
+
+	if err := ep.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Join gives the same data back
+	cData, err := ep.Join(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cData.SandboxKey != info.SandboxKey || cData.ResolvConfPath != info.ResolvConfPath {
+		t.Fatalf("Expected the container data of the join info, got %+v", cData)
+	}
+
+	if err := ep.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEndpointInfo(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := ep.Info()
+	if len(info.Interfaces) != 1 {
+		t.Fatalf("Expected one interface, got %d", len(info.Interfaces))
+	}
+
+	iface := info.Interfaces[0]
+	if iface.Address == nil || iface.Address.IP == nil {
+		t.Fatal("Endpoint info carries no IPv4 address")
+	}
+
+	if len(iface.MacAddress) == 0 {
+		t.Fatal("Endpoint info carries no MAC address")
+	}
+
+	if iface.DstName == "" {
+		t.Fatal("Endpoint info carries no interface name")
+	}
+
+	if info.Gateway == nil {
+		t.Fatal("Endpoint info carries no gateway")
+	}
+
+	if info.SandboxKey != "" {
+		t.Fatalf("Expected empty sandbox key before join, got %s", info.SandboxKey)
+	}
+
+	// Mutating the returned info must not affect the endpoint
+	iface.Address.IP[0]++
+	if ep.Info().Interfaces[0].Address.IP.Equal(iface.Address.IP) {
+		t.Fatal("Endpoint info is not a copy")
+	}
+
+	cData, err := ep.Join(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ep.Info().SandboxKey != cData.SandboxKey {
+		t.Fatalf("Expected sandbox key %s, got %s", cData.SandboxKey, ep.Info().SandboxKey)
+	}
+
+	if err := ep.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+
+	if ep.Info().SandboxKey != "" {
+		t.Fatalf("Expected empty sandbox key after leave, got %s", ep.Info().SandboxKey)
+	}
+}
+
+func TestEndpointStatistics(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ep.Statistics(); err != libnetwork.ErrNoContainer {
+		t.Fatalf("Expected %v before join, got %v", libnetwork.ErrNoContainer, err)
+	}
+
+	if _, err := ep.Join(containerID); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := ep.Statistics()
+	if err != nil {
+		t.Fatalf("Failed to get the endpoint statistics: %v", err)
+	}
+
+	// Only the interface of the endpoint is reported, not the loopback one
+	name := ep.Info().Interfaces[0].DstName
+	if len(stats) != 1 || stats[name] == nil {
+		t.Fatalf("Expected the statistics of interface %s, got %v", name, stats)
+	}
+
+	if err := ep.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ep.Statistics(); err != libnetwork.ErrNoContainer {
+		t.Fatalf("Expected %v after leave, got %v", libnetwork.ErrNoContainer, err)
+	}
+}
+
+func TestNetworkAddressCount(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	ip, subnet, err := net.ParseCIDR("192.168.30.1/30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subnet.IP = ip
+	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{"AddressIPv4": subnet})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The gateway takes one of the two addresses of the subnet
+	if total, used := n.AddressCount(); total != 2 || used != 1 {
+		t.Fatalf("Expected 2 addresses with 1 used, got %d with %d used", total, used)
+	}
+
+	ep, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total, used := n.AddressCount(); total != 2 || used != 2 {
+		t.Fatalf("Expected 2 addresses with 2 used, got %d with %d used", total, used)
+	}
+
+	if _, err := n.CreateEndpoint("ep2", nil); err != libnetwork.ErrNoAvailableIPs {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrNoAvailableIPs, err)
+	}
+	if !types.IsUnavailable(libnetwork.ErrNoAvailableIPs) {
+		t.Fatalf("Expected %v to be an unavailable error", libnetwork.ErrNoAvailableIPs)
+	}
+	if len(n.Endpoints()) != 1 {
+		t.Fatalf("Expected the failed endpoint to be left out, got %v", n.Endpoints())
+	}
+
+	// The address of a deleted endpoint is available again
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if total, used := n.AddressCount(); total != 2 || used != 1 {
+		t.Fatalf("Expected 2 addresses with 1 used, got %d with %d used", total, used)
+	}
+	if _, err := n.CreateEndpoint("ep2", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNetworkAddressCountReserved(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	// Only the fixed CIDR of the bridge subnet is allocated
+	ip, subnet, err := net.ParseCIDR("192.168.32.1/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subnet.IP = ip
+	_, fixed, err := net.ParseCIDR("192.168.32.0/30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{"AddressIPv4": subnet, "FixedCIDR": fixed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total, used := n.AddressCount(); total != 2 || used != 1 {
+		t.Fatalf("Expected 2 addresses with 1 used, got %d with %d used", total, used)
+	}
+	ep, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total, used := n.AddressCount(); total != 2 || used != 2 {
+		t.Fatalf("Expected 2 addresses with 2 used, got %d with %d used", total, used)
+	}
+	if _, err := n.CreateEndpoint("ep2", nil); err != libnetwork.ErrNoAvailableIPs {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrNoAvailableIPs, err)
+	}
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The auxiliary addresses are in use from the start
+	ip, subnet, err = net.ParseCIDR("192.168.33.1/29")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subnet.IP = ip
+	controller := libnetwork.New()
+	if err := controller.ConfigureNetworkDriver("bridge", options.Generic{"AddressIPv4": subnet}); err != nil {
+		t.Fatal(err)
+	}
+	aux := map[string]net.IP{"dns": net.ParseIP("192.168.33.2"), "proxy": net.ParseIP("192.168.33.3")}
+	n, err = controller.NewNetwork("bridge", "testnetwork", options.Generic{"AuxAddresses": aux})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total, used := n.AddressCount(); total != 6 || used != 3 {
+		t.Fatalf("Expected 6 addresses with 3 used, got %d with %d used", total, used)
+	}
+	for i := 1; i <= 3; i++ {
+		if _, err := n.CreateEndpoint(fmt.Sprintf("ep%d", i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if total, used := n.AddressCount(); total != 6 || used != 6 {
+		t.Fatalf("Expected 6 addresses with 6 used, got %d with %d used", total, used)
+	}
+	if _, err := n.CreateEndpoint("ep4", nil); err != libnetwork.ErrNoAvailableIPs {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrNoAvailableIPs, err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	controller := libnetwork.New()
+
+	config := `{
+		"drivers": [
+			{"type": "bridge", "options": {"AddressIPv4": "192.168.31.1/24", "Mtu": 1400}},
+			{"type": "foo"}
+		],
+		"networks": [
+			{"name": "network1", "type": "bridge", "labels": {"env": "test"}},
+			{"name": "network2", "type": "foo"},
+			{"name": "network 3", "type": "bridge"}
+		]
+	}`
+	err := controller.LoadConfig(strings.NewReader(config))
+	lerr, ok := err.(*libnetwork.LoadConfigError)
+	if !ok {
+		t.Fatalf("Expected a LoadConfigError, got %v", err)
+	}
+	var failed []string
+	for _, ce := range lerr.Errors {
+		failed = append(failed, ce.Kind+" "+ce.Name)
+	}
+	if expected := []string{"driver foo", "network network2", "network network 3"}; !reflect.DeepEqual(failed, expected) {
+		t.Fatalf("Expected the failed entries %v, got %v: %v", expected, failed, err)
+	}
+
+	// The entries failing did not prevent the others from being applied
+	n := controller.NetworkByName("network1")
+	if n == nil {
+		t.Fatal("Network network1 not created")
+	}
+	if labels := n.Labels(); labels["env"] != "test" {
+		t.Fatalf("Expected the network labels to be set, got %v", labels)
+	}
+	link, err := netlink.LinkByName("docker0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Attrs().MTU != 1400 {
+		t.Fatalf("Expected the bridge MTU 1400, got %d", link.Attrs().MTU)
+	}
+
+	// Loading the document again leaves the existing networks in place
+	if err := controller.LoadConfig(strings.NewReader(config)); err == nil {
+		t.Fatal("Expected the failing entries to fail again")
+	}
+	if controller.NetworkByName("network1") != n {
+		t.Fatal("Network network1 was created again")
+	}
+
+	if err := controller.LoadConfig(strings.NewReader("{")); err == nil {
+		t.Fatal("Expected the invalid document to be rejected")
+	}
+}
+
+func TestEndpointJoinInterfaceName(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep1, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep2, err := n.CreateEndpoint("ep2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	long := "interface-name-too-long"
+	if _, err := ep1.Join(containerID, libnetwork.JoinOptionInterfaceName(long)); err != libnetwork.InvalidNameError(long) {
+		t.Fatalf("Expected %v, got %v", libnetwork.InvalidNameError(long), err)
+	}
+
+	if _, err := ep1.Join(containerID, libnetwork.JoinOptionInterfaceName("web0")); err != nil {
+		t.Fatal(err)
+	}
+	defer ep1.Leave(containerID)
+	if name := ep1.Info().Interfaces[0].DstName; name != "web0" {
+		t.Fatalf("Expected interface web0, got %s", name)
+	}
+
+	if _, err := ep2.Join(containerID, libnetwork.JoinOptionInterfaceName("web0")); err != libnetwork.InterfaceNameError("web0") {
+		t.Fatalf("Expected %v, got %v", libnetwork.InterfaceNameError("web0"), err)
+	}
+
+	// Unless requested, the first free default name is taken
+	if _, err := ep2.Join(containerID); err != nil {
+		t.Fatal(err)
+	}
+	defer ep2.Leave(containerID)
+	if name := ep2.Info().Interfaces[0].DstName; name != "eth0" {
+		t.Fatalf("Expected interface eth0, got %s", name)
+	}
+}
+
+func TestEndpointLeaveOwnInterfaces(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep1, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep2, err := n.CreateEndpoint("ep2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cd, err := ep1.Join(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ep2.Join(containerID); err != nil {
+		t.Fatal(err)
+	}
+	defer ep2.Leave(containerID)
+
+	name1 := ep1.Info().Interfaces[0].DstName
+	name2 := ep2.Info().Interfaces[0].DstName
+	if name1 != "eth0" || name2 != "eth1" {
+		t.Fatalf("Expected interfaces eth0 and eth1, got %s and %s", name1, name2)
+	}
+
+	// Leaving removes the interfaces of the endpoint only
+	if err := ep1.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+	err = sandbox.Invoke(cd.SandboxKey, func() error {
+		if _, err := netlink.LinkByName(name1); err == nil {
+			return fmt.Errorf("interface %s left in the sandbox", name1)
+		}
+		_, err := netlink.LinkByName(name2)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := netlink.LinkByName(ep1.SandboxInfo().Interfaces[0].SrcName); err != nil {
+		t.Fatalf("Interface not moved back from the sandbox: %v", err)
+	}
+}
+
+func TestEndpointJoinMtu(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{"Mtu": 9000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep1, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep2, err := n.CreateEndpoint("ep2", options.Generic{"Mtu": 1500})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cd, err := ep1.Join(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep1.Leave(containerID)
+	if _, err := ep2.Join(containerID); err != nil {
+		t.Fatal(err)
+	}
+	defer ep2.Leave(containerID)
+
+	// The interfaces keep the MTU of their veth pair in the sandbox
+	for ep, mtu := range map[libnetwork.Endpoint]int{ep1: 9000, ep2: 1500} {
+		name := ep.Info().Interfaces[0].DstName
+		var lnk netlink.Link
+		err := sandbox.Invoke(cd.SandboxKey, func() error {
+			var err error
+			lnk, err = netlink.LinkByName(name)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lnk.Attrs().MTU != mtu {
+			t.Fatalf("Expected MTU %d on %s, got %d", mtu, name, lnk.Attrs().MTU)
+		}
+	}
+}
+
+func TestEndpointInfoPortBindings(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bindings := []types.PortBinding{
+		{Proto: types.TCP, ContainerPort: 80},
+		{Proto: types.UDP, ContainerPort: 53, HostPort: 55053},
+	}
+	ep, err := n.CreateEndpoint("ep1", options.Generic{"PortBindings": bindings})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ep.Info().PortBindings) != 0 {
+		t.Fatalf("Expected no port bindings before join, got %v", ep.Info().PortBindings)
+	}
+
+	if _, err := ep.Join(containerID); err != nil {
+		t.Fatal(err)
+	}
+
+	pbs := ep.Info().PortBindings
+	if len(pbs) != 2 {
+		t.Fatalf("Expected 2 port bindings, got %v", pbs)
+	}
+
+	if pbs[0].HostPort == 0 {
+		t.Fatalf("Expected a dynamically allocated host port, got %v", pbs[0])
+	}
+
+	if pbs[1].HostPort != 55053 {
+		t.Fatalf("Expected host port 55053, got %v", pbs[1])
+	}
+
+	if err := ep.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ep.Info().PortBindings) != 0 {
+		t.Fatalf("Expected no port bindings after leave, got %v", ep.Info().PortBindings)
+	}
+
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEndpointInfoBandwidth(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := n.CreateEndpoint("ep1", options.Generic{"EgressRate": "1mbit"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ep.Info().Bandwidth != nil {
+		t.Fatalf("Expected no bandwidth limits before join, got %v", ep.Info().Bandwidth)
+	}
+
+	if _, err := ep.Join(containerID); err != nil {
+		t.Fatal(err)
+	}
+
+	if bw := ep.Info().Bandwidth; bw == nil || bw.Egress != 1000*1000 || bw.Ingress != 0 {
+		t.Fatalf("Expected an egress limit of 1mbit, got %v", bw)
+	}
+
+	if err := ep.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+
+	if ep.Info().Bandwidth != nil {
+		t.Fatalf("Expected no bandwidth limits after leave, got %v", ep.Info().Bandwidth)
+	}
+
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEndpointInfoFirewallRules(t *testing.T) {
+	if _, err := exec.LookPath("iptables"); err != nil {
+		t.Skip("iptables is not available")
+	}
+	defer netutils.SetupTestNetNS(t)()
+
+	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := []types.FirewallRule{{Action: "deny", Direction: "ingress", Proto: "udp", Port: 53}}
+	ep, err := n.CreateEndpoint("ep1", options.Generic{"FirewallRules": rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ep.Info().FirewallRules) != 0 {
+		t.Fatalf("Expected no firewall rules before join, got %v", ep.Info().FirewallRules)
+	}
+
+	if _, err := ep.Join(containerID); err != nil {
+		t.Fatal(err)
+	}
+
+	if r := ep.Info().FirewallRules; len(r) != 1 || r[0].String() != "deny ingress udp/53" {
+		t.Fatalf("Expected the rule deny ingress udp/53, got %v", r)
+	}
+
+	if err := ep.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ep.Info().FirewallRules) != 0 {
+		t.Fatalf("Expected no firewall rules after leave, got %v", ep.Info().FirewallRules)
+	}
+
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEndpointInfoIPv6(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	_, cidrv6, err := net.ParseCIDR("2001:db8:1234::/64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	option := options.Generic{
+		"EnableIPv6":  true,
+		"FixedCIDRv6": cidrv6}
+
+	n, err := createTestNetwork("bridge", "testnetwork", option)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := ep.Info()
+	if info.Interfaces[0].Address == nil || info.Interfaces[0].AddressIPv6 == nil {
+		t.Fatalf("Expected both IPv4 and IPv6 addresses, got %v and %v",
+			info.Interfaces[0].Address, info.Interfaces[0].AddressIPv6)
+	}
+
+	if !cidrv6.Contains(info.Interfaces[0].AddressIPv6.IP) {
+		t.Fatalf("IPv6 address %v not allocated from %v", info.Interfaces[0].AddressIPv6, cidrv6)
+	}
+
+	if info.GatewayIPv6 == nil {
+		t.Fatal("Endpoint info carries no IPv6 gateway")
+	}
+
+	if _, err := ep.Join(containerID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEndpointJoinInvalidContainerId(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ep.Join("")
+	if err == nil {
+		t.Fatal("Expected to fail join with empty container id string")
+	}
+
+	if _, ok := err.(libnetwork.InvalidContainerIDError); !ok {
+		t.Fatalf("Failed for unexpected reason: %v", err)
+	}
+}
+
+func TestEndpointMultipleJoins(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ep.Join(containerID,
+		libnetwork.JoinOptionHostname("test"),
+		libnetwork.JoinOptionDomainname("docker.io"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ep.Join("container2")
+	if err == nil {
+		t.Fatal("Expected to fail multiple joins for the same endpoint")
+	}
+
+	if err != libnetwork.ErrInvalidJoin {
+		t.Fatalf("Failed for unexpected reason: %v", err)
+	}
+
+	err = ep.Leave(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEndpointJoinLeaveCycles(t *testing.T) {
+	controller := libnetwork.New()
+
+	n, err := controller.NewNetwork("null", "testnetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cid := range []string{containerID, "container2", containerID} {
+		if _, err := ep.Join(cid); err != nil {
+			t.Fatal(err)
+		}
+
+		if ep.ContainerID() != cid {
+			t.Fatalf("Expected container id %s, got %s", cid, ep.ContainerID())
+		}
+
+		if _, err := ep.Join("container3"); err != libnetwork.ErrEndpointInUse {
+			t.Fatalf("Expected ErrEndpointInUse, got %v", err)
+		}
+
+		if ep.ContainerID() != cid {
+			t.Fatalf("Failed join altered the joined container id: %s", ep.ContainerID())
+		}
+
+		if err := ep.Leave(cid); err != nil {
+			t.Fatal(err)
+		}
+
+		if ep.ContainerID() != "" {
+			t.Fatalf("Expected no container id after leave, got %s", ep.ContainerID())
+		}
+	}
+}
+
+func TestEndpointDeleteJoined(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := ep.Info().Interfaces[0].Address.IP
+
+	_, err = ep.Join(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Delete(); err != libnetwork.ErrEndpointInUse {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrEndpointInUse, err)
+	}
+
+	if err := ep.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n.EndpointByID(ep.ID()) != nil {
+		t.Fatal("Deleted endpoint still in the network")
+	}
+
+	// The address of the deleted endpoint is available again
+	ep, err = n.CreateEndpoint("ep2", options.Generic{"AddressIPv4": ip})
+	if err != nil {
+		t.Fatalf("Failed to reuse the address of the deleted endpoint: %v", err)
+	}
+
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := n.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEndpointInvalidLeave(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ep.Leave(containerID)
+	if err == nil {
+		t.Fatal("Expected to fail leave from an endpoint which has no active join")
+	}
+
+	if err != libnetwork.ErrNoContainer {
+		t.Fatalf("Failed for unexpected reason: %v", err)
+	}
+
+	_, err = ep.Join(containerID,
+		libnetwork.JoinOptionHostname("test"),
+		libnetwork.JoinOptionDomainname("docker.io"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ep.Leave("")
+	if err == nil {
+		t.Fatal("Expected to fail leave with empty container id")
+	}
+
+	if _, ok := err.(libnetwork.InvalidContainerIDError); !ok {
+		t.Fatalf("Failed for unexpected reason: %v", err)
+	}
+
+	err = ep.Leave("container2")
+	if err == nil {
+		t.Fatal("Expected to fail leave with wrong container id")
+	}
+
+	if _, ok := err.(libnetwork.InvalidContainerIDError); !ok {
+		t.Fatalf("Failed for unexpected reason: %v", err)
+	}
+
+	err = ep.Leave(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestControllerDeleteNetwork(t *testing.T) {
+	controller := libnetwork.New()
+
+	err := controller.ConfigureNetworkDriver("null", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	network, err := controller.NewNetwork("null", "testnetwork", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := network.CreateEndpoint("testep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = controller.DeleteNetwork(network.ID())
+	if err == nil {
+		t.Fatal("Expected to fail. But instead succeeded")
+	}
+
+	if _, ok := err.(*libnetwork.ActiveEndpointsError); !ok {
+		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	}
+
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := controller.DeleteNetwork(network.ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	if controller.NetworkByID(network.ID()) != nil {
+		t.Fatal("Network was not removed from the controller")
+	}
+
+	err = controller.DeleteNetwork(network.ID())
+	if err == nil {
+		t.Fatal("Expected to fail. But instead succeeded")
+	}
+
+	if _, ok := err.(*libnetwork.UnknownNetworkError); !ok {
+		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	}
+}
+
+func TestNetworkInfo(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	controller := libnetwork.New()
+
+	ip, subnet, err := net.ParseCIDR("192.168.245.1/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subnet.IP = ip
+
+	option := options.Generic{
+		"BridgeName":            bridgeName,
+		"AddressIPv4":           subnet,
+		"AllowNonDefaultBridge": true}
+	if err := controller.ConfigureNetworkDriver(netType, option); err != nil {
+		t.Fatal(err)
+	}
+
+	labels := map[string]string{"tier": "frontend"}
+	network, err := controller.NewNetwork(netType, "testnetwork", "", libnetwork.NetworkOptionLabels(labels))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := network.Info()
+	if info.Type != netType || info.Driver != netType {
+		t.Fatalf("Unexpected network type %q and driver %q", info.Type, info.Driver)
+	}
+
+	if len(info.Subnets) != 1 || info.Subnets[0].String() != "192.168.245.0/24" {
+		t.Fatalf("Unexpected network subnets %v", info.Subnets)
+	}
+
+	if !info.Gateway.Equal(ip) {
+		t.Fatalf("Expected gateway %v, got %v", ip, info.Gateway)
+	}
+
+	if info.Labels["tier"] != "frontend" {
+		t.Fatalf("Unexpected network labels %v", info.Labels)
+	}
+
+	// Mutating the snapshot must not alter the network
+	info.Subnets[0].IP[0] = 10
+	info.Gateway[0] = 10
+	info.Labels["tier"] = "backend"
+
+	info = network.Info()
+	if info.Subnets[0].String() != "192.168.245.0/24" || !info.Gateway.Equal(ip) || info.Labels["tier"] != "frontend" {
+		t.Fatalf("Network info altered through a snapshot: %v", info)
+	}
+}
+
+func TestNetworkLabels(t *testing.T) {
+	controller := libnetwork.New()
+
+	err := controller.ConfigureNetworkDriver("null", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := map[string]string{"tier": "frontend"}
+	net1, err := controller.NewNetwork("null", "network1", "", libnetwork.NetworkOptionLabels(labels))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = controller.NewNetwork("null", "network2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mutating the passed map or the returned copy must not alter the network
+	labels["tier"] = "backend"
+	net1.Labels()["tier"] = "backend"
+	if v := net1.Labels()["tier"]; v != "frontend" {
+		t.Fatalf("Expected label value frontend, got %q", v)
+	}
+
+	list := controller.NetworksByLabel("tier", "frontend")
+	if len(list) != 1 || list[0] != net1 {
+		t.Fatalf("NetworksByLabel() returned unexpected networks: %v", list)
+	}
+
+	list = controller.NetworksByLabel("tier", "backend")
+	if len(list) != 0 {
+		t.Fatalf("NetworksByLabel() returned unexpected networks: %v", list)
+	}
+}
+
+func TestNetworkFilters(t *testing.T) {
+	controller := libnetwork.New()
+
+	labels := map[string]string{"tier": "frontend"}
+	net1, err := controller.NewNetwork("null", "network1", "", libnetwork.NetworkOptionLabels(labels))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	net2, err := controller.NewNetwork("host", "network2", "", libnetwork.NetworkOptionLabels(labels))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := controller.NewNetwork("null", "network3", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	list := controller.NetworksByType("host")
+	if len(list) != 1 || list[0] != net2 {
+		t.Fatalf("NetworksByType() returned unexpected networks: %v", list)
+	}
+
+	if list = controller.NetworksByType("null"); len(list) != 2 {
+		t.Fatalf("Expected two null networks, got %v", list)
+	}
+
+	list = controller.FilterNetworks(libnetwork.NetworkFilterType("null"), libnetwork.NetworkFilterLabel("tier", "frontend"))
+	if len(list) != 1 || list[0] != net1 {
+		t.Fatalf("FilterNetworks() returned unexpected networks: %v", list)
+	}
+
+	if list = controller.FilterNetworks(); len(list) != 3 {
+		t.Fatalf("Expected all the networks without filters, got %v", list)
+	}
+
+	// Filtering works on a snapshot of the networks created concurrently
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			controller.NewNetwork("null", fmt.Sprintf("concurrent%d", i), "", libnetwork.NetworkOptionLabels(labels))
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		controller.FilterNetworks(libnetwork.NetworkFilterLabel("tier", "frontend"))
+	}
+	<-done
+
+	if list = controller.NetworksByLabel("tier", "frontend"); len(list) != 12 {
+		t.Fatalf("Expected 12 frontend networks, got %d", len(list))
+	}
+}
+
+func TestControllerID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libnetwork-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := datastore.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller, err := libnetwork.NewWithOptions(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if controller.ID() == "" {
+		t.Fatal("Controller has no id")
+	}
+
+	// The network records do not mix with the controller one
+	if _, err := controller.NewNetwork("null", "testnetwork", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = datastore.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := libnetwork.NewWithOptions(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.ID() != controller.ID() {
+		t.Fatalf("Expected the restored controller id %s, got %s", controller.ID(), restored.ID())
+	}
+	if len(restored.Networks()) != 1 {
+		t.Fatalf("Expected 1 restored network, got %d", len(restored.Networks()))
+	}
+
+	if id := libnetwork.New().ID(); id == "" || id == libnetwork.New().ID() {
+		t.Fatalf("Expected a fresh id per in-memory controller, got %q", id)
+	}
+}
+
+func TestControllerRestore(t *testing.T) {
+	store := datastore.NewMemoryStore()
+
+	controller, err := libnetwork.NewWithOptions(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	network, err := controller.NewNetwork("null", "testnetwork", "",
+		libnetwork.NetworkOptionLabels(map[string]string{"tier": "frontend"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := network.CreateEndpoint("testep", nil, libnetwork.EndpointOptionAliases("web"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := network.CreateEndpoint("tmpep", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := network.EndpointByName("tmpep").Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := libnetwork.NewWithOptions(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := restored.NetworkByID(network.ID())
+	if n == nil {
+		t.Fatal("Network was not restored from the store")
+	}
+
+	if n.Name() != network.Name() || n.Type() != network.Type() {
+		t.Fatalf("Restored network %s/%s does not match %s/%s", n.Name(), n.Type(), network.Name(), network.Type())
+	}
+
+	if n.Labels()["tier"] != "frontend" {
+		t.Fatalf("Network labels were not restored: %v", n.Labels())
+	}
+
+	eps := n.Endpoints()
+	if len(eps) != 1 || eps[0].ID() != ep.ID() {
+		t.Fatalf("Endpoints were not correctly restored: %v", eps)
+	}
+	if !reflect.DeepEqual(eps[0].Aliases(), []string{"web"}) {
+		t.Fatalf("Endpoint aliases were not restored: %v", eps[0].Aliases())
+	}
+
+	if err := eps[0].Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restored.DeleteNetwork(n.ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err = libnetwork.NewWithOptions(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(restored.Networks()) != 0 {
+		t.Fatalf("Deleted network was restored from the store")
+	}
+}
+
+func TestBridgeRestore(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	store := datastore.NewMemoryStore()
+	option := options.Generic{
+		"BridgeName":            bridgeName,
+		"AllowNonDefaultBridge": true}
+
+	controller, err := libnetwork.NewWithOptions(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.ConfigureNetworkDriver(netType, option); err != nil {
+		t.Fatal(err)
+	}
+	network, err := controller.NewNetwork(netType, "testnetwork", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := network.CreateEndpoint("testep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := libnetwork.NewWithOptions(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := restored.NetworkByID(network.ID())
+	if n == nil {
+		t.Fatal("Network was not restored from the store")
+	}
+	rep := n.EndpointByID(ep.ID())
+	if rep == nil {
+		t.Fatal("Endpoint was not restored from the store")
+	}
+
+	// The driver takes the network back once it is configured
+	if err := rep.Delete(); err != libnetwork.ErrDriverNotConfigured {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrDriverNotConfigured, err)
+	}
+	if err := restored.ConfigureNetworkDriver(netType, option); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := netlink.LinkByName(bridgeName); err == nil {
+		t.Fatalf("Bridge %s was not removed with the restored network", bridgeName)
+	}
+}
+
+func TestReloadDriverConfig(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	controller := libnetwork.New()
+
+	bridgeNet := &net.IPNet{IP: net.ParseIP("192.168.100.1"), Mask: net.CIDRMask(24, 32)}
+	option := options.Generic{"AddressIPv4": bridgeNet}
+	if err := controller.ReloadDriverConfig("bridge", option); err != libnetwork.ErrDriverNotConfigured {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrDriverNotConfigured, err)
+	}
+	if err := controller.ReloadDriverConfig("unknown", option); err != libnetwork.NetworkTypeError("unknown") {
+		t.Fatalf("Expected %v, got %v", libnetwork.NetworkTypeError("unknown"), err)
+	}
+
+	if err := controller.ConfigureNetworkDriver("null", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.ReloadDriverConfig("null", nil); err != libnetwork.ReloadNotSupportedError("null") {
+		t.Fatalf("Expected %v, got %v", libnetwork.ReloadNotSupportedError("null"), err)
+	}
+
+	if err := controller.ConfigureNetworkDriver("bridge", option); err != nil {
+		t.Fatal(err)
+	}
+	n, err := controller.NewNetwork("bridge", "testnetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, cancel := controller.Subscribe()
+	defer cancel()
+
+	// The gateway can change under the network, the subnet can not
+	gw4 := net.ParseIP("192.168.100.254")
+	if err := controller.ReloadDriverConfig("bridge", options.Generic{"AddressIPv4": bridgeNet, "DefaultGatewayIPv4": gw4}); err != nil {
+		t.Fatal(err)
+	}
+	if ev := <-events; ev.Type != libnetwork.EventDriverReload || ev.ID != "bridge" {
+		t.Fatalf("Expected a driver reload event for bridge, got %+v", ev)
+	}
+	if gw := n.Info().Gateway; !gw.Equal(gw4) {
+		t.Fatalf("Expected gateway %v, got %v", gw4, gw)
+	}
+
+	other := &net.IPNet{IP: net.ParseIP("192.168.101.1"), Mask: net.CIDRMask(24, 32)}
+	err = controller.ReloadDriverConfig("bridge", options.Generic{"AddressIPv4": other, "DefaultGatewayIPv4": gw4})
+	if ioe, ok := err.(driverapi.ImmutableOptionsError); !ok || len(ioe) != 1 || ioe[0] != "AddressIPv4" {
+		t.Fatalf("Expected an ImmutableOptionsError for AddressIPv4, got %v", err)
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("Unexpected event %+v for the rejected reload", ev)
+	default:
+	}
+}
+
+func TestControllerEvents(t *testing.T) {
+	controller := libnetwork.New()
+
+	events, cancel := controller.Subscribe()
+
+	network, err := controller.NewNetwork("null", "testnetwork", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := network.SetOptions(options.Generic{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := network.CreateEndpoint("testep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ep.Join(containerID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := network.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+
+	expected := []struct {
+		Type libnetwork.EventType
+		ID   string
+	}{
+		{libnetwork.EventNetworkCreate, network.ID()},
+		{libnetwork.EventNetworkUpdate, network.ID()},
+		{libnetwork.EventEndpointCreate, ep.ID()},
+		{libnetwork.EventEndpointJoin, ep.ID()},
+		{libnetwork.EventEndpointLeave, ep.ID()},
+		{libnetwork.EventEndpointDelete, ep.ID()},
+		{libnetwork.EventNetworkDelete, network.ID()},
+	}
+
+	i := 0
+	for ev := range events {
+		if i >= len(expected) {
+			t.Fatalf("Unexpected event %v", ev)
+		}
+		if ev.Type != expected[i].Type || ev.ID != expected[i].ID {
+			t.Fatalf("Expected event %s for %s, got %s for %s", expected[i].Type, expected[i].ID, ev.Type, ev.ID)
+		}
+		if ev.Time.IsZero() {
+			t.Fatal("Event carries no timestamp")
+		}
+		if ev.Controller != controller.ID() {
+			t.Fatalf("Expected event from controller %s, got %s", controller.ID(), ev.Controller)
+		}
+		i++
+	}
+
+	if i != len(expected) {
+		t.Fatalf("Received %d events instead of %d", i, len(expected))
+	}
+}
+
+func TestControllerEventsSlowSubscriber(t *testing.T) {
+	controller := libnetwork.New()
+
+	// Never drain the channel: the controller must not block on it.
+	_, cancel := controller.Subscribe()
+	defer cancel()
+
+	for i := 0; i < 100; i++ {
+		n, err := controller.NewNetwork("null", "testnetwork", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// sysctlIn returns the value of the sysctl at path in the sandbox.
+func sysctlIn(t *testing.T, key, path string) string {
+	var b []byte
+	err := sandbox.Invoke(key, func() error {
+		var err error
+		b, err = ioutil.ReadFile(path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func TestEndpointJoinSysctls(t *testing.T) {
+	controller := libnetwork.New()
+
+	n, err := controller.NewNetwork("null", "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep1, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep2, err := n.CreateEndpoint("ep2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cData, err := ep1.Join(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep1.Leave(containerID)
+
+	name, path := "net.ipv4.ip_forward", "/proc/sys/net/ipv4/ip_forward"
+	orig := sysctlIn(t, cData.SandboxKey, path)
+
+	invalid := "net.ipv4.conf.eth9.rp_filter"
+	_, err = ep2.Join(containerID, libnetwork.JoinOptionSysctls(map[string]string{name: "1", invalid: "1"}))
+	if err != sandbox.InvalidSysctlError(invalid) {
+		t.Fatalf("Expected %v, got %v", sandbox.InvalidSysctlError(invalid), err)
+	}
+	if v := sysctlIn(t, cData.SandboxKey, path); v != orig || ep2.ContainerID() != "" {
+		t.Fatalf("Failed join not rolled back, sysctl %s is %s", name, v)
+	}
+
+	if _, err := ep2.Join(containerID, libnetwork.JoinOptionSysctls(map[string]string{name: "1"})); err != nil {
+		t.Fatal(err)
+	}
+	if v := sysctlIn(t, cData.SandboxKey, path); v != "1" {
+		t.Fatalf("Expected sysctl %s to be 1, got %s", name, v)
+	}
+
+	if err := ep2.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+	if v := sysctlIn(t, cData.SandboxKey, path); v != orig {
+		t.Fatalf("Expected sysctl %s to be restored to %s, got %s", name, orig, v)
+	}
+
+	// A sysctl several endpoints set is restored once the last of them
+	// leaves, and can not be set to another value meanwhile
+	ep4, err := n.CreateEndpoint("ep4", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep5, err := n.CreateEndpoint("ep5", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ep := range []libnetwork.Endpoint{ep2, ep4} {
+		if _, err := ep.Join(containerID, libnetwork.JoinOptionSysctls(map[string]string{name: "1"})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = ep5.Join(containerID, libnetwork.JoinOptionSysctls(map[string]string{name: "0"}))
+	if _, ok := err.(*libnetwork.SysctlConflictError); !ok {
+		t.Fatalf("Expected a sysctl conflict error, got %v", err)
+	}
+	if err := ep2.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+	if v := sysctlIn(t, cData.SandboxKey, path); v != "1" {
+		t.Fatalf("Sysctl %s restored to %s while still set by a joined endpoint", name, v)
+	}
+	if err := ep4.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+	if v := sysctlIn(t, cData.SandboxKey, path); v != orig {
+		t.Fatalf("Expected sysctl %s to be restored to %s, got %s", name, orig, v)
+	}
+
+	// The host sysctls are not the container ones
+	hostNet, err := controller.NewNetwork("host", "host", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep3, err := hostNet.CreateEndpoint("ep3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ep3.Join("container2", libnetwork.JoinOptionSysctls(map[string]string{name: "1"})); err != libnetwork.ErrHostNetworkSysctls {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrHostNetworkSysctls, err)
+	}
+}
+
+func TestEndpointJoinGatewayConflict(t *testing.T) {
+	controller := libnetwork.New()
+
+	net1, err := controller.NewNetwork("null", "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	net2, err := controller.NewNetwork("null", "network2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep1, err := net1.CreateEndpoint("ep1", nil, libnetwork.EndpointOptionDefaultGateway())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep2, err := net2.CreateEndpoint("ep2", nil, libnetwork.EndpointOptionDefaultGateway())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ep1.Join(containerID); err != nil {
+		t.Fatal(err)
+	}
+	defer ep1.Leave(containerID)
+
+	if _, err := ep2.Join(containerID); err != libnetwork.GatewayConflictError("ep1") {
+		t.Fatalf("Expected %v, got %v", libnetwork.GatewayConflictError("ep1"), err)
+	}
+
+	// The failed join left the endpoint free to join another container
+	if _, err := ep2.Join("container2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep2.Leave("container2"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEndpointJoinDNS(t *testing.T) {
+	controller := libnetwork.New()
+
+	if err := controller.ConfigureNetworkDriver("null", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	net1, err := controller.NewNetwork("null", "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	net2, err := controller.NewNetwork("null", "network2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep1, err := net1.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep2, err := net2.CreateEndpoint("ep2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cData, err := ep1.Join(containerID,
+		libnetwork.JoinOptionDNS("10.0.0.2"),
+		libnetwork.JoinOptionDNSSearch("example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ep2.Join(containerID,
+		libnetwork.JoinOptionDNS("10.0.0.3"),
+		libnetwork.JoinOptionDNS("10.0.0.2"),
+		libnetwork.JoinOptionDNSOptions("ndots:2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(cData.ResolvConfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "nameserver 10.0.0.2\nnameserver 10.0.0.3\nsearch example.com\noptions ndots:2\n"
+	if string(content) != expected {
+		t.Fatalf("Expected resolv.conf %q, got %q", expected, content)
+	}
+
+	if err := ep1.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err = ioutil.ReadFile(cData.ResolvConfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The search domains not set by ep2 now come from the host
+	hostResolvConf, _ := resolvconf.Get()
+	if search := resolvconf.GetSearchDomains(content); !reflect.DeepEqual(search, resolvconf.GetSearchDomains(hostResolvConf)) {
+		t.Fatalf("Expected the host search domains after leave, got %v", search)
+	}
+
+	expectedNS := []string{"10.0.0.3", "10.0.0.2"}
+	if ns := resolvconf.GetNameservers(content); !reflect.DeepEqual(ns, expectedNS) {
+		t.Fatalf("Expected nameservers %v after leave, got %v", expectedNS, ns)
+	}
+
+	if err := ep2.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEndpointJoinDNSHostFallback(t *testing.T) {
+	network, err := createTestNetwork("null", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := network.CreateEndpoint("testep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cData, err := ep.Join(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Leave(containerID)
+
+	content, err := ioutil.ReadFile(cData.ResolvConfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostResolvConf, _ := resolvconf.Get()
+	expected := resolvconf.FilterLocalNameservers(resolvconf.GetNameservers(hostResolvConf))
+	if ns := resolvconf.GetNameservers(content); !reflect.DeepEqual(ns, expected) {
+		t.Fatalf("Expected the host nameservers %v, got %v", expected, ns)
+	}
+}
+
+func TestEndpointUpdateDNS(t *testing.T) {
+	controller := libnetwork.New()
+
+	net1, err := controller.NewNetwork("null", "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	net2, err := controller.NewNetwork("null", "network2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep1, err := net1.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep2, err := net2.CreateEndpoint("ep2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep1.UpdateDNS([]string{"10.0.0.4"}, nil, nil); err != libnetwork.ErrNoContainer {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrNoContainer, err)
+	}
+
+	cData, err := ep1.Join(containerID,
+		libnetwork.JoinOptionDNS("10.0.0.2"),
+		libnetwork.JoinOptionDNSSearch("example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep1.Leave(containerID)
+
+	if _, err := ep2.Join(containerID, libnetwork.JoinOptionDNS("10.0.0.3")); err != nil {
+		t.Fatal(err)
+	}
+	defer ep2.Leave(containerID)
+
+	before, err := os.Stat(cData.ResolvConfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, cancel := controller.Subscribe()
+	defer cancel()
+
+	if err := ep2.UpdateDNS([]string{"dns.example.com"}, nil, nil); !types.IsInvalidParameter(err) {
+		t.Fatalf("Expected an invalid parameter error, got %v", err)
+	}
+
+	if err := ep2.UpdateDNS([]string{"10.0.0.4", "10.0.0.2"}, []string{"corp.example"}, []string{"ndots:1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(cData.ResolvConfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "nameserver 10.0.0.2\nnameserver 10.0.0.4\nsearch example.com corp.example\noptions ndots:1\n"
+	if string(content) != expected {
+		t.Fatalf("Expected resolv.conf %q, got %q", expected, content)
+	}
+
+	// The file is rewritten in place, as it is mounted in the container
+	after, err := os.Stat(cData.ResolvConfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Fatal("resolv.conf replaced instead of rewritten")
+	}
+
+	select {
+	case ev := <-events:
+		if ev.Type != libnetwork.EventEndpointDNSUpdate || ev.ID != ep2.ID() {
+			t.Fatalf("Expected event %s for %s, got %s for %s", libnetwork.EventEndpointDNSUpdate, ep2.ID(), ev.Type, ev.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No event emitted on DNS update")
+	}
+
+	// Concurrent updates of the endpoints of the sandbox merge consistently
+	var wg sync.WaitGroup
+	for i, ep := range []libnetwork.Endpoint{ep1, ep2} {
+		wg.Add(1)
+		go func(ep libnetwork.Endpoint, ns string) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := ep.UpdateDNS([]string{ns}, nil, nil); err != nil {
+					t.Error(err)
+				}
+			}
+		}(ep, fmt.Sprintf("10.0.1.%d", i+1))
+	}
+	wg.Wait()
+
+	content, err = ioutil.ReadFile(cData.ResolvConfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedNS := []string{"10.0.1.1", "10.0.1.2"}
+	if ns := resolvconf.GetNameservers(content); !reflect.DeepEqual(ns, expectedNS) {
+		t.Fatalf("Expected nameservers %v, got %v", expectedNS, ns)
+	}
+}
+
+// lookupIn resolves name from the sandbox identified by key, through the
+// nameserver at address.
+func lookupIn(key, address, name string) ([]string, error) {
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var conn net.Conn
+			err := sandbox.Invoke(key, func() error {
+				var err error
+				conn, err = (&net.Dialer{}).DialContext(ctx, network, net.JoinHostPort(address, "53"))
+				return err
+			})
+			return conn, err
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return r.LookupHost(ctx, name)
+}
+
+func TestEmbeddedDNS(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	controller := libnetwork.New()
+
+	if err := controller.ConfigureNetworkDriver(netType, options.Generic{}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := controller.NewNetwork(netType, "testnetwork", "", libnetwork.NetworkOptionEmbeddedDNS())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep1, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep2, err := n.CreateEndpoint("ep2", nil, libnetwork.EndpointOptionAliases("db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cData, err := ep1.Join("container1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep1.Leave("container1")
+
+	content, err := ioutil.ReadFile(cData.ResolvConfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns := resolvconf.GetNameservers(content); !reflect.DeepEqual(ns, []string{resolver.Address}) {
+		t.Fatalf("Expected the embedded resolver as nameserver, got %v", ns)
+	}
+
+	// Endpoints are resolvable once joined
+	if addrs, err := lookupIn(cData.SandboxKey, resolver.Address, "db."); err == nil {
+		t.Fatalf("Expected the lookup of the endpoint not joined to fail, got %v", addrs)
+	}
+
+	if _, err := ep2.Join("container2"); err != nil {
+		t.Fatal(err)
+	}
+
+	ip := ep2.Info().Interfaces[0].Address.IP.String()
+	for _, name := range []string{"ep2.", "DB."} {
+		addrs, err := lookupIn(cData.SandboxKey, resolver.Address, name)
+		if err != nil {
+			t.Fatalf("Failed to resolve %s: %v", name, err)
+		}
+		if !reflect.DeepEqual(addrs, []string{ip}) {
+			t.Fatalf("Expected %s to resolve to %s, got %v", name, ip, addrs)
+		}
+	}
+
+	if err := ep2.Leave("container2"); err != nil {
+		t.Fatal(err)
+	}
+	if addrs, err := lookupIn(cData.SandboxKey, resolver.Address, "db."); err == nil {
+		t.Fatalf("Expected the lookup of the endpoint which left to fail, got %v", addrs)
+	}
+}
+
+func TestEndpointJoinHostsFile(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	n, err := createTestNetwork("bridge", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cData, err := ep.Join(containerID,
+		libnetwork.JoinOptionHostname("test"),
+		libnetwork.JoinOptionDomainname("docker.io"),
+		libnetwork.JoinOptionExtraHost("web", "10.1.1.1"),
+		libnetwork.JoinOptionExtraHost("db", "10.1.1.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(cData.HostsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := ep.Info().Interfaces[0].Address.IP
+	for _, entry := range []string{
+		ip.String() + "\ttest.docker.io test\n",
+		"127.0.0.1\tlocalhost\n",
+		"10.1.1.1\tweb\n",
+		"10.1.1.2\tdb\n",
+	} {
+		if !strings.Contains(string(content), entry) {
+			t.Fatalf("Expected entry %q in hosts file, got:\n%s", entry, content)
+		}
+	}
+
+	if err := ep.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err = ioutil.ReadFile(cData.HostsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(content), "127.0.0.1\tlocalhost\n") {
+		t.Fatalf("Expected localhost entry in hosts file after leave, got:\n%s", content)
+	}
+	for _, entry := range []string{ip.String(), "web", "db"} {
+		if strings.Contains(string(content), entry) {
+			t.Fatalf("Unexpected entry %q in hosts file after leave:\n%s", entry, content)
+		}
+	}
+}
+
+func TestEndpointJoinHostname(t *testing.T) {
+	network, err := createTestNetwork("null", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := network.CreateEndpoint("testep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"bad_host", "-host", "host-", "a..b", strings.Repeat("a", 65)} {
+		_, err = ep.Join(containerID, libnetwork.JoinOptionHostname(name))
+		if _, ok := err.(libnetwork.InvalidHostnameError); !ok {
+			t.Fatalf("Expected InvalidHostnameError for %q, got %v", name, err)
+		}
+	}
+
+	if _, err := ep.Join(containerID, libnetwork.JoinOptionHostname("test-host")); err != nil {
+		t.Fatal(err)
+	}
+
+	if hostname := ep.Info().Hostname; hostname != "test-host" {
+		t.Fatalf("Expected hostname test-host, got %q", hostname)
+	}
+
+	// Another endpoint of the container can not change the hostname
+	ep2, err := network.CreateEndpoint("testep2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ep2.Join(containerID, libnetwork.JoinOptionHostname("other-host"))
+	if name, ok := err.(libnetwork.HostnameConflictError); !ok || name != "test-host" || !types.IsForbidden(err) {
+		t.Fatalf("Expected HostnameConflictError for test-host, got %v", err)
+	}
+	if _, err := ep2.Join(containerID, libnetwork.JoinOptionHostname("test-host")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+
+	if hostname := ep.Info().Hostname; hostname != "" {
+		t.Fatalf("Expected empty hostname after leave, got %q", hostname)
+	}
+	if hostname := ep2.Info().Hostname; hostname != "test-host" {
+		t.Fatalf("Expected hostname test-host after the first endpoint left, got %q", hostname)
+	}
+	if err := ep2.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestControllerGC(t *testing.T) {
+	controller := libnetwork.New()
+
+	network, err := controller.NewNetwork("null", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := network.CreateEndpoint("testep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	joined, err := ep.Join(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Leave(containerID)
+
+	// Sandboxes left behind, as by a crash of a previous controller
+	orphanKey := sandbox.GenerateKey("gc_orphan")
+	if _, err := sandbox.NewSandbox(orphanKey, true); err != nil {
+		t.Fatal(err)
+	}
+	aliveKey := sandbox.GenerateKey("gc_alive")
+	if _, err := sandbox.NewSandbox(aliveKey, true); err != nil {
+		t.Fatal(err)
+	}
+	defer sandbox.Remove(aliveKey)
+
+	alive := func(key string) bool { return key == aliveKey }
+
+	contains := func(keys []string, key string) bool {
+		for _, k := range keys {
+			if k == key {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Without the predicate the sandboxes of others would be destroyed
+	if _, err := controller.GC(nil, false); err != libnetwork.ErrNoAlivePredicate {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrNoAlivePredicate, err)
+	}
+	if _, err := os.Stat(orphanKey); err != nil {
+		t.Fatalf("Sandbox removed without a predicate: %v", err)
+	}
+
+	orphans, err := controller.GC(alive, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !contains(orphans, orphanKey) {
+		t.Fatalf("Expected orphan sandbox %s to be reported, got %v", orphanKey, orphans)
+	}
+	if contains(orphans, aliveKey) || contains(orphans, joined.SandboxKey) {
+		t.Fatalf("Sandboxes in use reported as orphans: %v", orphans)
+	}
+	if _, err := os.Stat(orphanKey); err != nil {
+		t.Fatalf("Orphan sandbox removed in dry run: %v", err)
+	}
+
+	orphans, err = controller.GC(alive, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !contains(orphans, orphanKey) {
+		t.Fatalf("Expected orphan sandbox %s to be removed, got %v", orphanKey, orphans)
+	}
+	if _, err := os.Stat(orphanKey); !os.IsNotExist(err) {
+		t.Fatalf("Orphan sandbox not removed: %v", err)
+	}
+
+	for _, key := range []string{aliveKey, joined.SandboxKey} {
+		if _, err := os.Stat(key); err != nil {
+			t.Fatalf("Sandbox in use %s removed: %v", key, err)
+		}
+	}
+}
+
+func TestEndpointJoinSandboxKey(t *testing.T) {
+	network, err := createTestNetwork("null", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep1, err := network.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep2, err := network.CreateEndpoint("ep2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cData1, err := ep1.Join(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep1.Leave(containerID)
+
+	// The second join references the sandbox created by the first one
+	cData2, err := ep2.Join(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep2.Leave(containerID)
+
+	if cData1.SandboxKey == "" {
+		t.Fatal("Expected a sandbox key from join")
+	}
+	if cData1.SandboxKey != cData2.SandboxKey {
+		t.Fatalf("Expected the same sandbox key for both joins, got %s and %s", cData1.SandboxKey, cData2.SandboxKey)
+	}
+	if key := sandbox.GenerateKey(containerID); cData1.SandboxKey != key {
+		t.Fatalf("Expected sandbox key %s, got %s", key, cData1.SandboxKey)
+	}
+
+	if _, err := os.Stat(cData1.SandboxKey); err != nil {
+		t.Fatalf("Sandbox key does not point to the sandbox: %v", err)
+	}
+	if key := ep2.Info().SandboxKey; key != cData2.SandboxKey {
+		t.Fatalf("Expected endpoint info sandbox key %s, got %s", cData2.SandboxKey, key)
+	}
+}
+
+func TestEndpointLeaveOneOfMany(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	controller := libnetwork.New()
+
+	var (
+		eps   [2]libnetwork.Endpoint
+		infos [2]*libnetwork.JoinInfo
+		peers [2]net.Listener
+	)
+	for i := range eps {
+		parent := fmt.Sprintf("mvparent%d", i)
+		veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: parent}, PeerName: parent + "p"}
+		if err := netlink.LinkAdd(veth); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{parent, parent + "p"} {
+			link, err := netlink.LinkByName(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := netlink.LinkSetUp(link); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		gw, subnet, _ := net.ParseCIDR(fmt.Sprintf("192.168.%d.1/24", 201+i))
+		network, err := controller.NewNetwork("macvlan", fmt.Sprintf("testnetwork%d", i), options.Generic{
+			"Parent":  parent,
+			"Subnet":  subnet,
+			"Gateway": gw})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if eps[i], err = network.CreateEndpoint("ep", nil); err != nil {
+			t.Fatal(err)
+		}
+		if infos[i], err = eps[i].JoinWithInfo(containerID, libnetwork.JoinOptionHostname("multihost")); err != nil {
+			t.Fatal(err)
+		}
+		defer eps[i].Leave(containerID)
+
+		// A peer of the container on each network
+		peerEp, err := network.CreateEndpoint("peer", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		peerID := fmt.Sprintf("peer_container%d", i)
+		cData, err := peerEp.Join(peerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer peerEp.Leave(peerID)
+
+		peerIP := peerEp.Info().Interfaces[0].Address.IP
+		if err := sandbox.Invoke(cData.SandboxKey, func() error {
+			peers[i], err = net.Listen("tcp", net.JoinHostPort(peerIP.String(), "0"))
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		defer peers[i].Close()
+	}
+
+	probe := func(ep libnetwork.Endpoint, peer net.Listener) {
+		r, err := ep.Probe(peer.Addr().String(), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.Success {
+			t.Fatalf("Failed to reach the peer %s: %s", r.Address, r.Error)
+		}
+	}
+	probe(eps[0], peers[0])
+	probe(eps[1], peers[1])
+
+	hosts, err := ioutil.ReadFile(infos[1].HostsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leftAddr := infos[0].Interfaces[0].Address.IP.String()
+	if !strings.Contains(string(hosts), leftAddr) {
+		t.Fatalf("Address %s missing from the hosts file:\n%s", leftAddr, hosts)
+	}
+
+	// The first endpoint provides the default route, handed over on leave
+	if err := eps[0].Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+
+	key := infos[1].SandboxKey
+	if _, err := os.Stat(key); err != nil {
+		t.Fatalf("Sandbox removed along with one of its endpoints: %v", err)
+	}
+
+	var (
+		links  []netlink.Link
+		routes []netlink.Route
+	)
+	if err := sandbox.Invoke(key, func() error {
+		var err error
+		if links, err = netlink.LinkList(); err != nil {
+			return err
+		}
+		routes, err = netlink.RouteList(nil, netlink.FAMILY_V4)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	kept := infos[1].Interfaces[0].DstName
+	var names []string
+	for _, l := range links {
+		names = append(names, l.Attrs().Name)
+	}
+	if len(names) != 2 || (names[0] != kept && names[1] != kept) {
+		t.Fatalf("Expected the loopback and %s left in the sandbox, got %v", kept, names)
+	}
+
+	var defaults []netlink.Route
+	for _, r := range routes {
+		if r.Dst == nil {
+			defaults = append(defaults, r)
+		}
+		if r.Dst != nil && r.Dst.Contains(net.ParseIP("192.168.201.1")) {
+			t.Fatalf("Route %v of the left endpoint kept in the sandbox", r)
+		}
+	}
+	if gw := net.ParseIP("192.168.202.1"); len(defaults) != 1 || !defaults[0].Gw.Equal(gw) {
+		t.Fatalf("Expected the default route through %v, got %v", gw, defaults)
+	}
+
+	if hosts, err = ioutil.ReadFile(infos[1].HostsPath); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(hosts), leftAddr) {
+		t.Fatalf("Address %s of the left endpoint kept in the hosts file:\n%s", leftAddr, hosts)
+	}
+
+	probe(eps[1], peers[1])
+	if err := eps[0].Leave(containerID); err != libnetwork.ErrNoContainer {
+		t.Fatalf("Expected %v leaving again, got %v", libnetwork.ErrNoContainer, err)
+	}
+}
+
+func TestControllerSandboxRoot(t *testing.T) {
+	var (
+		keys [2]string
+		eps  [2]libnetwork.Endpoint
+		gcs  [2]libnetwork.NetworkController
+	)
+	for i := range keys {
+		root, err := ioutil.TempDir("", "sandboxroot")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(root)
+
+		controller := libnetwork.New(libnetwork.ControllerOptionSandboxRoot(root))
+		network, err := controller.NewNetwork("null", "testnetwork", options.Generic{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ep, err := network.CreateEndpoint("testep", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Both controllers join the same container
+		cData, err := ep.Join(containerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ep.Leave(containerID)
+
+		if key := sandbox.GenerateKeyIn(root, containerID); cData.SandboxKey != key {
+			t.Fatalf("Expected sandbox key %s, got %s", key, cData.SandboxKey)
+		}
+		keys[i], eps[i], gcs[i] = cData.SandboxKey, ep, controller
+	}
+
+	if keys[0] == keys[1] {
+		t.Fatalf("Controllers with distinct roots share the sandbox %s", keys[0])
+	}
+
+	// GC of one controller ignores the sandboxes of the other one
+	for i, c := range gcs {
+		orphans, err := c.GC(func(string) bool { return false }, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(orphans) != 0 {
+			t.Fatalf("Controller %d reported orphans: %v", i, orphans)
+		}
+	}
+
+	if err := eps[0].Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(keys[0]); !os.IsNotExist(err) {
+		t.Fatalf("Sandbox %s not removed on leave: %v", keys[0], err)
+	}
+	if _, err := os.Stat(keys[1]); err != nil {
+		t.Fatalf("Sandbox %s of the other controller removed: %v", keys[1], err)
+	}
+}
+
+func TestEndpointConcurrentCreateDelete(t *testing.T) {
+	network, err := createTestNetwork("null", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const attempts = 10
+	errCh := make(chan error, attempts)
+	start := make(chan struct{})
+	for i := 0; i < attempts; i++ {
+		go func(i int) {
+			<-start
+			ep, err := network.CreateEndpoint(fmt.Sprintf("ep%d", i), nil)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			network.Endpoints()
+			ep.Info()
+			errCh <- ep.Delete()
+		}(i)
+	}
+	close(start)
+
+	for i := 0; i < attempts; i++ {
+		if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if eps := network.Endpoints(); len(eps) != 0 {
+		t.Fatalf("Expected no endpoints left, got %d", len(eps))
+	}
+}
+
+func TestNetworkDeleteConcurrentEndpoints(t *testing.T) {
+	network, err := createTestNetwork("null", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const attempts = 10
+	errCh := make(chan error, attempts)
+	start := make(chan struct{})
+	for i := 0; i < attempts; i++ {
+		go func(i int) {
+			<-start
+			ep, err := network.CreateEndpoint(fmt.Sprintf("ep%d", i), nil)
+			if err != nil {
+				if _, ok := err.(*libnetwork.UnknownNetworkError); ok {
+					err = nil
+				}
+				errCh <- err
+				return
+			}
+			errCh <- ep.Delete()
+		}(i)
+	}
+	close(start)
+
+	// The network deletion must fail while endpoints reference it, and
+	// the endpoint creations must fail once it succeeded.
+	deleted := false
+	for !deleted {
+		err := network.Delete()
+		switch err.(type) {
+		case nil:
+			deleted = true
+		case *libnetwork.ActiveEndpointsError:
+			runtime.Gosched()
+		default:
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < attempts; i++ {
+		if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if cnt := network.EndpointCount(); cnt != 0 {
+		t.Fatalf("Expected no endpoints left, got %d", cnt)
+	}
+
+	if _, err := network.CreateEndpoint("late", nil); err == nil {
+		t.Fatal("Expected endpoint creation on a deleted network to fail")
+	} else if _, ok := err.(*libnetwork.UnknownNetworkError); !ok {
+		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	}
+}
+
+func TestEndpointConcurrentJoin(t *testing.T) {
+	network, err := createTestNetwork("null", "testnetwork", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := network.CreateEndpoint("testep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const attempts = 10
+	errCh := make(chan error, attempts)
+	start := make(chan struct{})
+	for i := 0; i < attempts; i++ {
+		go func() {
+			<-start
+			_, err := ep.Join(containerID)
+			errCh <- err
+		}()
+	}
+	close(start)
+
+	joined := 0
+	for i := 0; i < attempts; i++ {
+		err := <-errCh
+		if err == nil {
+			joined++
+			continue
+		}
+		if err != libnetwork.ErrEndpointInUse {
+			t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+		}
+	}
+
+	if joined != 1 {
+		t.Fatalf("Expected exactly one join to succeed, got %d", joined)
+	}
+
+	if err := ep.Leave(containerID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The endpoints of a container join and leave while the other one reads
+	// their settings to build the container files
+	ep2, err := network.CreateEndpoint("testep2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < attempts; i++ {
+		var wg sync.WaitGroup
+		for _, e := range []libnetwork.Endpoint{ep, ep2} {
+			wg.Add(1)
+			go func(e libnetwork.Endpoint) {
+				defer wg.Done()
+				if _, err := e.Join(containerID, libnetwork.JoinOptionHostname("test"), libnetwork.JoinOptionDNS("10.0.0.1")); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := e.Leave(containerID); err != nil {
+					t.Error(err)
+				}
+			}(e)
+		}
+		wg.Wait()
+	}
+
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep2.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestControllerMarshalJSON(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	controller := libnetwork.New()
+
+	ip, subnet, err := net.ParseCIDR("192.168.247.1/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subnet.IP = ip
+
+	option := options.Generic{
+		"BridgeName":            bridgeName,
+		"AddressIPv4":           subnet,
+		"AllowNonDefaultBridge": true}
+	if err := controller.ConfigureNetworkDriver(netType, option); err != nil {
+		t.Fatal(err)
+	}
+
+	labels := map[string]string{"tier": "frontend"}
+	network, err := controller.NewNetwork(netType, "testnetwork", "", libnetwork.NetworkOptionLabels(labels))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := network.CreateEndpoint("testep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(controller)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var list []*libnetwork.NetworkResource
+	if err := json.Unmarshal(b, &list); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 1 {
+		t.Fatalf("Expected one network, got %d", len(list))
+	}
+	nres := list[0]
+	if nres.ID != network.ID() || nres.Name != "testnetwork" || nres.Type != netType {
+		t.Fatalf("Unexpected network %s %s of type %s", nres.ID, nres.Name, nres.Type)
+	}
+	if len(nres.Subnets) != 1 || nres.Subnets[0] != "192.168.247.0/24" || nres.Gateway != "192.168.247.1" {
+		t.Fatalf("Unexpected network subnets %v and gateway %s", nres.Subnets, nres.Gateway)
+	}
+	if nres.Labels["tier"] != "frontend" {
+		t.Fatalf("Unexpected network labels %v", nres.Labels)
+	}
+
+	if len(nres.Endpoints) != 1 {
+		t.Fatalf("Expected one endpoint, got %d", len(nres.Endpoints))
+	}
+	eres := nres.Endpoints[0]
+	if eres.ID != ep.ID() || eres.Name != "testep" || eres.Network != "testnetwork" {
+		t.Fatalf("Unexpected endpoint %s %s in network %s", eres.ID, eres.Name, eres.Network)
+	}
+	if len(eres.Interfaces) != 1 || eres.Interfaces[0].Address != ep.Info().Interfaces[0].Address.String() {
+		t.Fatalf("Unexpected endpoint interfaces %v", eres.Interfaces)
+	}
+
+	// The decoded resources are the ones built from the network
+	if !reflect.DeepEqual(nres, libnetwork.NewNetworkResource(network)) {
+		t.Fatalf("Network resource does not round trip: %s", b)
+	}
+
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := network.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestControllerStop(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	controller := libnetwork.New()
+	if err := controller.ConfigureNetworkDriver("bridge", options.Generic{}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := controller.NewNetwork("bridge", "testnetwork", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := controller.NewNetwork("null", "testnull", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := n.CreateEndpoint("ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cData, err := ep.Join(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is released while a network has endpoints
+	if err := controller.Stop(); err == nil {
+		t.Fatal("Expected to fail stopping the controller with active endpoints")
+	} else if _, ok := err.(*libnetwork.ActiveEndpointsError); !ok {
+		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	}
+	if len(controller.Networks()) != 2 || ep.ContainerID() != containerID {
+		t.Fatal("Unexpected cleanup on a failed stop")
+	}
+
+	if err := controller.ForceStop(); err != nil {
+		t.Fatalf("Failed to force the controller stop: %v", err)
+	}
+
+	if len(controller.Networks()) != 0 {
+		t.Fatalf("Expected no network after stop, got %d", len(controller.Networks()))
+	}
+	if _, err := os.Stat(cData.SandboxKey); !os.IsNotExist(err) {
+		t.Fatalf("Sandbox %s not destroyed: %v", cData.SandboxKey, err)
+	}
+
+	if err := controller.Stop(); err != libnetwork.ErrControllerStopped {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrControllerStopped, err)
+	}
+	if _, err := controller.NewNetwork("null", "testnull", ""); err != libnetwork.ErrControllerStopped {
+		t.Fatalf("Expected %v, got %v", libnetwork.ErrControllerStopped, err)
+	}
 }
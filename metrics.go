@@ -0,0 +1,104 @@
+package libnetwork
+
+import (
+	"time"
+
+	"github.com/docker/libnetwork/sandbox"
+)
+
+// The metrics the controller updates, named after the Prometheus
+// conventions.
+const (
+	// MetricNetworksCreated counts the networks created.
+	MetricNetworksCreated = "libnetwork_networks_created_total"
+	// MetricNetworksDeleted counts the networks deleted.
+	MetricNetworksDeleted = "libnetwork_networks_deleted_total"
+	// MetricEndpointsCreated counts the endpoints created.
+	MetricEndpointsCreated = "libnetwork_endpoints_created_total"
+	// MetricEndpointsDeleted counts the endpoints deleted.
+	MetricEndpointsDeleted = "libnetwork_endpoints_deleted_total"
+	// MetricSandboxes gauges the sandboxes joined by an endpoint at least.
+	MetricSandboxes = "libnetwork_sandboxes"
+	// MetricAllocatedIPs gauges the addresses of the endpoints, of either
+	// family.
+	MetricAllocatedIPs = "libnetwork_allocated_ips"
+	// MetricDriverOperationSeconds is the histogram of the latency of the
+	// driver operations, labeled with the network type of the driver and
+	// the operation.
+	MetricDriverOperationSeconds = "libnetwork_driver_operation_seconds"
+)
+
+// The operations of the MetricDriverOperationSeconds operation label.
+const (
+	driverCreateNetwork  = "create_network"
+	driverDeleteNetwork  = "delete_network"
+	driverCreateEndpoint = "create_endpoint"
+	driverDeleteEndpoint = "delete_endpoint"
+	driverJoin           = "join"
+	driverLeave          = "leave"
+)
+
+// MetricLabels are the label values of an observation, indexed by the label
+// name.
+type MetricLabels map[string]string
+
+// Metrics collects the metrics of a controller, such as an adapter
+// registering Prometheus collectors. The controller updates the metrics
+// without holding its lock, the collector must tolerate concurrent updates.
+type Metrics interface {
+	// IncCounter adds one to the counter name.
+	IncCounter(name string)
+	// AddGauge adds delta to the gauge name, a negative delta decreasing it.
+	AddGauge(name string, delta float64)
+	// Observe records value in the histogram name, under the labels.
+	Observe(name string, labels MetricLabels, value float64)
+}
+
+// NullMetrics is the Metrics of the controllers created without
+// ControllerOptionMetrics, discarding the updates.
+type NullMetrics struct{}
+
+// IncCounter does nothing.
+func (NullMetrics) IncCounter(name string) {}
+
+// AddGauge does nothing.
+func (NullMetrics) AddGauge(name string, delta float64) {}
+
+// Observe does nothing.
+func (NullMetrics) Observe(name string, labels MetricLabels, value float64) {}
+
+// ControllerOptionMetrics function returns an option setter for the
+// collector of the controller metrics.
+func ControllerOptionMetrics(metrics Metrics) ControllerOption {
+	return func(c *controller) {
+		c.metrics = metrics
+	}
+}
+
+// observeDriver records the latency of the driver operation op of the
+// networkType driver, started at start.
+func (c *controller) observeDriver(networkType, op string, start time.Time) {
+	labels := MetricLabels{"driver": networkType, "operation": op}
+	c.metrics.Observe(MetricDriverOperationSeconds, labels, time.Since(start).Seconds())
+}
+
+// addAllocatedIPs adds the addresses of the interfaces of sinfo, negated
+// when released, to the allocated addresses gauge.
+func (c *controller) addAllocatedIPs(sinfo *sandbox.Info, released bool) {
+	if sinfo == nil {
+		return
+	}
+
+	count := 0
+	for _, i := range sinfo.Interfaces {
+		count += len(i.Addresses())
+	}
+	if count == 0 {
+		return
+	}
+
+	if released {
+		count = -count
+	}
+	c.metrics.AddGauge(MetricAllocatedIPs, float64(count))
+}
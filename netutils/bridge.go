@@ -0,0 +1,131 @@
+package netutils
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// The netlink package neither reports nor sets the attributes of the bridge
+// devices: the requests are built here instead.
+
+// Attributes of the IFLA_INFO_DATA of the bridge devices
+const (
+	iflaBrForwardDelay = 1
+	iflaBrAgeingTime   = 4
+	iflaBrStpState     = 5
+)
+
+// clockTick is the unit of the bridge timers, the kernel USER_HZ.
+const clockTick = 10 * time.Millisecond
+
+// BridgeOptions are the kernel tunables of a bridge device.
+type BridgeOptions struct {
+	// STP tells whether the spanning tree protocol is enabled.
+	STP bool
+	// ForwardDelay is the time the ports spend in the listening and
+	// learning states when STP is enabled.
+	ForwardDelay time.Duration
+	// AgingTime is the time the learnt MAC addresses are kept for.
+	AgingTime time.Duration
+}
+
+// GetBridgeOptions returns the tunables of the bridge device.
+func GetBridgeOptions(name string) (*BridgeOptions, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	req := nl.NewNetlinkRequest(syscall.RTM_GETLINK, syscall.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(link.Attrs().Index)
+	req.AddData(msg)
+
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWLINK)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) != 1 {
+		return nil, syscall.ENODEV
+	}
+
+	attrs, err := nl.ParseRouteAttr(msgs[0][msg.Len():])
+	if err != nil {
+		return nil, err
+	}
+	for _, attr := range attrs {
+		if attr.Attr.Type != syscall.IFLA_LINKINFO {
+			continue
+		}
+		infos, err := nl.ParseRouteAttr(attr.Value)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if info.Attr.Type != nl.IFLA_INFO_DATA {
+				continue
+			}
+			data, err := nl.ParseRouteAttr(info.Value)
+			if err != nil {
+				return nil, err
+			}
+			return parseBridgeData(data), nil
+		}
+	}
+
+	// Not a bridge, or a kernel not reporting the bridge attributes
+	return nil, syscall.EOPNOTSUPP
+}
+
+func parseBridgeData(data []syscall.NetlinkRouteAttr) *BridgeOptions {
+	native := nl.NativeEndian()
+	opts := &BridgeOptions{}
+	for _, datum := range data {
+		if len(datum.Value) < 4 {
+			continue
+		}
+		v := native.Uint32(datum.Value[0:4])
+		switch datum.Attr.Type {
+		case iflaBrForwardDelay:
+			opts.ForwardDelay = time.Duration(v) * clockTick
+		case iflaBrAgeingTime:
+			opts.AgingTime = time.Duration(v) * clockTick
+		case iflaBrStpState:
+			opts.STP = v != 0
+		}
+	}
+	return opts
+}
+
+// SetBridgeOptions applies the tunables to the bridge device.
+func SetBridgeOptions(name string, opts *BridgeOptions) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+
+	req := nl.NewNetlinkRequest(syscall.RTM_NEWLINK, syscall.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(link.Attrs().Index)
+	req.AddData(msg)
+
+	var stp uint32
+	if opts.STP {
+		stp = 1
+	}
+	// The kernel applies the forward delay before the STP state, which
+	// lets both change in one request
+	linkInfo := nl.NewRtAttr(syscall.IFLA_LINKINFO, nil)
+	nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_KIND, nl.NonZeroTerminated("bridge"))
+	data := nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_DATA, nil)
+	nl.NewRtAttrChild(data, iflaBrForwardDelay, nl.Uint32Attr(uint32(opts.ForwardDelay/clockTick)))
+	nl.NewRtAttrChild(data, iflaBrAgeingTime, nl.Uint32Attr(uint32(opts.AgingTime/clockTick)))
+	nl.NewRtAttrChild(data, iflaBrStpState, nl.Uint32Attr(stp))
+	req.AddData(linkInfo)
+
+	_, err = req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
@@ -0,0 +1,46 @@
+package netutils
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// capNetAdmin is the bit of the CAP_NET_ADMIN capability in the capability
+// sets of the process.
+const capNetAdmin = 12
+
+// HasNetAdmin tells whether the process holds the CAP_NET_ADMIN capability
+// in its effective set, which programming the network stack of the host
+// requires. It reports false when the capabilities can not be read.
+func HasNetAdmin() bool {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	caps, err := effectiveCaps(f)
+	if err != nil {
+		return false
+	}
+	return caps&(1<<capNetAdmin) != 0
+}
+
+// effectiveCaps returns the effective capability set the CapEff line of the
+// passed process status reports.
+func effectiveCaps(status io.Reader) (uint64, error) {
+	s := bufio.NewScanner(status)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 2 && fields[0] == "CapEff:" {
+			return strconv.ParseUint(fields[1], 16, 64)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	return 0, io.ErrUnexpectedEOF
+}
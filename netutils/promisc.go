@@ -0,0 +1,55 @@
+package netutils
+
+import (
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// The netlink package reports the interface flags as net.Flags, which do not
+// carry the promiscuous mode, and has no setter for it: the requests are
+// built here instead.
+
+// Promisc tells whether the interface is in promiscuous mode.
+func Promisc(name string) (bool, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return false, err
+	}
+
+	req := nl.NewNetlinkRequest(syscall.RTM_GETLINK, syscall.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(link.Attrs().Index)
+	req.AddData(msg)
+
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWLINK)
+	if err != nil {
+		return false, err
+	}
+	if len(msgs) != 1 {
+		return false, syscall.ENODEV
+	}
+
+	return nl.DeserializeIfInfomsg(msgs[0]).Flags&syscall.IFF_PROMISC != 0, nil
+}
+
+// SetPromisc puts the interface in promiscuous mode, or out of it.
+func SetPromisc(name string, on bool) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+
+	req := nl.NewNetlinkRequest(syscall.RTM_NEWLINK, syscall.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(link.Attrs().Index)
+	msg.Change = syscall.IFF_PROMISC
+	if on {
+		msg.Flags = syscall.IFF_PROMISC
+	}
+	req.AddData(msg)
+
+	_, err = req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
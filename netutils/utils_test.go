@@ -3,7 +3,9 @@ package netutils
 import (
 	"bytes"
 	"net"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/vishvananda/netlink"
 )
@@ -210,6 +212,21 @@ func TestUtilGenerateRandomMAC(t *testing.T) {
 	}
 }
 
+func TestUtilGenerateMACFromIP(t *testing.T) {
+	ip := net.ParseIP("172.17.0.3")
+	mac := GenerateMACFromIP(ip)
+	if expected := "02:42:ac:11:00:03"; mac.String() != expected {
+		t.Fatalf("Expected mac %s, got %s", expected, mac)
+	}
+	// ensure generation is deterministic
+	if !bytes.Equal(mac, GenerateMACFromIP(ip)) {
+		t.Fatalf("mac %s should be derived identically from %s", mac, ip)
+	}
+	if bytes.Equal(mac, GenerateMACFromIP(net.ParseIP("172.17.0.4"))) {
+		t.Fatalf("mac %s should differ for different addresses", mac)
+	}
+}
+
 func TestCompareIPNet(t *testing.T) {
 	if CompareIPNet(nil, nil) == false {
 		t.Fatalf("Failed to detect two nil net.IPNets are equal")
@@ -261,3 +278,80 @@ func TestNetIPCopyFunctions(t *testing.T) {
 		t.Fatalf("Failed to return a true copy of net.IPNet")
 	}
 }
+
+func TestPromisc(t *testing.T) {
+	defer SetupTestNetNS(t)()
+
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth0"}, PeerName: "veth1"}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, on := range []bool{false, true, false} {
+		if on {
+			if err := SetPromisc("veth0", true); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := SetPromisc("veth0", false); err != nil {
+			t.Fatal(err)
+		}
+		promisc, err := Promisc("veth0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if promisc != on {
+			t.Fatalf("Expected the promiscuous mode %t, got %t", on, promisc)
+		}
+	}
+
+	if _, err := Promisc("missing0"); err == nil {
+		t.Fatal("Expected an error for a missing interface")
+	}
+}
+
+func TestBridgeOptions(t *testing.T) {
+	defer SetupTestNetNS(t)()
+
+	br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0"}}
+	if err := netlink.LinkAdd(br); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []BridgeOptions{
+		{STP: true, ForwardDelay: 5 * time.Second, AgingTime: 120 * time.Second},
+		{STP: false, ForwardDelay: 15 * time.Second, AgingTime: 300 * time.Second},
+	} {
+		if err := SetBridgeOptions("br0", &expected); err != nil {
+			t.Fatal(err)
+		}
+		opts, err := GetBridgeOptions("br0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *opts != expected {
+			t.Fatalf("Expected bridge options %+v, got %+v", expected, *opts)
+		}
+	}
+
+	if _, err := GetBridgeOptions("missing0"); err == nil {
+		t.Fatal("Expected an error for a missing interface")
+	}
+}
+
+func TestEffectiveCaps(t *testing.T) {
+	status := "Name:\ttest\nCapInh:\t0000000000000000\nCapPrm:\t0000003fffffffff\nCapEff:\t0000000000001000\n"
+	caps, err := effectiveCaps(strings.NewReader(status))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps != 1<<capNetAdmin {
+		t.Fatalf("Expected the CAP_NET_ADMIN capability only, got %x", caps)
+	}
+
+	if _, err := effectiveCaps(strings.NewReader("Name:\ttest\n")); err == nil {
+		t.Fatal("Expected an error for a status without capabilities")
+	}
+	if _, err := effectiveCaps(strings.NewReader("CapEff:\tnothex\n")); err == nil {
+		t.Fatal("Expected an error for a malformed capability set")
+	}
+}
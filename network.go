@@ -1,10 +1,19 @@
 package libnetwork
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
 	"sync"
+	"time"
 
-	"github.com/docker/docker/pkg/stringid"
+	log "github.com/Sirupsen/logrus"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/pkg/options"
+	"github.com/docker/libnetwork/sandbox"
 	"github.com/docker/libnetwork/types"
 )
 
@@ -20,31 +29,143 @@ type Network interface {
 	// The type of network, which corresponds to its managing driver.
 	Type() string
 
+	// Labels returns a copy of the labels attached to this network.
+	Labels() map[string]string
+
+	// Info returns a snapshot of the network settings.
+	Info() NetworkInfo
+
+	// SetGenericData stores value under key in the generic data of the
+	// network, an opaque slot persisted along with it where the
+	// applications and the drivers keep external metadata. The value must
+	// encode to JSON, a nil one removes the key. The generic data is left
+	// out of the NetworkResource unless ResourceOptionGenericData is passed.
+	SetGenericData(key string, value interface{}) error
+
+	// GenericData decodes the value stored under key into v, as
+	// json.Unmarshal does, the same before and after a restart. It returns
+	// GenericDataNotFoundError if the key holds no value.
+	GenericData(key string, v interface{}) error
+
+	// AddressCount returns the number of IPv4 addresses the driver of the
+	// network can allocate to its gateway and endpoints, along with the
+	// number of them in use, the reserved addresses included. Once all are
+	// used, CreateEndpoint fails with ErrNoAvailableIPs. For the drivers not
+	// counting their addresses, the count covers the subnets of the network,
+	// the network and broadcast addresses excluded, and the addresses of the
+	// gateway and the endpoints.
+	AddressCount() (total, used int)
+
 	// Create a new endpoint to this network symbolically identified by the
 	// specified unique name. The options parameter carry driver specific options.
-	// Labels support will be added in the near future.
-	CreateEndpoint(name string, options interface{}) (Endpoint, error)
-
-	// Delete the network.
+	// Labels support will be added in the near future. The name follows the
+	// same rules as the network names. Driver independent settings such as
+	// aliases are passed as EndpointOption(s). The aliases follow the name
+	// rules too, and must not be known as a name or alias of another
+	// endpoint of the network, else EndpointNameError is returned.
+	//
+	// The default endpoint options of the network, if any, are merged into
+	// the options, the options set here winning over the defaults. A nil
+	// options gets the defaults alone, while options which are not an
+	// options.Generic are rejected with EndpointOptionsTypeError as they can
+	// not be merged. Options requesting a feature the network driver does
+	// not support, such as a static address or port bindings, are rejected
+	// with UnsupportedFeatureError.
+	CreateEndpoint(name string, options interface{}, epOptions ...EndpointOption) (Endpoint, error)
+
+	// CreateEndpointWithContext creates a new endpoint as CreateEndpoint
+	// does, giving up once the passed context is done. The partially created
+	// endpoint is then removed and the context error returned.
+	CreateEndpointWithContext(ctx context.Context, name string, options interface{}, epOptions ...EndpointOption) (Endpoint, error)
+
+	// CreateEndpoints creates the endpoints the specs describe, as
+	// CreateEndpoint would one by one, returning them in the same order.
+	// The specs are all validated before any endpoint is created, and the
+	// endpoints are added to the network at once. Either all the endpoints
+	// are created, or none is: on failure the ones already set up by the
+	// driver, with their addresses, are removed and a *CreateEndpointsError
+	// reports the failed specs.
+	CreateEndpoints(specs []EndpointSpec) ([]Endpoint, error)
+
+	// ValidateEndpointConfig checks that CreateEndpoint would accept the
+	// driver specific options, without allocating nor setting up anything.
+	ValidateEndpointConfig(options interface{}) error
+
+	// SetOptions applies driver specific options to the existing network,
+	// in the form its driver accepts. Options the driver can not change once
+	// the network is created are rejected with a
+	// driverapi.ImmutableOptionError.
+	SetOptions(options interface{}) error
+
+	// Delete the network. It returns ActiveEndpointsError while the network
+	// has endpoints, the ones being created included, and the endpoint
+	// creations started once the deletion is fail with UnknownNetworkError.
 	Delete() error
 
 	// Endpoints returns the list of Endpoint(s) in this network.
 	Endpoints() []Endpoint
 
+	// EndpointCount returns the number of endpoints of the network, counting
+	// the ones being created or deleted, which prevent its deletion.
+	EndpointCount() int
+
 	// WalkEndpoints uses the provided function to walk the Endpoints
 	WalkEndpoints(walker EndpointWalker)
 
-	// EndpointByName returns the Endpoint which has the passed name, if it exists otherwise nil is returned
+	// EndpointByName returns the Endpoint which has the passed name or alias, if it exists otherwise nil is returned
 	EndpointByName(name string) Endpoint
 
 	// EndpointByID returns the Endpoint which has the passed id, if it exists otherwise nil is returned
 	EndpointByID(id string) Endpoint
 }
 
+// NetworkInfo represents the settings of a network, as returned by
+// Network.Info. It is a copy which later changes to the network do not
+// affect.
+type NetworkInfo struct {
+	// Type of the network.
+	Type string
+
+	// Driver is the type reported by the driver managing the network.
+	Driver string
+
+	// Subnets the endpoints addresses are allocated from.
+	Subnets []*net.IPNet
+
+	// IPv4 gateway of the network.
+	Gateway net.IP
+
+	// IPv6 gateway of the network.
+	GatewayIPv6 net.IP
+
+	// Labels attached to the network.
+	Labels map[string]string
+}
+
+// EndpointSpec describes an endpoint to create with Network.CreateEndpoints,
+// by the arguments CreateEndpoint takes.
+type EndpointSpec struct {
+	Name            string
+	Options         interface{}
+	EndpointOptions []EndpointOption
+}
+
 // EndpointWalker is a client provided function which will be used to walk the Endpoints.
 // When the function returns true, the walk will stop.
 type EndpointWalker func(ep Endpoint) bool
 
+// NetworkOption is a option setter function type used to pass various options to
+// the controller NewNetwork method. The various setter functions of type NetworkOption
+// are provided by libnetwork, they look like NetworkOption[...](...)
+type NetworkOption func(n *network)
+
+const (
+	networkKeyPrefix = "network"
+	maxNameLength    = 255
+)
+
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
 type network struct {
 	ctrlr       *controller
 	name        string
@@ -52,9 +173,60 @@ type network struct {
 	id          types.UUID
 	driver      driverapi.Driver
 	endpoints   endpointTable
+	labels      map[string]string
+	// whether the sandboxes joined to the network resolve the names of
+	// its endpoints through the embedded DNS resolver
+	embeddedDNS bool
+	// driver options the endpoints are created with unless they set them
+	endpointDefaults options.Generic
+	// endpointCnt counts the endpoints referencing the network, from the
+	// start of their creation to the end of their deletion
+	endpointCnt int
+	// deleted is set once the deletion of the network starts
+	deleted bool
+	// generic is the opaque data kept with the network
+	generic genericData
+	// restore tells whether the driver took back the network restored
+	// from the store
+	restore restoreState
 	sync.Mutex
 }
 
+// restoreState is the state of a network restored from the store with
+// respect to its driver.
+type restoreState int
+
+const (
+	// restoreDone is the state of the networks the driver created or took
+	// back.
+	restoreDone restoreState = iota
+	// restorePending is the state of the networks whose driver takes them
+	// back once configured.
+	restorePending
+	// restoreRunning is the state of the networks the driver is taking
+	// back.
+	restoreRunning
+	// restoreFailed is the state of the networks the driver failed to take
+	// back: their deletion, and the ones of their endpoints, only remove
+	// their records.
+	restoreFailed
+)
+
+// driverKnows tells whether the driver knows the network, failing with
+// ErrDriverNotConfigured while the driver has yet to take it back.
+func (n *network) driverKnows() (bool, error) {
+	n.Lock()
+	defer n.Unlock()
+
+	switch n.restore {
+	case restorePending, restoreRunning:
+		return false, ErrDriverNotConfigured
+	case restoreFailed:
+		return false, nil
+	}
+	return true, nil
+}
+
 func (n *network) Name() string {
 	return n.name
 }
@@ -63,12 +235,179 @@ func (n *network) ID() string {
 	return string(n.id)
 }
 
+// Type returns the network type the network was created with, which the
+// driver may not know when it was registered under a custom network type.
 func (n *network) Type() string {
+	return n.networkType
+}
+
+func (n *network) Key() []string {
+	return []string{networkKeyPrefix, string(n.id)}
+}
+
+// networkRecord is the store record of a network. It is kept apart from the
+// network so the JSON encoding of a network handle does not expose it.
+type networkRecord struct {
+	Name             string                     `json:"name"`
+	ID               string                     `json:"id"`
+	NetworkType      string                     `json:"networkType"`
+	Labels           map[string]string          `json:"labels"`
+	EmbeddedDNS      bool                       `json:"embeddedDNS"`
+	EndpointDefaults options.Generic            `json:"endpointDefaults,omitempty"`
+	GenericData      map[string]json.RawMessage `json:"genericData,omitempty"`
+}
+
+func (n *network) Value() []byte {
+	b, err := json.Marshal(&networkRecord{
+		Name:             n.name,
+		ID:               string(n.id),
+		NetworkType:      n.networkType,
+		Labels:           n.labels,
+		EmbeddedDNS:      n.embeddedDNS,
+		EndpointDefaults: n.endpointDefaults,
+		GenericData:      n.generic.snapshot(),
+	})
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// setValue restores the network from its store record.
+func (n *network) setValue(b []byte) error {
+	var r networkRecord
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+	n.name = r.Name
+	n.id = types.UUID(r.ID)
+	n.networkType = r.NetworkType
+	n.labels = r.Labels
+	n.embeddedDNS = r.EmbeddedDNS
+	n.endpointDefaults = r.EndpointDefaults
+	n.generic.data = r.GenericData
+	return nil
+}
+
+func (n *network) Labels() map[string]string {
+	n.Lock()
+	defer n.Unlock()
+
+	labels := make(map[string]string, len(n.labels))
+	for k, v := range n.labels {
+		labels[k] = v
+	}
+
+	return labels
+}
+
+func (n *network) Info() NetworkInfo {
+	info := NetworkInfo{Type: n.Type(), Labels: n.Labels()}
 	if n.driver == nil {
-		return ""
+		return info
 	}
+	info.Driver = n.driver.Type()
 
-	return n.driver.Type()
+	dinfo, err := n.driver.NetworkInfo(n.id)
+	if err != nil {
+		log.Warnf("Failed to get the driver settings of network %s: %v", n.id, err)
+		return info
+	}
+
+	for _, s := range dinfo.Subnets {
+		info.Subnets = append(info.Subnets, netutils.GetIPNetCopy(s))
+	}
+	if dinfo.Gateway != nil {
+		info.Gateway = netutils.GetIPCopy(dinfo.Gateway)
+	}
+	if dinfo.GatewayIPv6 != nil {
+		info.GatewayIPv6 = netutils.GetIPCopy(dinfo.GatewayIPv6)
+	}
+
+	return info
+}
+
+func (n *network) AddressCount() (total, used int) {
+	if n.driver != nil {
+		if dinfo, err := n.driver.NetworkInfo(n.id); err == nil && dinfo.IPv4Addresses != nil {
+			return dinfo.IPv4Addresses.Total, dinfo.IPv4Addresses.Used
+		}
+	}
+
+	// Estimated from the subnets for the drivers not counting the
+	// addresses they allocate
+	info := n.Info()
+
+	var subnets []*net.IPNet
+	for _, s := range info.Subnets {
+		if s.IP.To4() == nil {
+			continue
+		}
+		ones, bits := s.Mask.Size()
+		if hosts := 1<<uint(bits-ones) - 2; hosts > 0 {
+			total += hosts
+		}
+		subnets = append(subnets, s)
+	}
+
+	inSubnets := func(ip net.IP) bool {
+		for _, s := range subnets {
+			if s.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if info.Gateway != nil && inSubnets(info.Gateway) {
+		used++
+	}
+	for _, ep := range n.Endpoints() {
+		sinfo := ep.SandboxInfo()
+		if sinfo == nil {
+			continue
+		}
+		for _, i := range sinfo.Interfaces {
+			if i.Address != nil && inSubnets(i.Address.IP) {
+				used++
+			}
+		}
+	}
+
+	return total, used
+}
+
+func (n *network) SetOptions(options interface{}) error {
+	n.ctrlr.Lock()
+	_, ok := n.ctrlr.networks[n.id]
+	n.ctrlr.Unlock()
+	if !ok {
+		return &UnknownNetworkError{name: n.name, id: string(n.id)}
+	}
+
+	if err := n.driver.UpdateNetwork(n.id, options); err != nil {
+		return err
+	}
+
+	n.ctrlr.events.publish(EventNetworkUpdate, string(n.id))
+
+	return nil
+}
+
+func (n *network) ValidateEndpointConfig(options interface{}) error {
+	n.ctrlr.Lock()
+	_, ok := n.ctrlr.networks[n.id]
+	n.ctrlr.Unlock()
+	if !ok {
+		return &UnknownNetworkError{name: n.name, id: string(n.id)}
+	}
+
+	options, err := n.endpointOptions(options)
+	if err != nil {
+		return err
+	}
+
+	return n.driver.ValidateEndpoint(n.id, options)
 }
 
 func (n *network) Delete() error {
@@ -81,8 +420,17 @@ func (n *network) Delete() error {
 		return &UnknownNetworkError{name: n.name, id: string(n.id)}
 	}
 
+	known, err := n.driverKnows()
+	if err != nil {
+		n.ctrlr.Unlock()
+		return err
+	}
+
 	n.Lock()
-	numEps := len(n.endpoints)
+	numEps := n.endpointCnt
+	if numEps == 0 {
+		n.deleted = true
+	}
 	n.Unlock()
 	if numEps != 0 {
 		n.ctrlr.Unlock()
@@ -96,28 +444,298 @@ func (n *network) Delete() error {
 			n.ctrlr.Lock()
 			n.ctrlr.networks[n.id] = n
 			n.ctrlr.Unlock()
+			n.Lock()
+			n.deleted = false
+			n.Unlock()
 		}
 	}()
 
-	err = n.driver.DeleteNetwork(n.id)
-	return err
+	if known {
+		start := time.Now()
+		err = n.driver.DeleteNetwork(n.id)
+		n.ctrlr.observeDriver(n.networkType, driverDeleteNetwork, start)
+		if err != nil {
+			return err
+		}
+	} else {
+		log.Warnf("Deleting network %s unknown to its driver, its resources are left behind", n.id)
+	}
+
+	if e := n.ctrlr.store.DeleteObject(n); e != nil {
+		log.Warnf("Failed to remove network %s from the store: %v", n.id, e)
+	}
+
+	n.ctrlr.events.publish(EventNetworkDelete, string(n.id))
+	n.ctrlr.metrics.IncCounter(MetricNetworksDeleted)
+
+	return nil
 }
 
-func (n *network) CreateEndpoint(name string, options interface{}) (Endpoint, error) {
-	ep := &endpoint{name: name}
-	ep.id = types.UUID(stringid.GenerateRandomID())
-	ep.network = n
+func (n *network) CreateEndpoint(name string, options interface{}, epOptions ...EndpointOption) (Endpoint, error) {
+	return n.CreateEndpointWithContext(context.Background(), name, options, epOptions...)
+}
+
+func (n *network) CreateEndpointWithContext(ctx context.Context, name string, options interface{}, epOptions ...EndpointOption) (Endpoint, error) {
+	options, err := n.endpointOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := n.driverKnows(); err != nil {
+		return nil, err
+	}
+
+	ep, err := n.newEndpoint(name, epOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fail early on names already in use, they are checked again once the
+	// endpoint is created
+	n.Lock()
+	err = n.checkEndpointNames(ep)
+	n.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// The endpoint holds the network from now on, unless its creation fails
+	if err := n.acquireEndpoints(1); err != nil {
+		return nil, err
+	}
+	created := false
+	defer func() {
+		if !created {
+			n.releaseEndpoints(1)
+		}
+	}()
+
+	id, err := n.ctrlr.newID()
+	if err != nil {
+		return nil, err
+	}
+	defer n.ctrlr.releaseID(id)
+	ep.id = id
 
 	d := n.driver
-	sinfo, err := d.CreateEndpoint(n.id, ep.id, options)
+	sinfo, err := n.createDriverEndpoint(ctx, ep.id, options)
 	if err != nil {
 		return nil, err
 	}
 
+	// The driver may have completed right as the context got done
+	if err := ctx.Err(); err != nil {
+		if e := d.DeleteEndpoint(n.id, ep.id); e != nil {
+			log.Warnf("Failed to remove endpoint %s after cancellation: %v", ep.id, e)
+		}
+		return nil, err
+	}
+
 	ep.sandboxInfo = sinfo
+
+	// Persist the endpoint so that it can be restored after a restart
+	if err := n.ctrlr.store.PutObject(ep); err != nil {
+		if e := d.DeleteEndpoint(n.id, ep.id); e != nil {
+			log.Warnf("Failed to remove endpoint %s after store failure: %v", ep.id, e)
+		}
+		return nil, err
+	}
+
 	n.Lock()
+	if err := n.checkEndpointNames(ep); err != nil {
+		n.Unlock()
+		if e := n.ctrlr.store.DeleteObject(ep); e != nil {
+			log.Warnf("Failed to remove endpoint %s from the store: %v", ep.id, e)
+		}
+		if e := d.DeleteEndpoint(n.id, ep.id); e != nil {
+			log.Warnf("Failed to remove endpoint %s after name conflict: %v", ep.id, e)
+		}
+		return nil, err
+	}
 	n.endpoints[ep.id] = ep
 	n.Unlock()
+	created = true
+
+	n.ctrlr.events.publish(EventEndpointCreate, string(ep.id))
+	n.ctrlr.metrics.IncCounter(MetricEndpointsCreated)
+	n.ctrlr.addAllocatedIPs(ep.sandboxInfo, false)
+
+	return ep, nil
+}
+
+func (n *network) CreateEndpoints(specs []EndpointSpec) ([]Endpoint, error) {
+	eps := make([]*endpoint, len(specs))
+	opts := make([]interface{}, len(specs))
+	var errs []*EndpointSpecError
+	specError := func(i int, err error) {
+		errs = append(errs, &EndpointSpecError{Index: i, Name: specs[i].Name, Err: err})
+	}
+
+	// Validate all the specs, against the network endpoints and each other,
+	// before anything is allocated
+	n.Lock()
+	for i, s := range specs {
+		ep, err := n.newEndpoint(s.Name, s.EndpointOptions...)
+		if err == nil {
+			opts[i], err = n.endpointOptions(s.Options)
+		}
+		if err == nil {
+			err = n.checkEndpointNames(ep)
+		}
+		for _, prev := range eps[:i] {
+			if err != nil {
+				break
+			}
+			if prev != nil {
+				err = checkNames(prev, ep)
+			}
+		}
+		if err != nil {
+			specError(i, err)
+			continue
+		}
+		eps[i] = ep
+	}
+	n.Unlock()
+	if errs != nil {
+		return nil, &CreateEndpointsError{Errors: errs}
+	}
+
+	if err := n.acquireEndpoints(len(eps)); err != nil {
+		return nil, err
+	}
+	added := false
+	defer func() {
+		if !added {
+			n.releaseEndpoints(len(eps))
+		}
+	}()
+
+	for _, ep := range eps {
+		id, err := n.ctrlr.newID()
+		if err != nil {
+			return nil, err
+		}
+		defer n.ctrlr.releaseID(id)
+		ep.id = id
+	}
+
+	// created counts the endpoints set up by the driver, and stored the
+	// ones persisted, which are undone on failure
+	var created, stored int
+	rollback := func() {
+		for _, ep := range eps[:stored] {
+			if e := n.ctrlr.store.DeleteObject(ep); e != nil {
+				log.Warnf("Failed to remove endpoint %s from the store: %v", ep.id, e)
+			}
+		}
+		for _, ep := range eps[:created] {
+			if e := n.driver.DeleteEndpoint(n.id, ep.id); e != nil {
+				log.Warnf("Failed to remove endpoint %s on rollback: %v", ep.id, e)
+			}
+		}
+	}
+
+	for i, ep := range eps {
+		sinfo, err := n.createDriverEndpoint(context.Background(), ep.id, opts[i])
+		if err != nil {
+			rollback()
+			specError(i, err)
+			return nil, &CreateEndpointsError{Errors: errs}
+		}
+		ep.sandboxInfo = sinfo
+		created++
+	}
+
+	// Persist the endpoints so that they can be restored after a restart
+	for i, ep := range eps {
+		if err := n.ctrlr.store.PutObject(ep); err != nil {
+			rollback()
+			specError(i, err)
+			return nil, &CreateEndpointsError{Errors: errs}
+		}
+		stored++
+	}
+
+	// The names are checked again, against the endpoints created meanwhile
+	n.Lock()
+	for i, ep := range eps {
+		if err := n.checkEndpointNames(ep); err != nil {
+			specError(i, err)
+		}
+	}
+	if errs != nil {
+		n.Unlock()
+		rollback()
+		return nil, &CreateEndpointsError{Errors: errs}
+	}
+	for _, ep := range eps {
+		n.endpoints[ep.id] = ep
+	}
+	n.Unlock()
+	added = true
+
+	list := make([]Endpoint, 0, len(eps))
+	for _, ep := range eps {
+		n.ctrlr.events.publish(EventEndpointCreate, string(ep.id))
+		n.ctrlr.metrics.IncCounter(MetricEndpointsCreated)
+		n.ctrlr.addAllocatedIPs(ep.sandboxInfo, false)
+		list = append(list, ep)
+	}
+
+	return list, nil
+}
+
+// acquireEndpoints counts count more endpoints referencing the network. It
+// returns UnknownNetworkError once the network deletion started.
+func (n *network) acquireEndpoints(count int) error {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.deleted {
+		return &UnknownNetworkError{name: n.name, id: string(n.id)}
+	}
+	n.endpointCnt += count
+	return nil
+}
+
+// releaseEndpoints counts count less endpoints referencing the network.
+func (n *network) releaseEndpoints(count int) {
+	n.Lock()
+	n.endpointCnt -= count
+	n.Unlock()
+}
+
+func (n *network) EndpointCount() int {
+	n.Lock()
+	defer n.Unlock()
+	return n.endpointCnt
+}
+
+// newEndpoint returns the endpoint of the passed name and options, once its
+// name and aliases are checked valid.
+func (n *network) newEndpoint(name string, epOptions ...EndpointOption) (*endpoint, error) {
+	if !validName(name) {
+		return nil, InvalidNameError(name)
+	}
+
+	ep := &endpoint{name: name}
+	ep.network = n
+	for _, opt := range epOptions {
+		opt(ep)
+	}
+
+	for i, a := range ep.aliases {
+		if !validName(a) {
+			return nil, InvalidNameError(a)
+		}
+		for _, prev := range ep.aliases[:i] {
+			if a == prev {
+				return nil, EndpointNameError(a)
+			}
+		}
+	}
+
 	return ep, nil
 }
 
@@ -149,6 +767,12 @@ func (n *network) EndpointByName(name string) Endpoint {
 				e = current
 				return true
 			}
+			for _, a := range current.Aliases() {
+				if a == name {
+					e = current
+					return true
+				}
+			}
 			return false
 		}
 
@@ -158,6 +782,82 @@ func (n *network) EndpointByName(name string) Endpoint {
 	return e
 }
 
+// checkEndpointNames returns EndpointNameError if an alias of the endpoint is
+// a name or alias of an endpoint of the network, or its name is an alias.
+// Endpoint names alone can be shared. It must be called with the network
+// lock held.
+func (n *network) checkEndpointNames(ep *endpoint) error {
+	for _, e := range n.endpoints {
+		if err := checkNames(e, ep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createDriverEndpoint has the driver create the endpoint eid, retrying the
+// transient failures as the controller retry policy allows.
+func (n *network) createDriverEndpoint(ctx context.Context, eid types.UUID, options interface{}) (*sandbox.Info, error) {
+	var sinfo *sandbox.Info
+	err := n.ctrlr.retryPolicy.run(ctx, "endpoint creation", func() error {
+		var err error
+		start := time.Now()
+		sinfo, err = n.driver.CreateEndpointWithContext(ctx, n.id, eid, options)
+		n.ctrlr.observeDriver(n.networkType, driverCreateEndpoint, start)
+		return err
+	})
+	return sinfo, err
+}
+
+// endpointOptions returns the driver options of an endpoint created with
+// opts, merged with the default endpoint options of the network. The keys
+// set in opts win, and the merge is a new map so that neither is modified.
+// The merged options are checked against the driver capabilities.
+func (n *network) endpointOptions(opts interface{}) (interface{}, error) {
+	if len(n.endpointDefaults) == 0 {
+		if err := checkFeatures(n.driver, endpointFeatures, opts); err != nil {
+			return nil, err
+		}
+		return opts, nil
+	}
+
+	var generic options.Generic
+	if opts != nil {
+		var ok bool
+		if generic, ok = opts.(options.Generic); !ok {
+			return nil, EndpointOptionsTypeError(fmt.Sprintf("%T", opts))
+		}
+	}
+
+	merged := make(options.Generic, len(n.endpointDefaults)+len(generic))
+	for k, v := range n.endpointDefaults {
+		merged[k] = v
+	}
+	for k, v := range generic {
+		merged[k] = v
+	}
+	if err := checkFeatures(n.driver, endpointFeatures, merged); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// checkNames returns EndpointNameError if an alias of ep is a name or alias
+// of e, or the name of ep is an alias of e.
+func checkNames(e, ep *endpoint) error {
+	for _, a := range e.aliases {
+		if a == ep.name {
+			return EndpointNameError(a)
+		}
+	}
+	for _, a := range ep.aliases {
+		if e.hasName(a) {
+			return EndpointNameError(a)
+		}
+	}
+	return nil
+}
+
 func (n *network) EndpointByID(id string) Endpoint {
 	n.Lock()
 	defer n.Unlock()
@@ -166,3 +866,52 @@ func (n *network) EndpointByID(id string) Endpoint {
 	}
 	return nil
 }
+
+// NetworkOptionLabels function returns an option setter for the labels to be
+// attached to the network being created.
+func NetworkOptionLabels(labels map[string]string) NetworkOption {
+	return func(n *network) {
+		n.labels = make(map[string]string, len(labels))
+		for k, v := range labels {
+			n.labels[k] = v
+		}
+	}
+}
+
+// NetworkOptionEndpointDefaults function returns an option setter for the
+// driver options the endpoints of the network being created get by default,
+// such as their MTU. They are merged into the options each endpoint is
+// created with, as documented on Network.CreateEndpoint. The defaults are
+// persisted with the network: once restored, their values are the ones
+// decoded from JSON, which the drivers convert as any generic option.
+func NetworkOptionEndpointDefaults(defaults options.Generic) NetworkOption {
+	return func(n *network) {
+		n.endpointDefaults = make(options.Generic, len(defaults))
+		for k, v := range defaults {
+			n.endpointDefaults[k] = v
+		}
+	}
+}
+
+// NetworkOptionEmbeddedDNS function returns an option setter making the
+// containers joining the network being created resolve the names and aliases
+// of its joined endpoints through an embedded DNS resolver. The resolver
+// answers in the container network namespace at resolver.Address, which is
+// set as the only nameserver of the container resolv.conf, and forwards the
+// other queries to the nameservers the resolv.conf would list otherwise.
+func NetworkOptionEmbeddedDNS() NetworkOption {
+	return func(n *network) {
+		n.embeddedDNS = true
+	}
+}
+
+func (n *network) processOptions(options ...NetworkOption) {
+	for _, opt := range options {
+		opt(n)
+	}
+}
+
+// validName checks name is usable as a network or endpoint name.
+func validName(name string) bool {
+	return len(name) <= maxNameLength && nameRegexp.MatchString(name)
+}
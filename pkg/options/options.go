@@ -3,7 +3,9 @@
 package options
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
 	"reflect"
 )
 
@@ -18,6 +20,9 @@ func (e NoSuchFieldError) Error() string {
 	return fmt.Sprintf("no field %q in type %q", e.Field, e.Type)
 }
 
+// InvalidParameter denotes the type of this error
+func (e NoSuchFieldError) InvalidParameter() {}
+
 // CannotSetFieldError is the error returned when the generic parameters hold a
 // value for a field that cannot be set in the destination structure.
 type CannotSetFieldError struct {
@@ -29,6 +34,24 @@ func (e CannotSetFieldError) Error() string {
 	return fmt.Sprintf("cannot set field %q of type %q", e.Field, e.Type)
 }
 
+// InvalidParameter denotes the type of this error
+func (e CannotSetFieldError) InvalidParameter() {}
+
+// InvalidValueError is the error returned when the generic parameters hold a
+// value for a field which can not be converted to the field type.
+type InvalidValueError struct {
+	Field string
+	Type  string
+	Value string
+}
+
+func (e InvalidValueError) Error() string {
+	return fmt.Sprintf("invalid value %s for field %q of type %q", e.Value, e.Field, e.Type)
+}
+
+// InvalidParameter denotes the type of this error
+func (e InvalidValueError) InvalidParameter() {}
+
 // Generic is an basic type to store arbitrary settings.
 type Generic map[string]interface{}
 
@@ -62,7 +85,9 @@ func GenerateFromModel(options Generic, model interface{}) (interface{}, error)
 		if !field.CanSet() {
 			return nil, CannotSetFieldError{name, resType.String()}
 		}
-		field.Set(reflect.ValueOf(value))
+		if err := setField(field, name, value); err != nil {
+			return nil, err
+		}
 	}
 
 	// If the model is not of pointer type, return content of the result.
@@ -71,3 +96,101 @@ func GenerateFromModel(options Generic, model interface{}) (interface{}, error)
 	}
 	return res.Elem().Interface(), nil
 }
+
+// TypeMismatchError is the error returned when the options to update a model
+// with are neither generic parameters nor a value of the model type.
+type TypeMismatchError struct {
+	Options string
+	Type    string
+}
+
+func (e TypeMismatchError) Error() string {
+	return fmt.Sprintf("cannot update type %q from %q", e.Type, e.Options)
+}
+
+// InvalidParameter denotes the type of this error
+func (e TypeMismatchError) InvalidParameter() {}
+
+// UpdateModel takes a pointer to a model structure and returns an updated
+// copy of it, along with the names of the fields whose value changed. The
+// options are either generic parameters setting the matching fields, or a
+// pointer to a structure of the model type replacing the model as a whole.
+// The model itself is left untouched.
+func UpdateModel(options interface{}, model interface{}) (interface{}, []string, error) {
+	cur := reflect.ValueOf(model).Elem()
+	res := reflect.New(cur.Type())
+	res.Elem().Set(cur)
+
+	switch opt := options.(type) {
+	case nil:
+	case Generic:
+		for name, value := range opt {
+			field := res.Elem().FieldByName(name)
+			if !field.IsValid() {
+				return nil, nil, NoSuchFieldError{name, cur.Type().String()}
+			}
+			if !field.CanSet() {
+				return nil, nil, CannotSetFieldError{name, cur.Type().String()}
+			}
+			if err := setField(field, name, value); err != nil {
+				return nil, nil, err
+			}
+		}
+	default:
+		upd := reflect.ValueOf(options)
+		if upd.Type() != res.Type() {
+			return nil, nil, TypeMismatchError{upd.Type().String(), res.Type().String()}
+		}
+		res.Elem().Set(upd.Elem())
+	}
+
+	var changed []string
+	for i := 0; i < cur.NumField(); i++ {
+		if !reflect.DeepEqual(cur.Field(i).Interface(), res.Elem().Field(i).Interface()) {
+			changed = append(changed, cur.Type().Field(i).Name)
+		}
+	}
+
+	return res.Interface(), changed, nil
+}
+
+var ipNetType = reflect.TypeOf(&net.IPNet{})
+
+// setField sets field to value. A value of another type, such as decoded from
+// a JSON document, is converted through its JSON encoding, a CIDR string
+// setting a *net.IPNet field to the address and mask it denotes. A nil value
+// resets the field.
+func setField(field reflect.Value, name string, value interface{}) error {
+	if value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Type().AssignableTo(field.Type()) {
+		field.Set(v)
+		return nil
+	}
+
+	invalid := InvalidValueError{name, field.Type().String(), fmt.Sprintf("%v", value)}
+	if s, ok := value.(string); ok && field.Type() == ipNetType {
+		ip, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return invalid
+		}
+		ipNet.IP = ip
+		field.Set(reflect.ValueOf(ipNet))
+		return nil
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return invalid
+	}
+	res := reflect.New(field.Type())
+	if err := json.Unmarshal(b, res.Interface()); err != nil {
+		return invalid
+	}
+	field.Set(res.Elem())
+	return nil
+}
@@ -1,6 +1,7 @@
 package options
 
 import (
+	"net"
 	"reflect"
 	"strings"
 	"testing"
@@ -93,3 +94,78 @@ func TestFieldCannotBeSet(t *testing.T) {
 		t.Fatalf("expected %q in error message, got %s", expected, err.Error())
 	}
 }
+
+func TestGenerateConvert(t *testing.T) {
+	type Model struct {
+		Int    int
+		IP     net.IP
+		IPNet  *net.IPNet
+		Labels map[string]string
+	}
+
+	// The values as decoded from a JSON document
+	gen := Generic{
+		"Int":    float64(1500),
+		"IP":     "10.0.0.1",
+		"IPNet":  "192.168.1.1/24",
+		"Labels": map[string]interface{}{"foo": "bar"},
+	}
+	result, err := GenerateFromModel(gen, &Model{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cast := result.(*Model)
+	if cast.Int != 1500 || cast.IP.String() != "10.0.0.1" || cast.Labels["foo"] != "bar" {
+		t.Fatalf("unexpected generated model %v", cast)
+	}
+	if cast.IPNet.String() != "192.168.1.1/24" {
+		t.Fatalf("expected address 192.168.1.1/24, got %v", cast.IPNet)
+	}
+
+	for name, value := range map[string]interface{}{"Int": "foo", "IPNet": "192.168.1.1"} {
+		_, err := GenerateFromModel(Generic{name: value}, &Model{})
+		if _, ok := err.(InvalidValueError); !ok {
+			t.Fatalf("expected InvalidValueError for %s, got %#v", name, err)
+		}
+	}
+}
+
+func TestUpdateModel(t *testing.T) {
+	type Model struct {
+		Int    int
+		String string
+		Slice  []int
+	}
+	model := &Model{Int: 1, String: "foo", Slice: []int{1}}
+
+	result, changed, err := UpdateModel(Generic{"Int": 2, "String": "foo"}, model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cast := result.(*Model); cast.Int != 2 || cast.String != "foo" || len(cast.Slice) != 1 {
+		t.Fatalf("unexpected updated model %v", cast)
+	}
+	if !reflect.DeepEqual(changed, []string{"Int"}) {
+		t.Fatalf("expected changed fields [Int], got %v", changed)
+	}
+	if model.Int != 1 {
+		t.Fatal("model modified by the update")
+	}
+
+	_, changed, err = UpdateModel(&Model{Int: 1, String: "bar"}, model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changed, []string{"String", "Slice"}) {
+		t.Fatalf("expected changed fields [String Slice], got %v", changed)
+	}
+
+	if _, _, err := UpdateModel(Generic{"foo": "bar"}, model); err == nil {
+		t.Fatal("expected NoSuchFieldError, got nil")
+	}
+	if _, _, err := UpdateModel(Model{}, model); err == nil {
+		t.Fatal("expected TypeMismatchError, got nil")
+	} else if _, ok := err.(TypeMismatchError); !ok {
+		t.Fatalf("expected TypeMismatchError, got %#v", err)
+	}
+}
@@ -25,10 +25,13 @@ var (
 	ErrAllPortsAllocated = errors.New("all ports are allocated")
 	// ErrUnknownProtocol is returned when an unknown protocol was specified
 	ErrUnknownProtocol = errors.New("unknown protocol")
-	defaultIP          = net.ParseIP("0.0.0.0")
-	once               sync.Once
-	instance           *PortAllocator
-	createInstance     = func() { instance = newInstance() }
+	// ErrInvalidPortRange is returned when a port range is not within
+	// 1-65535 or ends before it begins
+	ErrInvalidPortRange = errors.New("invalid port range")
+	defaultIP           = net.ParseIP("0.0.0.0")
+	once                sync.Once
+	instance            *PortAllocator
+	createInstance      = func() { instance = newInstance() }
 )
 
 // ErrPortAlreadyAllocated is the returned error information when a requested port is already being used
@@ -64,6 +67,9 @@ func (e ErrPortAlreadyAllocated) Error() string {
 	return fmt.Sprintf("Bind for %s:%d failed: port is already allocated", e.ip, e.port)
 }
 
+// Forbidden denotes the type of this error
+func (e ErrPortAlreadyAllocated) Forbidden() {}
+
 type (
 	// PortAllocator manages the transport ports database
 	PortAllocator struct {
@@ -161,6 +167,29 @@ func (p *PortAllocator) RequestPort(ip net.IP, proto string, port int) (int, err
 	return port, nil
 }
 
+// SetPortRange changes the range the ports are picked from when none is
+// requested. The ports already allocated are kept, including the ones out of
+// the new range.
+func (p *PortAllocator) SetPortRange(begin, end int) error {
+	if begin < 1 || end > 65535 || begin > end {
+		return ErrInvalidPortRange
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.Begin, p.End = begin, end
+	for _, protomap := range p.ipMap {
+		for _, pm := range protomap {
+			pm.begin, pm.end = begin, end
+			if pm.last < begin || pm.last > end {
+				pm.last = end
+			}
+		}
+	}
+	return nil
+}
+
 // ReleasePort releases port from global ports pool for specified ip and proto.
 func (p *PortAllocator) ReleasePort(ip net.IP, proto string, port int) error {
 	p.mutex.Lock()
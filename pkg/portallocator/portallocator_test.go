@@ -153,6 +153,34 @@ func TestAllocateAllPorts(t *testing.T) {
 	}
 }
 
+func TestSetPortRange(t *testing.T) {
+	p := New()
+	defer resetPortAllocator()
+
+	if _, err := p.RequestPort(defaultIP, "tcp", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range [][2]int{{0, 10}, {10, 65536}, {20, 10}} {
+		if err := p.SetPortRange(r[0], r[1]); err != ErrInvalidPortRange {
+			t.Fatalf("Expected %v for range %v, got %v", ErrInvalidPortRange, r, err)
+		}
+	}
+
+	// The new range applies to the protocols already in use too
+	if err := p.SetPortRange(60000, 60001); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []int{60000, 60001} {
+		if port, err := p.RequestPort(defaultIP, "tcp", 0); err != nil || port != expected {
+			t.Fatalf("Expected port %d, got %d: %v", expected, port, err)
+		}
+	}
+	if _, err := p.RequestPort(defaultIP, "tcp", 0); err != ErrAllPortsAllocated {
+		t.Fatalf("Expected error %s got %s", ErrAllPortsAllocated, err)
+	}
+}
+
 func BenchmarkAllocatePorts(b *testing.B) {
 	p := New()
 	defer resetPortAllocator()
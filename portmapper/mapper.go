@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/Sirupsen/logrus"
@@ -16,6 +18,7 @@ type mapping struct {
 	userlandProxy userlandProxy
 	host          net.Addr
 	container     net.Addr
+	tag           string
 }
 
 var newProxy = newProxyCommand
@@ -58,8 +61,16 @@ func (pm *PortMapper) SetIptablesChain(c *iptables.Chain) {
 	pm.chain = c
 }
 
-// Map maps the specified container transport address to the host's network address and transport port
-func (pm *PortMapper) Map(container net.Addr, hostIP net.IP, hostPort int) (host net.Addr, err error) {
+// Map maps the specified container transport address to the host's network address and transport port.
+// With useProxy set, a userland proxy forwards the connections the iptables rules miss, such as the
+// ones to the loopback address. Otherwise the host port is only bound, so that no other process takes it.
+func (pm *PortMapper) Map(container net.Addr, hostIP net.IP, hostPort int, useProxy bool) (host net.Addr, err error) {
+	return pm.MapTagged(container, hostIP, hostPort, useProxy, "")
+}
+
+// MapTagged maps the container address as Map does, tagging the DNAT and ACCEPT rules of the chain
+// with the given comment, so that PruneStale can tell them apart once the mapping is gone.
+func (pm *PortMapper) MapTagged(container net.Addr, hostIP net.IP, hostPort int, useProxy bool, tag string) (host net.Addr, err error) {
 	pm.lock.Lock()
 	defer pm.lock.Unlock()
 
@@ -81,9 +92,14 @@ func (pm *PortMapper) Map(container net.Addr, hostIP net.IP, hostPort int) (host
 			proto:     proto,
 			host:      &net.TCPAddr{IP: hostIP, Port: allocatedHostPort},
 			container: container,
+			tag:       tag,
 		}
 
-		proxy = newProxy(proto, hostIP, allocatedHostPort, container.(*net.TCPAddr).IP, container.(*net.TCPAddr).Port)
+		if useProxy {
+			proxy = newProxy(proto, hostIP, allocatedHostPort, container.(*net.TCPAddr).IP, container.(*net.TCPAddr).Port)
+		} else {
+			proxy = newDummyProxy(proto, hostIP, allocatedHostPort)
+		}
 	case *net.UDPAddr:
 		proto = "udp"
 		if allocatedHostPort, err = pm.Allocator.RequestPort(hostIP, proto, hostPort); err != nil {
@@ -94,9 +110,14 @@ func (pm *PortMapper) Map(container net.Addr, hostIP net.IP, hostPort int) (host
 			proto:     proto,
 			host:      &net.UDPAddr{IP: hostIP, Port: allocatedHostPort},
 			container: container,
+			tag:       tag,
 		}
 
-		proxy = newProxy(proto, hostIP, allocatedHostPort, container.(*net.UDPAddr).IP, container.(*net.UDPAddr).Port)
+		if useProxy {
+			proxy = newProxy(proto, hostIP, allocatedHostPort, container.(*net.UDPAddr).IP, container.(*net.UDPAddr).Port)
+		} else {
+			proxy = newDummyProxy(proto, hostIP, allocatedHostPort)
+		}
 	default:
 		return nil, ErrUnknownBackendAddressType
 	}
@@ -114,14 +135,14 @@ func (pm *PortMapper) Map(container net.Addr, hostIP net.IP, hostPort int) (host
 	}
 
 	containerIP, containerPort := getIPAndPort(m.container)
-	if err := pm.forward(iptables.Append, m.proto, hostIP, allocatedHostPort, containerIP.String(), containerPort); err != nil {
+	if err := pm.forward(iptables.Append, m.proto, hostIP, allocatedHostPort, containerIP.String(), containerPort, m.tag); err != nil {
 		return nil, err
 	}
 
 	cleanup := func() error {
 		// need to undo the iptables rules before we return
 		proxy.Stop()
-		pm.forward(iptables.Delete, m.proto, hostIP, allocatedHostPort, containerIP.String(), containerPort)
+		pm.forward(iptables.Delete, m.proto, hostIP, allocatedHostPort, containerIP.String(), containerPort, m.tag)
 		if err := pm.Allocator.ReleasePort(hostIP, m.proto, allocatedHostPort); err != nil {
 			return err
 		}
@@ -157,7 +178,7 @@ func (pm *PortMapper) Unmap(host net.Addr) error {
 
 	containerIP, containerPort := getIPAndPort(data.container)
 	hostIP, hostPort := getIPAndPort(data.host)
-	if err := pm.forward(iptables.Delete, data.proto, hostIP, hostPort, containerIP.String(), containerPort); err != nil {
+	if err := pm.forward(iptables.Delete, data.proto, hostIP, hostPort, containerIP.String(), containerPort, data.tag); err != nil {
 		logrus.Errorf("Error on iptables delete: %s", err)
 	}
 
@@ -170,6 +191,21 @@ func (pm *PortMapper) Unmap(host net.Addr) error {
 	return nil
 }
 
+// MappedTo returns the host transport addresses mapped to a container
+// address within network.
+func (pm *PortMapper) MappedTo(network *net.IPNet) []net.Addr {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	var hosts []net.Addr
+	for _, m := range pm.currentMappings {
+		if ip, _ := getIPAndPort(m.container); ip != nil && network.Contains(ip) {
+			hosts = append(hosts, m.host)
+		}
+	}
+	return hosts
+}
+
 func getKey(a net.Addr) string {
 	switch t := a.(type) {
 	case *net.TCPAddr:
@@ -190,9 +226,118 @@ func getIPAndPort(a net.Addr) (net.IP, int) {
 	return nil, 0
 }
 
-func (pm *PortMapper) forward(action iptables.Action, proto string, sourceIP net.IP, sourcePort int, containerIP string, containerPort int) error {
+// PruneStale deletes the tagged rules of the chain whose tag is not the one of a current mapping,
+// as left behind by an instance which did not shut down cleanly. The untagged rules are kept.
+func (pm *PortMapper) PruneStale() error {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	if pm.chain == nil {
+		return nil
+	}
+
+	live := make(map[string]bool)
+	for _, m := range pm.currentMappings {
+		if m.tag != "" {
+			live[m.tag] = true
+		}
+	}
+
+	for _, table := range []iptables.Table{iptables.Nat, iptables.Filter} {
+		output, err := iptables.Raw("-t", string(table), "-S", pm.chain.Name)
+		if err != nil {
+			return err
+		}
+		for _, args := range staleRules(output, live) {
+			if output, err := iptables.Raw(append([]string{"-t", string(table), string(iptables.Delete)}, args...)...); err != nil {
+				return err
+			} else if len(output) != 0 {
+				return &iptables.ChainError{Chain: pm.chain.Name, Output: output}
+			}
+		}
+	}
+
+	return nil
+}
+
+// staleRules returns the rules of an iptables -S listing tagged with a
+// comment not in live, without their append action.
+func staleRules(listing []byte, live map[string]bool) [][]string {
+	var rules [][]string
+	for _, line := range strings.Split(string(listing), "\n") {
+		args := strings.Fields(line)
+		if len(args) < 2 || args[0] != string(iptables.Append) {
+			continue
+		}
+		for i := 1; i < len(args)-1; i++ {
+			if args[i] == "--comment" {
+				if tag := strings.Trim(args[i+1], `"`); !live[tag] {
+					rules = append(rules, args[1:])
+				}
+				break
+			}
+		}
+	}
+	return rules
+}
+
+// rule is an iptables rule, without its action.
+type rule struct {
+	table iptables.Table
+	args  []string
+}
+
+// forwardRules returns the table and arguments of each rule forwarding the
+// host port to the container port, as iptables.Chain.Forward programs them.
+func forwardRules(chain *iptables.Chain, proto string, sourceIP net.IP, sourcePort int, containerIP string, containerPort int, tag string) []rule {
+	daddr := sourceIP.String()
+	if sourceIP.IsUnspecified() {
+		// iptables interprets "0.0.0.0" as "0.0.0.0/32", whereas we
+		// want "0.0.0.0/0".
+		daddr = "0/0"
+	}
+
+	var comment []string
+	if tag != "" {
+		comment = []string{"-m", "comment", "--comment", tag}
+	}
+
+	dnat := append([]string{chain.Name,
+		"-p", proto,
+		"-d", daddr,
+		"--dport", strconv.Itoa(sourcePort),
+		"!", "-i", chain.Bridge},
+		comment...)
+	accept := append([]string{chain.Name,
+		"!", "-i", chain.Bridge,
+		"-o", chain.Bridge,
+		"-p", proto,
+		"-d", containerIP,
+		"--dport", strconv.Itoa(containerPort)},
+		comment...)
+
+	return []rule{
+		{iptables.Nat, append(dnat, "-j", "DNAT", "--to-destination", net.JoinHostPort(containerIP, strconv.Itoa(containerPort)))},
+		{iptables.Filter, append(accept, "-j", "ACCEPT")},
+		{iptables.Nat, []string{"POSTROUTING",
+			"-p", proto,
+			"-s", containerIP,
+			"-d", containerIP,
+			"--dport", strconv.Itoa(containerPort),
+			"-j", "MASQUERADE"}},
+	}
+}
+
+func (pm *PortMapper) forward(action iptables.Action, proto string, sourceIP net.IP, sourcePort int, containerIP string, containerPort int, tag string) error {
 	if pm.chain == nil {
 		return nil
 	}
-	return pm.chain.Forward(action, sourceIP, sourcePort, proto, containerIP, containerPort)
+	for _, r := range forwardRules(pm.chain, proto, sourceIP, sourcePort, containerIP, containerPort, tag) {
+		if output, err := iptables.Raw(append([]string{"-t", string(r.table), string(action)}, r.args...)...); err != nil {
+			return err
+		} else if len(output) != 0 {
+			return &iptables.ChainError{Chain: "FORWARD", Output: output}
+		}
+	}
+	return nil
 }
@@ -1,7 +1,9 @@
 package portmapper
 
 import (
+	"io"
 	"net"
+	"strings"
 	"testing"
 
 	"github.com/docker/docker/pkg/iptables"
@@ -44,22 +46,22 @@ func TestMapPorts(t *testing.T) {
 		return (addr1.Network() == addr2.Network()) && (addr1.String() == addr2.String())
 	}
 
-	if host, err := pm.Map(srcAddr1, dstIP1, 80); err != nil {
+	if host, err := pm.Map(srcAddr1, dstIP1, 80, true); err != nil {
 		t.Fatalf("Failed to allocate port: %s", err)
 	} else if !addrEqual(dstAddr1, host) {
 		t.Fatalf("Incorrect mapping result: expected %s:%s, got %s:%s",
 			dstAddr1.String(), dstAddr1.Network(), host.String(), host.Network())
 	}
 
-	if _, err := pm.Map(srcAddr1, dstIP1, 80); err == nil {
+	if _, err := pm.Map(srcAddr1, dstIP1, 80, true); err == nil {
 		t.Fatalf("Port is in use - mapping should have failed")
 	}
 
-	if _, err := pm.Map(srcAddr2, dstIP1, 80); err == nil {
+	if _, err := pm.Map(srcAddr2, dstIP1, 80, true); err == nil {
 		t.Fatalf("Port is in use - mapping should have failed")
 	}
 
-	if _, err := pm.Map(srcAddr2, dstIP2, 80); err != nil {
+	if _, err := pm.Map(srcAddr2, dstIP2, 80, true); err != nil {
 		t.Fatalf("Failed to allocate port: %s", err)
 	}
 
@@ -76,6 +78,74 @@ func TestMapPorts(t *testing.T) {
 	}
 }
 
+func TestMappedTo(t *testing.T) {
+	pm := New()
+	srcAddr1 := &net.TCPAddr{Port: 1080, IP: net.ParseIP("172.16.0.1")}
+	srcAddr2 := &net.UDPAddr{Port: 53, IP: net.ParseIP("172.17.0.1")}
+	_, network, _ := net.ParseCIDR("172.16.0.0/16")
+
+	host, err := pm.Map(srcAddr1, nil, 8080, true)
+	if err != nil {
+		t.Fatalf("Failed to allocate port: %s", err)
+	}
+	other, err := pm.Map(srcAddr2, nil, 5353, true)
+	if err != nil {
+		t.Fatalf("Failed to allocate port: %s", err)
+	}
+	defer pm.Unmap(other)
+
+	if hosts := pm.MappedTo(network); len(hosts) != 1 || hosts[0].String() != host.String() {
+		t.Fatalf("Expected %s mapped to %v, got %v", host, network, hosts)
+	}
+
+	if err := pm.Unmap(host); err != nil {
+		t.Fatal(err)
+	}
+	if hosts := pm.MappedTo(network); len(hosts) != 0 {
+		t.Fatalf("Unexpected hosts mapped to %v: %v", network, hosts)
+	}
+}
+
+func TestForwardRulesTagged(t *testing.T) {
+	c := &iptables.Chain{Name: "TEST", Bridge: "br0"}
+
+	rules := forwardRules(c, "tcp", net.IPv4zero, 8080, "172.16.0.1", 80, "ep1")
+	if len(rules) != 3 {
+		t.Fatalf("Expected 3 rules, got %v", rules)
+	}
+	// The DNAT and ACCEPT rules of the chain carry the tag
+	for _, r := range rules[:2] {
+		if args := strings.Join(r.args, " "); !strings.Contains(args, "-m comment --comment ep1") {
+			t.Fatalf("Rule not tagged: %s", args)
+		}
+	}
+	if args := strings.Join(rules[2].args, " "); strings.Contains(args, "comment") {
+		t.Fatalf("Unexpected tag on the masquerading rule: %s", args)
+	}
+
+	for _, r := range forwardRules(c, "tcp", net.IPv4zero, 8080, "172.16.0.1", 80, "") {
+		if args := strings.Join(r.args, " "); strings.Contains(args, "comment") {
+			t.Fatalf("Unexpected tag on an untagged rule: %s", args)
+		}
+	}
+}
+
+func TestStaleRules(t *testing.T) {
+	listing := []byte(`-N TEST
+-A TEST -d 0/0 ! -i br0 -p tcp -m tcp --dport 8080 -j DNAT --to-destination 172.16.0.1:80
+-A TEST -d 0/0 ! -i br0 -p tcp -m tcp --dport 8081 -m comment --comment ep1 -j DNAT --to-destination 172.16.0.1:81
+-A TEST -d 0/0 ! -i br0 -p tcp -m tcp --dport 8082 -m comment --comment "ep2" -j DNAT --to-destination 172.16.0.2:82
+`)
+
+	rules := staleRules(listing, map[string]bool{"ep1": true})
+	if len(rules) != 1 {
+		t.Fatalf("Expected only the rule of ep2 to be stale, got %v", rules)
+	}
+	if rules[0][0] != "TEST" || !strings.Contains(strings.Join(rules[0], " "), "--dport 8082") {
+		t.Fatalf("Unexpected stale rule %v", rules[0])
+	}
+}
+
 func TestGetUDPKey(t *testing.T) {
 	addr := &net.UDPAddr{IP: net.ParseIP("192.168.1.5"), Port: 53}
 
@@ -127,14 +197,14 @@ func TestMapAllPortsSingleInterface(t *testing.T) {
 	for i := 0; i < 10; i++ {
 		start, end := pm.Allocator.Begin, pm.Allocator.End
 		for i := start; i < end; i++ {
-			if host, err = pm.Map(srcAddr1, dstIP1, 0); err != nil {
+			if host, err = pm.Map(srcAddr1, dstIP1, 0, true); err != nil {
 				t.Fatal(err)
 			}
 
 			hosts = append(hosts, host)
 		}
 
-		if _, err := pm.Map(srcAddr1, dstIP1, start); err == nil {
+		if _, err := pm.Map(srcAddr1, dstIP1, start, true); err == nil {
 			t.Fatalf("Port %d should be bound but is not", start)
 		}
 
@@ -147,3 +217,47 @@ func TestMapAllPortsSingleInterface(t *testing.T) {
 		hosts = []net.Addr{}
 	}
 }
+
+func TestMapPortsWithoutProxy(t *testing.T) {
+	pm := New()
+	hostIP := net.ParseIP("127.0.0.1")
+
+	for _, container := range []net.Addr{
+		&net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 80},
+		&net.UDPAddr{IP: net.ParseIP("172.16.0.1"), Port: 53},
+	} {
+		host, err := pm.Map(container, hostIP, 0, false)
+		if err != nil {
+			t.Fatalf("Failed to allocate port: %s", err)
+		}
+
+		// The host port is held, though nothing is forwarded
+		if err := listenOn(host); err == nil {
+			t.Fatalf("Host port %s is not bound", host)
+		}
+
+		if err := pm.Unmap(host); err != nil {
+			t.Fatalf("Failed to release port: %s", err)
+		}
+		if err := listenOn(host); err != nil {
+			t.Fatalf("Host port %s still bound after release: %s", host, err)
+		}
+	}
+}
+
+func listenOn(addr net.Addr) error {
+	var (
+		l   io.Closer
+		err error
+	)
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		l, err = net.ListenTCP("tcp", a)
+	case *net.UDPAddr:
+		l, err = net.ListenUDP("udp", a)
+	}
+	if err != nil {
+		return err
+	}
+	return l.Close()
+}
@@ -0,0 +1,123 @@
+// Package resolvconf provides utility code to query and build the resolv.conf
+// file of the containers.
+package resolvconf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// DefaultPath is the path of the host resolv.conf file
+var DefaultPath = "/etc/resolv.conf"
+
+// DefaultNameservers are used when none of the host nameservers is reachable
+// from the container network namespace
+var DefaultNameservers = []string{"8.8.8.8", "8.8.4.4"}
+
+var (
+	nsRegexp      = regexp.MustCompile(`^\s*nameserver\s*(\S+)\s*$`)
+	searchRegexp  = regexp.MustCompile(`^\s*search\s*(([^\s]+\s*)*)$`)
+	optionsRegexp = regexp.MustCompile(`^\s*options\s*(([^\s]+\s*)*)$`)
+)
+
+// Get returns the contents of the host resolv.conf file
+func Get() ([]byte, error) {
+	return ioutil.ReadFile(DefaultPath)
+}
+
+// getLines parses input into lines and strips away comments.
+func getLines(input []byte, commentMarker []byte) [][]byte {
+	lines := bytes.Split(input, []byte("\n"))
+	var output [][]byte
+	for _, currentLine := range lines {
+		var commentIndex = bytes.Index(currentLine, commentMarker)
+		if commentIndex == -1 {
+			output = append(output, currentLine)
+		} else {
+			output = append(output, currentLine[:commentIndex])
+		}
+	}
+	return output
+}
+
+// GetNameservers returns nameservers (if any) listed in resolvConf
+func GetNameservers(resolvConf []byte) []string {
+	nameservers := []string{}
+	for _, line := range getLines(resolvConf, []byte("#")) {
+		var ns = nsRegexp.FindSubmatch(line)
+		if len(ns) > 0 && net.ParseIP(string(ns[1])) != nil {
+			nameservers = append(nameservers, string(ns[1]))
+		}
+	}
+	return nameservers
+}
+
+// GetSearchDomains returns the search domains (if any) listed in resolvConf.
+// Per resolv.conf(5), only the last search line is taken into account.
+func GetSearchDomains(resolvConf []byte) []string {
+	domains := []string{}
+	for _, line := range getLines(resolvConf, []byte("#")) {
+		match := searchRegexp.FindSubmatch(line)
+		if match == nil {
+			continue
+		}
+		domains = strings.Fields(string(match[1]))
+	}
+	return domains
+}
+
+// GetOptions returns the options (if any) listed in resolvConf. Per
+// resolv.conf(5), only the last options line is taken into account.
+func GetOptions(resolvConf []byte) []string {
+	options := []string{}
+	for _, line := range getLines(resolvConf, []byte("#")) {
+		match := optionsRegexp.FindSubmatch(line)
+		if match == nil {
+			continue
+		}
+		options = strings.Fields(string(match[1]))
+	}
+	return options
+}
+
+// FilterLocalNameservers removes the loopback nameservers, which are not
+// reachable from a container network namespace. DefaultNameservers are
+// returned if no nameserver is left.
+func FilterLocalNameservers(nameservers []string) []string {
+	filtered := []string{}
+	for _, ns := range nameservers {
+		if ip := net.ParseIP(ns); ip != nil && !ip.IsLoopback() {
+			filtered = append(filtered, ns)
+		}
+	}
+	if len(filtered) == 0 {
+		filtered = append(filtered, DefaultNameservers...)
+	}
+	return filtered
+}
+
+// Build writes a resolv.conf file at path with the passed nameservers,
+// search domains and options
+func Build(path string, dns, dnsSearch, dnsOptions []string) error {
+	content := bytes.NewBuffer(nil)
+	for _, ns := range dns {
+		if _, err := content.WriteString("nameserver " + ns + "\n"); err != nil {
+			return err
+		}
+	}
+	if len(dnsSearch) > 0 {
+		if _, err := content.WriteString("search " + strings.Join(dnsSearch, " ") + "\n"); err != nil {
+			return err
+		}
+	}
+	if len(dnsOptions) > 0 {
+		if _, err := content.WriteString("options " + strings.Join(dnsOptions, " ") + "\n"); err != nil {
+			return err
+		}
+	}
+
+	return ioutil.WriteFile(path, content.Bytes(), 0644)
+}
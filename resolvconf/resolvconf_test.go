@@ -0,0 +1,97 @@
+package resolvconf
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+const testResolvConf = `# generated
+nameserver 127.0.0.1
+nameserver 10.0.0.2 # primary
+nameserver not-an-address
+search foo.example.com
+search example.com example.org
+options ndots:2
+options timeout:1 attempts:3
+`
+
+func TestGet(t *testing.T) {
+	f, err := ioutil.TempFile("", "resolv.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(testResolvConf); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	defaultPath := DefaultPath
+	DefaultPath = f.Name()
+	defer func() { DefaultPath = defaultPath }()
+
+	content, err := Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != testResolvConf {
+		t.Fatalf("Unexpected content: %s", content)
+	}
+}
+
+func TestGetNameservers(t *testing.T) {
+	expected := []string{"127.0.0.1", "10.0.0.2"}
+	if ns := GetNameservers([]byte(testResolvConf)); !reflect.DeepEqual(ns, expected) {
+		t.Fatalf("Expected nameservers %v, got %v", expected, ns)
+	}
+}
+
+func TestGetSearchDomains(t *testing.T) {
+	expected := []string{"example.com", "example.org"}
+	if domains := GetSearchDomains([]byte(testResolvConf)); !reflect.DeepEqual(domains, expected) {
+		t.Fatalf("Expected search domains %v, got %v", expected, domains)
+	}
+}
+
+func TestGetOptions(t *testing.T) {
+	expected := []string{"timeout:1", "attempts:3"}
+	if options := GetOptions([]byte(testResolvConf)); !reflect.DeepEqual(options, expected) {
+		t.Fatalf("Expected options %v, got %v", expected, options)
+	}
+}
+
+func TestFilterLocalNameservers(t *testing.T) {
+	expected := []string{"10.0.0.2"}
+	if ns := FilterLocalNameservers([]string{"127.0.0.1", "10.0.0.2", "::1"}); !reflect.DeepEqual(ns, expected) {
+		t.Fatalf("Expected nameservers %v, got %v", expected, ns)
+	}
+
+	if ns := FilterLocalNameservers([]string{"127.0.1.1"}); !reflect.DeepEqual(ns, DefaultNameservers) {
+		t.Fatalf("Expected default nameservers, got %v", ns)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	f, err := ioutil.TempFile("", "resolv.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if err := Build(f.Name(), []string{"10.0.0.2", "10.0.0.3"}, []string{"example.com"}, []string{"ndots:2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "nameserver 10.0.0.2\nnameserver 10.0.0.3\nsearch example.com\noptions ndots:2\n"
+	if string(content) != expected {
+		t.Fatalf("Expected content %q, got %q", expected, content)
+	}
+}
@@ -0,0 +1,132 @@
+package resolver
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+const (
+	headerLen   = 12
+	maxNameLen  = 255
+	maxLabelLen = 63
+
+	typeA     = 1
+	typeAAAA  = 28
+	classINET = 1
+
+	rcodeSuccess       = 0
+	rcodeServerFailure = 2
+
+	flagResponse           = 1 << 15
+	flagAuthoritative      = 1 << 10
+	flagRecursionDesired   = 1 << 8
+	flagRecursionAvailable = 1 << 7
+	maskOpcode             = 0xf << 11
+)
+
+var errMalformed = errors.New("malformed DNS query")
+
+// query is a DNS query holding a single question, the only form resolvers
+// send in practice.
+type query struct {
+	id     uint16
+	flags  uint16
+	name   string
+	qtype  uint16
+	qclass uint16
+	// raw question section, echoed in the reply
+	question []byte
+}
+
+// parseQuery decodes the header and the question of a query. The name is
+// lowered and has no trailing dot.
+func parseQuery(b []byte) (*query, error) {
+	if len(b) < headerLen {
+		return nil, errMalformed
+	}
+
+	q := &query{
+		id:    binary.BigEndian.Uint16(b[0:]),
+		flags: binary.BigEndian.Uint16(b[2:]),
+	}
+	if q.flags&flagResponse != 0 || binary.BigEndian.Uint16(b[4:]) != 1 {
+		return nil, errMalformed
+	}
+
+	var labels []string
+	off, nameLen := headerLen, 0
+	for {
+		if off >= len(b) {
+			return nil, errMalformed
+		}
+		l := int(b[off])
+		off++
+		if l == 0 {
+			break
+		}
+		// Queries do not compress their single name
+		if l > maxLabelLen || off+l > len(b) {
+			return nil, errMalformed
+		}
+		if nameLen += l + 1; nameLen > maxNameLen {
+			return nil, errMalformed
+		}
+		labels = append(labels, string(b[off:off+l]))
+		off += l
+	}
+
+	if off+4 > len(b) {
+		return nil, errMalformed
+	}
+	q.name = strings.ToLower(strings.Join(labels, "."))
+	q.qtype = binary.BigEndian.Uint16(b[off:])
+	q.qclass = binary.BigEndian.Uint16(b[off+2:])
+	q.question = b[headerLen : off+4]
+
+	return q, nil
+}
+
+// reply encodes the response to the query, answering with the addresses of
+// the family the query asks for.
+func (q *query) reply(rcode int, ips []net.IP, ttl uint32) []byte {
+	var answers [][]byte
+	for _, ip := range ips {
+		if q.qtype == typeA {
+			ip = ip.To4()
+		} else if ip.To4() == nil {
+			ip = ip.To16()
+		} else {
+			ip = nil
+		}
+		if ip != nil {
+			answers = append(answers, ip)
+		}
+	}
+
+	flags := flagResponse | flagRecursionAvailable | q.flags&(maskOpcode|flagRecursionDesired) | uint16(rcode)
+	if rcode == rcodeSuccess {
+		flags |= flagAuthoritative
+	}
+
+	b := make([]byte, headerLen, headerLen+len(q.question)+len(answers)*28)
+	binary.BigEndian.PutUint16(b[0:], q.id)
+	binary.BigEndian.PutUint16(b[2:], flags)
+	binary.BigEndian.PutUint16(b[4:], 1)
+	binary.BigEndian.PutUint16(b[6:], uint16(len(answers)))
+	b = append(b, q.question...)
+
+	for _, a := range answers {
+		rr := make([]byte, 12, 12+len(a))
+		// The name points to the one of the question
+		binary.BigEndian.PutUint16(rr[0:], 0xc000|headerLen)
+		binary.BigEndian.PutUint16(rr[2:], q.qtype)
+		binary.BigEndian.PutUint16(rr[4:], classINET)
+		binary.BigEndian.PutUint32(rr[6:], ttl)
+		binary.BigEndian.PutUint16(rr[10:], uint16(len(a)))
+		b = append(b, append(rr, a...)...)
+	}
+
+	return b
+}
@@ -0,0 +1,191 @@
+// Package resolver provides the DNS server embedded in the container
+// sandboxes, which resolves the names of the endpoints and forwards the other
+// queries to the upstream nameservers.
+package resolver
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/sandbox"
+)
+
+// Address is the address the resolver listens on in the sandboxes, on their
+// loopback interface.
+const Address = "127.0.0.11"
+
+const (
+	dnsPort = "53"
+	// Time to live of the answered records, in seconds
+	ttl = 600
+	// Size of the buffers, large enough for the EDNS replies of the
+	// upstream nameservers
+	maxPacketLen   = 4096
+	forwardTimeout = 2 * time.Second
+)
+
+// ErrNoForwarders is returned when a query has to be forwarded while no
+// upstream nameserver is set.
+var ErrNoForwarders = errors.New("no upstream nameserver to forward the query to")
+
+// LookupFunc returns the IPv4, or IPv6 when ipv6 is set, addresses name
+// resolves to, and whether the name is known at all. Unknown names are
+// forwarded upstream.
+type LookupFunc func(name string, ipv6 bool) ([]net.IP, bool)
+
+// Resolver is a DNS server answering the queries for the names its lookup
+// function knows.
+type Resolver struct {
+	lookup     LookupFunc
+	forwarders []string
+	conn       net.PacketConn
+	done       chan struct{}
+	sync.Mutex
+}
+
+// New returns a resolver answering from lookup, which must be safe to call
+// concurrently.
+func New(lookup LookupFunc) *Resolver {
+	return &Resolver{lookup: lookup}
+}
+
+// SetForwarders sets the nameservers the unknown names are resolved by, in
+// order of preference. The port defaults to 53.
+func (r *Resolver) SetForwarders(servers []string) {
+	forwarders := make([]string, 0, len(servers))
+	for _, s := range servers {
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			s = net.JoinHostPort(s, dnsPort)
+		}
+		forwarders = append(forwarders, s)
+	}
+
+	r.Lock()
+	r.forwarders = forwarders
+	r.Unlock()
+}
+
+// Start serves the queries sent to Address in the network namespace of the
+// sandbox identified by key, until Stop is called. The queries are forwarded
+// from the namespace of the caller.
+func (r *Resolver) Start(sboxKey string) error {
+	var conn net.PacketConn
+	err := sandbox.Invoke(sboxKey, func() error {
+		var err error
+		conn, err = net.ListenPacket("udp", net.JoinHostPort(Address, dnsPort))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	r.Lock()
+	r.conn = conn
+	r.done = make(chan struct{})
+	r.Unlock()
+
+	go func() {
+		r.Serve(conn)
+		close(r.done)
+	}()
+
+	return nil
+}
+
+// Stop closes the connection set up by Start, once the resolver stopped
+// reading from it.
+func (r *Resolver) Stop() {
+	r.Lock()
+	conn, done := r.conn, r.done
+	r.conn = nil
+	r.Unlock()
+
+	if conn == nil {
+		return
+	}
+	conn.Close()
+	<-done
+}
+
+// Serve answers the queries read from conn until it is closed. Each query is
+// handled on its own, so that a slow upstream nameserver does not hold back
+// the other queries.
+func (r *Resolver) Serve(conn net.PacketConn) error {
+	for {
+		buf := make([]byte, maxPacketLen)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		go r.handle(conn, addr, buf[:n])
+	}
+}
+
+func (r *Resolver) handle(conn net.PacketConn, addr net.Addr, b []byte) {
+	q, err := parseQuery(b)
+	if err != nil {
+		log.Debugf("Dropping DNS query from %s: %v", addr, err)
+		return
+	}
+
+	var resp []byte
+	if ips, ok := r.lookup(q.name, q.qtype == typeAAAA); ok && q.qclass == classINET {
+		// The other record types are not served for the known names
+		if q.qtype != typeA && q.qtype != typeAAAA {
+			ips = nil
+		}
+		resp = q.reply(rcodeSuccess, ips, ttl)
+	} else if resp, err = r.forward(b); err != nil {
+		log.Debugf("Failed to forward the DNS query for %s: %v", q.name, err)
+		resp = q.reply(rcodeServerFailure, nil, 0)
+	}
+
+	if _, err := conn.WriteTo(resp, addr); err != nil {
+		log.Debugf("Failed to answer the DNS query from %s: %v", addr, err)
+	}
+}
+
+// forward relays the query to the upstream nameservers in turn, until one
+// of them answers.
+func (r *Resolver) forward(b []byte) ([]byte, error) {
+	r.Lock()
+	forwarders := r.forwarders
+	r.Unlock()
+
+	err := ErrNoForwarders
+	for _, s := range forwarders {
+		var resp []byte
+		if resp, err = exchange(s, b); err == nil {
+			return resp, nil
+		}
+	}
+	return nil, err
+}
+
+func exchange(server string, b []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", server, forwardTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(forwardTimeout))
+	if _, err := conn.Write(b); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, maxPacketLen)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Skip the stray replies to earlier queries
+		if n >= headerLen && buf[0] == b[0] && buf[1] == b[1] {
+			return buf[:n], nil
+		}
+	}
+}
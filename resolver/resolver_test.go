@@ -0,0 +1,126 @@
+package resolver
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newQuery encodes a query for name, as the stub resolvers send it.
+func newQuery(id uint16, name string, qtype uint16) []byte {
+	b := make([]byte, headerLen)
+	binary.BigEndian.PutUint16(b[0:], id)
+	binary.BigEndian.PutUint16(b[2:], flagRecursionDesired)
+	binary.BigEndian.PutUint16(b[4:], 1)
+	for _, l := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(l)))
+		b = append(b, l...)
+	}
+	b = append(b, 0, byte(qtype>>8), byte(qtype), 0, classINET)
+	return b
+}
+
+// exchangeWith sends the query to the resolver served on conn and returns its
+// response code and answered addresses.
+func exchangeWith(t *testing.T, conn net.PacketConn, query []byte) (int, []net.IP) {
+	resp, err := exchange(conn.LocalAddr().String(), query)
+	if err != nil {
+		t.Fatalf("DNS query failed: %v", err)
+	}
+
+	q, err := parseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ips []net.IP
+	off := headerLen + len(q.question)
+	for i := 0; i < int(binary.BigEndian.Uint16(resp[6:])); i++ {
+		l := int(binary.BigEndian.Uint16(resp[off+10:]))
+		ips = append(ips, net.IP(resp[off+12:off+12+l]))
+		off += 12 + l
+	}
+
+	return int(binary.BigEndian.Uint16(resp[2:]) & 0xf), ips
+}
+
+func listen(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestParseQuery(t *testing.T) {
+	q, err := parseQuery(newQuery(42, "Web.Example.", typeAAAA))
+	if err != nil {
+		t.Fatalf("Failed to parse the query: %v", err)
+	}
+	if q.id != 42 || q.name != "web.example" || q.qtype != typeAAAA || q.qclass != classINET {
+		t.Fatalf("Unexpected query %+v", q)
+	}
+
+	truncated := newQuery(42, "web", typeA)
+	for _, b := range [][]byte{truncated[:headerLen-1], truncated[:len(truncated)-2]} {
+		if _, err := parseQuery(b); err != errMalformed {
+			t.Fatalf("Expected %v, got %v", errMalformed, err)
+		}
+	}
+
+	// Responses are not queries
+	resp := q.reply(rcodeSuccess, nil, ttl)
+	if _, err := parseQuery(resp); err != errMalformed {
+		t.Fatalf("Expected %v, got %v", errMalformed, err)
+	}
+}
+
+func TestResolver(t *testing.T) {
+	ip := net.ParseIP("172.17.0.2")
+	r := New(func(name string, ipv6 bool) ([]net.IP, bool) {
+		if name != "web" {
+			return nil, false
+		}
+		if ipv6 {
+			return nil, true
+		}
+		return []net.IP{ip}, true
+	})
+
+	conn := listen(t)
+	defer conn.Close()
+	go r.Serve(conn)
+
+	rcode, ips := exchangeWith(t, conn, newQuery(1, "WEB.", typeA))
+	if rcode != rcodeSuccess || len(ips) != 1 || !ips[0].Equal(ip) {
+		t.Fatalf("Expected %v, got %v with code %d", ip, ips, rcode)
+	}
+
+	// The name exists, without IPv6 address
+	if rcode, ips = exchangeWith(t, conn, newQuery(2, "web", typeAAAA)); rcode != rcodeSuccess || len(ips) != 0 {
+		t.Fatalf("Expected an empty answer, got %v with code %d", ips, rcode)
+	}
+
+	if rcode, _ = exchangeWith(t, conn, newQuery(3, "db", typeA)); rcode != rcodeServerFailure {
+		t.Fatalf("Expected code %d without forwarders, got %d", rcodeServerFailure, rcode)
+	}
+
+	// The unknown names are forwarded upstream
+	upstreamIP := net.ParseIP("10.0.0.5")
+	upstream := listen(t)
+	defer upstream.Close()
+	go New(func(name string, ipv6 bool) ([]net.IP, bool) {
+		return []net.IP{upstreamIP}, true
+	}).Serve(upstream)
+
+	r.SetForwarders([]string{"127.0.0.1:1", upstream.LocalAddr().String()})
+	start := time.Now()
+	if rcode, ips = exchangeWith(t, conn, newQuery(4, "db", typeA)); rcode != rcodeSuccess || len(ips) != 1 || !ips[0].Equal(upstreamIP) {
+		t.Fatalf("Expected the upstream answer %v, got %v with code %d", upstreamIP, ips, rcode)
+	}
+	if time.Since(start) > forwardTimeout {
+		t.Fatal("Unreachable forwarder not skipped")
+	}
+}
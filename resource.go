@@ -0,0 +1,186 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"net"
+
+	"github.com/docker/libnetwork/types"
+)
+
+// Resources are the representations of the networks and endpoints exchanged
+// with API clients. Addresses are encoded in their string form, such as
+// "172.17.0.2/16", the field names being the ones of the remote driver
+// messages.
+
+// NetworkResource is the API representation of a network and its endpoints.
+type NetworkResource struct {
+	ID          string
+	Name        string
+	Type        string
+	Labels      map[string]string
+	Subnets     []string
+	Gateway     string
+	GatewayIPv6 string
+	Endpoints   []*EndpointResource
+	// GenericData is only set with ResourceOptionGenericData.
+	GenericData map[string]json.RawMessage `json:",omitempty"`
+}
+
+// EndpointResource is the API representation of an endpoint.
+type EndpointResource struct {
+	ID           string
+	Name         string
+	Network      string
+	Interfaces   []*InterfaceResource
+	Gateway      string
+	GatewayIPv6  string
+	ContainerID  string
+	SandboxKey   string
+	Hostname     string
+	PortBindings []types.PortBinding
+	// GenericData is only set with ResourceOptionGenericData.
+	GenericData map[string]json.RawMessage `json:",omitempty"`
+}
+
+// InterfaceResource is the API representation of an interface an endpoint
+// places into the sandbox.
+type InterfaceResource struct {
+	SrcName     string
+	DstName     string
+	Address     string
+	AddressIPv6 string
+	MacAddress  string
+}
+
+// ResourceOption is an option setter function type used to select what the
+// resources represent.
+type ResourceOption func(o *resourceOptions)
+
+type resourceOptions struct {
+	genericData bool
+}
+
+// ResourceOptionGenericData function returns an option setter adding the
+// generic data of the networks and endpoints to their resources, which
+// they leave out by default.
+func ResourceOptionGenericData() ResourceOption {
+	return func(o *resourceOptions) {
+		o.genericData = true
+	}
+}
+
+// genericDataOf returns a copy of the generic data of the libnetwork network
+// or endpoint x, nil for another implementation.
+func genericDataOf(x interface{}) map[string]json.RawMessage {
+	var g *genericData
+	switch x := x.(type) {
+	case *network:
+		g = &x.generic
+	case *endpoint:
+		g = &x.generic
+	default:
+		return nil
+	}
+
+	data := g.snapshot()
+	if data == nil {
+		return nil
+	}
+	c := make(map[string]json.RawMessage, len(data))
+	for k, v := range data {
+		c[k] = append(json.RawMessage(nil), v...)
+	}
+	return c
+}
+
+// NewNetworkResource returns the representation of the passed network, its
+// settings and endpoints as they are at the time of the call.
+func NewNetworkResource(n Network, opts ...ResourceOption) *NetworkResource {
+	var o resourceOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	info := n.Info()
+
+	res := &NetworkResource{
+		ID:          n.ID(),
+		Name:        n.Name(),
+		Type:        info.Type,
+		Labels:      info.Labels,
+		Gateway:     ipString(info.Gateway),
+		GatewayIPv6: ipString(info.GatewayIPv6),
+		Endpoints:   []*EndpointResource{},
+	}
+
+	for _, s := range info.Subnets {
+		res.Subnets = append(res.Subnets, s.String())
+	}
+
+	for _, ep := range n.Endpoints() {
+		res.Endpoints = append(res.Endpoints, NewEndpointResource(ep, opts...))
+	}
+	if o.genericData {
+		res.GenericData = genericDataOf(n)
+	}
+
+	return res
+}
+
+// NewEndpointResource returns the representation of the passed endpoint and
+// its settings as they are at the time of the call.
+func NewEndpointResource(ep Endpoint, opts ...ResourceOption) *EndpointResource {
+	var o resourceOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	info := ep.Info()
+
+	res := &EndpointResource{
+		ID:           ep.ID(),
+		Name:         ep.Name(),
+		Network:      ep.Network(),
+		Gateway:      ipString(info.Gateway),
+		GatewayIPv6:  ipString(info.GatewayIPv6),
+		ContainerID:  ep.ContainerID(),
+		SandboxKey:   info.SandboxKey,
+		Hostname:     info.Hostname,
+		PortBindings: info.PortBindings,
+	}
+
+	for _, i := range info.Interfaces {
+		ires := &InterfaceResource{SrcName: i.SrcName, DstName: i.DstName}
+		if i.Address != nil {
+			ires.Address = i.Address.String()
+		}
+		if i.AddressIPv6 != nil {
+			ires.AddressIPv6 = i.AddressIPv6.String()
+		}
+		if i.MacAddress != nil {
+			ires.MacAddress = i.MacAddress.String()
+		}
+		res.Interfaces = append(res.Interfaces, ires)
+	}
+	if o.genericData {
+		res.GenericData = genericDataOf(ep)
+	}
+
+	return res
+}
+
+// MarshalJSON encodes the networks managed by the controller and their
+// endpoints as a list of NetworkResource.
+func (c *controller) MarshalJSON() ([]byte, error) {
+	list := []*NetworkResource{}
+	for _, n := range c.Networks() {
+		list = append(list, NewNetworkResource(n))
+	}
+
+	return json.Marshal(list)
+}
+
+func ipString(ip net.IP) string {
+	if len(ip) == 0 {
+		return ""
+	}
+	return ip.String()
+}
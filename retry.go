@@ -0,0 +1,61 @@
+package libnetwork
+
+import (
+	"context"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/types"
+)
+
+// RetryPolicy bounds the attempts the controller makes at the network
+// creation, endpoint creation and join of a driver failing with a
+// types.Retryable error. The other errors fail the operation at once.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts, the first one included. One
+	// or less disables the retries.
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubled before each
+	// following one.
+	Backoff time.Duration
+	// MaxBackoff caps the wait between two attempts, unbounded when zero.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is the RetryPolicy of the controllers created without
+// ControllerOptionRetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+// ControllerOptionRetryPolicy function returns an option setter for the
+// policy the controller retries the transient driver failures with.
+func ControllerOptionRetryPolicy(policy RetryPolicy) ControllerOption {
+	return func(c *controller) {
+		c.retryPolicy = policy
+	}
+}
+
+// run calls op until it succeeds, fails with an error which is not
+// retryable, or the attempts are exhausted, returning the last error. It
+// gives up waiting for the next attempt once ctx is done, returning the
+// context error.
+func (p RetryPolicy) run(ctx context.Context, name string, op func() error) error {
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= p.MaxAttempts || !types.IsRetryable(err) {
+			return err
+		}
+
+		log.Debugf("Retrying %s in %v after attempt %d failed: %v", name, backoff, attempt, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if p.MaxBackoff != 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
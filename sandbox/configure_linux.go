@@ -10,26 +10,21 @@ import (
 	"github.com/vishvananda/netns"
 )
 
-func configureInterface(iface netlink.Link, settings *Interface) error {
+func configureInterface(iface netlink.Link, dstName string, addresses []*net.IPNet) error {
 	ifaceName := iface.Attrs().Name
-	ifaceConfigurators := []struct {
-		Fn         func(netlink.Link, *Interface) error
-		ErrMessage string
-	}{
-		{setInterfaceName, fmt.Sprintf("error renaming interface %q to %q", ifaceName, settings.DstName)},
-		{setInterfaceIP, fmt.Sprintf("error setting interface %q IP to %q", ifaceName, settings.Address)},
-		{setInterfaceIPv6, fmt.Sprintf("error setting interface %q IPv6 to %q", ifaceName, settings.AddressIPv6)},
-	}
-
-	for _, config := range ifaceConfigurators {
-		if err := config.Fn(iface, settings); err != nil {
-			return fmt.Errorf("%s: %v", config.ErrMessage, err)
+	if err := netlink.LinkSetName(iface, dstName); err != nil {
+		return fmt.Errorf("error renaming interface %q to %q: %v", ifaceName, dstName, err)
+	}
+
+	for _, addr := range addresses {
+		if err := netlink.AddrAdd(iface, &netlink.Addr{IPNet: addr, Label: ""}); err != nil {
+			return fmt.Errorf("error setting interface %q IP to %q: %v", ifaceName, addr, err)
 		}
 	}
 	return nil
 }
 
-func programGateway(path string, gw net.IP) error {
+func programGateway(path string, gw net.IP, isAdd bool) error {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
@@ -56,26 +51,97 @@ func programGateway(path string, gw net.IP) error {
 		return fmt.Errorf("route for the gateway could not be found: %v", err)
 	}
 
-	return netlink.RouteAdd(&netlink.Route{
+	route := &netlink.Route{
 		Scope:     netlink.SCOPE_UNIVERSE,
 		LinkIndex: gwRoutes[0].LinkIndex,
 		Gw:        gw,
-	})
+	}
+
+	if isAdd {
+		return netlink.RouteAdd(route)
+	}
+
+	return netlink.RouteDel(route)
+}
+
+// nsInvoke runs fn with the calling thread in the network namespace at path.
+func nsInvoke(path string, fn func() error) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origns, err := netns.Get()
+	if err != nil {
+		return err
+	}
+	defer origns.Close()
+
+	f, err := os.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed get network namespace %q: %v", path, err)
+	}
+	defer f.Close()
+
+	if err = netns.Set(netns.NsHandle(f.Fd())); err != nil {
+		return err
+	}
+	defer netns.Set(origns)
+
+	return fn()
 }
 
-func setInterfaceIP(iface netlink.Link, settings *Interface) error {
-	ipAddr := &netlink.Addr{IPNet: settings.Address, Label: ""}
-	return netlink.AddrAdd(iface, ipAddr)
+// netlinkRoute converts r into a netlink route. It must be called from the
+// sandbox network namespace.
+func netlinkRoute(r *Route) (*netlink.Route, error) {
+	iface, err := netlink.LinkByName(r.Interface)
+	if err != nil {
+		return nil, err
+	}
+
+	route := &netlink.Route{
+		LinkIndex: iface.Attrs().Index,
+		Scope:     netlink.SCOPE_UNIVERSE,
+		Dst:       r.Destination,
+	}
+
+	if len(r.NextHop) == 0 {
+		route.Scope = netlink.SCOPE_LINK
+	} else {
+		route.Gw = r.NextHop
+	}
+
+	if r.Destination != nil {
+		if ones, _ := r.Destination.Mask.Size(); ones == 0 {
+			route.Dst = nil
+		}
+	}
+
+	return route, nil
 }
 
-func setInterfaceIPv6(iface netlink.Link, settings *Interface) error {
-	if settings.AddressIPv6 == nil {
-		return nil
+func routeFamily(r *Route) int {
+	ip := r.NextHop
+	if r.Destination != nil {
+		ip = r.Destination.IP
+	}
+	if ip.To4() != nil {
+		return netlink.FAMILY_V4
 	}
-	ipAddr := &netlink.Addr{IPNet: settings.AddressIPv6, Label: ""}
-	return netlink.AddrAdd(iface, ipAddr)
+	return netlink.FAMILY_V6
 }
 
-func setInterfaceName(iface netlink.Link, settings *Interface) error {
-	return netlink.LinkSetName(iface, settings.DstName)
+// defaultRoutes returns the default routes of the family. It must be called
+// from the sandbox network namespace.
+func defaultRoutes(family int) ([]netlink.Route, error) {
+	routes, err := netlink.RouteList(nil, family)
+	if err != nil {
+		return nil, err
+	}
+
+	var defaults []netlink.Route
+	for _, r := range routes {
+		if r.Dst == nil {
+			defaults = append(defaults, r)
+		}
+	}
+	return defaults, nil
 }
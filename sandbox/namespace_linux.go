@@ -2,17 +2,33 @@ package sandbox
 
 import (
 	"fmt"
+	"hash/crc32"
+	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netns"
 )
 
-const prefix = "/var/lib/docker/network"
+const (
+	prefix    = "/var/lib/docker/network"
+	utsSuffix = "-uts"
+
+	// DefaultKeyRoot is the directory of the sandbox keys GenerateKey
+	// returns.
+	DefaultKeyRoot = prefix
+
+	// The network namespaces created for the sandboxes are pinned in the
+	// directory of the ip netns named namespaces as well.
+	netnsPrefix = "/var/run/netns"
+)
 
 var once sync.Once
 
@@ -20,8 +36,13 @@ var once sync.Once
 // interface. It represents a linux network namespace, and moves an interface
 // into it when called on method AddInterface or sets the gateway etc.
 type networkNamespace struct {
-	path  string
-	sinfo *Info
+	path      string
+	pinPath   string
+	utsPath   string
+	hostname  string
+	sinfo     *Info
+	replaced  map[*Route][]netlink.Route // default routes replaced by a route
+	destroyed bool
 }
 
 func createBasePath() {
@@ -34,21 +55,133 @@ func createBasePath() {
 // GenerateKey generates a sandbox key based on the passed
 // container id.
 func GenerateKey(containerID string) string {
+	return GenerateKeyIn(prefix, containerID)
+}
+
+// GenerateKeyIn generates a sandbox key based on the passed container id in
+// the root directory. The network namespaces are pinned after the last
+// element of their key: outside the default root the key carries the
+// checksum of the root as well, so that the sandboxes of distinct roots do
+// not collide.
+func GenerateKeyIn(root, containerID string) string {
 	maxLen := 12
 	if len(containerID) < maxLen {
 		maxLen = len(containerID)
 	}
 
-	return prefix + "/" + containerID[:maxLen]
+	name := containerID[:maxLen]
+	if root = filepath.Clean(root); root != prefix {
+		name = fmt.Sprintf("%s-%08x", name, crc32.ChecksumIEEE([]byte(root)))
+	}
+	return filepath.Join(root, name)
+}
+
+// pinPath returns the path the network namespace of the sandbox identified
+// by key is pinned at, named after the last element of the key.
+func pinPath(key string) string {
+	return filepath.Join(netnsPrefix, filepath.Base(key))
+}
+
+// Keys returns the keys of the sandboxes which exist on the host, including
+// the ones left behind by a previous process.
+func Keys() ([]string, error) {
+	return KeysIn(prefix)
+}
+
+// KeysIn returns the keys of the sandboxes which exist in the root
+// directory, as Keys does for the default one.
+func KeysIn(root string) ([]string, error) {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var keys []string
+	for _, e := range entries {
+		// Namespaces are bind mounted on regular files, the uts namespace
+		// of a sandbox next to its network namespace
+		if !e.Mode().IsRegular() || strings.HasSuffix(e.Name(), utsSuffix) {
+			continue
+		}
+		keys = append(keys, filepath.Join(root, e.Name()))
+	}
+
+	return keys, nil
+}
+
+// Remove destroys the sandbox identified by key without needing a Sandbox
+// instance, such as the sandboxes left behind by a previous process.
+func Remove(key string) error {
+	for _, path := range []string{pinPath(key), key + utsSuffix, key} {
+		if err := removeNamespaceFile(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeNamespaceFile unmounts the namespace bind mounted at path, if any,
+// and removes the file. A missing path is not an error.
+func removeNamespaceFile(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	// The namespace is not mounted anymore after a reboot
+	if err := syscall.Unmount(path, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// Invoke runs fn with the calling thread in the network namespace of the
+// sandbox identified by key, for the drivers programming their own sandboxes.
+func Invoke(key string, fn func() error) error {
+	return nsInvoke(key, fn)
 }
 
 // NewSandbox provides a new sandbox instance created in an os specific way
-// provided a key which uniquely identifies the sandbox
-func NewSandbox(key string) (Sandbox, error) {
-	return createNetworkNamespace(key)
+// provided a key which uniquely identifies the sandbox. When osCreate is false
+// no new network namespace is created and the key references the network
+// namespace of the caller instead. A new network namespace is also pinned
+// at a path named after the key, returned by NamespacePath, so that it
+// outlives the creating process and can be inspected with ip netns.
+func NewSandbox(key string, osCreate bool) (Sandbox, error) {
+	return createNetworkNamespace(key, osCreate)
 }
 
-func createNetworkNamespace(path string) (Sandbox, error) {
+// NewSandboxFromPath provides a sandbox instance for the existing network
+// namespace at nsPath, such as /proc/<pid>/ns/net for the namespace of a
+// process started by another runtime. The namespace is bind mounted at key,
+// which identifies the sandbox as for NewSandbox. Destroying the sandbox
+// leaves the namespace to the processes using it. InvalidNamespacePathError
+// is returned if nsPath is not a network namespace.
+func NewSandboxFromPath(key, nsPath string) (Sandbox, error) {
+	// Entering the namespace fails for anything else than a network
+	// namespace
+	if _, err := os.Stat(nsPath); err != nil || nsInvoke(nsPath, func() error { return nil }) != nil {
+		return nil, InvalidNamespacePathError(nsPath)
+	}
+
+	if err := createNamespaceFile(key); err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Mount(nsPath, key, "bind", syscall.MS_BIND, ""); err != nil {
+		os.Remove(key)
+		return nil, err
+	}
+
+	sinfo := &Info{Interfaces: []*Interface{}}
+	return &networkNamespace{path: key, sinfo: sinfo, replaced: make(map[*Route][]netlink.Route)}, nil
+}
+
+func createNetworkNamespace(path string, osCreate bool) (Sandbox, error) {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
@@ -62,15 +195,23 @@ func createNetworkNamespace(path string) (Sandbox, error) {
 		return nil, err
 	}
 
-	defer netns.Set(origns)
-	newns, err := netns.New()
-	if err != nil {
-		return nil, err
-	}
-	defer newns.Close()
-
-	if err := loopbackUp(); err != nil {
-		return nil, err
+	var utsPath string
+	if osCreate {
+		utsPath = path + utsSuffix
+		if err := createUTSNamespace(utsPath); err != nil {
+			return nil, err
+		}
+
+		defer netns.Set(origns)
+		newns, err := netns.New()
+		if err != nil {
+			return nil, err
+		}
+		defer newns.Close()
+
+		if err := loopbackUp(); err != nil {
+			return nil, err
+		}
 	}
 
 	procNet := fmt.Sprintf("/proc/%d/task/%d/ns/net", os.Getpid(), syscall.Gettid())
@@ -79,15 +220,85 @@ func createNetworkNamespace(path string) (Sandbox, error) {
 		return nil, err
 	}
 
+	var pin string
+	if osCreate {
+		pin = pinPath(path)
+		if err := pinNamespace(path, pin); err != nil {
+			syscall.Unmount(path, syscall.MNT_DETACH)
+			return nil, err
+		}
+	}
+
 	interfaces := []*Interface{}
 	sinfo := &Info{Interfaces: interfaces}
-	return &networkNamespace{path: path, sinfo: sinfo}, nil
+	return &networkNamespace{path: path, pinPath: pin, utsPath: utsPath, sinfo: sinfo, replaced: make(map[*Route][]netlink.Route)}, nil
+}
+
+// pinNamespace bind mounts the namespace mounted at path on pin. A stale
+// file at pin, such as the one of a sandbox of a previous process with the
+// same key, is unmounted and removed first.
+func pinNamespace(path, pin string) error {
+	if err := removeNamespaceFile(pin); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(netnsPrefix, 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(pin, os.O_RDONLY|os.O_CREATE|os.O_EXCL, 0444)
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	if err := syscall.Mount(path, pin, "bind", syscall.MS_BIND, ""); err != nil {
+		os.Remove(pin)
+		return err
+	}
+
+	return nil
+}
+
+// createUTSNamespace creates a new UTS namespace, bind mounted at path, which
+// lets the sandbox own a hostname. The calling thread is restored to its
+// original UTS namespace. It must be called with the OS thread locked.
+func createUTSNamespace(path string) error {
+	procUTS := fmt.Sprintf("/proc/%d/task/%d/ns/uts", os.Getpid(), syscall.Gettid())
+
+	origuts, err := os.Open(procUTS)
+	if err != nil {
+		return err
+	}
+	defer origuts.Close()
+
+	if err := createNamespaceFile(path); err != nil {
+		return err
+	}
+
+	if err := syscall.Unshare(syscall.CLONE_NEWUTS); err != nil {
+		os.Remove(path)
+		return err
+	}
+	defer netns.Setns(netns.NsHandle(origuts.Fd()), syscall.CLONE_NEWUTS)
+
+	if err := syscall.Mount(procUTS, path, "bind", syscall.MS_BIND, ""); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	return nil
 }
 
 func createNamespaceFile(path string) (err error) {
 	var f *os.File
 
 	once.Do(createBasePath)
+	if dir := filepath.Dir(path); dir != prefix {
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
 	if f, err = os.Create(path); err == nil {
 		f.Close()
 	}
@@ -102,7 +313,7 @@ func loopbackUp() error {
 	return netlink.LinkSetUp(iface)
 }
 
-func (n *networkNamespace) AddInterface(i *Interface) error {
+func (n *networkNamespace) AddInterface(srcName, dstName string, addresses []*net.IPNet) error {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
@@ -118,6 +329,17 @@ func (n *networkNamespace) AddInterface(i *Interface) error {
 	}
 	defer f.Close()
 
+	i := &Interface{SrcName: srcName, DstName: uniqueDstName(n.sinfo.Interfaces, dstName)}
+	for _, addr := range addresses {
+		if addr.IP.To4() != nil {
+			if i.Address == nil {
+				i.Address = addr
+			}
+		} else if i.AddressIPv6 == nil {
+			i.AddressIPv6 = addr
+		}
+	}
+
 	// Find the network inteerface identified by the SrcName attribute.
 	iface, err := netlink.LinkByName(i.SrcName)
 	if err != nil {
@@ -141,7 +363,7 @@ func (n *networkNamespace) AddInterface(i *Interface) error {
 	}
 
 	// Configure the interface now this is moved in the proper namespace.
-	if err := configureInterface(iface, i); err != nil {
+	if err := configureInterface(iface, i.DstName, addresses); err != nil {
 		return err
 	}
 
@@ -154,12 +376,76 @@ func (n *networkNamespace) AddInterface(i *Interface) error {
 	return nil
 }
 
+func (n *networkNamespace) RemoveInterface(dstName string) error {
+	var i *Interface
+	for _, intf := range n.sinfo.Interfaces {
+		if intf.DstName == dstName {
+			i = intf
+			break
+		}
+	}
+	if i == nil {
+		return InterfaceNotFoundError(dstName)
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origns, err := netns.Get()
+	if err != nil {
+		return err
+	}
+	defer origns.Close()
+
+	f, err := os.OpenFile(n.path, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed get network namespace %q: %v", n.path, err)
+	}
+	defer f.Close()
+
+	nsFD := f.Fd()
+	if err = netns.Set(netns.NsHandle(nsFD)); err != nil {
+		return err
+	}
+	defer netns.Set(origns)
+
+	// Find the network interface identified by the DstName attribute.
+	iface, err := netlink.LinkByName(i.DstName)
+	if err != nil {
+		return err
+	}
+
+	// Down the interface before configuring
+	if err := netlink.LinkSetDown(iface); err != nil {
+		return err
+	}
+
+	// Restore the original name before moving it out of the sandbox.
+	if err := netlink.LinkSetName(iface, i.SrcName); err != nil {
+		return err
+	}
+
+	// Move the network interface back to the caller namespace.
+	if err := netlink.LinkSetNsFd(iface, int(origns)); err != nil {
+		return err
+	}
+
+	for index, intf := range n.sinfo.Interfaces {
+		if intf == i {
+			n.sinfo.Interfaces = append(n.sinfo.Interfaces[:index], n.sinfo.Interfaces[index+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
 func (n *networkNamespace) SetGateway(gw net.IP) error {
 	if len(gw) == 0 {
 		return nil
 	}
 
-	err := programGateway(n.path, gw)
+	err := programGateway(n.path, gw, true)
 	if err == nil {
 		n.sinfo.Gateway = gw
 	}
@@ -172,7 +458,7 @@ func (n *networkNamespace) SetGatewayIPv6(gw net.IP) error {
 		return nil
 	}
 
-	err := programGateway(n.path, gw)
+	err := programGateway(n.path, gw, true)
 	if err == nil {
 		n.sinfo.GatewayIPv6 = gw
 	}
@@ -180,6 +466,236 @@ func (n *networkNamespace) SetGatewayIPv6(gw net.IP) error {
 	return err
 }
 
+func (n *networkNamespace) UnsetGateway() error {
+	if len(n.sinfo.Gateway) == 0 {
+		return nil
+	}
+
+	if n.forgetReplaced(n.sinfo.Gateway) {
+		n.sinfo.Gateway = net.IP{}
+		return nil
+	}
+
+	err := programGateway(n.path, n.sinfo.Gateway, false)
+	if err == nil {
+		n.sinfo.Gateway = net.IP{}
+	}
+
+	return err
+}
+
+func (n *networkNamespace) UnsetGatewayIPv6() error {
+	if len(n.sinfo.GatewayIPv6) == 0 {
+		return nil
+	}
+
+	if n.forgetReplaced(n.sinfo.GatewayIPv6) {
+		n.sinfo.GatewayIPv6 = net.IP{}
+		return nil
+	}
+
+	err := programGateway(n.path, n.sinfo.GatewayIPv6, false)
+	if err == nil {
+		n.sinfo.GatewayIPv6 = net.IP{}
+	}
+
+	return err
+}
+
+func (n *networkNamespace) AddRoute(dst *net.IPNet, gw net.IP, iface string) error {
+	r := &Route{Destination: dst, NextHop: gw, Interface: iface}
+
+	var replaced []netlink.Route
+	err := nsInvoke(n.path, func() error {
+		route, err := netlinkRoute(r)
+		if err != nil {
+			return err
+		}
+
+		if route.Dst == nil {
+			if replaced, err = defaultRoutes(routeFamily(r)); err != nil {
+				return err
+			}
+			for i := range replaced {
+				if err := netlink.RouteDel(&replaced[i]); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := netlink.RouteAdd(route); err != nil {
+			for i := range replaced {
+				netlink.RouteAdd(&replaced[i])
+			}
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	n.sinfo.Routes = append(n.sinfo.Routes, r)
+	if len(replaced) != 0 {
+		n.replaced[r] = replaced
+	}
+
+	return nil
+}
+
+func (n *networkNamespace) RemoveRoute(dst *net.IPNet, gw net.IP, iface string) error {
+	r := &Route{Destination: dst, NextHop: gw, Interface: iface}
+
+	index := -1
+	for i, route := range n.sinfo.Routes {
+		if route.Equal(r) {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return fmt.Errorf("route to %v via %v on %s is not in the sandbox", dst, gw, iface)
+	}
+	added := n.sinfo.Routes[index]
+
+	err := nsInvoke(n.path, func() error {
+		route, err := netlinkRoute(added)
+		if err != nil {
+			return err
+		}
+
+		if err := netlink.RouteDel(route); err != nil {
+			return err
+		}
+
+		// Restore the default routes this route replaced
+		for _, rt := range n.replaced[added] {
+			if err := netlink.RouteAdd(&rt); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	n.sinfo.Routes = append(n.sinfo.Routes[:index], n.sinfo.Routes[index+1:]...)
+	delete(n.replaced, added)
+
+	return nil
+}
+
+// forgetReplaced drops the default route via gw from the replaced default
+// routes so that it is not restored. It returns whether the route was found.
+func (n *networkNamespace) forgetReplaced(gw net.IP) bool {
+	for r, routes := range n.replaced {
+		for i, rt := range routes {
+			if rt.Gw.Equal(gw) {
+				n.replaced[r] = append(routes[:i], routes[i+1:]...)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (n *networkNamespace) SetHostname(name string) error {
+	if n.utsPath == "" {
+		n.hostname = name
+		return nil
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origuts, err := os.Open(fmt.Sprintf("/proc/%d/task/%d/ns/uts", os.Getpid(), syscall.Gettid()))
+	if err != nil {
+		return err
+	}
+	defer origuts.Close()
+
+	f, err := os.OpenFile(n.utsPath, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed get uts namespace %q: %v", n.utsPath, err)
+	}
+	defer f.Close()
+
+	if err := netns.Setns(netns.NsHandle(f.Fd()), syscall.CLONE_NEWUTS); err != nil {
+		return err
+	}
+	defer netns.Setns(netns.NsHandle(origuts.Fd()), syscall.CLONE_NEWUTS)
+
+	if err := syscall.Sethostname([]byte(name)); err != nil {
+		return err
+	}
+
+	n.hostname = name
+	return nil
+}
+
+func (n *networkNamespace) Hostname() string {
+	return n.hostname
+}
+
+// sysctlPath returns the path of the sysctl name under /proc/sys. Only the
+// net sysctls are scoped to the network namespace.
+func sysctlPath(name string) (string, error) {
+	if !strings.HasPrefix(name, "net.") || strings.HasSuffix(name, ".") ||
+		strings.Contains(name, "..") || strings.Contains(name, "/") {
+		return "", InvalidSysctlError(name)
+	}
+	return filepath.Join("/proc/sys", strings.Replace(name, ".", "/", -1)), nil
+}
+
+func (n *networkNamespace) Sysctl(name string) (string, error) {
+	path, err := sysctlPath(name)
+	if err != nil {
+		return "", err
+	}
+
+	// The sysctls are the ones of the namespace of the calling thread
+	var value string
+	err = nsInvoke(n.path, func() error {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return InvalidSysctlError(name)
+			}
+			return err
+		}
+		value = strings.TrimSpace(string(b))
+		return nil
+	})
+
+	return value, err
+}
+
+func (n *networkNamespace) SetSysctl(name, value string) error {
+	path, err := sysctlPath(name)
+	if err != nil {
+		return err
+	}
+
+	return nsInvoke(n.path, func() error {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			if os.IsNotExist(err) || os.IsPermission(err) {
+				return InvalidSysctlError(name)
+			}
+			return err
+		}
+		defer f.Close()
+
+		if _, err := f.WriteString(value); err != nil {
+			return fmt.Errorf("failed to set sysctl %s to %q: %v", name, value, err)
+		}
+		return nil
+	})
+}
+
 func (n *networkNamespace) Interfaces() []*Interface {
 	return n.sinfo.Interfaces
 }
@@ -188,12 +704,87 @@ func (n *networkNamespace) Key() string {
 	return n.path
 }
 
+func (n *networkNamespace) NamespacePath() string {
+	if n.pinPath != "" {
+		return n.pinPath
+	}
+	return n.path
+}
+
+func (n *networkNamespace) Statistics() (map[string]*InterfaceStatistics, error) {
+	if n.destroyed {
+		return nil, DestroyedError(n.path)
+	}
+
+	var stats map[string]*InterfaceStatistics
+	err := nsInvoke(n.path, func() error {
+		// The counters of the namespace of the calling thread
+		data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/task/%d/net/dev", os.Getpid(), syscall.Gettid()))
+		if err != nil {
+			return err
+		}
+		stats, err = parseNetDev(data)
+		return err
+	})
+	if err != nil {
+		// The namespace may have been removed from under the sandbox
+		if _, serr := os.Stat(n.path); os.IsNotExist(serr) {
+			return nil, DestroyedError(n.path)
+		}
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+func (n *networkNamespace) Probe(addr string, timeout time.Duration) (*ProbeResult, error) {
+	if n.destroyed {
+		return nil, DestroyedError(n.path)
+	}
+
+	timeout, err := checkProbe(addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var r *ProbeResult
+	err = nsInvoke(n.path, func() error {
+		r = probe(addr, timeout)
+		return nil
+	})
+	if err != nil {
+		if _, serr := os.Stat(n.path); os.IsNotExist(serr) {
+			return nil, DestroyedError(n.path)
+		}
+		return nil, err
+	}
+
+	return r, nil
+}
+
 func (n *networkNamespace) Destroy() error {
 	// Assuming no running process is executing in this network namespace,
 	// unmounting is sufficient to destroy it.
+	if n.pinPath != "" {
+		if err := removeNamespaceFile(n.pinPath); err != nil {
+			return err
+		}
+	}
+
 	if err := syscall.Unmount(n.path, syscall.MNT_DETACH); err != nil {
 		return err
 	}
 
+	if n.utsPath != "" {
+		if err := syscall.Unmount(n.utsPath, syscall.MNT_DETACH); err != nil {
+			return err
+		}
+
+		if err := os.Remove(n.utsPath); err != nil {
+			return err
+		}
+	}
+
+	n.destroyed = true
 	return os.Remove(n.path)
 }
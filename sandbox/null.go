@@ -0,0 +1,285 @@
+package sandbox
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NullSandbox is a Sandbox kept in memory, for the tests of the sandbox users
+// run without the privileges to create network namespaces. It records the
+// interfaces, routes and settings it is programmed with, which it gives back
+// as a network namespace would, and touches no device.
+type NullSandbox struct {
+	key         string
+	interfaces  []*Interface
+	routes      []*Route
+	gateway     net.IP
+	gatewayIPv6 net.IP
+	hostname    string
+	sysctls     map[string]string
+	destroyed   bool
+	sync.Mutex
+}
+
+// NewNullSandbox returns an empty NullSandbox identified by key.
+func NewNullSandbox(key string) *NullSandbox {
+	return &NullSandbox{key: key, sysctls: map[string]string{}}
+}
+
+// Key returns the key of the sandbox.
+func (n *NullSandbox) Key() string {
+	return n.key
+}
+
+// NamespacePath returns the key of the sandbox.
+func (n *NullSandbox) NamespacePath() string {
+	return n.key
+}
+
+// Interfaces returns the interfaces added to the sandbox.
+func (n *NullSandbox) Interfaces() []*Interface {
+	n.Lock()
+	defer n.Unlock()
+	return append([]*Interface(nil), n.interfaces...)
+}
+
+// AddInterface records the interface srcName under dstName, suffixed as
+// moving it to a network namespace would.
+func (n *NullSandbox) AddInterface(srcName, dstName string, addresses []*net.IPNet) error {
+	n.Lock()
+	defer n.Unlock()
+
+	i := &Interface{SrcName: srcName, DstName: uniqueDstName(n.interfaces, dstName)}
+	for _, addr := range addresses {
+		if addr.IP.To4() != nil {
+			if i.Address == nil {
+				i.Address = addr
+			}
+		} else if i.AddressIPv6 == nil {
+			i.AddressIPv6 = addr
+		}
+	}
+	n.interfaces = append(n.interfaces, i)
+	return nil
+}
+
+// RemoveInterface forgets the interface dstName.
+func (n *NullSandbox) RemoveInterface(dstName string) error {
+	n.Lock()
+	defer n.Unlock()
+
+	for index, i := range n.interfaces {
+		if i.DstName == dstName {
+			n.interfaces = append(n.interfaces[:index], n.interfaces[index+1:]...)
+			return nil
+		}
+	}
+	return InterfaceNotFoundError(dstName)
+}
+
+// SetGateway records the default IPv4 gateway.
+func (n *NullSandbox) SetGateway(gw net.IP) error {
+	n.Lock()
+	defer n.Unlock()
+	if len(gw) != 0 {
+		n.gateway = gw
+	}
+	return nil
+}
+
+// SetGatewayIPv6 records the default IPv6 gateway.
+func (n *NullSandbox) SetGatewayIPv6(gw net.IP) error {
+	n.Lock()
+	defer n.Unlock()
+	if len(gw) != 0 {
+		n.gatewayIPv6 = gw
+	}
+	return nil
+}
+
+// UnsetGateway forgets the default IPv4 gateway.
+func (n *NullSandbox) UnsetGateway() error {
+	n.Lock()
+	defer n.Unlock()
+	n.gateway = nil
+	return nil
+}
+
+// UnsetGatewayIPv6 forgets the default IPv6 gateway.
+func (n *NullSandbox) UnsetGatewayIPv6() error {
+	n.Lock()
+	defer n.Unlock()
+	n.gatewayIPv6 = nil
+	return nil
+}
+
+// Gateway returns the default IPv4 gateway, nil when unset.
+func (n *NullSandbox) Gateway() net.IP {
+	n.Lock()
+	defer n.Unlock()
+	return n.gateway
+}
+
+// GatewayIPv6 returns the default IPv6 gateway, nil when unset.
+func (n *NullSandbox) GatewayIPv6() net.IP {
+	n.Lock()
+	defer n.Unlock()
+	return n.gatewayIPv6
+}
+
+// AddRoute records the static route to dst.
+func (n *NullSandbox) AddRoute(dst *net.IPNet, gw net.IP, iface string) error {
+	n.Lock()
+	defer n.Unlock()
+	n.routes = append(n.routes, &Route{Destination: dst, NextHop: gw, Interface: iface})
+	return nil
+}
+
+// RemoveRoute forgets the static route to dst, which must have been added.
+func (n *NullSandbox) RemoveRoute(dst *net.IPNet, gw net.IP, iface string) error {
+	n.Lock()
+	defer n.Unlock()
+
+	r := &Route{Destination: dst, NextHop: gw, Interface: iface}
+	for index, route := range n.routes {
+		if route.Equal(r) {
+			n.routes = append(n.routes[:index], n.routes[index+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no route to %v via %v on %s", dst, gw, iface)
+}
+
+// Routes returns the static routes added to the sandbox.
+func (n *NullSandbox) Routes() []*Route {
+	n.Lock()
+	defer n.Unlock()
+	return append([]*Route(nil), n.routes...)
+}
+
+// SetHostname records the hostname.
+func (n *NullSandbox) SetHostname(name string) error {
+	n.Lock()
+	defer n.Unlock()
+	n.hostname = name
+	return nil
+}
+
+// Hostname returns the hostname previously set with SetHostname.
+func (n *NullSandbox) Hostname() string {
+	n.Lock()
+	defer n.Unlock()
+	return n.hostname
+}
+
+// Sysctl returns the value the network sysctl name was set to, "0" if it was
+// not.
+func (n *NullSandbox) Sysctl(name string) (string, error) {
+	if !nullSysctl(name) {
+		return "", InvalidSysctlError(name)
+	}
+
+	n.Lock()
+	defer n.Unlock()
+	if value, ok := n.sysctls[name]; ok {
+		return value, nil
+	}
+	return "0", nil
+}
+
+// SetSysctl records the value of the network sysctl name.
+func (n *NullSandbox) SetSysctl(name, value string) error {
+	if !nullSysctl(name) {
+		return InvalidSysctlError(name)
+	}
+
+	n.Lock()
+	defer n.Unlock()
+	n.sysctls[name] = value
+	return nil
+}
+
+// nullSysctl tells whether name is a sysctl of a network namespace, in the
+// dotted form.
+func nullSysctl(name string) bool {
+	return strings.HasPrefix(name, "net.") && !strings.ContainsAny(name, "/")
+}
+
+// Statistics returns zero counters for the loopback interface and the
+// interfaces added to the sandbox.
+func (n *NullSandbox) Statistics() (map[string]*InterfaceStatistics, error) {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.destroyed {
+		return nil, DestroyedError(n.key)
+	}
+	stats := map[string]*InterfaceStatistics{"lo": {}}
+	for _, i := range n.interfaces {
+		stats[i.DstName] = &InterfaceStatistics{}
+	}
+	return stats, nil
+}
+
+// Probe connects to addr from the network namespace of the caller, the
+// sandbox having none of its own.
+func (n *NullSandbox) Probe(addr string, timeout time.Duration) (*ProbeResult, error) {
+	n.Lock()
+	destroyed := n.destroyed
+	n.Unlock()
+
+	if destroyed {
+		return nil, DestroyedError(n.key)
+	}
+	timeout, err := checkProbe(addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return probe(addr, timeout), nil
+}
+
+// Destroy marks the sandbox destroyed.
+func (n *NullSandbox) Destroy() error {
+	n.Lock()
+	defer n.Unlock()
+	n.destroyed = true
+	return nil
+}
+
+// Destroyed tells whether the sandbox was destroyed.
+func (n *NullSandbox) Destroyed() bool {
+	n.Lock()
+	defer n.Unlock()
+	return n.destroyed
+}
+
+// NullFactory creates NullSandbox instances in place of network namespaces,
+// keeping the last one created for each key for inspection.
+type NullFactory struct {
+	sandboxes map[string]*NullSandbox
+	sync.Mutex
+}
+
+// NewSandbox returns a new NullSandbox identified by key, osCreate is
+// ignored.
+func (f *NullFactory) NewSandbox(key string, osCreate bool) (Sandbox, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.sandboxes == nil {
+		f.sandboxes = map[string]*NullSandbox{}
+	}
+	sb := NewNullSandbox(key)
+	f.sandboxes[key] = sb
+	return sb, nil
+}
+
+// Sandbox returns the last NullSandbox created for key, nil if none was.
+func (f *NullFactory) Sandbox(key string) *NullSandbox {
+	f.Lock()
+	defer f.Unlock()
+	return f.sandboxes[key]
+}
@@ -0,0 +1,64 @@
+package sandbox
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultProbeTimeout is the timeout of the probes run without one.
+const DefaultProbeTimeout = 5 * time.Second
+
+// ProbeResult is the outcome of a reachability probe of an address from a
+// sandbox.
+type ProbeResult struct {
+	// Address is the address probed, in the host:port form.
+	Address string
+	// Success tells whether the address accepted the connection.
+	Success bool
+	// Latency is the time the connection took to establish or fail.
+	Latency time.Duration
+	// Error describes why the probe failed, empty on success.
+	Error string
+}
+
+// InvalidProbeAddressError is returned when the address of a probe is not an
+// IP address and port.
+type InvalidProbeAddressError string
+
+func (addr InvalidProbeAddressError) Error() string {
+	return fmt.Sprintf("invalid probe address %q, expected an ip address and port", string(addr))
+}
+
+// InvalidParameter denotes the type of this error
+func (addr InvalidProbeAddressError) InvalidParameter() {}
+
+// checkProbe validates the probe of addr and returns the timeout it runs
+// with.
+func checkProbe(addr string, timeout time.Duration) (time.Duration, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) == nil {
+		return 0, InvalidProbeAddressError(addr)
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultProbeTimeout
+	}
+	return timeout, nil
+}
+
+// probe connects to addr over TCP from the network namespace of the calling
+// thread, which the socket is bound to as it is created.
+func probe(addr string, timeout time.Duration) *ProbeResult {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	r := &ProbeResult{Address: addr, Latency: time.Since(start)}
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+
+	conn.Close()
+	r.Success = true
+	return r
+}
@@ -1,7 +1,12 @@
 package sandbox
 
 import (
+	"bytes"
+	"fmt"
 	"net"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/docker/libnetwork/netutils"
 )
@@ -9,19 +14,36 @@ import (
 // Sandbox represents a network sandbox, identified by a specific key.  It
 // holds a list of Interfaces, routes etc, and more can be added dynamically.
 type Sandbox interface {
-	// The path where the network namespace is mounted.
+	// The path where the network namespace is mounted, which is the key
+	// identifying the sandbox.
 	Key() string
 
+	// NamespacePath returns the path the network namespace is pinned at,
+	// for the processes and tools entering it by path. A network namespace
+	// created for the sandbox is pinned under /var/run/netns, named after
+	// the last element of the key, the other ones are only mounted at the
+	// key.
+	NamespacePath() string
+
 	// The collection of Interface previously added with the AddInterface
 	// method. Note that this doesn't incude network interfaces added in any
 	// other way (such as the default loopback interface which are automatically
 	// created on creation of a sandbox).
 	Interfaces() []*Interface
 
-	// Add an existing Interface to this sandbox. The operation will rename
-	// from the Interface SrcName to DstName as it moves, and reconfigure the
-	// interface according to the specified settings.
-	AddInterface(*Interface) error
+	// Move the existing device srcName into this sandbox. The operation
+	// renames it to dstName as it moves and assigns it the addresses, of
+	// either family. When dstName is already used by another interface of
+	// the sandbox, the dstName prefix stripped from its trailing digits is
+	// suffixed with the lowest free index instead (eth0, eth1, ...), the
+	// name given is the DstName of the Interface recorded for srcName.
+	AddInterface(srcName, dstName string, addresses []*net.IPNet) error
+
+	// Remove the interface named dstName previously added with
+	// AddInterface. The operation renames the interface back to its
+	// SrcName and moves it out of the sandbox. InterfaceNotFoundError is
+	// returned if the sandbox has no such interface.
+	RemoveInterface(dstName string) error
 
 	// Set default IPv4 gateway for the sandbox
 	SetGateway(gw net.IP) error
@@ -29,10 +51,113 @@ type Sandbox interface {
 	// Set default IPv6 gateway for the sandbox
 	SetGatewayIPv6(gw net.IP) error
 
+	// Unset the previously set default IPv4 gateway in the sandbox
+	UnsetGateway() error
+
+	// Unset the previously set default IPv6 gateway in the sandbox
+	UnsetGatewayIPv6() error
+
+	// Add a static route to dst through the iface sandbox interface, via the
+	// gw next hop unless nil. A default route, whose dst is nil or has a zero
+	// length mask, replaces the default route of the same family in place,
+	// which gets restored when the route is removed.
+	AddRoute(dst *net.IPNet, gw net.IP, iface string) error
+
+	// Remove a static route previously added with AddRoute.
+	RemoveRoute(dst *net.IPNet, gw net.IP, iface string) error
+
+	// Set the hostname of the sandbox UTS namespace. The hostname of a
+	// sandbox sharing the caller network namespace is only recorded, the
+	// caller hostname is left untouched.
+	SetHostname(name string) error
+
+	// Hostname returns the hostname previously set with SetHostname
+	Hostname() string
+
+	// Sysctl returns the value of the network namespace sysctl name, in the
+	// dotted form such as net.ipv4.ip_forward. InvalidSysctlError is returned
+	// if the sandbox has no such sysctl.
+	Sysctl(name string) (string, error)
+
+	// SetSysctl sets the network namespace sysctl name to value.
+	// InvalidSysctlError is returned if the sandbox has no such sysctl or it
+	// is not writable.
+	SetSysctl(name, value string) error
+
+	// Statistics returns the traffic counters of the interfaces of the
+	// sandbox, including the ones not added with AddInterface, indexed by
+	// their name in the sandbox. It returns DestroyedError once the sandbox
+	// is destroyed.
+	Statistics() (map[string]*InterfaceStatistics, error)
+
+	// Probe checks that addr, an IP address and port, accepts TCP
+	// connections from the network namespace of the sandbox within
+	// timeout, or DefaultProbeTimeout when zero. An unreachable addr is
+	// reported by the ProbeResult, the error is returned when the probe can
+	// not run, such as InvalidProbeAddressError or DestroyedError.
+	Probe(addr string, timeout time.Duration) (*ProbeResult, error)
+
 	// Destroy the sandbox
 	Destroy() error
 }
 
+// InterfaceStatistics represents the traffic counters of an interface, since
+// its creation.
+type InterfaceStatistics struct {
+	RxBytes   uint64
+	RxPackets uint64
+	RxErrors  uint64
+	RxDropped uint64
+	TxBytes   uint64
+	TxPackets uint64
+	TxErrors  uint64
+	TxDropped uint64
+}
+
+// DestroyedError is returned when the sandbox identified by the key is
+// used after it was destroyed.
+type DestroyedError string
+
+func (key DestroyedError) Error() string {
+	return fmt.Sprintf("sandbox %s was destroyed", string(key))
+}
+
+// NotFound denotes the type of this error
+func (key DestroyedError) NotFound() {}
+
+// InvalidNamespacePathError is returned when a sandbox is created from a path
+// which does not exist or is not a network namespace.
+type InvalidNamespacePathError string
+
+func (path InvalidNamespacePathError) Error() string {
+	return fmt.Sprintf("%s is not a network namespace", string(path))
+}
+
+// InvalidParameter denotes the type of this error
+func (path InvalidNamespacePathError) InvalidParameter() {}
+
+// InterfaceNotFoundError is returned when the sandbox has no interface
+// with the passed name.
+type InterfaceNotFoundError string
+
+func (name InterfaceNotFoundError) Error() string {
+	return fmt.Sprintf("no interface %s in the sandbox", string(name))
+}
+
+// NotFound denotes the type of this error
+func (name InterfaceNotFoundError) NotFound() {}
+
+// InvalidSysctlError is returned when a sysctl is not a writable sysctl of
+// the network namespace of the sandbox.
+type InvalidSysctlError string
+
+func (name InvalidSysctlError) Error() string {
+	return fmt.Sprintf("sysctl %s is not available in the sandbox", string(name))
+}
+
+// InvalidParameter denotes the type of this error
+func (name InvalidSysctlError) InvalidParameter() {}
+
 // Info represents all possible information that
 // the driver wants to place in the sandbox which includes
 // interfaces, routes and gateway
@@ -45,7 +170,45 @@ type Info struct {
 	// IPv6 gateway for the sandbox.
 	GatewayIPv6 net.IP
 
-	// TODO: Add routes and ip tables etc.
+	// Static routes for the sandbox.
+	Routes []*Route
+
+	// TODO: Add ip tables etc.
+}
+
+// Route represents a static route to be programmed in a sandbox.
+type Route struct {
+	// Destination network, nil for the default route.
+	Destination *net.IPNet
+
+	// Next hop gateway, nil when the destination is directly reachable.
+	NextHop net.IP
+
+	// DstName of the sandbox interface the route goes through.
+	Interface string
+}
+
+// GetCopy returns a copy of this Route structure
+func (r *Route) GetCopy() *Route {
+	return &Route{
+		Destination: netutils.GetIPNetCopy(r.Destination),
+		NextHop:     netutils.GetIPCopy(r.NextHop),
+		Interface:   r.Interface,
+	}
+}
+
+// Equal checks if this instance of Route is equal to the passed one
+func (r *Route) Equal(o *Route) bool {
+	if r == o {
+		return true
+	}
+
+	if o == nil {
+		return false
+	}
+
+	return r.Interface == o.Interface && r.NextHop.Equal(o.NextHop) &&
+		netutils.CompareIPNet(r.Destination, o.Destination)
 }
 
 // Interface represents the settings and identity of a network device. It is
@@ -61,11 +224,18 @@ type Interface struct {
 	// network namespace.
 	DstName string
 
+	// MAC address of the interface.
+	MacAddress net.HardwareAddr
+
 	// IPv4 address for the interface.
 	Address *net.IPNet
 
 	// IPv6 address for the interface.
 	AddressIPv6 *net.IPNet
+
+	// Pool the IPv4 address was allocated from, when the network has
+	// several.
+	Pool *net.IPNet
 }
 
 // GetCopy returns a copy of this Interface structure
@@ -73,9 +243,23 @@ func (i *Interface) GetCopy() *Interface {
 	return &Interface{
 		SrcName:     i.SrcName,
 		DstName:     i.DstName,
+		MacAddress:  netutils.GetMacCopy(i.MacAddress),
 		Address:     netutils.GetIPNetCopy(i.Address),
 		AddressIPv6: netutils.GetIPNetCopy(i.AddressIPv6),
+		Pool:        netutils.GetIPNetCopy(i.Pool),
+	}
+}
+
+// Addresses returns the addresses of the Interface, in the form expected by
+// the Sandbox AddInterface method.
+func (i *Interface) Addresses() []*net.IPNet {
+	var addresses []*net.IPNet
+	for _, addr := range []*net.IPNet{i.Address, i.AddressIPv6} {
+		if addr != nil {
+			addresses = append(addresses, addr)
+		}
 	}
+	return addresses
 }
 
 // Equal checks if this instance of Interface is equal to the passed one
@@ -92,6 +276,10 @@ func (i *Interface) Equal(o *Interface) bool {
 		return false
 	}
 
+	if !bytes.Equal(i.MacAddress, o.MacAddress) {
+		return false
+	}
+
 	if !netutils.CompareIPNet(i.Address, o.Address) {
 		return false
 	}
@@ -100,9 +288,33 @@ func (i *Interface) Equal(o *Interface) bool {
 		return false
 	}
 
+	if !netutils.CompareIPNet(i.Pool, o.Pool) {
+		return false
+	}
+
 	return true
 }
 
+// uniqueDstName returns name if no interface in ifaces is named after it,
+// otherwise the name prefix followed by the lowest index which is free.
+func uniqueDstName(ifaces []*Interface, name string) string {
+	used := make(map[string]bool, len(ifaces))
+	for _, i := range ifaces {
+		used[i.DstName] = true
+	}
+
+	if !used[name] {
+		return name
+	}
+
+	prefix := strings.TrimRight(name, "0123456789")
+	for index := 0; ; index++ {
+		if candidate := prefix + strconv.Itoa(index); !used[candidate] {
+			return candidate
+		}
+	}
+}
+
 // GetCopy returns a copy of this SandboxInfo structure
 func (s *Info) GetCopy() *Info {
 	list := make([]*Interface, len(s.Interfaces))
@@ -112,7 +324,12 @@ func (s *Info) GetCopy() *Info {
 	gw := netutils.GetIPCopy(s.Gateway)
 	gw6 := netutils.GetIPCopy(s.GatewayIPv6)
 
-	return &Info{Interfaces: list, Gateway: gw, GatewayIPv6: gw6}
+	var routes []*Route
+	for _, r := range s.Routes {
+		routes = append(routes, r.GetCopy())
+	}
+
+	return &Info{Interfaces: list, Gateway: gw, GatewayIPv6: gw6, Routes: routes}
 }
 
 // Equal checks if this instance of SandboxInfo is equal to the passed one
@@ -146,6 +363,62 @@ func (s *Info) Equal(o *Info) bool {
 		}
 	}
 
+	if len(s.Routes) != len(o.Routes) {
+		return false
+	}
+
+	for i := 0; i < len(s.Routes); i++ {
+		if !s.Routes[i].Equal(o.Routes[i]) {
+			return false
+		}
+	}
+
 	return true
 
 }
+
+// parseNetDev parses the interface counters in the /proc/net/dev format:
+// two header lines, then one line per interface listing its name, the eight
+// receive counters and the eight transmit ones.
+func parseNetDev(data []byte) (map[string]*InterfaceStatistics, error) {
+	stats := make(map[string]*InterfaceStatistics)
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("invalid interface statistics: %q", data)
+	}
+
+	for _, line := range lines[2:] {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid interface statistics line: %q", line)
+		}
+
+		fields := strings.Fields(parts[1])
+		if len(fields) != 16 {
+			return nil, fmt.Errorf("invalid interface statistics line: %q", line)
+		}
+
+		var counters [16]uint64
+		for i, f := range fields {
+			v, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid interface statistics line: %q", line)
+			}
+			counters[i] = v
+		}
+
+		stats[strings.TrimSpace(parts[0])] = &InterfaceStatistics{
+			RxBytes:   counters[0],
+			RxPackets: counters[1],
+			RxErrors:  counters[2],
+			RxDropped: counters[3],
+			TxBytes:   counters[8],
+			TxPackets: counters[9],
+			TxErrors:  counters[10],
+			TxDropped: counters[11],
+		}
+	}
+
+	return stats, nil
+}
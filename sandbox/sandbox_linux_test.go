@@ -1,10 +1,14 @@
 package sandbox
 
 import (
+	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"syscall"
 	"testing"
 
 	"github.com/docker/libnetwork/netutils"
@@ -103,3 +107,156 @@ func verifySandbox(t *testing.T, s Sandbox) {
 			err)
 	}
 }
+
+func verifyInterfaceRemoved(t *testing.T, s Sandbox) {
+	if _, err := netlink.LinkByName(vethName2); err != nil {
+		t.Fatalf("Interface %s was not moved back out of the sandbox: %v", vethName2, err)
+	}
+
+	if err := netlink.LinkDel(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: vethName1}}); err != nil {
+		t.Fatalf("Could not cleanup veth pair %s: %v", vethName1, err)
+	}
+}
+
+func verifyHostname(t *testing.T, s Sandbox, name string) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	orighostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origns, err := os.Open(fmt.Sprintf("/proc/%d/task/%d/ns/uts", os.Getpid(), syscall.Gettid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer origns.Close()
+
+	f, err := os.Open(s.(*networkNamespace).utsPath)
+	if err != nil {
+		t.Fatalf("Failed to open the sandbox uts namespace: %v", err)
+	}
+	defer f.Close()
+
+	if err := netns.Setns(netns.NsHandle(f.Fd()), syscall.CLONE_NEWUTS); err != nil {
+		t.Fatalf("Failed to enter the sandbox uts namespace: %v", err)
+	}
+	defer netns.Setns(netns.NsHandle(origns.Fd()), syscall.CLONE_NEWUTS)
+
+	// The hostname is read from the uts namespace of the calling thread
+	hostname, err := ioutil.ReadFile("/proc/sys/kernel/hostname")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h := strings.TrimSpace(string(hostname)); h != name {
+		t.Fatalf("Expected sandbox hostname %s, got %s", name, h)
+	}
+	if orighostname == name {
+		t.Fatalf("Hostname %s leaked out of the sandbox", orighostname)
+	}
+}
+
+func newVethInterface(t *testing.T, name, dstName, cidr string) (*Interface, error) {
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: name + "h", TxQLen: 0},
+		PeerName:  name}
+	if err := netlink.LinkAdd(veth); err != nil {
+		return nil, err
+	}
+
+	ip, addr, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	addr.IP = ip
+
+	return &Interface{SrcName: name, DstName: dstName, Address: addr}, nil
+}
+
+func verifyInterfaces(t *testing.T, s Sandbox, names []string) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origns, err := netns.Get()
+	if err != nil {
+		t.Fatalf("Could not get the current netns: %v", err)
+	}
+	defer origns.Close()
+
+	f, err := os.OpenFile(s.Key(), os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("Failed top open network namespace path %q: %v", s.Key(), err)
+	}
+	defer f.Close()
+
+	if err = netns.Set(netns.NsHandle(f.Fd())); err != nil {
+		t.Fatalf("Setting to the namespace pointed to by the sandbox %s failed: %v", s.Key(), err)
+	}
+	defer netns.Set(origns)
+
+	for _, name := range names {
+		if _, err := netlink.LinkByName(name); err != nil {
+			t.Fatalf("Could not find the interface %s inside the sandbox: %v", name, err)
+		}
+	}
+}
+
+func verifyRoute(t *testing.T, s Sandbox, dst *net.IPNet, gw net.IP, present bool) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origns, err := netns.Get()
+	if err != nil {
+		t.Fatalf("Could not get the current netns: %v", err)
+	}
+	defer origns.Close()
+
+	f, err := os.OpenFile(s.Key(), os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("Failed top open network namespace path %q: %v", s.Key(), err)
+	}
+	defer f.Close()
+
+	if err = netns.Set(netns.NsHandle(f.Fd())); err != nil {
+		t.Fatalf("Setting to the namespace pointed to by the sandbox %s failed: %v", s.Key(), err)
+	}
+	defer netns.Set(origns)
+
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatalf("Could not list the routes inside the sandbox: %v", err)
+	}
+
+	found := false
+	for _, r := range routes {
+		if !r.Gw.Equal(gw) {
+			continue
+		}
+		if (dst == nil && r.Dst == nil) || (dst != nil && r.Dst != nil && r.Dst.String() == dst.String()) {
+			found = true
+			break
+		}
+	}
+
+	if found != present {
+		t.Fatalf("Expected route to %v via %v present to be %t", dst, gw, present)
+	}
+}
+
+// verifyPinned checks that the namespace path of the sandbox is the network
+// namespace mounted at its key.
+func verifyPinned(t *testing.T, s Sandbox) {
+	var pinned, mounted syscall.Stat_t
+	if err := syscall.Stat(s.NamespacePath(), &pinned); err != nil {
+		t.Fatalf("Failed to stat the pinned namespace %s: %v", s.NamespacePath(), err)
+	}
+	if err := syscall.Stat(s.Key(), &mounted); err != nil {
+		t.Fatalf("Failed to stat the namespace %s: %v", s.Key(), err)
+	}
+
+	if pinned.Dev != mounted.Dev || pinned.Ino != mounted.Ino {
+		t.Fatalf("Namespace pinned at %s is not the one of the sandbox %s", s.NamespacePath(), s.Key())
+	}
+}
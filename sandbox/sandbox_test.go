@@ -1,8 +1,15 @@
 package sandbox
 
 import (
+	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/netutils"
 )
 
 func TestSandboxCreate(t *testing.T) {
@@ -11,7 +18,7 @@ func TestSandboxCreate(t *testing.T) {
 		t.Fatalf("Failed to obtain a key: %v", err)
 	}
 
-	s, err := NewSandbox(key)
+	s, err := NewSandbox(key, true)
 	if err != nil {
 		t.Fatalf("Failed to create a new sandbox: %v", err)
 	}
@@ -26,7 +33,7 @@ func TestSandboxCreate(t *testing.T) {
 	}
 
 	for _, i := range info.Interfaces {
-		err = s.AddInterface(i)
+		err = s.AddInterface(i.SrcName, i.DstName, i.Addresses())
 		if err != nil {
 			t.Fatalf("Failed to add interfaces to sandbox: %v", err)
 		}
@@ -46,6 +53,52 @@ func TestSandboxCreate(t *testing.T) {
 	s.Destroy()
 }
 
+func TestSandboxRemoveInterface(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key, true)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	defer s.Destroy()
+
+	info, err := newInfo(t)
+	if err != nil {
+		t.Fatalf("Failed to generate new sandbox info: %v", err)
+	}
+
+	for _, i := range info.Interfaces {
+		if err := s.AddInterface(i.SrcName, i.DstName, i.Addresses()); err != nil {
+			t.Fatalf("Failed to add interfaces to sandbox: %v", err)
+		}
+	}
+
+	if err := s.SetGateway(info.Gateway); err != nil {
+		t.Fatalf("Failed to set gateway to sandbox: %v", err)
+	}
+
+	if err := s.UnsetGateway(); err != nil {
+		t.Fatalf("Failed to unset gateway in sandbox: %v", err)
+	}
+
+	for _, i := range info.Interfaces {
+		if err := s.RemoveInterface(i.DstName); err != nil {
+			t.Fatalf("Failed to remove interfaces from sandbox: %v", err)
+		}
+	}
+
+	if len(s.Interfaces()) != 0 {
+		t.Fatalf("Expected no interfaces in sandbox. Found %d", len(s.Interfaces()))
+	}
+
+	verifyInterfaceRemoved(t, s)
+}
+
 func TestInterfaceEqual(t *testing.T) {
 	list := getInterfaceList()
 
@@ -127,3 +180,464 @@ func getInterfaceList() []*Interface {
 		},
 	}
 }
+
+func TestSandboxHostname(t *testing.T) {
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key, true)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	defer s.Destroy()
+
+	if err := s.SetHostname("sandboxhost"); err != nil {
+		t.Fatalf("Failed to set the sandbox hostname: %v", err)
+	}
+
+	if s.Hostname() != "sandboxhost" {
+		t.Fatalf("Expected hostname sandboxhost, got %s", s.Hostname())
+	}
+
+	verifyHostname(t, s, "sandboxhost")
+}
+
+func TestSandboxMultipleInterfaces(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key, true)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	defer s.Destroy()
+
+	for index, name := range []string{"multi0", "multi1", "multi2"} {
+		i, err := newVethInterface(t, name, "eth0", fmt.Sprintf("192.168.%d.2/24", 10+index))
+		if err != nil {
+			t.Fatalf("Failed to create the interface to add: %v", err)
+		}
+
+		if err := s.AddInterface(i.SrcName, i.DstName, i.Addresses()); err != nil {
+			t.Fatalf("Failed to add interface %s to sandbox: %v", name, err)
+		}
+	}
+
+	ifaces := s.Interfaces()
+	if len(ifaces) != 3 {
+		t.Fatalf("Expected 3 interfaces in sandbox, got %d", len(ifaces))
+	}
+	for index, expected := range []string{"eth0", "eth1", "eth2"} {
+		if ifaces[index].DstName != expected || ifaces[index].Address.String() != fmt.Sprintf("192.168.%d.2/24", 10+index) {
+			t.Fatalf("Expected interface %d to be named %s, got %v", index, expected, ifaces[index])
+		}
+	}
+
+	// The name freed by a removed interface gets reused
+	if err := s.RemoveInterface("eth1"); err != nil {
+		t.Fatalf("Failed to remove interface from sandbox: %v", err)
+	}
+
+	i, err := newVethInterface(t, "multi3", "eth0", "192.168.13.2/24")
+	if err != nil {
+		t.Fatalf("Failed to create the interface to add: %v", err)
+	}
+	if err := s.AddInterface(i.SrcName, i.DstName, i.Addresses()); err != nil {
+		t.Fatalf("Failed to add interface to sandbox: %v", err)
+	}
+	if ifaces := s.Interfaces(); ifaces[len(ifaces)-1].DstName != "eth1" {
+		t.Fatalf("Expected the interface to reuse name eth1, got %s", ifaces[len(ifaces)-1].DstName)
+	}
+
+	verifyInterfaces(t, s, []string{"eth0", "eth1", "eth2"})
+
+	if err := s.RemoveInterface("eth3"); err != InterfaceNotFoundError("eth3") {
+		t.Fatalf("Expected %v, got %v", InterfaceNotFoundError("eth3"), err)
+	}
+}
+
+func TestSandboxRoutes(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key, true)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	defer s.Destroy()
+
+	i, err := newVethInterface(t, "route0", "eth0", "192.168.20.2/24")
+	if err != nil {
+		t.Fatalf("Failed to create the interface to add: %v", err)
+	}
+	if err := s.AddInterface(i.SrcName, i.DstName, i.Addresses()); err != nil {
+		t.Fatalf("Failed to add interface to sandbox: %v", err)
+	}
+
+	gw := net.ParseIP("192.168.20.1")
+	if err := s.SetGateway(gw); err != nil {
+		t.Fatalf("Failed to set gateway: %v", err)
+	}
+
+	_, static, _ := net.ParseCIDR("10.10.0.0/16")
+	staticGw := net.ParseIP("192.168.20.254")
+	if err := s.AddRoute(static, staticGw, i.DstName); err != nil {
+		t.Fatalf("Failed to add static route: %v", err)
+	}
+	verifyRoute(t, s, static, staticGw, true)
+
+	// A default route replaces the gateway route until it is removed
+	_, any, _ := net.ParseCIDR("0.0.0.0/0")
+	defaultGw := net.ParseIP("192.168.20.253")
+	if err := s.AddRoute(any, defaultGw, i.DstName); err != nil {
+		t.Fatalf("Failed to add default route: %v", err)
+	}
+	verifyRoute(t, s, nil, defaultGw, true)
+	verifyRoute(t, s, nil, gw, false)
+
+	if err := s.RemoveRoute(any, defaultGw, i.DstName); err != nil {
+		t.Fatalf("Failed to remove default route: %v", err)
+	}
+	verifyRoute(t, s, nil, defaultGw, false)
+	verifyRoute(t, s, nil, gw, true)
+
+	if err := s.RemoveRoute(static, staticGw, i.DstName); err != nil {
+		t.Fatalf("Failed to remove static route: %v", err)
+	}
+	verifyRoute(t, s, static, staticGw, false)
+
+	if err := s.RemoveRoute(static, staticGw, i.DstName); err == nil {
+		t.Fatalf("Expected removing an unknown route to fail")
+	}
+}
+
+func TestParseNetDev(t *testing.T) {
+	data := []byte(`Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:     840      10    0    0    0     0          0         0      840      10    0    0    0     0       0          0
+  eth0:    1296      16    1    2    0     0          0         0      578       7    3    4    0     0       0          0
+`)
+
+	stats, err := parseNetDev(data)
+	if err != nil {
+		t.Fatalf("Failed to parse the statistics: %v", err)
+	}
+
+	expected := &InterfaceStatistics{RxBytes: 1296, RxPackets: 16, RxErrors: 1, RxDropped: 2,
+		TxBytes: 578, TxPackets: 7, TxErrors: 3, TxDropped: 4}
+	if len(stats) != 2 || stats["lo"] == nil || *stats["eth0"] != *expected {
+		t.Fatalf("Unexpected statistics %v", stats)
+	}
+
+	if _, err := parseNetDev([]byte("Inter-|\n face |\n  eth0: 1 2 3\n")); err == nil {
+		t.Fatal("Expected an error parsing truncated statistics")
+	}
+}
+
+func TestSandboxStatistics(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key, true)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+
+	i, err := newVethInterface(t, "stats0", "eth0", "192.168.20.2/24")
+	if err != nil {
+		t.Fatalf("Failed to create the interface to add: %v", err)
+	}
+	if err := s.AddInterface(i.SrcName, i.DstName, i.Addresses()); err != nil {
+		t.Fatalf("Failed to add interface to sandbox: %v", err)
+	}
+
+	stats, err := s.Statistics()
+	if err != nil {
+		t.Fatalf("Failed to get the sandbox statistics: %v", err)
+	}
+	for _, name := range []string{"lo", "eth0"} {
+		if _, ok := stats[name]; !ok {
+			t.Fatalf("No statistics for interface %s: %v", name, stats)
+		}
+	}
+	// The host interfaces are not in the sandbox
+	if _, ok := stats["stats0h"]; ok {
+		t.Fatalf("Statistics of a host interface returned: %v", stats)
+	}
+
+	if err := s.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Statistics(); err != DestroyedError(key) {
+		t.Fatalf("Expected %v, got %v", DestroyedError(key), err)
+	}
+}
+
+func TestSandboxProbe(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key, true)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+
+	for _, addr := range []string{"", "192.168.20.2", "localhost:80"} {
+		if _, err := s.Probe(addr, 0); err != InvalidProbeAddressError(addr) {
+			t.Fatalf("Expected %v, got %v", InvalidProbeAddressError(addr), err)
+		}
+	}
+
+	// The listener is only reachable from the sandbox network namespace
+	var l net.Listener
+	if err := Invoke(key, func() (err error) {
+		l, err = net.Listen("tcp", "127.0.0.1:0")
+		return err
+	}); err != nil {
+		t.Fatalf("Failed to listen in the sandbox: %v", err)
+	}
+	addr := l.Addr().String()
+
+	r, err := s.Probe(addr, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to probe %s: %v", addr, err)
+	}
+	if !r.Success || r.Address != addr || r.Latency <= 0 || r.Error != "" {
+		t.Fatalf("Unexpected result of the probe of %s: %#v", addr, r)
+	}
+
+	l.Close()
+	r, err = s.Probe(addr, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to probe %s: %v", addr, err)
+	}
+	if r.Success || r.Error == "" {
+		t.Fatalf("Unexpected result of the probe of closed %s: %#v", addr, r)
+	}
+
+	if err := s.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Probe(addr, 0); err != DestroyedError(key) {
+		t.Fatalf("Expected %v, got %v", DestroyedError(key), err)
+	}
+}
+
+func TestSandboxFromPath(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key, true)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	defer s.Destroy()
+
+	f, err := ioutil.TempFile("", "netns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	for _, path := range []string{key + "-missing", f.Name()} {
+		if _, err := NewSandboxFromPath(key+"-other", path); err != InvalidNamespacePathError(path) {
+			t.Fatalf("Expected %v, got %v", InvalidNamespacePathError(path), err)
+		}
+	}
+
+	// The existing namespace is configured through the new sandbox
+	other, err := NewSandboxFromPath(key+"-other", key)
+	if err != nil {
+		t.Fatalf("Failed to create a sandbox from %s: %v", key, err)
+	}
+
+	i, err := newVethInterface(t, "frompath0", "eth0", "192.168.21.2/24")
+	if err != nil {
+		t.Fatalf("Failed to create the interface to add: %v", err)
+	}
+	if err := other.AddInterface(i.SrcName, i.DstName, i.Addresses()); err != nil {
+		t.Fatalf("Failed to add interface to sandbox: %v", err)
+	}
+	verifyInterfaces(t, s, []string{"eth0"})
+
+	// Destroying the sandbox leaves the namespace to its other users
+	if err := other.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	verifyInterfaces(t, s, []string{"eth0"})
+}
+
+func TestSandboxNamespacePath(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key, true)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	defer s.Destroy()
+
+	if expected := filepath.Join("/var/run/netns", filepath.Base(key)); s.NamespacePath() != expected {
+		t.Fatalf("Expected the namespace pinned at %s, got %s", expected, s.NamespacePath())
+	}
+	verifyPinned(t, s)
+
+	// The pin of the first sandbox is stale for a sandbox of the same name
+	dir, err := ioutil.TempDir("", "netns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	other, err := NewSandbox(filepath.Join(dir, filepath.Base(key)), true)
+	if err != nil {
+		t.Fatalf("Failed to create a sandbox over a stale pin: %v", err)
+	}
+	if other.NamespacePath() != s.NamespacePath() {
+		t.Fatalf("Expected the namespace pinned at %s, got %s", s.NamespacePath(), other.NamespacePath())
+	}
+	verifyPinned(t, other)
+
+	if err := other.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(other.NamespacePath()); !os.IsNotExist(err) {
+		t.Fatalf("Pinned namespace %s left behind: %v", other.NamespacePath(), err)
+	}
+
+	// The namespace of the sandbox sharing the caller one is not pinned
+	shared, err := NewSandbox(key+"-shared", false)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	defer shared.Destroy()
+
+	if shared.NamespacePath() != shared.Key() {
+		t.Fatalf("Expected the namespace at %s, got %s", shared.Key(), shared.NamespacePath())
+	}
+}
+
+func TestSandboxSysctl(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key, true)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	defer s.Destroy()
+
+	i, err := newVethInterface(t, "sysctl0", "eth0", "192.168.22.2/24")
+	if err != nil {
+		t.Fatalf("Failed to create the interface to add: %v", err)
+	}
+	if err := s.AddInterface(i.SrcName, i.DstName, i.Addresses()); err != nil {
+		t.Fatalf("Failed to add interface to sandbox: %v", err)
+	}
+
+	orig, err := ioutil.ReadFile("/proc/sys/net/ipv4/ip_forward")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, value := range map[string]string{"net.ipv4.ip_forward": "1", "net.ipv4.conf.eth0.rp_filter": "2"} {
+		if err := s.SetSysctl(name, value); err != nil {
+			t.Fatalf("Failed to set sysctl %s: %v", name, err)
+		}
+		if v, err := s.Sysctl(name); err != nil || v != value {
+			t.Fatalf("Expected sysctl %s to be %s, got %q (%v)", name, value, v, err)
+		}
+	}
+
+	// The sysctls of the caller namespace are left untouched
+	if cur, err := ioutil.ReadFile("/proc/sys/net/ipv4/ip_forward"); err != nil || string(cur) != string(orig) {
+		t.Fatalf("Sysctl of the caller changed to %q (%v)", cur, err)
+	}
+
+	for _, name := range []string{"kernel.hostname", "net.ipv4.conf.eth1.rp_filter", "net.ipv4.conf.all.mc_forwarding", "net.ipv4/../../kernel"} {
+		if err := s.SetSysctl(name, "1"); err != InvalidSysctlError(name) {
+			t.Fatalf("Expected %v, got %v", InvalidSysctlError(name), err)
+		}
+	}
+	if _, err := s.Sysctl("net.ipv4.conf.eth1.rp_filter"); err != InvalidSysctlError("net.ipv4.conf.eth1.rp_filter") {
+		t.Fatalf("Expected %v, got %v", InvalidSysctlError("net.ipv4.conf.eth1.rp_filter"), err)
+	}
+}
+
+func TestNullSandbox(t *testing.T) {
+	f := &NullFactory{}
+	s, err := f.NewSandbox("nullkey", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := f.Sandbox("nullkey")
+	if n != s {
+		t.Fatalf("Expected the factory to keep the sandbox it created")
+	}
+
+	_, addr, _ := net.ParseCIDR("192.168.30.2/24")
+	for _, src := range []string{"null0", "null1"} {
+		if err := s.AddInterface(src, "eth0", []*net.IPNet{addr}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ifaces := s.Interfaces(); len(ifaces) != 2 || ifaces[1].DstName != "eth1" || ifaces[1].Address != addr {
+		t.Fatalf("Unexpected interfaces %v", ifaces)
+	}
+	if err := s.RemoveInterface("eth2"); err != InterfaceNotFoundError("eth2") {
+		t.Fatalf("Expected %v, got %v", InterfaceNotFoundError("eth2"), err)
+	}
+
+	_, static, _ := net.ParseCIDR("10.10.0.0/16")
+	if err := s.AddRoute(static, nil, "eth1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveRoute(static, nil, "eth0"); err == nil {
+		t.Fatal("Expected removing an unknown route to fail")
+	}
+	if err := s.RemoveRoute(static, nil, "eth1"); err != nil || len(n.Routes()) != 0 {
+		t.Fatalf("Failed to remove the route: %v (%v)", err, n.Routes())
+	}
+
+	if err := s.SetSysctl("kernel.hostname", "1"); err != InvalidSysctlError("kernel.hostname") {
+		t.Fatalf("Expected %v, got %v", InvalidSysctlError("kernel.hostname"), err)
+	}
+
+	if err := s.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Statistics(); err != DestroyedError("nullkey") {
+		t.Fatalf("Expected %v, got %v", DestroyedError("nullkey"), err)
+	}
+}
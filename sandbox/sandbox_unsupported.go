@@ -10,6 +10,33 @@ var (
 
 // NewSandbox provides a new sandbox instance created in an os specific way
 // provided a key which uniquely identifies the sandbox
-func NewSandbox(key string) (Sandbox, error) {
+func NewSandbox(key string, osCreate bool) (Sandbox, error) {
 	return nil, ErrNotImplemented
 }
+
+// NewSandboxFromPath provides a sandbox instance for the existing network
+// namespace at nsPath.
+func NewSandboxFromPath(key, nsPath string) (Sandbox, error) {
+	return nil, ErrNotImplemented
+}
+
+// Keys returns the keys of the sandboxes which exist on the host.
+func Keys() ([]string, error) {
+	return nil, ErrNotImplemented
+}
+
+// KeysIn returns the keys of the sandboxes which exist in the root
+// directory.
+func KeysIn(root string) ([]string, error) {
+	return nil, ErrNotImplemented
+}
+
+// Remove destroys the sandbox identified by key.
+func Remove(key string) error {
+	return ErrNotImplemented
+}
+
+// Invoke runs fn in the network namespace of the sandbox identified by key.
+func Invoke(key string, fn func() error) error {
+	return ErrNotImplemented
+}
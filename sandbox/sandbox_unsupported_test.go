@@ -4,6 +4,7 @@ package sandbox
 
 import (
 	"errors"
+	"net"
 	"testing"
 )
 
@@ -18,3 +19,27 @@ func newKey(t *testing.T) (string, error) {
 func verifySandbox(t *testing.T, s Sandbox) {
 	return
 }
+
+func verifyInterfaceRemoved(t *testing.T, s Sandbox) {
+	return
+}
+
+func verifyHostname(t *testing.T, s Sandbox, name string) {
+	return
+}
+
+func newVethInterface(t *testing.T, name, dstName, cidr string) (*Interface, error) {
+	return nil, ErrNotImplemented
+}
+
+func verifyInterfaces(t *testing.T, s Sandbox, names []string) {
+	return
+}
+
+func verifyRoute(t *testing.T, s Sandbox, dst *net.IPNet, gw net.IP, present bool) {
+	return
+}
+
+func verifyPinned(t *testing.T, s Sandbox) {
+	return
+}
@@ -0,0 +1,260 @@
+package libnetwork
+
+import (
+	"net"
+
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/sandbox"
+)
+
+// Topology is a copy of the networks, endpoints and sandboxes of a
+// controller, as returned by NetworkController.Snapshot. It shares nothing
+// with the controller, so that it is read without any lock and later
+// changes to the controller do not affect it. Its accessors return copies
+// too.
+type Topology struct {
+	networks  []NetworkSnapshot
+	sandboxes []SandboxSnapshot
+}
+
+// NetworkSnapshot is the copy of a network held by a Topology.
+type NetworkSnapshot struct {
+	name        string
+	id          string
+	networkType string
+	labels      map[string]string
+	endpoints   []EndpointSnapshot
+}
+
+// EndpointSnapshot is the copy of an endpoint held by a Topology.
+type EndpointSnapshot struct {
+	name        string
+	id          string
+	aliases     []string
+	network     string
+	interfaces  []*sandbox.Interface
+	gateway     net.IP
+	gatewayIPv6 net.IP
+	sandboxKey  string
+}
+
+// SandboxSnapshot is the copy of a sandbox held by a Topology.
+type SandboxSnapshot struct {
+	key       string
+	shared    bool
+	endpoints []string
+}
+
+// Snapshot copies the networks, their endpoints and the sandboxes the
+// endpoints are joined to. The controller lock is held for the copy only,
+// with each network lock acquired in turn, and no driver is called.
+func (c *controller) Snapshot() Topology {
+	c.Lock()
+	defer c.Unlock()
+
+	var t Topology
+	joined := make(map[*endpoint]string)
+	for key, sData := range c.sandboxes {
+		s := SandboxSnapshot{key: key, shared: sData.hostNetwork != ""}
+		for _, ep := range sData.endpoints {
+			s.endpoints = append(s.endpoints, string(ep.id))
+			joined[ep] = key
+		}
+		t.sandboxes = append(t.sandboxes, s)
+	}
+
+	for _, n := range c.networks {
+		n.Lock()
+		ns := NetworkSnapshot{
+			name:        n.name,
+			id:          string(n.id),
+			networkType: n.networkType,
+			labels:      copyLabels(n.labels),
+		}
+		for _, ep := range n.endpoints {
+			es := EndpointSnapshot{
+				name:       ep.name,
+				id:         string(ep.id),
+				aliases:    append([]string(nil), ep.aliases...),
+				network:    n.name,
+				sandboxKey: joined[ep],
+			}
+			if sinfo := ep.sandboxInfo; sinfo != nil {
+				es.interfaces = sinfo.GetCopy().Interfaces
+				if sinfo.Gateway != nil {
+					es.gateway = netutils.GetIPCopy(sinfo.Gateway)
+				}
+				if sinfo.GatewayIPv6 != nil {
+					es.gatewayIPv6 = netutils.GetIPCopy(sinfo.GatewayIPv6)
+				}
+			}
+			ns.endpoints = append(ns.endpoints, es)
+		}
+		n.Unlock()
+		t.networks = append(t.networks, ns)
+	}
+
+	return t
+}
+
+// Networks returns the networks of the topology.
+func (t Topology) Networks() []NetworkSnapshot {
+	return append([]NetworkSnapshot(nil), t.networks...)
+}
+
+// NetworkByName returns the network of the topology which has the passed
+// name, and whether it exists.
+func (t Topology) NetworkByName(name string) (NetworkSnapshot, bool) {
+	for _, n := range t.networks {
+		if n.name == name {
+			return n, true
+		}
+	}
+	return NetworkSnapshot{}, false
+}
+
+// NetworkByID returns the network of the topology which has the passed id,
+// and whether it exists.
+func (t Topology) NetworkByID(id string) (NetworkSnapshot, bool) {
+	for _, n := range t.networks {
+		if n.id == id {
+			return n, true
+		}
+	}
+	return NetworkSnapshot{}, false
+}
+
+// Sandboxes returns the sandboxes of the topology, which have one endpoint
+// joined at least.
+func (t Topology) Sandboxes() []SandboxSnapshot {
+	return append([]SandboxSnapshot(nil), t.sandboxes...)
+}
+
+// SandboxByKey returns the sandbox of the topology which has the passed key,
+// and whether it exists.
+func (t Topology) SandboxByKey(key string) (SandboxSnapshot, bool) {
+	for _, s := range t.sandboxes {
+		if s.key == key {
+			return s, true
+		}
+	}
+	return SandboxSnapshot{}, false
+}
+
+// Name returns the name of the network.
+func (n NetworkSnapshot) Name() string {
+	return n.name
+}
+
+// ID returns the id of the network.
+func (n NetworkSnapshot) ID() string {
+	return n.id
+}
+
+// Type returns the type of the network.
+func (n NetworkSnapshot) Type() string {
+	return n.networkType
+}
+
+// Labels returns the labels attached to the network.
+func (n NetworkSnapshot) Labels() map[string]string {
+	return copyLabels(n.labels)
+}
+
+// Endpoints returns the endpoints of the network.
+func (n NetworkSnapshot) Endpoints() []EndpointSnapshot {
+	return append([]EndpointSnapshot(nil), n.endpoints...)
+}
+
+// EndpointByName returns the endpoint of the network which has the passed
+// name or alias, and whether it exists.
+func (n NetworkSnapshot) EndpointByName(name string) (EndpointSnapshot, bool) {
+	for _, e := range n.endpoints {
+		if e.name == name {
+			return e, true
+		}
+		for _, a := range e.aliases {
+			if a == name {
+				return e, true
+			}
+		}
+	}
+	return EndpointSnapshot{}, false
+}
+
+// EndpointByID returns the endpoint of the network which has the passed id,
+// and whether it exists.
+func (n NetworkSnapshot) EndpointByID(id string) (EndpointSnapshot, bool) {
+	for _, e := range n.endpoints {
+		if e.id == id {
+			return e, true
+		}
+	}
+	return EndpointSnapshot{}, false
+}
+
+// Name returns the name of the endpoint.
+func (e EndpointSnapshot) Name() string {
+	return e.name
+}
+
+// ID returns the id of the endpoint.
+func (e EndpointSnapshot) ID() string {
+	return e.id
+}
+
+// Aliases returns the aliases of the endpoint.
+func (e EndpointSnapshot) Aliases() []string {
+	return append([]string(nil), e.aliases...)
+}
+
+// Network returns the name of the network of the endpoint.
+func (e EndpointSnapshot) Network() string {
+	return e.network
+}
+
+// Info returns the settings the driver allocated to the endpoint, along with
+// the key of the sandbox it is joined to. The interfaces are named as the
+// driver created them, and the join settings such as the port bindings are
+// not part of the snapshot.
+func (e EndpointSnapshot) Info() EndpointInfo {
+	info := EndpointInfo{SandboxKey: e.sandboxKey}
+	if e.gateway != nil {
+		info.Gateway = netutils.GetIPCopy(e.gateway)
+	}
+	if e.gatewayIPv6 != nil {
+		info.GatewayIPv6 = netutils.GetIPCopy(e.gatewayIPv6)
+	}
+	for _, i := range e.interfaces {
+		info.Interfaces = append(info.Interfaces, i.GetCopy())
+	}
+	return info
+}
+
+// Key returns the key of the sandbox.
+func (s SandboxSnapshot) Key() string {
+	return s.key
+}
+
+// SharesHostNetwork tells whether the sandbox shares the host network
+// namespace.
+func (s SandboxSnapshot) SharesHostNetwork() bool {
+	return s.shared
+}
+
+// Endpoints returns the ids of the endpoints joined to the sandbox, in join
+// order.
+func (s SandboxSnapshot) Endpoints() []string {
+	return append([]string(nil), s.endpoints...)
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	c := make(map[string]string, len(labels))
+	for k, v := range labels {
+		c[k] = v
+	}
+	return c
+}
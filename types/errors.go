@@ -0,0 +1,121 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+)
+
+// NotFound is implemented by the errors reporting that the requested object
+// does not exist.
+type NotFound interface {
+	error
+	NotFound()
+}
+
+// InvalidParameter is implemented by the errors reporting that the request
+// carries an invalid value.
+type InvalidParameter interface {
+	error
+	InvalidParameter()
+}
+
+// Forbidden is implemented by the errors reporting that the request conflicts
+// with the current state of the objects, which must change first.
+type Forbidden interface {
+	error
+	Forbidden()
+}
+
+// Unavailable is implemented by the errors reporting that the request can not
+// be served at the moment, and may succeed later.
+type Unavailable interface {
+	error
+	Unavailable()
+}
+
+// Retryable is implemented by the errors reporting a transient failure, such
+// as a remote system timing out, for which the same request may succeed if
+// made again.
+type Retryable interface {
+	error
+	Retryable()
+}
+
+// NotFoundErrorf returns an error of the NotFound category, formatted as
+// fmt.Errorf does. The error wraps the %w operand, if any.
+func NotFoundErrorf(format string, a ...interface{}) error {
+	return &notFoundError{fmt.Errorf(format, a...)}
+}
+
+// InvalidParameterErrorf returns an error of the InvalidParameter category,
+// formatted as fmt.Errorf does. The error wraps the %w operand, if any.
+func InvalidParameterErrorf(format string, a ...interface{}) error {
+	return &invalidParameterError{fmt.Errorf(format, a...)}
+}
+
+// ForbiddenErrorf returns an error of the Forbidden category, formatted as
+// fmt.Errorf does. The error wraps the %w operand, if any.
+func ForbiddenErrorf(format string, a ...interface{}) error {
+	return &forbiddenError{fmt.Errorf(format, a...)}
+}
+
+// UnavailableErrorf returns an error of the Unavailable category, formatted
+// as fmt.Errorf does. The error wraps the %w operand, if any.
+func UnavailableErrorf(format string, a ...interface{}) error {
+	return &unavailableError{fmt.Errorf(format, a...)}
+}
+
+// IsNotFound tells whether err, or an error it wraps, is of the NotFound
+// category.
+func IsNotFound(err error) bool {
+	var e NotFound
+	return errors.As(err, &e)
+}
+
+// IsInvalidParameter tells whether err, or an error it wraps, is of the
+// InvalidParameter category.
+func IsInvalidParameter(err error) bool {
+	var e InvalidParameter
+	return errors.As(err, &e)
+}
+
+// IsForbidden tells whether err, or an error it wraps, is of the Forbidden
+// category.
+func IsForbidden(err error) bool {
+	var e Forbidden
+	return errors.As(err, &e)
+}
+
+// IsUnavailable tells whether err, or an error it wraps, is of the
+// Unavailable category.
+func IsUnavailable(err error) bool {
+	var e Unavailable
+	return errors.As(err, &e)
+}
+
+// IsRetryable tells whether err, or an error it wraps, reports a transient
+// failure.
+func IsRetryable(err error) bool {
+	var e Retryable
+	return errors.As(err, &e)
+}
+
+type notFoundError struct{ error }
+
+func (e *notFoundError) NotFound()     {}
+func (e *notFoundError) Unwrap() error { return errors.Unwrap(e.error) }
+
+type invalidParameterError struct{ error }
+
+func (e *invalidParameterError) InvalidParameter() {}
+func (e *invalidParameterError) Unwrap() error     { return errors.Unwrap(e.error) }
+
+type forbiddenError struct{ error }
+
+func (e *forbiddenError) Forbidden()    {}
+func (e *forbiddenError) Unwrap() error { return errors.Unwrap(e.error) }
+
+type unavailableError struct{ error }
+
+func (e *unavailableError) Unavailable()  {}
+func (e *unavailableError) Unwrap() error { return errors.Unwrap(e.error) }
@@ -0,0 +1,50 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorCategories(t *testing.T) {
+	cause := errors.New("cause")
+
+	for _, c := range []struct {
+		err   error
+		is    func(error) bool
+		other func(error) bool
+	}{
+		{NotFoundErrorf("no such object %s", "x"), IsNotFound, IsForbidden},
+		{InvalidParameterErrorf("invalid value"), IsInvalidParameter, IsNotFound},
+		{ForbiddenErrorf("in use"), IsForbidden, IsUnavailable},
+		{UnavailableErrorf("retry later: %w", cause), IsUnavailable, IsInvalidParameter},
+	} {
+		if !c.is(c.err) || c.other(c.err) {
+			t.Fatalf("Error %q misclassified", c.err)
+		}
+
+		// The category shows through the errors wrapping it
+		wrapped := fmt.Errorf("request failed: %w", c.err)
+		if !c.is(wrapped) || c.other(wrapped) {
+			t.Fatalf("Wrapped error %q misclassified", wrapped)
+		}
+	}
+
+	err := UnavailableErrorf("retry later: %w", cause)
+	if err.Error() != "retry later: cause" || !errors.Is(err, cause) {
+		t.Fatalf("Unexpected error %q not wrapping %q", err, cause)
+	}
+
+	if IsRetryable(err) || !IsRetryable(fmt.Errorf("request failed: %w", retryableError{})) {
+		t.Fatal("Retryable error misclassified")
+	}
+
+	if IsNotFound(nil) || IsNotFound(cause) {
+		t.Fatal("Uncategorized error classified")
+	}
+}
+
+type retryableError struct{}
+
+func (retryableError) Error() string { return "timed out" }
+func (retryableError) Retryable()    {}
@@ -1,5 +1,110 @@
 // Package types contains types that are common across libnetwork project
 package types
 
+import (
+	"fmt"
+	"net"
+)
+
 // UUID represents a globally unique ID of various resources like network and endpoint
 type UUID string
+
+// Protocol represents a IP protocol number
+type Protocol uint8
+
+const (
+	// TCP is for the TCP ip protocol
+	TCP Protocol = 6
+	// UDP is for the UDP ip protocol
+	UDP Protocol = 17
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case TCP:
+		return "tcp"
+	case UDP:
+		return "udp"
+	default:
+		return fmt.Sprintf("%d", p)
+	}
+}
+
+// Bandwidth represents the rate limits of the traffic of an endpoint, in
+// bits per second. A zero rate is unlimited.
+type Bandwidth struct {
+	Ingress uint64
+	Egress  uint64
+}
+
+// FirewallRule represents a rule filtering the traffic of an endpoint. The
+// rules of an endpoint apply in order, the first one matching a packet
+// deciding whether it is accepted or dropped.
+type FirewallRule struct {
+	// Action is "allow" or "deny".
+	Action string
+	// Direction is "ingress" for the traffic the endpoint receives, or
+	// "egress" for the traffic it sends.
+	Direction string
+	// Proto is "tcp", "udp" or "icmp", empty for any protocol.
+	Proto string
+	// Port is the destination port of the tcp or udp traffic, 0 for any.
+	Port int
+	// CIDR is the subnet of the remote end, the source of the ingress
+	// traffic or the destination of the egress one, nil for any.
+	CIDR *net.IPNet
+}
+
+// GetCopy returns a copy of this FirewallRule structure
+func (r FirewallRule) GetCopy() FirewallRule {
+	c := r
+	if r.CIDR != nil {
+		c.CIDR = &net.IPNet{IP: append(net.IP(nil), r.CIDR.IP...), Mask: append(net.IPMask(nil), r.CIDR.Mask...)}
+	}
+	return c
+}
+
+// String returns the rule in the "allow ingress tcp/80 from 10.0.0.0/8" form
+func (r FirewallRule) String() string {
+	s := r.Action + " " + r.Direction
+	if r.Proto != "" {
+		s += " " + r.Proto
+		if r.Port != 0 {
+			s += fmt.Sprintf("/%d", r.Port)
+		}
+	}
+	if r.CIDR != nil {
+		if r.Direction == "egress" {
+			s += " to " + r.CIDR.String()
+		} else {
+			s += " from " + r.CIDR.String()
+		}
+	}
+	return s
+}
+
+// PortBinding represents a container port published on the host
+type PortBinding struct {
+	Proto         Protocol
+	ContainerPort int
+	HostIP        net.IP
+	HostPort      int
+}
+
+// GetCopy returns a copy of this PortBinding structure
+func (p PortBinding) GetCopy() PortBinding {
+	hostIP := make(net.IP, len(p.HostIP))
+	copy(hostIP, p.HostIP)
+	return PortBinding{
+		Proto:         p.Proto,
+		ContainerPort: p.ContainerPort,
+		HostIP:        hostIP,
+		HostPort:      p.HostPort,
+	}
+}
+
+// String returns the host and container side of the binding in the
+// hostIP:hostPort->containerPort/proto form
+func (p PortBinding) String() string {
+	return fmt.Sprintf("%s:%d->%d/%s", p.HostIP, p.HostPort, p.ContainerPort, p.Proto)
+}